@@ -0,0 +1,385 @@
+package client
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// A bundle is a tar archive holding the latest version of every file under a prefix,
+// for moving files between deployments which cannot reach each other. The first entry
+// is the bundle header. It is followed by the chunks and file records: each chunk is
+// stored once, under chunks/<hex sum>, before the first file which contains it, and
+// each file is described by a JSON record under files/<name relative to the prefix>.
+const (
+	bundleHeaderName = "jotfs-bundle.json"
+	bundleVersion    = 1
+	bundleChunkDir   = "chunks/"
+	bundleFileDir    = "files/"
+)
+
+type bundleHeader struct {
+	Version   int       `json:"version"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	// ChunkHash is the hash function the bundle's chunks are named by. Bundles which
+	// predate it name chunks by BLAKE3.
+	ChunkHash string `json:"chunk_hash,omitempty"`
+}
+
+type bundleFile struct {
+	Name      string            `json:"name"`
+	Size      uint64            `json:"size"`
+	CreatedAt time.Time         `json:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	// Chunks are the hex sums of the file's chunks, in order.
+	Chunks []string `json:"chunks"`
+}
+
+// BundleOpts may be provided to ExportBundle and ImportBundle to configure their
+// behaviour.
+type BundleOpts struct {
+	// Progress, if set, is called before each file is exported or imported. The action
+	// is one of "export", "upload" or "skip".
+	Progress func(action string, name string)
+	// TempDir is the directory in which ImportBundle holds the bundle's chunks until
+	// the files containing them are uploaded. Defaults to the system temporary
+	// directory.
+	TempDir string
+}
+
+// BundleResult summarises the files written by ExportBundle or read by ImportBundle.
+type BundleResult struct {
+	// Files is the number of files exported, or uploaded by an import.
+	Files int
+	// Unchanged is the number of files skipped by an import because their latest
+	// version already has the same chunks.
+	Unchanged int
+	// Chunks is the number of distinct chunks in the bundle, and ChunkSize their total
+	// size in bytes.
+	Chunks    int
+	ChunkSize uint64
+}
+
+// ExportBundle writes the latest version of every file under prefix to w as a bundle:
+// a tar archive containing each distinct chunk of the files once, with the name,
+// user-defined metadata and chunks of each file. The bundle can be copied to another
+// deployment, e.g. one without network access to this server, and loaded with
+// ImportBundle. The chunks are stored uncompressed, so the bundle may be compressed,
+// e.g. with zstd.
+func (c *Client) ExportBundle(ctx context.Context, prefix string, w io.Writer, opts *BundleOpts) (BundleResult, error) {
+	if opts == nil {
+		opts = &BundleOpts{}
+	}
+	prefix = path.Clean("/" + prefix)
+	dirPrefix := strings.TrimSuffix(prefix, "/") + "/"
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+
+	remote, err := c.latestVersions(ctx, prefix, &SyncOpts{})
+	if err != nil {
+		return BundleResult{}, fmt.Errorf("listing files: %w", err)
+	}
+	names := make([]string, 0, len(remote))
+	for name := range remote {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(w)
+	now := time.Now().UTC()
+	hash, err := c.hash(ctx)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	header := bundleHeader{Version: bundleVersion, Prefix: prefix, CreatedAt: now, ChunkHash: string(hash)}
+	if err := writeBundleJSON(tw, bundleHeaderName, now, header); err != nil {
+		return BundleResult{}, err
+	}
+
+	var result BundleResult
+	written := make(map[sum.Sum]bool)
+	for _, name := range names {
+		info := remote[name]
+		progress("export", name)
+		resp, err := c.iclient.Download(ctx, &pb.FileID{Sum: info.FileID[:]})
+		if isNotFound(err) {
+			// Deleted since it was listed
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("getting download sections of %s: %w", name, err)
+		}
+
+		file := bundleFile{
+			Name:      strings.TrimPrefix(name, dirPrefix),
+			Size:      info.Size,
+			CreatedAt: info.CreatedAt,
+			Metadata:  info.Metadata,
+		}
+		for i, section := range resp.Sections {
+			missing := false
+			for _, chunk := range section.Chunks {
+				s, err := sum.FromBytes(chunk.Sum)
+				if err != nil {
+					return result, err
+				}
+				file.Chunks = append(file.Chunks, s.AsHex())
+				missing = missing || !written[s]
+			}
+			if !missing {
+				continue
+			}
+			err := c.sectionChunks(ctx, section, func(chunk *pb.SectionChunk, b []byte) error {
+				s, err := sum.FromBytes(chunk.Sum)
+				if err != nil || written[s] {
+					return err
+				}
+				hdr := &tar.Header{Name: bundleChunkDir + s.AsHex(), Mode: 0644, Size: int64(len(b)), ModTime: now}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := tw.Write(b); err != nil {
+					return err
+				}
+				written[s] = true
+				result.Chunks++
+				result.ChunkSize += uint64(len(b))
+				return nil
+			})
+			if err != nil {
+				return result, fmt.Errorf("%s section %d: %w", name, i, err)
+			}
+		}
+		if err := writeBundleJSON(tw, bundleFileDir+file.Name, info.CreatedAt, file); err != nil {
+			return result, err
+		}
+		result.Files++
+	}
+	return result, tw.Close()
+}
+
+func writeBundleJSON(tw *tar.Writer, name string, modTime time.Time, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), ModTime: modTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(b)
+	return err
+}
+
+// ImportBundle uploads the files in a bundle written by ExportBundle. Each file is
+// uploaded under prefix, or under the prefix it was exported from if prefix is empty,
+// with its user-defined metadata. As with Sync, files whose latest version on the
+// server already has the same chunks are skipped, so an interrupted import may be run
+// again. The chunks are checked against their sums before any file containing them is
+// uploaded.
+func (c *Client) ImportBundle(ctx context.Context, r io.Reader, prefix string, opts *BundleOpts) (BundleResult, error) {
+	if opts == nil {
+		opts = &BundleOpts{}
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != bundleHeaderName) {
+		return BundleResult{}, errors.New("not a bundle")
+	}
+	if err != nil {
+		return BundleResult{}, fmt.Errorf("reading bundle: %w", err)
+	}
+	var header bundleHeader
+	if err := json.NewDecoder(tr).Decode(&header); err != nil {
+		return BundleResult{}, fmt.Errorf("reading bundle header: %w", err)
+	}
+	if header.Version != bundleVersion {
+		return BundleResult{}, fmt.Errorf("unsupported bundle version %d", header.Version)
+	}
+	hash, err := sum.ParseAlgorithm(header.ChunkHash)
+	if err != nil {
+		return BundleResult{}, fmt.Errorf("reading bundle header: %w", err)
+	}
+	if prefix == "" {
+		prefix = header.Prefix
+	}
+	dirPrefix := strings.TrimSuffix(path.Clean("/"+prefix), "/") + "/"
+
+	tmp, err := ioutil.TempDir(opts.TempDir, "jotfs-bundle-")
+	if err != nil {
+		return BundleResult{}, err
+	}
+	defer os.RemoveAll(tmp)
+	chunks := make(map[sum.Sum]int64)
+
+	var result BundleResult
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("reading bundle: %w", err)
+		}
+
+		switch {
+		case strings.HasPrefix(hdr.Name, bundleChunkDir):
+			s, err := sum.FromHex(strings.TrimPrefix(hdr.Name, bundleChunkDir))
+			if err != nil {
+				return result, fmt.Errorf("invalid chunk %s: %w", hdr.Name, err)
+			}
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return result, fmt.Errorf("reading bundle: %w", err)
+			}
+			if hash.Compute(b) != s {
+				return result, fmt.Errorf("chunk %s is corrupt", s.AsHex())
+			}
+			if err := ioutil.WriteFile(filepath.Join(tmp, s.AsHex()), b, 0600); err != nil {
+				return result, err
+			}
+			chunks[s] = int64(len(b))
+			result.Chunks++
+			result.ChunkSize += uint64(len(b))
+
+		case strings.HasPrefix(hdr.Name, bundleFileDir):
+			var file bundleFile
+			if err := json.NewDecoder(tr).Decode(&file); err != nil {
+				return result, fmt.Errorf("reading %s: %w", hdr.Name, err)
+			}
+			name := path.Clean(dirPrefix + file.Name)
+			if !strings.HasPrefix(name, dirPrefix) {
+				return result, fmt.Errorf("file %q is outside the prefix", file.Name)
+			}
+			uploaded, err := c.importBundleFile(ctx, tmp, chunks, name, file)
+			if err != nil {
+				return result, fmt.Errorf("importing %s: %w", name, err)
+			}
+			if uploaded {
+				progress("upload", name)
+				result.Files++
+			} else {
+				progress("skip", name)
+				result.Unchanged++
+			}
+
+		default:
+			return result, fmt.Errorf("unexpected bundle entry %s", hdr.Name)
+		}
+	}
+}
+
+// importBundleFile uploads a file from a bundle as name, with its chunks read from the
+// directory dir. Returns false if the file's latest version already has its chunks.
+func (c *Client) importBundleFile(ctx context.Context, dir string, chunks map[sum.Sum]int64, name string, file bundleFile) (bool, error) {
+	sums := make([]sum.Sum, len(file.Chunks))
+	var size uint64
+	for i, h := range file.Chunks {
+		s, err := sum.FromHex(h)
+		if err != nil {
+			return false, err
+		}
+		n, ok := chunks[s]
+		if !ok {
+			return false, fmt.Errorf("chunk %s is not in the bundle", h)
+		}
+		sums[i] = s
+		size += uint64(n)
+	}
+	if size != file.Size {
+		return false, fmt.Errorf("expected size %d but chunks have size %d", file.Size, size)
+	}
+
+	latest, err := c.Latest(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if err == nil && latest.Size == file.Size {
+		remote, err := c.fileSums(ctx, latest.FileID)
+		if err != nil {
+			return false, err
+		}
+		if equalSums(remote, sums) {
+			return false, nil
+		}
+	}
+
+	r := &chunkFileReader{dir: dir, sums: sums}
+	defer r.Close()
+	_, err = c.UploadWithMetadata(ctx, r, name, file.Metadata)
+	return err == nil, err
+}
+
+func equalSums(a []sum.Sum, b []sum.Sum) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkFileReader reads the concatenation of the chunks sums, each stored in a file in
+// dir named by its hex sum. Only one file is open at a time.
+type chunkFileReader struct {
+	dir  string
+	sums []sum.Sum
+	f    *os.File
+}
+
+func (r *chunkFileReader) Read(p []byte) (int, error) {
+	for {
+		if r.f == nil {
+			if len(r.sums) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(filepath.Join(r.dir, r.sums[0].AsHex()))
+			if err != nil {
+				return 0, err
+			}
+			r.f = f
+			r.sums = r.sums[1:]
+		}
+		n, err := r.f.Read(p)
+		if err == io.EOF {
+			r.f.Close()
+			r.f = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkFileReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,301 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/diskspace"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// defaultCacheSize is the size of the chunk cache if Options.CacheSize is not set.
+const defaultCacheSize = 1024 * miB
+
+// CacheStats reports the size and use of a client's chunk cache.
+type CacheStats struct {
+	// Hits is the number of chunks read from the cache, and Reused their total size.
+	Hits   uint64
+	Reused uint64
+	// Misses is the number of chunks downloaded from the store and added to the cache.
+	Misses uint64
+	// Chunks is the number of chunks in the cache and Size their total size.
+	Chunks uint64
+	Size   uint64
+	// Shed is the number of chunks removed because the disk was low on free space.
+	Shed uint64
+}
+
+// diskCache is a content-addressed cache of decoded chunks in a local directory,
+// limited by the total size of the chunks. Each chunk is stored in a file named by
+// the hex encoding of its sum. When the cache is full, the least recently used
+// chunks are removed. The directory may be shared by several clients and processes:
+// a chunk removed by another process is treated as a miss, and chunks read from the
+// cache are checked against their sum. If a minimum free space is set, the least
+// recently used chunks are also removed when the free space of the directory's file
+// system falls below it.
+type diskCache struct {
+	dir     string
+	maxSize int64
+	minFree int64
+	// free returns the free space of the file system holding a directory
+	free func(dir string) (uint64, error)
+
+	loadOnce sync.Once
+	loadErr  error
+
+	mu      sync.Mutex
+	size    int64
+	entries map[sum.Sum]*diskEntry
+
+	hits   uint64
+	reused uint64
+	misses uint64
+	shed   uint64
+}
+
+type diskEntry struct {
+	size int64
+	used time.Time
+}
+
+func newDiskCache(dir string, maxSize int64, minFree int64) *diskCache {
+	if maxSize <= 0 {
+		maxSize = defaultCacheSize
+	}
+	return &diskCache{dir: dir, maxSize: maxSize, minFree: minFree, free: diskspace.Free, entries: make(map[sum.Sum]*diskEntry)}
+}
+
+// load records the size and modification time of the chunks already in the cache
+// directory, creating it if it does not exist.
+func (c *diskCache) load() error {
+	c.loadOnce.Do(func() {
+		if err := os.MkdirAll(c.dir, 0o755); err != nil {
+			c.loadErr = fmt.Errorf("creating cache directory: %w", err)
+			return
+		}
+		c.loadErr = filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			s, err := sum.FromHex(info.Name())
+			if err != nil {
+				// Not a chunk, e.g. a partially written temporary file
+				return nil
+			}
+			c.entries[s] = &diskEntry{size: info.Size(), used: info.ModTime()}
+			c.size += info.Size()
+			return nil
+		})
+		if c.loadErr != nil {
+			c.loadErr = fmt.Errorf("reading cache directory: %w", c.loadErr)
+			return
+		}
+		c.mu.Lock()
+		c.shrink(0)
+		c.mu.Unlock()
+	})
+	return c.loadErr
+}
+
+// path returns the name of the file holding the chunk s. Chunks are spread over 256
+// subdirectories by the first byte of their sum.
+func (c *diskCache) path(s sum.Sum) string {
+	h := s.AsHex()
+	return filepath.Join(c.dir, h[:2], h)
+}
+
+// get returns the chunk s, or false if it is not in the cache. The chunk is checked
+// against s with hash.
+func (c *diskCache) get(s sum.Sum, hash sum.Algorithm) ([]byte, bool) {
+	if err := c.load(); err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	_, ok := c.entries[s]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	b, err := ioutil.ReadFile(c.path(s))
+	if err != nil || hash.Compute(b) != s {
+		c.remove(s)
+		return nil, false
+	}
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.entries[s]; ok {
+		e.used = now
+	}
+	c.mu.Unlock()
+	// The modification time records the last use for the next process to load the cache
+	os.Chtimes(c.path(s), now, now)
+	return b, true
+}
+
+// put adds the chunk s to the cache, removing the least recently used chunks if the
+// cache is full. The chunk is not added if the disk would be left with less than the
+// minimum free space, even after removing every other chunk.
+func (c *diskCache) put(s sum.Sum, b []byte) error {
+	if err := c.load(); err != nil {
+		return err
+	}
+	if int64(len(b)) > c.maxSize {
+		return nil
+	}
+	c.mu.Lock()
+	_, ok := c.entries[s]
+	room := ok || c.shrink(int64(len(b)))
+	c.mu.Unlock()
+	if ok || !room {
+		return nil
+	}
+
+	// Write to a temporary file first so a partially written chunk is never read
+	dst := c.path(s)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dst), "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[s]; !ok {
+		c.entries[s] = &diskEntry{size: int64(len(b)), used: time.Now()}
+		c.size += int64(len(b))
+	}
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used chunks until the cache is no larger than 90%
+// of its maximum size, so each eviction frees space for several chunks. c.mu must be
+// held.
+func (c *diskCache) evict() {
+	if c.size <= c.maxSize {
+		return
+	}
+	sums := make([]sum.Sum, 0, len(c.entries))
+	for s := range c.entries {
+		sums = append(sums, s)
+	}
+	sort.Slice(sums, func(i, j int) bool {
+		return c.entries[sums[i]].used.Before(c.entries[sums[j]].used)
+	})
+	target := c.maxSize / 10 * 9
+	for _, s := range sums {
+		if c.size <= target {
+			break
+		}
+		c.size -= c.entries[s].size
+		delete(c.entries, s)
+		os.Remove(c.path(s))
+	}
+}
+
+// shrink removes the least recently used chunks until the file system holding the
+// cache has room for need bytes in addition to the minimum free space. Returns false
+// if there is still not enough room once the cache is empty. The free space is
+// assumed to be sufficient if it can't be read. c.mu must be held.
+func (c *diskCache) shrink(need int64) bool {
+	if c.minFree <= 0 {
+		return true
+	}
+	free, err := c.free(c.dir)
+	if err != nil {
+		return true
+	}
+	short := c.minFree + need - int64(free)
+	if short <= 0 {
+		return true
+	}
+	sums := make([]sum.Sum, 0, len(c.entries))
+	for s := range c.entries {
+		sums = append(sums, s)
+	}
+	sort.Slice(sums, func(i, j int) bool {
+		return c.entries[sums[i]].used.Before(c.entries[sums[j]].used)
+	})
+	for _, s := range sums {
+		if short <= 0 {
+			break
+		}
+		size := c.entries[s].size
+		c.size -= size
+		delete(c.entries, s)
+		os.Remove(c.path(s))
+		atomic.AddUint64(&c.shed, 1)
+		short -= size
+	}
+	return short <= 0
+}
+
+// remove deletes the chunk s from the cache.
+func (c *diskCache) remove(s sum.Sum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[s]; ok {
+		c.size -= e.size
+		delete(c.entries, s)
+	}
+	os.Remove(c.path(s))
+}
+
+// section returns the decoded chunks of a section if every one of them is in the
+// cache. A section is fetched from the store with a single request, so a section with
+// any chunk missing from the cache is downloaded in full.
+func (c *diskCache) section(section *pb.Section, hash sum.Algorithm) ([][]byte, bool) {
+	chunks := make([][]byte, len(section.Chunks))
+	for i, chunk := range section.Chunks {
+		s, err := sum.FromBytes(chunk.Sum)
+		if err != nil {
+			return nil, false
+		}
+		b, ok := c.get(s, hash)
+		if !ok || uint64(len(b)) != chunk.Size {
+			return nil, false
+		}
+		chunks[i] = b
+	}
+	return chunks, true
+}
+
+func (c *diskCache) stats() CacheStats {
+	c.mu.Lock()
+	chunks, size := len(c.entries), c.size
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Reused: atomic.LoadUint64(&c.reused),
+		Misses: atomic.LoadUint64(&c.misses),
+		Chunks: uint64(chunks),
+		Size:   uint64(size),
+		Shed:   atomic.LoadUint64(&c.shed),
+	}
+}
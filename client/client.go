@@ -0,0 +1,2110 @@
+// Package client implements a Go client for a JotFS server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/compress"
+	"github.com/jotfs/jotfs/internal/object"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/rs/xid"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	kiB = 1024
+	miB = 1024 * kiB
+
+	// defaultPackfileSize is the size at which the client stops adding chunks to a
+	// packfile and uploads it to the server, if the server does not give one.
+	defaultPackfileSize = 64 * miB
+
+	// maxBatchSize is the maximum number of chunks the client checks for existence
+	// on the server in a single request.
+	maxBatchSize = 256
+
+	// maxAttempts is the number of times the client attempts a request which fails
+	// with a transient error before giving up.
+	maxAttempts = 3
+)
+
+// ErrNotFound is returned when a file does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when a file cannot be renamed because the new name is in use.
+var ErrExists = errors.New("already exists")
+
+// ErrConflict is returned when a conditional upload or delete is rejected because the
+// file's latest version is not the expected version.
+var ErrConflict = errors.New("precondition failed")
+
+// FileID uniquely identifies a version of a file.
+type FileID [sum.Size]byte
+
+// String returns the hex-encoded representation of a FileID.
+func (id FileID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ParseFileID converts a hex-encoded string to a FileID.
+func ParseFileID(s string) (FileID, error) {
+	sum, err := sum.FromHex(s)
+	if err != nil {
+		return FileID{}, err
+	}
+	return FileID(sum), nil
+}
+
+// Checksum identifies the data of a file. Files with the same data have the same
+// checksum.
+type Checksum [sum.Size]byte
+
+// String returns the hex-encoded representation of a Checksum.
+func (c Checksum) String() string {
+	return hex.EncodeToString(c[:])
+}
+
+func fileIDFromBytes(b []byte) (FileID, error) {
+	s, err := sum.FromBytes(b)
+	if err != nil {
+		return FileID{}, err
+	}
+	return FileID(s), nil
+}
+
+// FileInfo stores the metadata for a version of a file.
+type FileInfo struct {
+	Name      string
+	CreatedAt time.Time
+	Size      uint64
+	FileID    FileID
+	// VersionID is a time-sortable identifier for the file version.
+	VersionID string
+	// IsPrefix is true if the entry is a group of files formed by ListOpts.Delimiter
+	// rather than a file version. Only Name is set.
+	IsPrefix bool
+	// Metadata is the user-defined metadata of the file version, or nil if it has none.
+	Metadata map[string]string
+}
+
+// Options may be provided to New to configure a Client.
+type Options struct {
+	// HTTPClient is used for all requests to the server and object store. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+	// Key, if set, is the API key sent with each request to the server, for servers
+	// with an access policy. It is not sent to the object store.
+	Key string
+	// CacheDir, if set, is a local directory in which chunks downloaded from the store
+	// are cached. Downloads reuse the cached chunks instead of fetching them again, so
+	// restoring many similar files, or versions of the same file, transfers less data.
+	// The directory may be shared by several clients.
+	CacheDir string
+	// CacheSize is the maximum total size of the chunks in CacheDir in bytes. Defaults
+	// to 1 GiB.
+	CacheSize int64
+	// CacheMinFreeSpace, if set, is the free space in bytes kept on the file system of
+	// CacheDir. The least recently used chunks are removed when the free space falls
+	// below it, and downloaded chunks are not cached until enough space is free.
+	CacheMinFreeSpace int64
+	// PackfileSize, if set, limits the size in bytes of the packfiles the client
+	// uploads to less than the size configured on the server. Each upload buffers a
+	// packfile in memory, so it limits the memory used by each upload.
+	PackfileSize int64
+	// DownloadConcurrency is the number of sections of a file, i.e. ranges of its
+	// packfiles, fetched from the store at once by Download, for stores with a high
+	// latency. Sections fetched ahead of the one being written are held in memory.
+	// Defaults to 1, fetching one section at a time.
+	DownloadConcurrency int
+	// UploadConcurrency is the number of packfiles of an upload sent to the server at
+	// once, so a large file is not uploaded one packfile at a time. Each packfile being
+	// sent is held in memory, so memory use grows with the concurrency and the packfile
+	// size. Defaults to 1, sending one packfile at a time.
+	UploadConcurrency int
+}
+
+// Client communicates with a JotFS server.
+type Client struct {
+	host    string
+	hclient *http.Client
+	iclient pb.JotFS
+	key     string
+	cache   *diskCache
+
+	// packLimit is the PackfileSize option
+	packLimit uint64
+	// concurrency is the DownloadConcurrency option
+	concurrency int
+	// uploadConcurrency is the UploadConcurrency option
+	uploadConcurrency int
+
+	paramsOnce    sync.Once
+	params        chunker.Options
+	chunkHash     sum.Algorithm
+	profiles      []chunkerProfile
+	packSize      uint64
+	flushInterval time.Duration
+	inlineSize    uint64
+	paramsErr     error
+}
+
+// New returns a new Client connected to the server at endpoint. The endpoint should
+// include the scheme, e.g. http://localhost:6777.
+func New(endpoint string, opts *Options) (*Client, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("endpoint %q must begin with http:// or https://", endpoint)
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	hclient := http.DefaultClient
+	if opts != nil && opts.HTTPClient != nil {
+		hclient = opts.HTTPClient
+	}
+	var key string
+	var cache *diskCache
+	var packLimit uint64
+	concurrency := 1
+	uploadConcurrency := 1
+	if opts != nil {
+		key = opts.Key
+		if opts.CacheDir != "" {
+			cache = newDiskCache(opts.CacheDir, opts.CacheSize, opts.CacheMinFreeSpace)
+		}
+		if opts.PackfileSize > 0 {
+			packLimit = uint64(opts.PackfileSize)
+		}
+		if opts.DownloadConcurrency > 1 {
+			concurrency = opts.DownloadConcurrency
+		}
+		if opts.UploadConcurrency > 1 {
+			uploadConcurrency = opts.UploadConcurrency
+		}
+	}
+	return &Client{
+		host:              endpoint,
+		hclient:           hclient,
+		iclient:           pb.NewJotFSProtobufClient(endpoint, &keyClient{hclient, key}),
+		key:               key,
+		cache:             cache,
+		packLimit:         packLimit,
+		concurrency:       concurrency,
+		uploadConcurrency: uploadConcurrency,
+	}, nil
+}
+
+// keyClient sets the Authorization header of each request to an API key, if the key
+// is not empty.
+type keyClient struct {
+	*http.Client
+	key string
+}
+
+func (c *keyClient) Do(req *http.Request) (*http.Response, error) {
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.Client.Do(req)
+	if headers, ok := req.Context().Value(responseHeaderKey{}).([]*responseHeader); ok && err == nil {
+		for _, h := range headers {
+			h.value = resp.Header.Get(h.name)
+		}
+	}
+	return resp, err
+}
+
+// responseHeaderKey is the context key of the responseHeaders of an API request.
+type responseHeaderKey struct{}
+
+// responseHeader receives the value of a header of the response to an API request
+// whose context holds it. The generated API client doesn't return response headers.
+type responseHeader struct {
+	name  string
+	value string
+}
+
+// chunkerParams returns the chunking parameters configured for the server. The
+// parameters, and the server's packfile settings, are requested once and cached.
+func (c *Client) chunkerParams(ctx context.Context) (chunker.Options, error) {
+	c.paramsOnce.Do(func() {
+		alg := &responseHeader{name: "x-jotfs-chunk-algorithm"}
+		hash := &responseHeader{name: "x-jotfs-chunk-hash"}
+		profiles := &responseHeader{name: "x-jotfs-chunker-profiles"}
+		ctx = context.WithValue(ctx, responseHeaderKey{}, []*responseHeader{alg, hash, profiles})
+		p, err := c.iclient.GetChunkerParams(ctx, &pb.Empty{})
+		if err != nil {
+			c.paramsErr = fmt.Errorf("getting chunker params: %w", err)
+			return
+		}
+		if c.profiles, err = parseChunkerProfiles(profiles.value); err != nil {
+			c.paramsErr = fmt.Errorf("getting chunker params: %w", err)
+			return
+		}
+		// Servers which predate the header chunk files with FastCDC
+		algorithm, err := chunker.ParseAlgorithm(alg.value)
+		if err != nil {
+			c.paramsErr = fmt.Errorf("getting chunker params: %w", err)
+			return
+		}
+		// Servers which predate the chunk hash header identify chunks by BLAKE3
+		if c.chunkHash, err = sum.ParseAlgorithm(hash.value); err != nil {
+			c.paramsErr = fmt.Errorf("getting chunker params: %w", err)
+			return
+		}
+		c.params = chunker.Options{
+			MinChunkSize:  int(p.MinChunkSize),
+			AvgChunkSize:  int(p.AvgChunkSize),
+			MaxChunkSize:  int(p.MaxChunkSize),
+			Normalization: int(p.Normalization),
+			Algorithm:     algorithm,
+		}
+		// Servers which predate the packfile settings don't send them
+		c.packSize = p.PackfileSize
+		if c.packSize == 0 {
+			c.packSize = defaultPackfileSize
+		}
+		if c.packLimit > 0 && c.packLimit < c.packSize {
+			c.packSize = c.packLimit
+		}
+		c.flushInterval = time.Duration(p.PackfileFlushInterval)
+		// Servers which predate inline storage don't send an inline size
+		c.inlineSize = p.InlineSize
+	})
+	return c.params, c.paramsErr
+}
+
+// hash returns the hash function the server identifies chunks by.
+func (c *Client) hash(ctx context.Context) (sum.Algorithm, error) {
+	if _, err := c.chunkerParams(ctx); err != nil {
+		return "", err
+	}
+	return c.chunkHash, nil
+}
+
+// Upload reads data from r and saves it to the server as a file named dst. Only
+// chunks of data not already on the server are uploaded. The server verifies the
+// SHA-256 checksum of the new file version against the data read from r, and rejects
+// the file if they differ. Returns the ID of the new file version.
+func (c *Client) Upload(ctx context.Context, r io.Reader, dst string) (FileID, error) {
+	return c.UploadWithMetadata(ctx, r, dst, nil)
+}
+
+// UploadWithMetadata uploads a file, as with Upload, and attaches user-defined
+// metadata to the new file version. Metadata keys may contain ASCII letters, digits,
+// '-', '_' and '.'.
+func (c *Client) UploadWithMetadata(ctx context.Context, r io.Reader, dst string, metadata map[string]string) (FileID, error) {
+	return c.UploadWithOpts(ctx, r, dst, &UploadOpts{Metadata: metadata})
+}
+
+// UploadOpts may be provided to UploadWithOpts to configure an upload.
+type UploadOpts struct {
+	// Metadata is attached to the new file version, as with UploadWithMetadata.
+	Metadata map[string]string
+	// IfMatch, if set, is the expected latest version of the file. The upload fails
+	// with ErrConflict if the latest version differs, or the file does not exist.
+	IfMatch *FileID
+	// IfNotExists makes the upload fail with ErrConflict if the file exists.
+	IfNotExists bool
+	// Base, if set, is the latest version of the file when the caller read it, e.g.
+	// when a sync compared it with a local file. If another client has changed the file
+	// since, the server resolves the conflict with the strategy configured for the
+	// file's prefix, and reports it in the UploadResult. A rejected upload fails with
+	// ErrConflict. May not be set with IfMatch or IfNotExists.
+	Base *FileID
+	// BaseNotExists is set instead of Base if the file did not exist.
+	BaseNotExists bool
+}
+
+// ConflictResolution is how the server resolved a change made to a file by another
+// client while it was being uploaded.
+type ConflictResolution int
+
+// Conflict resolutions reported by UploadWithResult and Sync.
+const (
+	NoConflict ConflictResolution = iota
+	// ConflictReplaced means the upload replaced the other client's version as the
+	// latest version of the file.
+	ConflictReplaced
+	// ConflictBranched means the upload was saved under a new name.
+	ConflictBranched
+	// ConflictRejected means the upload failed, keeping the other client's version.
+	ConflictRejected
+)
+
+func (r ConflictResolution) String() string {
+	switch r {
+	case NoConflict:
+		return "none"
+	case ConflictReplaced:
+		return "replaced"
+	case ConflictBranched:
+		return "branched"
+	case ConflictRejected:
+		return "rejected"
+	}
+	return fmt.Sprintf("ConflictResolution(%d)", int(r))
+}
+
+// UploadResult is returned by UploadWithResult.
+type UploadResult struct {
+	// ID is the ID of the new file version.
+	ID FileID
+	// Name is the name of the new file version. It differs from the name uploaded to
+	// if the version was branched.
+	Name string
+	// Conflict is how a conflict with another client was resolved, if UploadOpts.Base
+	// or UploadOpts.BaseNotExists was set.
+	Conflict ConflictResolution
+	// Referenced is true if the version was created by UploadByChecksum from data
+	// already on the server, so no chunks were uploaded.
+	Referenced bool
+}
+
+// UploadWithOpts uploads a file, as with Upload. Set IfMatch or IfNotExists in opts to
+// prevent a concurrent change to the file by another client from being overwritten.
+func (c *Client) UploadWithOpts(ctx context.Context, r io.Reader, dst string, opts *UploadOpts) (FileID, error) {
+	res, err := c.UploadWithResult(ctx, r, dst, opts)
+	return res.ID, err
+}
+
+// UploadWithResult uploads a file, as with UploadWithOpts, and returns the name of
+// the new file version and how any conflict with another client was resolved.
+func (c *Client) UploadWithResult(ctx context.Context, r io.Reader, dst string, opts *UploadOpts) (UploadResult, error) {
+	if opts == nil {
+		opts = &UploadOpts{}
+	}
+	params, err := c.chunkerOptions(ctx, dst)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	hash, err := c.hash(ctx)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if c.inlineSize > 0 {
+		// A file no larger than the inline size is sent with the request creating it,
+		// and stored in the server's database instead of a packfile
+		head, err := ioutil.ReadAll(io.LimitReader(r, int64(c.inlineSize)+1))
+		if err != nil {
+			return UploadResult{}, fmt.Errorf("reading data: %w", err)
+		}
+		if len(head) > 0 && uint64(len(head)) <= c.inlineSize {
+			checksum := sha256.Sum256(head)
+			return c.createVersion(ctx, dst, nil, head, checksum[:], opts)
+		}
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+	h := sha256.New()
+	sums, err := c.uploadChunks(ctx, io.TeeReader(r, h), dst, params, hash)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	return c.createVersion(ctx, dst, sums, nil, h.Sum(nil), opts)
+}
+
+// uploadChunks splits the data read from r into chunks with params, uploading the
+// chunks which don't exist on the server in packfiles for the file dst. Returns the
+// sum of each chunk.
+func (c *Client) uploadChunks(ctx context.Context, r io.Reader, dst string, params chunker.Options, hash sum.Algorithm) ([][]byte, error) {
+	ck, err := chunker.New(r, params)
+	if err != nil {
+		return nil, fmt.Errorf("creating chunker: %w", err)
+	}
+
+	pw := newPackWriter(c, dst)
+	defer pw.wait()
+	var sums [][]byte
+	batch := make([]chunkData, 0, maxBatchSize)
+	for {
+		chunk, err := ck.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading data: %w", err)
+		}
+		data := make([]byte, len(chunk.Data))
+		copy(data, chunk.Data)
+		batch = append(batch, chunkData{data: data})
+
+		if len(batch) == maxBatchSize {
+			sums = hashChunks(hash, batch, sums)
+			if err := pw.addBatch(ctx, batch); err != nil {
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+	}
+	sums = hashChunks(hash, batch, sums)
+	if err := pw.addBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := pw.flush(ctx); err != nil {
+		return nil, err
+	}
+	if err := pw.wait(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// Append creates a new version of the file dst with the data read from r appended to
+// its latest version. Only the appended data is uploaded, and the new version shares
+// the chunks of the previous version, so a growing file such as a log may be shipped
+// as it is written. If ifMatch is not nil, the request fails with ErrConflict unless
+// the file's latest version is ifMatch; it also fails with ErrConflict if another
+// client changes the file concurrently. Returns the ID of the new version, or the
+// latest version if r is empty, or ErrNotFound if the file does not exist.
+func (c *Client) Append(ctx context.Context, r io.Reader, dst string, ifMatch *FileID) (FileID, error) {
+	params, err := c.chunkerOptions(ctx, dst)
+	if err != nil {
+		return FileID{}, err
+	}
+	hash, err := c.hash(ctx)
+	if err != nil {
+		return FileID{}, err
+	}
+	sums, err := c.uploadChunks(ctx, r, dst, params, hash)
+	if err != nil {
+		return FileID{}, err
+	}
+	if len(sums) == 0 {
+		info, err := c.Latest(ctx, dst)
+		if err != nil {
+			return FileID{}, err
+		}
+		if ifMatch != nil && info.FileID != *ifMatch {
+			return FileID{}, ErrConflict
+		}
+		return info.FileID, nil
+	}
+
+	req := &pb.AppendRequest{Name: dst, Sums: sums, IdempotencyKey: xid.New().String()}
+	if ifMatch != nil {
+		req.IfMatch = ifMatch[:]
+	}
+	var id *pb.FileID
+	err = retry(ctx, func() error {
+		var err error
+		id, err = c.iclient.Append(ctx, req)
+		return err
+	})
+	if isNotFound(err) {
+		return FileID{}, ErrNotFound
+	}
+	if isConflict(err) {
+		return FileID{}, ErrConflict
+	}
+	if err != nil {
+		return FileID{}, err
+	}
+	return fileIDFromBytes(id.Sum)
+}
+
+// UploadByChecksum creates a version of the file dst with the data of an existing file
+// version, of any file, with the SHA-256 checksum of the data. No data is uploaded, so
+// a backup client can skip uploading a file the server already has, e.g. a copy or a
+// renamed file. Returns ErrNotFound if no file version has the checksum, in which case
+// the file must be uploaded with UploadWithResult.
+func (c *Client) UploadByChecksum(ctx context.Context, checksum [sha256.Size]byte, dst string, opts *UploadOpts) (UploadResult, error) {
+	if opts == nil {
+		opts = &UploadOpts{}
+	}
+	res, err := c.createVersion(ctx, dst, nil, nil, checksum[:], opts)
+	var terr twirp.Error
+	if errors.As(err, &terr) && (terr.Code() == twirp.NotFound || terr.Meta("argument") == "checksums") {
+		// Servers which predate versions created by reference reject the checksum
+		return UploadResult{}, ErrNotFound
+	}
+	res.Referenced = err == nil && checksum != emptyChecksum
+	return res, err
+}
+
+// ArchiveFile is a file version created by UploadArchive.
+type ArchiveFile struct {
+	Name string
+	ID   FileID
+	Size uint64
+}
+
+// UploadArchive creates a version of a file for each regular file in the tar archive
+// read from r, named by its path in the archive under the directory dst. The server
+// chunks the files itself and saves them together in shared packfiles, which is much
+// faster than calling Upload for each file when there are many small files. Either
+// all or none of the versions are created. The request is not retried because the
+// archive is streamed to the server.
+func (c *Client) UploadArchive(ctx context.Context, r io.Reader, dst string) ([]ArchiveFile, error) {
+	req, err := http.NewRequest("POST", c.host+"/files?dst="+url.QueryEscape(dst), r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-tar")
+	resp, err := (&keyClient{c.hclient, c.key}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("uploading archive: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("uploading archive: server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var out struct {
+		Files []struct {
+			Name string `json:"name"`
+			Sum  string `json:"sum"`
+			Size uint64 `json:"size"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("uploading archive: decoding response: %w", err)
+	}
+	files := make([]ArchiveFile, len(out.Files))
+	for i, f := range out.Files {
+		id, err := ParseFileID(f.Sum)
+		if err != nil {
+			return nil, fmt.Errorf("uploading archive: file %s: %w", f.Name, err)
+		}
+		files[i] = ArchiveFile{Name: f.Name, ID: id, Size: f.Size}
+	}
+	return files, nil
+}
+
+// emptyChecksum is the SHA-256 checksum of an empty file.
+var emptyChecksum = sha256.Sum256(nil)
+
+// createVersion creates a version of the file dst made of the chunks sums, or of the
+// inline data, with the SHA-256 checksum of its data.
+func (c *Client) createVersion(ctx context.Context, dst string, sums [][]byte, data []byte, checksum []byte, opts *UploadOpts) (UploadResult, error) {
+	file := &pb.File{
+		Name:           dst,
+		Sums:           sums,
+		Data:           data,
+		IdempotencyKey: xid.New().String(),
+		Metadata:       opts.Metadata,
+		Checksums:      map[string][]byte{"sha256": checksum},
+		IfNotExists:    opts.IfNotExists,
+	}
+	if opts.IfMatch != nil {
+		file.IfMatch = opts.IfMatch[:]
+	}
+	if opts.Base != nil {
+		file.Base = opts.Base[:]
+	}
+	file.BaseNotExists = opts.BaseNotExists
+	id, err := c.createFile(ctx, file)
+	if isConflict(err) {
+		return UploadResult{Conflict: ConflictRejected}, ErrConflict
+	}
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("creating file: %w", err)
+	}
+	fid, err := fileIDFromBytes(id.Sum)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	res := UploadResult{ID: fid, Name: id.Name}
+	if res.Name == "" {
+		// Returned by servers which do not report the name
+		res.Name = dst
+	}
+	switch id.Conflict {
+	case pb.ConflictResolution_REPLACED:
+		res.Conflict = ConflictReplaced
+	case pb.ConflictResolution_BRANCHED:
+		res.Conflict = ConflictBranched
+	}
+	return res, nil
+}
+
+// createFile creates a file version on the server, retrying if the request fails
+// with a transient error. The request's idempotency key ensures at most one version
+// is created.
+func (c *Client) createFile(ctx context.Context, file *pb.File) (*pb.FileID, error) {
+	var id *pb.FileID
+	err := retry(ctx, func() error {
+		var err error
+		id, err = c.iclient.CreateFile(ctx, file)
+		return err
+	})
+	return id, err
+}
+
+// retry calls f until it succeeds, returns an error which is not transient, or has
+// been called maxAttempts times. f must be safe to repeat.
+func retry(ctx context.Context, f func() error) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = f()
+		if err == nil || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt < maxAttempts {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// transientError wraps an error from a non-twirp request which may succeed if
+// retried.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+
+func (e *transientError) Unwrap() error { return e.err }
+
+// isTransient returns true if a request which failed with err may succeed if retried.
+func isTransient(err error) bool {
+	var te *transientError
+	if errors.As(err, &te) {
+		return true
+	}
+	var terr twirp.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	switch terr.Code() {
+	case twirp.ResourceExhausted:
+		// A full quota is not transient, unlike the server's rate limit
+		return terr.Meta("quota") == ""
+	case twirp.Internal, twirp.Unavailable, twirp.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+type chunkData struct {
+	sum  sum.Sum
+	data []byte
+}
+
+// hashChunks sets the sum of each chunk in batch, computed with hash, and appends the
+// sums to sums. The chunks are hashed in parallel, one goroutine per CPU.
+func hashChunks(hash sum.Algorithm, batch []chunkData, sums [][]byte) [][]byte {
+	workers := runtime.NumCPU()
+	if workers > len(batch) {
+		workers = len(batch)
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := atomic.AddInt64(&next, 1); i < int64(len(batch)); i = atomic.AddInt64(&next, 1) {
+				batch[i].sum = hash.Compute(batch[i].data)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, chunk := range batch {
+		s := chunk.sum
+		sums = append(sums, s[:])
+	}
+	return sums
+}
+
+// packWriter accumulates chunks into a packfile and uploads it to the server when
+// the packfile reaches the server's packfile size, or has been open for its flush
+// interval. With an upload concurrency above 1, packfiles are uploaded in the
+// background, and wait must be called before the chunks are referenced. The client's
+// chunker params must have been requested.
+type packWriter struct {
+	c       *Client
+	name    string
+	buf     *bytes.Buffer
+	builder *object.PackfileBuilder
+	started time.Time
+	pending map[sum.Sum]bool
+
+	// uploads holds a token for each packfile being uploaded in the background
+	uploads chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+}
+
+func newPackWriter(c *Client, name string) *packWriter {
+	w := &packWriter{c: c, name: name, buf: new(bytes.Buffer), pending: make(map[sum.Sum]bool)}
+	if c.uploadConcurrency > 1 {
+		w.uploads = make(chan struct{}, c.uploadConcurrency)
+	}
+	return w
+}
+
+// full returns true if the packfile should be uploaded before a chunk of size n is
+// added to it.
+func (w *packWriter) full(n int) bool {
+	if w.builder == nil {
+		return false
+	}
+	if w.c.flushInterval > 0 && time.Since(w.started) >= w.c.flushInterval {
+		return true
+	}
+	return w.builder.BytesWritten()+uint64(n) > w.c.packSize
+}
+
+// addBatch adds the chunks in batch, which do not already exist on the server, to the
+// packfile.
+func (w *packWriter) addBatch(ctx context.Context, batch []chunkData) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	req := &pb.ChunksExistRequest{Sums: make([][]byte, len(batch))}
+	for i := range batch {
+		req.Sums[i] = batch[i].sum[:]
+	}
+	var resp *pb.ChunksExistResponse
+	err := retry(ctx, func() error {
+		var err error
+		resp, err = w.c.iclient.ChunksExist(ctx, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("checking chunks exist: %w", err)
+	}
+	if len(resp.Exists) != len(batch) {
+		return fmt.Errorf("expected %d values from ChunksExist but received %d", len(batch), len(resp.Exists))
+	}
+
+	for i, chunk := range batch {
+		if resp.Exists[i] || w.pending[chunk.sum] {
+			continue
+		}
+		if w.full(len(chunk.data)) {
+			if err := w.flush(ctx); err != nil {
+				return err
+			}
+		}
+		if w.builder == nil {
+			if w.builder, err = object.NewPackfileBuilder(w.buf); err != nil {
+				return err
+			}
+			w.started = time.Now()
+		}
+		if err := w.builder.Append(chunk.data, chunk.sum, compress.Zstd); err != nil {
+			return fmt.Errorf("adding chunk to packfile: %w", err)
+		}
+		w.pending[chunk.sum] = true
+	}
+	return nil
+}
+
+// flush uploads the current packfile to the server, if it's not empty. With an
+// upload concurrency above 1, the packfile is uploaded in the background once fewer
+// than that many packfiles are being uploaded, and an error from an earlier upload is
+// returned.
+func (w *packWriter) flush(ctx context.Context) error {
+	if w.builder == nil {
+		return nil
+	}
+	index := w.builder.Build()
+	w.builder = nil
+	if len(index.Blocks) == 0 {
+		w.buf.Reset()
+		return nil
+	}
+	if w.uploads == nil {
+		defer w.buf.Reset()
+		return w.c.uploadPackfile(ctx, w.buf.Bytes(), index.Sum, w.name)
+	}
+
+	select {
+	case w.uploads <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := w.uploadErr(); err != nil {
+		<-w.uploads
+		return err
+	}
+	packfile := w.buf.Bytes()
+	w.buf = new(bytes.Buffer)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.uploads }()
+		if err := w.c.uploadPackfile(ctx, packfile, index.Sum, w.name); err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// wait waits for the packfiles being uploaded in the background, and returns the
+// first error from any upload.
+func (w *packWriter) wait() error {
+	w.wg.Wait()
+	return w.uploadErr()
+}
+
+func (w *packWriter) uploadErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// uploadPackfile sends a packfile for the file name to the server, retrying if the
+// request fails with a transient error. Each attempt sends the same idempotency key,
+// so the server acknowledges a retry of an upload which succeeded without saving the
+// packfile again. The name lets the server reject the upload if the file's quota is
+// full.
+func (c *Client) uploadPackfile(ctx context.Context, packfile []byte, s sum.Sum, name string) error {
+	key := xid.New().String()
+	return retry(ctx, func() error {
+		req, err := http.NewRequest("POST", c.host+"/packfile", bytes.NewReader(packfile))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+		req.Header.Set("x-jotfs-idempotency-key", key)
+		if name != "" {
+			req.Header.Set("x-jotfs-name", url.PathEscape(name))
+		}
+		resp, err := (&keyClient{c.hclient, c.key}).Do(req)
+		if err != nil {
+			return &transientError{fmt.Errorf("uploading packfile: %w", err)}
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+			err := fmt.Errorf("uploading packfile: server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+			if resp.StatusCode == http.StatusInsufficientStorage {
+				// The file's quota is full
+				return err
+			}
+			if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+				return &transientError{err}
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// Download writes the contents of a file version to w. Returns ErrNotFound if the file
+// does not exist.
+func (c *Client) Download(ctx context.Context, id FileID, w io.Writer) error {
+	resp, err := c.iclient.Download(ctx, &pb.FileID{Sum: id[:]})
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("getting download sections: %w", err)
+	}
+	if c.concurrency > 1 {
+		return c.fetchSections(ctx, resp.Sections, func(_ int, chunks [][]byte) error {
+			for _, b := range chunks {
+				if _, err := w.Write(b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	for i, section := range resp.Sections {
+		if err := c.downloadSection(ctx, section, w); err != nil {
+			return fmt.Errorf("section %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// fetchedSection is the decoded chunks of a section fetched by fetchSections.
+type fetchedSection struct {
+	chunks [][]byte
+	err    error
+}
+
+// fetchSections gets the data for each section from the object store with a pool of
+// c.concurrency workers, and calls f with the decoded chunks of each section, in
+// order. Sections fetched before the ones preceding them wait in a reorder buffer. At
+// most c.concurrency sections are fetched or waiting at once, which limits the memory
+// used.
+func (c *Client) fetchSections(ctx context.Context, sections []*pb.Section, f func(i int, chunks [][]byte) error) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each section's result is sent on its own channel, so the channels together form
+	// the reorder buffer
+	results := make([]chan fetchedSection, len(sections))
+	for i := range results {
+		results[i] = make(chan fetchedSection, 1)
+	}
+	window := make(chan struct{}, c.concurrency)
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range sections {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for n := 0; n < c.concurrency && n < len(sections); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				w := &chunkWriter{section: sections[i]}
+				err := c.downloadSection(ctx, sections[i], w)
+				results[i] <- fetchedSection{w.chunks, err}
+			}
+		}()
+	}
+
+	for i := range sections {
+		var res fetchedSection
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if res.err != nil {
+			return fmt.Errorf("section %d: %w", i, res.err)
+		}
+		if err := f(i, res.chunks); err != nil {
+			return err
+		}
+		<-window
+	}
+	return nil
+}
+
+// downloadSection gets the data for a section from the object store and writes the
+// decoded chunks to w.
+func (c *Client) downloadSection(ctx context.Context, section *pb.Section, w io.Writer) error {
+	return c.sectionChunks(ctx, section, func(_ *pb.SectionChunk, b []byte) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// sectionChunks gets the data for a section from the object store and calls f with
+// each decoded chunk, in order. If the client has a chunk cache and every chunk of the
+// section is cached, the chunks are read from the cache instead.
+func (c *Client) sectionChunks(ctx context.Context, section *pb.Section, f func(chunk *pb.SectionChunk, b []byte) error) error {
+	if len(section.Chunks) == 0 {
+		return nil
+	}
+	hash, err := c.hash(ctx)
+	if err != nil {
+		return err
+	}
+	if section.Data != nil {
+		return inlineChunk(section, hash, f)
+	}
+	if c.cache != nil {
+		if chunks, ok := c.cache.section(section, hash); ok {
+			for i, chunk := range section.Chunks {
+				atomic.AddUint64(&c.cache.hits, 1)
+				atomic.AddUint64(&c.cache.reused, uint64(len(chunks[i])))
+				if err := f(chunk, chunks[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	u := section.Url
+	if strings.HasPrefix(u, "/") {
+		// Stores hosted by the server return URLs relative to the server address
+		u = c.host + u
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", section.RangeStart, section.RangeEnd))
+	resp, err := c.hclient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("store returned %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if expected := section.RangeEnd - section.RangeStart + 1; uint64(len(data)) != expected {
+		return fmt.Errorf("expected %d bytes but received %d", expected, len(data))
+	}
+
+	for _, chunk := range section.Chunks {
+		if chunk.BlockOffset >= uint64(len(data)) {
+			return fmt.Errorf("chunk %d offset %d out of range", chunk.Sequence, chunk.BlockOffset)
+		}
+		b, err := object.DecodeBlock(data[chunk.BlockOffset:], hash)
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", chunk.Sequence, err)
+		}
+		if c.cache != nil {
+			atomic.AddUint64(&c.cache.misses, 1)
+			if s, err := sum.FromBytes(chunk.Sum); err == nil {
+				// The cache is best effort, so a chunk which can't be cached is
+				// only downloaded again next time
+				c.cache.put(s, b)
+			}
+		}
+		if err := f(chunk, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inlineChunk passes the data of a section holding a file stored inline to f, after
+// verifying it against the sum of the section's single chunk.
+func inlineChunk(section *pb.Section, hash sum.Algorithm, f func(chunk *pb.SectionChunk, b []byte) error) error {
+	if len(section.Chunks) != 1 {
+		return fmt.Errorf("inline section has %d chunks, expected 1", len(section.Chunks))
+	}
+	chunk := section.Chunks[0]
+	if s := hash.Compute(section.Data); !bytes.Equal(s[:], chunk.Sum) {
+		return fmt.Errorf("chunk %d: sum of inline data is %x, expected %x", chunk.Sequence, s, chunk.Sum)
+	}
+	return f(chunk, section.Data)
+}
+
+// CacheStats returns the size of the client's chunk cache, and the number of chunks
+// read from and added to it. Returns zero stats if the client has no cache.
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.stats()
+}
+
+// DeltaStats is returned by DownloadDelta.
+type DeltaStats struct {
+	// Downloaded is the number of bytes of chunk data downloaded from the store.
+	Downloaded uint64
+	// Reused is the number of bytes copied from the base.
+	Reused uint64
+}
+
+// DownloadDelta writes the contents of a file version to w, as with Download, but only
+// downloads the chunks which are not in base, e.g. a local copy of a previous version
+// of the file. base is split into chunks with the server's chunking parameters, and
+// each chunk of the file version found in base is copied from it instead. The
+// downloaded chunks are held in memory until they are written. Returns ErrNotFound if
+// the file does not exist.
+func (c *Client) DownloadDelta(ctx context.Context, id FileID, base io.ReadSeeker, w io.Writer) (DeltaStats, error) {
+	// Find the offset of each chunk in base
+	params, err := c.chunkerParams(ctx)
+	if err != nil {
+		return DeltaStats{}, err
+	}
+	if len(c.profiles) > 0 {
+		// The file version was chunked with the parameters of its name
+		stat, err := c.StatVersion(ctx, id)
+		if err != nil {
+			return DeltaStats{}, err
+		}
+		if params, err = c.chunkerOptions(ctx, stat.Name); err != nil {
+			return DeltaStats{}, err
+		}
+	}
+	hash := c.chunkHash
+	if _, err := base.Seek(0, io.SeekStart); err != nil {
+		return DeltaStats{}, err
+	}
+	ck, err := chunker.New(base, params)
+	if err != nil {
+		return DeltaStats{}, fmt.Errorf("creating chunker: %w", err)
+	}
+	offsets := make(map[sum.Sum]int64)
+	have := make([][]byte, 0)
+	var offset int64
+	for {
+		chunk, err := ck.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return DeltaStats{}, fmt.Errorf("reading base: %w", err)
+		}
+		s := hash.Compute(chunk.Data)
+		if _, ok := offsets[s]; !ok {
+			offsets[s] = offset
+			have = append(have, s[:])
+		}
+		offset += int64(len(chunk.Data))
+	}
+
+	resp, err := c.iclient.DownloadDelta(ctx, &pb.DownloadDeltaRequest{Sum: id[:], Have: have})
+	if isNotFound(err) {
+		return DeltaStats{}, ErrNotFound
+	}
+	if err != nil {
+		return DeltaStats{}, fmt.Errorf("getting download sections: %w", err)
+	}
+	var stats DeltaStats
+	downloaded := make(map[sum.Sum][]byte)
+	for i, section := range resp.Sections {
+		err := c.sectionChunks(ctx, section, func(chunk *pb.SectionChunk, b []byte) error {
+			s, err := sum.FromBytes(chunk.Sum)
+			if err != nil {
+				return err
+			}
+			downloaded[s] = b
+			stats.Downloaded += uint64(len(b))
+			return nil
+		})
+		if err != nil {
+			return stats, fmt.Errorf("section %d: %w", i, err)
+		}
+	}
+
+	// Rebuild the file from the recipe
+	for i, chunk := range resp.Recipe {
+		s, err := sum.FromBytes(chunk.Sum)
+		if err != nil {
+			return stats, err
+		}
+		if b, ok := downloaded[s]; ok {
+			if _, err := w.Write(b); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		offset, ok := offsets[s]
+		if !ok {
+			return stats, fmt.Errorf("chunk %d is neither in base nor downloaded", i)
+		}
+		if _, err := base.Seek(offset, io.SeekStart); err != nil {
+			return stats, err
+		}
+		if _, err := io.CopyN(w, base, int64(chunk.Size)); err != nil {
+			return stats, fmt.Errorf("reading base: %w", err)
+		}
+		stats.Reused += chunk.Size
+	}
+	return stats, nil
+}
+
+// Copy makes a copy of a file version to a new file named dst. The copy is made on the
+// server without transferring any file data. Returns the ID of the new file.
+func (c *Client) Copy(ctx context.Context, src FileID, dst string) (FileID, error) {
+	id, err := c.iclient.Copy(ctx, &pb.CopyRequest{SrcId: src[:], Dst: dst})
+	if isNotFound(err) {
+		return FileID{}, ErrNotFound
+	}
+	if err != nil {
+		return FileID{}, err
+	}
+	return fileIDFromBytes(id.Sum)
+}
+
+// ConcatOpts may be provided to Concat to configure the new file version.
+type ConcatOpts struct {
+	// Metadata is attached to the new file version.
+	Metadata map[string]string
+	// IfMatch, if set, is the expected latest version of the file. Concat fails with
+	// ErrConflict if the latest version differs, or the file does not exist.
+	IfMatch *FileID
+	// IfNotExists makes Concat fail with ErrConflict if the file exists.
+	IfNotExists bool
+}
+
+// Concat creates a new version of the file dst whose data is the data of the file
+// versions srcs in order. The server splices the chunk lists of the versions, so no
+// data is transferred, and a large file may be uploaded as parts in parallel, e.g. as
+// temporary files which are deleted once assembled. Returns ErrNotFound if any of srcs
+// does not exist.
+func (c *Client) Concat(ctx context.Context, srcs []FileID, dst string, opts *ConcatOpts) (FileID, error) {
+	if opts == nil {
+		opts = &ConcatOpts{}
+	}
+	req := &pb.ConcatRequest{
+		Dst:            dst,
+		SrcIds:         make([][]byte, len(srcs)),
+		Metadata:       opts.Metadata,
+		IfNotExists:    opts.IfNotExists,
+		IdempotencyKey: xid.New().String(),
+	}
+	for i := range srcs {
+		req.SrcIds[i] = srcs[i][:]
+	}
+	if opts.IfMatch != nil {
+		req.IfMatch = opts.IfMatch[:]
+	}
+	var id *pb.FileID
+	err := retry(ctx, func() error {
+		var err error
+		id, err = c.iclient.Concat(ctx, req)
+		return err
+	})
+	if isNotFound(err) {
+		return FileID{}, ErrNotFound
+	}
+	if isConflict(err) {
+		return FileID{}, ErrConflict
+	}
+	if err != nil {
+		return FileID{}, err
+	}
+	return fileIDFromBytes(id.Sum)
+}
+
+// RevertToVersion restores the file version id by creating a new latest version of
+// its file with the same data and metadata. No file data is transferred. If ifMatch is
+// not nil, the request fails with ErrConflict unless the file's latest version is
+// ifMatch. Returns the ID of the new version, or ErrNotFound if id does not exist.
+func (c *Client) RevertToVersion(ctx context.Context, id FileID, ifMatch *FileID) (FileID, error) {
+	req := &pb.RevertToVersionRequest{Sum: id[:]}
+	if ifMatch != nil {
+		req.IfMatch = ifMatch[:]
+	}
+	resp, err := c.iclient.RevertToVersion(ctx, req)
+	if isNotFound(err) {
+		return FileID{}, ErrNotFound
+	}
+	if isConflict(err) {
+		return FileID{}, ErrConflict
+	}
+	if err != nil {
+		return FileID{}, err
+	}
+	return fileIDFromBytes(resp.Sum)
+}
+
+// SetMetadata changes the user-defined metadata of a file version. Keys in set are
+// added or replaced, and keys in del are removed. Returns the metadata of the file
+// version after the change, or ErrNotFound if the file version does not exist.
+func (c *Client) SetMetadata(ctx context.Context, id FileID, set map[string]string, del []string) (map[string]string, error) {
+	resp, err := c.iclient.SetMetadata(ctx, &pb.SetMetadataRequest{Sum: id[:], Set: set, Delete: del})
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Metadata, nil
+}
+
+// Rename changes the name of a file from src to dst, keeping every version of the
+// file. Returns ErrNotFound if src does not exist, or ErrExists if dst exists.
+func (c *Client) Rename(ctx context.Context, src string, dst string) error {
+	_, err := c.rename(ctx, &pb.RenameRequest{Src: src, Dst: dst})
+	return err
+}
+
+// RenamePrefix moves every file in the directory src and its subdirectories to the
+// directory dst, and returns the number of files moved. Either every file is moved,
+// or none are. Returns ErrNotFound if src contains no files, or ErrExists if any of
+// the new names are in use.
+func (c *Client) RenamePrefix(ctx context.Context, src string, dst string) (int, error) {
+	resp, err := c.rename(ctx, &pb.RenameRequest{Src: src, Dst: dst, Prefix: true})
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.NumFiles), nil
+}
+
+func (c *Client) rename(ctx context.Context, req *pb.RenameRequest) (*pb.RenameResponse, error) {
+	resp, err := c.iclient.Rename(ctx, req)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	var terr twirp.Error
+	if errors.As(err, &terr) && terr.Code() == twirp.AlreadyExists {
+		return nil, fmt.Errorf("%w: %s", ErrExists, terr.Msg())
+	}
+	return resp, err
+}
+
+// Delete removes a file version. Returns ErrNotFound if the file does not exist.
+func (c *Client) Delete(ctx context.Context, id FileID) error {
+	_, err := c.iclient.Delete(ctx, &pb.FileID{Sum: id[:]})
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// MaxDeleteBatch is the maximum combined number of file versions and names which may
+// be passed to DeleteBatch.
+const MaxDeleteBatch = 1000
+
+// DeleteBatch removes the file versions in ids, and every version of the files in
+// names, in a single request. Either all of the deletes succeed, or none do. File
+// versions and names which do not exist are ignored. Returns the IDs of the file
+// versions deleted.
+func (c *Client) DeleteBatch(ctx context.Context, ids []FileID, names []string) ([]FileID, error) {
+	req := &pb.DeleteBatchRequest{Sums: make([][]byte, len(ids)), Names: names}
+	for i := range ids {
+		req.Sums[i] = ids[i][:]
+	}
+	resp, err := c.iclient.DeleteBatch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]FileID, len(resp.Deleted))
+	for i, b := range resp.Deleted {
+		if deleted[i], err = fileIDFromBytes(b); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteIfMatch removes every version of the file name if its latest version is id.
+// Returns ErrConflict if the latest version differs, or the file does not exist.
+func (c *Client) DeleteIfMatch(ctx context.Context, name string, id FileID) error {
+	req := &pb.DeleteBatchRequest{Names: []string{name}, IfMatch: map[string][]byte{name: id[:]}}
+	_, err := c.iclient.DeleteBatch(ctx, req)
+	if isConflict(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// PrefixStats is the number of files, file versions and their total size under a
+// prefix.
+type PrefixStats struct {
+	NumFiles        uint64
+	NumFileVersions uint64
+	TotalSize       uint64
+}
+
+// DeletePrefix removes every version of every file in the directory prefix and its
+// subdirectories, and returns the number of files, versions and bytes deleted. The
+// files are deleted in batches, so an error may leave some files deleted. If dryRun is
+// true, DeletePrefix returns what would be deleted without deleting anything.
+func (c *Client) DeletePrefix(ctx context.Context, prefix string, dryRun bool) (PrefixStats, error) {
+	var stats PrefixStats
+	for {
+		resp, err := c.iclient.DeletePrefix(ctx, &pb.DeletePrefixRequest{Prefix: prefix, DryRun: dryRun})
+		if err != nil {
+			return stats, err
+		}
+		stats.NumFiles += resp.NumFiles
+		stats.NumFileVersions += resp.NumFileVersions
+		stats.TotalSize += resp.TotalSize
+		if !resp.More {
+			return stats, nil
+		}
+	}
+}
+
+// Latest returns the latest version of a file. Returns ErrNotFound if the file does not
+// exist.
+func (c *Client) Latest(ctx context.Context, name string) (FileInfo, error) {
+	resp, err := c.iclient.Head(ctx, &pb.HeadRequest{Name: name, Limit: 1})
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(resp.Info) == 0 {
+		return FileInfo{}, ErrNotFound
+	}
+	return fileInfoFromPB(resp.Info[0])
+}
+
+// FileStat is returned by Stat.
+type FileStat struct {
+	FileInfo
+	// NumVersions is the number of versions of the file.
+	NumVersions uint64
+	// NumChunks is the number of chunks in the file version.
+	NumChunks uint64
+	// Checksum is the checksum of the file version's data. It may be compared to the
+	// result of Checksum to check if a local file needs to be uploaded.
+	Checksum Checksum
+	// Checksums are the whole-file checksums computed by the server when the file
+	// version was created, keyed by algorithm. "sha256" is always present for file
+	// versions created by this version of the server. "md5" and "sha1" are present if
+	// the server is configured to compute them.
+	Checksums map[string][]byte
+}
+
+// Stat returns information about the latest version of a file, without downloading
+// its data. Returns ErrNotFound if the file does not exist.
+func (c *Client) Stat(ctx context.Context, name string) (FileStat, error) {
+	return c.stat(ctx, &pb.GetFileInfoRequest{Name: name})
+}
+
+// StatVersion returns information about a file version, as with Stat.
+func (c *Client) StatVersion(ctx context.Context, id FileID) (FileStat, error) {
+	return c.stat(ctx, &pb.GetFileInfoRequest{Sum: id[:]})
+}
+
+// StatTag returns information about the version of a file labelled with tag, as with
+// Stat. Returns ErrNotFound if the file has no such tag.
+func (c *Client) StatTag(ctx context.Context, name string, tag string) (FileStat, error) {
+	return c.stat(ctx, &pb.GetFileInfoRequest{Name: name, Tag: tag})
+}
+
+func (c *Client) stat(ctx context.Context, req *pb.GetFileInfoRequest) (FileStat, error) {
+	resp, err := c.iclient.GetFileInfo(ctx, req)
+	if isNotFound(err) {
+		return FileStat{}, ErrNotFound
+	}
+	if err != nil {
+		return FileStat{}, err
+	}
+	info, err := fileInfoFromPB(resp.Info)
+	if err != nil {
+		return FileStat{}, err
+	}
+	checksum, err := sum.FromBytes(resp.Checksum)
+	if err != nil {
+		return FileStat{}, err
+	}
+	return FileStat{
+		FileInfo:    info,
+		NumVersions: resp.NumVersions,
+		NumChunks:   resp.NumChunks,
+		Checksum:    Checksum(checksum),
+		Checksums:   resp.Checksums,
+	}, nil
+}
+
+// VersionTag is a label naming a version of a file.
+type VersionTag struct {
+	Tag       string
+	FileID    FileID
+	CreatedAt time.Time
+}
+
+// TagVersion labels a file version with tag, e.g. "release-1.2". If the tag names
+// another version of the same file, it is moved. Tagged versions are kept when their
+// file is replaced on a server with versioning turned off. Returns ErrNotFound if the
+// file version does not exist.
+func (c *Client) TagVersion(ctx context.Context, id FileID, tag string) error {
+	_, err := c.iclient.TagVersion(ctx, &pb.TagVersionRequest{Sum: id[:], Tag: tag})
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UntagVersion removes tag from the file name. Returns ErrNotFound if the file has no
+// such tag.
+func (c *Client) UntagVersion(ctx context.Context, name string, tag string) error {
+	_, err := c.iclient.UntagVersion(ctx, &pb.UntagVersionRequest{Name: name, Tag: tag})
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Tags returns the tags of the file name, in tag order.
+func (c *Client) Tags(ctx context.Context, name string) ([]VersionTag, error) {
+	resp, err := c.iclient.ListTags(ctx, &pb.ListTagsRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]VersionTag, len(resp.Tags))
+	for i, t := range resp.Tags {
+		id, err := fileIDFromBytes(t.Sum)
+		if err != nil {
+			return nil, err
+		}
+		tags[i] = VersionTag{Tag: t.Tag, FileID: id, CreatedAt: time.Unix(0, t.CreatedAt).UTC()}
+	}
+	return tags, nil
+}
+
+// Snapshot is a named record of the latest version of every file in a directory.
+type Snapshot struct {
+	Name string
+	// Prefix is the snapshot's directory, ending with a slash.
+	Prefix    string
+	CreatedAt time.Time
+	// NumFiles is the number of files in the snapshot, and TotalSize the total size of
+	// their versions.
+	NumFiles  uint64
+	TotalSize uint64
+}
+
+// CreateSnapshot records the latest version of every file in the directory prefix
+// and its subdirectories under a new snapshot. Versions in a snapshot are kept when
+// their file is replaced on a server with versioning turned off. Returns ErrExists if
+// a snapshot with the same name exists.
+func (c *Client) CreateSnapshot(ctx context.Context, name string, prefix string) (Snapshot, error) {
+	resp, err := c.iclient.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: name, Prefix: prefix})
+	var terr twirp.Error
+	if errors.As(err, &terr) && terr.Code() == twirp.AlreadyExists {
+		return Snapshot{}, fmt.Errorf("%w: %s", ErrExists, terr.Msg())
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return snapshotFromPB(resp), nil
+}
+
+// Snapshots returns the snapshots of the directory prefix and its subdirectories, most
+// recent first. An empty prefix returns every snapshot.
+func (c *Client) Snapshots(ctx context.Context, prefix string) ([]Snapshot, error) {
+	resp, err := c.iclient.ListSnapshots(ctx, &pb.ListSnapshotsRequest{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]Snapshot, len(resp.Snapshots))
+	for i, s := range resp.Snapshots {
+		snaps[i] = snapshotFromPB(s)
+	}
+	return snaps, nil
+}
+
+// DeleteSnapshot removes a snapshot. The file versions in the snapshot are not
+// deleted. Returns ErrNotFound if the snapshot does not exist.
+func (c *Client) DeleteSnapshot(ctx context.Context, name string) error {
+	_, err := c.iclient.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Name: name})
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ChangeType is the kind of change made to a file between two snapshots.
+type ChangeType int
+
+// Changes reported by DiffSnapshot.
+const (
+	ChangeAdded ChangeType = iota
+	ChangeModified
+	ChangeDeleted
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(t))
+}
+
+// SnapshotChange is a file which differs between a snapshot and a later state of its
+// directory.
+type SnapshotChange struct {
+	Name string
+	Type ChangeType
+	// Old is the file's version in the snapshot. It is nil if the file was added, or
+	// if the version has been deleted.
+	Old *FileID
+	// New is the file's version now, or in the snapshot compared to. It is nil if the
+	// file was deleted.
+	New *FileID
+}
+
+// DiffSnapshot returns the files added, modified and deleted in the directory of the
+// snapshot name since it was taken, in name order. If to is not empty, the snapshot
+// is compared to the snapshot to instead of the latest versions of the files. Returns
+// ErrNotFound if either snapshot does not exist.
+func (c *Client) DiffSnapshot(ctx context.Context, name string, to string) ([]SnapshotChange, error) {
+	resp, err := c.iclient.DiffSnapshot(ctx, &pb.DiffSnapshotRequest{Name: name, To: to})
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]SnapshotChange, len(resp.Changes))
+	for i, ch := range resp.Changes {
+		changes[i] = SnapshotChange{Name: ch.Name, Type: ChangeType(ch.Type)}
+		if changes[i].Old, err = optionalFileID(ch.OldSum); err != nil {
+			return nil, err
+		}
+		if changes[i].New, err = optionalFileID(ch.NewSum); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// RollbackStats reports the outcome of RollbackSnapshot.
+type RollbackStats struct {
+	// Restored is the number of files restored to their version in the snapshot.
+	Restored uint64
+	// Deleted is the number of files deleted because prune was set.
+	Deleted uint64
+	// Unavailable is the number of files left unchanged because their version in the
+	// snapshot has been deleted.
+	Unavailable uint64
+}
+
+// RollbackSnapshot restores each file in the snapshot name to its version in the
+// snapshot, by creating a new latest version with the same data and metadata. If
+// prune is true, every file added to the snapshot's directory since it was taken is
+// deleted. Either every file is changed, or none are. Returns ErrNotFound if the
+// snapshot does not exist, or ErrConflict if a file was changed during the rollback.
+func (c *Client) RollbackSnapshot(ctx context.Context, name string, prune bool) (RollbackStats, error) {
+	resp, err := c.iclient.RollbackSnapshot(ctx, &pb.RollbackSnapshotRequest{Name: name, Prune: prune})
+	if isNotFound(err) {
+		return RollbackStats{}, ErrNotFound
+	}
+	if isConflict(err) {
+		return RollbackStats{}, ErrConflict
+	}
+	if err != nil {
+		return RollbackStats{}, err
+	}
+	return RollbackStats{Restored: resp.Restored, Deleted: resp.Deleted, Unavailable: resp.Unavailable}, nil
+}
+
+func snapshotFromPB(s *pb.Snapshot) Snapshot {
+	return Snapshot{
+		Name:      s.Name,
+		Prefix:    s.Prefix,
+		CreatedAt: time.Unix(0, s.CreatedAt).UTC(),
+		NumFiles:  s.NumFiles,
+		TotalSize: s.TotalSize,
+	}
+}
+
+// optionalFileID returns nil if b is empty, and the file ID in b otherwise.
+func optionalFileID(b []byte) (*FileID, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	id, err := fileIDFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// ByteRange is a range of bytes in a file version.
+type ByteRange struct {
+	Offset uint64
+	Length uint64
+	// NumChunks is the number of chunks in the range.
+	NumChunks uint64
+}
+
+// FileDiff is returned by Diff.
+type FileDiff struct {
+	// Added are the ranges of the new version made of chunks which are not in the old
+	// version.
+	Added []ByteRange
+	// Removed are the ranges of the old version made of chunks which are not in the
+	// new version.
+	Removed []ByteRange
+	OldSize uint64
+	NewSize uint64
+}
+
+// Diff compares two file versions by their chunks, without downloading them. A client
+// holding the old version only needs to download the Added ranges of the new version,
+// e.g. with File.ReadAt, to build the new version. Returns ErrNotFound if either
+// version does not exist.
+func (c *Client) Diff(ctx context.Context, old FileID, new FileID) (FileDiff, error) {
+	resp, err := c.iclient.Diff(ctx, &pb.DiffRequest{OldSum: old[:], NewSum: new[:]})
+	if isNotFound(err) {
+		return FileDiff{}, ErrNotFound
+	}
+	if err != nil {
+		return FileDiff{}, err
+	}
+	ranges := func(rs []*pb.ByteRange) []ByteRange {
+		res := make([]ByteRange, len(rs))
+		for i, r := range rs {
+			res[i] = ByteRange{Offset: r.Offset, Length: r.Length, NumChunks: r.NumChunks}
+		}
+		return res
+	}
+	return FileDiff{
+		Added:   ranges(resp.Added),
+		Removed: ranges(resp.Removed),
+		OldSize: resp.OldSize,
+		NewSize: resp.NewSize,
+	}, nil
+}
+
+// HistogramBucket counts the values which are at least Min and less than Max, and
+// Total is their sum. Max is zero for the last bucket of a histogram, which has no
+// upper bound.
+type HistogramBucket struct {
+	Min   uint64
+	Max   uint64
+	Count uint64
+	Total uint64
+}
+
+// ChunkReport reports how the data uploaded to the server in a period was chunked,
+// packed and deduplicated.
+type ChunkReport struct {
+	// The server's chunker parameters.
+	MinChunkSize uint64
+	AvgChunkSize uint64
+	MaxChunkSize uint64
+
+	// ChunkSizes is a histogram of the sizes of the new chunks stored, before
+	// compression, with buckets doubling from the minimum chunk size. Chunks smaller
+	// than the minimum are the ends of files, and chunks in the last bucket were cut
+	// at the maximum chunk size. StoredSize is their size after compression.
+	ChunkSizes []HistogramBucket
+	NumChunks  uint64
+	ChunksSize uint64
+	StoredSize uint64
+
+	// PackSizes is a histogram of the sizes of the packfiles created, in tenths of the
+	// packfile size configured on the server. PackFill is their average size as a
+	// fraction of it.
+	PackSizes []HistogramBucket
+	NumPacks  uint64
+	PacksSize uint64
+	PackFill  float64
+
+	// NumFileVersions and FilesSize are the number and total size of the file
+	// versions created. DedupHitRate is the fraction of their data which was already
+	// stored, so was not uploaded.
+	NumFileVersions uint64
+	FilesSize       uint64
+	DedupHitRate    float64
+}
+
+// ChunkReport reports on the data uploaded from since until until, to check the
+// server's chunker parameters suit the data. The period starts with the first upload
+// if since is zero, and ends now if until is zero. Packfiles rewritten by a vacuum
+// count as created at the time of the vacuum. Requires an admin key if the server has
+// an access policy.
+func (c *Client) ChunkReport(ctx context.Context, since time.Time, until time.Time) (ChunkReport, error) {
+	req := &pb.ChunkReportRequest{}
+	if !since.IsZero() {
+		req.Since = since.UnixNano()
+	}
+	if !until.IsZero() {
+		req.Until = until.UnixNano()
+	}
+	resp, err := c.iclient.ChunkReport(ctx, req)
+	if err != nil {
+		return ChunkReport{}, err
+	}
+	histogram := func(buckets []*pb.HistogramBucket) []HistogramBucket {
+		res := make([]HistogramBucket, len(buckets))
+		for i, b := range buckets {
+			res[i] = HistogramBucket{Min: b.Min, Max: b.Max, Count: b.Count, Total: b.Total}
+		}
+		return res
+	}
+	return ChunkReport{
+		MinChunkSize:    resp.Params.GetMinChunkSize(),
+		AvgChunkSize:    resp.Params.GetAvgChunkSize(),
+		MaxChunkSize:    resp.Params.GetMaxChunkSize(),
+		ChunkSizes:      histogram(resp.ChunkSizes),
+		NumChunks:       resp.NumChunks,
+		ChunksSize:      resp.ChunksSize,
+		StoredSize:      resp.StoredSize,
+		PackSizes:       histogram(resp.PackSizes),
+		NumPacks:        resp.NumPacks,
+		PacksSize:       resp.PacksSize,
+		PackFill:        resp.PackFill,
+		NumFileVersions: resp.NumFileVersions,
+		FilesSize:       resp.FilesSize,
+		DedupHitRate:    resp.DedupHitRate,
+	}, nil
+}
+
+// Checksum reads data from r and returns the checksum it would have if it was uploaded
+// to the server. Nothing is uploaded. The checksum depends on the chunking parameters,
+// so use ChecksumAs for a file under a chunker profile.
+func (c *Client) Checksum(ctx context.Context, r io.Reader) (Checksum, error) {
+	return c.ChecksumAs(ctx, r, "/")
+}
+
+// ChecksumAs reads data from r and returns the checksum it would have if it was
+// uploaded to the server as a file named name. Nothing is uploaded.
+func (c *Client) ChecksumAs(ctx context.Context, r io.Reader, name string) (Checksum, error) {
+	params, err := c.chunkerOptions(ctx, name)
+	if err != nil {
+		return Checksum{}, err
+	}
+	hash, err := c.hash(ctx)
+	if err != nil {
+		return Checksum{}, err
+	}
+	ck, err := chunker.New(r, params)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("creating chunker: %w", err)
+	}
+	var sums []byte
+	for {
+		chunk, err := ck.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Checksum{}, fmt.Errorf("reading data: %w", err)
+		}
+		s := hash.Compute(chunk.Data)
+		sums = append(sums, s[:]...)
+	}
+	return Checksum(sum.Compute(sums)), nil
+}
+
+// SortOrder is the order of the results returned by List.
+type SortOrder int
+
+// Sort orders supported by List.
+const (
+	SortCreatedAt SortOrder = iota
+	SortName
+	SortSize
+)
+
+// ListOpts may be provided to List and Head to configure the response.
+type ListOpts struct {
+	// Exclude is a glob pattern. Files matching the pattern are excluded from the
+	// response.
+	Exclude string
+	// Include is a glob pattern which forces inclusion of files matched by Exclude.
+	Include string
+	// Sort sets the order of the results. Results are in descending order of the
+	// creation time of the file versions by default.
+	Sort SortOrder
+	// Ascending returns results in ascending order, instead of the default descending
+	// order.
+	Ascending bool
+	// Delimiter, if set, groups the files with names containing the delimiter after the
+	// prefix into a single result with IsPrefix set, e.g. set it to "/" to list the
+	// files and subdirectories in a directory. Requires SortName.
+	Delimiter string
+	// BatchSize is the number of results to request from the server at a time.
+	BatchSize uint64
+}
+
+// List returns an iterator over all file versions with names beginning with prefix. If
+// prefix ends with "/", only files in that directory are matched.
+func (c *Client) List(prefix string, opts *ListOpts) *FileIterator {
+	if opts == nil {
+		opts = &ListOpts{}
+	}
+	return &FileIterator{opts: *opts, fetch: func(ctx context.Context, limit uint64, cursor string) ([]FileInfo, string, error) {
+		resp, err := c.iclient.List(ctx, &pb.ListRequest{
+			Prefix:    prefix,
+			Limit:     limit,
+			Cursor:    cursor,
+			Exclude:   opts.Exclude,
+			Include:   opts.Include,
+			Ascending: opts.Ascending,
+			Sort:      pb.ListSort(opts.Sort),
+			Delimiter: opts.Delimiter,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		infos, err := mergePrefixes(resp.Info, resp.Prefixes, opts.Ascending)
+		if err != nil {
+			return nil, "", err
+		}
+		return infos, resp.NextCursor, nil
+	}}
+}
+
+// mergePrefixes combines the files and prefixes returned by a List request, which are
+// both in name order, into a single slice.
+func mergePrefixes(files []*pb.FileInfo, prefixes []string, ascending bool) ([]FileInfo, error) {
+	infos := make([]FileInfo, 0, len(files)+len(prefixes))
+	for len(files) > 0 || len(prefixes) > 0 {
+		usePrefix := len(files) == 0
+		if len(files) > 0 && len(prefixes) > 0 {
+			usePrefix = (prefixes[0] < files[0].Name) == ascending
+		}
+		if usePrefix {
+			infos = append(infos, FileInfo{Name: prefixes[0], IsPrefix: true})
+			prefixes = prefixes[1:]
+			continue
+		}
+		info, err := fileInfoFromPB(files[0])
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+		files = files[1:]
+	}
+	return infos, nil
+}
+
+// SearchOpts may be provided to Search to configure the search.
+type SearchOpts struct {
+	// Regex treats the pattern as an RE2 regular expression instead of a glob.
+	Regex bool
+	// BatchSize is the maximum number of results to request from the server at a time.
+	BatchSize uint64
+	// Metadata, if set, only matches file versions with all of the given metadata. The
+	// pattern may be empty if Metadata is set.
+	Metadata map[string]string
+}
+
+// Search returns an iterator over all file versions with names matching a pattern, in
+// name order. By default the pattern is a glob, in which * and ? do not match /, but
+// ** does. For example, logs/2020-*/**.gz matches every .gz file in the directories
+// under /logs beginning with 2020-. Searches are fastest when the pattern begins with a
+// directory name, since only the files in that directory are scanned.
+func (c *Client) Search(pattern string, opts *SearchOpts) *FileIterator {
+	if opts == nil {
+		opts = &SearchOpts{}
+	}
+	return &FileIterator{opts: ListOpts{BatchSize: opts.BatchSize}, fetch: func(ctx context.Context, limit uint64, cursor string) ([]FileInfo, string, error) {
+		resp, err := c.iclient.Search(ctx, &pb.SearchRequest{
+			Pattern:  pattern,
+			Regex:    opts.Regex,
+			Limit:    limit,
+			Cursor:   cursor,
+			Metadata: opts.Metadata,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		infos := make([]FileInfo, len(resp.Info))
+		for i, info := range resp.Info {
+			if infos[i], err = fileInfoFromPB(info); err != nil {
+				return nil, "", err
+			}
+		}
+		return infos, resp.NextCursor, nil
+	}}
+}
+
+// Head returns an iterator over all versions of a file. Options Exclude, Include, Sort
+// and Delimiter are ignored.
+func (c *Client) Head(name string, opts *ListOpts) *FileIterator {
+	if opts == nil {
+		opts = &ListOpts{}
+	}
+	return &FileIterator{opts: *opts, fetch: func(ctx context.Context, limit uint64, cursor string) ([]FileInfo, string, error) {
+		var token int64
+		if cursor != "" {
+			var err error
+			if token, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+				return nil, "", err
+			}
+		}
+		resp, err := c.iclient.Head(ctx, &pb.HeadRequest{
+			Name:          name,
+			Limit:         limit,
+			NextPageToken: token,
+			Ascending:     opts.Ascending,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		infos := make([]FileInfo, len(resp.Info))
+		for i, info := range resp.Info {
+			if infos[i], err = fileInfoFromPB(info); err != nil {
+				return nil, "", err
+			}
+		}
+		if resp.NextPageToken == -1 {
+			return infos, "", nil
+		}
+		return infos, strconv.FormatInt(resp.NextPageToken, 10), nil
+	}}
+}
+
+// fetchFunc returns a page of results starting at cursor, and the cursor of the next
+// page, which is empty if there are no more pages.
+type fetchFunc func(ctx context.Context, limit uint64, cursor string) ([]FileInfo, string, error)
+
+// FileIterator iterates over a sequence of file versions.
+type FileIterator struct {
+	opts   ListOpts
+	fetch  fetchFunc
+	cursor string
+	infos  []FileInfo
+	done   bool
+}
+
+// Next returns the next file in the sequence. Returns io.EOF when no files remain.
+func (it *FileIterator) Next(ctx context.Context) (FileInfo, error) {
+	// A page may be empty even if more results remain
+	for len(it.infos) == 0 {
+		if it.done {
+			return FileInfo{}, io.EOF
+		}
+		limit := it.opts.BatchSize
+		if limit == 0 {
+			limit = 1000
+		}
+		infos, cursor, err := it.fetch(ctx, limit, it.cursor)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		it.infos = infos
+		it.cursor = cursor
+		it.done = cursor == ""
+	}
+	info := it.infos[0]
+	it.infos = it.infos[1:]
+	return info, nil
+}
+
+func fileInfoFromPB(info *pb.FileInfo) (FileInfo, error) {
+	id, err := fileIDFromBytes(info.Sum)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{
+		Name:      info.Name,
+		CreatedAt: time.Unix(0, info.CreatedAt).UTC(),
+		Size:      info.Size,
+		FileID:    id,
+		VersionID: info.VersionId,
+		Metadata:  info.Metadata,
+	}, nil
+}
+
+func isNotFound(err error) bool {
+	var terr twirp.Error
+	if errors.As(err, &terr) {
+		return terr.Code() == twirp.NotFound
+	}
+	return false
+}
+
+func isConflict(err error) bool {
+	var terr twirp.Error
+	if errors.As(err, &terr) {
+		return terr.Code() == twirp.Aborted
+	}
+	return false
+}
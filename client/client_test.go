@@ -0,0 +1,1543 @@
+package client
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/server"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/sum"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadDownload(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(1278, 5*1024*1024)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/data/test.bin")
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	err = c.Download(ctx, id, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+
+	// Upload the same data again to a new file -- should be deduplicated
+	id2, err := c.Upload(ctx, bytes.NewReader(data), "/data/test2.bin")
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, id2)
+
+	// Empty file
+	id3, err := c.Upload(ctx, bytes.NewReader(nil), "/empty")
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, c.Download(ctx, id3, buf))
+	assert.Empty(t, buf.Bytes())
+
+	// Error if file does not exist
+	err = c.Download(ctx, FileID{}, ioutil.Discard)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestFileWriter(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Write in small pieces which do not align with chunk boundaries
+	data := randomData(99, 300*1024)
+	w := c.NewFileWriter(ctx, "/data/writer.bin")
+	for b := data; len(b) > 0; {
+		n := 1000
+		if n > len(b) {
+			n = len(b)
+		}
+		_, err := w.Write(b[:n])
+		assert.NoError(t, err)
+		b = b[n:]
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, w.Close())
+	_, err := w.Write([]byte("more"))
+	assert.Error(t, err)
+
+	info, err := c.Latest(ctx, "/data/writer.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, w.ID(), info.FileID)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, c.Download(ctx, w.ID(), buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// An aborted writer does not create a file
+	w = c.NewFileWriter(ctx, "/data/aborted.bin")
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	w.Abort()
+	assert.True(t, errors.Is(w.Close(), ErrAborted))
+	_, err = c.Latest(ctx, "/data/aborted.bin")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Packfile uploads which fail with a server error are retried
+	rt := &flakyTransport{fail: 1}
+	c2, err := New(c.host, &Options{HTTPClient: &http.Client{Transport: rt}})
+	assert.NoError(t, err)
+	w = c2.NewFileWriter(ctx, "/data/retried.bin")
+	_, err = w.Write(randomData(100, 64*1024))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, 2, rt.packfiles)
+}
+
+// flakyTransport returns a 503 response for the first fail packfile uploads.
+type flakyTransport struct {
+	fail      int
+	packfiles int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/packfile" {
+		t.packfiles++
+		if t.packfiles <= t.fail {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Status:     "503 Service Unavailable",
+				Body:       ioutil.NopCloser(strings.NewReader("unavailable")),
+				Request:    req,
+			}, nil
+		}
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOpenFile(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(7, 6*1024*1024)
+	_, err := c.Upload(ctx, bytes.NewReader(data), "/data/file.bin")
+	assert.NoError(t, err)
+
+	f, err := c.OpenFile(ctx, "/data/file.bin", "")
+	assert.NoError(t, err)
+	defer f.Close()
+	assert.Equal(t, int64(len(data)), f.Size())
+
+	// Random access, including ranges spanning chunks and the end of the file
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		off := rng.Int63n(int64(len(data)))
+		p := make([]byte, rng.Intn(100*1024))
+		n, err := f.ReadAt(p, off)
+		end := off + int64(len(p))
+		if end > int64(len(data)) {
+			end = int64(len(data))
+			assert.Equal(t, io.EOF, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, data[off:end], p[:n])
+	}
+	n, err := f.ReadAt(make([]byte, 10), int64(len(data)))
+	assert.Zero(t, n)
+	assert.Equal(t, io.EOF, err)
+
+	// Sequential reads
+	pos, err := f.Seek(-10, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)-10), pos)
+	_, err = f.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	// Versions may be opened by version ID
+	info, err := c.Latest(ctx, "/data/file.bin")
+	assert.NoError(t, err)
+	f2, err := c.OpenFile(ctx, "/data/file.bin", info.VersionID)
+	assert.NoError(t, err)
+	assert.Equal(t, info, f2.Stat())
+
+	// A File may be read by archive/zip
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	fw, err := zw.Create("inner.bin")
+	assert.NoError(t, err)
+	_, err = fw.Write(data[:200*1024])
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	_, err = c.Upload(ctx, buf, "/data/archive.zip")
+	assert.NoError(t, err)
+	zf, err := c.OpenFile(ctx, "/data/archive.zip", "")
+	assert.NoError(t, err)
+	zr, err := zip.NewReader(zf, zf.Size())
+	assert.NoError(t, err)
+	if assert.Len(t, zr.File, 1) {
+		rc, err := zr.File[0].Open()
+		assert.NoError(t, err)
+		b, err = ioutil.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, data[:200*1024], b)
+	}
+
+	_, err = c.OpenFile(ctx, "/data/missing.bin", "")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = c.OpenFile(ctx, "/data/file.bin", "missing")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestListCopyDelete(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(1, 1024)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/a.txt")
+	assert.NoError(t, err)
+	_, err = c.Copy(ctx, id, "/data/b.txt")
+	assert.NoError(t, err)
+
+	names := listNames(t, c.List("/", &ListOpts{BatchSize: 1}))
+	assert.Equal(t, []string{"/data/b.txt", "/a.txt"}, names)
+
+	for _, ascending := range []bool{true, false} {
+		it := c.List("/", &ListOpts{Sort: SortName, Ascending: ascending, Delimiter: "/"})
+		a, err := it.Next(ctx)
+		assert.NoError(t, err)
+		b, err := it.Next(ctx)
+		assert.NoError(t, err)
+		if !ascending {
+			a, b = b, a
+		}
+		assert.Equal(t, "/a.txt", a.Name)
+		assert.False(t, a.IsPrefix)
+		assert.Equal(t, FileInfo{Name: "/data/", IsPrefix: true}, b)
+		_, err = it.Next(ctx)
+		assert.Equal(t, io.EOF, err)
+	}
+
+	assert.Equal(t, []string{"/data/b.txt"}, listNames(t, c.Search("/*/*.txt", nil)))
+	assert.Equal(t, []string{"/a.txt", "/data/b.txt"}, listNames(t, c.Search(`^/.*\.txt$`, &SearchOpts{Regex: true, BatchSize: 1})))
+
+	info, err := c.Latest(ctx, "/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, id, info.FileID)
+	assert.Equal(t, uint64(len(data)), info.Size)
+
+	stat, err := c.Stat(ctx, "/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, info, stat.FileInfo)
+	assert.Equal(t, uint64(1), stat.NumVersions)
+	checksum, err := c.Checksum(ctx, bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, checksum, stat.Checksum)
+	checksum, err = c.Checksum(ctx, bytes.NewReader(data[1:]))
+	assert.NoError(t, err)
+	assert.NotEqual(t, checksum, stat.Checksum)
+	sha256Sum := sha256.Sum256(data)
+	assert.Equal(t, sha256Sum[:], stat.Checksums["sha256"])
+	stat, err = c.StatVersion(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "/a.txt", stat.Name)
+	_, err = c.Stat(ctx, "/missing.txt")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, c.Delete(ctx, id))
+	_, err = c.Latest(ctx, "/a.txt")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, c.Delete(ctx, id))
+
+	// Batch delete
+	id2, err := c.Upload(ctx, bytes.NewReader(data), "/c.txt")
+	assert.NoError(t, err)
+	deleted, err := c.DeleteBatch(ctx, []FileID{id2}, []string{"/data/b.txt"})
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.Contains(t, deleted, id2)
+	assert.Empty(t, listNames(t, c.List("/", nil)))
+
+	// Prefix delete
+	for _, name := range []string{"/logs/a.txt", "/logs/2020/b.txt"} {
+		_, err = c.Upload(ctx, bytes.NewReader(data), name)
+		assert.NoError(t, err)
+	}
+	want := PrefixStats{NumFiles: 2, NumFileVersions: 2, TotalSize: 2 * uint64(len(data))}
+	stats, err := c.DeletePrefix(ctx, "/logs", true)
+	assert.NoError(t, err)
+	assert.Equal(t, want, stats)
+	assert.Len(t, listNames(t, c.List("/", nil)), 2)
+	stats, err = c.DeletePrefix(ctx, "/logs", false)
+	assert.NoError(t, err)
+	assert.Equal(t, want, stats)
+	assert.Empty(t, listNames(t, c.List("/", nil)))
+
+	// Rename
+	for _, name := range []string{"/d.txt", "/e.txt", "/tmp/f.txt"} {
+		_, err = c.Upload(ctx, bytes.NewReader(data), name)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, c.Rename(ctx, "/d.txt", "/tmp/d.txt"))
+	assert.True(t, errors.Is(c.Rename(ctx, "/e.txt", "/tmp/d.txt"), ErrExists))
+	assert.Equal(t, ErrNotFound, c.Rename(ctx, "/d.txt", "/g.txt"))
+	n, err := c.RenamePrefix(ctx, "/tmp", "/final")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.ElementsMatch(t, []string{"/e.txt", "/final/d.txt", "/final/f.txt"}, listNames(t, c.List("/", nil)))
+
+	// Metadata
+	id, err = c.UploadWithMetadata(ctx, bytes.NewReader(data), "/m.txt", map[string]string{"env": "prod"})
+	assert.NoError(t, err)
+	m, err := c.SetMetadata(ctx, id, map[string]string{"team": "data"}, []string{"env"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "data"}, m)
+	info, err = c.Latest(ctx, "/m.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, m, info.Metadata)
+	assert.Equal(t, []string{"/m.txt"}, listNames(t, c.Search("", &SearchOpts{Metadata: m})))
+	_, err = c.SetMetadata(ctx, id2, m, nil)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestUploadByChecksum(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(343, 100*1024)
+	checksum := sha256.Sum256(data)
+	_, err := c.UploadByChecksum(ctx, checksum, "/b.bin", nil)
+	assert.Equal(t, ErrNotFound, err)
+
+	// Once the data is on the server, a version of another file references it
+	_, err = c.Upload(ctx, bytes.NewReader(data), "/a.bin")
+	assert.NoError(t, err)
+	res, err := c.UploadByChecksum(ctx, checksum, "/b.bin", &UploadOpts{Metadata: map[string]string{"k": "v"}})
+	assert.NoError(t, err)
+	assert.True(t, res.Referenced)
+	assert.Equal(t, "/b.bin", res.Name)
+	var out bytes.Buffer
+	assert.NoError(t, c.Download(ctx, res.ID, &out))
+	assert.Equal(t, data, out.Bytes())
+	stat, err := c.StatVersion(ctx, res.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"k": "v"}, stat.Metadata)
+
+	// Preconditions apply as with any upload
+	_, err = c.UploadByChecksum(ctx, checksum, "/b.bin", &UploadOpts{IfNotExists: true})
+	assert.Equal(t, ErrConflict, err)
+}
+
+func TestUploadConflicts(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(7, 10*1024)
+	for _, dir := range []string{"/replace", "/reject", "/branch"} {
+		name := dir + "/a.txt"
+		res, err := c.UploadWithResult(ctx, bytes.NewReader(data), name, &UploadOpts{BaseNotExists: true})
+		assert.NoError(t, err)
+		assert.Equal(t, UploadResult{ID: res.ID, Name: name, Conflict: NoConflict}, res)
+		base := res.ID
+
+		// Another client changes the file after the upload began
+		_, err = c.Upload(ctx, bytes.NewReader(data[:1024]), name)
+		assert.NoError(t, err)
+		res, err = c.UploadWithResult(ctx, bytes.NewReader(data), name, &UploadOpts{Base: &base})
+		switch dir {
+		case "/replace":
+			assert.NoError(t, err)
+			assert.Equal(t, ConflictReplaced, res.Conflict)
+			assert.Equal(t, name, res.Name)
+		case "/reject":
+			assert.Equal(t, ErrConflict, err)
+			assert.Equal(t, ConflictRejected, res.Conflict)
+		case "/branch":
+			assert.NoError(t, err)
+			assert.Equal(t, ConflictBranched, res.Conflict)
+			assert.Equal(t, "/branch/a.conflict-1.txt", res.Name)
+		}
+	}
+
+	// A file created by another client during a sync
+	dir, err := ioutil.TempDir("", "jotfs-conflicts-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.txt"), data, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "c.txt"), data, 0644))
+	opts := &SyncOpts{Progress: func(action string, name string) {
+		if name == "/reject/b.txt" {
+			_, err := c.Upload(ctx, bytes.NewReader(data[:1024]), name)
+			assert.NoError(t, err)
+		}
+	}}
+	result, err := c.Sync(ctx, dir, "/reject", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Uploaded)
+	assert.Equal(t, []SyncConflict{{Name: "/reject/b.txt", Resolution: ConflictRejected}}, result.Conflicts)
+}
+
+func TestPreconditions(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(6, 10*1024)
+	id1, err := c.UploadWithOpts(ctx, bytes.NewReader(data), "/a.txt", &UploadOpts{IfNotExists: true})
+	assert.NoError(t, err)
+	_, err = c.UploadWithOpts(ctx, bytes.NewReader(data), "/a.txt", &UploadOpts{IfNotExists: true})
+	assert.Equal(t, ErrConflict, err)
+
+	// Two clients updating the same version: the second update is rejected
+	id2, err := c.UploadWithOpts(ctx, bytes.NewReader(data), "/a.txt", &UploadOpts{IfMatch: &id1})
+	assert.NoError(t, err)
+	_, err = c.UploadWithOpts(ctx, bytes.NewReader(data), "/a.txt", &UploadOpts{IfMatch: &id1})
+	assert.Equal(t, ErrConflict, err)
+
+	assert.Equal(t, ErrConflict, c.DeleteIfMatch(ctx, "/a.txt", id1))
+	assert.NoError(t, c.DeleteIfMatch(ctx, "/a.txt", id2))
+	_, err = c.Latest(ctx, "/a.txt")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestDiff(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	old := randomData(7, 200*1024)
+	data := make([]byte, len(old))
+	copy(data, old)
+	data[100*1024] ^= 0xff
+	id1, err := c.Upload(ctx, bytes.NewReader(old), "/a.bin")
+	assert.NoError(t, err)
+	id2, err := c.Upload(ctx, bytes.NewReader(data), "/b.bin")
+	assert.NoError(t, err)
+
+	diff, err := c.Diff(ctx, id1, id2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(old)), diff.OldSize)
+	assert.Equal(t, uint64(len(data)), diff.NewSize)
+	assert.Len(t, diff.Added, 1)
+	assert.Len(t, diff.Removed, 1)
+	added := diff.Added[0]
+	assert.True(t, added.Offset <= 100*1024 && 100*1024 < added.Offset+added.Length)
+	assert.True(t, added.Length < diff.NewSize)
+
+	diff, err = c.Diff(ctx, id2, id2)
+	assert.NoError(t, err)
+	assert.Empty(t, diff.Added)
+	_, err = c.Diff(ctx, id1, FileID{})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestChunkReport(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(8, 200*1024)
+	_, err := c.Upload(ctx, bytes.NewReader(data), "/a.bin")
+	assert.NoError(t, err)
+	_, err = c.Upload(ctx, bytes.NewReader(data), "/b.bin")
+	assert.NoError(t, err)
+
+	r, err := c.ChunkReport(ctx, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.NotZero(t, r.AvgChunkSize)
+	assert.Equal(t, uint64(2), r.NumFileVersions)
+	assert.Equal(t, uint64(2*len(data)), r.FilesSize)
+	assert.Equal(t, uint64(len(data)), r.ChunksSize)
+	assert.InDelta(t, 0.5, r.DedupHitRate, 1e-9)
+	assert.Equal(t, uint64(1), r.NumPacks)
+	var n uint64
+	for _, b := range r.ChunkSizes {
+		n += b.Count
+	}
+	assert.Equal(t, r.NumChunks, n)
+
+	r, err = c.ChunkReport(ctx, time.Now().Add(time.Hour), time.Time{})
+	assert.NoError(t, err)
+	assert.Zero(t, r.NumFileVersions)
+}
+
+func TestPackfileSize(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	packs := func(data []byte, c *Client) (uint64, uint64) {
+		before, err := c.ChunkReport(ctx, time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		_, err = c.Upload(ctx, bytes.NewReader(data), "/a.bin")
+		assert.NoError(t, err)
+		after, err := c.ChunkReport(ctx, time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		return after.NumPacks - before.NumPacks, after.NumChunks - before.NumChunks
+	}
+
+	// The server's packfile size is used by default
+	n, _ := packs(randomData(10, 64*1024), c)
+	assert.Equal(t, uint64(1), n)
+	assert.Equal(t, uint64(defaultPackfileSize), c.packSize)
+
+	// The PackfileSize option limits the size of each packfile
+	limited, err := New(c.host, &Options{PackfileSize: 16 * 1024})
+	assert.NoError(t, err)
+	n, _ = packs(randomData(11, 64*1024), limited)
+	assert.GreaterOrEqual(t, n, uint64(4))
+	assert.Equal(t, uint64(16*1024), limited.packSize)
+
+	// A packfile is uploaded once the flush interval has passed since it was started
+	flushed, err := New(c.host, nil)
+	assert.NoError(t, err)
+	_, err = flushed.chunkerParams(ctx)
+	assert.NoError(t, err)
+	flushed.flushInterval = time.Nanosecond
+	n, chunks := packs(randomData(12, 64*1024), flushed)
+	assert.Greater(t, chunks, uint64(1))
+	assert.Equal(t, chunks, n)
+
+	// With UploadConcurrency, the packfiles of a file are uploaded at once
+	rt := &concurrentTransport{delay: 20 * time.Millisecond}
+	parallel, err := New(c.host, &Options{PackfileSize: 16 * 1024, UploadConcurrency: 4, HTTPClient: &http.Client{Transport: rt}})
+	assert.NoError(t, err)
+	data := randomData(13, 256*1024)
+	n, _ = packs(data, parallel)
+	assert.GreaterOrEqual(t, n, uint64(8))
+	assert.Greater(t, rt.max, 1)
+	assert.LessOrEqual(t, rt.max, 4)
+	info, err := parallel.Latest(ctx, "/a.bin")
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, parallel.Download(ctx, info.FileID, &out))
+	assert.Equal(t, data, out.Bytes())
+
+	// An upload fails if any of its packfiles fails
+	rt.fail = true
+	_, err = parallel.Upload(ctx, bytes.NewReader(randomData(14, 256*1024)), "/b.bin")
+	assert.Error(t, err)
+	_, err = parallel.Latest(ctx, "/b.bin")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+// concurrentTransport delays packfile uploads, recording the largest number uploaded
+// at once. If fail is set, packfile uploads are rejected.
+type concurrentTransport struct {
+	delay time.Duration
+	fail  bool
+
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (t *concurrentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path != "/packfile" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	t.mu.Lock()
+	t.current++
+	if t.current > t.max {
+		t.max = t.current
+	}
+	fail := t.fail
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.current--
+		t.mu.Unlock()
+	}()
+	time.Sleep(t.delay)
+	if fail {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Status:     "400 Bad Request",
+			Body:       ioutil.NopCloser(strings.NewReader("rejected")),
+			Request:    req,
+		}, nil
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestChunkAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := testClient(t)
+	defer cleanup()
+	params, err := c.chunkerParams(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, chunker.FastCDC, params.Algorithm)
+
+	fixed, cleanup := testClientParams(t, server.ChunkerParams{
+		MinChunkSize:  1024,
+		AvgChunkSize:  4096,
+		MaxChunkSize:  16 * 1024,
+		Normalization: 2,
+		Algorithm:     "fixed",
+	})
+	defer cleanup()
+	params, err = fixed.chunkerParams(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, chunker.Fixed, params.Algorithm)
+
+	// Files are chunked with the server's algorithm
+	data := randomData(339, 40*1024)
+	fileID, err := fixed.Upload(ctx, bytes.NewReader(data), "/fixed")
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, fixed.Download(ctx, fileID, &out))
+	assert.Equal(t, data, out.Bytes())
+}
+
+func TestChunkHash(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := testClientParams(t, server.ChunkerParams{
+		MinChunkSize:  1024,
+		AvgChunkSize:  4096,
+		MaxChunkSize:  16 * 1024,
+		Normalization: 2,
+		ChunkHash:     "sha256",
+	})
+	defer cleanup()
+	hash, err := c.hash(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, sum.SHA256, hash)
+
+	// Chunks are identified by the server's hash function
+	data := randomData(342, 200*1024)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/a.bin")
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, c.Download(ctx, id, &out))
+	assert.Equal(t, data, out.Bytes())
+
+	stat, err := c.StatVersion(ctx, id)
+	assert.NoError(t, err)
+	checksum, err := c.Checksum(ctx, bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, checksum, stat.Checksum)
+
+	changed := make([]byte, len(data))
+	copy(changed, data)
+	changed[100*1024] ^= 0xff
+	id, err = c.Upload(ctx, bytes.NewReader(changed), "/b.bin")
+	assert.NoError(t, err)
+	out.Reset()
+	stats, err := c.DownloadDelta(ctx, id, bytes.NewReader(data), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, changed, out.Bytes())
+	assert.True(t, stats.Reused > 0)
+}
+
+func TestInlineFiles(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := testClientConfig(t, server.Config{
+		Bucket:          "test",
+		MaxChunkSize:    16 * 1024,
+		MaxPackfileSize: 128 * miB,
+		InlineSize:      1024,
+		Params:          server.ChunkerParams{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16 * 1024, Normalization: 2},
+	})
+	defer cleanup()
+
+	// Files up to the inline size are sent with the request creating them, and
+	// larger files are chunked as usual
+	for _, size := range []int{1, 1024, 1025, 50 * 1024} {
+		data := randomData(int64(size), size)
+		id, err := c.Upload(ctx, bytes.NewReader(data), "/a.bin")
+		assert.NoError(t, err, size)
+		var out bytes.Buffer
+		assert.NoError(t, c.Download(ctx, id, &out), size)
+		assert.Equal(t, data, out.Bytes(), size)
+
+		f, err := c.OpenFile(ctx, "/a.bin", "")
+		assert.NoError(t, err, size)
+		b := make([]byte, 1)
+		_, err = f.ReadAt(b, int64(size-1))
+		assert.NoError(t, err, size)
+		assert.Equal(t, data[size-1:], b, size)
+	}
+	assert.Equal(t, uint64(1024), c.inlineSize)
+
+	// A delta download of a file stored inline holds its data
+	old := randomData(1, 100)
+	data := randomData(2, 100)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/b.bin")
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	_, err = c.DownloadDelta(ctx, id, bytes.NewReader(old), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, data, out.Bytes())
+}
+
+func TestAppend(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(1, 100*1024)
+	id, err := c.Upload(ctx, bytes.NewReader(data[:60*1024]), "/app.log")
+	assert.NoError(t, err)
+	id2, err := c.Append(ctx, bytes.NewReader(data[60*1024:]), "/app.log", &id)
+	assert.NoError(t, err)
+	info, err := c.Latest(ctx, "/app.log")
+	assert.NoError(t, err)
+	assert.Equal(t, id2, info.FileID)
+	var out bytes.Buffer
+	assert.NoError(t, c.Download(ctx, id2, &out))
+	assert.Equal(t, data, out.Bytes())
+
+	// Appending no data returns the latest version
+	id3, err := c.Append(ctx, bytes.NewReader(nil), "/app.log", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, id2, id3)
+
+	_, err = c.Append(ctx, strings.NewReader("more"), "/app.log", &id)
+	assert.Equal(t, ErrConflict, err)
+	_, err = c.Append(ctx, strings.NewReader("more"), "/missing.log", nil)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestConcat(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Parts uploaded in parallel are assembled in order
+	data := randomData(3, 300*1024)
+	parts := make([]FileID, 3)
+	var wg sync.WaitGroup
+	errs := make([]error, len(parts))
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			part := data[i*100*1024 : (i+1)*100*1024]
+			parts[i], errs[i] = c.Upload(ctx, bytes.NewReader(part), "/tmp/part"+strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	id, err := c.Concat(ctx, parts, "/whole.bin", &ConcatOpts{IfNotExists: true})
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, c.Download(ctx, id, &out))
+	assert.Equal(t, data, out.Bytes())
+
+	_, err = c.Concat(ctx, parts, "/whole.bin", &ConcatOpts{IfNotExists: true})
+	assert.Equal(t, ErrConflict, err)
+	_, err = c.Concat(ctx, []FileID{{}}, "/other.bin", nil)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestUploadArchive(t *testing.T) {
+	ctx := context.Background()
+	c, cleanup := testClientParams(t, server.ChunkerParams{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16 * 1024, Normalization: 2})
+	defer cleanup()
+
+	files := map[string][]byte{"a.txt": randomData(1, 10), "lib/b.bin": randomData(2, 50*1024), "lib/c.bin": nil}
+	names := []string{"a.txt", "lib/b.bin", "lib/c.bin"}
+	buf := new(bytes.Buffer)
+	w := tar.NewWriter(buf)
+	for _, name := range names {
+		assert.NoError(t, w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))}))
+		_, err := w.Write(files[name])
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	res, err := c.UploadArchive(ctx, buf, "/src")
+	assert.NoError(t, err)
+	if !assert.Len(t, res, len(names)) {
+		return
+	}
+	for i, name := range names {
+		assert.Equal(t, "/src/"+name, res[i].Name)
+		assert.Equal(t, uint64(len(files[name])), res[i].Size)
+		var out bytes.Buffer
+		assert.NoError(t, c.Download(ctx, res[i].ID, &out))
+		assert.Equal(t, len(files[name]), out.Len())
+		assert.True(t, bytes.Equal(files[name], out.Bytes()), name)
+	}
+
+	// A malformed archive creates no files
+	_, err = c.UploadArchive(ctx, strings.NewReader("not an archive"), "/bad")
+	assert.Error(t, err)
+}
+
+func TestChunkerProfiles(t *testing.T) {
+	ctx := context.Background()
+	params := server.ChunkerParams{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16 * 1024, Normalization: 2}
+	vm := server.ChunkerProfile{
+		Prefix:        "/vm",
+		ChunkerParams: server.ChunkerParams{MinChunkSize: 8 * 1024, AvgChunkSize: 20 * 1024, MaxChunkSize: 64 * 1024, Algorithm: "fixed"},
+	}
+	c, cleanup := testClientParams(t, params, vm)
+	defer cleanup()
+
+	// Files under the profile's prefix are chunked with its parameters, even when
+	// their chunks are larger than the server's maximum chunk size
+	data := randomData(340, 40*1024)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/vm/disk.img")
+	assert.NoError(t, err)
+	stat, err := c.Stat(ctx, "/vm/disk.img")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), stat.NumChunks)
+	_, err = c.Upload(ctx, bytes.NewReader(data), "/vmlinuz")
+	assert.NoError(t, err)
+	other, err := c.Stat(ctx, "/vmlinuz")
+	assert.NoError(t, err)
+	assert.Greater(t, other.NumChunks, uint64(2))
+
+	// Checksums and deltas use the parameters of the file's name
+	s, err := c.ChecksumAs(ctx, bytes.NewReader(data), "vm/disk.img")
+	assert.NoError(t, err)
+	assert.Equal(t, stat.Checksum, s)
+	s, err = c.Checksum(ctx, bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, other.Checksum, s)
+	var out bytes.Buffer
+	delta, err := c.DownloadDelta(ctx, id, bytes.NewReader(data), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), delta.Downloaded)
+	assert.Equal(t, data, out.Bytes())
+}
+
+func TestDownloadConcurrency(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Small packfiles split the file into many sections
+	uploader, err := New(c.host, &Options{PackfileSize: 16 * 1024})
+	assert.NoError(t, err)
+	data := randomData(20, 256*1024)
+	id, err := uploader.Upload(ctx, bytes.NewReader(data), "/a.bin")
+	assert.NoError(t, err)
+	resp, err := c.iclient.Download(ctx, &pb.FileID{Sum: id[:]})
+	assert.NoError(t, err)
+	assert.Greater(t, len(resp.Sections), 4)
+
+	for _, n := range []int{2, 4, 100} {
+		pc, err := New(c.host, &Options{DownloadConcurrency: n})
+		assert.NoError(t, err)
+		buf := new(bytes.Buffer)
+		assert.NoError(t, pc.Download(ctx, id, buf))
+		assert.Equal(t, data, buf.Bytes())
+	}
+
+	// Fetching stops at the first error
+	pc, err := New(c.host, &Options{DownloadConcurrency: 4})
+	assert.NoError(t, err)
+	sections := append([]*pb.Section{}, resp.Sections...)
+	sections[2] = &pb.Section{Chunks: sections[2].Chunks, Url: sections[2].Url + "x", RangeStart: 0, RangeEnd: 0}
+	var written []int
+	err = pc.fetchSections(ctx, sections, func(i int, chunks [][]byte) error {
+		written = append(written, i)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []int{0, 1}, written)
+}
+
+func TestRevertToVersion(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	data := randomData(9, 20*1024)
+	id1, err := c.Upload(ctx, bytes.NewReader(data), "/a.txt")
+	assert.NoError(t, err)
+	_, err = c.RevertToVersion(ctx, id1, &FileID{})
+	assert.Equal(t, ErrConflict, err)
+	id2, err := c.RevertToVersion(ctx, id1, &id1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+
+	info, err := c.Latest(ctx, "/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, id2, info.FileID)
+	var buf bytes.Buffer
+	assert.NoError(t, c.Download(ctx, id2, &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	_, err = c.RevertToVersion(ctx, FileID{}, nil)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestVersionTags(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id1, err := c.Upload(ctx, bytes.NewReader(randomData(10, 1024)), "/a.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, c.TagVersion(ctx, id1, "golden"))
+	assert.Equal(t, ErrNotFound, c.TagVersion(ctx, FileID{}, "golden"))
+
+	// The tagged version is kept when the file is replaced
+	id2, err := c.Upload(ctx, bytes.NewReader(randomData(11, 1024)), "/a.txt")
+	assert.NoError(t, err)
+	stat, err := c.StatTag(ctx, "/a.txt", "golden")
+	assert.NoError(t, err)
+	assert.Equal(t, id1, stat.FileID)
+	stat, err = c.Stat(ctx, "/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, id2, stat.FileID)
+
+	tags, err := c.Tags(ctx, "/a.txt")
+	assert.NoError(t, err)
+	if assert.Len(t, tags, 1) {
+		assert.Equal(t, "golden", tags[0].Tag)
+		assert.Equal(t, id1, tags[0].FileID)
+	}
+	assert.NoError(t, c.UntagVersion(ctx, "/a.txt", "golden"))
+	assert.Equal(t, ErrNotFound, c.UntagVersion(ctx, "/a.txt", "golden"))
+	_, err = c.StatTag(ctx, "/a.txt", "golden")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSnapshots(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	a1, err := c.Upload(ctx, bytes.NewReader(randomData(12, 1024)), "/data/a.txt")
+	assert.NoError(t, err)
+	_, err = c.Upload(ctx, bytes.NewReader(randomData(13, 1024)), "/other.txt")
+	assert.NoError(t, err)
+	snap, err := c.CreateSnapshot(ctx, "before", "/data")
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/", snap.Prefix)
+	assert.Equal(t, uint64(1), snap.NumFiles)
+	_, err = c.CreateSnapshot(ctx, "before", "/data")
+	assert.True(t, errors.Is(err, ErrExists))
+
+	a2, err := c.Upload(ctx, bytes.NewReader(randomData(14, 1024)), "/data/a.txt")
+	assert.NoError(t, err)
+	b, err := c.Upload(ctx, bytes.NewReader(randomData(15, 1024)), "/data/b.txt")
+	assert.NoError(t, err)
+	changes, err := c.DiffSnapshot(ctx, "before", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []SnapshotChange{
+		{Name: "/data/a.txt", Type: ChangeModified, Old: &a1, New: &a2},
+		{Name: "/data/b.txt", Type: ChangeAdded, New: &b},
+	}, changes)
+
+	// The replaced version is kept because it's in the snapshot
+	stats, err := c.RollbackSnapshot(ctx, "before", true)
+	assert.NoError(t, err)
+	assert.Equal(t, RollbackStats{Restored: 1, Deleted: 1}, stats)
+	changes, err = c.DiffSnapshot(ctx, "before", "")
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+	_, err = c.Stat(ctx, "/data/b.txt")
+	assert.Equal(t, ErrNotFound, err)
+
+	snaps, err := c.Snapshots(ctx, "")
+	assert.NoError(t, err)
+	if assert.Len(t, snaps, 1) {
+		assert.Equal(t, "before", snaps[0].Name)
+	}
+	assert.NoError(t, c.DeleteSnapshot(ctx, "before"))
+	assert.Equal(t, ErrNotFound, c.DeleteSnapshot(ctx, "before"))
+	_, err = c.DiffSnapshot(ctx, "before", "")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestChunkCache(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "jotfs-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := randomData(16, 64*1024)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/a.txt")
+	assert.NoError(t, err)
+	cached, err := New(c.host, &Options{CacheDir: dir})
+	assert.NoError(t, err)
+
+	// The second download reads every chunk from the cache
+	var buf bytes.Buffer
+	assert.NoError(t, cached.Download(ctx, id, &buf))
+	assert.Equal(t, data, buf.Bytes())
+	stats := cached.CacheStats()
+	assert.Zero(t, stats.Hits)
+	assert.NotZero(t, stats.Misses)
+	buf.Reset()
+	assert.NoError(t, cached.Download(ctx, id, &buf))
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, CacheStats{Hits: stats.Misses, Reused: uint64(len(data)), Misses: stats.Misses, Chunks: stats.Chunks, Size: stats.Size}, cached.CacheStats())
+	assert.NotZero(t, stats.Chunks)
+	assert.NotZero(t, stats.Size)
+
+	// The cache is reused by a new client, and a corrupt chunk is downloaded again
+	cache := newDiskCache(dir, 0, 0)
+	assert.NoError(t, cache.load())
+	assert.Len(t, cache.entries, int(stats.Misses))
+	var corrupt sum.Sum
+	for s := range cache.entries {
+		corrupt = s
+		break
+	}
+	assert.NoError(t, ioutil.WriteFile(cache.path(corrupt), []byte("corrupt"), 0o644))
+	_, ok := cache.get(corrupt, sum.BLAKE3)
+	assert.False(t, ok)
+	cached, err = New(c.host, &Options{CacheDir: dir})
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, cached.Download(ctx, id, &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// The least recently used chunks are evicted once the cache is full
+	cache = newDiskCache(dir, 3000, 0)
+	assert.NoError(t, cache.put(sum.Compute([]byte("x")), make([]byte, 1000)))
+	assert.LessOrEqual(t, cache.size, int64(3000))
+	assert.LessOrEqual(t, len(cache.entries), 2)
+	b := make([]byte, 1000)
+	s := sum.Compute(b)
+	assert.NoError(t, cache.put(s, b))
+	got, ok := cache.get(s, sum.BLAKE3)
+	assert.True(t, ok)
+	assert.Equal(t, b, got)
+
+	// The least recently used chunks are removed while the disk is low on free space,
+	// and a chunk is not added if there is still no room
+	cache = newDiskCache(dir, 3000, 500)
+	assert.NoError(t, cache.load())
+	free := uint64(1000)
+	cache.free = func(string) (uint64, error) { return free, nil }
+	c2 := bytes.Repeat([]byte{1}, 1000)
+	assert.NoError(t, cache.put(sum.Compute(c2), c2))
+	assert.NotZero(t, cache.stats().Shed)
+	assert.Contains(t, cache.entries, sum.Compute(c2))
+	free = 0
+	c3 := bytes.Repeat([]byte{2}, 2000)
+	assert.NoError(t, cache.put(sum.Compute(c3), c3))
+	assert.NotContains(t, cache.entries, sum.Compute(c3))
+	assert.Zero(t, cache.stats().Chunks)
+}
+
+func TestBundle(t *testing.T) {
+	src, cleanup := testClient(t)
+	defer cleanup()
+	dst, cleanup2 := testClient(t)
+	defer cleanup2()
+	ctx := context.Background()
+
+	a := randomData(17, 64*1024)
+	b := randomData(18, 1024)
+	_, err := src.UploadWithMetadata(ctx, bytes.NewReader(a), "/data/a.txt", map[string]string{"env": "prod"})
+	assert.NoError(t, err)
+	_, err = src.Upload(ctx, bytes.NewReader(a), "/data/sub/copy.txt")
+	assert.NoError(t, err)
+	_, err = src.Upload(ctx, bytes.NewReader(b), "/data/b.txt")
+	assert.NoError(t, err)
+	_, err = src.Upload(ctx, bytes.NewReader(b), "/other.txt")
+	assert.NoError(t, err)
+
+	// Chunks shared by files are only written once
+	var bundle bytes.Buffer
+	result, err := src.ExportBundle(ctx, "/data", &bundle, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Files)
+	assert.Equal(t, uint64(len(a)+len(b)), result.ChunkSize)
+
+	result, err = dst.ImportBundle(ctx, bytes.NewReader(bundle.Bytes()), "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Files)
+	info, err := dst.Latest(ctx, "/data/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, info.Metadata)
+	var buf bytes.Buffer
+	assert.NoError(t, dst.Download(ctx, info.FileID, &buf))
+	assert.Equal(t, a, buf.Bytes())
+	_, err = dst.Latest(ctx, "/other.txt")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Unchanged files are skipped, and files can be imported under another prefix
+	result, err = dst.ImportBundle(ctx, bytes.NewReader(bundle.Bytes()), "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, BundleResult{Unchanged: 3, Chunks: result.Chunks, ChunkSize: result.ChunkSize}, result)
+	result, err = dst.ImportBundle(ctx, bytes.NewReader(bundle.Bytes()), "/restored", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Files)
+	info, err = dst.Latest(ctx, "/restored/sub/copy.txt")
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, dst.Download(ctx, info.FileID, &buf))
+	assert.Equal(t, a, buf.Bytes())
+
+	// Corrupt chunks are rejected
+	corrupt := bundle.Bytes()
+	i := bytes.Index(corrupt, a[:64])
+	corrupt[i] ^= 0xff
+	_, err = dst.ImportBundle(ctx, bytes.NewReader(corrupt), "/corrupt", nil)
+	assert.Error(t, err)
+	_, err = dst.ImportBundle(ctx, strings.NewReader("not a bundle"), "", nil)
+	assert.Error(t, err)
+}
+
+func TestDownloadDelta(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	old := randomData(8, 200*1024)
+	data := make([]byte, len(old))
+	copy(data, old)
+	data[100*1024] ^= 0xff
+	_, err := c.Upload(ctx, bytes.NewReader(old), "/a.bin")
+	assert.NoError(t, err)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/b.bin")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	stats, err := c.DownloadDelta(ctx, id, bytes.NewReader(old), &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, uint64(len(data)), stats.Downloaded+stats.Reused)
+	assert.True(t, stats.Downloaded > 0 && stats.Downloaded < uint64(len(data))/4)
+
+	// Everything is downloaded without a useful base
+	buf.Reset()
+	stats, err = c.DownloadDelta(ctx, id, bytes.NewReader(nil), &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, uint64(len(data)), stats.Downloaded)
+
+	_, err = c.DownloadDelta(ctx, FileID{}, bytes.NewReader(old), &buf)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestParseFileID(t *testing.T) {
+	var id FileID
+	id[0] = 1
+	parsed, err := ParseFileID(id.String())
+	assert.NoError(t, err)
+	assert.Equal(t, id, parsed)
+
+	_, err = ParseFileID("abc")
+	assert.Error(t, err)
+}
+
+func TestSync(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "jotfs-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name string, data []byte) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("a.txt", randomData(1, 50*1024))
+	writeFile("sub/b.txt", randomData(2, 1024))
+	writeFile("sub/c.log", randomData(3, 1024))
+
+	// A file outside of the sync prefix, which should never be deleted
+	_, err = c.Upload(ctx, bytes.NewReader(nil), "/backup2/x.txt")
+	assert.NoError(t, err)
+
+	opts := &SyncOpts{Exclude: "*.log", Delete: true}
+	result, err := c.Sync(ctx, dir, "/backup", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, SyncResult{Uploaded: 2}, result)
+
+	// Nothing has changed
+	result, err = c.Sync(ctx, dir, "/backup", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, SyncResult{Unchanged: 2}, result)
+
+	// Modify one file without changing its size, and delete the other
+	writeFile("a.txt", randomData(4, 50*1024))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "sub", "b.txt")))
+	var actions []string
+	opts.Progress = func(action string, name string) {
+		actions = append(actions, action+" "+name)
+	}
+	result, err = c.Sync(ctx, dir, "/backup", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, SyncResult{Uploaded: 1, Deleted: 1}, result)
+	assert.Equal(t, []string{"upload /backup/a.txt", "delete /backup/sub/b.txt"}, actions)
+
+	names := listNames(t, c.List("/", nil))
+	assert.Equal(t, []string{"/backup/a.txt", "/backup2/x.txt"}, names)
+}
+
+func TestMirror(t *testing.T) {
+	src, cleanupSrc := testClient(t)
+	defer cleanupSrc()
+	dst, cleanupDst := testClient(t)
+	defer cleanupDst()
+	ctx := context.Background()
+
+	data := randomData(5, 100*1024)
+	_, err := src.UploadWithMetadata(ctx, bytes.NewReader(data), "/src/a.txt", map[string]string{"env": "prod"})
+	assert.NoError(t, err)
+	_, err = dst.Upload(ctx, bytes.NewReader(nil), "/dst/old.txt")
+	assert.NoError(t, err)
+
+	opts := &SyncOpts{Delete: true}
+	result, err := src.Mirror(ctx, dst, "/src", "/dst", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, SyncResult{Uploaded: 1, Deleted: 1}, result)
+	info, err := dst.Latest(ctx, "/dst/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, info.Metadata)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, dst.Download(ctx, info.FileID, buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// Nothing has changed
+	result, err = src.Mirror(ctx, dst, "/src", "/dst", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, SyncResult{Unchanged: 1}, result)
+
+	// The destination already has every chunk of a new file with the same data
+	_, err = src.Upload(ctx, bytes.NewReader(data), "/src/sub/b.txt")
+	assert.NoError(t, err)
+	result, err = src.Mirror(ctx, dst, "/src", "/dst", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, SyncResult{Uploaded: 1, Unchanged: 1}, result)
+	info, err = dst.Latest(ctx, "/dst/sub/b.txt")
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, dst.Download(ctx, info.FileID, buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	assert.ElementsMatch(t, []string{"/dst/a.txt", "/dst/sub/b.txt"}, listNames(t, dst.List("/", nil)))
+}
+
+func TestBuildIndex(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for _, name := range []string{"/dist/a.tar.gz", "/dist/v1/b c.zip", "/dist/v1/x/c.zip", "/dist/index.html", "/other/d"} {
+		_, err := c.Upload(ctx, bytes.NewReader([]byte(name)), name)
+		assert.NoError(t, err)
+	}
+
+	pages, err := c.BuildIndex(ctx, "/dist", nil)
+	assert.NoError(t, err)
+	assert.Len(t, pages, 3)
+
+	root := pages[""]
+	assert.Equal(t, "/dist/", root.Path)
+	assert.Equal(t, []string{"v1"}, root.Dirs)
+	assert.Len(t, root.Files, 1)
+	assert.Equal(t, "a.tar.gz", root.Files[0].Name)
+	assert.Equal(t, uint64(len("/dist/a.tar.gz")), root.Files[0].Size)
+
+	v1 := pages["v1"]
+	assert.Equal(t, "/dist/v1/", v1.Path)
+	assert.Equal(t, []string{"x"}, v1.Dirs)
+	assert.Equal(t, "b%20c.zip", v1.Files[0].URL)
+	assert.Equal(t, "/dist/v1/x/", pages["v1/x"].Path)
+
+	html, err := v1.HTML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(html), `<a href="x/index.html">x/</a>`)
+	assert.Contains(t, string(html), `<a href="b%20c.zip">b c.zip</a>`)
+	manifest, err := v1.JSON()
+	assert.NoError(t, err)
+	assert.Contains(t, string(manifest), `"path": "/dist/v1/"`)
+
+	// Links use the base URL if provided
+	pages, err = c.BuildIndex(ctx, "/dist", &IndexOpts{BaseURL: "https://example.com/files/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/files/dist/v1/b%20c.zip", pages["v1"].Files[0].URL)
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"/*.log", "/a/b/c.log", true},
+		{"/*.log", "/a/b/c.txt", false},
+		{"/a?c", "/abc", true},
+		{"/[ab].txt", "/b.txt", true},
+		{"/[^ab].txt", "/b.txt", false},
+		{"/a.(txt)", "/a.(txt)", true},
+	}
+	for _, test := range tests {
+		re, err := globRegexp(test.pattern)
+		assert.NoError(t, err)
+		assert.Equal(t, test.match, re.MatchString(test.name), test.pattern)
+	}
+
+	_, err := globRegexp("/[abc")
+	assert.Error(t, err)
+}
+
+func listNames(t *testing.T, it *FileIterator) []string {
+	var names []string
+	for {
+		info, err := it.Next(context.Background())
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, info.Name)
+	}
+}
+
+// testClient returns a client connected to an in-process server backed by an
+// in-memory store and database.
+func TestExport(t *testing.T) {
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "jotfs-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := randomData(1, 50*1024)
+	b := randomData(2, 1024)
+	for name, data := range map[string][]byte{"/data/a.txt": a, "/data/sub/b.txt": b, "/database.txt": b} {
+		_, err = c.Upload(ctx, bytes.NewReader(data), name)
+		assert.NoError(t, err)
+	}
+
+	first := filepath.Join(dir, "1")
+	result, err := c.Export(ctx, "/data", first, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ExportResult{Downloaded: 2}, result)
+	got, err := ioutil.ReadFile(filepath.Join(first, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, a, got)
+	got, err = ioutil.ReadFile(filepath.Join(first, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, b, got)
+	_, err = os.Stat(filepath.Join(first, "database.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Unchanged files are linked to the previous export
+	a2 := randomData(3, 50*1024)
+	_, err = c.Upload(ctx, bytes.NewReader(a2), "/data/a.txt")
+	assert.NoError(t, err)
+	second := filepath.Join(dir, "2")
+	var actions []string
+	opts := &ExportOpts{
+		LinkDest: first,
+		Progress: func(action string, name string) { actions = append(actions, action+" "+name) },
+	}
+	result, err = c.Export(ctx, "/data", second, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, ExportResult{Downloaded: 1, Linked: 1}, result)
+	assert.Equal(t, []string{"download /data/a.txt", "link /data/sub/b.txt"}, actions)
+
+	stat1, err := os.Stat(filepath.Join(first, "sub", "b.txt"))
+	assert.NoError(t, err)
+	stat2, err := os.Stat(filepath.Join(second, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(stat1, stat2))
+	got, err = ioutil.ReadFile(filepath.Join(second, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, a2, got)
+
+	// Re-exporting over a linked file does not modify the previous export
+	_, err = c.Upload(ctx, bytes.NewReader(a), "/data/sub/b.txt")
+	assert.NoError(t, err)
+	_, err = c.Export(ctx, "/data", second, nil)
+	assert.NoError(t, err)
+	got, err = ioutil.ReadFile(filepath.Join(first, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, b, got)
+}
+
+func testClient(t *testing.T) (*Client, func()) {
+	return testClientParams(t, server.ChunkerParams{
+		MinChunkSize:  1024,
+		AvgChunkSize:  4096,
+		MaxChunkSize:  16 * 1024,
+		Normalization: 2,
+	})
+}
+
+// testClientParams returns a client of a test server with the given chunker params
+// and profiles.
+func testClientParams(t *testing.T, params server.ChunkerParams, profiles ...server.ChunkerProfile) (*Client, func()) {
+	return testClientConfig(t, server.Config{
+		Bucket:          "test",
+		MaxChunkSize:    16 * 1024,
+		MaxPackfileSize: 128 * miB,
+		Params:          params,
+		Conflicts:       server.ConflictRules{"/reject": server.ConflictReject, "/branch": server.ConflictBranch},
+	}, profiles...)
+}
+
+// testClientConfig returns a client of a test server with the given config and
+// chunker profiles.
+func testClientConfig(t *testing.T, cfg server.Config, profiles ...server.ChunkerProfile) (*Client, func()) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	blobs := httptest.NewServer(s)
+	s.url = blobs.URL
+
+	srv := server.New(adapter, s, cfg)
+	for _, p := range profiles {
+		if _, err := srv.SetChunkerProfile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	twirpHandler := pb.NewJotFSServer(srv, nil)
+	mux.Handle(twirpHandler.PathPrefix(), twirpHandler)
+	mux.HandleFunc("/packfile", srv.PackfileUploadHandler)
+	mux.HandleFunc("/files", srv.FilesUploadHandler(nil))
+	api := httptest.NewServer(mux)
+
+	c, err := New(api.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		api.Close()
+		blobs.Close()
+	}
+}
+
+// memStore is an in-memory store which serves presigned URLs over HTTP.
+type memStore struct {
+	sync.Mutex
+	url  string
+	data map[string][]byte
+}
+
+func (s *memStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data[key] = b
+	return nil
+}
+
+func (s *memStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.data[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.data[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	if offset > uint64(len(b)) {
+		offset = uint64(len(b))
+	}
+	b = b[offset:]
+	if length < uint64(len(b)) {
+		b = b[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memStore) Copy(bucket string, from string, to string) error {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.data[from]
+	if !ok {
+		return store.ErrNotFound
+	}
+	s.data[to] = b
+	return nil
+}
+
+func (s *memStore) Delete(bucket string, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *memStore) PresignGetURL(bucket string, key string, expires time.Duration, contentRange *store.Range) (string, error) {
+	return s.url + "/" + key, nil
+}
+
+func (s *memStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	b, ok := s.data[strings.TrimPrefix(req.URL.Path, "/")]
+	s.Unlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	rnge := strings.TrimPrefix(req.Header.Get("Range"), "bytes=")
+	parts := strings.Split(rnge, "-")
+	from, err1 := strconv.Atoi(parts[0])
+	to, err2 := strconv.Atoi(parts[len(parts)-1])
+	if len(parts) != 2 || err1 != nil || err2 != nil || to >= len(b) {
+		http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(b[from : to+1])
+}
+
+func randomData(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	b := make([]byte, size)
+	rng.Read(b)
+	return b
+}
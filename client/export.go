@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportOpts may be provided to Export to configure its behaviour.
+type ExportOpts struct {
+	// LinkDest is a local directory containing a previous export of the same prefix.
+	// Files in LinkDest which have the same contents as the latest version on the
+	// server are hard-linked into the new export instead of being downloaded, so
+	// unchanged files take no extra disk space. LinkDest must be on the same
+	// filesystem as the export directory.
+	LinkDest string
+	// Progress, if set, is called before each file is exported. The action is one of
+	// "download" or "link".
+	Progress func(action string, name string)
+}
+
+// ExportResult summarises the files written by Export.
+type ExportResult struct {
+	Downloaded int
+	Linked     int
+}
+
+// Export writes the latest version of every file under prefix on the server to the
+// local directory dir, creating subdirectories as required. Each file's modification
+// time is set to the time its version was created. Existing files in dir are
+// replaced, never modified in place, so a file hard-linked from a previous export is
+// left unchanged.
+func (c *Client) Export(ctx context.Context, prefix string, dir string, opts *ExportOpts) (ExportResult, error) {
+	if opts == nil {
+		opts = &ExportOpts{}
+	}
+	prefix = path.Clean("/" + prefix)
+	dirPrefix := strings.TrimSuffix(prefix, "/") + "/"
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+
+	remote, err := c.latestVersions(ctx, prefix, &SyncOpts{})
+	if err != nil {
+		return ExportResult{}, fmt.Errorf("listing files: %w", err)
+	}
+	names := make([]string, 0, len(remote))
+	for name := range remote {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result ExportResult
+	for _, name := range names {
+		info := remote[name]
+		rel := filepath.FromSlash(strings.TrimPrefix(name, dirPrefix))
+		dst := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return result, err
+		}
+
+		if opts.LinkDest != "" {
+			linked, err := c.linkUnchanged(ctx, filepath.Join(opts.LinkDest, rel), dst, info)
+			if err != nil {
+				return result, fmt.Errorf("linking %s: %w", name, err)
+			}
+			if linked {
+				progress("link", name)
+				result.Linked++
+				continue
+			}
+		}
+
+		progress("download", name)
+		if err := c.downloadFile(ctx, info, dst); err != nil {
+			return result, fmt.Errorf("downloading %s: %w", name, err)
+		}
+		result.Downloaded++
+	}
+	return result, nil
+}
+
+// linkUnchanged hard-links src to dst if src has the same contents as the file
+// version. Returns false if src does not exist or has changed.
+func (c *Client) linkUnchanged(ctx context.Context, src string, dst string, info FileInfo) (bool, error) {
+	unchanged, err := c.unchanged(ctx, src, info)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil || !unchanged {
+		return false, err
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, os.Link(src, dst)
+}
+
+// downloadFile downloads a file version to a temporary file in the same directory as
+// dst, and renames it to dst once complete.
+func (c *Client) downloadFile(ctx context.Context, info FileInfo, dst string) error {
+	f, err := ioutil.TempFile(filepath.Dir(dst), ".jotfs-export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := c.Download(ctx, info.FileID, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(f.Name(), info.CreatedAt, info.CreatedAt); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	pb "github.com/jotfs/jotfs/internal/protos"
+)
+
+const (
+	// fileReadahead is the minimum amount of data a File requests from the store when
+	// it reads a chunk which is not cached.
+	fileReadahead = 4 * miB
+
+	// fileCacheSize is the maximum total size of the decoded chunks cached by a File.
+	fileCacheSize = 32 * miB
+)
+
+// File provides random access to the contents of a file version. It implements
+// io.ReaderAt, io.ReadSeeker and io.Closer, so it may be passed to libraries which
+// need random access to a file, such as archive/zip. Data is downloaded from the
+// store on demand, in ranges of whole chunks. Reading a chunk also reads the chunks
+// following it, and recently read chunks are cached. A File is safe for concurrent
+// use.
+type File struct {
+	c    *Client
+	ctx  context.Context
+	info FileInfo
+	// starts is the offset of each chunk in the file, followed by the file's size.
+	starts []uint64
+
+	mu     sync.Mutex
+	offset int64
+	cache  chunkCache
+}
+
+// OpenFile opens a version of a file for reading. If versionID is empty, the latest
+// version is opened. Returns ErrNotFound if the file or version does not exist. ctx
+// is used for all requests made by the File.
+func (c *Client) OpenFile(ctx context.Context, name string, versionID string) (*File, error) {
+	var info FileInfo
+	var err error
+	if versionID == "" {
+		if info, err = c.Latest(ctx, name); err != nil {
+			return nil, err
+		}
+	} else {
+		it := c.Head(name, nil)
+		for {
+			info, err = it.Next(ctx)
+			if err == io.EOF {
+				return nil, ErrNotFound
+			}
+			if err != nil {
+				return nil, err
+			}
+			if info.VersionID == versionID {
+				break
+			}
+		}
+	}
+	return c.Open(ctx, info)
+}
+
+// Open opens a file version for reading. Returns ErrNotFound if the file version does
+// not exist. ctx is used for all requests made by the File.
+func (c *Client) Open(ctx context.Context, info FileInfo) (*File, error) {
+	resp, err := c.iclient.Download(ctx, &pb.FileID{Sum: info.FileID[:]})
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting download sections: %w", err)
+	}
+	var starts []uint64
+	var offset uint64
+	for _, section := range resp.Sections {
+		for _, chunk := range section.Chunks {
+			if chunk.Sequence != uint64(len(starts)) {
+				return nil, fmt.Errorf("expected chunk %d but received %d", len(starts), chunk.Sequence)
+			}
+			starts = append(starts, offset)
+			offset += chunk.Size
+		}
+	}
+	starts = append(starts, offset)
+	if offset != info.Size {
+		return nil, fmt.Errorf("file size is %d but chunks total %d bytes", info.Size, offset)
+	}
+	f := &File{c: c, ctx: ctx, info: info, starts: starts}
+	f.cache.init(fileCacheSize)
+	return f, nil
+}
+
+// Stat returns the file version's metadata.
+func (f *File) Stat() FileInfo {
+	return f.info
+}
+
+// Size returns the size of the file in bytes.
+func (f *File) Size() int64 {
+	return int64(f.info.Size)
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		pos := uint64(off) + uint64(n)
+		if pos >= f.info.Size {
+			return n, io.EOF
+		}
+		// The last chunk with a start offset <= pos contains pos
+		seq := sort.Search(len(f.starts), func(i int) bool { return f.starts[i] > pos }) - 1
+		data, err := f.chunk(seq)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-f.starts[seq]:])
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(f.info.Size)
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close releases the cached data. It always returns nil.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache.init(fileCacheSize)
+	return nil
+}
+
+// chunk returns the decoded data for a chunk, downloading it and the chunks which
+// follow it, up to fileReadahead bytes, if it's not cached.
+func (f *File) chunk(seq int) ([]byte, error) {
+	if data, ok := f.cache.get(seq); ok {
+		return data, nil
+	}
+	start := f.starts[seq]
+	end := seq + 1
+	for end < len(f.starts)-1 && f.starts[end]-start < fileReadahead {
+		end++
+	}
+
+	resp, err := f.c.iclient.DownloadRange(f.ctx, &pb.DownloadRangeRequest{
+		Sum:    f.info.FileID[:],
+		Offset: start,
+		Length: f.starts[end] - start,
+	})
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting download sections: %w", err)
+	}
+	if resp.Offset != start {
+		return nil, fmt.Errorf("expected range to begin at %d but server returned %d", start, resp.Offset)
+	}
+
+	var data []byte
+	err = f.c.fetchSections(f.ctx, resp.Sections, func(i int, chunks [][]byte) error {
+		for j, b := range chunks {
+			s := int(resp.Sections[i].Chunks[j].Sequence)
+			if s >= len(f.starts)-1 || uint64(len(b)) != f.starts[s+1]-f.starts[s] {
+				return fmt.Errorf("chunk %d has unexpected size %d", s, len(b))
+			}
+			f.cache.put(s, b)
+			if s == seq {
+				data = b
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("chunk %d not returned by server", seq)
+	}
+	return data, nil
+}
+
+// chunkWriter collects the decoded chunks of a section. downloadSection calls Write
+// once for each chunk.
+type chunkWriter struct {
+	section *pb.Section
+	chunks  [][]byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if len(w.chunks) == len(w.section.Chunks) {
+		return 0, errors.New("too many chunks in section")
+	}
+	b := make([]byte, len(p))
+	copy(b, p)
+	w.chunks = append(w.chunks, b)
+	return len(p), nil
+}
+
+// chunkCache is a least-recently-used cache of decoded chunks, limited by their total
+// size.
+type chunkCache struct {
+	maxSize int
+	size    int
+	order   *list.List
+	items   map[int]*list.Element
+}
+
+type cacheEntry struct {
+	seq  int
+	data []byte
+}
+
+func (c *chunkCache) init(maxSize int) {
+	c.maxSize = maxSize
+	c.size = 0
+	c.order = list.New()
+	c.items = make(map[int]*list.Element)
+}
+
+func (c *chunkCache) get(seq int) ([]byte, bool) {
+	e, ok := c.items[seq]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+func (c *chunkCache) put(seq int, data []byte) {
+	if e, ok := c.items[seq]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[seq] = c.order.PushFront(&cacheEntry{seq, data})
+	c.size += len(data)
+	// Always keep the most recent chunk, even if it's larger than the cache
+	for c.size > c.maxSize && c.order.Len() > 1 {
+		e := c.order.Back()
+		entry := e.Value.(*cacheEntry)
+		c.order.Remove(e)
+		delete(c.items, entry.seq)
+		c.size -= len(entry.data)
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the files generated for each directory by BuildIndex. Files with these
+// names are excluded from the index.
+const (
+	IndexHTMLName = "index.html"
+	IndexJSONName = "index.json"
+)
+
+// IndexOpts may be provided to BuildIndex to configure the generated index.
+type IndexOpts struct {
+	// BaseURL, if set, is prepended to the full name of each file to create its link.
+	// Otherwise, links are relative to the page.
+	BaseURL string
+	// Exclude is a glob pattern. Files matching the pattern are not included in the
+	// index. The pattern has the same syntax as ListOpts.Exclude.
+	Exclude string
+	// Include is a glob pattern which forces inclusion of files matched by Exclude.
+	Include string
+}
+
+// IndexPage is the listing of a single directory.
+type IndexPage struct {
+	// Path is the full path of the directory, ending in a slash.
+	Path string `json:"path"`
+	// Dirs are the names of the directory's subdirectories, in sorted order.
+	Dirs []string `json:"dirs"`
+	// Files are the latest versions of the files in the directory, sorted by name.
+	Files []IndexFile `json:"files"`
+}
+
+// IndexFile is a file listed in an IndexPage.
+type IndexFile struct {
+	Name      string    `json:"name"`
+	Size      uint64    `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	FileID    string    `json:"file_id"`
+	URL       string    `json:"url"`
+}
+
+// BuildIndex creates a listing of the latest version of every file under prefix. It
+// returns a page for each directory, keyed by the directory's path relative to prefix.
+// The key of the page for prefix itself is "".
+func (c *Client) BuildIndex(ctx context.Context, prefix string, opts *IndexOpts) (map[string]*IndexPage, error) {
+	if opts == nil {
+		opts = &IndexOpts{}
+	}
+	prefix = path.Clean("/" + prefix)
+	files, err := c.latestVersions(ctx, prefix, &SyncOpts{Exclude: opts.Exclude, Include: opts.Include})
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	dirPrefix := strings.TrimSuffix(prefix, "/") + "/"
+
+	pages := make(map[string]*IndexPage)
+	page := func(rel string) *IndexPage {
+		p, ok := pages[rel]
+		if !ok {
+			p = &IndexPage{Path: path.Join(dirPrefix, rel) + "/", Dirs: []string{}, Files: []IndexFile{}}
+			if rel == "" {
+				p.Path = dirPrefix
+			}
+			pages[rel] = p
+		}
+		return p
+	}
+	page("")
+
+	for name, info := range files {
+		rel := strings.TrimPrefix(name, dirPrefix)
+		dir, base := path.Split(rel)
+		if base == IndexHTMLName || base == IndexJSONName {
+			continue
+		}
+		dir = strings.TrimSuffix(dir, "/")
+		link := url.PathEscape(base)
+		if opts.BaseURL != "" {
+			link = strings.TrimSuffix(opts.BaseURL, "/") + escapePath(name)
+		}
+		p := page(dir)
+		p.Files = append(p.Files, IndexFile{
+			Name:      base,
+			Size:      info.Size,
+			CreatedAt: info.CreatedAt,
+			FileID:    info.FileID.String(),
+			URL:       link,
+		})
+
+		// Make sure each parent directory lists its child
+		for dir != "" {
+			parent, child := path.Split(dir)
+			parent = strings.TrimSuffix(parent, "/")
+			pp := page(parent)
+			if !containsString(pp.Dirs, child) {
+				pp.Dirs = append(pp.Dirs, child)
+			}
+			dir = parent
+		}
+	}
+
+	for _, p := range pages {
+		sort.Strings(p.Dirs)
+		sort.Slice(p.Files, func(i, j int) bool { return p.Files[i].Name < p.Files[j].Name })
+	}
+	return pages, nil
+}
+
+// escapePath escapes each element of a slash-separated path.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func containsString(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"escape": url.PathEscape,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Created</th></tr>
+{{- range .Dirs}}
+<tr><td><a href="{{escape .}}/index.html">{{.}}/</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Files}}
+<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// HTML renders the page as an HTML document.
+func (p *IndexPage) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// JSON renders the page as a JSON manifest.
+func (p *IndexPage) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
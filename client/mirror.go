@@ -0,0 +1,224 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/rs/xid"
+)
+
+// Mirror copies the latest version of every file under srcPrefix on this client's
+// server to dstPrefix on the server of dst. A file is only copied if it does not exist
+// under dstPrefix, or if its data differs from the latest version there. If dst already
+// stores every chunk of a file, the file is created from the chunks' checksums without
+// transferring any data. Otherwise, the file is downloaded and uploaded, and only the
+// chunks missing from dst are sent. Metadata is copied with each file. Options are
+// interpreted as for Sync, with the source files taking the place of the local
+// directory.
+func (c *Client) Mirror(ctx context.Context, dst *Client, srcPrefix string, dstPrefix string, opts *SyncOpts) (SyncResult, error) {
+	if opts == nil {
+		opts = &SyncOpts{}
+	}
+	srcPrefix = path.Clean("/" + srcPrefix)
+	dstPrefix = path.Clean("/" + dstPrefix)
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+
+	src, err := c.latestVersions(ctx, srcPrefix, opts)
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("listing source files: %w", err)
+	}
+	remote, err := dst.latestVersions(ctx, dstPrefix, opts)
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("listing destination files: %w", err)
+	}
+
+	// Key the source files by their name under dstPrefix
+	local := make(map[string]FileInfo, len(src))
+	names := make([]string, 0, len(src))
+	for name, info := range src {
+		name = path.Join(dstPrefix, strings.TrimPrefix(name, srcPrefix))
+		local[name] = info
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result SyncResult
+	for _, name := range names {
+		info := local[name]
+		if dstInfo, ok := remote[name]; ok {
+			unchanged, err := sameData(ctx, c, info, dst, dstInfo)
+			if err != nil {
+				return result, fmt.Errorf("comparing %s: %w", info.Name, err)
+			}
+			if unchanged {
+				result.Unchanged++
+				continue
+			}
+		}
+		progress("upload", name)
+		if !opts.DryRun {
+			if _, err := c.mirrorFile(ctx, dst, info, name); err != nil {
+				return result, fmt.Errorf("copying %s: %w", info.Name, err)
+			}
+		}
+		result.Uploaded++
+	}
+
+	if !opts.Delete {
+		return result, nil
+	}
+	var deleted []string
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	for len(deleted) > 0 {
+		n := len(deleted)
+		if n > MaxDeleteBatch {
+			n = MaxDeleteBatch
+		}
+		batch := deleted[:n]
+		deleted = deleted[n:]
+		for _, name := range batch {
+			progress("delete", name)
+		}
+		if !opts.DryRun {
+			if _, err := dst.DeleteBatch(ctx, nil, batch); err != nil {
+				return result, fmt.Errorf("deleting files: %w", err)
+			}
+		}
+		result.Deleted += len(batch)
+	}
+	return result, nil
+}
+
+// Replicate copies the file version id from this client's server to the server of dst,
+// as a version of the file of the same name, unless the latest version of the file on
+// dst already has the same data. The data and metadata are copied as with Mirror.
+// Returns the ID of the file version on dst.
+func (c *Client) Replicate(ctx context.Context, dst *Client, id FileID) (FileID, error) {
+	stat, err := c.StatVersion(ctx, id)
+	if err != nil {
+		return FileID{}, err
+	}
+	info := stat.FileInfo
+	latest, err := dst.Latest(ctx, info.Name)
+	if err != nil && err != ErrNotFound {
+		return FileID{}, fmt.Errorf("getting latest version on destination: %w", err)
+	}
+	if err == nil {
+		unchanged, err := sameData(ctx, c, info, dst, latest)
+		if err != nil {
+			return FileID{}, fmt.Errorf("comparing %s: %w", info.Name, err)
+		}
+		if unchanged {
+			return latest.FileID, nil
+		}
+	}
+	return c.mirrorFile(ctx, dst, info, info.Name)
+}
+
+// sameData returns true if file version a on client ca has the same data as file
+// version b on client cb. The whole-file SHA-256 checksums of the versions are
+// compared if both servers computed them. Otherwise, the versions' chunk checksums are
+// compared, which only match if both servers use the same chunker parameters.
+func sameData(ctx context.Context, ca *Client, a FileInfo, cb *Client, b FileInfo) (bool, error) {
+	if a.Size != b.Size {
+		return false, nil
+	}
+	statA, err := ca.StatVersion(ctx, a.FileID)
+	if err != nil {
+		return false, err
+	}
+	statB, err := cb.StatVersion(ctx, b.FileID)
+	if err != nil {
+		return false, err
+	}
+	sumA, okA := statA.Checksums["sha256"]
+	sumB, okB := statB.Checksums["sha256"]
+	if okA && okB {
+		return bytes.Equal(sumA, sumB), nil
+	}
+	return statA.Checksum == statB.Checksum, nil
+}
+
+// mirrorFile copies a file version from this client's server to the server of dst as
+// a file named name. Returns the ID of the new file version on dst.
+func (c *Client) mirrorFile(ctx context.Context, dst *Client, info FileInfo, name string) (FileID, error) {
+	sums, err := c.fileSums(ctx, info.FileID)
+	if err != nil {
+		return FileID{}, err
+	}
+	if sums == nil {
+		return FileID{}, fmt.Errorf("data of file version %s is unavailable", info.FileID)
+	}
+
+	// Negotiate the chunks dst already has
+	allSums := make([][]byte, len(sums))
+	for i := range sums {
+		allSums[i] = sums[i][:]
+	}
+	exists, err := dst.chunksExist(ctx, allSums)
+	if err != nil {
+		return FileID{}, fmt.Errorf("checking chunks: %w", err)
+	}
+	if exists {
+		file := &pb.File{Name: name, Sums: allSums, IdempotencyKey: xid.New().String(), Metadata: info.Metadata}
+		stat, err := c.StatVersion(ctx, info.FileID)
+		if err != nil {
+			return FileID{}, err
+		}
+		if s, ok := stat.Checksums["sha256"]; ok {
+			file.Checksums = map[string][]byte{"sha256": s}
+		}
+		id, err := dst.createFile(ctx, file)
+		if err != nil {
+			return FileID{}, fmt.Errorf("creating file: %w", err)
+		}
+		return fileIDFromBytes(id.Sum)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.Download(ctx, info.FileID, pw))
+	}()
+	id, err := dst.UploadWithMetadata(ctx, pr, name, info.Metadata)
+	pr.CloseWithError(err)
+	return id, err
+}
+
+// chunksExist returns true if the server stores every chunk in sums. Returns false if
+// sums is empty.
+func (c *Client) chunksExist(ctx context.Context, sums [][]byte) (bool, error) {
+	if len(sums) == 0 {
+		return false, nil
+	}
+	for len(sums) > 0 {
+		n := len(sums)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		resp, err := c.iclient.ChunksExist(ctx, &pb.ChunksExistRequest{Sums: sums[:n]})
+		if err != nil {
+			return false, err
+		}
+		for _, ok := range resp.Exists {
+			if !ok {
+				return false, nil
+			}
+		}
+		sums = sums[n:]
+	}
+	return true, nil
+}
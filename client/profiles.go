@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+)
+
+// chunkerProfile is the chunking parameters the server sets for the files under a
+// prefix.
+type chunkerProfile struct {
+	Prefix        string `json:"prefix"`
+	MinChunkSize  int    `json:"min_chunk_size"`
+	AvgChunkSize  int    `json:"avg_chunk_size"`
+	MaxChunkSize  int    `json:"max_chunk_size"`
+	Normalization int    `json:"normalization"`
+	Algorithm     string `json:"algorithm"`
+}
+
+// parseChunkerProfiles parses the chunker profiles header of a GetChunkerParams
+// response. Servers which predate profiles, or have none, don't send it.
+func parseChunkerProfiles(header string) ([]chunkerProfile, error) {
+	if header == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("decoding chunker profiles: %w", err)
+	}
+	var profiles []chunkerProfile
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, fmt.Errorf("decoding chunker profiles: %w", err)
+	}
+	for _, p := range profiles {
+		if _, err := chunker.ParseAlgorithm(p.Algorithm); err != nil {
+			return nil, fmt.Errorf("chunker profile %s: %w", p.Prefix, err)
+		}
+	}
+	return profiles, nil
+}
+
+// inPrefix returns true if a file named name is under prefix. Both are cleaned as
+// the server cleans file names.
+func inPrefix(name string, prefix string) bool {
+	name = path.Clean("/" + name)
+	prefix = path.Clean("/" + prefix)
+	return prefix == "/" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// chunkerOptions returns the options to chunk a file named name with: those of the
+// chunker profile with the longest prefix of the name, or the server's parameters if
+// no profile applies.
+func (c *Client) chunkerOptions(ctx context.Context, name string) (chunker.Options, error) {
+	opts, err := c.chunkerParams(ctx)
+	if err != nil {
+		return chunker.Options{}, err
+	}
+	var match *chunkerProfile
+	for i, p := range c.profiles {
+		if inPrefix(name, p.Prefix) && (match == nil || len(p.Prefix) > len(match.Prefix)) {
+			match = &c.profiles[i]
+		}
+	}
+	if match == nil {
+		return opts, nil
+	}
+	return chunker.Options{
+		MinChunkSize:  match.MinChunkSize,
+		AvgChunkSize:  match.AvgChunkSize,
+		MaxChunkSize:  match.MaxChunkSize,
+		Normalization: match.Normalization,
+		Algorithm:     chunker.Algorithm(match.Algorithm),
+	}, nil
+}
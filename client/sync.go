@@ -0,0 +1,379 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/twitchtv/twirp"
+)
+
+// SyncOpts may be provided to Sync to configure its behaviour.
+type SyncOpts struct {
+	// Exclude is a glob pattern. Files with a remote name matching the pattern are
+	// not synced. The pattern has the same syntax as ListOpts.Exclude.
+	Exclude string
+	// Include is a glob pattern which forces inclusion of files matched by Exclude.
+	Include string
+	// Delete removes files from the server, under the destination prefix, which do not
+	// exist in the local directory. All versions of a deleted file are removed.
+	Delete bool
+	// DryRun reports the changes Sync would make without making them.
+	DryRun bool
+	// Progress, if set, is called before each file is uploaded or deleted. The action
+	// is one of "upload" or "delete".
+	Progress func(action string, name string)
+}
+
+// SyncResult summarises the changes made by Sync.
+type SyncResult struct {
+	Uploaded  int
+	Unchanged int
+	Deleted   int
+	// Conflicts are the files changed on the server by another client while they were
+	// being synced, and how the server resolved each conflict. Rejected files are not
+	// counted as uploaded.
+	Conflicts []SyncConflict
+}
+
+// SyncConflict is a file changed by another client while it was being synced.
+type SyncConflict struct {
+	Name       string
+	Resolution ConflictResolution
+	// Branch is the name the local file was uploaded to if it was branched.
+	Branch string
+}
+
+// Sync uploads every file in the local directory dir, and its subdirectories, to the
+// server under prefix. A file is only uploaded if it does not exist on the server, or
+// if its chunks differ from those in the latest version of the file on the server. If
+// another client changes a file between the comparison and the upload, the server
+// resolves the conflict with the strategy configured for the file, and the conflict
+// is reported in the result.
+func (c *Client) Sync(ctx context.Context, dir string, prefix string, opts *SyncOpts) (SyncResult, error) {
+	if opts == nil {
+		opts = &SyncOpts{}
+	}
+	filter, err := newSyncFilter(opts.Exclude, opts.Include)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	prefix = path.Clean("/" + prefix)
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+
+	local, err := walkDir(dir, prefix, filter)
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("reading directory %s: %w", dir, err)
+	}
+	remote, err := c.latestVersions(ctx, prefix, opts)
+	if err != nil {
+		return SyncResult{}, fmt.Errorf("listing files: %w", err)
+	}
+
+	var result SyncResult
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		src := local[name]
+		base := &UploadOpts{BaseNotExists: true}
+		if info, ok := remote[name]; ok {
+			unchanged, err := c.unchanged(ctx, src, info)
+			if err != nil {
+				return result, fmt.Errorf("comparing %s: %w", src, err)
+			}
+			if unchanged {
+				result.Unchanged++
+				continue
+			}
+			base = &UploadOpts{Base: &info.FileID}
+		}
+		progress("upload", name)
+		if !opts.DryRun {
+			res, err := c.uploadFile(ctx, src, name, base)
+			if res.Conflict != NoConflict {
+				conflict := SyncConflict{Name: name, Resolution: res.Conflict}
+				if res.Conflict == ConflictBranched {
+					conflict.Branch = res.Name
+				}
+				result.Conflicts = append(result.Conflicts, conflict)
+			}
+			if errors.Is(err, ErrConflict) {
+				continue
+			}
+			if err != nil {
+				return result, fmt.Errorf("uploading %s: %w", src, err)
+			}
+		}
+		result.Uploaded++
+	}
+
+	if !opts.Delete {
+		return result, nil
+	}
+	var deleted []string
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	for len(deleted) > 0 {
+		n := len(deleted)
+		if n > MaxDeleteBatch {
+			n = MaxDeleteBatch
+		}
+		batch := deleted[:n]
+		deleted = deleted[n:]
+		for _, name := range batch {
+			progress("delete", name)
+		}
+		if !opts.DryRun {
+			if _, err := c.DeleteBatch(ctx, nil, batch); err != nil {
+				return result, fmt.Errorf("deleting files: %w", err)
+			}
+		}
+		result.Deleted += len(batch)
+	}
+	return result, nil
+}
+
+// walkDir returns the local path of every regular file in dir, keyed by its remote
+// name under prefix.
+func walkDir(dir string, prefix string, filter syncFilter) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		if filter.match(name) {
+			files[name] = p
+		}
+		return nil
+	})
+	return files, err
+}
+
+// latestVersions returns the latest version of each file under prefix.
+func (c *Client) latestVersions(ctx context.Context, prefix string, opts *SyncOpts) (map[string]FileInfo, error) {
+	// The server matches any name beginning with prefix, so we need to exclude
+	// files in sibling directories e.g. /data2 for prefix /data
+	dirPrefix := strings.TrimSuffix(prefix, "/") + "/"
+	it := c.List(dirPrefix, &ListOpts{Exclude: opts.Exclude, Include: opts.Include})
+	files := make(map[string]FileInfo)
+	for {
+		info, err := it.Next(ctx)
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(info.Name, dirPrefix) {
+			continue
+		}
+		// Results are in reverse-chronological order so the first version seen is
+		// the latest
+		if _, ok := files[info.Name]; !ok {
+			files[info.Name] = info
+		}
+	}
+}
+
+// unchanged returns true if the local file src has the same chunks as a file version
+// on the server.
+func (c *Client) unchanged(ctx context.Context, src string, info FileInfo) (bool, error) {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	if uint64(stat.Size()) != info.Size {
+		return false, nil
+	}
+	remote, err := c.fileSums(ctx, info.FileID)
+	if err != nil {
+		return false, err
+	}
+	if remote == nil {
+		return false, nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	params, err := c.chunkerOptions(ctx, info.Name)
+	if err != nil {
+		return false, err
+	}
+	hash, err := c.hash(ctx)
+	if err != nil {
+		return false, err
+	}
+	ck, err := chunker.New(f, params)
+	if err != nil {
+		return false, fmt.Errorf("creating chunker: %w", err)
+	}
+	for i := 0; ; i++ {
+		chunk, err := ck.Next()
+		if err == io.EOF {
+			return i == len(remote), nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if i >= len(remote) || hash.Compute(chunk.Data) != remote[i] {
+			return false, nil
+		}
+	}
+}
+
+// fileSums returns the checksum of each chunk in a file version, in order. Returns a
+// nil slice if the file's data is unavailable on the server.
+func (c *Client) fileSums(ctx context.Context, id FileID) ([]sum.Sum, error) {
+	resp, err := c.iclient.Download(ctx, &pb.FileID{Sum: id[:]})
+	var terr twirp.Error
+	if errors.As(err, &terr) && (terr.Code() == twirp.NotFound || terr.Code() == twirp.DataLoss) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sums := make([]sum.Sum, 0)
+	for _, section := range resp.Sections {
+		for _, chunk := range section.Chunks {
+			s, err := sum.FromBytes(chunk.Sum)
+			if err != nil {
+				return nil, err
+			}
+			sums = append(sums, s)
+		}
+	}
+	return sums, nil
+}
+
+// uploadFile uploads the local file src to the server as dst. If the server already
+// has a file version with the same data, the new version is created by reference to it
+// and nothing is uploaded.
+func (c *Client) uploadFile(ctx context.Context, src string, dst string, opts *UploadOpts) (UploadResult, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer f.Close()
+	var checksum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return UploadResult{}, err
+	}
+	copy(checksum[:], h.Sum(nil))
+	res, err := c.UploadByChecksum(ctx, checksum, dst, opts)
+	if !errors.Is(err, ErrNotFound) {
+		return res, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return UploadResult{}, err
+	}
+	return c.UploadWithResult(ctx, f, dst, opts)
+}
+
+// syncFilter matches file names against exclude and include patterns using the same
+// glob syntax as the server.
+type syncFilter struct {
+	exclude *regexp.Regexp
+	include *regexp.Regexp
+}
+
+func newSyncFilter(exclude string, include string) (syncFilter, error) {
+	// Patterns are cleaned by the server in the same way as file names
+	exclude = cleanPattern(exclude)
+	include = cleanPattern(include)
+	var f syncFilter
+	var err error
+	if exclude != "" {
+		if f.exclude, err = globRegexp(exclude); err != nil {
+			return f, fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+	}
+	if include != "" {
+		if f.include, err = globRegexp(include); err != nil {
+			return f, fmt.Errorf("invalid include pattern: %w", err)
+		}
+	}
+	return f, nil
+}
+
+func cleanPattern(p string) string {
+	if p == "" {
+		return p
+	}
+	p = path.Clean(strings.TrimSpace(p))
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return strings.TrimSuffix(p, "/")
+}
+
+// match returns true if name should be synced.
+func (f syncFilter) match(name string) bool {
+	if f.exclude == nil || !f.exclude.MatchString(name) {
+		return true
+	}
+	return f.include != nil && f.include.MatchString(name)
+}
+
+// globRegexp converts a SQLite GLOB pattern to a regular expression. As with GLOB, *
+// and ? match any character including /.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == 0 {
+				// A ] immediately after [ is part of the character class
+				end = strings.IndexByte(pattern[i+2:], ']') + 1
+			}
+			if end <= 0 {
+				return nil, fmt.Errorf("unterminated character class in %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrAborted is returned by FileWriter.Close if the writer was aborted.
+var ErrAborted = errors.New("aborted")
+
+// FileWriter is an io.WriteCloser which uploads the data written to it as a new
+// version of a file. Data is chunked and deduplicated as it is written, and requests
+// which fail with a transient error are retried. The file version is only created
+// when Close succeeds, so a failed or aborted upload never leaves a partial file.
+type FileWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	id   FileID
+	err  error
+}
+
+// NewFileWriter returns a FileWriter which uploads a new version of the file name.
+// The upload is cancelled if ctx is cancelled before Close returns.
+func (c *Client) NewFileWriter(ctx context.Context, name string) *FileWriter {
+	pr, pw := io.Pipe()
+	w := &FileWriter{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		w.id, w.err = c.Upload(ctx, pr, name)
+		// Unblock any pending writes, and fail subsequent writes, with the upload error
+		pr.CloseWithError(w.err)
+	}()
+	return w
+}
+
+// Write writes p to the file. If the upload has failed, Write returns its error.
+func (w *FileWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close finishes the upload and creates the file version. It blocks until the
+// remaining data has been uploaded, and returns the upload error, if any. Calling
+// Close more than once returns the same result.
+func (w *FileWriter) Close() error {
+	w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+// Abort cancels the upload without creating a file version. Data already sent to the
+// server is removed by the server's next vacuum. After Abort, Close returns an error
+// wrapping ErrAborted, unless the upload had already completed.
+func (w *FileWriter) Abort() {
+	w.pw.CloseWithError(ErrAborted)
+	<-w.done
+}
+
+// ID returns the ID of the new file version. It is only valid after Close returns
+// nil.
+func (w *FileWriter) ID() FileID {
+	return w.id
+}
@@ -0,0 +1,1345 @@
+// jot is a command line client for a JotFS server.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jotfs/jotfs/client"
+)
+
+const (
+	defaultEndpoint = "http://localhost:6777"
+	jotPrefix       = "jot://"
+	miB             = 1024 * 1024
+)
+
+const usage = `usage: jot [-endpoint URL] [-key KEY] <command> [arguments]
+
+Commands:
+  cp     copy files to, from, and within the server
+  mv     rename files and directories on the server
+  ls     list files
+  find   search for files by name
+  meta   view or change the metadata of a file
+  stat   display information about a file
+  diff   show the byte ranges which changed between two versions of a file
+  revert restore a previous version of a file as its latest version
+  tag    list, add or remove the tags of a file's versions
+  snap   take, list, compare, restore or remove snapshots of a directory
+  rm     remove files
+  cat    write files to stdout
+  sync   upload changed files in a local directory
+  mirror copy changed files from one server to another
+  index  generate static HTML and JSON listings of a directory
+  report show how recently uploaded data was chunked, packed and deduplicated
+
+Remote files are prefixed with %s. A local file name of "-" refers to stdin or
+stdout. Run jot <command> -h for help on a command.
+`
+
+// apiKey is sent with each request to the server.
+var apiKey string
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error
+	flags func(flags *flag.FlagSet)
+}
+
+var commands = []*command{cpCmd, mvCmd, lsCmd, findCmd, metaCmd, statCmd, diffCmd, revertCmd, tagCmd, snapshotCmd, rmCmd, catCmd, appendCmd, syncCmd, mirrorCmd, indexCmd, reportCmd}
+
+func run() error {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, jotPrefix)
+		flag.PrintDefaults()
+	}
+	endpoint := flag.String("endpoint", defaultEndpoint, "server endpoint")
+	flag.StringVar(&apiKey, "key", os.Getenv("JOT_KEY"), "API key for servers with an access policy. Defaults to $JOT_KEY")
+	cacheDir := flag.String("cache_dir", os.Getenv("JOT_CACHE_DIR"), "directory in which to cache downloaded chunks for reuse. Defaults to $JOT_CACHE_DIR")
+	cacheSize := flag.Int64("cache_size", 1024, "maximum size of the chunk cache in MiB")
+	cacheMinFree := flag.Int64("cache_min_free", 0, "MiB of free disk space to keep on the file system of the chunk cache. Cached chunks are removed when free space is lower. Disabled if 0")
+	concurrency := flag.Int("download_concurrency", 1, "number of sections of a file to fetch from the store at once when downloading")
+	uploadConcurrency := flag.Int("upload_concurrency", 1, "number of packfiles of a file to send to the server at once when uploading")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return errors.New("no command given")
+	}
+	name := flag.Arg(0)
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: jot %s %s\n", cmd.name, cmd.usage)
+		flags.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	flags.Parse(flag.Args()[1:])
+
+	c, err := client.New(*endpoint, &client.Options{
+		Key:                 apiKey,
+		CacheDir:            *cacheDir,
+		CacheSize:           *cacheSize * miB,
+		CacheMinFreeSpace:   *cacheMinFree * miB,
+		DownloadConcurrency: *concurrency,
+		UploadConcurrency:   *uploadConcurrency,
+	})
+	if err != nil {
+		return err
+	}
+	return cmd.run(context.Background(), c, flags)
+}
+
+// remoteName returns the file name of a remote path, and true if s is a remote path.
+func remoteName(s string) (string, bool) {
+	if strings.HasPrefix(s, jotPrefix) {
+		return "/" + strings.TrimPrefix(s, jotPrefix), true
+	}
+	return "", false
+}
+
+var (
+	cpMetadata    = metadataFlag{}
+	cpIfMatch     string
+	cpIfNotExists bool
+	cpDelta       bool
+	cpTag         string
+)
+
+var cpCmd = &command{
+	name:  "cp",
+	usage: "[flags] <src> <dst>",
+	flags: func(flags *flag.FlagSet) {
+		flags.Var(cpMetadata, "meta", "attach metadata to an uploaded file, as key=value. May be repeated")
+		flags.StringVar(&cpIfMatch, "if_match", "", "only upload the file if the ID of its latest version is this ID")
+		flags.BoolVar(&cpIfNotExists, "if_not_exists", false, "only upload the file if it does not exist")
+		flags.BoolVar(&cpDelta, "delta", false, "when downloading over an existing local file, only download the chunks which differ from it")
+		flags.StringVar(&cpTag, "tag", "", "copy the version of a remote src with this tag instead of the latest version, or tag the uploaded version of a local src")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return errors.New("expected 2 arguments")
+		}
+		src, dst := flags.Arg(0), flags.Arg(1)
+		srcName, srcRemote := remoteName(src)
+		dstName, dstRemote := remoteName(dst)
+
+		switch {
+		case !srcRemote && dstRemote:
+			if strings.HasSuffix(dstName, "/") {
+				dstName += filepath.Base(src)
+			}
+			return upload(ctx, c, src, dstName)
+		case srcRemote && !dstRemote:
+			if info, err := os.Stat(dst); err == nil && info.IsDir() {
+				dst = filepath.Join(dst, path.Base(srcName))
+			}
+			return download(ctx, c, srcName, dst)
+		case srcRemote && dstRemote:
+			if strings.HasSuffix(dstName, "/") {
+				dstName += path.Base(srcName)
+			}
+			info, err := srcVersion(ctx, c, srcName)
+			if err != nil {
+				return err
+			}
+			if _, err = c.Copy(ctx, info.FileID, dstName); err != nil {
+				return err
+			}
+			fmt.Printf("copy: %s -> %s\n", src, jotPrefix+strings.TrimPrefix(dstName, "/"))
+			return nil
+		default:
+			return fmt.Errorf("at least one of src or dst must begin with %s", jotPrefix)
+		}
+	},
+}
+
+func upload(ctx context.Context, c *client.Client, src string, dst string) error {
+	var r io.Reader
+	if src == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	opts := &client.UploadOpts{Metadata: cpMetadata, IfNotExists: cpIfNotExists}
+	if cpIfMatch != "" {
+		id, err := client.ParseFileID(cpIfMatch)
+		if err != nil {
+			return fmt.Errorf("invalid -if_match: %w", err)
+		}
+		opts.IfMatch = &id
+	}
+	id, err := c.UploadWithOpts(ctx, r, dst, opts)
+	if err != nil {
+		return err
+	}
+	if cpTag != "" {
+		if err := c.TagVersion(ctx, id, cpTag); err != nil {
+			return fmt.Errorf("tagging %s: %w", dst, err)
+		}
+	}
+	if src != "-" {
+		fmt.Printf("upload: %s -> %s\n", src, jotPrefix+strings.TrimPrefix(dst, "/"))
+	}
+	return nil
+}
+
+func download(ctx context.Context, c *client.Client, src string, dst string) error {
+	info, err := srcVersion(ctx, c, src)
+	if err != nil {
+		return err
+	}
+	if dst == "-" {
+		return c.Download(ctx, info.FileID, os.Stdout)
+	}
+
+	// With -delta, the existing file is the base for the download
+	var base *os.File
+	if cpDelta {
+		base, err = os.Open(dst)
+		if os.IsNotExist(err) {
+			base = nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Download to a temporary file first so we don't leave a partial file behind
+	// if the download fails
+	f, err := os.Create(dst + ".jotpart")
+	if err != nil {
+		if base != nil {
+			base.Close()
+		}
+		return err
+	}
+	var stats *client.DeltaStats
+	if base != nil {
+		var s client.DeltaStats
+		s, err = c.DownloadDelta(ctx, info.FileID, base, f)
+		base.Close()
+		stats = &s
+	} else {
+		err = c.Download(ctx, info.FileID, f)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return err
+	}
+	if stats != nil {
+		fmt.Printf("download: %s -> %s (%d bytes downloaded, %d bytes reused)\n", jotPrefix+strings.TrimPrefix(src, "/"), dst, stats.Downloaded, stats.Reused)
+		return nil
+	}
+	fmt.Printf("download: %s -> %s\n", jotPrefix+strings.TrimPrefix(src, "/"), dst)
+	return nil
+}
+
+// srcVersion returns the version of a remote cp source: the version with the -tag
+// flag's tag if it is set, or the latest version.
+func srcVersion(ctx context.Context, c *client.Client, name string) (client.FileInfo, error) {
+	if cpTag == "" {
+		return latest(ctx, c, name)
+	}
+	stat, err := c.StatTag(ctx, name, cpTag)
+	if errors.Is(err, client.ErrNotFound) {
+		return client.FileInfo{}, fmt.Errorf("file %s has no tag %s", name, cpTag)
+	}
+	return stat.FileInfo, err
+}
+
+func latest(ctx context.Context, c *client.Client, name string) (client.FileInfo, error) {
+	info, err := c.Latest(ctx, name)
+	if errors.Is(err, client.ErrNotFound) {
+		return client.FileInfo{}, fmt.Errorf("file %s does not exist", name)
+	}
+	return info, err
+}
+
+var (
+	lsLong      bool
+	lsVersions  bool
+	lsExclude   string
+	lsInclude   string
+	lsSort      string
+	lsAscending bool
+	lsDirs      bool
+)
+
+// lsSortOrders are the values of the ls -sort flag.
+var lsSortOrders = map[string]client.SortOrder{
+	"time": client.SortCreatedAt,
+	"name": client.SortName,
+	"size": client.SortSize,
+}
+
+var lsCmd = &command{
+	name:  "ls",
+	usage: "[flags] <prefix>",
+	flags: func(flags *flag.FlagSet) {
+		flags.BoolVar(&lsLong, "l", false, "include the file ID and metadata in the output")
+		flags.BoolVar(&lsVersions, "versions", false, "list all versions of a single file")
+		flags.StringVar(&lsExclude, "exclude", "", "exclude files matching a glob pattern")
+		flags.StringVar(&lsInclude, "include", "", "include files excluded by -exclude which match a glob pattern")
+		flags.StringVar(&lsSort, "sort", "time", "sort by time, name or size. Descending unless -asc is set")
+		flags.BoolVar(&lsAscending, "asc", false, "sort in ascending order")
+		flags.BoolVar(&lsDirs, "d", false, "list the files and subdirectories in a directory, instead of every file under the prefix. Sorts by name")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		prefix := "/"
+		if flags.NArg() > 1 {
+			flags.Usage()
+			return errors.New("expected at most 1 argument")
+		}
+		if flags.NArg() == 1 {
+			prefix = flags.Arg(0)
+			if name, ok := remoteName(prefix); ok {
+				prefix = name
+			}
+		}
+
+		sortOrder, ok := lsSortOrders[lsSort]
+		if !ok {
+			return fmt.Errorf("invalid -sort %q", lsSort)
+		}
+		opts := &client.ListOpts{Exclude: lsExclude, Include: lsInclude, Sort: sortOrder, Ascending: lsAscending}
+		if lsDirs {
+			opts.Sort = client.SortName
+			opts.Delimiter = "/"
+			if !strings.HasSuffix(prefix, "/") {
+				prefix += "/"
+			}
+		}
+		var it *client.FileIterator
+		if lsVersions {
+			it = c.Head(prefix, opts)
+		} else {
+			it = c.List(prefix, opts)
+		}
+		return printFiles(ctx, it, lsLong)
+	},
+}
+
+var (
+	findLong     bool
+	findRegex    bool
+	findMetadata = metadataFlag{}
+)
+
+var findCmd = &command{
+	name:  "find",
+	usage: "[flags] <pattern>",
+	flags: func(flags *flag.FlagSet) {
+		flags.BoolVar(&findLong, "l", false, "include the file ID and metadata in the output")
+		flags.BoolVar(&findRegex, "regex", false, "treat the pattern as a regular expression instead of a glob")
+		flags.Var(findMetadata, "meta", "only find files with metadata key=value. May be repeated")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() > 1 || (flags.NArg() == 0 && len(findMetadata) == 0) {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+		pattern := flags.Arg(0)
+		if name, ok := remoteName(pattern); ok {
+			pattern = name
+		}
+		opts := &client.SearchOpts{Regex: findRegex, Metadata: findMetadata}
+		return printFiles(ctx, c.Search(pattern, opts), findLong)
+	},
+}
+
+var (
+	metaSet    = metadataFlag{}
+	metaDelete stringsFlag
+)
+
+var metaCmd = &command{
+	name:  "meta",
+	usage: "[flags] <file>",
+	flags: func(flags *flag.FlagSet) {
+		flags.Var(metaSet, "set", "set metadata key=value on the latest version of the file. May be repeated")
+		flags.Var(&metaDelete, "delete", "remove a metadata key from the latest version of the file. May be repeated")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+		name, ok := remoteName(flags.Arg(0))
+		if !ok {
+			return fmt.Errorf("file must begin with %s", jotPrefix)
+		}
+		info, err := latest(ctx, c, name)
+		if err != nil {
+			return err
+		}
+		metadata := info.Metadata
+		if len(metaSet) > 0 || len(metaDelete) > 0 {
+			if metadata, err = c.SetMetadata(ctx, info.FileID, metaSet, metaDelete); err != nil {
+				return err
+			}
+		}
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, metadata[k])
+		}
+		return nil
+	},
+}
+
+var statCmd = &command{
+	name:  "stat",
+	usage: "<file>",
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+		name, ok := remoteName(flags.Arg(0))
+		if !ok {
+			return fmt.Errorf("file must begin with %s", jotPrefix)
+		}
+		stat, err := c.Stat(ctx, name)
+		if errors.Is(err, client.ErrNotFound) {
+			return fmt.Errorf("file %s does not exist", name)
+		}
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Name:\t%s\n", stat.Name)
+		fmt.Fprintf(w, "Size:\t%d\n", stat.Size)
+		fmt.Fprintf(w, "Created:\t%s\n", stat.CreatedAt.Local().Format(time.RFC3339))
+		fmt.Fprintf(w, "File ID:\t%s\n", stat.FileID)
+		fmt.Fprintf(w, "Version ID:\t%s\n", stat.VersionID)
+		fmt.Fprintf(w, "Versions:\t%d\n", stat.NumVersions)
+		fmt.Fprintf(w, "Chunks:\t%d\n", stat.NumChunks)
+		fmt.Fprintf(w, "Checksum:\t%s\n", stat.Checksum)
+		algorithms := make([]string, 0, len(stat.Checksums))
+		for a := range stat.Checksums {
+			algorithms = append(algorithms, a)
+		}
+		sort.Strings(algorithms)
+		for _, a := range algorithms {
+			fmt.Fprintf(w, "%s:\t%x\n", strings.ToUpper(a), stat.Checksums[a])
+		}
+		if len(stat.Metadata) > 0 {
+			fmt.Fprintf(w, "Metadata:\t%s\n", formatMetadata(stat.Metadata))
+		}
+		return w.Flush()
+	},
+}
+
+var (
+	diffFrom string
+	diffTo   string
+)
+
+var diffCmd = &command{
+	name:  "diff",
+	usage: "[flags] <file>",
+	flags: func(flags *flag.FlagSet) {
+		flags.StringVar(&diffFrom, "from", "", "ID of the old version (default: the version before the latest)")
+		flags.StringVar(&diffTo, "to", "", "ID of the new version (default: the latest version)")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+		name, ok := remoteName(flags.Arg(0))
+		if !ok {
+			return fmt.Errorf("file must begin with %s", jotPrefix)
+		}
+
+		// Default to the latest two versions of the file
+		var versions []client.FileID
+		if diffFrom == "" || diffTo == "" {
+			it := c.Head(name, &client.ListOpts{BatchSize: 2})
+			for len(versions) < 2 {
+				info, err := it.Next(ctx)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				versions = append(versions, info.FileID)
+			}
+			if len(versions) < 2 {
+				return fmt.Errorf("file %s has fewer than 2 versions", name)
+			}
+		}
+		parse := func(flagName string, s string, def client.FileID) (client.FileID, error) {
+			if s == "" {
+				return def, nil
+			}
+			id, err := client.ParseFileID(s)
+			if err != nil {
+				return client.FileID{}, fmt.Errorf("invalid -%s: %w", flagName, err)
+			}
+			return id, nil
+		}
+		var from, to client.FileID
+		if len(versions) == 2 {
+			from, to = versions[1], versions[0]
+		}
+		from, err := parse("from", diffFrom, from)
+		if err != nil {
+			return err
+		}
+		to, err = parse("to", diffTo, to)
+		if err != nil {
+			return err
+		}
+
+		diff, err := c.Diff(ctx, from, to)
+		if errors.Is(err, client.ErrNotFound) {
+			return errors.New("file version does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		var added, removed uint64
+		for _, r := range diff.Removed {
+			fmt.Printf("- %d-%d (%d bytes, %d chunks)\n", r.Offset, r.Offset+r.Length, r.Length, r.NumChunks)
+			removed += r.Length
+		}
+		for _, r := range diff.Added {
+			fmt.Printf("+ %d-%d (%d bytes, %d chunks)\n", r.Offset, r.Offset+r.Length, r.Length, r.NumChunks)
+			added += r.Length
+		}
+		fmt.Printf("%s..%s: %d bytes added, %d bytes removed, size %d -> %d\n",
+			from, to, added, removed, diff.OldSize, diff.NewSize)
+		return nil
+	},
+}
+
+var revertVersion string
+
+var revertCmd = &command{
+	name:  "revert",
+	usage: "[flags] <file>",
+	flags: func(flags *flag.FlagSet) {
+		flags.StringVar(&revertVersion, "version", "", "ID of the version to restore (default: the version before the latest)")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+		name, ok := remoteName(flags.Arg(0))
+		if !ok {
+			return fmt.Errorf("file must begin with %s", jotPrefix)
+		}
+
+		var versions []client.FileInfo
+		it := c.Head(name, &client.ListOpts{BatchSize: 2})
+		for len(versions) < 2 {
+			info, err := it.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			versions = append(versions, info)
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("file %s does not exist", name)
+		}
+		latest := versions[0].FileID
+
+		var id client.FileID
+		if revertVersion == "" {
+			if len(versions) < 2 {
+				return fmt.Errorf("file %s has no previous version", name)
+			}
+			id = versions[1].FileID
+		} else {
+			var err error
+			if id, err = client.ParseFileID(revertVersion); err != nil {
+				return fmt.Errorf("invalid -version: %w", err)
+			}
+			stat, err := c.StatVersion(ctx, id)
+			if errors.Is(err, client.ErrNotFound) {
+				return errors.New("file version does not exist")
+			}
+			if err != nil {
+				return err
+			}
+			if stat.Name != versions[0].Name {
+				return fmt.Errorf("version %s is a version of %s", id, stat.Name)
+			}
+		}
+
+		newID, err := c.RevertToVersion(ctx, id, &latest)
+		if errors.Is(err, client.ErrConflict) {
+			return fmt.Errorf("file %s was changed concurrently", name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored %s as %s\n", id, newID)
+		return nil
+	},
+}
+
+var (
+	tagVersion string
+	tagDelete  bool
+)
+
+var tagCmd = &command{
+	name:  "tag",
+	usage: "[flags] <file> [tag]",
+	flags: func(flags *flag.FlagSet) {
+		flags.StringVar(&tagVersion, "version", "", "ID of the version to tag (default: the latest version)")
+		flags.BoolVar(&tagDelete, "d", false, "remove the tag from the file")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() < 1 || flags.NArg() > 2 {
+			flags.Usage()
+			return errors.New("expected 1 or 2 arguments")
+		}
+		name, ok := remoteName(flags.Arg(0))
+		if !ok {
+			return fmt.Errorf("file must begin with %s", jotPrefix)
+		}
+
+		// List the file's tags if no tag is given
+		if flags.NArg() == 1 {
+			tags, err := c.Tags(ctx, name)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, t := range tags {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", t.CreatedAt.Local().Format(time.RFC3339), t.FileID, t.Tag)
+			}
+			return w.Flush()
+		}
+
+		tag := flags.Arg(1)
+		if tagDelete {
+			err := c.UntagVersion(ctx, name, tag)
+			if errors.Is(err, client.ErrNotFound) {
+				return fmt.Errorf("file %s has no tag %s", name, tag)
+			}
+			return err
+		}
+		var id client.FileID
+		if tagVersion == "" {
+			info, err := latest(ctx, c, name)
+			if err != nil {
+				return err
+			}
+			id = info.FileID
+		} else {
+			var err error
+			if id, err = client.ParseFileID(tagVersion); err != nil {
+				return fmt.Errorf("invalid -version: %w", err)
+			}
+			stat, err := c.StatVersion(ctx, id)
+			if errors.Is(err, client.ErrNotFound) {
+				return errors.New("file version does not exist")
+			}
+			if err != nil {
+				return err
+			}
+			if stat.Name != name {
+				return fmt.Errorf("version %s is a version of %s", id, stat.Name)
+			}
+		}
+		return c.TagVersion(ctx, id, tag)
+	},
+}
+
+var (
+	snapshotDiff     bool
+	snapshotTo       string
+	snapshotRollback bool
+	snapshotPrune    bool
+	snapshotDelete   bool
+)
+
+var snapshotCmd = &command{
+	name:  "snap",
+	usage: "[flags] [name] [dir]",
+	flags: func(flags *flag.FlagSet) {
+		flags.BoolVar(&snapshotDiff, "diff", false, "list the files changed since the snapshot was taken")
+		flags.StringVar(&snapshotTo, "to", "", "with -diff, compare the snapshot to this snapshot instead of the latest files")
+		flags.BoolVar(&snapshotRollback, "rollback", false, "restore the files in the snapshot to their versions in the snapshot")
+		flags.BoolVar(&snapshotPrune, "prune", false, "with -rollback, remove the files added since the snapshot was taken")
+		flags.BoolVar(&snapshotDelete, "d", false, "remove the snapshot")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() > 2 {
+			flags.Usage()
+			return errors.New("expected at most 2 arguments")
+		}
+		if flags.NArg() != 1 && (snapshotDiff || snapshotRollback || snapshotDelete) {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+
+		// List the snapshots if no name is given, or take a snapshot if a directory is given
+		if flags.NArg() == 0 {
+			snaps, err := c.Snapshots(ctx, "")
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, s := range snaps {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", s.CreatedAt.Local().Format(time.RFC3339), s.NumFiles, s.TotalSize, s.Prefix, s.Name)
+			}
+			return w.Flush()
+		}
+		name := flags.Arg(0)
+		if flags.NArg() == 2 {
+			dir, ok := remoteName(flags.Arg(1))
+			if !ok {
+				return fmt.Errorf("dir must begin with %s", jotPrefix)
+			}
+			snap, err := c.CreateSnapshot(ctx, name, dir)
+			if errors.Is(err, client.ErrExists) {
+				return fmt.Errorf("snapshot %s already exists", name)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("snapshot %s: %d files (%d bytes) in %s\n", snap.Name, snap.NumFiles, snap.TotalSize, snap.Prefix)
+			return nil
+		}
+
+		var err error
+		switch {
+		case snapshotDiff:
+			var changes []client.SnapshotChange
+			changes, err = c.DiffSnapshot(ctx, name, snapshotTo)
+			if err == nil {
+				for _, ch := range changes {
+					fmt.Printf("%-8s  %s\n", ch.Type, ch.Name)
+				}
+			}
+		case snapshotRollback:
+			var stats client.RollbackStats
+			stats, err = c.RollbackSnapshot(ctx, name, snapshotPrune)
+			if errors.Is(err, client.ErrConflict) {
+				return fmt.Errorf("files in snapshot %s were changed concurrently", name)
+			}
+			if err == nil {
+				fmt.Printf("restored %d files, removed %d files\n", stats.Restored, stats.Deleted)
+				if stats.Unavailable > 0 {
+					fmt.Printf("%d files unchanged because their version in the snapshot was removed\n", stats.Unavailable)
+				}
+			}
+		case snapshotDelete:
+			err = c.DeleteSnapshot(ctx, name)
+		default:
+			flags.Usage()
+			return errors.New("expected one of -diff, -rollback or -d, or a directory to snapshot")
+		}
+		if errors.Is(err, client.ErrNotFound) {
+			if snapshotTo != "" {
+				return fmt.Errorf("snapshot %s or %s does not exist", name, snapshotTo)
+			}
+			return fmt.Errorf("snapshot %s does not exist", name)
+		}
+		return err
+	},
+}
+
+// metadataFlag is a flag.Value which collects repeated key=value arguments.
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	return formatMetadata(m)
+}
+
+func (m metadataFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	m[s[:i]] = s[i+1:]
+	return nil
+}
+
+// stringsFlag is a flag.Value which collects repeated arguments.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// formatMetadata returns the key=value pairs of a file's metadata, in key order.
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + metadata[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// printFiles writes a line to stdout for each result of an iterator.
+func printFiles(ctx context.Context, it *client.FileIterator, long bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for {
+		info, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsPrefix {
+			if long {
+				fmt.Fprintf(w, "\tDIR\t\t%s\n", info.Name)
+			} else {
+				fmt.Fprintf(w, "\tDIR\t%s\n", info.Name)
+			}
+			continue
+		}
+		createdAt := info.CreatedAt.Local().Format(time.RFC3339)
+		if long {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", createdAt, info.Size, info.FileID, info.Name, formatMetadata(info.Metadata))
+		} else {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", createdAt, info.Size, info.Name)
+		}
+	}
+	return w.Flush()
+}
+
+var mvRecursive bool
+
+var mvCmd = &command{
+	name:  "mv",
+	usage: "[flags] <src> <dst>",
+	flags: func(flags *flag.FlagSet) {
+		flags.BoolVar(&mvRecursive, "r", false, "move every file in the directory src and its subdirectories to dst")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return errors.New("expected 2 arguments")
+		}
+		src, dst := flags.Arg(0), flags.Arg(1)
+		srcName, srcRemote := remoteName(src)
+		dstName, dstRemote := remoteName(dst)
+		if !srcRemote || !dstRemote {
+			return fmt.Errorf("src and dst must begin with %s", jotPrefix)
+		}
+		if mvRecursive {
+			n, err := c.RenamePrefix(ctx, srcName, dstName)
+			if err != nil {
+				return fmt.Errorf("moving %s: %w", src, err)
+			}
+			fmt.Printf("move: %s -> %s (%d files)\n", src, dst, n)
+			return nil
+		}
+		if strings.HasSuffix(dstName, "/") {
+			dstName += path.Base(srcName)
+		}
+		if err := c.Rename(ctx, srcName, dstName); err != nil {
+			return fmt.Errorf("moving %s: %w", src, err)
+		}
+		fmt.Printf("move: %s -> %s\n", src, jotPrefix+strings.TrimPrefix(dstName, "/"))
+		return nil
+	},
+}
+
+var (
+	rmAll       bool
+	rmRecursive bool
+	rmDryRun    bool
+	rmIfMatch   string
+)
+
+var rmCmd = &command{
+	name:  "rm",
+	usage: "[flags] <file>...",
+	flags: func(flags *flag.FlagSet) {
+		flags.BoolVar(&rmAll, "a", false, "remove all versions of the file")
+		flags.BoolVar(&rmRecursive, "r", false, "remove all versions of every file in the directory and its subdirectories")
+		flags.BoolVar(&rmDryRun, "dryrun", false, "with -r, output the number of files which would be removed without removing them")
+		flags.StringVar(&rmIfMatch, "if_match", "", "with -a, only remove the file if the ID of its latest version is this ID")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() == 0 {
+			flags.Usage()
+			return errors.New("expected at least 1 argument")
+		}
+		for _, arg := range flags.Args() {
+			name := arg
+			if n, ok := remoteName(arg); ok {
+				name = n
+			}
+			if rmRecursive {
+				stats, err := c.DeletePrefix(ctx, name, rmDryRun)
+				if err != nil {
+					return fmt.Errorf("deleting %s: %w", name, err)
+				}
+				action := "delete"
+				if rmDryRun {
+					action = "(dryrun) delete"
+				}
+				fmt.Printf("%s: %s (%d files, %d versions, %d bytes)\n", action, jotPrefix+strings.TrimPrefix(name, "/"),
+					stats.NumFiles, stats.NumFileVersions, stats.TotalSize)
+				continue
+			}
+			if rmAll && rmIfMatch != "" {
+				id, err := client.ParseFileID(rmIfMatch)
+				if err != nil {
+					return fmt.Errorf("invalid -if_match: %w", err)
+				}
+				if err := c.DeleteIfMatch(ctx, name, id); err != nil {
+					return fmt.Errorf("deleting %s: %w", name, err)
+				}
+			} else if rmAll {
+				deleted, err := c.DeleteBatch(ctx, nil, []string{name})
+				if err != nil {
+					return fmt.Errorf("deleting %s: %w", name, err)
+				}
+				if len(deleted) == 0 {
+					return fmt.Errorf("file %s does not exist", name)
+				}
+			} else {
+				info, err := latest(ctx, c, name)
+				if err != nil {
+					return err
+				}
+				if err := c.Delete(ctx, info.FileID); err != nil {
+					return fmt.Errorf("deleting %s version %s: %w", name, info.FileID, err)
+				}
+			}
+			fmt.Printf("delete: %s\n", jotPrefix+strings.TrimPrefix(name, "/"))
+		}
+		return nil
+	},
+}
+
+var catCmd = &command{
+	name:  "cat",
+	usage: "<file>...",
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() == 0 {
+			flags.Usage()
+			return errors.New("expected at least 1 argument")
+		}
+		for _, arg := range flags.Args() {
+			name := arg
+			if n, ok := remoteName(arg); ok {
+				name = n
+			}
+			info, err := latest(ctx, c, name)
+			if err != nil {
+				return err
+			}
+			if err := c.Download(ctx, info.FileID, os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var appendCmd = &command{
+	name:  "append",
+	usage: "<src> <dst>",
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return errors.New("expected 2 arguments")
+		}
+		name, ok := remoteName(flags.Arg(1))
+		if !ok {
+			return fmt.Errorf("destination must begin with %s", jotPrefix)
+		}
+		r := io.Reader(os.Stdin)
+		if src := flags.Arg(0); src != "-" {
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		id, err := c.Append(ctx, r, name, nil)
+		if errors.Is(err, client.ErrNotFound) {
+			return fmt.Errorf("file %s does not exist", name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("append: %s %s\n", jotPrefix+strings.TrimPrefix(name, "/"), id)
+		return nil
+	},
+}
+
+var (
+	syncExclude string
+	syncInclude string
+	syncDelete  bool
+	syncDryRun  bool
+)
+
+var syncCmd = &command{
+	name:  "sync",
+	usage: "[flags] <dir> <prefix>",
+	flags: func(flags *flag.FlagSet) {
+		flags.StringVar(&syncExclude, "exclude", "", "exclude files matching a glob pattern")
+		flags.StringVar(&syncInclude, "include", "", "include files excluded by -exclude which match a glob pattern")
+		flags.BoolVar(&syncDelete, "delete", false, "remove all versions of files under prefix which do not exist in dir")
+		flags.BoolVar(&syncDryRun, "dryrun", false, "output the changes which would be made without making them")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return errors.New("expected 2 arguments")
+		}
+		dir, prefix := flags.Arg(0), flags.Arg(1)
+		if name, ok := remoteName(prefix); ok {
+			prefix = name
+		}
+		if info, err := os.Stat(dir); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+
+		opts := &client.SyncOpts{
+			Exclude: syncExclude,
+			Include: syncInclude,
+			Delete:  syncDelete,
+			DryRun:  syncDryRun,
+			Progress: func(action string, name string) {
+				if syncDryRun {
+					action = "(dryrun) " + action
+				}
+				fmt.Printf("%s: %s\n", action, jotPrefix+strings.TrimPrefix(name, "/"))
+			},
+		}
+		result, err := c.Sync(ctx, dir, prefix, opts)
+		if err != nil {
+			return err
+		}
+		for _, conflict := range result.Conflicts {
+			msg := fmt.Sprintf("conflict: %s changed on the server during the sync", jotPrefix+strings.TrimPrefix(conflict.Name, "/"))
+			switch conflict.Resolution {
+			case client.ConflictReplaced:
+				msg += ", replaced by the local file"
+			case client.ConflictBranched:
+				msg += ", local file uploaded to " + jotPrefix + strings.TrimPrefix(conflict.Branch, "/")
+			case client.ConflictRejected:
+				msg += ", local file not uploaded"
+			}
+			fmt.Println(msg)
+		}
+		fmt.Printf("%d uploaded, %d unchanged, %d deleted\n", result.Uploaded, result.Unchanged, result.Deleted)
+		return nil
+	},
+}
+
+var (
+	mirrorExclude  string
+	mirrorInclude  string
+	mirrorDelete   bool
+	mirrorDryRun   bool
+	mirrorInterval time.Duration
+)
+
+var mirrorCmd = &command{
+	name:  "mirror",
+	usage: "[flags] <src-endpoint>:<prefix> <dst-endpoint>:<prefix>",
+	flags: func(flags *flag.FlagSet) {
+		flags.StringVar(&mirrorExclude, "exclude", "", "exclude files matching a glob pattern")
+		flags.StringVar(&mirrorInclude, "include", "", "include files excluded by -exclude which match a glob pattern")
+		flags.BoolVar(&mirrorDelete, "delete", false, "remove all versions of files under the destination prefix which do not exist under the source prefix")
+		flags.BoolVar(&mirrorDryRun, "dryrun", false, "output the changes which would be made without making them")
+		flags.DurationVar(&mirrorInterval, "interval", 0, "mirror repeatedly, waiting this long between runs, e.g. 5m. Runs once if 0")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return errors.New("expected 2 arguments")
+		}
+		src, srcPrefix, err := mirrorArg(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		dst, dstPrefix, err := mirrorArg(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+
+		opts := &client.SyncOpts{
+			Exclude: mirrorExclude,
+			Include: mirrorInclude,
+			Delete:  mirrorDelete,
+			DryRun:  mirrorDryRun,
+			Progress: func(action string, name string) {
+				if mirrorDryRun {
+					action = "(dryrun) " + action
+				}
+				fmt.Printf("%s: %s\n", action, jotPrefix+strings.TrimPrefix(name, "/"))
+			},
+		}
+		for {
+			result, err := src.Mirror(ctx, dst, srcPrefix, dstPrefix, opts)
+			if err != nil && mirrorInterval == 0 {
+				return err
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			} else {
+				fmt.Printf("%d uploaded, %d unchanged, %d deleted\n", result.Uploaded, result.Unchanged, result.Deleted)
+			}
+			if mirrorInterval == 0 {
+				return nil
+			}
+			time.Sleep(mirrorInterval)
+		}
+	},
+}
+
+// mirrorArg returns a client for the server endpoint, and the prefix, of a mirror
+// argument. The endpoint is separated from the prefix by the last colon, e.g.
+// http://localhost:6777:/data.
+func mirrorArg(s string) (*client.Client, string, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 || !strings.Contains(s[:i], "://") {
+		return nil, "", fmt.Errorf("invalid argument %q: expected <endpoint>:<prefix>", s)
+	}
+	c, err := client.New(s[:i], &client.Options{Key: apiKey})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, s[i+1:], nil
+}
+
+var (
+	indexOutput  string
+	indexBaseURL string
+	indexExclude string
+	indexInclude string
+)
+
+var indexCmd = &command{
+	name:  "index",
+	usage: "[flags] <prefix>",
+	flags: func(flags *flag.FlagSet) {
+		flags.StringVar(&indexOutput, "o", "", "write the index to a local directory instead of uploading it to the server under prefix")
+		flags.StringVar(&indexBaseURL, "base_url", "", "URL prepended to the full name of each file to create its link. Links are relative by default")
+		flags.StringVar(&indexExclude, "exclude", "", "exclude files matching a glob pattern")
+		flags.StringVar(&indexInclude, "include", "", "include files excluded by -exclude which match a glob pattern")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("expected 1 argument")
+		}
+		prefix := flags.Arg(0)
+		if name, ok := remoteName(prefix); ok {
+			prefix = name
+		}
+		pages, err := c.BuildIndex(ctx, prefix, &client.IndexOpts{
+			BaseURL: indexBaseURL,
+			Exclude: indexExclude,
+			Include: indexInclude,
+		})
+		if err != nil {
+			return err
+		}
+		rels := make([]string, 0, len(pages))
+		for rel := range pages {
+			rels = append(rels, rel)
+		}
+		sort.Strings(rels)
+		for _, rel := range rels {
+			page := pages[rel]
+			html, err := page.HTML()
+			if err != nil {
+				return err
+			}
+			manifest, err := page.JSON()
+			if err != nil {
+				return err
+			}
+			if err := writeIndexFile(ctx, c, rel, page.Path, client.IndexHTMLName, html); err != nil {
+				return err
+			}
+			if err := writeIndexFile(ctx, c, rel, page.Path, client.IndexJSONName, manifest); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// writeIndexFile saves a generated index file to the -o directory, or to the server
+// if -o is not set.
+func writeIndexFile(ctx context.Context, c *client.Client, rel string, dir string, name string, data []byte) error {
+	if indexOutput == "" {
+		dst := path.Join(dir, name)
+		if _, err := c.Upload(ctx, bytes.NewReader(data), dst); err != nil {
+			return fmt.Errorf("uploading %s: %w", dst, err)
+		}
+		fmt.Printf("upload: %s\n", jotPrefix+strings.TrimPrefix(dst, "/"))
+		return nil
+	}
+	localDir := filepath.Join(indexOutput, filepath.FromSlash(rel))
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(localDir, name)
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("write: %s\n", dst)
+	return nil
+}
+
+var (
+	reportSince time.Duration
+	reportUntil time.Duration
+)
+
+var reportCmd = &command{
+	name:  "report",
+	usage: "[flags]",
+	flags: func(flags *flag.FlagSet) {
+		flags.DurationVar(&reportSince, "since", 0, "report on the data uploaded within this long of now, e.g. 168h. Reports on all data if 0")
+		flags.DurationVar(&reportUntil, "until", 0, "end the report this long before now")
+	},
+	run: func(ctx context.Context, c *client.Client, flags *flag.FlagSet) error {
+		if flags.NArg() != 0 {
+			flags.Usage()
+			return errors.New("expected no arguments")
+		}
+		now := time.Now()
+		var since, until time.Time
+		if reportSince > 0 {
+			since = now.Add(-reportSince)
+		}
+		if reportUntil > 0 {
+			until = now.Add(-reportUntil)
+		}
+		r, err := c.ChunkReport(ctx, since, until)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Chunker:\tmin %s, avg %s, max %s\n", formatSize(r.MinChunkSize), formatSize(r.AvgChunkSize), formatSize(r.MaxChunkSize))
+		fmt.Fprintf(w, "File versions:\t%d (%s)\n", r.NumFileVersions, formatSize(r.FilesSize))
+		fmt.Fprintf(w, "New chunks:\t%d (%s, %s compressed)\n", r.NumChunks, formatSize(r.ChunksSize), formatSize(r.StoredSize))
+		fmt.Fprintf(w, "Dedup hit rate:\t%.1f%%\n", 100*r.DedupHitRate)
+		fmt.Fprintf(w, "Packfiles:\t%d (%s), %.1f%% full on average\n", r.NumPacks, formatSize(r.PacksSize), 100*r.PackFill)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "CHUNK_SIZE\tCHUNKS\tBYTES\t")
+		for _, b := range r.ChunkSizes {
+			fmt.Fprintf(w, "%s\t%d\t%d\t\n", formatBucket(b), b.Count, b.Total)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "PACKFILE_SIZE\tPACKFILES\tBYTES\t")
+		for _, b := range r.PackSizes {
+			fmt.Fprintf(w, "%s\t%d\t%d\t\n", formatBucket(b), b.Count, b.Total)
+		}
+		return w.Flush()
+	},
+}
+
+// formatSize formats a number of bytes in the largest binary unit it's at least one
+// of, e.g. "1.5 MiB".
+func formatSize(n uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// formatBucket formats the range of sizes of a histogram bucket.
+func formatBucket(b client.HistogramBucket) string {
+	if b.Max == 0 {
+		return ">= " + formatSize(b.Min)
+	}
+	if b.Min == 0 {
+		return "< " + formatSize(b.Max)
+	}
+	return formatSize(b.Min) + " - " + formatSize(b.Max)
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const adminUsage = `Usage: jotfs admin <command> [flags]
+
+Commands:
+  print-iam-policy   print the least privilege IAM policy for the configured store
+  tune               compare the deduplication of sample data at different chunk sizes
+  import-dir         upload a local directory directly to the database and store
+  export-dir         download a directory to a local directory, hard-linking unchanged files
+  export-bundle      write the files in a directory to stdout as a portable bundle
+  import-bundle      upload the files in a bundle read from stdin
+  migrate-store      copy every object in the store to another store, verifying checksums
+  ingest-store       import the objects in another bucket as files, deduplicating their data
+  vacuum-report      report what a vacuum would reclaim, without changing anything
+  rechunk            chunk the files under a prefix again with their current chunking parameters
+  migrate-db         apply pending database schema migrations, or list them with -dry_run
+  backup-db          upload a backup of the database, or list the backups with -list
+  restore-db         replace the database with a backup, with the server stopped
+  compact-db         upload a copy of the database without free pages, optionally swapping it in`
+
+// runAdmin runs an admin subcommand.
+func runAdmin(args []string) error {
+	if len(args) == 0 {
+		return errors.New(adminUsage)
+	}
+	switch args[0] {
+	case "print-iam-policy":
+		return printIAMPolicy(args[1:], os.Stdout, os.Stderr)
+	case "tune":
+		return tune(args[1:], os.Stdout)
+	case "import-dir":
+		return importDir(args[1:], os.Stdout)
+	case "export-dir":
+		return exportDir(args[1:], os.Stdout)
+	case "export-bundle":
+		return exportBundle(args[1:], os.Stdout, os.Stderr)
+	case "import-bundle":
+		return importBundle(args[1:], os.Stdin, os.Stdout)
+	case "migrate-store":
+		return migrateStore(args[1:], os.Stdout)
+	case "ingest-store":
+		return ingestStore(args[1:], os.Stdout)
+	case "vacuum-report":
+		return vacuumReport(args[1:], os.Stdout)
+	case "rechunk":
+		return rechunk(args[1:], os.Stdout)
+	case "migrate-db":
+		return migrateDB(args[1:], os.Stdout)
+	case "backup-db":
+		return backupDB(args[1:], os.Stdout)
+	case "restore-db":
+		return restoreDB(args[1:], os.Stdout)
+	case "compact-db":
+		return compactDB(args[1:], os.Stdout)
+	default:
+		return fmt.Errorf("unknown admin command %q\n%s", args[0], adminUsage)
+	}
+}
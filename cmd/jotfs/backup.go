@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/jotfs/jotfs/server"
+)
+
+// backupDB uploads a backup of the database to the store.
+func backupDB(args []string, w io.Writer) error {
+	var list bool
+	fs := flag.NewFlagSet("backup-db", flag.ContinueOnError)
+	fs.BoolVar(&list, "list", false, "list the backups in the store instead of making one")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if list {
+		backups, err := s.srv.ListDatabaseBackups(ctx)
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			fmt.Fprintf(w, "%s %d\n", b.Key, b.Size)
+		}
+		return nil
+	}
+	res, err := s.srv.BackupDatabase(ctx)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Uploaded %s (%d bytes)\n", res.Key, res.Size)
+	if res.Deleted > 0 {
+		fmt.Fprintf(w, "Deleted %d older backups\n", res.Deleted)
+	}
+	return nil
+}
+
+// restoreDB replaces the database with a backup downloaded from the store.
+func restoreDB(args []string, w io.Writer) error {
+	var opts server.RestoreOpts
+	fs := flag.NewFlagSet("restore-db", flag.ContinueOnError)
+	fs.StringVar(&opts.Key, "key", "", "key of the backup to restore. Defaults to the latest backup")
+	fs.BoolVar(&opts.Force, "force", false, "replace the database if it exists")
+	load := localConfigFlags(fs, true)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+
+	backup, err := server.RestoreDatabase(context.Background(), newServerConfig(cfg), &opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Restored %s (%d bytes) to %s\n", backup.Key, backup.Size, cfg.Server.databasePath())
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/jotfs/jotfs/server"
+)
+
+// compactDB uploads a compacted copy of the database to the store.
+func compactDB(args []string, w io.Writer) error {
+	var swap bool
+	fs := flag.NewFlagSet("compact-db", flag.ContinueOnError)
+	fs.BoolVar(&swap, "swap", false, "replace the database with the compacted copy when the server next starts")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	result, err := s.srv.CompactDatabase(context.Background(), &server.CompactOpts{Swap: swap})
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Uploaded %s (%d bytes). The database is %d bytes, %d of which are free\n",
+		result.Key, result.CompactedSize, result.Size, result.FreeBytes)
+	if result.Pending {
+		fmt.Fprintln(w, "The copy replaces the database when the server next starts")
+	}
+	return nil
+}
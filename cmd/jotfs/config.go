@@ -0,0 +1,544 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/jotfs/jotfs/server"
+)
+
+// envPrefix is the prefix of environment variables which override config values.
+const envPrefix = "JOTFS_"
+
+type config struct {
+	Server serverConfig `toml:"server"`
+	Store  storeConfig  `toml:"store"`
+}
+
+type serverConfig struct {
+	Port                  uint   `toml:"port"`
+	Database              string `toml:"db"`
+	DataDir               string `toml:"data_dir"`
+	VersioningEnabled     bool   `toml:"enable_versioning"`
+	AvgChunkKiB           uint   `toml:"chunk_size"`
+	ChunkAlgorithm        string `toml:"chunk_algorithm"`
+	ChunkHash             string `toml:"chunk_hash"`
+	LogLevel              string `toml:"log_level"`
+	TLSCert               string `toml:"tls_cert"`
+	TLSKey                string `toml:"tls_key"`
+	DLTimeoutMinutes      uint   `toml:"download_timeout"`
+	VacuumScheduleMinutes uint   `toml:"vacuum_schedule"`
+	DisableAutoVacuum     bool   `toml:"disable_vacuum"`
+	ShutdownTimeoutSecs   uint   `toml:"shutdown_timeout"`
+	CacheTTLSecs          uint   `toml:"cache_ttl"`
+	OpLogIntervalSecs     uint   `toml:"oplog_interval"`
+	DisableChunkFilter    bool   `toml:"disable_chunk_filter"`
+	CheckpointMiB         uint   `toml:"checkpoint_size"`
+	CheckpointIdleSecs    uint   `toml:"checkpoint_idle"`
+	DBBusyTimeoutMS       uint   `toml:"db_busy_timeout"`
+	DBSynchronous         string `toml:"db_synchronous"`
+	DBReaders             uint   `toml:"db_readers"`
+	ManualMigrations      bool   `toml:"manual_migrations"`
+	BackupIntervalHours   uint   `toml:"backup_interval"`
+	BackupRetain          uint   `toml:"backup_retain"`
+	Jobs                  string `toml:"jobs"`
+	MaxJobs               uint   `toml:"max_jobs"`
+	PackfileMiB           uint   `toml:"packfile_size"`
+	MaxPackfileMiB        uint   `toml:"max_packfile_size"`
+	PackfileFlushSecs     uint   `toml:"packfile_flush_interval"`
+	InlineKiB             uint   `toml:"inline_size"`
+	Standby               bool   `toml:"standby"`
+	SecretsFromEnv        bool   `toml:"secrets_from_env"`
+	OTLPEndpoint          string `toml:"otlp_endpoint"`
+	OTLPInsecure          bool   `toml:"otlp_insecure"`
+	CORSOrigins           string `toml:"cors_origins"`
+	Checksums             string `toml:"checksums"`
+	IndexCacheDir         string `toml:"index_cache_dir"`
+	ChunkCacheDir         string `toml:"chunk_cache_dir"`
+	ChunkCacheMiB         uint   `toml:"chunk_cache_size"`
+	LocationCacheSize     uint   `toml:"location_cache_size"`
+	ReadAhead             uint   `toml:"read_ahead"`
+	UploadQueueDir        string `toml:"upload_queue_dir"`
+	UploadQueueSize       uint   `toml:"upload_queue_size"`
+	UploadQueueWorkers    uint   `toml:"upload_queue_workers"`
+	MinFreeMiB            uint   `toml:"min_free_space"`
+	MaxUploads            uint   `toml:"max_uploads"`
+	MaxUploadMiB          uint   `toml:"max_upload_mib"`
+	NameMaxLength         uint   `toml:"name_max_length"`
+	NamePattern           string `toml:"name_pattern"`
+	NormalizeNames        bool   `toml:"normalize_names"`
+	Conflicts             string `toml:"conflicts"`
+	Quotas                string `toml:"quotas"`
+	MetadataFile          string `toml:"metadata_file"`
+	SyncReplication       string `toml:"sync_replication"`
+	Replicas              string `toml:"replicas"`
+	ReplicaKey            string `toml:"replica_key" secret:"true"`
+	ReplicaTimeoutSecs    uint   `toml:"replica_timeout"`
+	ShedMaxRequests       uint   `toml:"shed_max_requests"`
+	ShedQueueMillis       uint   `toml:"shed_queue_timeout"`
+	ShedMaxHeapMiB        uint   `toml:"shed_max_heap"`
+	ShedStoreMillis       uint   `toml:"shed_store_latency"`
+	RateRequests          uint   `toml:"rate_requests"`
+	RateMiB               uint   `toml:"rate_mib"`
+	ClientRateRequests    uint   `toml:"client_rate_requests"`
+	ClientRateMiB         uint   `toml:"client_rate_mib"`
+	MaxUploadMbps         uint   `toml:"max_upload_mbps"`
+	MaxDownloadMbps       uint   `toml:"max_download_mbps"`
+	AdminToken            string `toml:"admin_token" secret:"true"`
+	PolicyFile            string `toml:"policy_file"`
+	ReportIntervalHours   uint   `toml:"report_interval"`
+	ReportTenant          string `toml:"report_tenant"`
+	ReportWebhook         string `toml:"report_webhook" secret:"true"`
+	ReportSMTPAddr        string `toml:"report_smtp_addr"`
+	ReportSMTPUsername    string `toml:"report_smtp_username"`
+	ReportSMTPPassword    string `toml:"report_smtp_password" secret:"true"`
+	ReportFrom            string `toml:"report_from"`
+	ReportTo              string `toml:"report_to"`
+}
+
+type storeConfig struct {
+	URL        string `toml:"url" secret:"true"`
+	AccessKey  string `toml:"access_key" secret:"true"`
+	SecretKey  string `toml:"secret_key" secret:"true"`
+	Bucket     string `toml:"bucket"`
+	Region     string `toml:"region"`
+	DisableSSL bool   `toml:"disable_ssl"`
+	PathStyle  bool   `toml:"path_style"`
+	Endpoint   string `toml:"endpoint"`
+	RoleARN    string `toml:"role_arn"`
+	ExternalID string `toml:"role_external_id"`
+
+	PartSizeMiB       uint `toml:"part_size"`
+	UploadConcurrency uint `toml:"upload_concurrency"`
+
+	RetryAttempts      uint `toml:"retry_attempts"`
+	RetryBudgetPercent uint `toml:"retry_budget"`
+
+	BreakerThreshold    uint `toml:"breaker_threshold"`
+	BreakerCooldownSecs uint `toml:"breaker_cooldown"`
+
+	EventsToken string `toml:"events_token" secret:"true"`
+	EventsQueue string `toml:"events_queue"`
+
+	WaitTimeoutSeconds uint `toml:"wait_timeout"`
+}
+
+// configField is a single value in the config.
+type configField struct {
+	section string
+	key     string
+	secret  bool
+	value   reflect.Value
+}
+
+// flagName returns the command line flag name for the field.
+func (f configField) flagName() string {
+	if f.section == "server" {
+		return f.key
+	}
+	return f.section + "_" + f.key
+}
+
+// envName returns the environment variable name for the field.
+func (f configField) envName() string {
+	return envPrefix + strings.ToUpper(f.section+"_"+f.key)
+}
+
+// fields returns every field in the config.
+func (c *config) fields() []configField {
+	var fields []configField
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		section := v.Type().Field(i).Tag.Get("toml")
+		sv := v.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			sf := sv.Type().Field(j)
+			fields = append(fields, configField{
+				section: section,
+				key:     sf.Tag.Get("toml"),
+				secret:  sf.Tag.Get("secret") == "true",
+				value:   sv.Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+// loadConfig builds the config by applying, in order of increasing precedence, the
+// defaults already in cfg, the TOML config file at filename (if not empty),
+// environment variables, and any flags set on the command line.
+func loadConfig(cfg *config, filename string, flags *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	// Remember the flags set on the command line so they can be re-applied after
+	// the file and environment are loaded
+	setFlags := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	var meta toml.MetaData
+	if filename != "" {
+		var err error
+		if meta, err = toml.DecodeFile(filename, cfg); err != nil {
+			return fmt.Errorf("reading config file %s: %v", filename, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config file %s: unknown key %s", filename, undecoded[0])
+		}
+	}
+
+	fields := cfg.fields()
+	for _, f := range fields {
+		s, ok := lookupEnv(f.envName())
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, s); err != nil {
+			return fmt.Errorf("environment variable %s: %v", f.envName(), err)
+		}
+	}
+
+	for name, value := range setFlags {
+		if err := flags.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	// Secrets may be required to come from the environment so they never need to be
+	// written to a config file or be visible in the process list
+	if !cfg.Server.SecretsFromEnv {
+		return nil
+	}
+	for _, f := range fields {
+		if !f.secret {
+			continue
+		}
+		if meta.IsDefined(f.section, f.key) {
+			return fmt.Errorf("%s.%s must be set with environment variable %s instead of the config file", f.section, f.key, f.envName())
+		}
+		if _, ok := setFlags[f.flagName()]; ok {
+			return fmt.Errorf("-%s must be set with environment variable %s instead of a flag", f.flagName(), f.envName())
+		}
+	}
+	return nil
+}
+
+// setValue parses s and assigns it to v.
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Uint:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", s)
+		}
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Kind())
+	}
+	return nil
+}
+
+func requiredFlagError(flag string) error {
+	return fmt.Errorf("flag -%s is reqiured", flag)
+}
+
+func (c serverConfig) validate() error {
+	if c.AvgChunkKiB < minAvgKib || c.AvgChunkKiB > maxAvgKib {
+		return fmt.Errorf("-chunk_size must be in range %d to %d", minAvgKib, maxAvgKib)
+	}
+	if _, err := chunker.ParseAlgorithm(c.ChunkAlgorithm); err != nil {
+		return fmt.Errorf("-chunk_algorithm: %w", err)
+	}
+	if _, err := sum.ParseAlgorithm(c.ChunkHash); err != nil {
+		return fmt.Errorf("-chunk_hash: %w", err)
+	}
+	if (c.TLSCert == "" && c.TLSKey != "") || (c.TLSCert != "" && c.TLSKey == "") {
+		return fmt.Errorf("flags -ssl_cert and -ssl_key must be provided together")
+	}
+	if !c.DisableAutoVacuum && c.VacuumScheduleMinutes < minVacuumScheduleMinutes {
+		return fmt.Errorf("flag -vacuum_schedule must be at least %d", minVacuumScheduleMinutes)
+	}
+	if c.SyncReplication != "" && c.Replicas == "" {
+		return fmt.Errorf("flag -sync_replication requires -replicas")
+	}
+	if c.MaxPackfileMiB > 0 && c.PackfileMiB > c.MaxPackfileMiB {
+		return fmt.Errorf("flag -packfile_size must be at most -max_packfile_size")
+	}
+	if c.InlineKiB > maxInlineKib {
+		return fmt.Errorf("flag -inline_size must be at most %d", maxInlineKib)
+	}
+	if c.NameMaxLength > maxNameLength {
+		return fmt.Errorf("flag -name_max_length must be at most %d", maxNameLength)
+	}
+	if c.NamePattern != "" {
+		if _, err := regexp.Compile(c.NamePattern); err != nil {
+			return fmt.Errorf("invalid -name_pattern: %w", err)
+		}
+	}
+	if _, err := c.conflicts(); err != nil {
+		return err
+	}
+	if _, err := c.jobs(); err != nil {
+		return err
+	}
+	if _, err := c.quotas(); err != nil {
+		return err
+	}
+	if _, err := c.metadataRules(); err != nil {
+		return err
+	}
+	switch c.LogLevel {
+	case "", "debug", "info", "warn", "error":
+		break
+	default:
+		return fmt.Errorf("invalid -log_level %q. Must be one of: debug, info, warn, error", c.LogLevel)
+	}
+	return nil
+}
+
+func (c storeConfig) validate() error {
+	if c.URL != "" {
+		return nil
+	}
+	if c.Bucket == "" {
+		return requiredFlagError("store_bucket")
+	}
+	if (c.AccessKey == "") != (c.SecretKey == "") {
+		return fmt.Errorf("flags -store_access_key and -store_secret_key must be provided together")
+	}
+	return nil
+}
+
+// autoFromEnv returns true if the -auto flag is set, or if the JOTFS_AUTO environment
+// variable is set to a true value.
+func autoFromEnv(flagValue bool, lookupEnv func(string) (string, bool)) (bool, error) {
+	if flagValue {
+		return true, nil
+	}
+	s, ok := lookupEnv(envPrefix + "AUTO")
+	if !ok {
+		return false, nil
+	}
+	auto, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("environment variable %sAUTO: invalid boolean %q", envPrefix, s)
+	}
+	return auto, nil
+}
+
+// setDefaults sets the defaults of the values which have flags in server mode, for
+// commands which load the server config without defining every flag.
+func (c *config) setDefaults() {
+	c.Server.Port = defaultPort
+	c.Server.DataDir = defaultDataDir
+	c.Server.AvgChunkKiB = defaultAvgKib
+	c.Server.LogLevel = defaultLogLevel
+	c.Server.DLTimeoutMinutes = defaultDLTimeoutMinutes
+	c.Server.VacuumScheduleMinutes = defaultVacuumMinutes
+	c.Server.ShutdownTimeoutSecs = defaultShutdownSeconds
+	c.Server.NameMaxLength = maxNameLength
+	c.Store.RetryAttempts = defaultRetryAttempts
+	c.Store.RetryBudgetPercent = defaultRetryBudget
+	c.Store.BreakerThreshold = defaultBreakerThreshold
+	c.Store.BreakerCooldownSecs = defaultBreakerCooldownSecs
+}
+
+// setAutoDefaults replaces the defaults in cfg with those used in auto mode. It must
+// be called before loadConfig.
+func (c *config) setAutoDefaults() {
+	c.Server.DataDir = defaultAutoDataDir
+	c.Server.LogLevel = "info"
+	c.Store.WaitTimeoutSeconds = defaultAutoStoreWaitSeconds
+}
+
+// databasePath returns the location of the metadata database. The database is placed
+// in the data directory if its location is not set explicitly.
+func (c serverConfig) databasePath() string {
+	if c.Database != "" {
+		return c.Database
+	}
+	return filepath.Join(c.DataDir, defaultDatabaseName)
+}
+
+// corsOrigins returns the comma-separated list of CORS origins as a slice.
+func (c serverConfig) corsOrigins() []string {
+	return splitList(c.CORSOrigins)
+}
+
+// replicas returns the comma-separated list of replica endpoints as replica configs.
+// Every replica uses the same key.
+func (c serverConfig) replicas() []server.ReplicaConfig {
+	var replicas []server.ReplicaConfig
+	for _, endpoint := range splitList(c.Replicas) {
+		replicas = append(replicas, server.ReplicaConfig{Endpoint: endpoint, Key: c.ReplicaKey})
+	}
+	return replicas
+}
+
+// reportTo returns the comma-separated list of usage report recipients as a slice.
+func (c serverConfig) reportTo() []string {
+	return splitList(c.ReportTo)
+}
+
+// checksums returns the comma-separated list of checksum algorithms as a slice.
+func (c serverConfig) checksums() []string {
+	return splitList(strings.ToLower(c.Checksums))
+}
+
+// conflicts returns the comma-separated list of prefix=strategy conflict rules as a
+// map from prefix to strategy.
+func (c serverConfig) conflicts() (map[string]string, error) {
+	items := splitList(c.Conflicts)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	rules := make(map[string]string, len(items))
+	for _, item := range items {
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid -conflicts rule %q. Must be prefix=strategy", item)
+		}
+		strategy := strings.ToLower(strings.TrimSpace(item[i+1:]))
+		switch strategy {
+		case "replace", "reject", "branch":
+		default:
+			return nil, fmt.Errorf("invalid -conflicts strategy %q. Must be one of: replace, reject, branch", strategy)
+		}
+		rules[strings.TrimSpace(item[:i])] = strategy
+	}
+	return rules, nil
+}
+
+// quotas returns the comma-separated list of prefix=soft:hard:grace quotas, where soft
+// and hard are limits in MiB and grace is a duration such as "72h". Any of the three
+// may be empty.
+func (c serverConfig) quotas() ([]server.Quota, error) {
+	items := splitList(c.Quotas)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	quotas := make([]server.Quota, len(items))
+	for i, item := range items {
+		j := strings.LastIndex(item, "=")
+		limits := strings.Split(item[j+1:], ":")
+		if j < 0 || len(limits) > 3 {
+			return nil, fmt.Errorf("invalid -quotas quota %q. Must be prefix=soft:hard:grace", item)
+		}
+		q := server.Quota{Prefix: strings.TrimSpace(item[:j])}
+		for k, dst := range []*uint64{&q.Soft, &q.Hard} {
+			if k >= len(limits) || strings.TrimSpace(limits[k]) == "" {
+				continue
+			}
+			mib, err := strconv.ParseUint(strings.TrimSpace(limits[k]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -quotas limit %q. Must be a number of MiB", limits[k])
+			}
+			*dst = mib * miB
+		}
+		if len(limits) == 3 && strings.TrimSpace(limits[2]) != "" {
+			grace, err := time.ParseDuration(strings.TrimSpace(limits[2]))
+			if err != nil || grace <= 0 {
+				return nil, fmt.Errorf("invalid -quotas grace period %q. Must be a duration such as 72h", limits[2])
+			}
+			q.Grace = grace
+		}
+		if q.Soft == 0 && q.Hard == 0 {
+			return nil, fmt.Errorf("invalid -quotas quota %q. Must set a soft or hard limit", item)
+		}
+		if q.Hard > 0 && q.Soft > q.Hard {
+			return nil, fmt.Errorf("invalid -quotas quota %q. The soft limit must be at most the hard limit", item)
+		}
+		quotas[i] = q
+	}
+	return quotas, nil
+}
+
+// jobs returns the semicolon-separated list of name=schedule job schedules as a map
+// from job name to schedule. The schedules are checked by the server.
+func (c serverConfig) jobs() (map[string]string, error) {
+	var jobs map[string]string
+	for _, item := range strings.Split(c.Jobs, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid -jobs schedule %q. Must be name=schedule", item)
+		}
+		if jobs == nil {
+			jobs = make(map[string]string)
+		}
+		jobs[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+	}
+	return jobs, nil
+}
+
+// metadataFile is the format of the file of default metadata rules.
+type metadataFile struct {
+	Rules []struct {
+		Prefix       string            `toml:"prefix"`
+		Metadata     map[string]string `toml:"metadata"`
+		ContentTypes map[string]string `toml:"content_types"`
+	} `toml:"rules"`
+}
+
+// metadataRules returns the default metadata rules in the -metadata_file.
+func (c serverConfig) metadataRules() ([]server.MetadataRule, error) {
+	if c.MetadataFile == "" {
+		return nil, nil
+	}
+	var f metadataFile
+	md, err := toml.DecodeFile(c.MetadataFile, &f)
+	if err != nil {
+		return nil, fmt.Errorf("reading -metadata_file: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("reading -metadata_file: unknown field %s", undecoded[0])
+	}
+	rules := make([]server.MetadataRule, len(f.Rules))
+	for i, r := range f.Rules {
+		if r.Prefix == "" {
+			return nil, fmt.Errorf("reading -metadata_file: rules[%d] has no prefix", i)
+		}
+		rules[i] = server.MetadataRule{Prefix: r.Prefix, Metadata: r.Metadata, ContentTypes: r.ContentTypes}
+	}
+	return rules, nil
+}
+
+// splitList splits a comma-separated list, ignoring whitespace and empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configFileFromEnv returns the value of the -config flag, or the JOTFS_CONFIG
+// environment variable if the flag is not set.
+func configFileFromEnv(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(envPrefix + "CONFIG")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jotfs/jotfs/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFlags(cfg *config) *flag.FlagSet {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.UintVar(&cfg.Server.Port, "port", defaultPort, "")
+	flags.StringVar(&cfg.Store.Bucket, "store_bucket", "", "")
+	flags.StringVar(&cfg.Store.SecretKey, "store_secret_key", "", "")
+	flags.BoolVar(&cfg.Store.PathStyle, "store_path_style", false, "")
+	return flags
+}
+
+func writeConfig(t *testing.T, s string) string {
+	f, err := ioutil.TempFile("", "jotfs-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func envMap(m map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := m[k]
+		return v, ok
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	name := writeConfig(t, `
+[server]
+port = 8000
+db = "/data/jotfs.db"
+
+[store]
+bucket = "from-file"
+region = "eu-west-1"
+`)
+	defer os.Remove(name)
+
+	var cfg config
+	flags := testFlags(&cfg)
+	assert.NoError(t, flags.Parse([]string{"-store_bucket", "from-flag"}))
+	env := envMap(map[string]string{
+		"JOTFS_SERVER_PORT":      "9000",
+		"JOTFS_STORE_SECRET_KEY": "secret",
+		"JOTFS_STORE_PATH_STYLE": "true",
+		"JOTFS_STORE_BUCKET":     "from-env",
+	})
+	assert.NoError(t, loadConfig(&cfg, name, flags, env))
+
+	assert.Equal(t, uint(9000), cfg.Server.Port)
+	assert.Equal(t, "/data/jotfs.db", cfg.Server.Database)
+	assert.Equal(t, "from-flag", cfg.Store.Bucket)
+	assert.Equal(t, "eu-west-1", cfg.Store.Region)
+	assert.Equal(t, "secret", cfg.Store.SecretKey)
+	assert.True(t, cfg.Store.PathStyle)
+
+	// Flag defaults are kept if not set elsewhere
+	cfg = config{}
+	flags = testFlags(&cfg)
+	assert.NoError(t, flags.Parse(nil))
+	assert.NoError(t, loadConfig(&cfg, "", flags, envMap(nil)))
+	assert.Equal(t, uint(defaultPort), cfg.Server.Port)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	// Invalid environment variable
+	var cfg config
+	flags := testFlags(&cfg)
+	env := envMap(map[string]string{"JOTFS_SERVER_PORT": "abc"})
+	assert.Error(t, loadConfig(&cfg, "", flags, env))
+
+	// Unknown key in config file
+	name := writeConfig(t, "[store]\nbukcet = \"a\"\n")
+	defer os.Remove(name)
+	cfg = config{}
+	flags = testFlags(&cfg)
+	assert.Error(t, loadConfig(&cfg, name, flags, envMap(nil)))
+}
+
+func TestConflictsConfig(t *testing.T) {
+	c := serverConfig{Conflicts: "/shared=reject, /shared/drafts = BRANCH,"}
+	rules, err := c.conflicts()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"/shared": "reject", "/shared/drafts": "branch"}, rules)
+
+	for _, s := range []string{"/shared", "/shared=merge"} {
+		c.Conflicts = s
+		_, err := c.conflicts()
+		assert.Error(t, err)
+	}
+}
+
+func TestQuotasConfig(t *testing.T) {
+	c := serverConfig{Quotas: "/tenants/acme=1024:2048:72h, /tmp=:10,"}
+	quotas, err := c.quotas()
+	assert.NoError(t, err)
+	assert.Equal(t, []server.Quota{
+		{Prefix: "/tenants/acme", Soft: 1024 * miB, Hard: 2048 * miB, Grace: 72 * time.Hour},
+		{Prefix: "/tmp", Hard: 10 * miB},
+	}, quotas)
+
+	for _, s := range []string{"/tmp", "/tmp=:", "/tmp=ten", "/tmp=1:2:3:4", "/tmp=1::forever", "/tmp=2:1"} {
+		c.Quotas = s
+		_, err := c.quotas()
+		assert.Error(t, err, s)
+	}
+}
+
+func TestJobsConfig(t *testing.T) {
+	c := serverConfig{Jobs: "scrub = 0 4 * * 0; vacuum=off;"}
+	jobs, err := c.jobs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"scrub": "0 4 * * 0", "vacuum": "off"}, jobs)
+
+	c.Jobs = "scrub"
+	_, err = c.jobs()
+	assert.Error(t, err)
+}
+
+func TestMetadataRulesConfig(t *testing.T) {
+	name := writeConfig(t, `
+[[rules]]
+prefix = "/finance"
+metadata = { owner = "finance", classification = "internal" }
+content_types = { ".csv" = "text/csv" }
+`)
+	defer os.Remove(name)
+	c := serverConfig{MetadataFile: name}
+	rules, err := c.metadataRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []server.MetadataRule{{
+		Prefix:       "/finance",
+		Metadata:     map[string]string{"owner": "finance", "classification": "internal"},
+		ContentTypes: map[string]string{".csv": "text/csv"},
+	}}, rules)
+
+	for _, content := range []string{"[[rules]]\nmetadata = { a = \"b\" }\n", "[[rules]]\nprefix = \"/\"\nowner = \"x\"\n"} {
+		name := writeConfig(t, content)
+		defer os.Remove(name)
+		c.MetadataFile = name
+		_, err := c.metadataRules()
+		assert.Error(t, err)
+	}
+}
+
+func TestSecretsFromEnv(t *testing.T) {
+	name := writeConfig(t, "[server]\nsecrets_from_env = true\n[store]\nsecret_key = \"abc\"\n")
+	defer os.Remove(name)
+
+	// Secret in config file
+	var cfg config
+	flags := testFlags(&cfg)
+	assert.Error(t, loadConfig(&cfg, name, flags, envMap(nil)))
+
+	// Secret in flag
+	cfg = config{}
+	flags = testFlags(&cfg)
+	assert.NoError(t, flags.Parse([]string{"-store_secret_key", "abc"}))
+	env := envMap(map[string]string{"JOTFS_SERVER_SECRETS_FROM_ENV": "true"})
+	assert.Error(t, loadConfig(&cfg, "", flags, env))
+
+	// Secret in environment
+	cfg = config{}
+	flags = testFlags(&cfg)
+	env = envMap(map[string]string{"JOTFS_SERVER_SECRETS_FROM_ENV": "1", "JOTFS_STORE_SECRET_KEY": "abc"})
+	assert.NoError(t, loadConfig(&cfg, "", flags, env))
+	assert.Equal(t, "abc", cfg.Store.SecretKey)
+}
+
+func TestAutoConfig(t *testing.T) {
+	auto, err := autoFromEnv(false, envMap(map[string]string{"JOTFS_AUTO": "true"}))
+	assert.NoError(t, err)
+	assert.True(t, auto)
+	auto, err = autoFromEnv(false, envMap(nil))
+	assert.NoError(t, err)
+	assert.False(t, auto)
+	_, err = autoFromEnv(false, envMap(map[string]string{"JOTFS_AUTO": "abc"}))
+	assert.Error(t, err)
+
+	var cfg config
+	flags := testFlags(&cfg)
+	assert.NoError(t, flags.Parse(nil))
+	cfg.setAutoDefaults()
+	env := envMap(map[string]string{"JOTFS_SERVER_DATA_DIR": "/var/lib/jotfs"})
+	assert.NoError(t, loadConfig(&cfg, "", flags, env))
+	assert.Equal(t, "/var/lib/jotfs/jotfs.db", cfg.Server.databasePath())
+	assert.Equal(t, uint(defaultAutoStoreWaitSeconds), cfg.Store.WaitTimeoutSeconds)
+
+	// An explicit database location takes precedence over the data directory
+	env = envMap(map[string]string{"JOTFS_SERVER_DB": "/tmp/meta.db"})
+	assert.NoError(t, loadConfig(&cfg, "", flags, env))
+	assert.Equal(t, "/tmp/meta.db", cfg.Server.databasePath())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/jotfs/jotfs/internal/store/s3"
+)
+
+// printIAMPolicy writes the IAM policy required by the store, as configured by the
+// config file, environment and flags in args, to w. Notes for the operator are
+// written to notes.
+func printIAMPolicy(args []string, w io.Writer, notes io.Writer) error {
+	var cfg config
+	var configFile string
+	var format string
+	fs := flag.NewFlagSet("print-iam-policy", flag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "TOML config file")
+	fs.StringVar(&format, "format", "json", "output format: json, terraform or cloudformation")
+	fs.StringVar(&cfg.Store.URL, "store_url", "", "URL of the store")
+	fs.StringVar(&cfg.Store.Bucket, "store_bucket", "", "bucket name")
+	fs.StringVar(&cfg.Store.Region, "store_region", "", "store region name")
+	fs.StringVar(&cfg.Store.RoleARN, "store_role_arn", "", "ARN of the IAM role the server assumes")
+	fs.StringVar(&cfg.Store.EventsQueue, "store_events_queue", "", "URL of the SQS queue bucket notifications are read from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := loadConfig(&cfg, configFileFromEnv(configFile), fs, os.LookupEnv); err != nil {
+		return err
+	}
+
+	pcfg := s3.PolicyConfig{
+		Bucket:      cfg.Store.Bucket,
+		Region:      cfg.Store.Region,
+		EventsQueue: cfg.Store.EventsQueue,
+	}
+	roleARN := cfg.Store.RoleARN
+	if cfg.Store.URL != "" {
+		u, err := url.Parse(cfg.Store.URL)
+		if err != nil {
+			return fmt.Errorf("invalid store URL: %w", err)
+		}
+		if u.Scheme != "s3" {
+			return fmt.Errorf("IAM policies only apply to s3 stores, not %s", u.Scheme)
+		}
+		pcfg.Bucket = u.Host
+		pcfg.Region = u.Query().Get("region")
+		roleARN = u.Query().Get("role_arn")
+	}
+	if pcfg.Bucket == "" {
+		return requiredFlagError("store_bucket")
+	}
+
+	doc, err := s3.Policy(pcfg)
+	if err != nil {
+		return err
+	}
+	var out string
+	switch format {
+	case "json":
+		out, err = policyJSON(doc)
+	case "terraform":
+		out, err = policyTerraform(doc, pcfg.Bucket, roleARN)
+	case "cloudformation":
+		out, err = policyCloudFormation(doc, pcfg.Bucket, roleARN)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, out); err != nil {
+		return err
+	}
+
+	if roleARN != "" {
+		fmt.Fprintf(notes, "Attach the policy to role %s. The server's own credentials need sts:AssumeRole on the role.\n", roleARN)
+	}
+	return nil
+}
+
+func policyJSON(doc s3.PolicyDocument) (string, error) {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	return string(b), err
+}
+
+// nonIdentifier matches characters which are not valid in Terraform and
+// CloudFormation resource names.
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// roleName returns the name of the role from its ARN.
+func roleName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func policyTerraform(doc s3.PolicyDocument, bucket string, roleARN string) (string, error) {
+	policy, err := policyJSON(doc)
+	if err != nil {
+		return "", err
+	}
+	name := "jotfs_" + strings.ToLower(nonIdentifier.ReplaceAllString(bucket, "_"))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "resource \"aws_iam_policy\" %q {\n", name)
+	fmt.Fprintf(&sb, "  name        = %q\n", "jotfs-"+bucket)
+	fmt.Fprintf(&sb, "  description = %q\n", "JotFS access to bucket "+bucket)
+	fmt.Fprintf(&sb, "  policy      = <<POLICY\n%s\nPOLICY\n}\n", policy)
+	if roleARN != "" {
+		fmt.Fprintf(&sb, "\nresource \"aws_iam_role_policy_attachment\" %q {\n", name)
+		fmt.Fprintf(&sb, "  role       = %q\n", roleName(roleARN))
+		fmt.Fprintf(&sb, "  policy_arn = aws_iam_policy.%s.arn\n}\n", name)
+	}
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+func policyCloudFormation(doc s3.PolicyDocument, bucket string, roleARN string) (string, error) {
+	props := map[string]interface{}{
+		"Description":    "JotFS access to bucket " + bucket,
+		"PolicyDocument": doc,
+	}
+	if roleARN != "" {
+		props["Roles"] = []string{roleName(roleARN)}
+	}
+	name := "JotFS" + nonIdentifier.ReplaceAllString(strings.Title(bucket), "") + "Policy"
+	template := map[string]interface{}{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Resources": map[string]interface{}{
+			name: map[string]interface{}{
+				"Type":       "AWS::IAM::ManagedPolicy",
+				"Properties": props,
+			},
+		},
+	}
+	b, err := json.MarshalIndent(template, "", "  ")
+	return string(b), err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintIAMPolicy(t *testing.T) {
+	var out, notes bytes.Buffer
+	err := printIAMPolicy([]string{"-store_bucket=my-data", "-store_region=us-east-1"}, &out, &notes)
+	assert.NoError(t, err)
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Contains(t, out.String(), "arn:aws:s3:::my-data/*")
+	assert.Empty(t, notes.String())
+
+	// Bucket, region and role from the store URL
+	out.Reset()
+	err = printIAMPolicy([]string{
+		"-store_url=s3://other?region=us-west-2&role_arn=arn:aws:iam::123456789012:role/jotfs",
+		"-format=terraform",
+	}, &out, &notes)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), `resource "aws_iam_policy" "jotfs_other"`)
+	assert.Contains(t, out.String(), "arn:aws:s3:::other")
+	assert.Contains(t, out.String(), `role       = "jotfs"`)
+	assert.Contains(t, notes.String(), "sts:AssumeRole")
+
+	out.Reset()
+	err = printIAMPolicy([]string{"-store_bucket=my-data", "-format=cloudformation"}, &out, &notes)
+	assert.NoError(t, err)
+	var template struct {
+		Resources map[string]struct {
+			Type string
+		}
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &template))
+	assert.Equal(t, "AWS::IAM::ManagedPolicy", template.Resources["JotFSMyDataPolicy"].Type)
+
+	// Errors
+	assert.Error(t, printIAMPolicy(nil, &out, &notes))
+	assert.Error(t, printIAMPolicy([]string{"-store_bucket=a", "-format=yaml"}, &out, &notes))
+	assert.Error(t, printIAMPolicy([]string{"-store_url=file:///data"}, &out, &notes))
+	assert.Error(t, runAdmin([]string{"unknown"}))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/jotfs/jotfs/server"
+)
+
+// ingestStore imports the objects in another store as files.
+func ingestStore(args []string, w io.Writer) error {
+	var srcURL, srcPrefix, prefix string
+	var verbose bool
+	fs := flag.NewFlagSet("ingest-store", flag.ContinueOnError)
+	fs.StringVar(&srcURL, "src_url", "", "URL of the store holding the objects to import, e.g. s3://bucket?region=us-east-1")
+	fs.StringVar(&srcPrefix, "src_prefix", "", "import only the objects whose keys begin with this prefix. It's removed from the file names")
+	fs.StringVar(&prefix, "prefix", "/", "directory on the server to import the files into")
+	fs.BoolVar(&verbose, "v", false, "output the key of each object")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if srcURL == "" {
+		return requiredFlagError("src_url")
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	opts := &server.IngestOpts{
+		Prefix: srcPrefix,
+		Dst:    prefix,
+		Progress: func(key string, action string) {
+			if verbose || action == "invalid" {
+				fmt.Fprintf(w, "%s %s\n", action, key)
+			}
+		},
+	}
+	result, err := s.srv.IngestStore(context.Background(), server.StoreConfig{URL: srcURL}, opts)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Imported %d objects (%d bytes). %d unchanged, %d with invalid names\n",
+		result.Uploaded, result.Bytes, result.Unchanged, result.Invalid)
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jotfs/jotfs/client"
+	"github.com/jotfs/jotfs/internal/pipenet"
+	"github.com/jotfs/jotfs/server"
+
+	"github.com/rs/zerolog"
+)
+
+// localHost is the address of the server run in-process by the local admin commands.
+const localHost = "jotfs.local:80"
+
+// localServer is a server which opens the database and store directly, and serves a
+// client over an in-memory connection rather than the network.
+type localServer struct {
+	srv    *server.Server
+	hsrv   *http.Server
+	client *client.Client
+}
+
+// localServerFlags registers the flags used to open a local server on fs, and returns
+// a function which opens the server once fs is parsed.
+func localServerFlags(fs *flag.FlagSet) func() (*localServer, error) {
+	load := localConfigFlags(fs, true)
+	return func() (*localServer, error) {
+		cfg, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return openLocalServer(newServerConfig(cfg))
+	}
+}
+
+// localConfigFlags registers the flags used to configure the local admin commands on
+// fs, and returns a function which loads and validates the configuration once fs is
+// parsed. The store flags are only registered, and the store configuration is only
+// validated, if withStore is true. The logger writes to stderr at the configured level.
+func localConfigFlags(fs *flag.FlagSet, withStore bool) func() (*config, error) {
+	var cfg config
+	cfg.setDefaults()
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "TOML config file of the server")
+	fs.StringVar(&cfg.Server.Database, "db", "", "location of metadata cache. Defaults to jotfs.db in the data directory")
+	fs.StringVar(&cfg.Server.DataDir, "data_dir", defaultDataDir, "directory containing the metadata cache")
+	if withStore {
+		fs.StringVar(&cfg.Store.URL, "store_url", "", "URL of the store")
+		fs.StringVar(&cfg.Store.Bucket, "store_bucket", "", "bucket name")
+	}
+	return func() (*config, error) {
+		if err := loadConfig(&cfg, configFileFromEnv(configFile), fs, os.LookupEnv); err != nil {
+			return nil, err
+		}
+		if err := cfg.Server.validate(); err != nil {
+			return nil, err
+		}
+		if withStore {
+			if err := cfg.Store.validate(); err != nil {
+				return nil, err
+			}
+		}
+		// Log to stderr, so the log is kept apart from any output written to stdout
+		console := zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) { w.Out = os.Stderr })
+		logger = zerolog.New(console).With().Timestamp().Logger().Level(getLoggerLevel(cfg.Server.LogLevel))
+		return &cfg, nil
+	}
+}
+
+// openLocalServer opens the server's database and store. The server's background
+// tasks, including the automatic vacuum, are not started.
+func openLocalServer(cfg server.Config) (*localServer, error) {
+	srv, err := server.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ln := pipenet.Listen(localHost)
+	hsrv := &http.Server{Handler: srv}
+	go hsrv.Serve(ln)
+
+	// Requests for objects in remote stores still use the network
+	hclient := &http.Client{Transport: ln.Transport()}
+	c, err := client.New("http://"+localHost, &client.Options{HTTPClient: hclient})
+	if err != nil {
+		hsrv.Close()
+		srv.Close()
+		return nil, err
+	}
+	return &localServer{srv: srv, hsrv: hsrv, client: c}, nil
+}
+
+// Close waits for any packfile uploads to complete and closes the server.
+func (s *localServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err := s.srv.Shutdown(ctx)
+	s.hsrv.Close()
+	if cerr := s.srv.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// importDir uploads a local directory to the server without running the server.
+func importDir(args []string, w io.Writer) error {
+	var dir, prefix, exclude, include string
+	var dryRun bool
+	fs := flag.NewFlagSet("import-dir", flag.ContinueOnError)
+	fs.StringVar(&dir, "dir", "", "local directory to import")
+	fs.StringVar(&prefix, "prefix", "/", "directory on the server to import the files into")
+	fs.StringVar(&exclude, "exclude", "", "exclude files matching a glob pattern")
+	fs.StringVar(&include, "include", "", "include files excluded by -exclude which match a glob pattern")
+	fs.BoolVar(&dryRun, "dryrun", false, "output the files which would be imported without importing them")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" {
+		return requiredFlagError("dir")
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	opts := &client.SyncOpts{
+		Exclude: exclude,
+		Include: include,
+		DryRun:  dryRun,
+		Progress: func(action string, name string) {
+			fmt.Fprintf(w, "%s %s\n", action, name)
+		},
+	}
+	result, err := s.client.Sync(context.Background(), dir, prefix, opts)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Imported %d files. %d unchanged\n", result.Uploaded, result.Unchanged)
+	return nil
+}
+
+// exportDir downloads the files under a prefix to a local directory without running
+// the server.
+func exportDir(args []string, w io.Writer) error {
+	var dir, prefix, linkDest string
+	fs := flag.NewFlagSet("export-dir", flag.ContinueOnError)
+	fs.StringVar(&dir, "dir", "", "local directory to export the files to")
+	fs.StringVar(&prefix, "prefix", "/", "directory on the server to export")
+	fs.StringVar(&linkDest, "link_dest", "", "previous export to hard-link unchanged files from")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" {
+		return requiredFlagError("dir")
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	opts := &client.ExportOpts{
+		LinkDest: linkDest,
+		Progress: func(action string, name string) {
+			fmt.Fprintf(w, "%s %s\n", action, name)
+		},
+	}
+	result, err := s.client.Export(context.Background(), prefix, dir, opts)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Exported %d files. %d downloaded, %d linked\n", result.Downloaded+result.Linked, result.Downloaded, result.Linked)
+	return nil
+}
+
+// exportBundle writes a bundle of the files under a prefix to out without running the
+// server.
+func exportBundle(args []string, out io.Writer, w io.Writer) error {
+	var prefix string
+	fs := flag.NewFlagSet("export-bundle", flag.ContinueOnError)
+	fs.StringVar(&prefix, "prefix", "/", "directory on the server to export")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	opts := &client.BundleOpts{
+		Progress: func(action string, name string) {
+			fmt.Fprintf(w, "%s %s\n", action, name)
+		},
+	}
+	result, err := s.client.ExportBundle(context.Background(), prefix, out, opts)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Exported %d files. %d chunks, %d bytes\n", result.Files, result.Chunks, result.ChunkSize)
+	return nil
+}
+
+// importBundle uploads the files in a bundle read from in without running the server.
+func importBundle(args []string, in io.Reader, w io.Writer) error {
+	var prefix, tempDir string
+	fs := flag.NewFlagSet("import-bundle", flag.ContinueOnError)
+	fs.StringVar(&prefix, "prefix", "", "directory on the server to import the files into. Defaults to the directory they were exported from")
+	fs.StringVar(&tempDir, "temp_dir", "", "directory to hold the bundle's chunks during the import. Defaults to the system temporary directory")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	opts := &client.BundleOpts{
+		TempDir: tempDir,
+		Progress: func(action string, name string) {
+			fmt.Fprintf(w, "%s %s\n", action, name)
+		},
+	}
+	result, err := s.client.ImportBundle(context.Background(), in, prefix, opts)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Imported %d files. %d unchanged\n", result.Files, result.Unchanged)
+	return nil
+}
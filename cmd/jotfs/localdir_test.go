@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportExportDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("world"), 0644))
+
+	serverArgs := []string{
+		"-db", filepath.Join(dir, "jotfs.db"),
+		"-store_url", "file://" + filepath.ToSlash(filepath.Join(dir, "store")),
+	}
+	var out bytes.Buffer
+	err = importDir(append(serverArgs, "-dir", src, "-prefix", "/backup"), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Imported 2 files. 0 unchanged")
+
+	out.Reset()
+	first := filepath.Join(dir, "1")
+	err = exportDir(append(serverArgs, "-dir", first, "-prefix", "/backup"), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Exported 2 files. 2 downloaded, 0 linked")
+	data, err := ioutil.ReadFile(filepath.Join(first, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	// Only the modified file is imported and downloaded again
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello again"), 0644))
+	out.Reset()
+	err = importDir(append(serverArgs, "-dir", src, "-prefix", "/backup"), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Imported 1 files. 1 unchanged")
+
+	out.Reset()
+	second := filepath.Join(dir, "2")
+	err = exportDir(append(serverArgs, "-dir", second, "-prefix", "/backup", "-link_dest", first), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Exported 2 files. 1 downloaded, 1 linked")
+	data, err = ioutil.ReadFile(filepath.Join(second, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello again", string(data))
+	stat1, err := os.Stat(filepath.Join(first, "sub", "b.txt"))
+	assert.NoError(t, err)
+	stat2, err := os.Stat(filepath.Join(second, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(stat1, stat2))
+
+	err = importDir(serverArgs, &out)
+	assert.EqualError(t, err, requiredFlagError("dir").Error())
+}
+
+func TestBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("world"), 0644))
+
+	serverArgs := func(name string) []string {
+		return []string{
+			"-db", filepath.Join(dir, name+".db"),
+			"-store_url", "file://" + filepath.ToSlash(filepath.Join(dir, name)),
+		}
+	}
+	var out bytes.Buffer
+	err = importDir(append(serverArgs("first"), "-dir", src, "-prefix", "/backup"), &out)
+	assert.NoError(t, err)
+
+	// Export from one deployment and import into another
+	var bundle bytes.Buffer
+	out.Reset()
+	err = exportBundle(append(serverArgs("first"), "-prefix", "/backup"), &bundle, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Exported 2 files. 2 chunks, 10 bytes")
+
+	out.Reset()
+	err = importBundle(serverArgs("second"), bytes.NewReader(bundle.Bytes()), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Imported 2 files. 0 unchanged")
+	out.Reset()
+	err = importBundle(serverArgs("second"), bytes.NewReader(bundle.Bytes()), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Imported 0 files. 2 unchanged")
+
+	out.Reset()
+	restore := filepath.Join(dir, "restore")
+	err = exportDir(append(serverArgs("second"), "-dir", restore, "-prefix", "/backup"), &out)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(restore, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+}
+
+func TestMigrateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, os.MkdirAll(src, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("hello"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "b.txt"), []byte("world"), 0644))
+	db := []string{"-db", filepath.Join(dir, "jotfs.db")}
+	oldStore := append(db, "-store_url", "file://"+filepath.ToSlash(filepath.Join(dir, "old")))
+	newStore := append(db, "-store_url", "file://"+filepath.ToSlash(filepath.Join(dir, "new")))
+	dstURL := "file://" + filepath.ToSlash(filepath.Join(dir, "new"))
+
+	var out bytes.Buffer
+	assert.NoError(t, importDir(append(oldStore, "-dir", src, "-prefix", "/data"), &out))
+
+	// 2 packfiles and their indexes, and 2 files
+	out.Reset()
+	assert.NoError(t, migrateStore(append(oldStore, "-dst_url", dstURL), &out))
+	assert.Contains(t, out.String(), "Copied 6 objects")
+	out.Reset()
+	assert.NoError(t, migrateStore(append(oldStore, "-dst_url", dstURL, "-update_etags"), &out))
+	assert.Contains(t, out.String(), "Copied 0 objects (0 bytes). 6 already copied, 0 missing")
+
+	// The files can be read from the new store
+	out.Reset()
+	restore := filepath.Join(dir, "restore")
+	assert.NoError(t, exportDir(append(newStore, "-dir", restore, "-prefix", "/data"), &out))
+	data, err := ioutil.ReadFile(filepath.Join(restore, "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	err = migrateStore(oldStore, &out)
+	assert.EqualError(t, err, requiredFlagError("dst_url").Error())
+}
+
+func TestIngestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "data"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "data", "a.txt"), []byte("hello"), 0644))
+	srcURL := "file://" + filepath.ToSlash(src)
+	args := []string{"-db", filepath.Join(dir, "jotfs.db"), "-store_url", "file://" + filepath.ToSlash(filepath.Join(dir, "store"))}
+
+	var out bytes.Buffer
+	assert.NoError(t, ingestStore(append(args, "-src_url", srcURL, "-src_prefix", "data/", "-prefix", "/imported"), &out))
+	assert.Contains(t, out.String(), "Imported 1 objects (5 bytes). 0 unchanged")
+	out.Reset()
+	assert.NoError(t, ingestStore(append(args, "-src_url", srcURL, "-src_prefix", "data/", "-prefix", "/imported"), &out))
+	assert.Contains(t, out.String(), "Imported 0 objects (0 bytes). 1 unchanged")
+
+	out.Reset()
+	restore := filepath.Join(dir, "restore")
+	assert.NoError(t, exportDir(append(args, "-dir", restore, "-prefix", "/imported"), &out))
+	data, err := ioutil.ReadFile(filepath.Join(restore, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	err = ingestStore(args, &out)
+	assert.EqualError(t, err, requiredFlagError("src_url").Error())
+}
+
+func TestCompactDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	args := []string{"-db", filepath.Join(dir, "jotfs.db"), "-store_url", "file://" + filepath.ToSlash(filepath.Join(dir, "store"))}
+
+	var out bytes.Buffer
+	assert.NoError(t, compactDB(args, &out))
+	assert.Contains(t, out.String(), "Uploaded backups/db/")
+	assert.NotContains(t, out.String(), "replaces the database")
+
+	out.Reset()
+	assert.NoError(t, compactDB(append(args, "-swap"), &out))
+	assert.Contains(t, out.String(), "The copy replaces the database when the server next starts")
+	_, err = os.Stat(filepath.Join(dir, "jotfs.db.compact"))
+	assert.NoError(t, err)
+}
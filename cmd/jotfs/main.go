@@ -1,31 +1,19 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"database/sql"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
-	"github.com/jotfs/jotfs/internal/db"
-	pb "github.com/jotfs/jotfs/internal/protos"
-	"github.com/jotfs/jotfs/internal/server"
-	"github.com/jotfs/jotfs/internal/store"
-	"github.com/jotfs/jotfs/internal/store/s3"
+	"github.com/jotfs/jotfs/server"
 
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/rs/xid"
 	"github.com/rs/zerolog"
-	"github.com/twitchtv/twirp"
 )
 
 // Build flags
@@ -36,228 +24,49 @@ var (
 )
 
 const (
-	defaultDatabase         = "./jotfs.db"
+	defaultDatabaseName     = "jotfs.db"
+	defaultDataDir          = "."
 	defaultPort             = 6777
 	defaultLogLevel         = "warn"
 	defaultDLTimeoutMinutes = 120
+	defaultShutdownSeconds  = 300
+	defaultReportHours      = 7 * 24
+	defaultCheckpointMiB    = 64
+	defaultCheckpointSecs   = 5
+	defaultDBBusyTimeoutMS  = 5000
+	defaultDBSynchronous    = "FULL"
+	defaultDBReaders        = 16
+	defaultRetryAttempts    = 3
+	defaultRetryBudget      = 10
+	defaultReadAhead        = 4
+
+	defaultBreakerThreshold    = 10
+	defaultBreakerCooldownSecs = 30
+
+	tracingShutdownTimeout = 5 * time.Second
 
 	defaultStoreEndpoint = "s3.amazonaws.com"
 	defaultRegion        = "us-east-1"
 
+	defaultAutoDataDir          = "/data"
+	defaultAutoStoreWaitSeconds = 300
+
 	kiB = 1024
 	miB = 1024 * kiB
 
-	maxPackfileSize          = 128 * miB
 	minVacuumScheduleMinutes = 5
+	defaultVacuumMinutes     = 180
 
-	minAvgKib            = 64
-	maxAvgKib            = 64 * 1024 // 64 MiB
-	defaultAvgKib        = 512
-	defaultNormalization = 2
-
-	chunkParamsKey = "params.json"
-)
-
-type serverConfig struct {
-	Port                  uint
-	Database              string
-	VersioningEnabled     bool
-	AvgChunkKiB           uint
-	LogLevel              string
-	TLSCert               string
-	TLSKey                string
-	DLTimeoutMinutes      uint
-	VacuumScheduleMinutes uint
-	DisableAutoVacuum     bool
-}
-
-type storeConfig struct {
-	AccessKey  string
-	SecretKey  string
-	Bucket     string
-	Region     string
-	DisableSSL bool
-	PathStyle  bool
-	Endpoint   string
-}
-
-type config struct {
-	Server *serverConfig
-	Store  *storeConfig
-}
-
-func openDB(filename string) (*db.Adapter, error) {
-	exists, err := fileExists(filename)
-	if err != nil {
-		return nil, fmt.Errorf("opening file %s: %v", filename, err)
-	}
-	if exists {
-		fmt.Printf("Using existing database %s\n", filename)
-	} else {
-		fmt.Printf("Creating new database %s\n", filename)
-	}
-	sqldb, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_fk=true", filename))
-	if err != nil {
-		return nil, err
-	}
-	if err := sqldb.Ping(); err != nil {
-		return nil, fmt.Errorf("could not connect")
-	}
-	adapter := db.NewAdapter(sqldb)
-	if !exists {
-		if err := adapter.InitSchema(); err != nil {
-			return nil, fmt.Errorf("internal error: creating database schema: %v", err)
-		}
-	}
-	return adapter, nil
-}
-
-func fileExists(f string) (bool, error) {
-	info, err := os.Stat(f)
-	if os.IsNotExist(err) {
-		return false, nil
-	}
-	if err != nil {
-		return false, err
-	}
-	if info.IsDir() {
-		return false, errors.New("is a directory but a file is required")
-	}
-	return true, nil
-}
-
-func requiredFlagError(flag string) error {
-	return fmt.Errorf("flag -%s is reqiured", flag)
-}
-
-func (c serverConfig) validate() error {
-	if c.AvgChunkKiB < minAvgKib || c.AvgChunkKiB > maxAvgKib {
-		return fmt.Errorf("-chunk_size must be in range %d to %d", minAvgKib, maxAvgKib)
-	}
-	if (c.TLSCert == "" && c.TLSKey != "") || (c.TLSCert != "" && c.TLSKey == "") {
-		return fmt.Errorf("flags -ssl_cert and -ssl_key must be provided together")
-	}
-	if !c.DisableAutoVacuum && c.VacuumScheduleMinutes < minVacuumScheduleMinutes {
-		return fmt.Errorf("flag -vacuum_schedule must be at least %d", minVacuumScheduleMinutes)
-	}
-	switch c.LogLevel {
-	case "", "debug", "info", "warn", "error":
-		break
-	default:
-		return fmt.Errorf("invalid -log_level %q. Must be one of: debug, info, warn, error", c.LogLevel)
-	}
-	return nil
-}
-
-func (c storeConfig) validate() error {
-	if c.Bucket == "" {
-		return requiredFlagError("store_bucket")
-	}
-	return nil
-}
-
-func loggingServerHooks() *twirp.ServerHooks {
-	hooks := &twirp.ServerHooks{}
-
-	// Define a key type to keep context.WithValue happy
-	type key int
-	const (
-		receivedAtKey key = iota + 1
-		msgKey
-		reqIDKey
-	)
-
-	hooks.RequestReceived = func(ctx context.Context) (context.Context, error) {
-		reqID := xid.New().String()
-		twirp.SetHTTPResponseHeader(ctx, "x-jotfs-request-id", reqID)
-		ctx = context.WithValue(ctx, reqIDKey, xid.New().String())
-		ctx = context.WithValue(ctx, receivedAtKey, time.Now())
-		return ctx, nil
-	}
-
-	hooks.Error = func(ctx context.Context, err twirp.Error) context.Context {
-		ctx = context.WithValue(ctx, msgKey, err.Error())
-		return ctx
-	}
-
-	hooks.ResponseSent = func(ctx context.Context) {
-		var reqID string
-		if v := ctx.Value(reqIDKey); v != nil {
-			reqID = v.(string)
-		}
-		var elapsed time.Duration
-		if v := ctx.Value(receivedAtKey); v != nil {
-			elapsed = time.Since(v.(time.Time))
-		}
-		var msg string
-		if v := ctx.Value(msgKey); v != nil {
-			msg = v.(string)
-		}
-		method, _ := twirp.MethodName(ctx)
-		code, _ := twirp.StatusCode(ctx)
-		status, _ := strconv.Atoi(code)
-
-		rpcLogger := logger.With().
-			Str("method", method).
-			Int("status", status).
-			Int64("elapsed", elapsed.Milliseconds()).
-			Str("id", reqID).
-			Logger()
-
-		if 200 <= status && status < 300 {
-			rpcLogger.Info().Msg(msg)
-		} else if 400 <= status && status < 500 {
-			rpcLogger.Warn().Msg(msg)
-		} else {
-			rpcLogger.Error().Msg(msg)
-		}
-	}
-
-	return hooks
-}
-
-// getChunkerParams gets the chunker parameters from the store. Return nil if the file
-// does not exist.
-func getChunkerParams(ctx context.Context, s store.Store, bucket string) (*server.ChunkerParams, error) {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-
-	r, err := s.Get(ctx, bucket, chunkParamsKey)
-	if errors.Is(err, store.ErrNotFound) {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer r.Close()
-
-	var params server.ChunkerParams
-	b, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, fmt.Errorf("reading object: %v", err)
-	}
-	if err := json.Unmarshal(b, &params); err != nil {
-		return nil, fmt.Errorf("decoding JSON: %v", err)
-	}
+	minAvgKib     = 64
+	maxAvgKib     = 64 * 1024 // 64 MiB
+	defaultAvgKib = 512
 
-	return &params, nil
-}
-
-// saveChunkerParams saves the chunker params to the store.
-func saveChunkerParams(ctx context.Context, s store.Store, bucket string, params *server.ChunkerParams) error {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+	// maxNameLength is the maximum length of a file name supported by the server.
+	maxNameLength = 1024
 
-	b, err := json.Marshal(params)
-	if err != nil {
-		return fmt.Errorf("encoding json: %v", err)
-	}
-	if err := s.Put(ctx, bucket, chunkParamsKey, bytes.NewReader(b)); err != nil {
-		return fmt.Errorf("putting object to store: %v", err)
-	}
-
-	return nil
-}
+	// maxInlineKib is the largest file size, in KiB, which may be stored inline.
+	maxInlineKib = 64
+)
 
 func getLoggerLevel(s string) zerolog.Level {
 	switch s {
@@ -276,30 +85,118 @@ func getLoggerLevel(s string) zerolog.Level {
 
 var logger zerolog.Logger
 
+// jsonErrors is set if a startup error should be logged as JSON rather than printed.
+var jsonErrors bool
+
 func run() error {
-	var serverConfig serverConfig
+	var cfg config
+	serverConfig := &cfg.Server
+	storeConfig := &cfg.Store
+	var configFile string
+	flag.StringVar(&configFile, "config", "", "TOML config file. Values may be overridden by JOTFS_<SECTION>_<KEY> environment variables")
 	flag.UintVar(&serverConfig.Port, "port", defaultPort, "server listening port")
-	flag.StringVar(&serverConfig.Database, "db", defaultDatabase, "location of metadata cache")
+	flag.StringVar(&serverConfig.Database, "db", "", "location of metadata cache. Defaults to jotfs.db in the data directory")
+	flag.StringVar(&serverConfig.DataDir, "data_dir", defaultDataDir, "directory to store the metadata cache in")
 	flag.BoolVar(&serverConfig.VersioningEnabled, "enable_versioning", false, "enable file versioning")
 	flag.UintVar(&serverConfig.AvgChunkKiB, "chunk_size", defaultAvgKib, "average chunk size in KiB")
+	flag.StringVar(&serverConfig.ChunkAlgorithm, "chunk_algorithm", "fastcdc", "chunking algorithm for a new bucket: fastcdc, rabin or fixed")
+	flag.StringVar(&serverConfig.ChunkHash, "chunk_hash", "blake3", "chunk hash function for a new bucket: blake3 or sha256")
 	flag.StringVar(&serverConfig.LogLevel, "log_level", defaultLogLevel, "server logging level")
 	flag.StringVar(&serverConfig.TLSCert, "tls_cert", "", "server TLS certificate file")
 	flag.StringVar(&serverConfig.TLSKey, "tls_key", "", "server TLS key file")
 	flag.UintVar(&serverConfig.DLTimeoutMinutes, "download_timeout", defaultDLTimeoutMinutes, "the maximum allotted time, in minutes, for a client to download a file")
-	flag.UintVar(&serverConfig.VacuumScheduleMinutes, "vacuum_schedule", 180, "number of minutes between automatic vacuums")
+	flag.UintVar(&serverConfig.VacuumScheduleMinutes, "vacuum_schedule", defaultVacuumMinutes, "number of minutes between automatic vacuums")
 	flag.BoolVar(&serverConfig.DisableAutoVacuum, "disable_vacuum", false, "disable the automatic vacuum")
-
-	var storeConfig storeConfig
-	flag.StringVar(&storeConfig.AccessKey, "store_access_key", "", "access key for the object store")
+	flag.UintVar(&serverConfig.ShutdownTimeoutSecs, "shutdown_timeout", defaultShutdownSeconds, "the maximum time, in seconds, to wait for in-flight requests to complete on shutdown")
+	flag.UintVar(&serverConfig.CacheTTLSecs, "cache_ttl", 0, "number of seconds to cache the responses of listing and stats requests for. Disabled if 0")
+	flag.UintVar(&serverConfig.OpLogIntervalSecs, "oplog_interval", 0, "number of seconds between uploads of the operation log to the store, or between replays of it if -standby is set. Disabled if 0")
+	flag.BoolVar(&serverConfig.DisableChunkFilter, "disable_chunk_filter", false, "disable the in-memory filter used to find new chunks without querying the database")
+	flag.UintVar(&serverConfig.CheckpointMiB, "checkpoint_size", defaultCheckpointMiB, "size in MiB of the database write-ahead log at which it is checkpointed while the database is being written to")
+	flag.UintVar(&serverConfig.CheckpointIdleSecs, "checkpoint_idle", defaultCheckpointSecs, "number of seconds without database writes after which the write-ahead log is checkpointed and truncated")
+	flag.UintVar(&serverConfig.DBBusyTimeoutMS, "db_busy_timeout", defaultDBBusyTimeoutMS, "milliseconds a database connection waits for a lock held by another connection before failing")
+	flag.StringVar(&serverConfig.DBSynchronous, "db_synchronous", defaultDBSynchronous, "SQLite synchronous setting for the database: OFF, NORMAL, FULL or EXTRA")
+	flag.UintVar(&serverConfig.DBReaders, "db_readers", defaultDBReaders, "maximum number of database connections used for reads")
+	flag.BoolVar(&serverConfig.ManualMigrations, "manual_migrations", false, "refuse to start while database schema migrations are pending, instead of applying them. Apply them with jotfs admin migrate-db")
+	flag.UintVar(&serverConfig.BackupIntervalHours, "backup_interval", 0, "number of hours between backups of the database to the store. Disabled if 0")
+	flag.UintVar(&serverConfig.BackupRetain, "backup_retain", 0, "number of database backups kept in the store, deleting the oldest. All are kept if 0")
+	flag.StringVar(&serverConfig.Jobs, "jobs", "", "semicolon-separated list of name=schedule background job schedules, where name is vacuum, backup, compact, scrub, oplog or report, and schedule is a cron expression in UTC, @every <duration>, @hourly, @daily, @weekly, @monthly or off")
+	flag.UintVar(&serverConfig.MaxJobs, "max_jobs", 0, "maximum number of background jobs run at once (default 2)")
+	flag.UintVar(&serverConfig.PackfileMiB, "packfile_size", 0, "size in MiB at which clients upload a packfile and start another (default 64)")
+	flag.UintVar(&serverConfig.MaxPackfileMiB, "max_packfile_size", 0, "size in MiB of the largest packfile accepted from clients, at least -packfile_size (default 128, or twice -packfile_size if larger)")
+	flag.UintVar(&serverConfig.PackfileFlushSecs, "packfile_flush_interval", 0, "number of seconds after which clients upload a packfile which is not full. Disabled if 0")
+	flag.UintVar(&serverConfig.InlineKiB, "inline_size", 0, "size in KiB, at most 64, of the largest file stored in the database instead of a packfile. Disabled if 0")
+	flag.BoolVar(&serverConfig.Standby, "standby", false, "run as a read-only warm standby, replaying the operation log of the primary server using the same store")
+	flag.BoolVar(&serverConfig.SecretsFromEnv, "secrets_from_env", false, "require store credentials and tokens to be set by environment variables")
+	flag.StringVar(&serverConfig.AdminToken, "admin_token", "", "enable the admin API at /admin/ using this bearer token")
+	flag.StringVar(&serverConfig.PolicyFile, "policy_file", "", "TOML file granting API keys access to files by name prefix. Reloaded when it changes")
+	flag.UintVar(&serverConfig.ReportIntervalHours, "report_interval", defaultReportHours, "number of hours between usage reports")
+	flag.StringVar(&serverConfig.ReportTenant, "report_tenant", "", "name of the server in usage reports")
+	flag.StringVar(&serverConfig.ReportWebhook, "report_webhook", "", "URL to post usage reports to as JSON")
+	flag.StringVar(&serverConfig.ReportSMTPAddr, "report_smtp_addr", "", "host:port of an SMTP server to email usage reports through")
+	flag.StringVar(&serverConfig.ReportSMTPUsername, "report_smtp_username", "", "username for the usage report SMTP server")
+	flag.StringVar(&serverConfig.ReportSMTPPassword, "report_smtp_password", "", "password for the usage report SMTP server")
+	flag.StringVar(&serverConfig.ReportFrom, "report_from", "", "sender address of usage report emails")
+	flag.StringVar(&serverConfig.ReportTo, "report_to", "", "comma-separated list of usage report email recipients")
+	flag.StringVar(&serverConfig.Checksums, "checksums", "", "comma-separated list of whole-file checksums to compute for new file versions, in addition to sha256: md5, sha1")
+	flag.StringVar(&serverConfig.IndexCacheDir, "index_cache_dir", "", "local directory for cached packfile indexes, used to locate chunks for downloads without querying the database")
+	flag.StringVar(&serverConfig.ChunkCacheDir, "chunk_cache_dir", "", "local directory in which to cache chunks read from the store for share links and other downloads served by the server")
+	flag.UintVar(&serverConfig.ChunkCacheMiB, "chunk_cache_size", 0, "maximum size in MiB of the chunks in -chunk_cache_dir (default 1024)")
+	flag.UintVar(&serverConfig.LocationCacheSize, "location_cache_size", 0, "number of chunk locations to keep in memory, used to locate chunks for downloads without querying the database")
+	flag.UintVar(&serverConfig.ReadAhead, "read_ahead", defaultReadAhead, "number of chunk ranges to read from the store ahead of the one being written when the server streams a file, or 0 to read them one at a time")
+	flag.StringVar(&serverConfig.UploadQueueDir, "upload_queue_dir", "", "local directory in which to queue packfile uploads, so clients don't wait for the store to save each packfile")
+	flag.UintVar(&serverConfig.UploadQueueSize, "upload_queue_size", 0, "maximum number of packfiles in -upload_queue_dir. Uploads wait when the queue is full (default 16)")
+	flag.UintVar(&serverConfig.UploadQueueWorkers, "upload_queue_workers", 0, "number of queued packfiles saved to the store at once (default 4)")
+	flag.UintVar(&serverConfig.MinFreeMiB, "min_free_space", 0, "MiB of free disk space to keep on the file systems of -chunk_cache_dir and -upload_queue_dir. Cached chunks are removed, and queued uploads rejected with a retryable error, when free space is lower. Disabled if 0")
+	flag.UintVar(&serverConfig.MaxUploads, "max_uploads", 0, "maximum number of packfile uploads in progress at once. Further uploads are rejected with a retryable error. Unlimited if 0")
+	flag.UintVar(&serverConfig.MaxUploadMiB, "max_upload_mib", 0, "maximum total MiB of packfile uploads in progress at once. Further uploads are rejected with a retryable error. Unlimited if 0")
+	flag.StringVar(&serverConfig.CORSOrigins, "cors_origins", "", "comma-separated list of origins allowed to call the API from a browser, or * for any origin")
+	flag.UintVar(&serverConfig.NameMaxLength, "name_max_length", maxNameLength, "maximum length of a file name in bytes")
+	flag.StringVar(&serverConfig.NamePattern, "name_pattern", "", "regular expression which new file names must match")
+	flag.BoolVar(&serverConfig.NormalizeNames, "normalize_names", false, "convert file names to Unicode normalization form NFC")
+	flag.StringVar(&serverConfig.MetadataFile, "metadata_file", "", "TOML file of rules setting default metadata, such as content types by extension, on new files under a prefix")
+	flag.StringVar(&serverConfig.SyncReplication, "sync_replication", "", "comma-separated list of name prefixes of files whose uploads only succeed once a replica has acknowledged them")
+	flag.StringVar(&serverConfig.Replicas, "replicas", "", "comma-separated list of JotFS servers, tried in order, which files under -sync_replication are copied to")
+	flag.StringVar(&serverConfig.ReplicaKey, "replica_key", "", "API key sent to the -replicas servers")
+	flag.UintVar(&serverConfig.ReplicaTimeoutSecs, "replica_timeout", 0, "number of seconds each replica has to acknowledge a file (default 60)")
+	flag.StringVar(&serverConfig.Conflicts, "conflicts", "", "comma-separated list of prefix=strategy rules for concurrent uploads of a file, where strategy is replace, reject or branch")
+	flag.StringVar(&serverConfig.Quotas, "quotas", "", "comma-separated list of prefix=soft:hard:grace quotas limiting the total MiB of the file versions under a prefix. Uploads over the soft limit succeed for the grace period, 168h by default, and uploads over the hard limit fail")
+	flag.UintVar(&serverConfig.ShedMaxRequests, "shed_max_requests", 0, "maximum number of requests served at once. Uploads may use three quarters, and background and admin requests a quarter, of the limit. Unlimited if 0")
+	flag.UintVar(&serverConfig.ShedQueueMillis, "shed_queue_timeout", 0, "number of milliseconds a request waits for a free slot when -shed_max_requests are being served before it's rejected")
+	flag.UintVar(&serverConfig.ShedMaxHeapMiB, "shed_max_heap", 0, "reject uploads and background requests while the server's heap is larger than this many MiB. Disabled if 0")
+	flag.UintVar(&serverConfig.RateRequests, "rate_requests", 0, "maximum number of API requests and packfile uploads per second received from all clients. Unlimited if 0")
+	flag.UintVar(&serverConfig.RateMiB, "rate_mib", 0, "maximum MiB per second of request bodies received from all clients. Unlimited if 0")
+	flag.UintVar(&serverConfig.ClientRateRequests, "client_rate_requests", 0, "maximum number of API requests and packfile uploads per second received from each API key, or IP address. Unlimited if 0")
+	flag.UintVar(&serverConfig.ClientRateMiB, "client_rate_mib", 0, "maximum MiB per second of request bodies received from each API key, or IP address. Unlimited if 0")
+	flag.UintVar(&serverConfig.MaxUploadMbps, "max_upload_mbps", 0, "maximum megabits per second received from each API key, or IP address. Faster clients are slowed down rather than rejected. Unlimited if 0")
+	flag.UintVar(&serverConfig.MaxDownloadMbps, "max_download_mbps", 0, "maximum megabits per second sent to each API key, or IP address, by the server. Faster clients are slowed down rather than rejected. Unlimited if 0")
+	flag.UintVar(&serverConfig.ShedStoreMillis, "shed_store_latency", 0, "reject background requests while store requests take longer than this many milliseconds on average. Disabled if 0")
+	flag.StringVar(&serverConfig.OTLPEndpoint, "otlp_endpoint", "", "address of an OpenTelemetry collector to export traces to, e.g. localhost:4317")
+	flag.BoolVar(&serverConfig.OTLPInsecure, "otlp_insecure", false, "connect to the OpenTelemetry collector without TLS")
+
+	flag.StringVar(&storeConfig.URL, "store_url", "", "URL of the store, e.g. s3://bucket or file:///var/lib/jotfs. Overrides the other -store_* connection flags")
+	flag.StringVar(&storeConfig.AccessKey, "store_access_key", "", "access key for the object store. The AWS default credential chain is used if not set")
 	flag.StringVar(&storeConfig.SecretKey, "store_secret_key", "", "secret key for the object store")
 	flag.StringVar(&storeConfig.Bucket, "store_bucket", "", "bucket name (required)")
 	flag.BoolVar(&storeConfig.DisableSSL, "store_disable_ssl", false, "don't require an SSL connection to connect to the store")
 	flag.BoolVar(&storeConfig.PathStyle, "store_path_style", false, "use path-style requests to the store")
 	flag.StringVar(&storeConfig.Endpoint, "store_endpoint", "", "endpoint of S3-compatible store. Connects to AWS S3 by default")
 	flag.StringVar(&storeConfig.Region, "store_region", "", "store region name")
-
+	flag.StringVar(&storeConfig.RoleARN, "store_role_arn", "", "ARN of an IAM role to assume when accessing the store")
+	flag.StringVar(&storeConfig.ExternalID, "store_role_external_id", "", "external ID used when assuming -store_role_arn")
+	flag.UintVar(&storeConfig.PartSizeMiB, "store_part_size", 0, "size in MiB of the parts packfiles are uploaded to S3 in, at least 5 (default 8). Set part_size in -store_url instead when it's used")
+	flag.UintVar(&storeConfig.UploadConcurrency, "store_upload_concurrency", 0, "number of parts of a packfile uploaded to S3 at once (default 4). Set upload_concurrency in -store_url instead when it's used")
+	flag.UintVar(&storeConfig.RetryAttempts, "store_retry_attempts", defaultRetryAttempts, "maximum number of times a store request which fails with a transient error is made. Not retried if less than 2")
+	flag.UintVar(&storeConfig.RetryBudgetPercent, "store_retry_budget", defaultRetryBudget, "maximum number of store retries as a percentage of store requests. Unlimited if 0")
+	flag.UintVar(&storeConfig.BreakerThreshold, "store_breaker_threshold", defaultBreakerThreshold, "number of consecutive failed store requests after which requests needing the store fail immediately and the server reports it's not ready. Disabled if 0")
+	flag.UintVar(&storeConfig.BreakerCooldownSecs, "store_breaker_cooldown", defaultBreakerCooldownSecs, "number of seconds requests fail immediately after -store_breaker_threshold failures before a trial request is sent to the store")
+	flag.StringVar(&storeConfig.EventsToken, "store_events_token", "", "accept bucket notifications at /store/events using this bearer token")
+	flag.StringVar(&storeConfig.EventsQueue, "store_events_queue", "", "URL of an SQS queue to receive bucket notifications from")
+	flag.UintVar(&storeConfig.WaitTimeoutSeconds, "store_wait_timeout", 0, "number of seconds to wait for the store to become reachable at startup")
+
+	var auto bool
 	var debug bool
 	var version bool
+	flag.BoolVar(&auto, "auto", false, "run in container mode: read config from JOTFS_* environment variables only, log JSON and wait for the store at startup. May also be enabled with JOTFS_AUTO=true")
 	flag.BoolVar(&debug, "debug", false, "enable debug output")
 	flag.BoolVar(&version, "version", false, "output version info and exit")
 
@@ -313,6 +210,20 @@ func run() error {
 		return nil
 	}
 
+	auto, err := autoFromEnv(auto, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	filename := configFileFromEnv(configFile)
+	if auto {
+		if filename != "" {
+			return errors.New("a config file may not be used in auto mode")
+		}
+		cfg.setAutoDefaults()
+	}
+	if err := loadConfig(&cfg, filename, flag.CommandLine, os.LookupEnv); err != nil {
+		return err
+	}
 	if err := serverConfig.validate(); err != nil {
 		return err
 	}
@@ -320,198 +231,252 @@ func run() error {
 		return err
 	}
 
-	// Configure the logger
-	if debug {
+	// Configure the logger. In auto mode, all output is logged as JSON so it may be
+	// collected by the container runtime.
+	printf := func(format string, v ...interface{}) {
+		fmt.Printf(format+"\n", v...)
+	}
+	if debug && !auto {
 		logger = zerolog.New(zerolog.NewConsoleWriter()).With().Timestamp().Logger().Level(zerolog.DebugLevel)
-		fmt.Println("Debug mode enabled")
+		printf("Debug mode enabled")
 	} else {
 		level := getLoggerLevel(serverConfig.LogLevel)
+		if debug {
+			level = zerolog.DebugLevel
+		}
 		logger = zerolog.New(os.Stderr).With().Timestamp().Logger().Level(level)
-		fmt.Printf("Logging level: %s\n", level.String())
-	}
-
-	adapter, err := openDB(serverConfig.Database)
-	if err != nil {
-		return fmt.Errorf("database: %v", err)
+		if auto {
+			jsonErrors = true
+			printf = func(format string, v ...interface{}) {
+				logger.Log().Msgf(format, v...)
+			}
+		}
+		printf("Logging level: %s", level.String())
 	}
 
-	fmt.Printf("Connecting to object store %s\n", storeConfig.Endpoint)
-	store, err := s3.New(s3.Config{
-		Region:     storeConfig.Region,
-		Endpoint:   storeConfig.Endpoint,
-		AccessKey:  storeConfig.AccessKey,
-		SecretKey:  storeConfig.SecretKey,
-		PathStyle:  storeConfig.PathStyle,
-		DisableSSL: storeConfig.DisableSSL,
-	})
-	if err != nil {
-		return fmt.Errorf("connecting to store: ")
+	if auto {
+		if err := os.MkdirAll(serverConfig.DataDir, 0755); err != nil {
+			return fmt.Errorf("creating data directory: %w", err)
+		}
 	}
 
-	fmt.Printf("Using bucket %s\n", storeConfig.Bucket)
-
-	// Get the chunking parameters from the store or create the object if it doesn't exist
-	ctx := context.Background()
-	chunkerParams, err := getChunkerParams(ctx, store, storeConfig.Bucket)
-	if err != nil {
-		return fmt.Errorf("getting chunker params: %v", err)
+	if serverConfig.VersioningEnabled {
+		printf("File versioning enabled")
+	} else {
+		printf("File versioning disabled")
 	}
-	if chunkerParams == nil {
-		avg := serverConfig.AvgChunkKiB * kiB
-		chunkerParams = &server.ChunkerParams{
-			MinChunkSize:  avg / 4,
-			AvgChunkSize:  avg,
-			MaxChunkSize:  avg * 4,
-			Normalization: defaultNormalization,
+	if storeConfig.URL != "" {
+		printf("Connecting to store %s", redactURL(storeConfig.URL))
+	} else {
+		printf("Connecting to object store %s", storeConfig.Endpoint)
+		printf("Using bucket %s", storeConfig.Bucket)
+		if storeConfig.AccessKey == "" {
+			printf("Using AWS default credential chain")
 		}
-		if err = saveChunkerParams(ctx, store, storeConfig.Bucket, chunkerParams); err != nil {
-			return fmt.Errorf("saving chunker params: %v", err)
+		if storeConfig.RoleARN != "" {
+			printf("Assuming role %s", storeConfig.RoleARN)
 		}
 	}
-
-	if serverConfig.VersioningEnabled {
-		fmt.Println("File versioning enabled")
-	} else {
-		fmt.Println("File versioning disabled")
+	if serverConfig.TLSCert != "" {
+		printf("TLS enabled")
 	}
-
-	srv := server.New(adapter, store, server.Config{
-		Bucket:            storeConfig.Bucket,
-		VersioningEnabled: serverConfig.VersioningEnabled,
-		MaxChunkSize:      uint64(chunkerParams.MaxChunkSize),
-		MaxPackfileSize:   maxPackfileSize,
-		DownloadTimeout:   time.Minute * time.Duration(serverConfig.DLTimeoutMinutes),
-		Params:            *chunkerParams,
-	})
-	srv.SetLogger(logger)
-	srvHandler := pb.NewJotFSServer(srv, loggingServerHooks())
-
-	mux := http.NewServeMux()
-	mux.Handle(srvHandler.PathPrefix(), srvHandler)
-	mux.HandleFunc("/packfile", logHandler(postHandler(srv.PackfileUploadHandler), "PackfileUpload"))
-
-	httpServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", serverConfig.Port),
-		Handler: mux,
+	if storeConfig.EventsToken != "" {
+		printf("Accepting store notifications at /store/events")
 	}
-
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-
-	// Start the server
-	fmt.Printf("Listening on port %d\n", serverConfig.Port)
-	go func() {
-		var err error
-		if serverConfig.TLSCert != "" {
-			fmt.Println("TLS enabled")
-			err = httpServer.ListenAndServeTLS(serverConfig.TLSCert, serverConfig.TLSKey)
-		} else {
-			err = httpServer.ListenAndServe()
-		}
-		if err != nil && err != http.ErrServerClosed {
-			logger.Error().Msg(err.Error())
+	if storeConfig.EventsQueue != "" {
+		printf("Receiving store notifications from %s", storeConfig.EventsQueue)
+	}
+	if serverConfig.AdminToken != "" {
+		printf("Admin API enabled at /admin/")
+	}
+	if serverConfig.PolicyFile != "" {
+		printf("Enforcing access policy %s", serverConfig.PolicyFile)
+	}
+	if serverConfig.ReportWebhook != "" || serverConfig.ReportSMTPAddr != "" {
+		printf("Sending usage reports every %d hours", serverConfig.ReportIntervalHours)
+	}
+	if serverConfig.OTLPEndpoint != "" {
+		shutdown, err := setupTracing(serverConfig.OTLPEndpoint, serverConfig.OTLPInsecure)
+		if err != nil {
+			return err
 		}
-	}()
-
-	// Start the background vacuum
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	if !serverConfig.DisableAutoVacuum {
-		ticker := time.NewTicker(time.Minute * time.Duration(serverConfig.VacuumScheduleMinutes))
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					_, err := srv.StartVacuum(ctx, &pb.Empty{})
-					if err != nil {
-						logger.Error().Msg(err.Error())
-					}
-				}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Error().Msgf("shutting down tracing: %v", err)
 			}
 		}()
+		printf("Exporting traces to %s", serverConfig.OTLPEndpoint)
 	}
 
-	// Wait for a stop signal and then kill the vacuum process
-	<-done
-	cancel()
+	srvConfig := newServerConfig(&cfg)
+	if !serverConfig.DisableAutoVacuum {
+		srvConfig.VacuumInterval = time.Minute * time.Duration(serverConfig.VacuumScheduleMinutes)
+	}
 
-	// Allow the server to shutdown gracefully
-	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Minute)
+	// Stop the server on an interrupt signal
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		msg := fmt.Sprintf("server shutdown: %v", err)
-		logger.Error().Msg(msg)
-	}
-	fmt.Println("Server shutdown")
-	return nil
-}
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-done
+		printf("Shutting down. Send the signal again to exit immediately")
+		cancel()
+		<-done
+		printf("Exiting without waiting for requests to complete")
+		os.Exit(1)
+	}()
 
-// postHandler returns a http handler which returns a 500 error code unless invoked
-// through a POST request.
-func postHandler(handler http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		if req.Method != "POST" {
-			code := http.StatusMethodNotAllowed
-			http.Error(w, http.StatusText(code), code)
-			return
-		}
-		handler(w, req)
+	printf("Listening on port %d", serverConfig.Port)
+	if err := server.Run(ctx, srvConfig); err != nil {
+		return err
 	}
+	printf("Server shutdown")
+	return nil
 }
 
-// logHandler returns a http handler which logs the status code and execution time of
-// the request.
-func logHandler(handler http.HandlerFunc, name string) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		start := time.Now()
-		ww := &responseWriter{w, 0, ""}
-		handler(ww, req)
-		elapsedMillis := time.Since(start).Milliseconds()
-		reqID := xid.New().String()
-
-		w.Header().Set("x-jotfs-request-id", reqID)
-
-		rpcLogger := logger.With().
-			Str("method", name).
-			Int("status", ww.statusCode).
-			Int("elapsed", int(elapsedMillis)).
-			Str("id", reqID).
-			Logger()
-
-		if 200 <= ww.statusCode && ww.statusCode < 300 {
-			rpcLogger.Info().Msg("")
-		} else if 400 <= ww.statusCode && ww.statusCode < 500 {
-			rpcLogger.Warn().Msg(ww.errMsg)
-		} else {
-			rpcLogger.Error().Msg(ww.errMsg)
-		}
+// newServerConfig returns the configuration of the server described by c, which must
+// have been validated.
+func newServerConfig(c *config) server.Config {
+	conflicts, _ := c.Server.conflicts()
+	quotas, _ := c.Server.quotas()
+	jobs, _ := c.Server.jobs()
+	metadataRules, _ := c.Server.metadataRules()
+	return server.Config{
+		Database: c.Server.databasePath(),
+		Store: server.StoreConfig{
+			URL:        c.Store.URL,
+			Bucket:     c.Store.Bucket,
+			Endpoint:   c.Store.Endpoint,
+			Region:     c.Store.Region,
+			AccessKey:  c.Store.AccessKey,
+			SecretKey:  c.Store.SecretKey,
+			PathStyle:  c.Store.PathStyle,
+			DisableSSL: c.Store.DisableSSL,
+			RoleARN:    c.Store.RoleARN,
+			ExternalID: c.Store.ExternalID,
+
+			PartSize:          int64(c.Store.PartSizeMiB) * miB,
+			UploadConcurrency: int(c.Store.UploadConcurrency),
+		},
+		VersioningEnabled:     c.Server.VersioningEnabled,
+		AvgChunkSize:          c.Server.AvgChunkKiB * kiB,
+		ChunkAlgorithm:        c.Server.ChunkAlgorithm,
+		ChunkHash:             c.Server.ChunkHash,
+		PackfileSize:          uint64(c.Server.PackfileMiB) * miB,
+		MaxPackfileSize:       uint64(c.Server.MaxPackfileMiB) * miB,
+		PackfileFlushInterval: time.Second * time.Duration(c.Server.PackfileFlushSecs),
+		InlineSize:            uint64(c.Server.InlineKiB) * kiB,
+		DownloadTimeout:       time.Minute * time.Duration(c.Server.DLTimeoutMinutes),
+		EventsToken:           c.Store.EventsToken,
+		EventsQueue:           c.Store.EventsQueue,
+		CORSOrigins:           c.Server.corsOrigins(),
+		Checksums:             c.Server.checksums(),
+		IndexCacheDir:         c.Server.IndexCacheDir,
+		ChunkCacheDir:         c.Server.ChunkCacheDir,
+		ChunkCacheSize:        int64(c.Server.ChunkCacheMiB) * miB,
+		LocationCacheSize:     int(c.Server.LocationCacheSize),
+		ReadAhead:             int(c.Server.ReadAhead),
+		UploadQueueDir:        c.Server.UploadQueueDir,
+		UploadQueueSize:       int(c.Server.UploadQueueSize),
+		UploadQueueWorkers:    int(c.Server.UploadQueueWorkers),
+		MinFreeSpace:          int64(c.Server.MinFreeMiB) * miB,
+		MaxUploads:            int(c.Server.MaxUploads),
+		MaxUploadBytes:        uint64(c.Server.MaxUploadMiB) * miB,
+		MaxNameLength:         int(c.Server.NameMaxLength),
+		NamePattern:           c.Server.NamePattern,
+		NormalizeNames:        c.Server.NormalizeNames,
+		Conflicts:             conflicts,
+		Quotas:                quotas,
+		DefaultMetadata:       metadataRules,
+		SyncReplication:       splitList(c.Server.SyncReplication),
+		Replicas:              c.Server.replicas(),
+		ReplicaTimeout:        time.Second * time.Duration(c.Server.ReplicaTimeoutSecs),
+		AdminToken:            c.Server.AdminToken,
+		PolicyFile:            c.Server.PolicyFile,
+		StoreRetry: server.RetryConfig{
+			MaxAttempts: int(c.Store.RetryAttempts),
+			Budget:      float64(c.Store.RetryBudgetPercent) / 100,
+		},
+		StoreBreaker: server.BreakerConfig{
+			Threshold: int(c.Store.BreakerThreshold),
+			Cooldown:  time.Second * time.Duration(c.Store.BreakerCooldownSecs),
+		},
+		StoreWaitTimeout:    time.Second * time.Duration(c.Store.WaitTimeoutSeconds),
+		Logger:              &logger,
+		Addr:                fmt.Sprintf(":%d", c.Server.Port),
+		TLSCert:             c.Server.TLSCert,
+		TLSKey:              c.Server.TLSKey,
+		ShutdownTimeout:     time.Second * time.Duration(c.Server.ShutdownTimeoutSecs),
+		CacheTTL:            time.Second * time.Duration(c.Server.CacheTTLSecs),
+		OpLogInterval:       time.Second * time.Duration(c.Server.OpLogIntervalSecs),
+		DisableChunkFilter:  c.Server.DisableChunkFilter,
+		CheckpointSize:      int64(c.Server.CheckpointMiB) * miB,
+		CheckpointIdle:      time.Second * time.Duration(c.Server.CheckpointIdleSecs),
+		DatabaseBusyTimeout: time.Millisecond * time.Duration(c.Server.DBBusyTimeoutMS),
+		DatabaseSynchronous: c.Server.DBSynchronous,
+		DatabaseReaders:     int(c.Server.DBReaders),
+		ManualMigrations:    c.Server.ManualMigrations,
+		BackupInterval:      time.Hour * time.Duration(c.Server.BackupIntervalHours),
+		BackupRetain:        int(c.Server.BackupRetain),
+		Jobs:                jobs,
+		MaxJobs:             int(c.Server.MaxJobs),
+		Standby:             c.Server.Standby,
+		Build:               server.BuildInfo{Version: Version, BuildDate: BuildDate, CommitID: CommitID},
+		Report: server.ReportConfig{
+			Interval:     time.Hour * time.Duration(c.Server.ReportIntervalHours),
+			Tenant:       c.Server.ReportTenant,
+			WebhookURL:   c.Server.ReportWebhook,
+			SMTPAddr:     c.Server.ReportSMTPAddr,
+			SMTPUsername: c.Server.ReportSMTPUsername,
+			SMTPPassword: c.Server.ReportSMTPPassword,
+			From:         c.Server.ReportFrom,
+			To:           c.Server.reportTo(),
+		},
+		Shedding: server.ShedConfig{
+			MaxRequests:     int(c.Server.ShedMaxRequests),
+			QueueTimeout:    time.Millisecond * time.Duration(c.Server.ShedQueueMillis),
+			MaxHeapBytes:    uint64(c.Server.ShedMaxHeapMiB) * miB,
+			MaxStoreLatency: time.Millisecond * time.Duration(c.Server.ShedStoreMillis),
+		},
+		RateLimit: server.RateLimitConfig{
+			Requests:       float64(c.Server.RateRequests),
+			Bytes:          float64(c.Server.RateMiB) * miB,
+			ClientRequests: float64(c.Server.ClientRateRequests),
+			ClientBytes:    float64(c.Server.ClientRateMiB) * miB,
+		},
+		Throttle: server.ThrottleConfig{
+			UploadBytes:   float64(c.Server.MaxUploadMbps) * 1e6 / 8,
+			DownloadBytes: float64(c.Server.MaxDownloadMbps) * 1e6 / 8,
+		},
 	}
 }
 
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
-	errMsg     string
-}
-
-func (w *responseWriter) WriteHeader(statusCode int) {
-	w.statusCode = statusCode
-	w.ResponseWriter.WriteHeader(statusCode)
-}
-
-func (w *responseWriter) Write(p []byte) (int, error) {
-	if w.statusCode >= 400 {
-		w.errMsg = string(p)
+// redactURL removes the password, if any, from a URL so it may be logged.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
 	}
-	return w.ResponseWriter.Write(p)
+	u.User = url.User(u.User.Username())
+	return u.String()
 }
 
 func main() {
-	err := run()
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		err = runAdmin(os.Args[2:])
+	} else {
+		err = run()
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if jsonErrors {
+			logger.Error().Msg(err.Error())
+		} else {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
 		os.Exit(1)
 	}
 	os.Exit(0)
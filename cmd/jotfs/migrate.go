@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jotfs/jotfs/server"
+)
+
+// migrateStore copies the objects in the configured store to another store.
+func migrateStore(args []string, w io.Writer) error {
+	var dstURL string
+	var updateETags, verbose bool
+	fs := flag.NewFlagSet("migrate-store", flag.ContinueOnError)
+	fs.StringVar(&dstURL, "dst_url", "", "URL of the store to copy the objects to, e.g. s3://bucket?region=us-east-1")
+	fs.BoolVar(&updateETags, "update_etags", false, "record the ETags of the packfiles in the destination store. Use with the server stopped, before switching it to the destination store")
+	fs.BoolVar(&verbose, "v", false, "output the key of each object")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dstURL == "" {
+		return requiredFlagError("dst_url")
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	opts := &server.MigrateOpts{
+		UpdateETags: updateETags,
+		Progress: func(key string, action string) {
+			if verbose || action == "missing" {
+				fmt.Fprintf(w, "%s %s\n", action, key)
+			}
+		},
+	}
+	result, err := s.srv.MigrateStore(context.Background(), server.StoreConfig{URL: dstURL}, opts)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Copied %d objects (%d bytes). %d already copied, %d missing from the source store\n",
+		result.Copied, result.Bytes, result.Skipped, result.Missing)
+	return nil
+}
+
+// migrateDB applies the pending schema migrations to the database.
+func migrateDB(args []string, w io.Writer) error {
+	var dryRun bool
+	fs := flag.NewFlagSet("migrate-db", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry_run", false, "list the migrations without applying any")
+	load := localConfigFlags(fs, false)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := server.MigrateDatabase(newServerConfig(cfg), &server.MigrateDatabaseOpts{DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		status := "pending"
+		if m.Applied && m.AppliedAt.IsZero() {
+			status = "applied"
+		} else if m.Applied {
+			status = "applied " + m.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%03d_%s %s\n", m.Version, m.Name, status)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// rechunk chunks the files under a prefix again with the chunking parameters which now
+// apply to them, with the server stopped.
+func rechunk(args []string, w io.Writer) error {
+	var prefix string
+	fs := flag.NewFlagSet("rechunk", flag.ContinueOnError)
+	fs.StringVar(&prefix, "prefix", "/", "directory of the files to rechunk")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.srv.Rechunk(context.Background(), prefix)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Rechunked %d of %d file versions, saving %d new chunks of %d bytes in %d packfiles\n",
+		res.Rechunked, res.Checked, res.Chunks, res.Bytes, res.Packs)
+	if res.Skipped > 0 {
+		fmt.Fprintf(w, "%d file versions were deleted while they were rechunked\n", res.Skipped)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/propagators"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+)
+
+const serviceName = "jotfs"
+
+// setupTracing registers a global tracer provider which exports spans to the OTLP
+// collector at endpoint. It returns a function which flushes any buffered spans and
+// closes the connection to the collector.
+func setupTracing(endpoint string, insecure bool) (func(context.Context) error, error) {
+	opts := []otlp.ExporterOption{otlp.WithAddress(endpoint)}
+	if insecure {
+		opts = append(opts, otlp.WithInsecure())
+	}
+	exp, err := otlp.NewExporter(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+
+	bsp := sdktrace.NewBatchSpanProcessor(exp)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(bsp),
+		sdktrace.WithResource(resource.New(semconv.ServiceNameKey.String(serviceName))),
+	)
+	global.SetTracerProvider(provider)
+	global.SetTextMapPropagator(otel.NewCompositeTextMapPropagator(propagators.TraceContext{}, propagators.Baggage{}))
+
+	shutdown := func(ctx context.Context) error {
+		bsp.Shutdown()
+		return exp.Shutdown(ctx)
+	}
+	return shutdown, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// defaultTuneSizes are the average chunk sizes, in KiB, compared by tune if
+// -chunk_sizes is not set.
+var defaultTuneSizes = []uint{64, 128, 256, 512, 1024, 2048, 4096}
+
+// tuneNormalization is the chunker normalization used by the server for new buckets.
+const tuneNormalization = 2
+
+// tuneResult is the outcome of chunking the sample data with one chunk size.
+type tuneResult struct {
+	AvgChunkKiB  uint
+	TotalSize    uint64
+	NumChunks    uint64
+	UniqueChunks uint64
+	UniqueSize   uint64
+}
+
+// dedupRatio returns the ratio of the size of the sample data to the size of its
+// unique chunks.
+func (r tuneResult) dedupRatio() float64 {
+	if r.UniqueSize == 0 {
+		return 0
+	}
+	return float64(r.TotalSize) / float64(r.UniqueSize)
+}
+
+// tune chunks every file in a sample directory with each of a set of average chunk
+// sizes, and writes a table comparing the number of chunks and projected dedup ratio
+// for each size to w. Chunk data is not compressed, so the projected stored sizes are
+// an upper bound.
+func tune(args []string, w io.Writer) error {
+	var sampleDir string
+	var sizes string
+	var algorithm string
+	fs := flag.NewFlagSet("tune", flag.ContinueOnError)
+	fs.StringVar(&sampleDir, "sample_dir", "", "directory of sample data, representative of the files to be stored")
+	fs.StringVar(&sizes, "chunk_sizes", "", "comma-separated average chunk sizes to compare, in KiB")
+	fs.StringVar(&algorithm, "algorithm", "fastcdc", "chunking algorithm: fastcdc, rabin or fixed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if sampleDir == "" {
+		return requiredFlagError("sample_dir")
+	}
+	alg, err := chunker.ParseAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	avgs := defaultTuneSizes
+	if sizes != "" {
+		if avgs, err = parseChunkSizes(sizes); err != nil {
+			return err
+		}
+	}
+
+	paths, err := sampleFiles(sampleDir)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no files found in %s", sampleDir)
+	}
+
+	results := make([]tuneResult, len(avgs))
+	for i, avg := range avgs {
+		if results[i], err = tuneChunkSize(paths, avg, alg); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "%d files, %d bytes\n\n", len(paths), results[0].TotalSize)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "CHUNK_SIZE_KIB\tCHUNKS\tUNIQUE_CHUNKS\tSTORED_BYTES\tDEDUP_RATIO\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%.2f\t\n", r.AvgChunkKiB, r.NumChunks, r.UniqueChunks, r.UniqueSize, r.dedupRatio())
+	}
+	return tw.Flush()
+}
+
+// parseChunkSizes parses a comma-separated list of average chunk sizes in KiB.
+func parseChunkSizes(s string) ([]uint, error) {
+	var avgs []uint
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(field), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q", field)
+		}
+		if n < minAvgKib || n > maxAvgKib {
+			return nil, fmt.Errorf("chunk size %d must be in range %d to %d", n, minAvgKib, maxAvgKib)
+		}
+		avgs = append(avgs, uint(n))
+	}
+	sort.Slice(avgs, func(i, j int) bool { return avgs[i] < avgs[j] })
+	return avgs, nil
+}
+
+// sampleFiles returns the paths of all regular files under dir.
+func sampleFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// tuneChunkSize chunks the files using the parameters the server would choose for
+// the given average chunk size and algorithm.
+func tuneChunkSize(paths []string, avgKiB uint, alg chunker.Algorithm) (tuneResult, error) {
+	avg := int(avgKiB * kiB)
+	opts := chunker.Options{
+		MinChunkSize:  avg / 4,
+		AvgChunkSize:  avg,
+		MaxChunkSize:  avg * 4,
+		Normalization: tuneNormalization,
+		Algorithm:     alg,
+	}
+	res := tuneResult{AvgChunkKiB: avgKiB}
+	seen := make(map[sum.Sum]bool)
+	for _, path := range paths {
+		if err := chunkFile(path, opts, func(data []byte) {
+			res.TotalSize += uint64(len(data))
+			res.NumChunks++
+			s := sum.Compute(data)
+			if !seen[s] {
+				seen[s] = true
+				res.UniqueChunks++
+				res.UniqueSize += uint64(len(data))
+			}
+		}); err != nil {
+			return tuneResult{}, err
+		}
+	}
+	return res, nil
+}
+
+func chunkFile(path string, opts chunker.Options, f func(data []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	ck, err := chunker.New(file, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := ck.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("chunking %s: %w", path, err)
+		}
+		f(chunk.Data)
+	}
+}
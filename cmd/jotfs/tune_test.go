@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+)
+
+func TestTune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Two copies of the same data, the second with a modified prefix
+	data := make([]byte, 4*1024*kiB)
+	rand.New(rand.NewSource(1)).Read(data)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), data, 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	copy(data, "modified")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "b"), data, 0644))
+
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")}
+	res, err := tuneChunkSize(paths, 64, chunker.FastCDC)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2*len(data)), res.TotalSize)
+	assert.Less(t, res.UniqueChunks, res.NumChunks)
+	assert.Greater(t, res.dedupRatio(), 1.8)
+
+	// The modified prefix is the same length, so fixed size chunks dedup too
+	res, err = tuneChunkSize(paths, 64, chunker.Fixed)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2*len(data)/(64*kiB)), res.NumChunks)
+	assert.Greater(t, res.dedupRatio(), 1.8)
+
+	var out bytes.Buffer
+	assert.NoError(t, tune([]string{"-sample_dir=" + dir, "-chunk_sizes=256,64"}, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 5) {
+		assert.Equal(t, "2 files, 8388608 bytes", lines[0])
+		assert.Contains(t, lines[2], "CHUNK_SIZE_KIB")
+		assert.True(t, strings.HasPrefix(strings.TrimSpace(lines[3]), "64 "))
+		assert.True(t, strings.HasPrefix(strings.TrimSpace(lines[4]), "256 "))
+	}
+
+	// Errors
+	assert.Error(t, tune(nil, &out))
+	assert.Error(t, tune([]string{"-sample_dir=" + dir, "-chunk_sizes=1"}, &out))
+	assert.Error(t, tune([]string{"-sample_dir=" + dir, "-chunk_sizes=abc"}, &out))
+	assert.Error(t, tune([]string{"-sample_dir=" + dir, "-algorithm=cdc"}, &out))
+	assert.Error(t, tune([]string{"-sample_dir=" + filepath.Join(dir, "missing")}, &out))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// vacuumReport prints what a vacuum would reclaim, without changing anything.
+func vacuumReport(args []string, w io.Writer) error {
+	var files uint
+	var verbose bool
+	fs := flag.NewFlagSet("vacuum-report", flag.ContinueOnError)
+	fs.UintVar(&files, "files", 10, "maximum number of files listed for each packfile rebuilt")
+	fs.BoolVar(&verbose, "v", false, "list each packfile deleted or rebuilt, and the files with data in the rebuilt packfiles")
+	open := localServerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	s, err := open()
+	if err != nil {
+		return err
+	}
+
+	report, err := s.srv.VacuumDryRun(context.Background(), int(files))
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if verbose {
+		for _, p := range report.Packs {
+			action := "delete"
+			if p.Rebuilt {
+				action = "rebuild"
+			}
+			fmt.Fprintf(w, "%s %s: %d of %d chunks, %d bytes\n", action, p.Sum, p.Chunks, p.TotalChunks, p.Bytes)
+			for _, name := range p.Files {
+				fmt.Fprintf(w, "  %s\n", name)
+			}
+		}
+	}
+	fmt.Fprintf(w, "A vacuum would delete %d packfiles and rebuild %d, removing %d chunks and reclaiming %d bytes\n",
+		report.DeletedPacks, report.RebuiltPacks, report.Chunks, report.Bytes)
+	return nil
+}
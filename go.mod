@@ -1,19 +1,25 @@
 module github.com/jotfs/jotfs
 
-go 1.14
+go 1.16
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/DataDog/zstd v1.4.5
 	github.com/aws/aws-sdk-go v1.30.12
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/golang/protobuf v1.4.0-rc.4.0.20200313231945-b860323f09d0
+	github.com/golang/protobuf v1.4.2
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
+	github.com/oklog/ulid v1.3.1
 	github.com/rs/xid v1.2.1
 	github.com/rs/zerolog v1.19.0
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.6.1
 	github.com/twitchtv/twirp v5.10.1+incompatible
 	github.com/zeebo/blake3 v0.0.3
+	go.opentelemetry.io/otel v0.13.0
+	go.opentelemetry.io/otel/exporters/otlp v0.13.0
+	go.opentelemetry.io/otel/sdk v0.13.0
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
 	golang.org/x/sys v0.0.0-20200519105757-fe76b779f299 // indirect
-	google.golang.org/protobuf v1.20.1-0.20200309200217-e05f789c0967
+	golang.org/x/text v0.3.0
+	google.golang.org/protobuf v1.23.0
 )
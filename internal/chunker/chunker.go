@@ -0,0 +1,231 @@
+// Package chunker splits a stream of data into chunks. Chunks are content-defined,
+// using the FastCDC or Rabin algorithm, or of a fixed size.
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Algorithm is a chunking algorithm.
+type Algorithm string
+
+const (
+	// FastCDC chunks data with the FastCDC algorithm, which finds chunk boundaries
+	// with a gear hash. It's the default algorithm.
+	FastCDC Algorithm = "fastcdc"
+	// Rabin chunks data with Rabin fingerprints of a sliding window of the data.
+	Rabin Algorithm = "rabin"
+	// Fixed splits data into chunks of AvgChunkSize bytes. It's cheaper than content
+	// defined chunking, and as effective for data which doesn't deduplicate, such as
+	// compressed or encrypted data, but inserting data in a stream changes every chunk
+	// after it.
+	Fixed Algorithm = "fixed"
+)
+
+// ParseAlgorithm returns the algorithm with the given name. The empty name is
+// FastCDC.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch a := Algorithm(name); a {
+	case "":
+		return FastCDC, nil
+	case FastCDC, Rabin, Fixed:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown chunking algorithm %q", name)
+}
+
+// Options configures a Chunker.
+type Options struct {
+	// MinChunkSize is the minimum size of a chunk in bytes. The final chunk in a stream
+	// may be smaller than this value.
+	MinChunkSize int
+	// AvgChunkSize is the target average size of a chunk in bytes.
+	AvgChunkSize int
+	// MaxChunkSize is the maximum size of a chunk in bytes.
+	MaxChunkSize int
+	// Normalization controls how tightly chunk sizes are distributed around the
+	// average. Higher values give a narrower distribution. Not used by the Fixed
+	// algorithm.
+	Normalization int
+	// Algorithm is the chunking algorithm. Defaults to FastCDC if empty.
+	Algorithm Algorithm
+}
+
+// Validate returns an error if the options are invalid.
+func (opts Options) Validate() error {
+	if _, err := ParseAlgorithm(string(opts.Algorithm)); err != nil {
+		return err
+	}
+	if opts.MinChunkSize <= 0 {
+		return errors.New("min chunk size must be positive")
+	}
+	if opts.AvgChunkSize <= opts.MinChunkSize || opts.AvgChunkSize >= opts.MaxChunkSize {
+		return errors.New("avg chunk size must be between min and max chunk size")
+	}
+	bits := log2(opts.AvgChunkSize)
+	if opts.Normalization < 0 || opts.Normalization >= bits {
+		return fmt.Errorf("normalization must be in range 0 to %d", bits-1)
+	}
+	return nil
+}
+
+// Chunk is a contiguous section of data in a stream.
+type Chunk struct {
+	// Offset is the byte-offset of the chunk within the stream.
+	Offset uint64
+	// Data holds the contents of the chunk. It is only valid until the next call to
+	// Next.
+	Data []byte
+}
+
+// Chunker splits data read from an io.Reader into chunks.
+type Chunker struct {
+	r     io.Reader
+	opts  Options
+	maskS uint64
+	maskL uint64
+	// rabinS and rabinL are the masks of the low bits of a Rabin fingerprint used in
+	// place of maskS and maskL
+	rabinS uint64
+	rabinL uint64
+	buf    []byte
+	cursor int
+	end    int
+	offset uint64
+	eof    bool
+}
+
+// New returns a new Chunker reading from r.
+func New(r io.Reader, opts Options) (*Chunker, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	bits := log2(opts.AvgChunkSize)
+	return &Chunker{
+		r:      r,
+		opts:   opts,
+		maskS:  mask(bits + opts.Normalization),
+		maskL:  mask(bits - opts.Normalization),
+		rabinS: lowMask(bits + opts.Normalization),
+		rabinL: lowMask(bits - opts.Normalization),
+		buf:    make([]byte, 2*opts.MaxChunkSize),
+	}, nil
+}
+
+// Next returns the next chunk in the stream. Returns io.EOF when no data remains.
+func (c *Chunker) Next() (Chunk, error) {
+	if err := c.fill(); err != nil {
+		return Chunk{}, err
+	}
+	if c.cursor == c.end {
+		return Chunk{}, io.EOF
+	}
+
+	n := c.nextBoundary(c.buf[c.cursor:c.end])
+	chunk := Chunk{Offset: c.offset, Data: c.buf[c.cursor : c.cursor+n]}
+	c.cursor += n
+	c.offset += uint64(n)
+	return chunk, nil
+}
+
+// fill ensures the buffer holds at least MaxChunkSize bytes, unless the end of the
+// stream has been reached.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.cursor >= c.opts.MaxChunkSize {
+		return nil
+	}
+	// Move the remaining data to the start of the buffer
+	copy(c.buf, c.buf[c.cursor:c.end])
+	c.end -= c.cursor
+	c.cursor = 0
+
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextBoundary returns the size of the next chunk in b.
+func (c *Chunker) nextBoundary(b []byte) int {
+	n := len(b)
+	if n <= c.opts.MinChunkSize {
+		return n
+	}
+	if n > c.opts.MaxChunkSize {
+		n = c.opts.MaxChunkSize
+	}
+	mid := c.opts.AvgChunkSize
+	if n < mid {
+		mid = n
+	}
+	switch c.opts.Algorithm {
+	case Fixed:
+		return mid
+	case Rabin:
+		return c.rabinBoundary(b[:n], mid)
+	}
+
+	var fp uint64
+	i := c.opts.MinChunkSize
+	for ; i < mid; i++ {
+		fp = (fp << 1) + gear[b[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[b[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// log2 returns the base-2 logarithm of n, rounded down.
+func log2(n int) int {
+	return bits.Len(uint(n)) - 1
+}
+
+// mask returns a mask with the n most significant bits set.
+func mask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// lowMask returns a mask with the n least significant bits set.
+func lowMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) >> (64 - n)
+}
+
+// gear is a table of random 64-bit values indexed by byte value. It's generated
+// deterministically so chunk boundaries are stable across processes.
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	x := uint64(0x6a09e667f3bcc908)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
@@ -0,0 +1,134 @@
+package chunker
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOpts = Options{
+	MinChunkSize:  1024,
+	AvgChunkSize:  4096,
+	MaxChunkSize:  16384,
+	Normalization: 2,
+}
+
+func TestChunker(t *testing.T) {
+	data := randomData(1278, 1024*1024)
+
+	c, err := New(bytes.NewReader(data), testOpts)
+	assert.NoError(t, err)
+
+	var sizes []int
+	buf := new(bytes.Buffer)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, uint64(buf.Len()), chunk.Offset)
+		buf.Write(chunk.Data)
+		sizes = append(sizes, len(chunk.Data))
+	}
+
+	// The chunks should reconstruct the original data
+	assert.Equal(t, data, buf.Bytes())
+
+	// All chunks, except for the last, should be within the size bounds
+	for _, size := range sizes[:len(sizes)-1] {
+		assert.GreaterOrEqual(t, size, testOpts.MinChunkSize)
+		assert.LessOrEqual(t, size, testOpts.MaxChunkSize)
+	}
+}
+
+func TestChunkerDeterministic(t *testing.T) {
+	data := randomData(2020, 256*1024)
+	a := chunkSizes(t, data, testOpts)
+	b := chunkSizes(t, data, testOpts)
+	assert.Equal(t, a, b)
+
+	// Inserting data at the start should only affect the chunk boundaries near the
+	// start of the stream
+	shifted := append(randomData(1, 100), data...)
+	c := chunkSizes(t, shifted, testOpts)
+	assert.Equal(t, a[len(a)-5:], c[len(c)-5:])
+}
+
+func TestChunkerAlgorithms(t *testing.T) {
+	data := randomData(339, 512*1024)
+	shifted := append(randomData(1, 100), data...)
+
+	rabin := testOpts
+	rabin.Algorithm = Rabin
+	a := chunkSizes(t, data, rabin)
+	assert.Equal(t, a, chunkSizes(t, data, rabin))
+	for _, size := range a[:len(a)-1] {
+		assert.GreaterOrEqual(t, size, rabin.MinChunkSize)
+		assert.LessOrEqual(t, size, rabin.MaxChunkSize)
+	}
+	assert.NotEqual(t, a, chunkSizes(t, data, testOpts))
+	c := chunkSizes(t, shifted, rabin)
+	assert.Equal(t, a[len(a)-5:], c[len(c)-5:])
+
+	// Fixed size chunks are all the average size, except the last
+	fixed := testOpts
+	fixed.Algorithm = Fixed
+	a = chunkSizes(t, data[:len(data)-10], fixed)
+	assert.Len(t, a, len(data)/fixed.AvgChunkSize)
+	for _, size := range a[:len(a)-1] {
+		assert.Equal(t, fixed.AvgChunkSize, size)
+	}
+	assert.Equal(t, fixed.AvgChunkSize-10, a[len(a)-1])
+}
+
+func TestChunkerEmpty(t *testing.T) {
+	c, err := New(bytes.NewReader(nil), testOpts)
+	assert.NoError(t, err)
+	_, err = c.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	bad := []Options{
+		{0, 4096, 16384, 2, FastCDC},
+		{1024, 1024, 16384, 2, FastCDC},
+		{1024, 4096, 4096, 2, Rabin},
+		{1024, 4096, 16384, 12, FastCDC},
+		{1024, 4096, 16384, 2, "cdc"},
+	}
+	for i, opts := range bad {
+		_, err := New(bytes.NewReader(nil), opts)
+		assert.Error(t, err, i)
+	}
+}
+
+func chunkSizes(t *testing.T, data []byte, opts Options) []int {
+	c, err := New(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return sizes
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(chunk.Data))
+	}
+}
+
+func randomData(seed int64, size int) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	b := make([]byte, size)
+	rng.Read(b)
+	return b
+}
@@ -0,0 +1,93 @@
+package chunker
+
+import "math/bits"
+
+const (
+	// rabinPoly is the irreducible polynomial, of degree 53, used to compute Rabin
+	// fingerprints.
+	rabinPoly uint64 = 0x3DA3358B4DC173
+	// rabinWindow is the size of the sliding window fingerprinted at each byte.
+	rabinWindow = 64
+)
+
+// rabinDegree is the degree of rabinPoly, and rabinShift the shift which gives the
+// top byte of a fingerprint.
+var (
+	rabinDegree = polyDegree(rabinPoly)
+	rabinShift  = rabinDegree - 8
+)
+
+// rabinOut holds the fingerprint of each byte value followed by rabinWindow-1 zero
+// bytes, which removes the byte leaving the window from a fingerprint. rabinMod holds
+// the reduction of each top byte of a fingerprint.
+var rabinOut, rabinMod [256]uint64
+
+func init() {
+	for b := range rabinOut {
+		h := rabinAppend(0, byte(b))
+		for i := 0; i < rabinWindow-1; i++ {
+			h = rabinAppend(h, 0)
+		}
+		rabinOut[b] = h
+	}
+	for b := range rabinMod {
+		x := uint64(b) << rabinDegree
+		rabinMod[b] = polyMod(x, rabinPoly) | x
+	}
+}
+
+// rabinBoundary returns the size of the next chunk in b, using a Rabin fingerprint of
+// the rabinWindow bytes up to each position. As with FastCDC, the boundary condition
+// is stricter before mid.
+func (c *Chunker) rabinBoundary(b []byte, mid int) int {
+	n := len(b)
+	// Fingerprint the window before the minimum chunk size, so every fingerprint
+	// checked covers a full window
+	start := c.opts.MinChunkSize - rabinWindow
+	if start < 0 {
+		start = 0
+	}
+	var fp uint64
+	for i := start; i < n; i++ {
+		if i-start >= rabinWindow {
+			fp ^= rabinOut[b[i-rabinWindow]]
+		}
+		fp = rabinSlide(fp, b[i])
+		if i < c.opts.MinChunkSize {
+			continue
+		}
+		mask := c.rabinL
+		if i < mid {
+			mask = c.rabinS
+		}
+		if fp&mask == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// rabinSlide appends b to the fingerprint fp using the reduction table.
+func rabinSlide(fp uint64, b byte) uint64 {
+	top := fp >> rabinShift
+	return (fp<<8 | uint64(b)) ^ rabinMod[top]
+}
+
+// rabinAppend appends b to the fingerprint h by polynomial division.
+func rabinAppend(h uint64, b byte) uint64 {
+	return polyMod(h<<8|uint64(b), rabinPoly)
+}
+
+// polyDegree returns the degree of the polynomial p over GF(2).
+func polyDegree(p uint64) int {
+	return bits.Len64(p) - 1
+}
+
+// polyMod returns the remainder of dividing x by p over GF(2).
+func polyMod(x uint64, p uint64) uint64 {
+	d := polyDegree(p)
+	for polyDegree(x) >= d {
+		x ^= p << uint(polyDegree(x)-d)
+	}
+	return x
+}
@@ -1,14 +1,19 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jotfs/jotfs/internal/compress"
+	"github.com/jotfs/jotfs/internal/id"
 	"github.com/jotfs/jotfs/internal/object"
 	"github.com/jotfs/jotfs/internal/sum"
 	"github.com/rs/xid"
@@ -39,27 +44,101 @@ func (s VacuumStatus) String() string {
 
 // Adapter interfaces with the database.
 type Adapter struct {
+	// writes is the number of committed updates, and lastWrite the time of the last,
+	// in Unix nanoseconds. Both are accessed atomically, so they come first to be
+	// 64-bit aligned.
+	writes    uint64
+	lastWrite int64
+
 	mut sync.Mutex
 	db  *sql.DB
+	// writer is the connection used for updates if it is separate from the pool used
+	// for reads, or nil otherwise.
+	writer *sql.DB
+	ids    id.Generator
+	// oplog is true if changes are recorded in the operation log.
+	oplog bool
+
+	statsMu     sync.Mutex
+	checkpoints CheckpointStats
+
+	// filter is nil if the chunk filter is disabled
+	filter *chunkFilter
 }
 
-// NewAdapter returns a new database adapter.
+// NewAdapter returns a new database adapter. Identifiers for packfiles and file
+// versions are ULIDs by default.
 func NewAdapter(db *sql.DB) *Adapter {
-	return &Adapter{sync.Mutex{}, db}
+	return &Adapter{db: db, ids: id.NewULIDGenerator()}
+}
+
+// SetIDGenerator sets the generator used to create identifiers for new packfiles and
+// file versions.
+func (a *Adapter) SetIDGenerator(g id.Generator) {
+	a.ids = g
 }
 
-// InitSchema creates the tables for a new database.
-func (a *Adapter) InitSchema() error {
-	_, err := a.db.Exec(Q_000_Base)
+// Close closes the underlying database connections.
+func (a *Adapter) Close() error {
+	err := a.db.Close()
+	if a.writer != nil {
+		if werr := a.writer.Close(); err == nil {
+			err = werr
+		}
+	}
 	return err
 }
 
+// Ping checks that the database can be queried.
+func (a *Adapter) Ping(ctx context.Context) error {
+	var one int
+	return a.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// backfillIDs assigns a legacy identifier to every packfile and file version created
+// before identifiers were introduced.
+func backfillIDs(tx *sql.Tx) error {
+	for _, table := range []string{"packs", "file_versions"} {
+		rows, err := tx.Query(fmt.Sprintf("SELECT id, created_at, sum FROM %s WHERE uid = ''", table))
+		if err != nil {
+			return err
+		}
+		uids := make(map[int64]string)
+		for rows.Next() {
+			var rowID, createdAt int64
+			var s []byte
+			if err := rows.Scan(&rowID, &createdAt, &s); err != nil {
+				rows.Close()
+				return err
+			}
+			uids[rowID] = id.Legacy(time.Unix(0, createdAt), s)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return err
+		}
+		rows.Close()
+
+		q := fmt.Sprintf("UPDATE %s SET uid = ? WHERE id = ?", table)
+		for rowID, uid := range uids {
+			if _, err := tx.Exec(q, uid, rowID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // update accepts a function which may modify the database in a transaction. It cancels
 // the transaction if the function returns an error, or commits the transaction otherwise.
 func (a *Adapter) update(f func(tx *sql.Tx) error) error {
 	a.mut.Lock()
 	defer a.mut.Unlock()
-	tx, err := a.db.Begin()
+	w := a.db
+	if a.writer != nil {
+		w = a.writer
+	}
+	tx, err := w.Begin()
 	if err != nil {
 		return err
 	}
@@ -70,14 +149,19 @@ func (a *Adapter) update(f func(tx *sql.Tx) error) error {
 		}
 		return err
 	}
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	atomic.AddUint64(&a.writes, 1)
+	atomic.StoreInt64(&a.lastWrite, time.Now().UnixNano())
+	return nil
 }
 
 // GetFileInfo returns the FileInfo for a given file version. Returns ErrNotFound if the
 // file does not exist.
 func (a *Adapter) GetFileInfo(s sum.Sum) (FileInfo, error) {
 	q := `
-	SELECT name, created_at, size, versioned
+	SELECT name, created_at, size, versioned, uid, file_versions.id
 	FROM file_versions JOIN files on files.id = file_versions.file 
 	WHERE sum = ?
 	`
@@ -86,7 +170,9 @@ func (a *Adapter) GetFileInfo(s sum.Sum) (FileInfo, error) {
 	var createdAt int64
 	var size uint64
 	var vflag int
-	if err := row.Scan(&name, &createdAt, &size, &vflag); err == sql.ErrNoRows {
+	var uid string
+	var verID int64
+	if err := row.Scan(&name, &createdAt, &size, &vflag, &uid, &verID); err == sql.ErrNoRows {
 		return FileInfo{}, ErrNotFound
 	} else if err != nil {
 		return FileInfo{}, err
@@ -96,6 +182,10 @@ func (a *Adapter) GetFileInfo(s sum.Sum) (FileInfo, error) {
 	if err != nil {
 		return FileInfo{}, err
 	}
+	metadata, err := getMetadata(a.db, []int64{verID})
+	if err != nil {
+		return FileInfo{}, err
+	}
 
 	return FileInfo{
 		Name:      name,
@@ -103,6 +193,8 @@ func (a *Adapter) GetFileInfo(s sum.Sum) (FileInfo, error) {
 		Size:      size,
 		Sum:       s,
 		Versioned: versioned,
+		VersionID: uid,
+		Metadata:  metadata[verID],
 	}, nil
 }
 
@@ -123,13 +215,32 @@ func (a *Adapter) ChunksExist(sums []sum.Sum) ([]bool, error) {
 	if len(sums) == 0 {
 		return nil, nil
 	}
+	result := make([]bool, len(sums))
+
+	// Only the chunks which may be in the chunk filter are looked up in the database
+	lookup := sums
+	f, err := a.chunkFilter()
+	if err != nil {
+		return nil, fmt.Errorf("chunk filter: %w", err)
+	}
+	if f != nil {
+		lookup = make([]sum.Sum, 0)
+		for _, i := range f.candidates(sums) {
+			lookup = append(lookup, sums[i])
+		}
+		if len(lookup) == 0 {
+			return result, nil
+		}
+	}
+
 	q := fmt.Sprintf(
-		"SELECT DISTINCT sum FROM indexes WHERE sum IN (%s) AND delete_marker <> 1",
-		strings.Repeat("?, ", len(sums)-1)+"?",
+		`SELECT DISTINCT indexes.sum FROM indexes JOIN packs ON packs.id = indexes.pack
+		WHERE indexes.sum IN (%s) AND indexes.delete_marker <> 1 AND packs.degraded_at = 0`,
+		strings.Repeat("?, ", len(lookup)-1)+"?",
 	)
-	args := make([]interface{}, len(sums))
-	for i := range sums {
-		args[i] = sums[i][:]
+	args := make([]interface{}, len(lookup))
+	for i := range lookup {
+		args[i] = lookup[i][:]
 	}
 	rows, err := a.db.Query(q, args...)
 	if err != nil {
@@ -153,11 +264,13 @@ func (a *Adapter) ChunksExist(sums []sum.Sum) ([]bool, error) {
 		return nil, err
 	}
 
-	result := make([]bool, len(sums))
 	for i, s := range sums {
 		_, ok := exists[s]
 		result[i] = ok
 	}
+	if f != nil {
+		f.falsePositives(len(lookup) - len(exists))
+	}
 
 	return result, nil
 }
@@ -165,6 +278,59 @@ func (a *Adapter) ChunksExist(sums []sum.Sum) ([]bool, error) {
 // ErrNotFound is returned when a row does not exist.
 var ErrNotFound = errors.New("not found")
 
+// ErrExists is returned when a row cannot be created or renamed because another row
+// with the same name exists.
+var ErrExists = errors.New("already exists")
+
+// ErrConflict is returned when a file cannot be changed because a Precondition on its
+// latest version does not hold.
+var ErrConflict = errors.New("precondition failed")
+
+// Precondition is a condition on the latest version of a file which must hold for the
+// file to be changed. The zero value always holds.
+type Precondition struct {
+	// IfMatch, if not nil, is the sum of the expected latest version of the file.
+	IfMatch *sum.Sum
+	// IfNotExists requires the file to have no versions.
+	IfNotExists bool
+}
+
+// checkPrecondition returns ErrConflict if cond does not hold for the file name.
+func checkPrecondition(tx *sql.Tx, name string, cond Precondition) error {
+	if cond.IfMatch == nil && !cond.IfNotExists {
+		return nil
+	}
+	q := `
+	SELECT file_versions.sum
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	WHERE name = ?
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+	var b []byte
+	err := tx.QueryRow(q, name).Scan(&b)
+	if err == sql.ErrNoRows {
+		if cond.IfMatch != nil {
+			return ErrConflict
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting latest version of %s: %w", name, err)
+	}
+	if cond.IfNotExists {
+		return ErrConflict
+	}
+	latest, err := sum.FromBytes(b)
+	if err != nil {
+		return err
+	}
+	if latest != *cond.IfMatch {
+		return ErrConflict
+	}
+	return nil
+}
+
 // GetChunkSize gets the size of a chunk. Returns ErrNotFound if the chunk does not exist.
 func (a *Adapter) GetChunkSize(s sum.Sum) (uint64, error) {
 	q := "SELECT chunk_size FROM indexes WHERE sum = ?"
@@ -183,38 +349,450 @@ func (a *Adapter) InsertPackIndex(index object.PackIndex, createdAt time.Time) e
 	if len(index.Blocks) == 0 {
 		return fmt.Errorf("pack index is empty")
 	}
+	uid, err := a.ids.New(createdAt)
+	if err != nil {
+		return fmt.Errorf("generating packfile ID: %w", err)
+	}
 	return a.update(func(tx *sql.Tx) error {
-		packID, err := insertPackfile(tx, index, createdAt)
-		if err != nil {
-			return fmt.Errorf("inserting packfile: %w", err)
+		if _, err := insertPackIndex(tx, uid, index, createdAt); err != nil {
+			return err
+		}
+		a.addBlocks(index.Blocks)
+		return a.logOp(tx, op{Type: opInsertPack, UID: uid, Time: createdAt.UnixNano(), Data: index.MarshalBinary()})
+	})
+}
+
+// InsertPackIndexOnce inserts a pack index, as with InsertPackIndex, and records the
+// packfile in the upload journal under an idempotency key. If the journal already
+// has an entry for key and the same packfile, the index is not inserted and inserted
+// is false.
+func (a *Adapter) InsertPackIndexOnce(index object.PackIndex, createdAt time.Time, key string) (inserted bool, err error) {
+	if len(index.Blocks) == 0 {
+		return false, fmt.Errorf("pack index is empty")
+	}
+	uid, err := a.ids.New(createdAt)
+	if err != nil {
+		return false, fmt.Errorf("generating packfile ID: %w", err)
+	}
+	err = a.update(func(tx *sql.Tx) error {
+		err := scanJournalledPack(tx.QueryRow(selectJournalledPack, key, index.Sum[:]))
+		if err == nil {
+			return nil
 		}
-		err = insertPackBlocks(tx, packID, index.Blocks)
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("reading upload journal: %w", err)
+		}
+		packID, err := insertPackIndex(tx, uid, index, createdAt)
 		if err != nil {
-			return fmt.Errorf("insert pack blocks: %w", err)
+			return err
 		}
-		return nil
+		if err := insertUploadJournalEntry(tx, key, packID, createdAt); err != nil {
+			return err
+		}
+		a.addBlocks(index.Blocks)
+		inserted = true
+		return a.logOp(tx, op{
+			Type:           opInsertPack,
+			UID:            uid,
+			Time:           createdAt.UnixNano(),
+			Data:           index.MarshalBinary(),
+			IdempotencyKey: key,
+		})
 	})
+	return inserted, err
+}
+
+// insertPackIndex inserts a packfile and its blocks. Returns the ID of the packfile.
+func insertPackIndex(tx *sql.Tx, uid string, index object.PackIndex, createdAt time.Time) (int64, error) {
+	packID, err := insertPackfile(tx, uid, index, createdAt)
+	if err != nil {
+		return 0, fmt.Errorf("inserting packfile: %w", err)
+	}
+	err = insertPackBlocks(tx, packID, index.Blocks)
+	if err != nil {
+		return 0, fmt.Errorf("insert pack blocks: %w", err)
+	}
+	return packID, nil
+}
+
+// insertUploadJournalEntry records the packfile uploaded by a request with a given key
+// in the upload journal.
+func insertUploadJournalEntry(tx *sql.Tx, key string, packID int64, createdAt time.Time) error {
+	q := insertOne("upload_journal", []string{"idempotency_key", "pack", "created_at"})
+	if _, err := tx.Exec(q, key, packID, createdAt.UnixNano()); err != nil {
+		return fmt.Errorf("inserting upload journal entry: %w", err)
+	}
+	return nil
+}
+
+// GetJournalledPack returns nil if a packfile with the given sum was uploaded by a
+// request with key. Returns ErrNotFound if the upload journal has no entry for the
+// key and packfile, or if the packfile has since been deleted.
+func (a *Adapter) GetJournalledPack(key string, s sum.Sum) error {
+	return scanJournalledPack(a.db.QueryRow(selectJournalledPack, key, s[:]))
+}
+
+const selectJournalledPack = `
+SELECT packs.id
+FROM upload_journal JOIN packs ON packs.id = upload_journal.pack
+WHERE idempotency_key = ? AND packs.sum = ?
+`
+
+func scanJournalledPack(row *sql.Row) error {
+	var id int64
+	if err := row.Scan(&id); err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
 }
 
-// InsertFile saves a File object to the database.
-func (a *Adapter) InsertFile(file object.File, sum sum.Sum) error {
+// InsertFile saves a File object, and the metadata and whole-file checksums of the new
+// file version, to the database. Returns ErrConflict if cond does not hold for the
+// file.
+func (a *Adapter) InsertFile(file object.File, sum sum.Sum, metadata map[string]string, checksums map[string][]byte, cond Precondition) error {
+	uid, err := a.ids.New(file.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("generating file version ID: %w", err)
+	}
 	return a.update(func(tx *sql.Tx) error {
-		fileID, err := insertFileIfNotExists(tx, file.Name)
-		if err != nil {
-			return fmt.Errorf("inserting file: %w", err)
+		if err := checkPrecondition(tx, file.Name, cond); err != nil {
+			return err
 		}
-		fileVerID, err := insertFileVersion(tx, fileID, file, sum)
-		if err != nil {
-			return fmt.Errorf("inserting file version: %w", err)
+		if _, err := insertFileAndChunks(tx, uid, file, sum, metadata, checksums); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{
+			Type:      opInsertFile,
+			UID:       uid,
+			Data:      file.MarshalBinary(),
+			Sums:      [][]byte{sum[:]},
+			Set:       metadata,
+			Checksums: checksums,
+		})
+	})
+}
+
+// CreateKey identifies a request to create a file version made with an idempotency
+// key. Manifest is the checksum of the file's chunk sums.
+type CreateKey struct {
+	Name           string
+	Manifest       sum.Sum
+	IdempotencyKey string
+}
+
+// InsertFileOnce inserts a file, as with InsertFile, and records the new file version
+// in the create journal under key. If the journal already has an entry for key, the
+// file is not inserted, and the sum of the journalled file version is returned with
+// inserted set to false, even if cond no longer holds.
+func (a *Adapter) InsertFileOnce(file object.File, s sum.Sum, metadata map[string]string, checksums map[string][]byte, cond Precondition, key CreateKey) (sum.Sum, bool, error) {
+	uid, err := a.ids.New(file.CreatedAt)
+	if err != nil {
+		return sum.Sum{}, false, fmt.Errorf("generating file version ID: %w", err)
+	}
+	result := s
+	inserted := false
+	err = a.update(func(tx *sql.Tx) error {
+		row := tx.QueryRow(selectJournalledFile, key.Name, key.Manifest[:], key.IdempotencyKey)
+		prev, err := scanJournalledFile(row)
+		if err == nil {
+			result = prev
+			return nil
 		}
-		err = insertFileChunks(tx, fileVerID, file.Chunks)
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("reading create journal: %w", err)
+		}
+		if err := checkPrecondition(tx, file.Name, cond); err != nil {
+			return err
+		}
+		fileVerID, err := insertFileAndChunks(tx, uid, file, s, metadata, checksums)
 		if err != nil {
-			return fmt.Errorf("inserting file chunks: %w", err)
+			return err
 		}
-		return nil
+		if err := insertJournalEntry(tx, key, fileVerID, file.CreatedAt); err != nil {
+			return err
+		}
+		inserted = true
+		return a.logOp(tx, op{
+			Type:           opInsertFile,
+			UID:            uid,
+			Data:           file.MarshalBinary(),
+			Sums:           [][]byte{s[:]},
+			Set:            metadata,
+			Checksums:      checksums,
+			Name:           key.Name,
+			Manifest:       key.Manifest[:],
+			IdempotencyKey: key.IdempotencyKey,
+		})
+	})
+	if err != nil {
+		return sum.Sum{}, false, err
+	}
+	return result, inserted, nil
+}
+
+// insertJournalEntry records the file version created by a request with a given key in
+// the create journal.
+func insertJournalEntry(tx *sql.Tx, key CreateKey, fileVerID int64, createdAt time.Time) error {
+	q := insertOne("create_journal", []string{"name", "manifest", "idempotency_key", "file_version", "created_at"})
+	_, err := tx.Exec(q, key.Name, key.Manifest[:], key.IdempotencyKey, fileVerID, createdAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("inserting create journal entry: %w", err)
+	}
+	return nil
+}
+
+// GetJournalledFile returns the sum of the file version created by a request with a
+// given key. Returns ErrNotFound if the journal has no entry for the key, or if the
+// file version has since been deleted.
+func (a *Adapter) GetJournalledFile(key CreateKey) (sum.Sum, error) {
+	row := a.db.QueryRow(selectJournalledFile, key.Name, key.Manifest[:], key.IdempotencyKey)
+	return scanJournalledFile(row)
+}
+
+const selectJournalledFile = `
+SELECT file_versions.sum
+FROM create_journal JOIN file_versions ON file_versions.id = create_journal.file_version
+WHERE name = ? AND manifest = ? AND idempotency_key = ?
+`
+
+func scanJournalledFile(row *sql.Row) (sum.Sum, error) {
+	var b []byte
+	if err := row.Scan(&b); err == sql.ErrNoRows {
+		return sum.Sum{}, ErrNotFound
+	} else if err != nil {
+		return sum.Sum{}, err
+	}
+	return sum.FromBytes(b)
+}
+
+// DeleteJournalBefore removes create and upload journal entries recorded before a
+// given time.
+func (a *Adapter) DeleteJournalBefore(t time.Time) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := deleteJournalBefore(tx, t); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opDeleteJournal, Time: t.UnixNano()})
 	})
 }
 
+func deleteJournalBefore(tx *sql.Tx, t time.Time) error {
+	if _, err := tx.Exec("DELETE FROM create_journal WHERE created_at < ?", t.UTC().UnixNano()); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM upload_journal WHERE created_at < ?", t.UTC().UnixNano())
+	return err
+}
+
+// insertFileAndChunks inserts a file version, its chunks, metadata and checksums,
+// creating the file if it does not exist. Returns the ID of the file version.
+func insertFileAndChunks(tx *sql.Tx, uid string, file object.File, sum sum.Sum, metadata map[string]string, checksums map[string][]byte) (int64, error) {
+	fileID, err := insertFileIfNotExists(tx, file.Name)
+	if err != nil {
+		return 0, fmt.Errorf("inserting file: %w", err)
+	}
+	fileVerID, err := insertFileVersion(tx, uid, fileID, file, sum)
+	if err != nil {
+		return 0, fmt.Errorf("inserting file version: %w", err)
+	}
+	if file.Data != nil {
+		q := "INSERT INTO file_inline (file_version, sum, data) VALUES (?, ?, ?)"
+		if _, err := tx.Exec(q, fileVerID, file.Chunks[0].Sum[:], file.Data); err != nil {
+			return 0, fmt.Errorf("inserting inline data: %w", err)
+		}
+	} else if err := insertFileChunks(tx, fileVerID, file.Chunks); err != nil {
+		return 0, fmt.Errorf("inserting file chunks: %w", err)
+	}
+	if err := setMetadata(tx, fileVerID, metadata, nil); err != nil {
+		return 0, fmt.Errorf("inserting file metadata: %w", err)
+	}
+	q := "INSERT INTO file_checksums (file_version, algorithm, checksum) VALUES (?, ?, ?)"
+	for algorithm, checksum := range checksums {
+		if _, err := tx.Exec(q, fileVerID, algorithm, checksum); err != nil {
+			return 0, fmt.Errorf("inserting file checksums: %w", err)
+		}
+	}
+	return fileVerID, nil
+}
+
+// GetFileChecksums returns the whole-file checksums of a file version, keyed by
+// algorithm. Returns ErrNotFound if the file version does not exist.
+func (a *Adapter) GetFileChecksums(s sum.Sum) (map[string][]byte, error) {
+	q := `
+	SELECT file_versions.id, algorithm, checksum
+	FROM file_versions LEFT JOIN file_checksums ON file_checksums.file_version = file_versions.id
+	WHERE sum = ?
+	`
+	rows, err := a.db.Query(q, s[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := false
+	checksums := make(map[string][]byte)
+	for rows.Next() {
+		var verID int64
+		var algorithm sql.NullString
+		var checksum []byte
+		if err := rows.Scan(&verID, &algorithm, &checksum); err != nil {
+			return nil, err
+		}
+		found = true
+		if algorithm.Valid {
+			checksums[algorithm.String] = checksum
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return checksums, nil
+}
+
+// FindFileVersionByChecksum returns the sum of the latest file version, of any file,
+// with a whole-file checksum. Returns ErrNotFound if no file version has the checksum.
+func (a *Adapter) FindFileVersionByChecksum(algorithm string, checksum []byte) (sum.Sum, error) {
+	q := `
+	SELECT file_versions.sum
+	FROM file_checksums JOIN file_versions ON file_versions.id = file_checksums.file_version
+	WHERE algorithm = ? AND checksum = ?
+	ORDER BY file_versions.id DESC LIMIT 1
+	`
+	var b []byte
+	err := a.db.QueryRow(q, algorithm, checksum).Scan(&b)
+	if err == sql.ErrNoRows {
+		return sum.Sum{}, ErrNotFound
+	}
+	if err != nil {
+		return sum.Sum{}, err
+	}
+	return sum.FromBytes(b)
+}
+
+// GetChunkIndex returns the location of a chunk in a healthy packfile. Returns
+// ErrNotFound if no healthy packfile holds the chunk.
+func (a *Adapter) GetChunkIndex(s sum.Sum) (ChunkIndex, error) {
+	return a.getAlternateLocation(s)
+}
+
+// ChunkRef identifies a chunk of a file version and the packfile holding it.
+type ChunkRef struct {
+	Sequence uint64
+	Sum      sum.Sum
+	PackSum  sum.Sum
+	// PackDegraded is true if the packfile has been modified or deleted outside of the
+	// server.
+	PackDegraded bool
+	// Inline is the data of a file version stored inline, whose single chunk is in no
+	// packfile.
+	Inline []byte
+}
+
+// GetFileChunkRefs returns the sum and packfile of each chunk in a file version, in
+// order. Unlike GetFileChunks, the location of each chunk within its packfile is not
+// returned. Returns ErrNotFound if the file version does not exist.
+func (a *Adapter) GetFileChunkRefs(fileID sum.Sum) ([]ChunkRef, error) {
+	var verID int64
+	var nChunks int
+	row := a.db.QueryRow("SELECT id, num_chunks FROM file_versions WHERE sum = ?", fileID[:])
+	if err := row.Scan(&verID, &nChunks); err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT file_contents.sequence, indexes.sum, packs.sum, packs.degraded_at
+	FROM
+		file_contents
+		JOIN indexes ON indexes.id = file_contents.idx
+		JOIN packs ON packs.id = indexes.pack
+	WHERE file_contents.file_version = ?
+	ORDER BY file_contents.sequence
+	`
+	rows, err := a.db.Query(q, verID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refs := make([]ChunkRef, 0, nChunks)
+	for rows.Next() {
+		var ref ChunkRef
+		var cSum, pSum []byte
+		var degradedAt int64
+		if err := rows.Scan(&ref.Sequence, &cSum, &pSum, &degradedAt); err != nil {
+			return nil, err
+		}
+		if ref.Sum, err = sum.FromBytes(cSum); err != nil {
+			return nil, err
+		}
+		if ref.PackSum, err = sum.FromBytes(pSum); err != nil {
+			return nil, err
+		}
+		ref.PackDegraded = degradedAt != 0
+		refs = append(refs, ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 && nChunks == 1 {
+		c, data, err := a.getInline(verID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			refs = append(refs, ChunkRef{Sequence: c.Sequence, Sum: c.Sum, Inline: data})
+		}
+	}
+	if len(refs) != nChunks {
+		return nil, fmt.Errorf("expected %d chunks but received %d", nChunks, len(refs))
+	}
+	return refs, nil
+}
+
+// GetPackBlocks returns the blocks of a packfile, in sequence order. Returns
+// ErrNotFound if the packfile does not exist.
+func (a *Adapter) GetPackBlocks(packSum sum.Sum) ([]object.BlockInfo, error) {
+	q := `
+	SELECT indexes.sequence, indexes.sum, indexes.chunk_size, indexes.mode, indexes.offset, indexes.size
+	FROM indexes JOIN packs ON packs.id = indexes.pack
+	WHERE packs.sum = ?
+	ORDER BY indexes.sequence
+	`
+	rows, err := a.db.Query(q, packSum[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var blocks []object.BlockInfo
+	for rows.Next() {
+		var b object.BlockInfo
+		var s []byte
+		var mode uint8
+		if err := rows.Scan(&b.Sequence, &s, &b.ChunkSize, &mode, &b.Offset, &b.Size); err != nil {
+			return nil, err
+		}
+		if b.Sum, err = sum.FromBytes(s); err != nil {
+			return nil, err
+		}
+		if b.Mode, err = compress.FromUint8(mode); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, ErrNotFound
+	}
+	return blocks, nil
+}
+
 // GetFile returns a File from the database with a given sum. Returns db.ErrNotFound if
 // the file does not exist.
 func (a *Adapter) GetFile(s sum.Sum) (object.File, error) {
@@ -272,43 +850,70 @@ func (a *Adapter) GetFile(s sum.Sum) (object.File, error) {
 	if err := rows.Err(); err != nil {
 		return object.File{}, err
 	}
+	var data []byte
+	if len(chunks) == 0 && numChunks == 1 {
+		c, inline, err := a.getInline(versionID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return object.File{}, err
+		}
+		if err == nil {
+			chunks = append(chunks, c)
+			data = inline
+		}
+	}
 
 	return object.File{
 		Name:      name,
 		CreatedAt: time.Unix(0, createdAt).UTC(),
 		Chunks:    chunks,
 		Versioned: versioned,
+		Data:      data,
 	}, nil
 }
 
-// ListFiles returns a FileInfo slice containing corresponding to files that match the
-// provided prefix. Glob parametrs exclude and include are used to filter the result.
-// Pagination is achieved using the offset and limit parameters. Results are returned
+// getInline returns the single chunk and the data of a file version stored inline.
+// Returns ErrNotFound if the version's data is not stored inline.
+func (a *Adapter) getInline(verID int64) (object.Chunk, []byte, error) {
+	var b, data []byte
+	err := a.db.QueryRow("SELECT sum, data FROM file_inline WHERE file_version = ?", verID).Scan(&b, &data)
+	if err == sql.ErrNoRows {
+		return object.Chunk{}, nil, ErrNotFound
+	}
+	if err != nil {
+		return object.Chunk{}, nil, err
+	}
+	s, err := sum.FromBytes(b)
+	if err != nil {
+		return object.Chunk{}, nil, err
+	}
+	return object.Chunk{Sequence: 0, Size: uint64(len(data)), Sum: s}, data, nil
+}
+
+// ListFiles returns a FileInfo slice containing corresponding to files that match the
+// provided prefix. Glob parametrs exclude and include are used to filter the result.
+// Pagination is achieved using the offset and limit parameters. Results are returned
 // in reverse-chronological order by default. Setting ascending to true reverses the
 // order.
 func (a *Adapter) ListFiles(prefix string, offset int64, limit uint64, exclude string, include string, ascending bool) ([]FileInfo, error) {
 	q := `
-	SELECT name, created_at, size, sum, versioned 
+	SELECT name, created_at, size, sum, versioned, uid
 	FROM files JOIN file_versions ON files.id = file_versions.file
-	WHERE name LIKE ? AND created_at > ? %s
+	WHERE name LIKE ? AND %s %s
 	ORDER BY created_at %s
 	LIMIT ?
 	`
-	ord := "DESC"
-	if ascending {
-		ord = "ASC"
-	}
+	cond, offset, ord := pageCondition(offset, ascending)
 
 	var rows *sql.Rows
 	var err error
 	if exclude != "" && include != "" {
-		q = fmt.Sprintf(q, "AND ((NOT (name GLOB ?)) OR name GLOB ?)", ord)
+		q = fmt.Sprintf(q, cond, "AND ((NOT (name GLOB ?)) OR name GLOB ?)", ord)
 		rows, err = a.db.Query(q, prefix+"%", offset, exclude, include, limit)
 	} else if exclude != "" {
-		q = fmt.Sprintf(q, "AND NOT name GLOB ?", ord)
+		q = fmt.Sprintf(q, cond, "AND NOT name GLOB ?", ord)
 		rows, err = a.db.Query(q, prefix+"%", offset, exclude, limit)
 	} else {
-		q = fmt.Sprintf(q, "", ord)
+		q = fmt.Sprintf(q, cond, "", ord)
 		rows, err = a.db.Query(q, prefix+"%", offset, limit)
 	}
 
@@ -321,10 +926,11 @@ func (a *Adapter) ListFiles(prefix string, offset int64, limit uint64, exclude s
 	var createdAt int64
 	var size uint64
 	var vflag int
+	var uid string
 	s := make([]byte, sum.Size)
 	infos := make([]FileInfo, 0)
 	for i := 0; rows.Next(); i++ {
-		if err := rows.Scan(&name, &createdAt, &size, &s, &vflag); err != nil {
+		if err := rows.Scan(&name, &createdAt, &size, &s, &vflag, &uid); err != nil {
 			return nil, err
 		}
 		sum, err := sum.FromBytes(s)
@@ -342,6 +948,7 @@ func (a *Adapter) ListFiles(prefix string, offset int64, limit uint64, exclude s
 			Size:      size,
 			Sum:       sum,
 			Versioned: versioned,
+			VersionID: uid,
 		}
 		infos = append(infos, info)
 	}
@@ -352,6 +959,336 @@ func (a *Adapter) ListFiles(prefix string, offset int64, limit uint64, exclude s
 	return infos, nil
 }
 
+// ListSort is the order of the results returned by ListFilePage.
+type ListSort int
+
+// Sort orders supported by ListFilePage. Ties are broken by the order in which the file
+// versions were inserted.
+const (
+	SortCreatedAt ListSort = iota
+	SortName
+	SortSize
+)
+
+// ListCursor is the position of a result returned by ListFilePage. Only the field
+// used by the sort order, and ID, are significant.
+type ListCursor struct {
+	Name      string
+	CreatedAt int64
+	Size      uint64
+	ID        int64
+}
+
+// ListOptions configure ListFilePage.
+type ListOptions struct {
+	// Prefix matches file names beginning with the string, compared byte by byte.
+	Prefix string
+	// Exclude and Include are glob patterns with the same meaning as in ListFiles.
+	Exclude string
+	Include string
+	// Glob, if set, only matches names matching the SQLite GLOB pattern.
+	Glob string
+	// Metadata, if not empty, only matches file versions with every key set to the
+	// given value.
+	Metadata map[string]string
+	// Delimiter, if not empty, groups the files whose names contain the delimiter after
+	// the prefix into a single result: the name up to and including the first
+	// delimiter. Only supported by SortName.
+	Delimiter string
+	Sort      ListSort
+	Ascending bool
+	Limit     uint64
+	// After, if not nil, returns the results which follow the cursor.
+	After *ListCursor
+}
+
+// ListPage is a page of results returned by ListFilePage.
+type ListPage struct {
+	Files []FileInfo
+	// Prefixes are the groups of files formed by ListOptions.Delimiter.
+	Prefixes []string
+	// Next is the position of the last result, or nil if there are no more results.
+	Next *ListCursor
+}
+
+// listEntry is a single result of ListFilePage: either a file or a prefix.
+type listEntry struct {
+	file   *FileInfo
+	prefix string
+	cursor ListCursor
+}
+
+// ListFilePage returns a page of the file versions matching opts. Unlike ListFiles, the
+// position of the page is given by a cursor, so results are never skipped or repeated
+// between pages, and each sort order is backed by an index.
+func (a *Adapter) ListFilePage(opts ListOptions) (ListPage, error) {
+	if opts.Delimiter != "" && opts.Sort != SortName {
+		return ListPage{}, errors.New("delimiter requires name sort order")
+	}
+	if opts.Limit == 0 {
+		return ListPage{}, nil
+	}
+
+	// Request one more result than required to find out if there's another page
+	want := int(opts.Limit) + 1
+	var entries []listEntry
+	after := opts.After
+	for len(entries) < want {
+		n := want - len(entries)
+		rows, err := a.listRows(opts, after, uint64(n))
+		if err != nil {
+			return ListPage{}, err
+		}
+		skipped := false
+		for i := range rows {
+			row := rows[i]
+			if prefix := groupPrefix(row.file.Name, opts.Prefix, opts.Delimiter); prefix != "" {
+				// Skip every file in the group. All names in the group are >= prefix and
+				// < the prefix's successor.
+				cursor := ListCursor{Name: prefix}
+				if opts.Ascending {
+					cursor.Name = successor(prefix)
+				}
+				entries = append(entries, listEntry{prefix: prefix, cursor: cursor})
+				after = &cursor
+				skipped = true
+				break
+			}
+			entries = append(entries, row)
+			after = &rows[i].cursor
+		}
+		if !skipped && len(rows) < n {
+			break
+		}
+	}
+
+	var page ListPage
+	if len(entries) > int(opts.Limit) {
+		entries = entries[:opts.Limit]
+		next := entries[len(entries)-1].cursor
+		page.Next = &next
+	}
+	page.Files = make([]FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if e.file != nil {
+			page.Files = append(page.Files, *e.file)
+		} else {
+			page.Prefixes = append(page.Prefixes, e.prefix)
+		}
+	}
+	return page, nil
+}
+
+// listRows returns up to limit file versions matching opts which follow the cursor
+// after, if not nil.
+func (a *Adapter) listRows(opts ListOptions, after *ListCursor, limit uint64) ([]listEntry, error) {
+	var conds []string
+	var args []interface{}
+	if opts.Prefix != "" {
+		conds = append(conds, "name >= ?")
+		args = append(args, opts.Prefix)
+		if end := successor(opts.Prefix); end != "" {
+			conds = append(conds, "name < ?")
+			args = append(args, end)
+		}
+	}
+	if opts.Glob != "" {
+		conds = append(conds, "name GLOB ?")
+		args = append(args, opts.Glob)
+	}
+	if opts.Exclude != "" && opts.Include != "" {
+		conds = append(conds, "((NOT (name GLOB ?)) OR name GLOB ?)")
+		args = append(args, opts.Exclude, opts.Include)
+	} else if opts.Exclude != "" {
+		conds = append(conds, "NOT name GLOB ?")
+		args = append(args, opts.Exclude)
+	}
+	keys := make([]string, 0, len(opts.Metadata))
+	for k := range opts.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		conds = append(conds, "EXISTS (SELECT 1 FROM file_metadata WHERE file_version = file_versions.id AND key = ? AND value = ?)")
+		args = append(args, k, opts.Metadata[k])
+	}
+
+	var col string
+	var value interface{}
+	switch opts.Sort {
+	case SortCreatedAt:
+		col = "created_at"
+		if after != nil {
+			value = after.CreatedAt
+		}
+	case SortName:
+		col = "name"
+		if after != nil {
+			value = after.Name
+		}
+	case SortSize:
+		col = "size"
+		if after != nil {
+			value = after.Size
+		}
+	default:
+		return nil, fmt.Errorf("invalid sort order %d", opts.Sort)
+	}
+	ord, cmp := "DESC", "<"
+	if opts.Ascending {
+		ord, cmp = "ASC", ">"
+	}
+	if after != nil {
+		conds = append(conds, fmt.Sprintf("(%s, file_versions.id) %s (?, ?)", col, cmp))
+		args = append(args, value, after.ID)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	q := fmt.Sprintf(`
+	SELECT name, created_at, size, sum, versioned, uid, file_versions.id
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	%s
+	ORDER BY %s %s, file_versions.id %s
+	LIMIT ?
+	`, where, col, ord, ord)
+	args = append(args, limit)
+
+	rows, err := a.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]listEntry, 0)
+	for rows.Next() {
+		var info FileInfo
+		var createdAt int64
+		var vflag int
+		var rowID int64
+		s := make([]byte, sum.Size)
+		if err := rows.Scan(&info.Name, &createdAt, &info.Size, &s, &vflag, &info.VersionID, &rowID); err != nil {
+			return nil, err
+		}
+		if info.Sum, err = sum.FromBytes(s); err != nil {
+			return nil, err
+		}
+		if info.Versioned, err = parseVFlag(vflag); err != nil {
+			return nil, err
+		}
+		info.CreatedAt = time.Unix(0, createdAt).UTC()
+		entries = append(entries, listEntry{
+			file:   &info,
+			cursor: ListCursor{Name: info.Name, CreatedAt: createdAt, Size: info.Size, ID: rowID},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].cursor.ID
+	}
+	metadata, err := getMetadata(a.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].file.Metadata = metadata[ids[i]]
+	}
+	return entries, nil
+}
+
+// searchBatchSize is the number of rows read at a time by SearchFiles.
+const searchBatchSize = 1000
+
+// SearchOptions configure SearchFiles.
+type SearchOptions struct {
+	// Prefix and Glob have the same meaning as in ListOptions, and should be used to
+	// narrow the rows scanned as much as possible.
+	Prefix string
+	Glob   string
+	// Metadata has the same meaning as in ListOptions.
+	Metadata map[string]string
+	// Match returns true if a file name matches the search.
+	Match func(name string) bool
+	Limit uint64
+	// MaxScan is the maximum number of rows scanned by a single call. Must be positive.
+	MaxScan int
+	// After, if not nil, continues the search after the cursor.
+	After *ListCursor
+}
+
+// SearchFiles returns the file versions, in ascending name order, matched by opts.
+// The search stops once Limit matches are found or MaxScan rows are scanned, so the
+// page may contain fewer than Limit files even if the search is incomplete. The
+// page's Next cursor is nil only if the search is complete.
+func (a *Adapter) SearchFiles(opts SearchOptions) (ListPage, error) {
+	if opts.Limit == 0 || opts.MaxScan <= 0 {
+		return ListPage{}, errors.New("limit and max scan must be positive")
+	}
+	lopts := ListOptions{Prefix: opts.Prefix, Glob: opts.Glob, Metadata: opts.Metadata, Sort: SortName, Ascending: true}
+	page := ListPage{Files: make([]FileInfo, 0)}
+	after := opts.After
+	scanned := 0
+	for {
+		n := searchBatchSize
+		if opts.MaxScan-scanned < n {
+			n = opts.MaxScan - scanned
+		}
+		rows, err := a.listRows(lopts, after, uint64(n))
+		if err != nil {
+			return ListPage{}, err
+		}
+		for i := range rows {
+			scanned++
+			after = &rows[i].cursor
+			if opts.Match(rows[i].file.Name) {
+				page.Files = append(page.Files, *rows[i].file)
+				if uint64(len(page.Files)) == opts.Limit {
+					page.Next = after
+					return page, nil
+				}
+			}
+		}
+		if len(rows) < n {
+			return page, nil
+		}
+		if scanned >= opts.MaxScan {
+			page.Next = after
+			return page, nil
+		}
+	}
+}
+
+// groupPrefix returns the name up to and including the first delimiter following the
+// prefix, or an empty string if the name doesn't contain the delimiter.
+func groupPrefix(name string, prefix string, delimiter string) string {
+	if delimiter == "" || !strings.HasPrefix(name, prefix) {
+		return ""
+	}
+	i := strings.Index(name[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return name[:len(prefix)+i+len(delimiter)]
+}
+
+// successor returns the smallest string, in byte order, which is greater than every
+// string beginning with s. Returns an empty string if there is no such string.
+func successor(s string) string {
+	b := []byte(s)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
 // GetLatestFileVersion returns the latest version of a file with a given name. Returns
 // db.ErrNotFound if the file does not exist.
 func (a *Adapter) GetLatestFileVersion(name string) (FileInfo, error) {
@@ -369,17 +1306,14 @@ func (a *Adapter) GetLatestFileVersion(name string) (FileInfo, error) {
 // achieved with the offset and limit parameters.
 func (a *Adapter) GetFileVersions(name string, offset int64, limit uint64, ascending bool) ([]FileInfo, error) {
 	q := `
-	SELECT created_at, size, sum, versioned 
+	SELECT created_at, size, sum, versioned, uid, file_versions.id
 	FROM files JOIN file_versions ON files.id = file_versions.file
-	WHERE name = ? AND created_at > ?
+	WHERE name = ? AND %s
 	ORDER BY created_at %s
 	LIMIT ?
 	`
-	ord := "DESC"
-	if ascending {
-		ord = "ASC"
-	}
-	q = fmt.Sprintf(q, ord)
+	cond, offset, ord := pageCondition(offset, ascending)
+	q = fmt.Sprintf(q, cond, ord)
 
 	rows, err := a.db.Query(q, name, offset, limit)
 	if err != nil {
@@ -391,9 +1325,12 @@ func (a *Adapter) GetFileVersions(name string, offset int64, limit uint64, ascen
 	var size uint64
 	s := make([]byte, sum.Size)
 	var vflag int
+	var uid string
+	var verID int64
 	infos := make([]FileInfo, 0)
+	ids := make([]int64, 0)
 	for i := 0; rows.Next(); i++ {
-		if err := rows.Scan(&createdAt, &size, &s, &vflag); err != nil {
+		if err := rows.Scan(&createdAt, &size, &s, &vflag, &uid, &verID); err != nil {
 			return nil, fmt.Errorf("row %d: %w", i, err)
 		}
 		sum, err := sum.FromBytes(s)
@@ -411,16 +1348,50 @@ func (a *Adapter) GetFileVersions(name string, offset int64, limit uint64, ascen
 			Size:      size,
 			Sum:       sum,
 			Versioned: versioned,
+			VersionID: uid,
 		}
 		infos = append(infos, info)
+		ids = append(ids, verID)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
+	metadata, err := getMetadata(a.db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		infos[i].Metadata = metadata[ids[i]]
+	}
 	return infos, nil
 }
 
+// CountFileVersions returns the number of versions of a file with a given name.
+func (a *Adapter) CountFileVersions(name string) (uint64, error) {
+	q := `
+	SELECT count(*)
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	WHERE name = ?
+	`
+	var n uint64
+	err := a.db.QueryRow(q, name).Scan(&n)
+	return n, err
+}
+
+// pageCondition returns the SQL condition on created_at, its argument, and the sort
+// order for a page of results beginning after offset. An offset of zero returns the
+// first page.
+func pageCondition(offset int64, ascending bool) (string, int64, string) {
+	if ascending {
+		return "created_at > ?", offset, "ASC"
+	}
+	if offset == 0 {
+		offset = math.MaxInt64
+	}
+	return "created_at < ?", offset, "DESC"
+}
+
 // FileInfo stores the metadata associated with a file.
 type FileInfo struct {
 	Name      string
@@ -428,6 +1399,10 @@ type FileInfo struct {
 	Size      uint64
 	Sum       sum.Sum
 	Versioned bool
+	// VersionID is a time-sortable identifier for the file version.
+	VersionID string
+	// Metadata is the user-defined metadata of the file version, or nil if it has none.
+	Metadata map[string]string
 }
 
 // ChunkIndex is returned by GetFileChunks.
@@ -435,10 +1410,17 @@ type ChunkIndex struct {
 	Sequence uint64
 	PackSum  sum.Sum
 	Block    object.BlockInfo
+	// PackDegraded is true if the packfile containing the chunk has been modified or
+	// deleted outside of the server.
+	PackDegraded bool
+	// Inline is the data of a file version stored inline, whose single chunk is in no
+	// packfile. PackSum and the location of Block are zero.
+	Inline []byte
 }
 
-// GetFileChunks returns the packfile location of each chunk in a file. Returns
-// ErrNotFound if the file does not exist.
+// GetFileChunks returns the packfile location of each chunk in a file. If a chunk's
+// packfile is degraded, the location of a copy of the chunk in another packfile is
+// returned instead, if one exists. Returns ErrNotFound if the file does not exist.
 func (a *Adapter) GetFileChunks(fileID sum.Sum) ([]ChunkIndex, error) {
 
 	// Get the row id for the file version
@@ -461,7 +1443,8 @@ func (a *Adapter) GetFileChunks(fileID sum.Sum) ([]ChunkIndex, error) {
 			indexes.offset,
 			indexes.size,
 			indexes.sequence,
-			packs.sum
+			packs.sum,
+			packs.degraded_at
 		FROM 
 			file_contents 
 			JOIN indexes ON indexes.id = file_contents.idx
@@ -485,6 +1468,7 @@ func (a *Adapter) GetFileChunks(fileID sum.Sum) ([]ChunkIndex, error) {
 		bSize   uint64
 		bSeq    uint64
 		pSum    []byte
+		pDeg    int64
 	)
 	var i int
 	for ; rows.Next(); i++ {
@@ -492,7 +1476,7 @@ func (a *Adapter) GetFileChunks(fileID sum.Sum) ([]ChunkIndex, error) {
 			return nil, fmt.Errorf("number of chunks greater than expected %d", nChunks)
 		}
 
-		if err := rows.Scan(&cSeq, &cSum, &cSize, &mode, &bOffset, &bSize, &bSeq, &pSum); err != nil {
+		if err := rows.Scan(&cSeq, &cSum, &cSize, &mode, &bOffset, &bSize, &bSeq, &pSum, &pDeg); err != nil {
 			return nil, err
 		}
 		cmode, err := compress.FromUint8(mode)
@@ -522,18 +1506,91 @@ func (a *Adapter) GetFileChunks(fileID sum.Sum) ([]ChunkIndex, error) {
 				Size:      bSize,
 				Mode:      cmode,
 			},
+			PackDegraded: pDeg != 0,
 		}
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if i == 0 && nChunks == 1 {
+		c, data, err := a.getInline(verID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			chunks[0] = ChunkIndex{Block: object.BlockInfo{Sum: c.Sum, ChunkSize: c.Size}, Inline: data}
+		}
+	}
+
+	// A chunk in a degraded packfile may be read from another packfile holding a copy
+	// of the same data, if one exists
+	for i := range chunks {
+		if !chunks[i].PackDegraded {
+			continue
+		}
+		alt, err := a.getAlternateLocation(chunks[i].Block.Sum)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("finding alternate location for chunk %x: %w", chunks[i].Block.Sum, err)
+		}
+		alt.Sequence = chunks[i].Sequence
+		chunks[i] = alt
+	}
 
 	return chunks, nil
 }
 
-func insertPackfile(tx *sql.Tx, index object.PackIndex, createdAt time.Time) (int64, error) {
-	q := insertOne("packs", []string{"sum", "num_chunks", "size", "created_at"})
-	res, err := tx.Exec(q, index.Sum[:], len(index.Blocks), index.Size, createdAt.UnixNano())
+// getAlternateLocation returns the location of a chunk in a packfile which is not
+// degraded. Copies referenced by a file are preferred because unreferenced copies
+// may be removed by the next vacuum. Returns ErrNotFound if no such copy exists.
+func (a *Adapter) getAlternateLocation(s sum.Sum) (ChunkIndex, error) {
+	q := `
+	SELECT indexes.chunk_size, indexes.mode, indexes.offset, indexes.size, indexes.sequence, packs.sum
+	FROM indexes JOIN packs ON packs.id = indexes.pack
+	WHERE indexes.sum = ? AND indexes.delete_marker = 0 AND packs.degraded_at = 0
+	ORDER BY indexes.refcount = 0, indexes.id
+	LIMIT 1
+	`
+	var (
+		cSize   uint64
+		mode    uint8
+		bOffset uint64
+		bSize   uint64
+		bSeq    uint64
+		pSum    []byte
+	)
+	row := a.db.QueryRow(q, s[:])
+	if err := row.Scan(&cSize, &mode, &bOffset, &bSize, &bSeq, &pSum); err == sql.ErrNoRows {
+		return ChunkIndex{}, ErrNotFound
+	} else if err != nil {
+		return ChunkIndex{}, err
+	}
+	cmode, err := compress.FromUint8(mode)
+	if err != nil {
+		return ChunkIndex{}, err
+	}
+	ps, err := sum.FromBytes(pSum)
+	if err != nil {
+		return ChunkIndex{}, err
+	}
+	return ChunkIndex{
+		PackSum: ps,
+		Block: object.BlockInfo{
+			Sum:       s,
+			ChunkSize: cSize,
+			Sequence:  bSeq,
+			Offset:    bOffset,
+			Size:      bSize,
+			Mode:      cmode,
+		},
+	}, nil
+}
+
+func insertPackfile(tx *sql.Tx, uid string, index object.PackIndex, createdAt time.Time) (int64, error) {
+	q := insertOne("packs", []string{"uid", "sum", "num_chunks", "size", "created_at"})
+	res, err := tx.Exec(q, uid, index.Sum[:], len(index.Blocks), index.Size, createdAt.UnixNano())
 	if err != nil {
 		return 0, err
 	}
@@ -541,48 +1598,74 @@ func insertPackfile(tx *sql.Tx, index object.PackIndex, createdAt time.Time) (in
 }
 
 func insertPackBlocks(tx *sql.Tx, packID int64, blocks []object.BlockInfo) error {
-	q := insertOne(
-		"indexes",
-		[]string{"pack", "sequence", "sum", "chunk_size", "mode", "offset", "size", "refcount"},
-	)
-	for _, b := range blocks {
-		_, err := tx.Exec(q, packID, b.Sequence, b.Sum[:], b.ChunkSize, b.Mode, b.Offset, b.Size, 0)
-		if err != nil {
-			return err
-		}
+	cols := []string{"pack", "sequence", "sum", "chunk_size", "mode", "offset", "size", "refcount"}
+	args := make([]interface{}, 0, len(cols)*len(blocks))
+	for i := range blocks {
+		b := &blocks[i]
+		args = append(args, packID, b.Sequence, b.Sum[:], b.ChunkSize, b.Mode, b.Offset, b.Size, 0)
 	}
-	return nil
+	return insertMany(tx, "indexes", cols, args)
 }
 
+// insertFileChunks inserts the chunks of a file version and increments the reference
+// count of their pack indexes. The index of each distinct chunk is looked up once, and
+// the rows are inserted in batches.
 func insertFileChunks(tx *sql.Tx, fileVerID int64, chunks []object.Chunk) error {
-	q := insertOne("file_contents", []string{"file_version", "idx", "sequence"})
-	qIncRC := "UPDATE indexes SET refcount = refcount + 1 WHERE id = ?"
+	if len(chunks) == 0 {
+		return nil
+	}
+	lookup, err := tx.Prepare(packIndexIDQuery)
+	if err != nil {
+		return err
+	}
+	defer lookup.Close()
+
+	ids := make(map[sum.Sum]int64)
+	var order []int64
+	refs := make(map[int64]int64)
+	args := make([]interface{}, 0, 3*len(chunks))
 	for _, c := range chunks {
-		idxID, err := getPackIndexID(tx, c.Sum)
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("no pack index for chunk %x", c.Sum)
-		} else if err != nil {
-			return err
+		idxID, ok := ids[c.Sum]
+		if !ok {
+			err := lookup.QueryRow(c.Sum[:]).Scan(&idxID)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("no pack index for chunk %x", c.Sum)
+			} else if err != nil {
+				return err
+			}
+			ids[c.Sum] = idxID
 		}
-		if _, err = tx.Exec(q, fileVerID, idxID, c.Sequence); err != nil {
-			return err
+		if _, ok := refs[idxID]; !ok {
+			order = append(order, idxID)
 		}
+		refs[idxID]++
+		args = append(args, fileVerID, idxID, c.Sequence)
+	}
+	if err := insertMany(tx, "file_contents", []string{"file_version", "idx", "sequence"}, args); err != nil {
+		return err
+	}
 
-		// increment the refence count of the chunk index
-		if _, err = tx.Exec(qIncRC, idxID); err != nil {
+	// increment the refence count of each chunk index by its number of references
+	inc, err := tx.Prepare("UPDATE indexes SET refcount = refcount + ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer inc.Close()
+	for _, idxID := range order {
+		if _, err := inc.Exec(refs[idxID], idxID); err != nil {
 			return fmt.Errorf("incrementing index refcount: %w", err)
 		}
 	}
 	return nil
 }
 
-func insertFileVersion(tx *sql.Tx, fileID int64, file object.File, sum sum.Sum) (int64, error) {
-	q := insertOne("file_versions", []string{"file", "created_at", "size", "num_chunks", "sum", "versioned"})
+func insertFileVersion(tx *sql.Tx, uid string, fileID int64, file object.File, sum sum.Sum) (int64, error) {
+	q := insertOne("file_versions", []string{"uid", "file", "created_at", "size", "num_chunks", "sum", "versioned"})
 	var vflag int
 	if file.Versioned {
 		vflag = 1
 	}
-	res, err := tx.Exec(q, fileID, file.CreatedAt.UnixNano(), file.Size(), len(file.Chunks), sum[:], vflag)
+	res, err := tx.Exec(q, uid, fileID, file.CreatedAt.UnixNano(), file.Size(), len(file.Chunks), sum[:], vflag)
 	if err != nil {
 		return 0, err
 	}
@@ -606,200 +1689,1345 @@ func insertFileIfNotExists(tx *sql.Tx, name string) (int64, error) {
 	return id, nil
 }
 
-// getPackIndexID gets a row ID for a pack index corresponding to a chunk.
+// packIndexIDQuery gets a row ID for a pack index corresponding to a chunk.
 // Note: a chunk may be found in multiple packfiles, but we just return the first one
-// found.
-func getPackIndexID(tx *sql.Tx, sum sum.Sum) (int64, error) {
-	q := "SELECT id FROM indexes WHERE sum = ? ORDER BY id"
-	row := tx.QueryRow(q, sum[:])
-	var id int64
-	err := row.Scan(&id)
-	return id, err
-}
+// found, preferring packfiles which are not degraded.
+const packIndexIDQuery = `
+	SELECT indexes.id FROM indexes JOIN packs ON packs.id = indexes.pack
+	WHERE indexes.sum = ? ORDER BY packs.degraded_at <> 0, indexes.id
+	`
 
 // DeleteFile deletes a file and decrements all chunks referenced by the file by one.
 // Returns ErrNotFound if the file does not exist.
 func (a *Adapter) DeleteFile(s sum.Sum) error {
 	return a.update(func(tx *sql.Tx) error {
-		// Get the row ID of the file version
-		var verID int64
-		var fileID int64
-		row := tx.QueryRow("SELECT id, file FROM file_versions WHERE sum = ?", s[:])
-		if err := row.Scan(&verID, &fileID); err == sql.ErrNoRows {
-			return ErrNotFound
-		} else if err != nil {
+		if err := deleteFileVersion(tx, s); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opDeleteFiles, Sums: [][]byte{s[:]}})
+	})
+}
+
+// DeleteFiles deletes the file versions with the given sums, and every version of the
+// files with the given names, in a single transaction. Sums and names which do not
+// exist are ignored. ifMatch may give the expected sum of the latest version of any
+// of the files in names, and nothing is deleted, and ErrConflict is returned, if the
+// latest version of any of those files differs. Returns the sums of the file versions
+// deleted.
+func (a *Adapter) DeleteFiles(sums []sum.Sum, names []string, ifMatch map[string]sum.Sum) ([]sum.Sum, error) {
+	var deleted []sum.Sum
+	err := a.update(func(tx *sql.Tx) error {
+		var err error
+		deleted, err = a.deleteFiles(tx, sums, names, ifMatch)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// deleteFiles deletes file versions, as with DeleteFiles, in the transaction tx.
+func (a *Adapter) deleteFiles(tx *sql.Tx, sums []sum.Sum, names []string, ifMatch map[string]sum.Sum) ([]sum.Sum, error) {
+	for name, s := range ifMatch {
+		s := s
+		if err := checkPrecondition(tx, name, Precondition{IfMatch: &s}); err != nil {
+			return nil, err
+		}
+	}
+	all := make([]sum.Sum, 0, len(sums))
+	all = append(all, sums...)
+	for _, name := range names {
+		versions, err := fileVersionSums(tx, name)
+		if err != nil {
+			return nil, fmt.Errorf("getting versions of %s: %w", name, err)
+		}
+		all = append(all, versions...)
+	}
+
+	deleted := make([]sum.Sum, 0, len(sums))
+	seen := make(map[sum.Sum]bool, len(all))
+	for _, s := range all {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		err := deleteFileVersion(tx, s)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("deleting file %x: %w", s, err)
+		}
+		deleted = append(deleted, s)
+	}
+	if len(deleted) == 0 {
+		return deleted, nil
+	}
+	return deleted, a.logOp(tx, op{Type: opDeleteFiles, Sums: sumBytes(deleted)})
+}
+
+// RenameFile changes the name of a file. Every version of the file is renamed.
+// Returns ErrNotFound if the file does not exist, or ErrExists if a file named dst
+// already exists.
+func (a *Adapter) RenameFile(src string, dst string) error {
+	return a.update(func(tx *sql.Tx) error {
+		if exists, err := fileExists(tx, dst); err != nil {
+			return err
+		} else if exists {
+			return ErrExists
+		}
+		if err := renameFile(tx, src, dst); err != nil {
 			return err
 		}
+		return a.logOp(tx, op{Type: opRenameFile, Src: src, Dst: dst})
+	})
+}
+
+// renameFile changes the name of a file. Returns ErrNotFound if the file does not
+// exist.
+func renameFile(tx *sql.Tx, src string, dst string) error {
+	res, err := tx.Exec("UPDATE files SET name = ? WHERE name = ?", dst, src)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-		// Decrement the refcount of each chunk referenced in the file
-		q := "SELECT idx FROM file_contents WHERE file_version = ?"
-		rows, err := tx.Query(q, verID)
+// RenamePrefix replaces the prefix src with dst in the names of every file whose name
+// begins with src, in a single transaction. check is called with each new name and the
+// rename is aborted if it returns an error. Returns the number of files renamed,
+// ErrNotFound if no names begin with src, or ErrExists if any new name is already in
+// use. The prefixes should end with "/", and neither may begin with the other.
+func (a *Adapter) RenamePrefix(src string, dst string, check func(name string) error) (int, error) {
+	var n int
+	err := a.update(func(tx *sql.Tx) error {
+		n = 0
+		rows, err := tx.Query("SELECT name FROM files WHERE substr(name, 1, length(?1)) = ?1", src)
 		if err != nil {
 			return err
 		}
 		defer rows.Close()
-		updateQ := "UPDATE indexes SET refcount = refcount - 1 WHERE id = ?"
-		var indexID int64
+		var names []string
 		for rows.Next() {
-			if err := rows.Scan(&indexID); err != nil {
-				return err
-			}
-			if _, err := tx.Exec(updateQ, indexID); err != nil {
+			var name string
+			if err := rows.Scan(&name); err != nil {
 				return err
 			}
+			names = append(names, name)
 		}
 		if err := rows.Err(); err != nil {
 			return err
 		}
-
-		// Delete row from file_version and corresponding rows from file_contents
-		q = "DELETE FROM file_contents WHERE file_version = ?"
-		if _, err := tx.Exec(q, verID); err != nil {
-			return fmt.Errorf("deleting file_contents: %w", err)
-		}
-		q = "DELETE FROM file_versions WHERE id = ?"
-		if _, err := tx.Exec(q, verID); err != nil {
-			return fmt.Errorf("deleting file_versions: %w", err)
+		rows.Close()
+		if len(names) == 0 {
+			return ErrNotFound
 		}
 
-		// Get the number of versions with the same name. If this version is the last,
-		// we can delete the row from the files table
-		q = "SELECT count(*) FROM file_versions WHERE file = ?"
-		row = tx.QueryRow(q, fileID)
-		var numVersions int64
-		if err := row.Scan(&numVersions); err != nil {
-			return err
-		}
-		if numVersions == 0 {
-			q = "DELETE FROM files WHERE id = ?"
-			if _, err := tx.Exec(q, fileID); err != nil {
-				return fmt.Errorf("deleting file: %w", err)
+		for _, name := range names {
+			newName := dst + strings.TrimPrefix(name, src)
+			if err := check(newName); err != nil {
+				return fmt.Errorf("%s: %w", newName, err)
+			}
+			if exists, err := fileExists(tx, newName); err != nil {
+				return err
+			} else if exists {
+				return fmt.Errorf("%s: %w", newName, ErrExists)
 			}
 		}
 
-		return nil
+		if err := renamePrefix(tx, src, dst); err != nil {
+			return err
+		}
+		n = len(names)
+		return a.logOp(tx, op{Type: opRenamePrefix, Src: src, Dst: dst})
 	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
-// ZeroRefcount is returned by GetZeroRefcount. It stores a pack ID and a sorted sequence
-// of each block in the file with a zero refcount.
-type ZeroRefcount struct {
-	PackID    sum.Sum
-	Sequences []uint64
+// renamePrefix replaces the prefix src with dst in the names of every file whose name
+// begins with src.
+func renamePrefix(tx *sql.Tx, src string, dst string) error {
+	q := "UPDATE files SET name = ?2 || substr(name, length(?1) + 1) WHERE substr(name, 1, length(?1)) = ?1"
+	_, err := tx.Exec(q, src, dst)
+	return err
 }
 
-// GetZeroRefcount returns the block sequence numbers in each packfile with a zero
-// refcount.
-func (a *Adapter) GetZeroRefcount(createdBefore time.Time) ([]ZeroRefcount, error) {
-	var result []ZeroRefcount
+func fileExists(tx *sql.Tx, name string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM files WHERE name = ?)", name).Scan(&exists)
+	return exists, err
+}
 
-	a.update(func(tx *sql.Tx) error {
-		q := `
-		SELECT indexes.id, packs.sum, indexes.sequence 
-		FROM indexes JOIN packs on packs.id = indexes.pack
-		WHERE indexes.refcount = 0 AND packs.created_at < ?
+// SetMetadata adds or replaces the metadata keys in set, and removes the keys in del,
+// on a file version. check is called with the resulting metadata before the change is
+// committed, and the change is abandoned if it returns an error. Returns the resulting
+// metadata, or ErrNotFound if the file version does not exist.
+func (a *Adapter) SetMetadata(s sum.Sum, set map[string]string, del []string, check func(metadata map[string]string) error) (map[string]string, error) {
+	var result map[string]string
+	err := a.update(func(tx *sql.Tx) error {
+		verID, err := fileVersionID(tx, s)
+		if err != nil {
+			return err
+		}
+		if err := setMetadata(tx, verID, set, del); err != nil {
+			return err
+		}
+		metadata, err := getMetadata(tx, []int64{verID})
+		if err != nil {
+			return err
+		}
+		result = metadata[verID]
+		if check != nil {
+			if err := check(result); err != nil {
+				return err
+			}
+		}
+		return a.logOp(tx, op{Type: opSetMetadata, Sums: [][]byte{s[:]}, Set: set, Delete: del})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fileVersionID returns the row ID of a file version. Returns ErrNotFound if the file
+// version does not exist.
+func fileVersionID(tx *sql.Tx, s sum.Sum) (int64, error) {
+	var verID int64
+	err := tx.QueryRow("SELECT id FROM file_versions WHERE sum = ?", s[:]).Scan(&verID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return verID, err
+}
+
+// setMetadata adds or replaces the metadata keys in set, and removes the keys in del,
+// on the file version with row ID verID.
+func setMetadata(tx *sql.Tx, verID int64, set map[string]string, del []string) error {
+	for _, k := range del {
+		if _, err := tx.Exec("DELETE FROM file_metadata WHERE file_version = ? AND key = ?", verID, k); err != nil {
+			return err
+		}
+	}
+	q := "INSERT OR REPLACE INTO file_metadata (file_version, key, value) VALUES (?, ?, ?)"
+	for k, v := range set {
+		if _, err := tx.Exec(q, verID, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metadataBatchSize is the maximum number of file versions getMetadata queries at once,
+// to stay below SQLite's limit on the number of query parameters.
+const metadataBatchSize = 500
+
+// getMetadata returns the metadata of the file versions with the given row IDs. File
+// versions without metadata are omitted.
+func getMetadata(db interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, ids []int64) (map[int64]map[string]string, error) {
+	result := make(map[int64]map[string]string)
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > metadataBatchSize {
+			batch = batch[:metadataBatchSize]
+		}
+		ids = ids[len(batch):]
+
+		q := fmt.Sprintf(
+			"SELECT file_version, key, value FROM file_metadata WHERE file_version IN (%s)",
+			strings.Repeat("?, ", len(batch)-1)+"?",
+		)
+		args := make([]interface{}, len(batch))
+		for i := range batch {
+			args[i] = batch[i]
+		}
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			var k, v string
+			if err := rows.Scan(&id, &k, &v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if result[id] == nil {
+				result[id] = make(map[string]string)
+			}
+			result[id][k] = v
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// VersionTag is a label naming a version of a file.
+type VersionTag struct {
+	Tag       string
+	Sum       sum.Sum
+	CreatedAt time.Time
+}
+
+// TagVersion labels the file version s with tag. If the tag already names another
+// version of the same file, it is moved to s. Returns ErrNotFound if the file version
+// does not exist.
+func (a *Adapter) TagVersion(s sum.Sum, tag string, createdAt time.Time) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := tagVersion(tx, s, tag, createdAt); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opTagVersion, Sums: [][]byte{s[:]}, Name: tag, Time: createdAt.UnixNano()})
+	})
+}
+
+func tagVersion(tx *sql.Tx, s sum.Sum, tag string, createdAt time.Time) error {
+	var verID, fileID int64
+	row := tx.QueryRow("SELECT id, file FROM file_versions WHERE sum = ?", s[:])
+	if err := row.Scan(&verID, &fileID); err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	q := "INSERT OR REPLACE INTO version_tags (file, file_version, tag, created_at) VALUES (?, ?, ?, ?)"
+	_, err := tx.Exec(q, fileID, verID, tag, createdAt.UnixNano())
+	return err
+}
+
+// UntagVersion removes tag from the file name. Returns ErrNotFound if the file has no
+// such tag.
+func (a *Adapter) UntagVersion(name string, tag string) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := untagVersion(tx, name, tag); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opUntagVersion, Name: tag, Src: name})
+	})
+}
+
+func untagVersion(tx *sql.Tx, name string, tag string) error {
+	q := "DELETE FROM version_tags WHERE tag = ? AND file = (SELECT id FROM files WHERE name = ?)"
+	res, err := tx.Exec(q, tag, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetTaggedVersion returns the version of the file name labelled with tag. Returns
+// ErrNotFound if the file has no such tag.
+func (a *Adapter) GetTaggedVersion(name string, tag string) (FileInfo, error) {
+	q := `
+	SELECT file_versions.sum
+	FROM version_tags
+		JOIN files ON files.id = version_tags.file
+		JOIN file_versions ON file_versions.id = version_tags.file_version
+	WHERE name = ? AND tag = ?
+	`
+	var b []byte
+	if err := a.db.QueryRow(q, name, tag).Scan(&b); err == sql.ErrNoRows {
+		return FileInfo{}, ErrNotFound
+	} else if err != nil {
+		return FileInfo{}, err
+	}
+	s, err := sum.FromBytes(b)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return a.GetFileInfo(s)
+}
+
+// GetFileTags returns the tags of the file name, in tag order.
+func (a *Adapter) GetFileTags(name string) ([]VersionTag, error) {
+	q := `
+	SELECT tag, file_versions.sum, version_tags.created_at
+	FROM version_tags
+		JOIN files ON files.id = version_tags.file
+		JOIN file_versions ON file_versions.id = version_tags.file_version
+	WHERE name = ?
+	ORDER BY tag
+	`
+	rows, err := a.db.Query(q, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make([]VersionTag, 0)
+	for rows.Next() {
+		var t VersionTag
+		var b []byte
+		var createdAt int64
+		if err := rows.Scan(&t.Tag, &b, &createdAt); err != nil {
+			return nil, err
+		}
+		if t.Sum, err = sum.FromBytes(b); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = time.Unix(0, createdAt).UTC()
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// IsVersionPinned returns true if the file version s has any tags, or is in a
+// snapshot.
+func (a *Adapter) IsVersionPinned(s sum.Sum) (bool, error) {
+	q := `
+	SELECT EXISTS (SELECT 1 FROM version_tags WHERE file_version = file_versions.id)
+		OR EXISTS (SELECT 1 FROM snapshot_files WHERE file_version = file_versions.id)
+	FROM file_versions
+	WHERE sum = ?
+	`
+	var pinned bool
+	err := a.db.QueryRow(q, s[:]).Scan(&pinned)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return pinned, err
+}
+
+// Snapshot is a record of the latest version of every file in a directory at a point
+// in time.
+type Snapshot struct {
+	Name string
+	// Prefix is the directory, ending with a slash.
+	Prefix    string
+	CreatedAt time.Time
+	// NumFiles is the number of files in the snapshot, and TotalFilesSize is the total
+	// size of the versions which have not been deleted.
+	NumFiles       uint64
+	TotalFilesSize uint64
+}
+
+// FileHead is the latest version of a file, either now or when a snapshot was taken.
+type FileHead struct {
+	Name string
+	Sum  sum.Sum
+	// Deleted is true if the version of a file in a snapshot has since been deleted,
+	// in which case Sum is zero.
+	Deleted bool
+}
+
+// CreateSnapshot records the latest version of every file whose name begins with
+// prefix in a new snapshot. The snapshot is taken in a single transaction, so it
+// includes either all or none of the changes made by any other update. Returns
+// ErrExists if a snapshot with the same name exists.
+func (a *Adapter) CreateSnapshot(name string, prefix string, createdAt time.Time) (Snapshot, error) {
+	err := a.update(func(tx *sql.Tx) error {
+		heads, err := fileHeads(tx, prefix)
+		if err != nil {
+			return fmt.Errorf("getting latest file versions: %w", err)
+		}
+		sums := make([]sum.Sum, len(heads))
+		for i := range heads {
+			sums[i] = heads[i].Sum
+		}
+		if err := insertSnapshot(tx, name, prefix, createdAt, sums); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opCreateSnapshot, Name: name, Src: prefix, Time: createdAt.UnixNano(), Sums: sumBytes(sums)})
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return a.GetSnapshot(name)
+}
+
+// insertSnapshot inserts a snapshot of the file versions with the given sums.
+func insertSnapshot(tx *sql.Tx, name string, prefix string, createdAt time.Time, sums []sum.Sum) error {
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM snapshots WHERE name = ?)", name).Scan(&exists); err != nil {
+		return err
+	} else if exists {
+		return ErrExists
+	}
+	q := insertOne("snapshots", []string{"name", "prefix", "created_at"})
+	res, err := tx.Exec(q, name, prefix, createdAt.UnixNano())
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	q = `
+	INSERT INTO snapshot_files (snapshot, name, file_version)
+	SELECT ?, files.name, file_versions.id
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	WHERE sum = ?
+	`
+	for _, s := range sums {
+		res, err := tx.Exec(q, id, s[:])
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("file %x: %w", s, ErrNotFound)
+		}
+	}
+	return nil
+}
+
+// fileHeads returns the latest version of every file whose name begins with prefix,
+// in name order.
+func fileHeads(db interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, prefix string) ([]FileHead, error) {
+	q := `
+	SELECT name, file_versions.sum
+	FROM files JOIN file_versions ON file_versions.id = (
+		SELECT id FROM file_versions WHERE file = files.id ORDER BY created_at DESC LIMIT 1
+	)
+	WHERE substr(name, 1, length(?1)) = ?1
+	ORDER BY name
+	`
+	rows, err := db.Query(q, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	heads := make([]FileHead, 0)
+	for rows.Next() {
+		var h FileHead
+		var b []byte
+		if err := rows.Scan(&h.Name, &b); err != nil {
+			return nil, err
+		}
+		if h.Sum, err = sum.FromBytes(b); err != nil {
+			return nil, err
+		}
+		heads = append(heads, h)
+	}
+	return heads, rows.Err()
+}
+
+// GetFileHeads returns the latest version of every file whose name begins with
+// prefix, in name order.
+func (a *Adapter) GetFileHeads(prefix string) ([]FileHead, error) {
+	return fileHeads(a.db, prefix)
+}
+
+const selectSnapshots = `
+SELECT snapshots.name, prefix, snapshots.created_at, count(snapshot_files.name), ifnull(sum(size), 0)
+FROM snapshots
+	LEFT JOIN snapshot_files ON snapshot_files.snapshot = snapshots.id
+	LEFT JOIN file_versions ON file_versions.id = snapshot_files.file_version
+WHERE %s
+GROUP BY snapshots.id
+ORDER BY snapshots.created_at DESC, snapshots.id DESC
+`
+
+// GetSnapshot returns a snapshot. Returns ErrNotFound if the snapshot does not exist.
+func (a *Adapter) GetSnapshot(name string) (Snapshot, error) {
+	snapshots, err := a.querySnapshots("snapshots.name = ?", name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(snapshots) == 0 {
+		return Snapshot{}, ErrNotFound
+	}
+	return snapshots[0], nil
+}
+
+// ListSnapshots returns the snapshots of the directories beginning with prefix, most
+// recent first.
+func (a *Adapter) ListSnapshots(prefix string) ([]Snapshot, error) {
+	return a.querySnapshots("substr(prefix, 1, length(?1)) = ?1", prefix)
+}
+
+func (a *Adapter) querySnapshots(where string, args ...interface{}) ([]Snapshot, error) {
+	rows, err := a.db.Query(fmt.Sprintf(selectSnapshots, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snapshots := make([]Snapshot, 0)
+	for rows.Next() {
+		var s Snapshot
+		var createdAt int64
+		if err := rows.Scan(&s.Name, &s.Prefix, &createdAt, &s.NumFiles, &s.TotalFilesSize); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = time.Unix(0, createdAt).UTC()
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// GetSnapshotFiles returns the files in a snapshot, in name order. Returns ErrNotFound
+// if the snapshot does not exist.
+func (a *Adapter) GetSnapshotFiles(name string) ([]FileHead, error) {
+	var id int64
+	if err := a.db.QueryRow("SELECT id FROM snapshots WHERE name = ?", name).Scan(&id); err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	q := `
+	SELECT snapshot_files.name, file_versions.sum
+	FROM snapshot_files LEFT JOIN file_versions ON file_versions.id = snapshot_files.file_version
+	WHERE snapshot = ?
+	ORDER BY snapshot_files.name
+	`
+	rows, err := a.db.Query(q, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	files := make([]FileHead, 0)
+	for rows.Next() {
+		var f FileHead
+		var b []byte
+		if err := rows.Scan(&f.Name, &b); err != nil {
+			return nil, err
+		}
+		if b == nil {
+			f.Deleted = true
+		} else if f.Sum, err = sum.FromBytes(b); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// DeleteSnapshot deletes a snapshot. The file versions in the snapshot are not
+// changed. Returns ErrNotFound if the snapshot does not exist.
+func (a *Adapter) DeleteSnapshot(name string) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := deleteSnapshot(tx, name); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opDeleteSnapshot, Name: name})
+	})
+}
+
+func deleteSnapshot(tx *sql.Tx, name string) error {
+	res, err := tx.Exec("DELETE FROM snapshots WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// NewFileVersion is a file version to insert with InsertFiles.
+type NewFileVersion struct {
+	File      object.File
+	Sum       sum.Sum
+	Metadata  map[string]string
+	Checksums map[string][]byte
+	Cond      Precondition
+}
+
+// InsertFiles inserts new file versions, as with InsertFile, and deletes every version
+// of the files named in del, in a single transaction. del maps the name of each file
+// to delete to the sum of its expected latest version. Nothing is changed, and
+// ErrConflict is returned, if the precondition of a new version does not hold or the
+// latest version of a file in del differs. Returns the sums of the deleted versions.
+func (a *Adapter) InsertFiles(files []NewFileVersion, del map[string]sum.Sum) ([]sum.Sum, error) {
+	uids := make([]string, len(files))
+	for i := range files {
+		uid, err := a.ids.New(files[i].File.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("generating file version ID: %w", err)
+		}
+		uids[i] = uid
+	}
+	names := make([]string, 0, len(del))
+	for name := range del {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var deleted []sum.Sum
+	err := a.update(func(tx *sql.Tx) error {
+		for i, f := range files {
+			if err := checkPrecondition(tx, f.File.Name, f.Cond); err != nil {
+				return err
+			}
+			if _, err := insertFileAndChunks(tx, uids[i], f.File, f.Sum, f.Metadata, f.Checksums); err != nil {
+				return err
+			}
+			err := a.logOp(tx, op{
+				Type:      opInsertFile,
+				UID:       uids[i],
+				Data:      f.File.MarshalBinary(),
+				Sums:      [][]byte{f.Sum[:]},
+				Set:       f.Metadata,
+				Checksums: f.Checksums,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		deleted, err = a.deleteFiles(tx, nil, names, del)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// PrefixStats summarises the files under a prefix.
+type PrefixStats struct {
+	NumFiles        uint64
+	NumFileVersions uint64
+	TotalFilesSize  uint64
+}
+
+// GetPrefixStats returns the number of files, file versions and their total size for
+// the files whose names begin with prefix.
+func (a *Adapter) GetPrefixStats(prefix string) (PrefixStats, error) {
+	q := `
+	SELECT count(DISTINCT files.id), count(*), ifnull(sum(size), 0)
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	WHERE substr(name, 1, length(?1)) = ?1
+	`
+	var stats PrefixStats
+	row := a.db.QueryRow(q, prefix)
+	if err := row.Scan(&stats.NumFiles, &stats.NumFileVersions, &stats.TotalFilesSize); err != nil {
+		return PrefixStats{}, err
+	}
+	return stats, nil
+}
+
+// DeletePrefix deletes at most limit file versions of the files whose names begin
+// with prefix in a single transaction. Returns the sums of the deleted versions, the
+// number of files, file versions and total size deleted, and whether any versions
+// under the prefix remain.
+func (a *Adapter) DeletePrefix(prefix string, limit uint64) ([]sum.Sum, PrefixStats, bool, error) {
+	var deleted []sum.Sum
+	var stats PrefixStats
+	var more bool
+	err := a.update(func(tx *sql.Tx) error {
+		deleted, stats = nil, PrefixStats{}
+		numFiles, err := countPrefixFiles(tx, prefix)
+		if err != nil {
+			return err
+		}
+
+		q := `
+		SELECT size, sum
+		FROM files JOIN file_versions ON files.id = file_versions.file
+		WHERE substr(name, 1, length(?1)) = ?1
+		ORDER BY file_versions.id
+		LIMIT ?2
+		`
+		// Select one more than the limit to check if any versions remain
+		rows, err := tx.Query(q, prefix, limit+1)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var size uint64
+			var b []byte
+			if err := rows.Scan(&size, &b); err != nil {
+				return err
+			}
+			s, err := sum.FromBytes(b)
+			if err != nil {
+				return err
+			}
+			if uint64(len(deleted)) == limit {
+				more = true
+				break
+			}
+			deleted = append(deleted, s)
+			stats.TotalFilesSize += size
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, s := range deleted {
+			if err := deleteFileVersion(tx, s); err != nil {
+				return fmt.Errorf("deleting file %x: %w", s, err)
+			}
+		}
+		stats.NumFileVersions = uint64(len(deleted))
+
+		remaining, err := countPrefixFiles(tx, prefix)
+		if err != nil {
+			return err
+		}
+		stats.NumFiles = numFiles - remaining
+		if len(deleted) == 0 {
+			return nil
+		}
+		return a.logOp(tx, op{Type: opDeleteFiles, Sums: sumBytes(deleted)})
+	})
+	if err != nil {
+		return nil, PrefixStats{}, false, err
+	}
+	return deleted, stats, more, nil
+}
+
+func countPrefixFiles(tx *sql.Tx, prefix string) (uint64, error) {
+	var n uint64
+	err := tx.QueryRow("SELECT count(*) FROM files WHERE substr(name, 1, length(?1)) = ?1", prefix).Scan(&n)
+	return n, err
+}
+
+// fileVersionSums returns the sums of every version of a file.
+func fileVersionSums(tx *sql.Tx, name string) ([]sum.Sum, error) {
+	q := `
+	SELECT file_versions.sum
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	WHERE name = ?
+	`
+	rows, err := tx.Query(q, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sums []sum.Sum
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, s)
+	}
+	return sums, rows.Err()
+}
+
+// deleteFileVersion deletes a file version and decrements all chunks referenced by it
+// by one. The file is deleted if this is its last version. Returns ErrNotFound if the
+// file version does not exist.
+func deleteFileVersion(tx *sql.Tx, s sum.Sum) error {
+	// Get the row ID of the file version
+	var verID int64
+	var fileID int64
+	row := tx.QueryRow("SELECT id, file FROM file_versions WHERE sum = ?", s[:])
+	if err := row.Scan(&verID, &fileID); err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// Delete row from file_version and corresponding rows from file_contents
+	if err := deleteFileChunks(tx, verID); err != nil {
+		return err
+	}
+	q := "DELETE FROM file_versions WHERE id = ?"
+	if _, err := tx.Exec(q, verID); err != nil {
+		return fmt.Errorf("deleting file_versions: %w", err)
+	}
+
+	// Get the number of versions with the same name. If this version is the last,
+	// we can delete the row from the files table
+	q = "SELECT count(*) FROM file_versions WHERE file = ?"
+	row = tx.QueryRow(q, fileID)
+	var numVersions int64
+	if err := row.Scan(&numVersions); err != nil {
+		return err
+	}
+	if numVersions == 0 {
+		q = "DELETE FROM files WHERE id = ?"
+		if _, err := tx.Exec(q, fileID); err != nil {
+			return fmt.Errorf("deleting file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteFileChunks deletes the chunks of a file version, decrementing the refcount of
+// each chunk by one.
+func deleteFileChunks(tx *sql.Tx, verID int64) error {
+	q := "SELECT idx FROM file_contents WHERE file_version = ?"
+	rows, err := tx.Query(q, verID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	updateQ := "UPDATE indexes SET refcount = refcount - 1 WHERE id = ?"
+	var indexID int64
+	for rows.Next() {
+		if err := rows.Scan(&indexID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(updateQ, indexID); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	q = "DELETE FROM file_contents WHERE file_version = ?"
+	if _, err := tx.Exec(q, verID); err != nil {
+		return fmt.Errorf("deleting file_contents: %w", err)
+	}
+	return nil
+}
+
+// ReplaceFileVersion replaces the chunks of the file version old with the chunks of
+// file, which must hold the same data, and changes the version's sum to s. The
+// version keeps its ID, metadata, checksums and tags, and stays in its snapshots. The
+// refcounts of the old chunks are decremented, so a vacuum may reclaim them. Returns
+// ErrNotFound if the file version does not exist.
+func (a *Adapter) ReplaceFileVersion(old sum.Sum, file object.File, s sum.Sum) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := replaceFileVersion(tx, old, file, s); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opReplaceFileVersion, Data: file.MarshalBinary(), Sums: [][]byte{old[:], s[:]}})
+	})
+}
+
+func replaceFileVersion(tx *sql.Tx, old sum.Sum, file object.File, s sum.Sum) error {
+	verID, err := fileVersionID(tx, old)
+	if err != nil {
+		return err
+	}
+	if err := deleteFileChunks(tx, verID); err != nil {
+		return err
+	}
+	if err := insertFileChunks(tx, verID, file.Chunks); err != nil {
+		return err
+	}
+	q := "UPDATE file_versions SET sum = ?, num_chunks = ? WHERE id = ?"
+	if _, err := tx.Exec(q, s[:], len(file.Chunks), verID); err != nil {
+		return fmt.Errorf("updating file_versions: %w", err)
+	}
+	return nil
+}
+
+// ZeroRefcount is returned by GetZeroRefcount. It stores a pack ID and a sorted sequence
+// of each block in the file with a zero refcount.
+type ZeroRefcount struct {
+	PackID    sum.Sum
+	Sequences []uint64
+}
+
+// GetZeroRefcount returns the block sequence numbers in each packfile with a zero
+// refcount.
+func (a *Adapter) GetZeroRefcount(createdBefore time.Time) ([]ZeroRefcount, error) {
+	var result []ZeroRefcount
+
+	a.update(func(tx *sql.Tx) error {
+		q := `
+		SELECT indexes.id, packs.sum, indexes.sequence 
+		FROM indexes JOIN packs on packs.id = indexes.pack
+		WHERE indexes.refcount = 0 AND packs.created_at < ?
 		ORDER BY packs.id, indexes.sequence
 		`
 		rows, err := a.db.Query(q, createdBefore.UTC().UnixNano())
 		if err != nil {
-			return err
+			return err
+		}
+		defer rows.Close()
+
+		var indexIDs []int64
+		var prevSum sum.Sum
+		var slice []uint64
+		var indexID int64
+		var seq uint64
+		packID := make([]byte, sum.Size)
+		for i := 0; rows.Next(); i++ {
+			if err := rows.Scan(&indexID, &packID, &seq); err != nil {
+				return err
+			}
+			sum, err := sum.FromBytes(packID)
+			if err != nil {
+				return err
+			}
+			if prevSum != sum {
+				if i != 0 {
+					seqs := make([]uint64, len(slice))
+					copy(seqs, slice)
+					result = append(result, ZeroRefcount{prevSum, seqs})
+					slice = slice[:0]
+				}
+				prevSum = sum
+			}
+			slice = append(slice, seq)
+			indexIDs = append(indexIDs, indexID)
+		}
+		if len(slice) > 0 { // Don't forget the last slice
+			seqs := make([]uint64, len(slice))
+			copy(seqs, slice)
+			result = append(result, ZeroRefcount{prevSum, seqs})
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Set the delete marker on all blocks picked out
+		q = "UPDATE indexes SET delete_marker = 1 WHERE id = ?"
+		for _, id := range indexIDs {
+			if _, err := tx.Exec(q, id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return result, nil
+}
+
+// UpdateIndex overwrites the contents of a pack index with a new one. The map m
+// specifies the mapping from the sequence numbers of the new index to the sequence
+// numbers of the old index. Any sequences in the old index which are not re-mapped will
+// be deleted when DeletePackIndex is called on the old index.
+func (a *Adapter) UpdateIndex(newIndex object.PackIndex, createdAt time.Time, oldIndexSum sum.Sum, m map[uint64]uint64) error {
+	uid, err := a.ids.New(createdAt)
+	if err != nil {
+		return fmt.Errorf("generating packfile ID: %w", err)
+	}
+	return a.update(func(tx *sql.Tx) error {
+		if err := updateIndex(tx, uid, newIndex, createdAt, oldIndexSum, m); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{
+			Type:      opUpdateIndex,
+			UID:       uid,
+			Time:      createdAt.UnixNano(),
+			Data:      newIndex.MarshalBinary(),
+			Sums:      [][]byte{oldIndexSum[:]},
+			Sequences: m,
+		})
+	})
+}
+
+func updateIndex(tx *sql.Tx, uid string, newIndex object.PackIndex, createdAt time.Time, oldIndexSum sum.Sum, m map[uint64]uint64) error {
+	newPackID, err := insertPackfile(tx, uid, newIndex, createdAt.UTC())
+	if err != nil {
+		return fmt.Errorf("insertPackfile: %w", err)
+	}
+
+	var oldPackID uint64
+	q := "SELECT id FROM packs WHERE sum = ?"
+	row := tx.QueryRow(q, oldIndexSum[:])
+	if err := row.Scan(&oldPackID); err != nil {
+		return fmt.Errorf("getting old pack row ID: %w", err)
+	}
+
+	q = `
+	UPDATE indexes 
+	SET pack = ?, sequence = ?, offset = ? 
+	WHERE pack = ? AND sequence = ?
+	`
+	for _, block := range newIndex.Blocks {
+		oldSeq, ok := m[block.Sequence]
+		if !ok {
+			return fmt.Errorf("no sequence mapping for block %d", block.Sequence)
+		}
+		_, err = tx.Exec(q, newPackID, block.Sequence, block.Offset, oldPackID, oldSeq)
+		if err != nil {
+			return fmt.Errorf("updating pack index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ReclaimablePack is a packfile with blocks which have a zero refcount, returned by
+// ListReclaimablePacks.
+type ReclaimablePack struct {
+	Sum sum.Sum
+	// Size is the size of the packfile, and NumChunks the number of blocks in it.
+	Size      uint64
+	NumChunks uint64
+	// Chunks is the number of blocks with a zero refcount, and ChunksSize their total
+	// size.
+	Chunks     uint64
+	ChunksSize uint64
+}
+
+// ListReclaimablePacks returns each packfile created before createdBefore with blocks
+// which have a zero refcount, in the order the packfiles were created. Unlike
+// GetZeroRefcount, the blocks are not marked for deletion.
+func (a *Adapter) ListReclaimablePacks(createdBefore time.Time) ([]ReclaimablePack, error) {
+	q := `
+	SELECT packs.sum, packs.size, packs.num_chunks, count(*), sum(indexes.size)
+	FROM indexes JOIN packs ON packs.id = indexes.pack
+	WHERE indexes.refcount = 0 AND packs.created_at < ?
+	GROUP BY packs.id
+	ORDER BY packs.id
+	`
+	rows, err := a.db.Query(q, createdBefore.UTC().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var packs []ReclaimablePack
+	for rows.Next() {
+		var p ReclaimablePack
+		var b []byte
+		if err := rows.Scan(&b, &p.Size, &p.NumChunks, &p.Chunks, &p.ChunksSize); err != nil {
+			return nil, err
+		}
+		if p.Sum, err = sum.FromBytes(b); err != nil {
+			return nil, err
+		}
+		packs = append(packs, p)
+	}
+	return packs, rows.Err()
+}
+
+// ListPackFiles returns the names of the files with a version holding data in the
+// packfile s, in order of name, up to limit names.
+func (a *Adapter) ListPackFiles(s sum.Sum, limit int) ([]string, error) {
+	q := `
+	SELECT DISTINCT files.name
+	FROM packs
+	JOIN indexes ON indexes.pack = packs.id
+	JOIN file_contents ON file_contents.idx = indexes.id
+	JOIN file_versions ON file_versions.id = file_contents.file_version
+	JOIN files ON files.id = file_versions.file
+	WHERE packs.sum = ?
+	ORDER BY files.name
+	LIMIT ?
+	`
+	rows, err := a.db.Query(q, s[:], limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// DeletePackIndex deletes a pack index from the database.
+func (a *Adapter) DeletePackIndex(sum sum.Sum) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := deletePackIndex(tx, sum); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opDeletePack, Sums: [][]byte{sum[:]}})
+	})
+}
+
+func deletePackIndex(tx *sql.Tx, s sum.Sum) error {
+	// Will also delete corresponding rows in indexes table because the FK has
+	// ON DELETE CASCADE set.
+	q := "DELETE FROM packs WHERE sum = ?"
+	_, err := tx.Exec(q, s[:])
+	return err
+}
+
+// PackInfo stores the metadata for a packfile.
+type PackInfo struct {
+	// ID is a time-sortable identifier for the packfile.
+	ID        string
+	Sum       sum.Sum
+	Size      uint64
+	CreatedAt time.Time
+	// DegradedAt is the time the packfile was marked as degraded, or the zero time if
+	// the packfile is healthy.
+	DegradedAt time.Time
+	// ETag is the ETag reported by the store for the packfile when it was uploaded, or
+	// empty if it was not recorded.
+	ETag string
+}
+
+const selectPackInfo = "SELECT uid, sum, size, created_at, degraded_at, etag FROM packs"
+
+func scanPackInfo(row interface{ Scan(...interface{}) error }) (PackInfo, error) {
+	var info PackInfo
+	var s []byte
+	var createdAt int64
+	var degradedAt int64
+	if err := row.Scan(&info.ID, &s, &info.Size, &createdAt, &degradedAt, &info.ETag); err != nil {
+		return PackInfo{}, err
+	}
+	var err error
+	if info.Sum, err = sum.FromBytes(s); err != nil {
+		return PackInfo{}, err
+	}
+	info.CreatedAt = time.Unix(0, createdAt).UTC()
+	if degradedAt != 0 {
+		info.DegradedAt = time.Unix(0, degradedAt).UTC()
+	}
+	return info, nil
+}
+
+// GetPackInfo returns the metadata for a packfile. Returns ErrNotFound if the packfile
+// does not exist.
+func (a *Adapter) GetPackInfo(s sum.Sum) (PackInfo, error) {
+	info, err := scanPackInfo(a.db.QueryRow(selectPackInfo+" WHERE sum = ?", s[:]))
+	if err == sql.ErrNoRows {
+		return PackInfo{}, ErrNotFound
+	}
+	return info, err
+}
+
+// ListPacks returns the metadata for every packfile, in the order they were created.
+func (a *Adapter) ListPacks() ([]PackInfo, error) {
+	rows, err := a.db.Query(selectPackInfo + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	packs := make([]PackInfo, 0)
+	for rows.Next() {
+		info, err := scanPackInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, info)
+	}
+	return packs, rows.Err()
+}
+
+// ListFileVersionSums returns the sum of every file version, in the order they were
+// created.
+func (a *Adapter) ListFileVersionSums() ([]sum.Sum, error) {
+	rows, err := a.db.Query("SELECT sum FROM file_versions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sums := make([]sum.Sum, 0)
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, err
 		}
-		defer rows.Close()
+		sums = append(sums, s)
+	}
+	return sums, rows.Err()
+}
 
-		var indexIDs []int64
-		var prevSum sum.Sum
-		var slice []uint64
-		var indexID int64
-		var seq uint64
-		packID := make([]byte, sum.Size)
-		for i := 0; rows.Next(); i++ {
-			if err := rows.Scan(&indexID, &packID, &seq); err != nil {
-				return err
-			}
-			sum, err := sum.FromBytes(packID)
-			if err != nil {
-				return err
-			}
-			if prevSum != sum {
-				if i != 0 {
-					seqs := make([]uint64, len(slice))
-					copy(seqs, slice)
-					result = append(result, ZeroRefcount{prevSum, seqs})
-					slice = slice[:0]
-				}
-				prevSum = sum
-			}
-			slice = append(slice, seq)
-			indexIDs = append(indexIDs, indexID)
-		}
-		if len(slice) > 0 { // Don't forget the last slice
-			seqs := make([]uint64, len(slice))
-			copy(seqs, slice)
-			result = append(result, ZeroRefcount{prevSum, seqs})
+// ListPrefixVersionSums returns the sum of every file version with a name beginning
+// with prefix, in the order they were created.
+func (a *Adapter) ListPrefixVersionSums(prefix string) ([]sum.Sum, error) {
+	q := `SELECT sum FROM files JOIN file_versions ON files.id = file_versions.file
+	WHERE name >= ?`
+	args := []interface{}{prefix}
+	if end := successor(prefix); end != "" {
+		q += " AND name < ?"
+		args = append(args, end)
+	}
+	rows, err := a.db.Query(q+" ORDER BY file_versions.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sums := make([]sum.Sum, 0)
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
 		}
-		if err := rows.Err(); err != nil {
-			return err
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, err
 		}
+		sums = append(sums, s)
+	}
+	return sums, rows.Err()
+}
 
-		// Set the delete marker on all blocks picked out
-		q = "UPDATE indexes SET delete_marker = 1 WHERE id = ?"
-		for _, id := range indexIDs {
-			if _, err := tx.Exec(q, id); err != nil {
-				return err
-			}
+// SetPackETag records the ETag reported by the store for a packfile. Returns
+// ErrNotFound if the packfile does not exist.
+func (a *Adapter) SetPackETag(s sum.Sum, etag string) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := setPackETag(tx, s, etag); err != nil {
+			return err
 		}
-
-		return nil
+		return a.logOp(tx, op{Type: opSetPackETag, Sums: [][]byte{s[:]}, ETag: etag})
 	})
+}
 
-	return result, nil
+func setPackETag(tx *sql.Tx, s sum.Sum, etag string) error {
+	res, err := tx.Exec("UPDATE packs SET etag = ? WHERE sum = ?", etag, s[:])
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-// UpdateIndex overwrites the contents of a pack index with a new one. The map m
-// specifies the mapping from the sequence numbers of the new index to the sequence
-// numbers of the old index. Any sequences in the old index which are not re-mapped will
-// be deleted when DeletePackIndex is called on the old index.
-func (a *Adapter) UpdateIndex(newIndex object.PackIndex, createdAt time.Time, oldIndexSum sum.Sum, m map[uint64]uint64) error {
+// MarkPackDegraded flags a packfile as degraded. Chunks in a degraded packfile are
+// not reported as existing by ChunksExist. Returns ErrNotFound if the packfile does
+// not exist.
+func (a *Adapter) MarkPackDegraded(s sum.Sum, at time.Time) error {
 	return a.update(func(tx *sql.Tx) error {
-		newPackID, err := insertPackfile(tx, newIndex, createdAt.UTC())
-		if err != nil {
-			return fmt.Errorf("insertPackfile: %w", err)
-		}
-
-		var oldPackID uint64
-		q := "SELECT id FROM packs WHERE sum = ?"
-		row := tx.QueryRow(q, oldIndexSum[:])
-		if err := row.Scan(&oldPackID); err != nil {
-			return fmt.Errorf("getting old pack row ID: %w", err)
-		}
-
-		q = `
-		UPDATE indexes 
-		SET pack = ?, sequence = ?, offset = ? 
-		WHERE pack = ? AND sequence = ?
-		`
-		for _, block := range newIndex.Blocks {
-			oldSeq, ok := m[block.Sequence]
-			if !ok {
-				return fmt.Errorf("no sequence mapping for block %d", block.Sequence)
-			}
-			_, err = tx.Exec(q, newPackID, block.Sequence, block.Offset, oldPackID, oldSeq)
-			if err != nil {
-				return fmt.Errorf("updating pack index: %w", err)
-			}
+		if err := markPackDegraded(tx, s, at); err != nil {
+			return err
 		}
+		return a.logOp(tx, op{Type: opMarkPackDegraded, Sums: [][]byte{s[:]}, Time: at.UnixNano()})
+	})
+}
 
+func markPackDegraded(tx *sql.Tx, s sum.Sum, at time.Time) error {
+	q := "UPDATE packs SET degraded_at = ? WHERE sum = ? AND degraded_at = 0"
+	res, err := tx.Exec(q, at.UTC().UnixNano(), s[:])
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
 		return nil
-	})
+	}
+	var id int64
+	if err := tx.QueryRow("SELECT id FROM packs WHERE sum = ?", s[:]).Scan(&id); err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
 }
 
-// DeletePackIndex deletes a pack index from the database.
-func (a *Adapter) DeletePackIndex(sum sum.Sum) error {
+// InsertStoreEvent records a notification received from the object store.
+func (a *Adapter) InsertStoreEvent(key string, name string, eventTime time.Time, receivedAt time.Time) error {
 	return a.update(func(tx *sql.Tx) error {
-		// Will also delete corresponding rows in indexes table because the FK has
-		// ON DELETE CASCADE set.
-		q := "DELETE FROM packs WHERE sum = ?"
-		_, err := tx.Exec(q, sum[:])
+		q := insertOne("store_events", []string{"key", "name", "event_time", "received_at"})
+		_, err := tx.Exec(q, key, name, eventTime.UTC().UnixNano(), receivedAt.UTC().UnixNano())
 		return err
 	})
 }
@@ -855,42 +3083,357 @@ func (a *Adapter) GetVacuum(id string) (Vacuum, error) {
 	return Vacuum{id, VacuumStatus(status), startedAt, completedAt}, nil
 }
 
-// Stats store high-level statistics for the server -- number of file, number of file
-// versions, total size in bytes of all files, and total size of data stored. 
+// ListVacuums returns up to limit of the most recently started vacuums, in descending
+// order of start time.
+func (a *Adapter) ListVacuums(limit uint64) ([]Vacuum, error) {
+	q := "SELECT id, status, started_at, completed_at FROM vacuums ORDER BY started_at DESC LIMIT ?"
+	rows, err := a.db.Query(q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vacuums := make([]Vacuum, 0)
+	for rows.Next() {
+		var v Vacuum
+		var status int
+		if err := rows.Scan(&v.ID, &status, &v.StartedAt, &v.CompletedAt); err != nil {
+			return nil, err
+		}
+		v.Status = VacuumStatus(status)
+		vacuums = append(vacuums, v)
+	}
+	return vacuums, rows.Err()
+}
+
+// Share is a read-only link to the files under a prefix.
+type Share struct {
+	ID        string
+	Prefix    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// InsertShare inserts a share for prefix, identified by the hash of its token. Returns
+// the share's ID.
+func (a *Adapter) InsertShare(tokenHash [32]byte, prefix string, createdAt time.Time, expiresAt time.Time) (string, error) {
+	id := xid.New().String()
+	err := a.update(func(tx *sql.Tx) error {
+		if err := insertShare(tx, id, tokenHash[:], prefix, createdAt, expiresAt); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{
+			Type:    opInsertShare,
+			UID:     id,
+			Data:    tokenHash[:],
+			Name:    prefix,
+			Time:    createdAt.UnixNano(),
+			Expires: expiresAt.UnixNano(),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+const selectShare = "SELECT id, prefix, created_at, expires_at FROM shares"
+
+func scanShare(row interface{ Scan(...interface{}) error }) (Share, error) {
+	var share Share
+	var createdAt, expiresAt int64
+	if err := row.Scan(&share.ID, &share.Prefix, &createdAt, &expiresAt); err != nil {
+		return Share{}, err
+	}
+	share.CreatedAt = time.Unix(0, createdAt).UTC()
+	share.ExpiresAt = time.Unix(0, expiresAt).UTC()
+	return share, nil
+}
+
+// GetShare returns the share with a given token hash, including shares which have
+// expired. Returns ErrNotFound if the share does not exist.
+func (a *Adapter) GetShare(tokenHash [32]byte) (Share, error) {
+	share, err := scanShare(a.db.QueryRow(selectShare+" WHERE token_hash = ?", tokenHash[:]))
+	if err == sql.ErrNoRows {
+		return Share{}, ErrNotFound
+	}
+	return share, err
+}
+
+// ListShares returns every share in descending order of creation time.
+func (a *Adapter) ListShares() ([]Share, error) {
+	rows, err := a.db.Query(selectShare + " ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shares := make([]Share, 0)
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// DeleteShare deletes a share, revoking its link. Returns ErrNotFound if the share does
+// not exist.
+func (a *Adapter) DeleteShare(id string) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := deleteShare(tx, id); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opDeleteShare, UID: id})
+	})
+}
+
+func insertShare(tx *sql.Tx, id string, tokenHash []byte, prefix string, createdAt time.Time, expiresAt time.Time) error {
+	q := insertOne("shares", []string{"id", "token_hash", "prefix", "created_at", "expires_at"})
+	_, err := tx.Exec(q, id, tokenHash, prefix, createdAt.UTC().UnixNano(), expiresAt.UTC().UnixNano())
+	return err
+}
+
+func deleteShare(tx *sql.Tx, id string) error {
+	res, err := tx.Exec("DELETE FROM shares WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Stats store high-level statistics for the server -- number of files, number of file
+// versions, total size in bytes of all files, number of chunks and total size of chunk
+// data stored, and number of packfiles and their total size.
 type Stats struct {
 	NumFiles        uint64
 	NumFileVersions uint64
 	TotalFilesSize  uint64
+	NumChunks       uint64
 	TotalDataSize   uint64
+	NumPacks        uint64
+	TotalPacksSize  uint64
+}
+
+// Usage stores running totals of the file versions added and deleted. The totals only
+// increase, so the usage over a period is the difference between the totals at its
+// start and end.
+type Usage struct {
+	VersionsAdded   uint64
+	BytesAdded      uint64
+	VersionsDeleted uint64
+	BytesDeleted    uint64
+}
+
+// Sub returns the usage between u and an earlier usage v.
+func (u Usage) Sub(v Usage) Usage {
+	return Usage{
+		VersionsAdded:   u.VersionsAdded - v.VersionsAdded,
+		BytesAdded:      u.BytesAdded - v.BytesAdded,
+		VersionsDeleted: u.VersionsDeleted - v.VersionsDeleted,
+		BytesDeleted:    u.BytesDeleted - v.BytesDeleted,
+	}
 }
 
-// GetServerStats returns the Stats for the server.
+// GetServerStats returns the Stats for the server. The statistics are maintained by
+// the database as rows are inserted and deleted, so this does not scan any tables.
 func (a *Adapter) GetServerStats() (Stats, error) {
-	var numFiles uint64
-	row := a.db.QueryRow("SELECT count(*) FROM files")
-	if err := row.Scan(&numFiles); err != nil {
+	q := `
+	SELECT num_files, num_file_versions, total_files_size, num_chunks, total_data_size,
+		num_packs, total_packs_size
+	FROM stats
+	`
+	var s Stats
+	row := a.db.QueryRow(q)
+	err := row.Scan(
+		&s.NumFiles, &s.NumFileVersions, &s.TotalFilesSize, &s.NumChunks, &s.TotalDataSize,
+		&s.NumPacks, &s.TotalPacksSize,
+	)
+	if err != nil {
 		return Stats{}, err
 	}
+	return s, nil
+}
 
-	var numFileVersions uint64
-	row = a.db.QueryRow("SELECT count(*) FROM file_versions")
-	if err := row.Scan(&numFileVersions); err != nil {
-		return Stats{}, err
+// ChunkReport summarizes the packfiles created, and the file versions uploaded, in a
+// period.
+type ChunkReport struct {
+	// ChunkSizes is a histogram of the uncompressed sizes of the chunks in the new
+	// packfiles, and StoredSize their total compressed size.
+	ChunkSizes []Bucket
+	StoredSize uint64
+	// PackSizes is a histogram of the sizes of the new packfiles.
+	PackSizes       []Bucket
+	NumFileVersions uint64
+	FilesSize       uint64
+}
+
+// Bucket is the number of values in a bucket of a histogram, and their sum.
+type Bucket struct {
+	Count uint64
+	Total uint64
+}
+
+// GetChunkReport returns a ChunkReport for the period from since until until. The
+// period is unbounded at either end whose time is zero. Histograms have a bucket for
+// the values less than each of their bounds, which must be in increasing order, and
+// a final bucket for the values of at least the last bound. Packfiles rewritten by a
+// vacuum count as created at the time of the vacuum.
+func (a *Adapter) GetChunkReport(since time.Time, until time.Time, chunkBounds []uint64, packBounds []uint64) (ChunkReport, error) {
+	from, to := int64(0), int64(math.MaxInt64)
+	if !since.IsZero() {
+		from = since.UnixNano()
+	}
+	if !until.IsZero() {
+		to = until.UnixNano()
 	}
 
-	var totalFilesSize uint64
-	row = a.db.QueryRow("SELECT coalesce(sum(size), 0) FROM file_versions")
-	if err := row.Scan(&totalFilesSize); err != nil {
-		return Stats{}, err
+	var r ChunkReport
+	var err error
+	chunks := "indexes JOIN packs ON packs.id = indexes.pack WHERE packs.created_at >= ? AND packs.created_at < ?"
+	if r.ChunkSizes, err = a.histogram("indexes.chunk_size", chunks, chunkBounds, from, to); err != nil {
+		return ChunkReport{}, fmt.Errorf("chunk sizes: %w", err)
+	}
+	q := "SELECT coalesce(sum(indexes.size), 0) FROM " + chunks
+	if err = a.db.QueryRow(q, from, to).Scan(&r.StoredSize); err != nil {
+		return ChunkReport{}, fmt.Errorf("stored size: %w", err)
 	}
+	packs := "packs WHERE created_at >= ? AND created_at < ?"
+	if r.PackSizes, err = a.histogram("size", packs, packBounds, from, to); err != nil {
+		return ChunkReport{}, fmt.Errorf("packfile sizes: %w", err)
+	}
+	q = "SELECT count(*), coalesce(sum(size), 0) FROM file_versions WHERE created_at >= ? AND created_at < ?"
+	if err = a.db.QueryRow(q, from, to).Scan(&r.NumFileVersions, &r.FilesSize); err != nil {
+		return ChunkReport{}, fmt.Errorf("file versions: %w", err)
+	}
+	return r, nil
+}
 
-	var totalDataSize uint64
-	row = a.db.QueryRow("SELECT coalesce(sum(size), 0) FROM indexes")
-	if err := row.Scan(&totalDataSize); err != nil {
-		return Stats{}, err
+// histogram counts and sums the values of the expression expr over the rows selected
+// by from, a FROM clause without the FROM keyword, bucketed by bounds.
+func (a *Adapter) histogram(expr string, from string, bounds []uint64, args ...interface{}) ([]Bucket, error) {
+	var bucket strings.Builder
+	bucket.WriteString("CASE")
+	for i, b := range bounds {
+		fmt.Fprintf(&bucket, " WHEN %s < %d THEN %d", expr, b, i)
+	}
+	fmt.Fprintf(&bucket, " ELSE %d END", len(bounds))
+	q := fmt.Sprintf("SELECT %s AS bucket, count(*), sum(%s) FROM %s GROUP BY bucket", bucket.String(), expr, from)
+	rows, err := a.db.Query(q, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
+	res := make([]Bucket, len(bounds)+1)
+	for rows.Next() {
+		var i int
+		var b Bucket
+		if err := rows.Scan(&i, &b.Count, &b.Total); err != nil {
+			return nil, err
+		}
+		res[i] = b
+	}
+	return res, rows.Err()
+}
+
+// GetUsage returns the current usage totals.
+func (a *Adapter) GetUsage() (Usage, error) {
+	q := "SELECT versions_added, bytes_added, versions_deleted, bytes_deleted FROM stats"
+	var u Usage
+	err := a.db.QueryRow(q).Scan(&u.VersionsAdded, &u.BytesAdded, &u.VersionsDeleted, &u.BytesDeleted)
+	return u, err
+}
+
+// UsageReport records the usage totals at the time a usage report was sent.
+type UsageReport struct {
+	CreatedAt time.Time
+	Usage     Usage
+}
+
+// InsertUsageReport records that a usage report was sent at createdAt, covering the
+// usage up to the given totals.
+func (a *Adapter) InsertUsageReport(r UsageReport) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := insertUsageReport(tx, r); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opInsertUsageReport, Time: r.CreatedAt.UnixNano(), Usage: &r.Usage})
+	})
+}
+
+func insertUsageReport(tx *sql.Tx, r UsageReport) error {
+	q := insertOne("usage_reports", []string{"created_at", "versions_added", "bytes_added", "versions_deleted", "bytes_deleted"})
+	u := r.Usage
+	_, err := tx.Exec(q, r.CreatedAt.UnixNano(), u.VersionsAdded, u.BytesAdded, u.VersionsDeleted, u.BytesDeleted)
+	return err
+}
+
+// GetLastUsageReport returns the most recent usage report. Returns ErrNotFound if no
+// report has been sent.
+func (a *Adapter) GetLastUsageReport() (UsageReport, error) {
+	q := `
+	SELECT created_at, versions_added, bytes_added, versions_deleted, bytes_deleted
+	FROM usage_reports
+	ORDER BY id DESC
+	LIMIT 1
+	`
+	var r UsageReport
+	var createdAt int64
+	err := a.db.QueryRow(q).Scan(
+		&createdAt, &r.Usage.VersionsAdded, &r.Usage.BytesAdded, &r.Usage.VersionsDeleted, &r.Usage.BytesDeleted,
+	)
+	if err == sql.ErrNoRows {
+		return UsageReport{}, ErrNotFound
+	}
+	if err != nil {
+		return UsageReport{}, err
+	}
+	r.CreatedAt = time.Unix(0, createdAt).UTC()
+	return r, nil
+}
+
+// NamespaceStats stores statistics for the files in a namespace. A file's namespace
+// is its top-level directory, e.g. "/photos/", or "/" for files in the root directory.
+type NamespaceStats struct {
+	Namespace       string
+	NumFiles        uint64
+	NumFileVersions uint64
+	TotalFilesSize  uint64
+}
 
-	return Stats{numFiles, numFileVersions, totalFilesSize, totalDataSize}, nil
+// GetNamespaceStats returns the NamespaceStats of every namespace containing at least
+// one file, in order of namespace.
+func (a *Adapter) GetNamespaceStats() ([]NamespaceStats, error) {
+	q := `
+	SELECT CASE
+		WHEN instr(substr(name, 2), '/') > 0 THEN substr(name, 1, instr(substr(name, 2), '/') + 1)
+		ELSE '/'
+	END AS namespace, count(DISTINCT files.id), count(*), coalesce(sum(size), 0)
+	FROM files JOIN file_versions ON files.id = file_versions.file
+	GROUP BY namespace
+	ORDER BY namespace
+	`
+	rows, err := a.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make([]NamespaceStats, 0)
+	for rows.Next() {
+		var ns NamespaceStats
+		if err := rows.Scan(&ns.Namespace, &ns.NumFiles, &ns.NumFileVersions, &ns.TotalFilesSize); err != nil {
+			return nil, err
+		}
+		stats = append(stats, ns)
+	}
+	return stats, rows.Err()
 }
 
 func insertOne(table string, cols []string) string {
@@ -901,3 +3444,40 @@ func insertOne(table string, cols []string) string {
 		table, strings.Join(cols, ","), v,
 	)
 }
+
+// maxInsertParams is the largest number of parameters bound to a single INSERT
+// statement by insertMany, within SQLite's default limit of 999.
+const maxInsertParams = 999
+
+// insertMany inserts rows into a table with multi-row INSERT statements. args holds the
+// values of cols for each row in turn. Full batches reuse a single prepared statement.
+func insertMany(tx *sql.Tx, table string, cols []string, args []interface{}) error {
+	batch := maxInsertParams / len(cols) * len(cols)
+	if len(args) >= batch {
+		stmt, err := tx.Prepare(insertRows(table, cols, batch/len(cols)))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for len(args) >= batch {
+			if _, err := stmt.Exec(args[:batch]...); err != nil {
+				return err
+			}
+			args = args[batch:]
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(insertRows(table, cols, len(args)/len(cols)), args...)
+	return err
+}
+
+// insertRows returns an INSERT statement for n rows of cols.
+func insertRows(table string, cols []string, n int) string {
+	row := "(" + strings.Repeat("?,", len(cols)-1) + "?)"
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES %s",
+		table, strings.Join(cols, ","), strings.Repeat(row+",", n-1)+row,
+	)
+}
@@ -1,14 +1,23 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/jotfs/jotfs/internal/compress"
+	"github.com/jotfs/jotfs/internal/id"
 	"github.com/jotfs/jotfs/internal/object"
 	"github.com/jotfs/jotfs/internal/sum"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/xid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -83,7 +92,7 @@ func TestPackIndex(t *testing.T) {
 		Versioned: true,
 	}
 	fs0 := sum.Compute([]byte{0})
-	err = db.InsertFile(file, fs0)
+	err = db.InsertFile(file, fs0, nil, nil, Precondition{})
 	assert.NoError(t, err)
 
 	// InsertFile -- error if name is empty
@@ -93,7 +102,7 @@ func TestPackIndex(t *testing.T) {
 		Chunks:    chunks,
 	}
 	fs1 := sum.Compute([]byte{1})
-	err = db.InsertFile(file, fs1)
+	err = db.InsertFile(file, fs1, nil, nil, Precondition{})
 	assert.Error(t, err)
 
 	// InsertFile -- error if time is zero
@@ -103,7 +112,7 @@ func TestPackIndex(t *testing.T) {
 		Chunks:    chunks,
 	}
 	fs2 := sum.Compute([]byte{2})
-	err = db.InsertFile(file, fs2)
+	err = db.InsertFile(file, fs2, nil, nil, Precondition{})
 	assert.Error(t, err)
 
 	// InsertFile -- no chunks is fine
@@ -113,7 +122,7 @@ func TestPackIndex(t *testing.T) {
 		Chunks:    []object.Chunk{},
 	}
 	fs3 := sum.Compute([]byte{3})
-	err = db.InsertFile(file, fs3)
+	err = db.InsertFile(file, fs3, nil, nil, Precondition{})
 	assert.NoError(t, err)
 
 	// InsertFile -- error if chunk does not exist
@@ -122,10 +131,56 @@ func TestPackIndex(t *testing.T) {
 		CreatedAt: time.Now(),
 		Chunks:    []object.Chunk{{Sequence: 0, Size: 100, Sum: sum.Sum{}}},
 	}
-	err = db.InsertFile(file, sum.Compute([]byte{4}))
+	err = db.InsertFile(file, sum.Compute([]byte{4}), nil, nil, Precondition{})
 	assert.Error(t, err)
 }
 
+func TestInsertBatches(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A packfile with more blocks than fit in a single INSERT statement
+	blocks := make([]object.BlockInfo, 300)
+	for i := range blocks {
+		blocks[i] = object.BlockInfo{
+			Sum:       sum.Compute([]byte(strconv.Itoa(i))),
+			ChunkSize: 10,
+			Sequence:  uint64(i),
+			Offset:    uint64(1 + 20*i),
+			Size:      20,
+			Mode:      compress.None,
+		}
+	}
+	pack := object.PackIndex{Sum: sum.Compute([]byte("pack")), Blocks: blocks, Size: 6001}
+	assert.NoError(t, db.InsertPackIndex(pack, time.Now()))
+	exists, err := db.ChunksExist([]sum.Sum{blocks[0].Sum, blocks[299].Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true}, exists)
+
+	// A file referencing each chunk twice
+	var chunks []object.Chunk
+	for i := 0; i < 2*len(blocks); i++ {
+		chunks = append(chunks, object.Chunk{Sequence: uint64(i), Size: 10, Sum: blocks[i%len(blocks)].Sum})
+	}
+	file := object.File{Name: "big.txt", CreatedAt: time.Now(), Chunks: chunks, Versioned: true}
+	fileSum := sum.Compute(file.MarshalBinary())
+	assert.NoError(t, db.InsertFile(file, fileSum, nil, nil, Precondition{}))
+	indices, err := db.GetFileChunks(fileSum)
+	assert.NoError(t, err)
+	assert.Len(t, indices, len(chunks))
+	for i, idx := range indices {
+		assert.Equal(t, uint64(i), idx.Sequence)
+		assert.Equal(t, blocks[i%len(blocks)], idx.Block)
+	}
+	var refs, rows int
+	q := "SELECT sum(refcount), count(*) FROM indexes WHERE refcount = 2"
+	assert.NoError(t, db.db.QueryRow(q).Scan(&refs, &rows))
+	assert.Equal(t, 2*len(blocks), refs)
+	assert.Equal(t, len(blocks), rows)
+}
+
 func insertFile(t *testing.T, db *Adapter, name string) (sum.Sum, object.File) {
 	chunks := []object.Chunk{
 		{Sequence: 0, Size: block0.ChunkSize, Sum: block0.Sum},
@@ -138,7 +193,7 @@ func insertFile(t *testing.T, db *Adapter, name string) (sum.Sum, object.File) {
 		Versioned: true,
 	}
 	s := sum.Compute(file.MarshalBinary())
-	if err := db.InsertFile(file, s); err != nil {
+	if err := db.InsertFile(file, s, nil, nil, Precondition{}); err != nil {
 		t.Fatal(err)
 	}
 	return s, file
@@ -149,6 +204,7 @@ func TestGetFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	db.SetIDGenerator(&seqGenerator{})
 	createdAt := time.Now().UTC()
 	if err = db.InsertPackIndex(index, createdAt); err != nil {
 		t.Fatal(err)
@@ -157,9 +213,9 @@ func TestGetFile(t *testing.T) {
 	s1, f1 := insertFile(t, db, "/test1")
 	s2, f2 := insertFile(t, db, "/data/test2")
 	s3, f3 := insertFile(t, db, "/data/test2")
-	info1 := FileInfo{Name: f1.Name, CreatedAt: f1.CreatedAt, Size: f1.Size(), Sum: s1, Versioned: f1.Versioned}
-	info2 := FileInfo{Name: f2.Name, CreatedAt: f2.CreatedAt, Size: f2.Size(), Sum: s2, Versioned: f2.Versioned}
-	info3 := FileInfo{Name: f3.Name, CreatedAt: f3.CreatedAt, Size: f3.Size(), Sum: s3, Versioned: f3.Versioned}
+	info1 := FileInfo{Name: f1.Name, CreatedAt: f1.CreatedAt, Size: f1.Size(), Sum: s1, Versioned: f1.Versioned, VersionID: "2"}
+	info2 := FileInfo{Name: f2.Name, CreatedAt: f2.CreatedAt, Size: f2.Size(), Sum: s2, Versioned: f2.Versioned, VersionID: "3"}
+	info3 := FileInfo{Name: f3.Name, CreatedAt: f3.CreatedAt, Size: f3.Size(), Sum: s3, Versioned: f3.Versioned, VersionID: "4"}
 
 	// GetFile
 	fg1, err := db.GetFile(s1)
@@ -239,6 +295,16 @@ func TestGetFile(t *testing.T) {
 	assert.Equal(t, ErrNotFound, err)
 }
 
+// seqGenerator generates sequential integer IDs.
+type seqGenerator struct {
+	n int
+}
+
+func (g *seqGenerator) New(time.Time) (string, error) {
+	g.n++
+	return strconv.Itoa(g.n), nil
+}
+
 func TestVacuum(t *testing.T) {
 	db, err := EmptyInMemory()
 	if err != nil {
@@ -278,6 +344,20 @@ func TestVacuum(t *testing.T) {
 	// No Error from UpdateVacuum if id does not exist
 	err = db.UpdateVacuum("", completedAt, VacuumOK)
 	assert.NoError(t, err)
+
+	// List vacuums, most recent first
+	id2, err := db.InsertVacuum(startedAt.Add(time.Second))
+	assert.NoError(t, err)
+	vacuums, err := db.ListVacuums(10)
+	assert.NoError(t, err)
+	if assert.Len(t, vacuums, 2) {
+		assert.Equal(t, id2, vacuums[0].ID)
+		assert.Equal(t, VacuumRunning, vacuums[0].Status)
+		assert.Equal(t, vac2, vacuums[1])
+	}
+	vacuums, err = db.ListVacuums(1)
+	assert.NoError(t, err)
+	assert.Len(t, vacuums, 1)
 }
 
 func TestServerStats(t *testing.T) {
@@ -300,6 +380,68 @@ func TestServerStats(t *testing.T) {
 	assert.Equal(t, uint64(1), stats.NumFiles)
 	assert.NotZero(t, stats.TotalFilesSize)
 	assert.NotZero(t, stats.TotalDataSize)
+	assert.Equal(t, scanStats(t, db), stats)
+
+	// Stats are kept up to date as files and packfiles are deleted
+	s2, _ := insertFile(t, db, "abc")
+	assert.NoError(t, db.DeleteFile(s2))
+	stats, err = db.GetServerStats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), stats.NumFileVersions)
+	assert.Equal(t, scanStats(t, db), stats)
+
+	other := object.PackIndex{Sum: sum.Compute([]byte("other")), Blocks: []object.BlockInfo{block0}, Size: 50}
+	assert.NoError(t, db.InsertPackIndex(other, time.Now()))
+	stats, err = db.GetServerStats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.NumPacks)
+	assert.Equal(t, uint64(3), stats.NumChunks)
+	assert.Equal(t, scanStats(t, db), stats)
+	assert.NoError(t, db.DeletePackIndex(other.Sum))
+	stats, err = db.GetServerStats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), stats.NumPacks)
+	assert.Equal(t, scanStats(t, db), stats)
+
+	// Namespace stats
+	insertFile(t, db, "/photos/a.jpg")
+	insertFile(t, db, "/photos/2020/b.jpg")
+	insertFile(t, db, "/photos/2020/b.jpg")
+	insertFile(t, db, "/c.txt")
+	nss, err := db.GetNamespaceStats()
+	assert.NoError(t, err)
+	if assert.Len(t, nss, 2) {
+		assert.Equal(t, "/", nss[0].Namespace)
+		assert.Equal(t, uint64(2), nss[0].NumFiles)
+		assert.Equal(t, uint64(2), nss[0].NumFileVersions)
+		assert.Equal(t, "/photos/", nss[1].Namespace)
+		assert.Equal(t, uint64(2), nss[1].NumFiles)
+		assert.Equal(t, uint64(3), nss[1].NumFileVersions)
+		assert.NotZero(t, nss[1].TotalFilesSize)
+	}
+}
+
+// scanStats computes the server stats by scanning the tables.
+func scanStats(t *testing.T, db *Adapter) Stats {
+	q := `
+	SELECT
+		(SELECT count(*) FROM files),
+		(SELECT count(*) FROM file_versions),
+		(SELECT coalesce(sum(size), 0) FROM file_versions),
+		(SELECT count(*) FROM indexes),
+		(SELECT coalesce(sum(size), 0) FROM indexes),
+		(SELECT count(*) FROM packs),
+		(SELECT coalesce(sum(size), 0) FROM packs)
+	`
+	var s Stats
+	err := db.db.QueryRow(q).Scan(
+		&s.NumFiles, &s.NumFileVersions, &s.TotalFilesSize, &s.NumChunks, &s.TotalDataSize,
+		&s.NumPacks, &s.TotalPacksSize,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
 }
 
 func TestDeletePackIndex(t *testing.T) {
@@ -317,3 +459,1356 @@ func TestDeletePackIndex(t *testing.T) {
 	err = db.DeletePackIndex(sum.Sum{})
 	assert.NoError(t, err)
 }
+
+func TestDegradedPack(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+
+	info, err := db.GetPackInfo(index.Sum)
+	assert.NoError(t, err)
+	assert.Equal(t, index.Size, info.Size)
+	assert.True(t, info.DegradedAt.IsZero())
+
+	// Chunks in a degraded pack no longer exist
+	at := time.Now().UTC().Truncate(time.Second)
+	assert.NoError(t, db.MarkPackDegraded(index.Sum, at))
+	info, err = db.GetPackInfo(index.Sum)
+	assert.NoError(t, err)
+	assert.Equal(t, at, info.DegradedAt)
+	exists, err := db.ChunksExist([]sum.Sum{block0.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, exists)
+
+	// Marking a second time is a no-op
+	assert.NoError(t, db.MarkPackDegraded(index.Sum, time.Now()))
+
+	// Error if the pack does not exist
+	assert.Equal(t, ErrNotFound, db.MarkPackDegraded(sum.Sum{}, time.Now()))
+	_, err = db.GetPackInfo(sum.Sum{})
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, db.InsertStoreEvent("a.pack", "ObjectRemoved:Delete", time.Now(), time.Now()))
+}
+
+func TestPackETag(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	info, err := db.GetPackInfo(index.Sum)
+	assert.NoError(t, err)
+	assert.Empty(t, info.ETag)
+
+	assert.NoError(t, db.SetPackETag(index.Sum, "abc"))
+	packs, err := db.ListPacks()
+	assert.NoError(t, err)
+	if assert.Len(t, packs, 1) {
+		assert.Equal(t, index.Sum, packs[0].Sum)
+		assert.Equal(t, index.Size, packs[0].Size)
+		assert.Equal(t, "abc", packs[0].ETag)
+	}
+
+	assert.Equal(t, ErrNotFound, db.SetPackETag(sum.Sum{}, "abc"))
+}
+
+func TestChunkFilter(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := db.ChunkFilterStats()
+	assert.False(t, ok)
+
+	// Chunks inserted before the filter is built are read from the database
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	db.EnableChunkFilter()
+	exists, err := db.ChunksExist([]sum.Sum{block0.Sum, {}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, exists)
+	stats, ok := db.ChunkFilterStats()
+	assert.True(t, ok)
+	assert.Equal(t, ChunkFilterStats{
+		Chunks:   2,
+		Capacity: minFilterCapacity,
+		Size:     minFilterCapacity * filterBitsPerChunk / 8,
+		Builds:   1,
+		Lookups:  2,
+		Skipped:  1,
+	}, stats)
+
+	// Missing chunks are not looked up in the database
+	exists, err = db.ChunksExist([]sum.Sum{{1}, {2}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, false}, exists)
+	stats, _ = db.ChunkFilterStats()
+	assert.EqualValues(t, 3, stats.Skipped)
+
+	// Chunks inserted after the filter is built are added to it
+	s2 := sum.Compute([]byte("chunk"))
+	block2 := object.BlockInfo{Sum: s2, ChunkSize: 5, Offset: 1, Size: 6, Mode: compress.None}
+	index2 := object.PackIndex{Sum: sum.Compute([]byte("pack")), Blocks: []object.BlockInfo{block2}, Size: 10}
+	assert.NoError(t, db.InsertPackIndex(index2, time.Now()))
+	exists, err = db.ChunksExist([]sum.Sum{s2})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true}, exists)
+
+	// Deleted chunks remain in the filter, but are found not to exist
+	assert.NoError(t, db.DeletePackIndex(index2.Sum))
+	exists, err = db.ChunksExist([]sum.Sum{s2})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, exists)
+	stats, _ = db.ChunkFilterStats()
+	assert.EqualValues(t, 3, stats.Chunks)
+	assert.EqualValues(t, 1, stats.FalsePositives)
+
+	// The filter is rebuilt from the database once it's over capacity
+	db.filter.stats.Capacity = 1
+	exists, err = db.ChunksExist([]sum.Sum{block1.Sum, s2})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, exists)
+	stats, _ = db.ChunkFilterStats()
+	assert.EqualValues(t, 2, stats.Builds)
+	assert.EqualValues(t, 2, stats.Chunks)
+	assert.EqualValues(t, minFilterCapacity, stats.Capacity)
+}
+
+func TestAlternateLocation(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	fileSum, _ := insertFile(t, db, "/a")
+
+	// Another packfile has a copy of the first chunk only
+	copy0 := block0
+	copy0.Offset = 10
+	other := object.PackIndex{Sum: sum.Compute([]byte("other")), Blocks: []object.BlockInfo{copy0}, Size: 50}
+	assert.NoError(t, db.InsertPackIndex(other, time.Now()))
+
+	// Chunks are read from their original packfile
+	chunks, err := db.GetFileChunks(fileSum)
+	assert.NoError(t, err)
+	assert.Equal(t, index.Sum, chunks[0].PackSum)
+
+	// The copy is used when the original packfile is degraded
+	assert.NoError(t, db.MarkPackDegraded(index.Sum, time.Now()))
+	chunks, err = db.GetFileChunks(fileSum)
+	assert.NoError(t, err)
+	assert.Equal(t, ChunkIndex{Sequence: 0, PackSum: other.Sum, Block: copy0}, chunks[0])
+	assert.Equal(t, index.Sum, chunks[1].PackSum)
+	assert.True(t, chunks[1].PackDegraded)
+}
+
+func TestUpgradeSchema(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Upgrading a database with the latest schema is a no-op
+	assert.NoError(t, db.UpgradeSchema())
+	migrations, err := db.Migrations()
+	assert.NoError(t, err)
+	assert.Len(t, migrations, len(schema))
+	for i, m := range migrations {
+		assert.Equal(t, i, m.Version)
+		assert.True(t, m.Applied)
+		assert.False(t, m.AppliedAt.IsZero())
+	}
+	assert.Equal(t, "base", migrations[0].Name)
+
+	// Database created before schema versioning has only the base schema
+	sdb, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&_fk=on", xid.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sdb.Exec(schema[0].query)
+	assert.NoError(t, err)
+	createdAt := time.Now()
+	_, err = sdb.Exec(
+		"INSERT INTO packs (sum, num_chunks, size, created_at) VALUES (?, ?, ?, ?)",
+		index.Sum[:], 1, index.Size, createdAt.UnixNano(),
+	)
+	assert.NoError(t, err)
+	old := NewAdapter(sdb)
+	migrations, err = old.Migrations()
+	assert.NoError(t, err)
+	assert.True(t, migrations[0].Applied)
+	assert.False(t, migrations[1].Applied)
+	applied, err := old.Migrate()
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(schema)-1)
+	assert.Equal(t, 1, applied[0].Version)
+
+	// Migrations applied before they were recorded have no time
+	migrations, err = old.Migrations()
+	assert.NoError(t, err)
+	assert.True(t, migrations[0].Applied)
+	assert.True(t, migrations[0].AppliedAt.IsZero())
+	assert.True(t, migrations[1].Applied)
+	assert.False(t, migrations[1].AppliedAt.IsZero())
+	applied, err = old.Migrate()
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	// Existing packfiles are given a legacy ID
+	info, err := old.GetPackInfo(index.Sum)
+	assert.NoError(t, err)
+	assert.Equal(t, id.Legacy(createdAt, index.Sum[:]), info.ID)
+
+	// Stats are initialized from existing rows
+	stats, err := old.GetServerStats()
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{NumPacks: 1, TotalPacksSize: index.Size}, stats)
+
+	// Database newer than supported
+	_, err = db.db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (1000, 'future', 1)")
+	assert.NoError(t, err)
+	assert.Error(t, db.UpgradeSchema())
+	_, err = db.Migrations()
+	assert.Error(t, err)
+}
+
+func TestInsertFileOnce(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.InsertPackIndex(index, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	chunks := []object.Chunk{{Sequence: 0, Size: block0.ChunkSize, Sum: block0.Sum}}
+	key := CreateKey{Name: "/a", Manifest: sum.Compute(block0.Sum[:]), IdempotencyKey: "abc"}
+
+	_, err = db.GetJournalledFile(key)
+	assert.Equal(t, ErrNotFound, err)
+
+	// First request creates the file version
+	f1 := object.File{Name: "/a", CreatedAt: time.Now().UTC(), Chunks: chunks}
+	s1 := sum.Compute(f1.MarshalBinary())
+	s, inserted, err := db.InsertFileOnce(f1, s1, nil, nil, Precondition{}, key)
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	assert.Equal(t, s1, s)
+
+	// A retry returns the original version
+	f2 := object.File{Name: "/a", CreatedAt: time.Now().UTC().Add(time.Second), Chunks: chunks}
+	s2 := sum.Compute(f2.MarshalBinary())
+	s, inserted, err = db.InsertFileOnce(f2, s2, nil, nil, Precondition{}, key)
+	assert.NoError(t, err)
+	assert.False(t, inserted)
+	assert.Equal(t, s1, s)
+	_, err = db.GetFile(s2)
+	assert.Equal(t, ErrNotFound, err)
+
+	s, err = db.GetJournalledFile(key)
+	assert.NoError(t, err)
+	assert.Equal(t, s1, s)
+
+	// Old entries are removed
+	assert.NoError(t, db.DeleteJournalBefore(time.Now().Add(time.Hour)))
+	_, err = db.GetJournalledFile(key)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestInsertPackIndexOnce(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ErrNotFound, db.GetJournalledPack("abc", index.Sum))
+
+	// The first upload inserts the index, and a retry does not
+	inserted, err := db.InsertPackIndexOnce(index, time.Now().UTC(), "abc")
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+	inserted, err = db.InsertPackIndexOnce(index, time.Now().UTC(), "abc")
+	assert.NoError(t, err)
+	assert.False(t, inserted)
+	packs, err := db.ListPacks()
+	assert.NoError(t, err)
+	assert.Len(t, packs, 1)
+	assert.NoError(t, db.GetJournalledPack("abc", index.Sum))
+	assert.Equal(t, ErrNotFound, db.GetJournalledPack("abc", sum.Sum{1}))
+	assert.Equal(t, ErrNotFound, db.GetJournalledPack("def", index.Sum))
+
+	// The entry is removed with the packfile
+	assert.NoError(t, db.DeletePackIndex(index.Sum))
+	assert.Equal(t, ErrNotFound, db.GetJournalledPack("abc", index.Sum))
+
+	// Old entries are removed
+	_, err = db.InsertPackIndexOnce(index, time.Now().UTC(), "abc")
+	assert.NoError(t, err)
+	assert.NoError(t, db.DeleteJournalBefore(time.Now().Add(time.Hour)))
+	assert.Equal(t, ErrNotFound, db.GetJournalledPack("abc", index.Sum))
+}
+
+func TestShares(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createdAt := time.Now().UTC()
+	expiresAt := createdAt.Add(time.Hour)
+	hash := [32]byte{1}
+	id, err := db.InsertShare(hash, "/data", createdAt, expiresAt)
+	assert.NoError(t, err)
+	_, err = db.InsertShare([32]byte{2}, "/other", createdAt.Add(time.Second), expiresAt)
+	assert.NoError(t, err)
+
+	share, err := db.GetShare(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, Share{ID: id, Prefix: "/data", CreatedAt: createdAt, ExpiresAt: expiresAt}, share)
+	_, err = db.GetShare([32]byte{3})
+	assert.Equal(t, ErrNotFound, err)
+
+	shares, err := db.ListShares()
+	assert.NoError(t, err)
+	if assert.Len(t, shares, 2) {
+		assert.Equal(t, "/other", shares[0].Prefix)
+		assert.Equal(t, share, shares[1])
+	}
+
+	// Expiry must be after creation
+	_, err = db.InsertShare([32]byte{4}, "/data", createdAt, createdAt)
+	assert.Error(t, err)
+
+	assert.NoError(t, db.DeleteShare(id))
+	_, err = db.GetShare(hash)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, db.DeleteShare(id))
+}
+
+func TestDeletePrefix(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	insertFile(t, db, "/data/a.txt")
+	insertFile(t, db, "/data/sub/b.txt")
+	insertFile(t, db, "/data/sub/b.txt")
+	insertFile(t, db, "/database.txt")
+	insertFile(t, db, "/c%/d.txt")
+	total, err := db.GetServerStats()
+	assert.NoError(t, err)
+	size := total.TotalFilesSize / total.NumFileVersions
+
+	stats, err := db.GetPrefixStats("/data/")
+	assert.NoError(t, err)
+	assert.Equal(t, PrefixStats{NumFiles: 2, NumFileVersions: 3, TotalFilesSize: 3 * size}, stats)
+
+	// Prefixes are not LIKE patterns
+	stats, err = db.GetPrefixStats("/c_/")
+	assert.NoError(t, err)
+	assert.Zero(t, stats.NumFiles)
+
+	// Delete in batches of two versions
+	deleted, stats, more, err := db.DeletePrefix("/data/", 2)
+	assert.NoError(t, err)
+	assert.True(t, more)
+	assert.Len(t, deleted, 2)
+	assert.Equal(t, PrefixStats{NumFiles: 1, NumFileVersions: 2, TotalFilesSize: 2 * size}, stats)
+
+	deleted, stats, more, err = db.DeletePrefix("/data/", 2)
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Len(t, deleted, 1)
+	assert.Equal(t, PrefixStats{NumFiles: 1, NumFileVersions: 1, TotalFilesSize: size}, stats)
+
+	infos, err := db.ListFiles("/", 0, 10, "", "", true)
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, "/database.txt", infos[0].Name)
+		assert.Equal(t, "/c%/d.txt", infos[1].Name)
+	}
+
+	// Nothing left to delete
+	deleted, stats, more, err = db.DeletePrefix("/data/", 2)
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Empty(t, deleted)
+	assert.Equal(t, PrefixStats{}, stats)
+}
+
+func TestRename(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	v1, _ := insertFile(t, db, "/a.txt")
+	v2, _ := insertFile(t, db, "/a.txt")
+	insertFile(t, db, "/b.txt")
+	insertFile(t, db, "/dir/c.txt")
+	insertFile(t, db, "/dir/sub/d.txt")
+	insertFile(t, db, "/other/c.txt")
+
+	names := func() []string {
+		infos, err := db.ListFiles("/", 0, 100, "", "", true)
+		assert.NoError(t, err)
+		seen := make(map[string]bool)
+		var res []string
+		for _, info := range infos {
+			if !seen[info.Name] {
+				res = append(res, info.Name)
+				seen[info.Name] = true
+			}
+		}
+		return res
+	}
+
+	// Every version is renamed and keeps its sum
+	assert.NoError(t, db.RenameFile("/a.txt", "/x.txt"))
+	for _, s := range []sum.Sum{v1, v2} {
+		info, err := db.GetFileInfo(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "/x.txt", info.Name)
+	}
+	assert.Equal(t, ErrNotFound, db.RenameFile("/a.txt", "/y.txt"))
+	assert.Equal(t, ErrExists, db.RenameFile("/x.txt", "/b.txt"))
+	count, err := db.CountFileVersions("/x.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	count, err = db.CountFileVersions("/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	// Prefix rename
+	ok := func(string) error { return nil }
+	_, err = db.RenamePrefix("/dir/", "/other/", ok)
+	assert.True(t, errors.Is(err, ErrExists))
+	checkErr := errors.New("invalid")
+	_, err = db.RenamePrefix("/dir/", "/new/", func(name string) error {
+		if name == "/new/sub/d.txt" {
+			return checkErr
+		}
+		return nil
+	})
+	assert.True(t, errors.Is(err, checkErr))
+	_, err = db.RenamePrefix("/missing/", "/new/", ok)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, []string{"/x.txt", "/b.txt", "/dir/c.txt", "/dir/sub/d.txt", "/other/c.txt"}, names())
+
+	n, err := db.RenamePrefix("/dir/", "/new/", ok)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"/x.txt", "/b.txt", "/new/c.txt", "/new/sub/d.txt", "/other/c.txt"}, names())
+}
+
+func TestListFilePage(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+
+	// Files share creation times so pages must not split on created_at alone
+	createdAt := time.Now().UTC()
+	insert := func(name string, numChunks int) {
+		chunks := []object.Chunk{{Sequence: 0, Size: block0.ChunkSize, Sum: block0.Sum}}
+		if numChunks == 2 {
+			chunks = append(chunks, object.Chunk{Sequence: 1, Size: block1.ChunkSize, Sum: block1.Sum})
+		}
+		file := object.File{Name: name, CreatedAt: createdAt, Chunks: chunks, Versioned: true}
+		file.CreatedAt = file.CreatedAt.Add(time.Duration(len(name) % 2))
+		assert.NoError(t, db.InsertFile(file, sum.Compute(append(file.MarshalBinary(), name...)), nil, nil, Precondition{}))
+	}
+	insert("/a.txt", 1)
+	insert("/data/b.txt", 2)
+	insert("/data/c.txt", 1)
+	insert("/data/sub/d.txt", 2)
+	insert("/data/sub/e.txt", 1)
+	insert("/data/x/f.txt", 2)
+	insert("/data2/g.txt", 1)
+
+	list := func(opts ListOptions) ([]string, []string) {
+		var names, prefixes []string
+		for {
+			page, err := db.ListFilePage(opts)
+			if !assert.NoError(t, err) {
+				return nil, nil
+			}
+			assert.True(t, uint64(len(page.Files)+len(page.Prefixes)) <= opts.Limit)
+			for _, info := range page.Files {
+				names = append(names, info.Name)
+			}
+			prefixes = append(prefixes, page.Prefixes...)
+			if page.Next == nil {
+				return names, prefixes
+			}
+			opts.After = page.Next
+		}
+	}
+
+	for _, limit := range []uint64{1, 2, 100} {
+		names, _ := list(ListOptions{Prefix: "/data/", Sort: SortName, Ascending: true, Limit: limit})
+		assert.Equal(t, []string{"/data/b.txt", "/data/c.txt", "/data/sub/d.txt", "/data/sub/e.txt", "/data/x/f.txt"}, names)
+
+		names, _ = list(ListOptions{Prefix: "/data", Sort: SortName, Limit: limit})
+		assert.Equal(t, []string{"/data2/g.txt", "/data/x/f.txt", "/data/sub/e.txt", "/data/sub/d.txt", "/data/c.txt", "/data/b.txt"}, names)
+
+		names, _ = list(ListOptions{Prefix: "/", Sort: SortCreatedAt, Ascending: true, Limit: limit})
+		assert.Len(t, names, 7)
+		assert.Equal(t, "/a.txt", names[0])
+
+		names, _ = list(ListOptions{Prefix: "/data/", Sort: SortSize, Limit: limit, Exclude: "*/x/*"})
+		assert.Equal(t, []string{"/data/sub/d.txt", "/data/b.txt", "/data/sub/e.txt", "/data/c.txt"}, names)
+
+		names, prefixes := list(ListOptions{Prefix: "/data/", Delimiter: "/", Sort: SortName, Ascending: true, Limit: limit})
+		assert.Equal(t, []string{"/data/b.txt", "/data/c.txt"}, names)
+		assert.Equal(t, []string{"/data/sub/", "/data/x/"}, prefixes)
+
+		names, prefixes = list(ListOptions{Prefix: "/", Delimiter: "/", Sort: SortName, Limit: limit})
+		assert.Equal(t, []string{"/a.txt"}, names)
+		assert.Equal(t, []string{"/data2/", "/data/"}, prefixes)
+	}
+
+	_, err = db.ListFilePage(ListOptions{Delimiter: "/", Sort: SortSize, Limit: 1})
+	assert.Error(t, err)
+}
+
+func TestSearchFiles(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	for i := 0; i < 10; i++ {
+		insertFile(t, db, fmt.Sprintf("/logs/%d.txt", i))
+	}
+	insertFile(t, db, "/other/3.txt")
+
+	// Only scans two rows at a time, so some pages are empty
+	opts := SearchOptions{
+		Prefix:  "/logs/",
+		Glob:    "*.txt",
+		Match:   func(name string) bool { return name == "/logs/3.txt" || name == "/logs/8.txt" },
+		Limit:   10,
+		MaxScan: 2,
+	}
+	var names []string
+	var pages int
+	for {
+		page, err := db.SearchFiles(opts)
+		assert.NoError(t, err)
+		for _, info := range page.Files {
+			names = append(names, info.Name)
+		}
+		pages++
+		if page.Next == nil {
+			break
+		}
+		opts.After = page.Next
+	}
+	assert.Equal(t, []string{"/logs/3.txt", "/logs/8.txt"}, names)
+	assert.Equal(t, 6, pages)
+
+	_, err = db.SearchFiles(SearchOptions{Match: opts.Match, Limit: 1})
+	assert.Error(t, err)
+}
+
+func TestMetadata(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	_, file := insertFile(t, db, "/a.txt")
+	file.Name = "/b.txt"
+	s := sum.Compute(append(file.MarshalBinary(), 'b'))
+	assert.NoError(t, db.InsertFile(file, s, map[string]string{"env": "prod", "owner": "ops"}, nil, Precondition{}))
+
+	info, err := db.GetFileInfo(s)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "owner": "ops"}, info.Metadata)
+
+	// Set and delete keys
+	m, err := db.SetMetadata(s, map[string]string{"env": "dev", "team": "x"}, []string{"owner"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "dev", "team": "x"}, m)
+
+	// The change is abandoned if the check fails
+	tooMany := errors.New("too many keys")
+	_, err = db.SetMetadata(s, map[string]string{"c": "3"}, nil, func(m map[string]string) error {
+		if len(m) > 2 {
+			return tooMany
+		}
+		return nil
+	})
+	assert.Equal(t, tooMany, err)
+	versions, err := db.GetFileVersions("/b.txt", 0, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "dev", "team": "x"}, versions[0].Metadata)
+
+	_, err = db.SetMetadata(sum.Compute([]byte("x")), map[string]string{"a": "1"}, nil, nil)
+	assert.Equal(t, ErrNotFound, err)
+
+	// Listings include the metadata, and may be filtered by it
+	page, err := db.ListFilePage(ListOptions{Sort: SortName, Ascending: true, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, page.Files, 2)
+	assert.Nil(t, page.Files[0].Metadata)
+	assert.Equal(t, "dev", page.Files[1].Metadata["env"])
+
+	opts := SearchOptions{Metadata: map[string]string{"env": "dev", "team": "x"}, Match: func(string) bool { return true }, Limit: 10, MaxScan: 10}
+	page, err = db.SearchFiles(opts)
+	assert.NoError(t, err)
+	assert.Len(t, page.Files, 1)
+	assert.Equal(t, "/b.txt", page.Files[0].Name)
+	opts.Metadata["team"] = "y"
+	page, err = db.SearchFiles(opts)
+	assert.NoError(t, err)
+	assert.Empty(t, page.Files)
+
+	// Metadata is deleted with its file version
+	assert.NoError(t, db.DeleteFile(s))
+	var n int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM file_metadata").Scan(&n))
+	assert.Equal(t, 0, n)
+}
+
+func TestFileChecksums(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	s0, file := insertFile(t, db, "/a.txt")
+	checksums, err := db.GetFileChecksums(s0)
+	assert.NoError(t, err)
+	assert.Empty(t, checksums)
+
+	file.Name = "/b.txt"
+	s := sum.Compute(file.MarshalBinary())
+	expected := map[string][]byte{"md5": {1, 2}, "sha1": {3, 4}}
+	assert.NoError(t, db.InsertFile(file, s, nil, expected, Precondition{}))
+	checksums, err = db.GetFileChecksums(s)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, checksums)
+	found, err := db.FindFileVersionByChecksum("sha1", []byte{3, 4})
+	assert.NoError(t, err)
+	assert.Equal(t, s, found)
+	_, err = db.FindFileVersionByChecksum("md5", []byte{3, 4})
+	assert.Equal(t, ErrNotFound, err)
+
+	// Checksums are deleted with their file version
+	assert.NoError(t, db.DeleteFile(s))
+	_, err = db.GetFileChecksums(s)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = db.FindFileVersionByChecksum("sha1", []byte{3, 4})
+	assert.Equal(t, ErrNotFound, err)
+	var n int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM file_checksums").Scan(&n))
+	assert.Equal(t, 0, n)
+
+	// GetChunkIndex finds chunks in healthy packfiles
+	idx, err := db.GetChunkIndex(block1.Sum)
+	assert.NoError(t, err)
+	assert.Equal(t, index.Sum, idx.PackSum)
+	assert.Equal(t, block1, idx.Block)
+	assert.NoError(t, db.MarkPackDegraded(index.Sum, time.Now()))
+	_, err = db.GetChunkIndex(block1.Sum)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestInlineFile(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("small file")
+	chunk := object.Chunk{Sequence: 0, Size: uint64(len(data)), Sum: sum.Compute(data)}
+	file := object.File{Name: "/a.txt", CreatedAt: time.Now().UTC(), Chunks: []object.Chunk{chunk}, Data: data}
+	s := sum.Compute(file.MarshalBinary())
+	assert.NoError(t, db.InsertFile(file, s, nil, nil, Precondition{}))
+
+	// The data is stored with the version, without a packfile
+	f, err := db.GetFile(s)
+	assert.NoError(t, err)
+	assert.Equal(t, file, f)
+	indices, err := db.GetFileChunks(s)
+	assert.NoError(t, err)
+	assert.Equal(t, []ChunkIndex{{Block: object.BlockInfo{Sum: chunk.Sum, ChunkSize: chunk.Size}, Inline: data}}, indices)
+	refs, err := db.GetFileChunkRefs(s)
+	assert.NoError(t, err)
+	assert.Equal(t, []ChunkRef{{Sum: chunk.Sum, Inline: data}}, refs)
+	info, err := db.GetFileInfo(s)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), info.Size)
+
+	// The data is deleted with its file version
+	assert.NoError(t, db.DeleteFile(s))
+	var n int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM file_inline").Scan(&n))
+	assert.Equal(t, 0, n)
+}
+
+func TestUsage(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetLastUsageReport()
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	insertFile(t, db, "/a.txt")
+	s2, file := insertFile(t, db, "/b.txt")
+	assert.NoError(t, db.DeleteFile(s2))
+	size := file.Size()
+	usage, err := db.GetUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{VersionsAdded: 2, BytesAdded: 2 * size, VersionsDeleted: 1, BytesDeleted: size}, usage)
+
+	// Reports record the totals when they were sent
+	createdAt := time.Now().UTC()
+	assert.NoError(t, db.InsertUsageReport(UsageReport{CreatedAt: createdAt, Usage: usage}))
+	insertFile(t, db, "/c.txt")
+	report, err := db.GetLastUsageReport()
+	assert.NoError(t, err)
+	assert.Equal(t, createdAt.UnixNano(), report.CreatedAt.UnixNano())
+	assert.Equal(t, usage, report.Usage)
+	current, err := db.GetUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{VersionsAdded: 1, BytesAdded: size}, current.Sub(report.Usage))
+}
+
+func TestVersionTags(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	s1, _ := insertFile(t, db, "/a.txt")
+	s2, _ := insertFile(t, db, "/a.txt")
+	s3, _ := insertFile(t, db, "/b.txt")
+
+	now := time.Now().UTC()
+	assert.NoError(t, db.TagVersion(s1, "golden", now))
+	assert.NoError(t, db.TagVersion(s1, "release-1.2", now))
+	assert.NoError(t, db.TagVersion(s3, "golden", now))
+	assert.Equal(t, ErrNotFound, db.TagVersion(sum.Sum{}, "golden", now))
+	info, err := db.GetTaggedVersion("/a.txt", "golden")
+	assert.NoError(t, err)
+	assert.Equal(t, s1, info.Sum)
+	_, err = db.GetTaggedVersion("/a.txt", "missing")
+	assert.Equal(t, ErrNotFound, err)
+	tagged, err := db.IsVersionPinned(s1)
+	assert.NoError(t, err)
+	assert.True(t, tagged)
+	tagged, err = db.IsVersionPinned(s2)
+	assert.NoError(t, err)
+	assert.False(t, tagged)
+
+	// Tagging another version of the file moves the tag
+	assert.NoError(t, db.TagVersion(s2, "golden", now))
+	tags, err := db.GetFileTags("/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []VersionTag{
+		{Tag: "golden", Sum: s2, CreatedAt: now},
+		{Tag: "release-1.2", Sum: s1, CreatedAt: now},
+	}, tags)
+
+	// Tags move with renamed files, and are removed with their version
+	assert.NoError(t, db.RenameFile("/a.txt", "/c.txt"))
+	info, err = db.GetTaggedVersion("/c.txt", "release-1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, s1, info.Sum)
+	assert.NoError(t, db.DeleteFile(s1))
+	_, err = db.GetTaggedVersion("/c.txt", "release-1.2")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.NoError(t, db.UntagVersion("/c.txt", "golden"))
+	assert.Equal(t, ErrNotFound, db.UntagVersion("/c.txt", "golden"))
+	tags, err = db.GetFileTags("/c.txt")
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+	tags, err = db.GetFileTags("/b.txt")
+	assert.NoError(t, err)
+	assert.Len(t, tags, 1)
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sdb, err := sql.Open(WALDriver, fmt.Sprintf("file:%s?_fk=on", filepath.Join(dir, "jotfs.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewAdapter(sdb)
+	defer db.Close()
+	assert.NoError(t, db.InitSchema())
+	var mode string
+	assert.NoError(t, sdb.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	// Updates are written to the log, which is not checkpointed automatically
+	before, _ := db.Writes()
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	insertFile(t, db, "/a.txt")
+	n, last := db.Writes()
+	assert.Equal(t, before+2, n)
+	assert.WithinDuration(t, time.Now(), last, time.Minute)
+	size, err := db.WALSize()
+	assert.NoError(t, err)
+	assert.True(t, size > 0)
+
+	res, err := db.Checkpoint(CheckpointPassive)
+	assert.NoError(t, err)
+	assert.False(t, res.Busy)
+	assert.True(t, res.LogFrames > 0)
+	assert.Equal(t, res.LogFrames, res.Checkpointed)
+	_, err = db.Checkpoint(CheckpointTruncate)
+	assert.NoError(t, err)
+	size, err = db.WALSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+	stats := db.CheckpointStats()
+	assert.Equal(t, uint64(2), stats.Checkpoints)
+	assert.Equal(t, uint64(0), stats.Busy)
+	assert.False(t, stats.Last.IsZero())
+
+	// A database which is not in WAL mode is not checkpointed
+	mem, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = mem.Checkpoint(CheckpointTruncate)
+	assert.NoError(t, err)
+	assert.Equal(t, CheckpointResult{}, res)
+	assert.Equal(t, CheckpointStats{}, mem.CheckpointStats())
+	size, err = mem.WALSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestOpenDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jotfs.db")
+	opts := DiskOptions{BusyTimeout: 5 * time.Second, Synchronous: "normal", MaxReaders: 2}
+	db, err := OpenDisk(filename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assert.NoError(t, db.InitSchema())
+	var mode string
+	var sync int
+	assert.NoError(t, db.db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.NoError(t, db.writer.QueryRow("PRAGMA synchronous").Scan(&sync))
+	assert.Equal(t, "wal", mode)
+	assert.Equal(t, 1, sync)
+
+	// Two adapters writing to the same database wait for each other's locks
+	other, err := OpenDisk(filename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		for _, a := range []*Adapter{db, other} {
+			go func(a *Adapter, i int) {
+				block := object.BlockInfo{Sum: sum.Compute([]byte(fmt.Sprint(a == db, i))), ChunkSize: 1, Offset: 1, Size: 2}
+				pack := object.PackIndex{Sum: sum.Compute(block.Sum[:]), Blocks: []object.BlockInfo{block}, Size: 3}
+				if err := a.InsertPackIndex(pack, time.Now()); err != nil {
+					errs <- err
+					return
+				}
+				_, err := a.ChunksExist([]sum.Sum{block.Sum})
+				errs <- err
+			}(a, i)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, <-errs)
+	}
+	var n int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM packs").Scan(&n))
+	assert.Equal(t, 40, n)
+
+	_, err = OpenDisk(filename, DiskOptions{Synchronous: "sometimes"})
+	assert.Error(t, err)
+}
+
+func TestSnapshots(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	a1, _ := insertFile(t, db, "/data/a.txt")
+	b1, _ := insertFile(t, db, "/data/b.txt")
+	insertFile(t, db, "/database/c.txt")
+
+	// A snapshot records the latest version of each file in the directory
+	createdAt := time.Now().UTC()
+	snap, err := db.CreateSnapshot("v1", "/data/", createdAt)
+	assert.NoError(t, err)
+	assert.Equal(t, Snapshot{Name: "v1", Prefix: "/data/", CreatedAt: createdAt, NumFiles: 2, TotalFilesSize: 2 * (block0.ChunkSize + block1.ChunkSize)}, snap)
+	_, err = db.CreateSnapshot("v1", "/", createdAt)
+	assert.Equal(t, ErrExists, err)
+	pinned, err := db.IsVersionPinned(a1)
+	assert.NoError(t, err)
+	assert.True(t, pinned)
+
+	// Later changes don't change the snapshot
+	a2, _ := insertFile(t, db, "/data/a.txt")
+	insertFile(t, db, "/data/d.txt")
+	assert.NoError(t, db.RenameFile("/data/b.txt", "/data/e.txt"))
+	files, err := db.GetSnapshotFiles("v1")
+	assert.NoError(t, err)
+	assert.Equal(t, []FileHead{{Name: "/data/a.txt", Sum: a1}, {Name: "/data/b.txt", Sum: b1}}, files)
+	heads, err := db.GetFileHeads("/data/")
+	assert.NoError(t, err)
+	if assert.Len(t, heads, 3) {
+		assert.Equal(t, FileHead{Name: "/data/a.txt", Sum: a2}, heads[0])
+	}
+
+	// Deleted versions stay in the snapshot without a version
+	assert.NoError(t, db.DeleteFile(b1))
+	files, err = db.GetSnapshotFiles("v1")
+	assert.NoError(t, err)
+	assert.Equal(t, []FileHead{{Name: "/data/a.txt", Sum: a1}, {Name: "/data/b.txt", Deleted: true}}, files)
+	snap, err = db.GetSnapshot("v1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), snap.NumFiles)
+	assert.Equal(t, block0.ChunkSize+block1.ChunkSize, snap.TotalFilesSize)
+
+	_, err = db.CreateSnapshot("all", "/", createdAt.Add(time.Second))
+	assert.NoError(t, err)
+	snapshots, err := db.ListSnapshots("/")
+	assert.NoError(t, err)
+	if assert.Len(t, snapshots, 2) {
+		assert.Equal(t, "all", snapshots[0].Name)
+		assert.Equal(t, uint64(3), snapshots[0].NumFiles)
+	}
+	snapshots, err = db.ListSnapshots("/data/")
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+
+	assert.NoError(t, db.DeleteSnapshot("v1"))
+	assert.Equal(t, ErrNotFound, db.DeleteSnapshot("v1"))
+	_, err = db.GetSnapshot("v1")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = db.GetSnapshotFiles("v1")
+	assert.Equal(t, ErrNotFound, err)
+	pinned, err = db.IsVersionPinned(a1)
+	assert.NoError(t, err)
+	assert.False(t, pinned)
+}
+
+func TestInsertFiles(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	a1, file := insertFile(t, db, "/a.txt")
+	b1, _ := insertFile(t, db, "/b.txt")
+	insertFile(t, db, "/b.txt")
+
+	newVersion := func(name string, cond Precondition) NewFileVersion {
+		f := file
+		f.Name = name
+		f.CreatedAt = time.Now().UTC()
+		return NewFileVersion{File: f, Sum: sum.Compute(f.MarshalBinary()), Cond: cond}
+	}
+
+	// Nothing changes if a precondition fails
+	files := []NewFileVersion{newVersion("/a.txt", Precondition{IfMatch: &a1}), newVersion("/c.txt", Precondition{IfNotExists: true})}
+	_, err = db.InsertFiles(files, map[string]sum.Sum{"/b.txt": b1})
+	assert.Equal(t, ErrConflict, err)
+	latest, err := db.GetLatestFileVersion("/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, a1, latest.Sum)
+
+	b2, err := db.GetLatestFileVersion("/b.txt")
+	assert.NoError(t, err)
+	deleted, err := db.InsertFiles(files, map[string]sum.Sum{"/b.txt": b2.Sum})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []sum.Sum{b1, b2.Sum}, deleted)
+	for _, f := range files {
+		latest, err := db.GetLatestFileVersion(f.File.Name)
+		assert.NoError(t, err)
+		assert.Equal(t, f.Sum, latest.Sum)
+	}
+	_, err = db.GetLatestFileVersion("/b.txt")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestOpLog(t *testing.T) {
+	primary, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made before the log is enabled are not recorded
+	assert.NoError(t, primary.DeleteJournalBefore(time.Now()))
+	entries, err := primary.ReadOpLog(0, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	primary.EnableOpLog()
+	assert.NoError(t, primary.InsertPackIndex(index, time.Now()))
+	s1, file := insertFile(t, primary, "/a/1.txt")
+	insertFile(t, primary, "/a/2.txt")
+	s3, _ := insertFile(t, primary, "/b.txt")
+	file.Name = "/c.txt"
+	key := CreateKey{Name: "/c.txt", Manifest: sum.Compute(block0.Sum[:]), IdempotencyKey: "abc"}
+	_, _, err = primary.InsertFileOnce(file, sum.Compute(file.MarshalBinary()), map[string]string{"k": "v"}, map[string][]byte{"md5": {1}}, Precondition{}, key)
+	assert.NoError(t, err)
+	_, err = primary.SetMetadata(s1, map[string]string{"env": "prod"}, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, primary.RenameFile("/b.txt", "/d.txt"))
+	_, err = primary.RenamePrefix("/a/", "/e/", func(string) error { return nil })
+	assert.NoError(t, err)
+	assert.NoError(t, primary.TagVersion(s1, "v1", time.Now()))
+	assert.NoError(t, primary.TagVersion(s1, "v2", time.Now()))
+	assert.NoError(t, primary.UntagVersion("/e/1.txt", "v2"))
+	_, err = primary.CreateSnapshot("s1", "/e/", time.Now())
+	assert.NoError(t, err)
+	_, err = primary.CreateSnapshot("s2", "/", time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, primary.DeleteSnapshot("s1"))
+	file.Name = "/f.txt"
+	file.CreatedAt = time.Now().UTC()
+	_, err = primary.InsertFiles([]NewFileVersion{{File: file, Sum: sum.Compute(file.MarshalBinary())}}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, primary.DeleteFile(s3))
+	assert.NoError(t, primary.SetPackETag(index.Sum, "etag"))
+	assert.NoError(t, primary.MarkPackDegraded(index.Sum, time.Now()))
+	id, err := primary.InsertShare([32]byte{1}, "/e", time.Now(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	_, err = primary.InsertShare([32]byte{2}, "/c", time.Now(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, primary.DeleteShare(id))
+	assert.NoError(t, primary.InsertUsageReport(UsageReport{CreatedAt: time.Now(), Usage: Usage{VersionsAdded: 1}}))
+	profile := ChunkerProfile{Prefix: "/vm", MinChunkSize: 1, AvgChunkSize: 2, MaxChunkSize: 3, Algorithm: "fixed"}
+	assert.NoError(t, primary.SetChunkerProfile(profile))
+	profile.Prefix = "/src"
+	assert.NoError(t, primary.SetChunkerProfile(profile))
+	assert.NoError(t, primary.DeleteChunkerProfile("/vm"))
+	rechunked, err := primary.GetFile(s1)
+	assert.NoError(t, err)
+	rechunked.Chunks = []object.Chunk{{Sequence: 0, Size: block1.ChunkSize, Sum: block1.Sum}}
+	assert.NoError(t, primary.ReplaceFileVersion(s1, rechunked, sum.Compute(rechunked.MarshalBinary())))
+	inline := object.File{Name: "/g.txt", CreatedAt: time.Now().UTC(), Chunks: []object.Chunk{{Sequence: 0, Size: 2, Sum: sum.Compute([]byte("hi"))}}, Data: []byte("hi")}
+	assert.NoError(t, primary.InsertFile(inline, sum.Compute(inline.MarshalBinary()), nil, nil, Precondition{}))
+
+	// Failed changes are not recorded
+	assert.Equal(t, ErrNotFound, primary.DeleteFile(s3))
+
+	last, err := primary.LastOpSeq()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(27), last)
+	entries, err = primary.ReadOpLog(0, 100)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 27)
+
+	// Replaying the log makes the same changes
+	for _, e := range entries {
+		assert.NoError(t, replica.ApplyOp(e))
+	}
+	assert.Equal(t, dumpTables(t, primary), dumpTables(t, replica))
+	applied, err := replica.LastOpSeq()
+	assert.NoError(t, err)
+	assert.Equal(t, last, applied)
+
+	// Entries already applied are ignored, and entries out of order are rejected
+	assert.NoError(t, replica.ApplyOp(entries[3]))
+	assert.Error(t, replica.ApplyOp(OpLogEntry{Seq: last + 2, Op: entries[0].Op}))
+
+	// Pruning the log keeps the sequence number
+	assert.NoError(t, primary.PruneOpLog(10))
+	entries, err = primary.ReadOpLog(0, 100)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 17) {
+		assert.Equal(t, int64(11), entries[0].Seq)
+	}
+	assert.NoError(t, primary.PruneOpLog(last))
+	applied, err = primary.LastOpSeq()
+	assert.NoError(t, err)
+	assert.Equal(t, last, applied)
+}
+
+// dumpTables returns the rows of each table replicated by the operation log.
+func dumpTables(t *testing.T, db *Adapter) map[string][]string {
+	tables := []string{"packs", "indexes", "files", "file_versions", "file_contents", "file_metadata", "file_checksums", "file_inline", "create_journal", "shares", "usage_reports", "version_tags", "snapshots", "snapshot_files"}
+	result := make(map[string][]string)
+	for _, table := range tables {
+		rows, err := db.db.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY 1, 2", table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(cols))
+			ptrs := make([]interface{}, len(cols))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				t.Fatal(err)
+			}
+			result[table] = append(result[table], fmt.Sprint(values...))
+		}
+		assert.NoError(t, rows.Err())
+		rows.Close()
+	}
+	return result
+}
+
+func TestVacuumInto(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sdb, err := sql.Open(WALDriver, fmt.Sprintf("file:%s?_fk=on", filepath.Join(dir, "jotfs.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewAdapter(sdb)
+	defer db.Close()
+	assert.NoError(t, db.InitSchema())
+	assert.NoError(t, db.InsertPackIndex(index, time.Now()))
+	insertFile(t, db, "/a.txt")
+
+	ps, err := db.PageStats()
+	assert.NoError(t, err)
+	assert.True(t, ps.PageSize > 0)
+	assert.True(t, ps.Pages > 0)
+
+	// The copy holds every update
+	copyName := filepath.Join(dir, "copy.db")
+	assert.NoError(t, db.VacuumInto(copyName))
+	cdb, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_fk=on", copyName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cdb.Close()
+	_, err = NewAdapter(cdb).GetLatestFileVersion("/a.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, NewAdapter(cdb).QuickCheck())
+	assert.Error(t, db.VacuumInto(copyName))
+
+	// The log is empty while the database is frozen
+	assert.NoError(t, db.Freeze(func(filename string) error {
+		assert.Equal(t, filepath.Join(dir, "jotfs.db"), filename)
+		size, err := db.WALSize()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), size)
+		return nil
+	}))
+	assert.EqualError(t, db.Freeze(func(string) error { return errors.New("x") }), "x")
+}
+
+func TestJobRuns(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err := db.ListJobRuns()
+	assert.NoError(t, err)
+	assert.Empty(t, runs)
+
+	start := time.Unix(0, 1000)
+	run := JobRun{Name: "vacuum", StartedAt: start, Runs: 1}
+	assert.NoError(t, db.SaveJobRun(run))
+	runs, err = db.ListJobRuns()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]JobRun{"vacuum": run}, runs)
+
+	// The last run replaces the previous run
+	run.FinishedAt = time.Unix(0, 2000)
+	run.Error = "failed"
+	run.Failures = 1
+	assert.NoError(t, db.SaveJobRun(run))
+	runs, err = db.ListJobRuns()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]JobRun{"vacuum": run}, runs)
+}
+
+func TestQuotaGrace(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetQuotaGrace("/a/")
+	assert.Equal(t, ErrNotFound, err)
+
+	// The grace period starts the first time the limit is exceeded
+	t1 := time.Unix(0, 1000).UTC()
+	start, started, err := db.StartQuotaGrace("/a/", t1)
+	assert.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, t1, start)
+	start, started, err = db.StartQuotaGrace("/a/", time.Unix(0, 2000))
+	assert.NoError(t, err)
+	assert.False(t, started)
+	assert.Equal(t, t1, start)
+	start, err = db.GetQuotaGrace("/a/")
+	assert.NoError(t, err)
+	assert.Equal(t, t1, start)
+
+	assert.NoError(t, db.EndQuotaGrace("/a/"))
+	_, err = db.GetQuotaGrace("/a/")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestChunkerProfiles(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := db.ListChunkerProfiles()
+	assert.NoError(t, err)
+	assert.Empty(t, profiles)
+	size, err := db.MaxProfileChunkSize()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), size)
+
+	vm := ChunkerProfile{Prefix: "/vm", MinChunkSize: 2 << 20, AvgChunkSize: 8 << 20, MaxChunkSize: 32 << 20, Normalization: 2, Algorithm: "fastcdc"}
+	src := ChunkerProfile{Prefix: "/src", MinChunkSize: 16 << 10, AvgChunkSize: 64 << 10, MaxChunkSize: 256 << 10, Algorithm: "rabin"}
+	assert.NoError(t, db.SetChunkerProfile(vm))
+	assert.NoError(t, db.SetChunkerProfile(src))
+	profiles, err = db.ListChunkerProfiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []ChunkerProfile{src, vm}, profiles)
+	size, err = db.MaxProfileChunkSize()
+	assert.NoError(t, err)
+	assert.Equal(t, vm.MaxChunkSize, size)
+
+	// Setting a profile replaces the profile of its prefix
+	vm.Algorithm = "fixed"
+	assert.NoError(t, db.SetChunkerProfile(vm))
+	profiles, err = db.ListChunkerProfiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []ChunkerProfile{src, vm}, profiles)
+
+	// Invalid sizes are rejected
+	assert.Error(t, db.SetChunkerProfile(ChunkerProfile{Prefix: "/x", MinChunkSize: 2, AvgChunkSize: 1, MaxChunkSize: 3}))
+
+	assert.NoError(t, db.DeleteChunkerProfile("/vm"))
+	assert.Equal(t, ErrNotFound, db.DeleteChunkerProfile("/vm"))
+	profiles, err = db.ListChunkerProfiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []ChunkerProfile{src}, profiles)
+}
+
+func TestReplaceFileVersion(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now().Add(-time.Hour)))
+	old, file := insertFile(t, db, "/a/1.txt")
+	insertFile(t, db, "/b.txt")
+	assert.NoError(t, db.TagVersion(old, "v1", time.Now()))
+	_, err = db.SetMetadata(old, map[string]string{"k": "v"}, nil, nil)
+	assert.NoError(t, err)
+
+	sums, err := db.ListPrefixVersionSums("/a/")
+	assert.NoError(t, err)
+	assert.Equal(t, []sum.Sum{old}, sums)
+	sums, err = db.ListPrefixVersionSums("/")
+	assert.NoError(t, err)
+	assert.Len(t, sums, 2)
+
+	// The version keeps its ID, metadata and tags under the new sum
+	before, err := db.GetFileInfo(old)
+	assert.NoError(t, err)
+	file.Chunks = []object.Chunk{{Sequence: 0, Size: block0.ChunkSize, Sum: block0.Sum}}
+	s := sum.Compute(file.MarshalBinary())
+	assert.NoError(t, db.ReplaceFileVersion(old, file, s))
+	_, err = db.GetFileInfo(old)
+	assert.Equal(t, ErrNotFound, err)
+	after, err := db.GetFileInfo(s)
+	assert.NoError(t, err)
+	assert.Equal(t, before.VersionID, after.VersionID)
+	assert.Equal(t, map[string]string{"k": "v"}, after.Metadata)
+	tagged, err := db.GetTaggedVersion("/a/1.txt", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, s, tagged.Sum)
+	got, err := db.GetFile(s)
+	assert.NoError(t, err)
+	assert.Equal(t, file.Chunks, got.Chunks)
+
+	// The chunk dropped by the version is only referenced by /b.txt
+	packs, err := db.ListReclaimablePacks(time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, packs)
+	names, err := db.ListPackFiles(index.Sum, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a/1.txt", "/b.txt"}, names)
+
+	assert.Equal(t, ErrNotFound, db.ReplaceFileVersion(old, file, s))
+}
+
+func TestListReclaimablePacks(t *testing.T) {
+	db, err := EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.InsertPackIndex(index, time.Now().Add(-time.Hour)))
+	sa, _ := insertFile(t, db, "/a.txt")
+	file := object.File{
+		Name:      "/b.txt",
+		CreatedAt: time.Now().UTC(),
+		Chunks:    []object.Chunk{{Sequence: 0, Size: block0.ChunkSize, Sum: block0.Sum}},
+		Versioned: true,
+	}
+	assert.NoError(t, db.InsertFile(file, sum.Compute(file.MarshalBinary()), nil, nil, Precondition{}))
+	packs, err := db.ListReclaimablePacks(time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, packs)
+
+	names, err := db.ListPackFiles(index.Sum, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt", "/b.txt"}, names)
+	names, err = db.ListPackFiles(index.Sum, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt"}, names)
+
+	// Only the second block is unreferenced once /a.txt is deleted
+	assert.NoError(t, db.DeleteFile(sa))
+	expected := []ReclaimablePack{{
+		Sum:        index.Sum,
+		Size:       index.Size,
+		NumChunks:  2,
+		Chunks:     1,
+		ChunksSize: block1.Size,
+	}}
+	for i := 0; i < 2; i++ {
+		packs, err = db.ListReclaimablePacks(time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, packs)
+	}
+	packs, err = db.ListReclaimablePacks(time.Now().Add(-2 * time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, packs)
+	names, err = db.ListPackFiles(index.Sum, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/b.txt"}, names)
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// WALDriver is the name of a database/sql driver which opens SQLite databases in
+// write-ahead log mode with automatic checkpoints turned off. SQLite's automatic
+// checkpoint runs in the commit of whichever write fills the log, which stalls the
+// single writer in the middle of an upload under sustained ingest. The log of a
+// database opened with this driver must be checkpointed with Adapter.Checkpoint.
+const WALDriver = "sqlite3_wal"
+
+func init() {
+	sql.Register(WALDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, q := range []string{"PRAGMA journal_mode = WAL", "PRAGMA wal_autocheckpoint = 0"} {
+				if _, err := conn.Exec(q, nil); err != nil {
+					return fmt.Errorf("%s: %w", q, err)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// CheckpointMode selects how a checkpoint treats concurrent readers and writers.
+type CheckpointMode string
+
+const (
+	// CheckpointPassive copies as many frames from the log to the database as it can
+	// without waiting for readers or writers.
+	CheckpointPassive CheckpointMode = "PASSIVE"
+	// CheckpointTruncate waits for writers and readers to finish, copies every frame
+	// and truncates the log to zero bytes.
+	CheckpointTruncate CheckpointMode = "TRUNCATE"
+)
+
+// CheckpointResult is the outcome of a single checkpoint.
+type CheckpointResult struct {
+	// Busy is true if the checkpoint could not copy every frame because of a
+	// concurrent reader or writer.
+	Busy bool
+	// LogFrames is the number of frames in the log, and Checkpointed is the number of
+	// those which have been copied to the database.
+	LogFrames    int
+	Checkpointed int
+	Duration     time.Duration
+}
+
+// CheckpointStats are running totals of the checkpoints made by an Adapter.
+type CheckpointStats struct {
+	Checkpoints uint64
+	// Busy is the number of checkpoints which could not copy every frame.
+	Busy          uint64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	// Last is the time of the last checkpoint, and LastDuration its duration.
+	Last         time.Time
+	LastDuration time.Duration
+}
+
+// Checkpoint copies the frames in the database's write-ahead log to the database. A
+// CheckpointTruncate checkpoint holds the adapter's write lock, so it waits for any
+// update in progress and blocks updates until it is done. Checkpoint does nothing if
+// the database is not in WAL mode.
+func (a *Adapter) Checkpoint(mode CheckpointMode) (CheckpointResult, error) {
+	if mode == CheckpointTruncate {
+		a.mut.Lock()
+		defer a.mut.Unlock()
+	}
+	return a.checkpoint(mode)
+}
+
+// checkpoint makes a checkpoint without taking the adapter's write lock.
+func (a *Adapter) checkpoint(mode CheckpointMode) (CheckpointResult, error) {
+	start := time.Now()
+	var busy int
+	var res CheckpointResult
+	q := fmt.Sprintf("PRAGMA wal_checkpoint(%s)", mode)
+	if err := a.db.QueryRow(q).Scan(&busy, &res.LogFrames, &res.Checkpointed); err != nil {
+		return CheckpointResult{}, err
+	}
+	res.Duration = time.Since(start)
+	if res.LogFrames < 0 {
+		// Not in WAL mode
+		return CheckpointResult{}, nil
+	}
+	res.Busy = busy != 0 || res.Checkpointed < res.LogFrames
+
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	s := &a.checkpoints
+	s.Checkpoints++
+	if res.Busy {
+		s.Busy++
+	}
+	s.TotalDuration += res.Duration
+	if res.Duration > s.MaxDuration {
+		s.MaxDuration = res.Duration
+	}
+	s.Last = start
+	s.LastDuration = res.Duration
+	return res, nil
+}
+
+// CheckpointStats returns the totals of the checkpoints made by the adapter.
+func (a *Adapter) CheckpointStats() CheckpointStats {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
+	return a.checkpoints
+}
+
+// WALSize returns the size of the database's write-ahead log file in bytes. Returns
+// zero if the database has no log file.
+func (a *Adapter) WALSize() (int64, error) {
+	file, err := a.filename()
+	if err != nil {
+		return 0, err
+	}
+	if file == "" {
+		// An in-memory database
+		return 0, nil
+	}
+	info, err := os.Stat(file + "-wal")
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Writes returns the number of updates committed by the adapter, and the time of the
+// last update.
+func (a *Adapter) Writes() (uint64, time.Time) {
+	n := atomic.LoadUint64(&a.writes)
+	last := atomic.LoadInt64(&a.lastWrite)
+	if last == 0 {
+		return n, time.Time{}
+	}
+	return n, time.Unix(0, last)
+}
+
+// filename returns the path of the database file. Returns an empty string for an
+// in-memory database.
+func (a *Adapter) filename() (string, error) {
+	var seq int
+	var name, file string
+	if err := a.db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
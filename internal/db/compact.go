@@ -0,0 +1,79 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PageStats describes the space used by the database file.
+type PageStats struct {
+	// PageSize is the size of a page in bytes.
+	PageSize int64
+	// Pages is the number of pages in the database file, and FreePages the number of
+	// those which are unused, e.g. because the rows they held were deleted by a vacuum.
+	Pages     int64
+	FreePages int64
+}
+
+// PageStats returns the number of pages in the database, and how many are free.
+func (a *Adapter) PageStats() (PageStats, error) {
+	var ps PageStats
+	for _, p := range []struct {
+		q string
+		v *int64
+	}{
+		{"PRAGMA page_size", &ps.PageSize},
+		{"PRAGMA page_count", &ps.Pages},
+		{"PRAGMA freelist_count", &ps.FreePages},
+	} {
+		if err := a.db.QueryRow(p.q).Scan(p.v); err != nil {
+			return PageStats{}, fmt.Errorf("%s: %w", p.q, err)
+		}
+	}
+	return ps, nil
+}
+
+// VacuumInto writes a compacted copy of the database, which has no free pages, to
+// filename. The file must not exist. The copy is read in a single transaction, so
+// updates may be made while it's written, but are not included in the copy.
+func (a *Adapter) VacuumInto(filename string) error {
+	_, err := a.db.Exec("VACUUM INTO ?", filename)
+	return err
+}
+
+// Freeze checkpoints the whole write-ahead log and calls f with the path of the
+// database file while updates are blocked, so the file holds every update and is not
+// changed by the adapter until f returns. Returns an error if the log could not be
+// checkpointed because of a concurrent reader, or if the database is in memory.
+func (a *Adapter) Freeze(f func(filename string) error) error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	file, err := a.filename()
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		return errors.New("database is in memory")
+	}
+	res, err := a.checkpoint(CheckpointTruncate)
+	if err != nil {
+		return fmt.Errorf("checkpointing database: %w", err)
+	}
+	if res.Busy {
+		return errors.New("database log could not be checkpointed because the database is busy")
+	}
+	return f(file)
+}
+
+// QuickCheck checks the structure of the database file, returning an error describing
+// the first problem found if it's corrupt.
+func (a *Adapter) QuickCheck() error {
+	var res string
+	if err := a.db.QueryRow("PRAGMA quick_check(1)").Scan(&res); err != nil {
+		return err
+	}
+	if res != "ok" {
+		return fmt.Errorf("database is corrupt: %s", res)
+	}
+	return nil
+}
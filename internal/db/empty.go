@@ -4,13 +4,32 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/mattn/go-sqlite3"
 	"github.com/rs/xid"
 )
 
+// memoryDriver is the name of a database/sql driver for in-memory databases whose
+// connections share a cache, so every connection in the pool sees the same database.
+// Reads are uncommitted, so readers don't take table locks and don't fail while
+// another connection writes. Writes are serialized by the Adapter.
+const memoryDriver = "sqlite3_memory"
+
+func init() {
+	sql.Register(memoryDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if _, err := conn.Exec("PRAGMA read_uncommitted = 1", nil); err != nil {
+				return fmt.Errorf("PRAGMA read_uncommitted = 1: %w", err)
+			}
+			return nil
+		},
+	})
+}
+
 // EmptyInMemory returns an adapter to a new in-memory database with all tables created.
+// The database is discarded once every connection to it is closed.
 func EmptyInMemory() (*Adapter, error) {
 	id := xid.New()
-	sdb, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&_fk=on", id.String()))
+	sdb, err := sql.Open(memoryDriver, fmt.Sprintf("file:%s?mode=memory&cache=shared&_fk=on", id.String()))
 	if err != nil {
 		return nil, fmt.Errorf("connecting to in-memory SQLite instance: %v", err)
 	}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+const (
+	// filterBitsPerChunk and filterHashes give the chunk filter a false positive rate
+	// of about 1% when full.
+	filterBitsPerChunk = 10
+	filterHashes       = 7
+
+	// minFilterCapacity is the smallest number of chunks the filter is sized for.
+	minFilterCapacity = 1 << 16
+)
+
+// ChunkFilterStats are running totals of the lookups made in the chunk filter.
+type ChunkFilterStats struct {
+	// Chunks is the number of chunks added to the filter, and Capacity the number it
+	// can hold before it's rebuilt. Size is the size of the filter in bytes.
+	Chunks   uint64
+	Capacity uint64
+	Size     uint64
+	// Builds is the number of times the filter was built from the database.
+	Builds uint64
+	// Lookups is the number of chunks looked up, Skipped the number known not to
+	// exist without querying the database, and FalsePositives the number the filter
+	// could not rule out but which did not exist, including deleted chunks.
+	Lookups        uint64
+	Skipped        uint64
+	FalsePositives uint64
+}
+
+// chunkFilter is a bloom filter over the sums of the chunks in the indexes table. It
+// has no false negatives, so a chunk it does not contain does not exist. Chunks are
+// never removed, so deleted chunks remain in the filter until it's rebuilt.
+type chunkFilter struct {
+	mu    sync.RWMutex
+	bits  []uint64
+	built bool
+	stats ChunkFilterStats
+}
+
+// EnableChunkFilter turns on the chunk filter, which ChunksExist consults before
+// querying the database. The filter is built from the database by the first lookup,
+// and rebuilt with a larger size once it holds more chunks than it was sized for. It
+// uses about 10 bits per chunk.
+func (a *Adapter) EnableChunkFilter() {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.filter = &chunkFilter{}
+}
+
+// ChunkFilterStats returns the totals of the lookups made in the chunk filter. Returns
+// false if the filter is disabled.
+func (a *Adapter) ChunkFilterStats() (ChunkFilterStats, bool) {
+	if a.filter == nil {
+		return ChunkFilterStats{}, false
+	}
+	a.filter.mu.RLock()
+	defer a.filter.mu.RUnlock()
+	return a.filter.stats, true
+}
+
+// chunkFilter returns the chunk filter, building it if it has not been built or is
+// full. Returns nil if the filter is disabled.
+func (a *Adapter) chunkFilter() (*chunkFilter, error) {
+	f := a.filter
+	if f == nil {
+		return nil, nil
+	}
+	f.mu.RLock()
+	ready := f.built && f.stats.Chunks <= f.stats.Capacity
+	f.mu.RUnlock()
+	if ready {
+		return f, nil
+	}
+
+	// Updates are blocked while the filter is built, so no chunk is inserted between
+	// the filter reading the table and being ready
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.built && f.stats.Chunks <= f.stats.Capacity {
+		return f, nil
+	}
+	var count uint64
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM indexes").Scan(&count); err != nil {
+		return nil, fmt.Errorf("counting chunks: %w", err)
+	}
+	capacity := 2 * count
+	if capacity < minFilterCapacity {
+		capacity = minFilterCapacity
+	}
+	f.bits = make([]uint64, (capacity*filterBitsPerChunk+63)/64)
+	f.stats.Chunks = 0
+	f.stats.Capacity = capacity
+	f.stats.Size = uint64(len(f.bits)) * 8
+
+	rows, err := a.db.Query("SELECT sum FROM indexes")
+	if err != nil {
+		f.built = false
+		return nil, err
+	}
+	defer rows.Close()
+	b := make([]byte, sum.Size)
+	for rows.Next() {
+		if err := rows.Scan(&b); err != nil {
+			f.built = false
+			return nil, err
+		}
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			f.built = false
+			return nil, err
+		}
+		f.add(s)
+	}
+	if err := rows.Err(); err != nil {
+		f.built = false
+		return nil, err
+	}
+	f.built = true
+	f.stats.Builds++
+	return f, nil
+}
+
+// addBlocks adds the chunks of a packfile to the filter, if it's enabled and built.
+// It's called as the packfile is inserted, while a.mut is held, so the chunks are in
+// the filter before they can be found in the database. An unbuilt filter reads them
+// from the database when it's built.
+func (a *Adapter) addBlocks(blocks []object.BlockInfo) {
+	f := a.filter
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.built {
+		return
+	}
+	for _, b := range blocks {
+		f.add(b.Sum)
+	}
+}
+
+// add adds s to the filter. f.mu must be held.
+func (f *chunkFilter) add(s sum.Sum) {
+	m := uint64(len(f.bits)) * 64
+	h1, h2 := filterHash(s)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.stats.Chunks++
+}
+
+// mayContain returns false if s is not in the filter. f.mu must be held.
+func (f *chunkFilter) mayContain(s sum.Sum) bool {
+	m := uint64(len(f.bits)) * 64
+	h1, h2 := filterHash(s)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterHash returns the two hashes the filter's bits are derived from. Sums are
+// cryptographic hashes, so their bytes are used directly.
+func filterHash(s sum.Sum) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(s[0:8]), binary.LittleEndian.Uint64(s[8:16]) | 1
+}
+
+// candidates returns the indexes of the sums which may be in the filter, and records
+// the lookups.
+func (f *chunkFilter) candidates(sums []sum.Sum) []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []int
+	for i, s := range sums {
+		if f.mayContain(s) {
+			res = append(res, i)
+		}
+	}
+	f.stats.Lookups += uint64(len(sums))
+	f.stats.Skipped += uint64(len(sums) - len(res))
+	return res
+}
+
+// falsePositives records n chunks which were in the filter but did not exist.
+func (f *chunkFilter) falsePositives(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.FalsePositives += uint64(n)
+}
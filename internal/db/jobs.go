@@ -0,0 +1,58 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// JobRun is the last run of a background job.
+type JobRun struct {
+	Name string
+	// StartedAt is the time the run started, and FinishedAt the time it finished,
+	// which is zero if it's in progress or the server stopped during it.
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Error is the error returned by the run, if it failed.
+	Error string
+	// Runs is the number of times the job has run, and Failures the number of runs
+	// which failed.
+	Runs     int64
+	Failures int64
+}
+
+// SaveJobRun records the last run of a job, replacing the previous run.
+func (a *Adapter) SaveJobRun(run JobRun) error {
+	var finishedAt int64
+	if !run.FinishedAt.IsZero() {
+		finishedAt = run.FinishedAt.UnixNano()
+	}
+	return a.update(func(tx *sql.Tx) error {
+		q := `INSERT OR REPLACE INTO jobs (name, started_at, finished_at, error, runs, failures)
+		VALUES (?, ?, ?, ?, ?, ?)`
+		_, err := tx.Exec(q, run.Name, run.StartedAt.UnixNano(), finishedAt, run.Error, run.Runs, run.Failures)
+		return err
+	})
+}
+
+// ListJobRuns returns the last run of each job which has run, keyed by job name.
+func (a *Adapter) ListJobRuns() (map[string]JobRun, error) {
+	rows, err := a.db.Query("SELECT name, started_at, finished_at, error, runs, failures FROM jobs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := make(map[string]JobRun)
+	for rows.Next() {
+		var run JobRun
+		var startedAt, finishedAt int64
+		if err := rows.Scan(&run.Name, &startedAt, &finishedAt, &run.Error, &run.Runs, &run.Failures); err != nil {
+			return nil, err
+		}
+		run.StartedAt = time.Unix(0, startedAt)
+		if finishedAt != 0 {
+			run.FinishedAt = time.Unix(0, finishedAt)
+		}
+		runs[run.Name] = run
+	}
+	return runs, rows.Err()
+}
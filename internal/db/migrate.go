@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a versioned change to the database schema. Migrations are applied in
+// order of version.
+type Migration struct {
+	Version int
+	Name    string
+	// Applied is true if the migration has been applied to the database. AppliedAt is
+	// the time it was applied, which is zero if it was applied before migrations were
+	// recorded.
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// InitSchema creates the tables for a new database by applying every migration.
+func (a *Adapter) InitSchema() error {
+	_, err := a.migrate(true)
+	return err
+}
+
+// UpgradeSchema applies the migrations not yet applied to an existing database.
+func (a *Adapter) UpgradeSchema() error {
+	_, err := a.Migrate()
+	return err
+}
+
+// Migrations returns every migration known to this version of the server, in order of
+// version, and whether each has been applied to the database. Returns an error if the
+// database has migrations applied which are newer than those known.
+func (a *Adapter) Migrations() ([]Migration, error) {
+	applied, err := appliedMigrations(a.db)
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, len(schema))
+	for i, m := range schema {
+		at, ok := applied[m.version]
+		migrations[i] = Migration{Version: m.version, Name: m.name, Applied: ok, AppliedAt: at}
+	}
+	return migrations, nil
+}
+
+// Migrate applies the migrations not yet applied to the database, in order of version,
+// in a single transaction. Returns the migrations applied.
+func (a *Adapter) Migrate() ([]Migration, error) {
+	migrations, err := a.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := false
+	for _, m := range migrations {
+		pending = pending || !m.Applied
+	}
+	if !pending {
+		return nil, nil
+	}
+	return a.migrate(false)
+}
+
+// migrate applies the pending migrations, or every migration if the database is new,
+// and records them in the database.
+func (a *Adapter) migrate(fresh bool) ([]Migration, error) {
+	var done []Migration
+	err := a.update(func(tx *sql.Tx) error {
+		applied := make(map[int]time.Time)
+		if !fresh {
+			var err error
+			if applied, err = appliedMigrations(tx); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		for _, m := range schema {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if _, err := tx.Exec(m.query); err != nil {
+				return fmt.Errorf("applying migration %03d_%s: %w", m.version, m.name, err)
+			}
+			done = append(done, Migration{Version: m.version, Name: m.name, Applied: true, AppliedAt: now})
+		}
+		if len(done) == 0 {
+			return nil
+		}
+		if err := backfillIDs(tx); err != nil {
+			return fmt.Errorf("backfilling identifiers: %w", err)
+		}
+
+		// Record every migration, including those applied before migrations were
+		// recorded
+		q := "INSERT OR IGNORE INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"
+		for _, m := range done {
+			if _, err := tx.Exec(q, m.Version, m.Name, m.AppliedAt.UnixNano()); err != nil {
+				return fmt.Errorf("recording migration: %w", err)
+			}
+		}
+		for _, m := range schema {
+			if _, err := tx.Exec(q, m.version, m.name, 0); err != nil {
+				return fmt.Errorf("recording migration: %w", err)
+			}
+		}
+		// The schema version stops older servers from opening the database
+		_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(schema)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// appliedMigrations returns the time each migration applied to the database was
+// applied, keyed by version. Returns an error if a migration is newer than those known.
+func appliedMigrations(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	var tables int
+	q := "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	if err := db.QueryRow(q).Scan(&tables); err != nil {
+		return nil, err
+	}
+	if tables == 0 {
+		// Before migrations were recorded, the schema version was the number of schema
+		// files applied. Databases created before the schema version was recorded have
+		// only the base schema.
+		var version int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			return nil, err
+		}
+		if version == 0 {
+			version = 1
+		}
+		for v := 0; v < version; v++ {
+			applied[v] = time.Time{}
+		}
+	} else {
+		rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int
+			var at int64
+			if err := rows.Scan(&version, &at); err != nil {
+				return nil, err
+			}
+			applied[version] = time.Time{}
+			if at != 0 {
+				applied[version] = time.Unix(0, at)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for v := range applied {
+		if v >= len(schema) {
+			return nil, fmt.Errorf("database schema version %d is newer than supported version %d", v+1, len(schema))
+		}
+	}
+	return applied, nil
+}
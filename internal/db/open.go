@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DiskOptions configures the connections to a database file opened with OpenDisk.
+type DiskOptions struct {
+	// BusyTimeout is how long a connection waits for a lock held by another connection
+	// before failing with a "database is locked" error.
+	BusyTimeout time.Duration
+	// Synchronous is SQLite's synchronous setting: OFF, NORMAL, FULL or EXTRA. FULL
+	// syncs the write-ahead log on every commit. NORMAL syncs it only at checkpoints,
+	// so the last commits may be lost on power failure, but the database is never
+	// corrupted. Defaults to FULL.
+	Synchronous string
+	// MaxReaders is the largest number of connections used to read from the database
+	// at once. Zero means no limit.
+	MaxReaders int
+}
+
+// OpenDisk opens the database at filename, creating the file if it does not exist, in
+// write-ahead log mode with the WALDriver. Updates use a single connection, which takes
+// the write lock when its transaction begins, so concurrent updates queue for the
+// connection rather than failing when a transaction which began as a reader tries to
+// write. Reads use a separate pool of connections, which the log lets run alongside
+// the writer. The schema is not created or upgraded.
+func OpenDisk(filename string, opts DiskOptions) (*Adapter, error) {
+	mode := strings.ToUpper(opts.Synchronous)
+	switch mode {
+	case "":
+		mode = "FULL"
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return nil, fmt.Errorf("invalid synchronous setting %q", opts.Synchronous)
+	}
+	params := url.Values{}
+	params.Set("_fk", "true")
+	params.Set("_busy_timeout", fmt.Sprint(opts.BusyTimeout.Milliseconds()))
+	params.Set("_synchronous", mode)
+	dsn := fmt.Sprintf("file:%s?%s", filename, params.Encode())
+
+	writer, err := sql.Open(WALDriver, dsn+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	if err := writer.Ping(); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("connecting writer: %w", err)
+	}
+
+	reader, err := sql.Open(WALDriver, dsn)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	reader.SetMaxOpenConns(opts.MaxReaders)
+	if err := reader.Ping(); err != nil {
+		writer.Close()
+		reader.Close()
+		return nil, fmt.Errorf("connecting reader: %w", err)
+	}
+
+	a := NewAdapter(reader)
+	a.writer = writer
+	return a, nil
+}
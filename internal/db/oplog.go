@@ -0,0 +1,368 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// Operation types recorded in the operation log.
+const (
+	opInsertPack           = "insert_pack"
+	opInsertFile           = "insert_file"
+	opDeleteFiles          = "delete_files"
+	opRenameFile           = "rename_file"
+	opRenamePrefix         = "rename_prefix"
+	opSetMetadata          = "set_metadata"
+	opUpdateIndex          = "update_index"
+	opDeletePack           = "delete_pack"
+	opSetPackETag          = "set_pack_etag"
+	opMarkPackDegraded     = "mark_pack_degraded"
+	opDeleteJournal        = "delete_journal"
+	opInsertShare          = "insert_share"
+	opDeleteShare          = "delete_share"
+	opInsertUsageReport    = "insert_usage_report"
+	opTagVersion           = "tag_version"
+	opUntagVersion         = "untag_version"
+	opCreateSnapshot       = "create_snapshot"
+	opDeleteSnapshot       = "delete_snapshot"
+	opSetChunkerProfile    = "set_chunker_profile"
+	opDeleteChunkerProfile = "delete_chunker_profile"
+	opReplaceFileVersion   = "replace_file_version"
+)
+
+// op is a change to the database recorded in the operation log. It holds every value
+// needed to make the same change to a copy of the database, including the identifiers
+// and times generated when the change was first made. Fields are used according to the
+// operation's type.
+type op struct {
+	Type string `json:"type"`
+	UID  string `json:"uid,omitempty"`
+	// Time and Expires are Unix times in nanoseconds.
+	Time    int64 `json:"time,omitempty"`
+	Expires int64 `json:"expires,omitempty"`
+	// Data is the binary representation of a file or pack index, or a share's token
+	// hash.
+	Data           []byte            `json:"data,omitempty"`
+	Sums           [][]byte          `json:"sums,omitempty"`
+	Name           string            `json:"name,omitempty"`
+	Src            string            `json:"src,omitempty"`
+	Dst            string            `json:"dst,omitempty"`
+	Set            map[string]string `json:"set,omitempty"`
+	Checksums      map[string][]byte `json:"checksums,omitempty"`
+	Delete         []string          `json:"delete,omitempty"`
+	Manifest       []byte            `json:"manifest,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+	ETag           string            `json:"etag,omitempty"`
+	Sequences      map[uint64]uint64 `json:"sequences,omitempty"`
+	Usage          *Usage            `json:"usage,omitempty"`
+	Profile        *ChunkerProfile   `json:"profile,omitempty"`
+}
+
+// OpLogEntry is a change recorded in the operation log. Op is opaque to callers, and
+// is only interpreted by ApplyOp.
+type OpLogEntry struct {
+	// Seq is the position of the entry in the log. Sequence numbers start at 1 and
+	// increase by one for each change.
+	Seq int64           `json:"seq"`
+	Op  json.RawMessage `json:"op"`
+}
+
+// EnableOpLog turns on recording of changes to files, version tags, snapshots,
+// packfiles, shares, chunker profiles, usage reports and the create journal in the
+// operation log. Changes made before the log is enabled are not recorded, so a copy of
+// the database made after it is enabled may replay the log.
+// Vacuum records and store events are never recorded.
+func (a *Adapter) EnableOpLog() {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.oplog = true
+}
+
+// logOp records o in the operation log, if it is enabled, as part of the transaction
+// making the change.
+func (a *Adapter) logOp(tx *sql.Tx, o op) error {
+	if !a.oplog {
+		return nil
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("encoding %s operation: %w", o.Type, err)
+	}
+	if _, err := tx.Exec("INSERT INTO oplog (op) VALUES (?)", string(b)); err != nil {
+		return fmt.Errorf("recording %s operation: %w", o.Type, err)
+	}
+	return nil
+}
+
+// LastOpSeq returns the sequence number of the last entry recorded in, or applied to,
+// the operation log, or 0 if the log is empty. The sequence number is kept when the
+// entry is removed by PruneOpLog.
+func (a *Adapter) LastOpSeq() (int64, error) {
+	return lastOpSeq(a.db)
+}
+
+func lastOpSeq(db interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (int64, error) {
+	var seq int64
+	err := db.QueryRow("SELECT seq FROM sqlite_sequence WHERE name = 'oplog'").Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// ReadOpLog returns up to limit entries from the operation log with a sequence number
+// greater than after, in sequence order.
+func (a *Adapter) ReadOpLog(after int64, limit uint64) ([]OpLogEntry, error) {
+	rows, err := a.db.Query("SELECT seq, op FROM oplog WHERE seq > ? ORDER BY seq LIMIT ?", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []OpLogEntry
+	for rows.Next() {
+		var e OpLogEntry
+		var o string
+		if err := rows.Scan(&e.Seq, &o); err != nil {
+			return nil, err
+		}
+		e.Op = json.RawMessage(o)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PruneOpLog removes the entries with a sequence number less than or equal to seq from
+// the operation log.
+func (a *Adapter) PruneOpLog(seq int64) error {
+	return a.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM oplog WHERE seq <= ?", seq)
+		return err
+	})
+}
+
+// ApplyOp makes the change recorded in an operation log entry from another database,
+// and records the entry in this database's log under the same sequence number. Entries
+// at or below LastOpSeq have already been applied and are ignored. Returns an error if
+// the entry does not directly follow LastOpSeq.
+func (a *Adapter) ApplyOp(e OpLogEntry) error {
+	return a.update(func(tx *sql.Tx) error {
+		last, err := lastOpSeq(tx)
+		if err != nil {
+			return err
+		}
+		if e.Seq <= last {
+			return nil
+		}
+		if e.Seq != last+1 {
+			return fmt.Errorf("operation %d does not follow last applied operation %d", e.Seq, last)
+		}
+		var o op
+		if err := json.Unmarshal(e.Op, &o); err != nil {
+			return fmt.Errorf("decoding operation %d: %w", e.Seq, err)
+		}
+		if err := a.applyOp(tx, o); err != nil {
+			return fmt.Errorf("applying %s operation %d: %w", o.Type, e.Seq, err)
+		}
+		_, err = tx.Exec("INSERT INTO oplog (seq, op) VALUES (?, ?)", e.Seq, string(e.Op))
+		return err
+	})
+}
+
+// applyOp makes the change recorded by o.
+func (a *Adapter) applyOp(tx *sql.Tx, o op) error {
+	switch o.Type {
+	case opInsertPack:
+		var index object.PackIndex
+		if err := index.UnmarshalBinary(o.Data); err != nil {
+			return fmt.Errorf("decoding pack index: %w", err)
+		}
+		packID, err := insertPackIndex(tx, o.UID, index, time.Unix(0, o.Time))
+		if err != nil {
+			return err
+		}
+		if o.IdempotencyKey != "" {
+			if err := insertUploadJournalEntry(tx, o.IdempotencyKey, packID, time.Unix(0, o.Time)); err != nil {
+				return err
+			}
+		}
+		a.addBlocks(index.Blocks)
+		return nil
+
+	case opInsertFile:
+		var file object.File
+		if err := file.UnmarshalBinary(bytes.NewReader(o.Data)); err != nil {
+			return fmt.Errorf("decoding file: %w", err)
+		}
+		s, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		fileVerID, err := insertFileAndChunks(tx, o.UID, file, s, o.Set, o.Checksums)
+		if err != nil {
+			return err
+		}
+		if o.Manifest == nil {
+			return nil
+		}
+		manifest, err := sum.FromBytes(o.Manifest)
+		if err != nil {
+			return err
+		}
+		key := CreateKey{Name: o.Name, Manifest: manifest, IdempotencyKey: o.IdempotencyKey}
+		return insertJournalEntry(tx, key, fileVerID, file.CreatedAt)
+
+	case opDeleteFiles:
+		for _, b := range o.Sums {
+			s, err := sum.FromBytes(b)
+			if err != nil {
+				return err
+			}
+			if err := deleteFileVersion(tx, s); err != nil {
+				return fmt.Errorf("deleting file %x: %w", s, err)
+			}
+		}
+		return nil
+
+	case opRenameFile:
+		return renameFile(tx, o.Src, o.Dst)
+
+	case opRenamePrefix:
+		return renamePrefix(tx, o.Src, o.Dst)
+
+	case opSetMetadata:
+		s, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		verID, err := fileVersionID(tx, s)
+		if err != nil {
+			return err
+		}
+		return setMetadata(tx, verID, o.Set, o.Delete)
+
+	case opUpdateIndex:
+		var index object.PackIndex
+		if err := index.UnmarshalBinary(o.Data); err != nil {
+			return fmt.Errorf("decoding pack index: %w", err)
+		}
+		oldSum, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		return updateIndex(tx, o.UID, index, time.Unix(0, o.Time), oldSum, o.Sequences)
+
+	case opDeletePack:
+		s, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		return deletePackIndex(tx, s)
+
+	case opSetPackETag:
+		s, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		return setPackETag(tx, s, o.ETag)
+
+	case opMarkPackDegraded:
+		s, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		return markPackDegraded(tx, s, time.Unix(0, o.Time))
+
+	case opDeleteJournal:
+		return deleteJournalBefore(tx, time.Unix(0, o.Time))
+
+	case opInsertShare:
+		return insertShare(tx, o.UID, o.Data, o.Name, time.Unix(0, o.Time), time.Unix(0, o.Expires))
+
+	case opDeleteShare:
+		return deleteShare(tx, o.UID)
+
+	case opInsertUsageReport:
+		if o.Usage == nil {
+			return errors.New("operation has no usage")
+		}
+		return insertUsageReport(tx, UsageReport{CreatedAt: time.Unix(0, o.Time), Usage: *o.Usage})
+
+	case opTagVersion:
+		s, err := opSum(o)
+		if err != nil {
+			return err
+		}
+		return tagVersion(tx, s, o.Name, time.Unix(0, o.Time))
+
+	case opUntagVersion:
+		return untagVersion(tx, o.Src, o.Name)
+
+	case opCreateSnapshot:
+		sums := make([]sum.Sum, len(o.Sums))
+		for i, b := range o.Sums {
+			s, err := sum.FromBytes(b)
+			if err != nil {
+				return err
+			}
+			sums[i] = s
+		}
+		return insertSnapshot(tx, o.Name, o.Src, time.Unix(0, o.Time), sums)
+
+	case opDeleteSnapshot:
+		return deleteSnapshot(tx, o.Name)
+
+	case opSetChunkerProfile:
+		if o.Profile == nil {
+			return errors.New("operation has no profile")
+		}
+		return setChunkerProfile(tx, *o.Profile)
+
+	case opDeleteChunkerProfile:
+		return deleteChunkerProfile(tx, o.Name)
+
+	case opReplaceFileVersion:
+		var file object.File
+		if err := file.UnmarshalBinary(bytes.NewReader(o.Data)); err != nil {
+			return fmt.Errorf("decoding file: %w", err)
+		}
+		if len(o.Sums) != 2 {
+			return errors.New("operation must have the old and new sums")
+		}
+		old, err := sum.FromBytes(o.Sums[0])
+		if err != nil {
+			return err
+		}
+		s, err := sum.FromBytes(o.Sums[1])
+		if err != nil {
+			return err
+		}
+		return replaceFileVersion(tx, old, file, s)
+	}
+	return fmt.Errorf("unknown operation type %q", o.Type)
+}
+
+// opSum returns the first sum of an operation.
+func opSum(o op) (sum.Sum, error) {
+	if len(o.Sums) == 0 {
+		return sum.Sum{}, errors.New("operation has no sum")
+	}
+	return sum.FromBytes(o.Sums[0])
+}
+
+// sumBytes returns the bytes of each sum.
+func sumBytes(sums []sum.Sum) [][]byte {
+	b := make([][]byte, len(sums))
+	for i := range sums {
+		b[i] = sums[i][:]
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// ChunkerProfile is the chunking parameters of the files under a prefix. Sizes are in
+// bytes.
+type ChunkerProfile struct {
+	Prefix        string `json:"prefix"`
+	MinChunkSize  uint64 `json:"min_chunk_size"`
+	AvgChunkSize  uint64 `json:"avg_chunk_size"`
+	MaxChunkSize  uint64 `json:"max_chunk_size"`
+	Normalization uint64 `json:"normalization"`
+	Algorithm     string `json:"algorithm"`
+}
+
+// SetChunkerProfile saves a chunker profile, replacing any profile with the same
+// prefix.
+func (a *Adapter) SetChunkerProfile(p ChunkerProfile) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := setChunkerProfile(tx, p); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opSetChunkerProfile, Profile: &p})
+	})
+}
+
+// ListChunkerProfiles returns every chunker profile in order of prefix.
+func (a *Adapter) ListChunkerProfiles() ([]ChunkerProfile, error) {
+	q := `SELECT prefix, min_chunk_size, avg_chunk_size, max_chunk_size, normalization, algorithm
+	FROM chunker_profiles ORDER BY prefix`
+	rows, err := a.db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	profiles := make([]ChunkerProfile, 0)
+	for rows.Next() {
+		var p ChunkerProfile
+		if err := rows.Scan(&p.Prefix, &p.MinChunkSize, &p.AvgChunkSize, &p.MaxChunkSize, &p.Normalization, &p.Algorithm); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// MaxProfileChunkSize returns the largest maximum chunk size of any chunker profile,
+// or 0 if there are none.
+func (a *Adapter) MaxProfileChunkSize() (uint64, error) {
+	var size sql.NullInt64
+	if err := a.db.QueryRow("SELECT MAX(max_chunk_size) FROM chunker_profiles").Scan(&size); err != nil {
+		return 0, err
+	}
+	return uint64(size.Int64), nil
+}
+
+// DeleteChunkerProfile deletes the chunker profile of a prefix. Returns ErrNotFound if
+// the prefix has no profile.
+func (a *Adapter) DeleteChunkerProfile(prefix string) error {
+	return a.update(func(tx *sql.Tx) error {
+		if err := deleteChunkerProfile(tx, prefix); err != nil {
+			return err
+		}
+		return a.logOp(tx, op{Type: opDeleteChunkerProfile, Name: prefix})
+	})
+}
+
+func setChunkerProfile(tx *sql.Tx, p ChunkerProfile) error {
+	q := `INSERT OR REPLACE INTO chunker_profiles
+	(prefix, min_chunk_size, avg_chunk_size, max_chunk_size, normalization, algorithm)
+	VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := tx.Exec(q, p.Prefix, p.MinChunkSize, p.AvgChunkSize, p.MaxChunkSize, p.Normalization, p.Algorithm)
+	return err
+}
+
+func deleteChunkerProfile(tx *sql.Tx, prefix string) error {
+	res, err := tx.Exec("DELETE FROM chunker_profiles WHERE prefix = ?", prefix)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StartQuotaGrace records that the soft limit of the quota of prefix was exceeded at
+// t, unless its grace period has already started. Returns the start of the grace
+// period, and true if it started at t.
+func (a *Adapter) StartQuotaGrace(prefix string, t time.Time) (time.Time, bool, error) {
+	var startedAt int64
+	var started bool
+	err := a.update(func(tx *sql.Tx) error {
+		q := "INSERT OR IGNORE INTO quota_grace (prefix, started_at) VALUES (?, ?)"
+		res, err := tx.Exec(q, prefix, t.UnixNano())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		started = n == 1
+		return tx.QueryRow("SELECT started_at FROM quota_grace WHERE prefix = ?", prefix).Scan(&startedAt)
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.Unix(0, startedAt).UTC(), started, nil
+}
+
+// EndQuotaGrace removes the grace period of the quota of prefix, if it has one.
+func (a *Adapter) EndQuotaGrace(prefix string) error {
+	return a.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM quota_grace WHERE prefix = ?", prefix)
+		return err
+	})
+}
+
+// GetQuotaGrace returns the start of the grace period of the quota of prefix. Returns
+// ErrNotFound if its soft limit is not exceeded.
+func (a *Adapter) GetQuotaGrace(prefix string) (time.Time, error) {
+	var startedAt int64
+	err := a.db.QueryRow("SELECT started_at FROM quota_grace WHERE prefix = ?", prefix).Scan(&startedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, startedAt).UTC(), nil
+}
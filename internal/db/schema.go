@@ -0,0 +1,47 @@
+package db
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//go:embed schema/*.sql
+var schemaFiles embed.FS
+
+// migration is a schema file. Its version is the number at the start of its name,
+// which is followed by an underscore and the name of the migration.
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+// schema lists each schema file in the order it should be applied. The files are
+// numbered, so this is the order of their names, and the version of each file is its
+// index in the list.
+var schema = readSchema()
+
+func readSchema() []migration {
+	const dir = "schema"
+	entries, err := schemaFiles.ReadDir(dir)
+	if err != nil {
+		panic(err)
+	}
+	migrations := make([]migration, len(entries))
+	for i, e := range entries {
+		b, err := schemaFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		parts := strings.SplitN(strings.TrimSuffix(e.Name(), ".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || version != i {
+			panic(fmt.Sprintf("schema file %s must be named %03d_<name>.sql", e.Name(), i))
+		}
+		migrations[i] = migration{version: version, name: parts[1], query: string(b)}
+	}
+	return migrations
+}
@@ -0,0 +1,9 @@
+ALTER TABLE packs ADD COLUMN degraded_at INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE store_events (
+    id          INTEGER PRIMARY KEY,
+    key         TEXT NOT NULL,
+    name        TEXT NOT NULL,
+    event_time  INTEGER NOT NULL,
+    received_at INTEGER NOT NULL
+);
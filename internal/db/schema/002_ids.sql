@@ -0,0 +1,7 @@
+-- Time-sortable identifiers for packfiles and file versions. Rows created before
+-- this schema are backfilled by the adapter.
+ALTER TABLE packs ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+CREATE INDEX packs_uid_index ON packs (uid);
+
+ALTER TABLE file_versions ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+CREATE INDEX file_versions_uid_index ON file_versions (uid);
@@ -0,0 +1,15 @@
+-- Records file versions created by requests with an idempotency key, so a retried
+-- request returns the original version instead of creating a duplicate.
+CREATE TABLE create_journal (
+    name            TEXT NOT NULL,
+    manifest        BLOB NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    file_version    INTEGER NOT NULL REFERENCES file_versions (id) ON DELETE CASCADE,
+    created_at      INTEGER NOT NULL,
+
+    PRIMARY KEY (name, manifest, idempotency_key),
+    CHECK (length(manifest) = 32),
+    CHECK (length(idempotency_key) > 0),
+    CHECK (created_at > 0)
+);
+CREATE INDEX create_journal_file_version_index ON create_journal (file_version);
@@ -0,0 +1,3 @@
+-- The ETag reported by the store after a packfile is uploaded. Empty for packfiles
+-- uploaded before ETags were recorded.
+ALTER TABLE packs ADD COLUMN etag TEXT NOT NULL DEFAULT '';
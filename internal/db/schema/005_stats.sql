@@ -0,0 +1,66 @@
+-- Running totals for the server statistics, maintained by triggers so the statistics
+-- may be read without scanning the tables. The single row is initialized from the
+-- existing data.
+CREATE TABLE stats (
+    id                INTEGER PRIMARY KEY,
+    num_files         INTEGER NOT NULL,
+    num_file_versions INTEGER NOT NULL,
+    total_files_size  INTEGER NOT NULL,
+    num_chunks        INTEGER NOT NULL,
+    total_data_size   INTEGER NOT NULL,
+    num_packs         INTEGER NOT NULL,
+    total_packs_size  INTEGER NOT NULL,
+
+    CHECK (id = 1)
+);
+
+INSERT INTO stats VALUES (
+    1,
+    (SELECT count(*) FROM files),
+    (SELECT count(*) FROM file_versions),
+    (SELECT coalesce(sum(size), 0) FROM file_versions),
+    (SELECT count(*) FROM indexes),
+    (SELECT coalesce(sum(size), 0) FROM indexes),
+    (SELECT count(*) FROM packs),
+    (SELECT coalesce(sum(size), 0) FROM packs)
+);
+
+CREATE TRIGGER stats_files_insert AFTER INSERT ON files BEGIN
+    UPDATE stats SET num_files = num_files + 1;
+END;
+CREATE TRIGGER stats_files_delete AFTER DELETE ON files BEGIN
+    UPDATE stats SET num_files = num_files - 1;
+END;
+
+CREATE TRIGGER stats_file_versions_insert AFTER INSERT ON file_versions BEGIN
+    UPDATE stats SET
+        num_file_versions = num_file_versions + 1,
+        total_files_size = total_files_size + NEW.size;
+END;
+CREATE TRIGGER stats_file_versions_delete AFTER DELETE ON file_versions BEGIN
+    UPDATE stats SET
+        num_file_versions = num_file_versions - 1,
+        total_files_size = total_files_size - OLD.size;
+END;
+
+CREATE TRIGGER stats_indexes_insert AFTER INSERT ON indexes BEGIN
+    UPDATE stats SET
+        num_chunks = num_chunks + 1,
+        total_data_size = total_data_size + NEW.size;
+END;
+CREATE TRIGGER stats_indexes_delete AFTER DELETE ON indexes BEGIN
+    UPDATE stats SET
+        num_chunks = num_chunks - 1,
+        total_data_size = total_data_size - OLD.size;
+END;
+
+CREATE TRIGGER stats_packs_insert AFTER INSERT ON packs BEGIN
+    UPDATE stats SET
+        num_packs = num_packs + 1,
+        total_packs_size = total_packs_size + NEW.size;
+END;
+CREATE TRIGGER stats_packs_delete AFTER DELETE ON packs BEGIN
+    UPDATE stats SET
+        num_packs = num_packs - 1,
+        total_packs_size = total_packs_size - OLD.size;
+END;
@@ -0,0 +1,14 @@
+-- Read-only links granting access to the files under a prefix until they expire. Only
+-- a hash of each link's token is stored.
+CREATE TABLE shares (
+    id         TEXT PRIMARY KEY,
+    token_hash BLOB NOT NULL,
+    prefix     TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+
+    CHECK (length(token_hash) = 32),
+    CHECK (length(prefix) > 0),
+    CHECK (expires_at > created_at)
+);
+CREATE UNIQUE INDEX shares_token_hash_index ON shares (token_hash);
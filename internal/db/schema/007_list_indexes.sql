@@ -0,0 +1,5 @@
+-- Indexes for listing files in name, creation time or size order without sorting the
+-- whole table. The row ID breaks ties so the order is stable for pagination.
+CREATE INDEX file_versions_file_index ON file_versions (file);
+CREATE INDEX file_versions_created_at_index ON file_versions (created_at);
+CREATE INDEX file_versions_size_index ON file_versions (size);
@@ -0,0 +1,10 @@
+-- User-defined key/value metadata attached to file versions.
+CREATE TABLE file_metadata (
+    file_version INTEGER NOT NULL REFERENCES file_versions (id) ON DELETE CASCADE,
+    key          TEXT NOT NULL,
+    value        TEXT NOT NULL,
+
+    PRIMARY KEY (file_version, key),
+    CHECK (length(key) > 0)
+);
+CREATE INDEX file_metadata_key_value_index ON file_metadata (key, value);
@@ -0,0 +1,7 @@
+-- Log of committed changes to the metadata, shipped to the object store so a standby
+-- server can replay them. Rows are removed once they have been shipped. seq uses
+-- AUTOINCREMENT so sequence numbers are never reused after rows are removed.
+CREATE TABLE oplog (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    op  TEXT NOT NULL
+);
@@ -0,0 +1,9 @@
+-- Whole-file checksums of file versions, e.g. MD5 and SHA-1 for systems which require
+-- them, computed by the server when a file version is created.
+CREATE TABLE file_checksums (
+    file_version INTEGER NOT NULL REFERENCES file_versions (id) ON DELETE CASCADE,
+    algorithm    TEXT NOT NULL,
+    checksum     BLOB NOT NULL,
+
+    PRIMARY KEY (file_version, algorithm)
+);
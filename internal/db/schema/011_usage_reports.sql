@@ -0,0 +1,29 @@
+-- Running totals of the file versions added and deleted, used to find the changes
+-- between usage reports. Versions which existed before the totals were added are not
+-- counted as added.
+ALTER TABLE stats ADD COLUMN versions_added INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stats ADD COLUMN bytes_added INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stats ADD COLUMN versions_deleted INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stats ADD COLUMN bytes_deleted INTEGER NOT NULL DEFAULT 0;
+
+CREATE TRIGGER usage_file_versions_insert AFTER INSERT ON file_versions BEGIN
+    UPDATE stats SET
+        versions_added = versions_added + 1,
+        bytes_added = bytes_added + NEW.size;
+END;
+CREATE TRIGGER usage_file_versions_delete AFTER DELETE ON file_versions BEGIN
+    UPDATE stats SET
+        versions_deleted = versions_deleted + 1,
+        bytes_deleted = bytes_deleted + OLD.size;
+END;
+
+-- Usage reports sent by the server, with the running totals at the time of each
+-- report.
+CREATE TABLE usage_reports (
+    id               INTEGER PRIMARY KEY,
+    created_at       INTEGER NOT NULL,
+    versions_added   INTEGER NOT NULL,
+    bytes_added      INTEGER NOT NULL,
+    versions_deleted INTEGER NOT NULL,
+    bytes_deleted    INTEGER NOT NULL
+);
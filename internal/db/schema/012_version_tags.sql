@@ -0,0 +1,12 @@
+-- Labels naming file versions, e.g. "release-1.2". A tag names at most one version of
+-- each file, and moves with the file when it is renamed.
+CREATE TABLE version_tags (
+    file         INTEGER NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+    file_version INTEGER NOT NULL REFERENCES file_versions (id) ON DELETE CASCADE,
+    tag          TEXT NOT NULL,
+    created_at   INTEGER NOT NULL,
+
+    PRIMARY KEY (file, tag),
+    CHECK (length(tag) > 0)
+);
+CREATE INDEX version_tags_file_version_index ON version_tags (file_version);
@@ -0,0 +1,21 @@
+-- Snapshots record the latest version of every file in a directory at a point in
+-- time. Each file is recorded under the name it had when the snapshot was taken. If
+-- the version is deleted, the file stays in the snapshot with a NULL version.
+CREATE TABLE snapshots (
+    id         INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL UNIQUE,
+    prefix     TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+
+    CHECK (length(name) > 0),
+    CHECK (created_at > 0)
+);
+
+CREATE TABLE snapshot_files (
+    snapshot     INTEGER NOT NULL REFERENCES snapshots (id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    file_version INTEGER REFERENCES file_versions (id) ON DELETE SET NULL,
+
+    PRIMARY KEY (snapshot, name)
+);
+CREATE INDEX snapshot_files_file_version_index ON snapshot_files (file_version);
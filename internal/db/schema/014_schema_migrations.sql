@@ -0,0 +1,8 @@
+-- Each schema migration applied to the database, recorded by the number at the start
+-- of its file name. Migrations applied before this table was created are recorded
+-- with an applied_at of zero.
+CREATE TABLE schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at INTEGER NOT NULL
+);
@@ -0,0 +1,11 @@
+-- The last run of each background job, so the job schedules carry on from where they
+-- were when the server restarts. Times are Unix times in nanoseconds, and finished_at
+-- is zero while a run is in progress or if the server stopped during it.
+CREATE TABLE jobs (
+    name        TEXT PRIMARY KEY,
+    started_at  INTEGER NOT NULL,
+    finished_at INTEGER NOT NULL,
+    error       TEXT NOT NULL,
+    runs        INTEGER NOT NULL,
+    failures    INTEGER NOT NULL
+);
@@ -0,0 +1,11 @@
+-- Records packfiles uploaded by requests with an idempotency key, so a retried upload
+-- is acknowledged without saving the packfile again.
+CREATE TABLE upload_journal (
+    idempotency_key TEXT NOT NULL PRIMARY KEY,
+    pack            INTEGER NOT NULL REFERENCES packs (id) ON DELETE CASCADE,
+    created_at      INTEGER NOT NULL,
+
+    CHECK (length(idempotency_key) > 0),
+    CHECK (created_at > 0)
+);
+CREATE INDEX upload_journal_pack_index ON upload_journal (pack);
@@ -0,0 +1,15 @@
+-- Chunking parameters used in place of the server's for the files under a prefix.
+-- Sizes are in bytes.
+CREATE TABLE chunker_profiles (
+    prefix         TEXT NOT NULL PRIMARY KEY,
+    min_chunk_size INTEGER NOT NULL,
+    avg_chunk_size INTEGER NOT NULL,
+    max_chunk_size INTEGER NOT NULL,
+    normalization  INTEGER NOT NULL,
+    algorithm      TEXT NOT NULL,
+
+    CHECK (length(prefix) > 0),
+    CHECK (min_chunk_size > 0),
+    CHECK (avg_chunk_size > min_chunk_size),
+    CHECK (max_chunk_size > avg_chunk_size)
+);
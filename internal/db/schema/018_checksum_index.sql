@@ -0,0 +1,3 @@
+-- Index for finding a file version by its whole-file checksum, so a client can create
+-- a version of data already stored without uploading it.
+CREATE INDEX file_checksums_checksum_index ON file_checksums (algorithm, checksum);
@@ -0,0 +1,7 @@
+-- Content of small file versions stored in the database instead of a packfile. An
+-- inline version has a single chunk, with no rows in file_contents.
+CREATE TABLE file_inline (
+    file_version INTEGER PRIMARY KEY REFERENCES file_versions (id) ON DELETE CASCADE,
+    sum          BLOB NOT NULL,
+    data         BLOB NOT NULL
+);
@@ -0,0 +1,6 @@
+-- The start of the grace period of each quota whose soft limit is exceeded, by
+-- prefix. The row is removed once the usage of the prefix is back under the limit.
+CREATE TABLE quota_grace (
+    prefix     TEXT PRIMARY KEY,
+    started_at INTEGER NOT NULL
+);
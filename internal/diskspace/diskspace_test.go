@@ -0,0 +1,23 @@
+package diskspace
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-diskspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	free, err := Free(dir)
+	assert.NoError(t, err)
+	assert.True(t, free > 0)
+
+	_, err = Free(dir + "/missing")
+	assert.Error(t, err)
+}
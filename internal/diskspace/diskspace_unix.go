@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+// Package diskspace reports the free space of the file system holding a directory,
+// so local caches can shrink before they fill the disk.
+package diskspace
+
+import "syscall"
+
+// Free returns the number of bytes available to unprivileged users on the file
+// system holding dir.
+func Free(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
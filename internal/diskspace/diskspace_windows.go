@@ -0,0 +1,24 @@
+// Package diskspace reports the free space of the file system holding a directory,
+// so local caches can shrink before they fill the disk.
+package diskspace
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Free returns the number of bytes available to the user on the volume holding dir.
+func Free(dir string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail uint64
+	ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0)
+	if ok == 0 {
+		return 0, err
+	}
+	return avail, nil
+}
@@ -0,0 +1,66 @@
+// Package id generates identifiers for packfiles and file versions.
+package id
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/oklog/ulid"
+)
+
+// Generator creates unique identifiers.
+type Generator interface {
+	// New returns a new identifier for an object created at time t.
+	New(t time.Time) (string, error)
+}
+
+// ULIDGenerator generates ULIDs, which sort lexicographically by creation time.
+// Identifiers generated within the same millisecond are monotonically increasing.
+type ULIDGenerator struct {
+	mu      sync.Mutex
+	entropy io.Reader
+}
+
+// NewULIDGenerator returns a new ULIDGenerator.
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{entropy: ulid.Monotonic(rand.Reader, 0)}
+}
+
+// New returns a new ULID with a timestamp taken from t.
+func (g *ULIDGenerator) New(t time.Time) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id, err := ulid.New(ulid.Timestamp(t), g.entropy)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// Legacy returns a ULID for an object created before identifiers were introduced. The
+// identifier is deterministic: its timestamp is taken from t and its entropy is
+// derived from seed, which should be unique to the object.
+func Legacy(t time.Time, seed []byte) string {
+	h := sum.Compute(seed)
+	var id ulid.ULID
+	if err := id.SetTime(ulid.Timestamp(t)); err != nil {
+		// Only fails for times after the year 10889
+		binary.BigEndian.PutUint16(id[:2], 0xffff)
+		binary.BigEndian.PutUint32(id[2:6], 0xffffffff)
+	}
+	copy(id[6:], h[:10])
+	return id.String()
+}
+
+// Time returns the timestamp encoded in a ULID.
+func Time(s string) (time.Time, error) {
+	id, err := ulid.ParseStrict(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ulid.Time(id.Time()).UTC(), nil
+}
@@ -0,0 +1,43 @@
+package id
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestULIDGenerator(t *testing.T) {
+	g := NewULIDGenerator()
+	now := time.Now()
+
+	// IDs within the same millisecond are increasing
+	a, err := g.New(now)
+	assert.NoError(t, err)
+	b, err := g.New(now)
+	assert.NoError(t, err)
+	assert.True(t, a < b)
+
+	// IDs sort by time
+	c, err := g.New(now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, c < a)
+
+	ts, err := Time(a)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Truncate(time.Millisecond).UTC(), ts)
+
+	_, err = Time("abc")
+	assert.Error(t, err)
+}
+
+func TestLegacy(t *testing.T) {
+	now := time.Now()
+	a := Legacy(now, []byte("a"))
+	assert.Equal(t, a, Legacy(now, []byte("a")))
+	assert.NotEqual(t, a, Legacy(now, []byte("b")))
+
+	ts, err := Time(a)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Truncate(time.Millisecond).UTC(), ts)
+}
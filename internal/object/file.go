@@ -11,6 +11,7 @@ import (
 
 const maxChunks = 1000000
 const maxNameSize = 32768
+const maxDataSize = 1 << 20
 
 // File represents a file object.
 type File struct {
@@ -18,6 +19,9 @@ type File struct {
 	CreatedAt time.Time
 	Chunks    []Chunk
 	Versioned bool
+	// Data is the content of a file stored inline, in which case Chunks holds its
+	// single chunk. Nil if the file's data is stored in packfiles.
+	Data []byte
 }
 
 // Chunk stores the information for a chunk within a file object.
@@ -46,6 +50,10 @@ func (f *File) MarshalBinary() []byte {
 		b = append(b, buf...)
 		buf = buf[:0]
 	}
+	if f.Data != nil {
+		b = append(b, uint64Binary(uint64(len(f.Data)))...)
+		b = append(b, f.Data...)
+	}
 	return b
 }
 
@@ -94,10 +102,26 @@ func (f *File) UnmarshalBinary(r io.Reader) error {
 		c.unmarshalBinary(r)
 	}
 
+	// The inline data is optional, so the file may end after its chunks
+	var data []byte
+	dataSize, err := getBinaryUint64(r)
+	if err == nil {
+		if dataSize > maxDataSize {
+			return fmt.Errorf("inline data size %d exceeds maximum %d", dataSize, maxDataSize)
+		}
+		data = make([]byte, dataSize)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("reading inline data: %w", err)
+		}
+	} else if err != io.EOF {
+		return err
+	}
+
 	f.Name = string(name)
 	f.CreatedAt = time.Unix(0, int64(createdAtNanos)).UTC()
 	f.Chunks = chunks
 	f.Versioned = versioned
+	f.Data = data
 
 	return nil
 }
@@ -14,10 +14,11 @@ func TestFileMarshalUnmarshal(t *testing.T) {
 	c1 := Chunk{Sequence: 1, Size: 100, Sum: sum.Compute([]byte("b"))}
 
 	tests := []File{
-		{"abc", time.Now().UTC(), []Chunk{c0, c1}, true},
-		{"abc", time.Now().UTC(), []Chunk{c0, c1}, false},
-		{"abc", time.Now().UTC(), []Chunk{}, false},
-		{"", time.Now().UTC(), []Chunk{c0, c0, c1}, true},
+		{"abc", time.Now().UTC(), []Chunk{c0, c1}, true, nil},
+		{"abc", time.Now().UTC(), []Chunk{c0, c1}, false, nil},
+		{"abc", time.Now().UTC(), []Chunk{}, false, nil},
+		{"", time.Now().UTC(), []Chunk{c0, c0, c1}, true, nil},
+		{"abc", time.Now().UTC(), []Chunk{{0, 3, sum.Compute([]byte("xyz"))}}, false, []byte("xyz")},
 	}
 
 	for i, file := range tests {
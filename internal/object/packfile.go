@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 
 	"github.com/jotfs/jotfs/internal/compress"
 	"github.com/jotfs/jotfs/internal/sum"
@@ -102,8 +103,33 @@ type block struct {
 	Size   uint64
 }
 
+// blockHeaderSize is the size of the header before the data of each block: its data
+// size, compression mode and chunk checksum.
+const blockHeaderSize = 8 + 1 + sum.Size
+
+// PackLimits are the limits checked by LoadPackIndexLimited as it reads a packfile.
+type PackLimits struct {
+	// MaxChunkSize, if set, is the maximum size of a chunk once decompressed.
+	MaxChunkSize uint64
+
+	// Size, if set, is the size of the packfile. A block which extends past it is
+	// rejected before its data is read.
+	Size uint64
+
+	// ChunkHash is the hash function the checksum of each chunk is verified with.
+	// Defaults to BLAKE3.
+	ChunkHash sum.Algorithm
+}
+
 // LoadPackIndex reads a packfile and generates its pack index.
 func LoadPackIndex(r io.Reader) (PackIndex, error) {
+	return LoadPackIndexLimited(r, PackLimits{})
+}
+
+// LoadPackIndexLimited reads a packfile and generates its pack index, checking the
+// limits as it goes. Each block is decompressed and verified as it's read, without
+// buffering its data, so a malformed packfile is rejected at the first bad block.
+func LoadPackIndexLimited(r io.Reader, limits PackLimits) (PackIndex, error) {
 	// Send all data read from the packfile through a hash function
 	phash, err := sum.New()
 	if err != nil {
@@ -124,38 +150,50 @@ func LoadPackIndex(r io.Reader) (PackIndex, error) {
 	// Read each block from the packfile and parse its BlockInfo
 	idx := make([]BlockInfo, 0)
 	for seq := uint64(0); ; seq++ {
-		block, err := readBlock(cr)
+		offset := cr.bytesRead
+		size, mode, s, err := readBlockHeader(cr)
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return PackIndex{}, fmt.Errorf("reading block %d: %w", seq, err)
 		}
+		if limits.Size > 0 && (size > limits.Size || cr.bytesRead+size > limits.Size) {
+			return PackIndex{}, fmt.Errorf("block %d of %d bytes extends past the end of the packfile", seq, size)
+		}
 
-		// Decompress the data and verify the chunk's checksum
-		rd := bytes.NewBuffer(block.Data)
-		chash, err := sum.New()
+		// Decompress the data as it's read and verify the chunk's checksum
+		data := &io.LimitedReader{R: cr, N: int64(size)}
+		chash := limits.ChunkHash.New()
 		cw := &countingWriter{chash, 0}
-		if err != nil {
-			return PackIndex{}, err
+		var w io.Writer = cw
+		if limits.MaxChunkSize > 0 {
+			w = &chunkLimitWriter{cw, limits.MaxChunkSize}
 		}
-		if err := block.Mode.DecompressStream(cw, rd); err != nil {
+		if err := mode.DecompressStream(w, data); err != nil {
 			return PackIndex{}, fmt.Errorf("decompressing chunk data in block %d: %w", seq, err)
 		}
+		// Any data left in the block after the compressed stream is skipped
+		if _, err := io.Copy(ioutil.Discard, data); err != nil {
+			return PackIndex{}, fmt.Errorf("reading block %d: %w", seq, err)
+		}
+		if data.N > 0 {
+			return PackIndex{}, fmt.Errorf("reading block %d: %w", seq, io.ErrUnexpectedEOF)
+		}
 		actual := chash.Sum()
-		if actual != block.Sum {
+		if actual != s {
 			return PackIndex{}, fmt.Errorf(
 				"invalid chunk data in block %d. Expected checksum %x but actual checksum is %x",
-				seq, block.Sum, actual,
+				seq, s, actual,
 			)
 		}
 
 		info := BlockInfo{
-			Sum:       block.Sum,
+			Sum:       s,
 			ChunkSize: cw.bytesWritten,
 			Sequence:  seq,
-			Offset:    block.Offset,
-			Size:      block.Size,
-			Mode:      block.Mode,
+			Offset:    offset,
+			Size:      cr.bytesRead - offset,
+			Mode:      mode,
 		}
 		idx = append(idx, info)
 	}
@@ -163,13 +201,45 @@ func LoadPackIndex(r io.Reader) (PackIndex, error) {
 	return PackIndex{Blocks: idx, Sum: phash.Sum(), Size: cr.bytesRead}, nil
 }
 
+// chunkLimitWriter fails a write which would take the size of a chunk past max.
+type chunkLimitWriter struct {
+	w   *countingWriter
+	max uint64
+}
+
+func (w *chunkLimitWriter) Write(p []byte) (int, error) {
+	if w.w.bytesWritten+uint64(len(p)) > w.max {
+		return 0, fmt.Errorf("chunk exceeds maximum size of %d bytes", w.max)
+	}
+	return w.w.Write(p)
+}
+
+// DecodeBlock reads a single block from the start of b and returns its decompressed
+// chunk data. Returns an error if the chunk does not match its checksum computed with
+// hash.
+func DecodeBlock(b []byte, hash sum.Algorithm) ([]byte, error) {
+	block, err := readBlock(&countingReader{bytes.NewReader(b), 0})
+	if err != nil {
+		return nil, fmt.Errorf("reading block: %w", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := block.Mode.DecompressStream(buf, bytes.NewReader(block.Data)); err != nil {
+		return nil, fmt.Errorf("decompressing chunk data: %w", err)
+	}
+	data := buf.Bytes()
+	if actual := hash.Compute(data); actual != block.Sum {
+		return nil, fmt.Errorf("expected chunk checksum %x but actual checksum is %x", block.Sum, actual)
+	}
+	return data, nil
+}
+
 func makeBlock(data []byte, s sum.Sum, mode compress.Mode) ([]byte, error) {
 	compressed, err := mode.Compress(data)
 	if err != nil {
 		return nil, err
 	}
 
-	capacity := 8 + 1 + sum.Size + len(data)
+	capacity := blockHeaderSize + len(data)
 	block := make([]byte, 8, capacity)
 
 	binary.LittleEndian.PutUint64(block[:8], uint64(len(compressed)))
@@ -182,31 +252,51 @@ func makeBlock(data []byte, s sum.Sum, mode compress.Mode) ([]byte, error) {
 
 func readBlock(r *countingReader) (block, error) {
 	offset := r.bytesRead
+	size, mode, s, err := readBlockHeader(r)
+	if err != nil {
+		return block{}, err
+	}
+	// The buffer grows as data is read, so a corrupt size cannot exhaust memory
+	var compressed bytes.Buffer
+	if _, err := io.CopyN(&compressed, r, int64(size)); err != nil {
+		return block{}, noEOF(err)
+	}
+
+	blockSize := r.bytesRead - offset
+	return block{Sum: s, Mode: mode, Data: compressed.Bytes(), Size: blockSize, Offset: offset}, nil
+}
 
+// readBlockHeader reads the header of a block. Returns io.EOF if r is at its end.
+func readBlockHeader(r io.Reader) (uint64, compress.Mode, sum.Sum, error) {
 	var size uint64
 	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
-		return block{}, err
+		return 0, 0, sum.Sum{}, err
 	}
 	var m uint8
 	if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
-		return block{}, err
+		return 0, 0, sum.Sum{}, noEOF(err)
 	}
 	mode, err := compress.FromUint8(m)
 	if err != nil {
-		return block{}, fmt.Errorf("invalid compression mode %d", mode)
+		return 0, 0, sum.Sum{}, err
 	}
 	var s sum.Sum
 	if _, err := io.ReadFull(r, s[:]); err != nil {
-		return block{}, err
+		return 0, 0, sum.Sum{}, noEOF(err)
 	}
-	// TODO: put upper limit on size to prevent out-of-memory error
-	compressed := make([]byte, size)
-	if _, err := io.ReadFull(r, compressed); err != nil {
-		return block{}, err
+	if size > math.MaxInt64 {
+		return 0, 0, sum.Sum{}, fmt.Errorf("block size %d is too large", size)
 	}
+	return size, mode, s, nil
+}
 
-	blockSize := r.bytesRead - offset
-	return block{Sum: s, Mode: mode, Data: compressed, Size: blockSize, Offset: offset}, nil
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, for data which ends part way through
+// a block.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
 
 func copyBlock(r io.Reader, w io.Writer) error {
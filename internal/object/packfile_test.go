@@ -46,6 +46,87 @@ func TestPackfileBuilder(t *testing.T) {
 	err = indexB.UnmarshalBinary(b)
 	assert.NoError(t, err)
 	assert.Equal(t, index, *indexB)
+
+	// Decode each block from the packfile
+	for i, block := range index.Blocks {
+		data, err := DecodeBlock(packfile[block.Offset:block.Offset+block.Size], sum.BLAKE3)
+		assert.NoError(t, err)
+		assert.Equal(t, chunks[i], data)
+	}
+
+	// Decode a corrupted block
+	corrupted := make([]byte, index.Blocks[0].Size)
+	copy(corrupted, packfile[index.Blocks[0].Offset:])
+	corrupted[len(corrupted)-1]++
+	_, err = DecodeBlock(corrupted, sum.BLAKE3)
+	assert.Error(t, err)
+}
+
+func TestLoadPackIndexLimited(t *testing.T) {
+	buf := new(bytes.Buffer)
+	builder, err := NewPackfileBuilder(buf)
+	assert.NoError(t, err)
+	assert.NoError(t, builder.Append(a, sum.Compute(a), compress.None))
+	assert.NoError(t, builder.Append(b, sum.Compute(b), compress.Zstd))
+	index := builder.Build()
+	packfile := buf.Bytes()
+	max := uint64(len(a))
+	if uint64(len(b)) > max {
+		max = uint64(len(b))
+	}
+
+	loaded, err := LoadPackIndexLimited(bytes.NewReader(packfile), PackLimits{MaxChunkSize: max, Size: uint64(len(packfile))})
+	assert.NoError(t, err)
+	assert.Equal(t, index, loaded)
+
+	// A chunk larger than the maximum is rejected
+	_, err = LoadPackIndexLimited(bytes.NewReader(packfile), PackLimits{MaxChunkSize: max - 1})
+	assert.Error(t, err)
+
+	// A block which extends past the end of the packfile is rejected before it's read
+	r := &countingReader{bytes.NewReader(packfile), 0}
+	_, err = LoadPackIndexLimited(r, PackLimits{Size: uint64(len(packfile)) - 1})
+	assert.Error(t, err)
+	assert.Less(t, r.bytesRead, uint64(len(packfile)))
+
+	// A truncated packfile is rejected
+	_, err = LoadPackIndexLimited(bytes.NewReader(packfile[:len(packfile)-1]), PackLimits{})
+	assert.Error(t, err)
+	_, err = LoadPackIndexLimited(bytes.NewReader(packfile[:len(packfile)-int(index.Blocks[1].Size)+3]), PackLimits{})
+	assert.Error(t, err)
+
+	// A corrupt block size does not exhaust memory
+	corrupt := append([]byte{}, packfile...)
+	for i := 0; i < 8; i++ {
+		corrupt[1+i] = 0xff
+	}
+	corrupt[8] = 0x7f
+	_, err = LoadPackIndexLimited(bytes.NewReader(corrupt), PackLimits{})
+	assert.Error(t, err)
+	_, err = DecodeBlock(corrupt[1:], sum.BLAKE3)
+	assert.Error(t, err)
+}
+
+func TestChunkHash(t *testing.T) {
+	buf := new(bytes.Buffer)
+	builder, err := NewPackfileBuilder(buf)
+	assert.NoError(t, err)
+	assert.NoError(t, builder.Append(a, sum.SHA256.Compute(a), compress.Zstd))
+	index := builder.Build()
+	packfile := buf.Bytes()
+
+	// Chunks are verified with the given hash function
+	loaded, err := LoadPackIndexLimited(bytes.NewReader(packfile), PackLimits{ChunkHash: sum.SHA256})
+	assert.NoError(t, err)
+	assert.Equal(t, index, loaded)
+	_, err = LoadPackIndex(bytes.NewReader(packfile))
+	assert.Error(t, err)
+
+	data, err := DecodeBlock(packfile[1:], sum.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, a, data)
+	_, err = DecodeBlock(packfile[1:], sum.BLAKE3)
+	assert.Error(t, err)
 }
 
 func TestEmptyBuilder(t *testing.T) {
@@ -0,0 +1,84 @@
+// Package pipenet serves HTTP handlers over in-memory connections, so a client in the
+// same process calls them as it would a server on the network, with request and
+// response bodies streamed rather than buffered.
+package pipenet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Listener is a net.Listener which accepts in-memory connections made by Dial.
+type Listener struct {
+	addr  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Listen returns a listener for the address addr, e.g. "jotfs.local:80". The address
+// only names the listener, and is not resolved.
+func Listen(addr string) *Listener {
+	return &Listener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Dial returns a connection to the listener once it's accepted.
+func (l *Listener) Dial(ctx context.Context) (net.Conn, error) {
+	local, remote := net.Pipe()
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.done:
+	case <-ctx.Done():
+	}
+	local.Close()
+	remote.Close()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("listener closed")
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close implements net.Listener.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr {
+	return addr(l.addr)
+}
+
+// Transport returns a clone of http.DefaultTransport which dials the listener for
+// requests to its address, and the network for requests to other addresses.
+func (l *Listener) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		if addr == l.addr {
+			return l.Dial(ctx)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return transport
+}
+
+type addr string
+
+func (addr) Network() string  { return "pipe" }
+func (a addr) String() string { return string(a) }
@@ -0,0 +1,47 @@
+package pipenet
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	ln := Listen("test.local:80")
+	next := make(chan struct{})
+	hsrv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		w.Write(b)
+		w.(http.Flusher).Flush()
+		<-next
+		w.Write([]byte(" world"))
+	})}
+	go hsrv.Serve(ln)
+	defer hsrv.Close()
+	hclient := &http.Client{Transport: ln.Transport()}
+
+	// The response is streamed before the handler returns
+	resp, err := hclient.Post("http://test.local/echo", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	close(next)
+	rest, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, " world", string(rest))
+
+	// Dials fail once the listener is closed
+	ln.Close()
+	_, err = ln.Dial(context.Background())
+	assert.Error(t, err)
+}
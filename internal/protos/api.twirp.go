@@ -6,6 +6,7 @@ Package protos is a generated twirp stub package.
 This code was generated with github.com/twitchtv/twirp/protoc-gen-twirp v5.10.1.
 
 It is generated from these files:
+
 	internal/protos/api.proto
 */
 package protos
@@ -37,16 +38,56 @@ type JotFS interface {
 
 	CreateFile(context.Context, *File) (*FileID, error)
 
+	Append(context.Context, *AppendRequest) (*FileID, error)
+
+	Concat(context.Context, *ConcatRequest) (*FileID, error)
+
 	List(context.Context, *ListRequest) (*ListResponse, error)
 
 	Head(context.Context, *HeadRequest) (*HeadResponse, error)
 
+	GetFileInfo(context.Context, *GetFileInfoRequest) (*GetFileInfoResponse, error)
+
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+
 	Download(context.Context, *FileID) (*DownloadResponse, error)
 
+	DownloadRange(context.Context, *DownloadRangeRequest) (*DownloadRangeResponse, error)
+
+	Diff(context.Context, *DiffRequest) (*DiffResponse, error)
+
+	DownloadDelta(context.Context, *DownloadDeltaRequest) (*DownloadDeltaResponse, error)
+
 	Copy(context.Context, *CopyRequest) (*FileID, error)
 
+	RevertToVersion(context.Context, *RevertToVersionRequest) (*FileID, error)
+
+	Rename(context.Context, *RenameRequest) (*RenameResponse, error)
+
+	SetMetadata(context.Context, *SetMetadataRequest) (*SetMetadataResponse, error)
+
+	TagVersion(context.Context, *TagVersionRequest) (*Empty, error)
+
+	UntagVersion(context.Context, *UntagVersionRequest) (*Empty, error)
+
+	ListTags(context.Context, *ListTagsRequest) (*ListTagsResponse, error)
+
 	Delete(context.Context, *FileID) (*Empty, error)
 
+	DeleteBatch(context.Context, *DeleteBatchRequest) (*DeleteBatchResponse, error)
+
+	DeletePrefix(context.Context, *DeletePrefixRequest) (*DeletePrefixResponse, error)
+
+	CreateSnapshot(context.Context, *CreateSnapshotRequest) (*Snapshot, error)
+
+	ListSnapshots(context.Context, *ListSnapshotsRequest) (*ListSnapshotsResponse, error)
+
+	DiffSnapshot(context.Context, *DiffSnapshotRequest) (*DiffSnapshotResponse, error)
+
+	RollbackSnapshot(context.Context, *RollbackSnapshotRequest) (*RollbackSnapshotResponse, error)
+
+	DeleteSnapshot(context.Context, *DeleteSnapshotRequest) (*Empty, error)
+
 	GetChunkerParams(context.Context, *Empty) (*ChunkerParams, error)
 
 	StartVacuum(context.Context, *Empty) (*VacuumID, error)
@@ -54,6 +95,8 @@ type JotFS interface {
 	VacuumStatus(context.Context, *VacuumID) (*Vacuum, error)
 
 	ServerStats(context.Context, *Empty) (*Stats, error)
+
+	ChunkReport(context.Context, *ChunkReportRequest) (*ChunkReportResponse, error)
 }
 
 // =====================
@@ -62,7 +105,7 @@ type JotFS interface {
 
 type jotFSProtobufClient struct {
 	client HTTPClient
-	urls   [11]string
+	urls   [32]string
 	opts   twirp.ClientOptions
 }
 
@@ -79,18 +122,39 @@ func NewJotFSProtobufClient(addr string, client HTTPClient, opts ...twirp.Client
 	}
 
 	prefix := urlBase(addr) + JotFSPathPrefix
-	urls := [11]string{
+	urls := [32]string{
 		prefix + "ChunksExist",
 		prefix + "CreateFile",
+		prefix + "Append",
+		prefix + "Concat",
 		prefix + "List",
 		prefix + "Head",
+		prefix + "GetFileInfo",
+		prefix + "Search",
 		prefix + "Download",
+		prefix + "DownloadRange",
+		prefix + "Diff",
+		prefix + "DownloadDelta",
 		prefix + "Copy",
+		prefix + "RevertToVersion",
+		prefix + "Rename",
+		prefix + "SetMetadata",
+		prefix + "TagVersion",
+		prefix + "UntagVersion",
+		prefix + "ListTags",
 		prefix + "Delete",
+		prefix + "DeleteBatch",
+		prefix + "DeletePrefix",
+		prefix + "CreateSnapshot",
+		prefix + "ListSnapshots",
+		prefix + "DiffSnapshot",
+		prefix + "RollbackSnapshot",
+		prefix + "DeleteSnapshot",
 		prefix + "GetChunkerParams",
 		prefix + "StartVacuum",
 		prefix + "VacuumStatus",
 		prefix + "ServerStats",
+		prefix + "ChunkReport",
 	}
 
 	return &jotFSProtobufClient{
@@ -140,11 +204,11 @@ func (c *jotFSProtobufClient) CreateFile(ctx context.Context, in *File) (*FileID
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) List(ctx context.Context, in *ListRequest) (*ListResponse, error) {
+func (c *jotFSProtobufClient) Append(ctx context.Context, in *AppendRequest) (*FileID, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "List")
-	out := new(ListResponse)
+	ctx = ctxsetters.WithMethodName(ctx, "Append")
+	out := new(FileID)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[2], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -160,11 +224,11 @@ func (c *jotFSProtobufClient) List(ctx context.Context, in *ListRequest) (*ListR
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) Head(ctx context.Context, in *HeadRequest) (*HeadResponse, error) {
+func (c *jotFSProtobufClient) Concat(ctx context.Context, in *ConcatRequest) (*FileID, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Head")
-	out := new(HeadResponse)
+	ctx = ctxsetters.WithMethodName(ctx, "Concat")
+	out := new(FileID)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[3], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -180,11 +244,11 @@ func (c *jotFSProtobufClient) Head(ctx context.Context, in *HeadRequest) (*HeadR
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) Download(ctx context.Context, in *FileID) (*DownloadResponse, error) {
+func (c *jotFSProtobufClient) List(ctx context.Context, in *ListRequest) (*ListResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Download")
-	out := new(DownloadResponse)
+	ctx = ctxsetters.WithMethodName(ctx, "List")
+	out := new(ListResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[4], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -200,11 +264,11 @@ func (c *jotFSProtobufClient) Download(ctx context.Context, in *FileID) (*Downlo
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) Copy(ctx context.Context, in *CopyRequest) (*FileID, error) {
+func (c *jotFSProtobufClient) Head(ctx context.Context, in *HeadRequest) (*HeadResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Copy")
-	out := new(FileID)
+	ctx = ctxsetters.WithMethodName(ctx, "Head")
+	out := new(HeadResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[5], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -220,11 +284,11 @@ func (c *jotFSProtobufClient) Copy(ctx context.Context, in *CopyRequest) (*FileI
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) Delete(ctx context.Context, in *FileID) (*Empty, error) {
+func (c *jotFSProtobufClient) GetFileInfo(ctx context.Context, in *GetFileInfoRequest) (*GetFileInfoResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Delete")
-	out := new(Empty)
+	ctx = ctxsetters.WithMethodName(ctx, "GetFileInfo")
+	out := new(GetFileInfoResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[6], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -240,11 +304,11 @@ func (c *jotFSProtobufClient) Delete(ctx context.Context, in *FileID) (*Empty, e
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) GetChunkerParams(ctx context.Context, in *Empty) (*ChunkerParams, error) {
+func (c *jotFSProtobufClient) Search(ctx context.Context, in *SearchRequest) (*SearchResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "GetChunkerParams")
-	out := new(ChunkerParams)
+	ctx = ctxsetters.WithMethodName(ctx, "Search")
+	out := new(SearchResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[7], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -260,11 +324,11 @@ func (c *jotFSProtobufClient) GetChunkerParams(ctx context.Context, in *Empty) (
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) StartVacuum(ctx context.Context, in *Empty) (*VacuumID, error) {
+func (c *jotFSProtobufClient) Download(ctx context.Context, in *FileID) (*DownloadResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "StartVacuum")
-	out := new(VacuumID)
+	ctx = ctxsetters.WithMethodName(ctx, "Download")
+	out := new(DownloadResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[8], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -280,11 +344,11 @@ func (c *jotFSProtobufClient) StartVacuum(ctx context.Context, in *Empty) (*Vacu
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) VacuumStatus(ctx context.Context, in *VacuumID) (*Vacuum, error) {
+func (c *jotFSProtobufClient) DownloadRange(ctx context.Context, in *DownloadRangeRequest) (*DownloadRangeResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "VacuumStatus")
-	out := new(Vacuum)
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadRange")
+	out := new(DownloadRangeResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[9], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -300,11 +364,11 @@ func (c *jotFSProtobufClient) VacuumStatus(ctx context.Context, in *VacuumID) (*
 	return out, nil
 }
 
-func (c *jotFSProtobufClient) ServerStats(ctx context.Context, in *Empty) (*Stats, error) {
+func (c *jotFSProtobufClient) Diff(ctx context.Context, in *DiffRequest) (*DiffResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "ServerStats")
-	out := new(Stats)
+	ctx = ctxsetters.WithMethodName(ctx, "Diff")
+	out := new(DiffResponse)
 	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[10], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
@@ -320,56 +384,52 @@ func (c *jotFSProtobufClient) ServerStats(ctx context.Context, in *Empty) (*Stat
 	return out, nil
 }
 
-// =================
-// JotFS JSON Client
-// =================
+func (c *jotFSProtobufClient) DownloadDelta(ctx context.Context, in *DownloadDeltaRequest) (*DownloadDeltaResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadDelta")
+	out := new(DownloadDeltaResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[11], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
 
-type jotFSJSONClient struct {
-	client HTTPClient
-	urls   [11]string
-	opts   twirp.ClientOptions
-}
+	callClientResponseReceived(ctx, c.opts.Hooks)
 
-// NewJotFSJSONClient creates a JSON client that implements the JotFS interface.
-// It communicates using JSON and can be configured with a custom HTTPClient.
-func NewJotFSJSONClient(addr string, client HTTPClient, opts ...twirp.ClientOption) JotFS {
-	if c, ok := client.(*http.Client); ok {
-		client = withoutRedirects(c)
-	}
+	return out, nil
+}
 
-	clientOpts := twirp.ClientOptions{}
-	for _, o := range opts {
-		o(&clientOpts)
+func (c *jotFSProtobufClient) Copy(ctx context.Context, in *CopyRequest) (*FileID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Copy")
+	out := new(FileID)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[12], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
 
-	prefix := urlBase(addr) + JotFSPathPrefix
-	urls := [11]string{
-		prefix + "ChunksExist",
-		prefix + "CreateFile",
-		prefix + "List",
-		prefix + "Head",
-		prefix + "Download",
-		prefix + "Copy",
-		prefix + "Delete",
-		prefix + "GetChunkerParams",
-		prefix + "StartVacuum",
-		prefix + "VacuumStatus",
-		prefix + "ServerStats",
-	}
+	callClientResponseReceived(ctx, c.opts.Hooks)
 
-	return &jotFSJSONClient{
-		client: client,
-		urls:   urls,
-		opts:   clientOpts,
-	}
+	return out, nil
 }
 
-func (c *jotFSJSONClient) ChunksExist(ctx context.Context, in *ChunksExistRequest) (*ChunksExistResponse, error) {
+func (c *jotFSProtobufClient) RevertToVersion(ctx context.Context, in *RevertToVersionRequest) (*FileID, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "ChunksExist")
-	out := new(ChunksExistResponse)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[0], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "RevertToVersion")
+	out := new(FileID)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[13], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -384,12 +444,12 @@ func (c *jotFSJSONClient) ChunksExist(ctx context.Context, in *ChunksExistReques
 	return out, nil
 }
 
-func (c *jotFSJSONClient) CreateFile(ctx context.Context, in *File) (*FileID, error) {
+func (c *jotFSProtobufClient) Rename(ctx context.Context, in *RenameRequest) (*RenameResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "CreateFile")
-	out := new(FileID)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[1], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "Rename")
+	out := new(RenameResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[14], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -404,12 +464,12 @@ func (c *jotFSJSONClient) CreateFile(ctx context.Context, in *File) (*FileID, er
 	return out, nil
 }
 
-func (c *jotFSJSONClient) List(ctx context.Context, in *ListRequest) (*ListResponse, error) {
+func (c *jotFSProtobufClient) SetMetadata(ctx context.Context, in *SetMetadataRequest) (*SetMetadataResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "List")
-	out := new(ListResponse)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[2], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "SetMetadata")
+	out := new(SetMetadataResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[15], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -424,12 +484,12 @@ func (c *jotFSJSONClient) List(ctx context.Context, in *ListRequest) (*ListRespo
 	return out, nil
 }
 
-func (c *jotFSJSONClient) Head(ctx context.Context, in *HeadRequest) (*HeadResponse, error) {
+func (c *jotFSProtobufClient) TagVersion(ctx context.Context, in *TagVersionRequest) (*Empty, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Head")
-	out := new(HeadResponse)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[3], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "TagVersion")
+	out := new(Empty)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[16], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -444,12 +504,12 @@ func (c *jotFSJSONClient) Head(ctx context.Context, in *HeadRequest) (*HeadRespo
 	return out, nil
 }
 
-func (c *jotFSJSONClient) Download(ctx context.Context, in *FileID) (*DownloadResponse, error) {
+func (c *jotFSProtobufClient) UntagVersion(ctx context.Context, in *UntagVersionRequest) (*Empty, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Download")
-	out := new(DownloadResponse)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[4], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "UntagVersion")
+	out := new(Empty)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[17], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -464,12 +524,12 @@ func (c *jotFSJSONClient) Download(ctx context.Context, in *FileID) (*DownloadRe
 	return out, nil
 }
 
-func (c *jotFSJSONClient) Copy(ctx context.Context, in *CopyRequest) (*FileID, error) {
+func (c *jotFSProtobufClient) ListTags(ctx context.Context, in *ListTagsRequest) (*ListTagsResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "Copy")
-	out := new(FileID)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[5], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "ListTags")
+	out := new(ListTagsResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[18], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -484,12 +544,12 @@ func (c *jotFSJSONClient) Copy(ctx context.Context, in *CopyRequest) (*FileID, e
 	return out, nil
 }
 
-func (c *jotFSJSONClient) Delete(ctx context.Context, in *FileID) (*Empty, error) {
+func (c *jotFSProtobufClient) Delete(ctx context.Context, in *FileID) (*Empty, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
 	ctx = ctxsetters.WithMethodName(ctx, "Delete")
 	out := new(Empty)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[6], in, out)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[19], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -504,12 +564,12 @@ func (c *jotFSJSONClient) Delete(ctx context.Context, in *FileID) (*Empty, error
 	return out, nil
 }
 
-func (c *jotFSJSONClient) GetChunkerParams(ctx context.Context, in *Empty) (*ChunkerParams, error) {
+func (c *jotFSProtobufClient) DeleteBatch(ctx context.Context, in *DeleteBatchRequest) (*DeleteBatchResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "GetChunkerParams")
-	out := new(ChunkerParams)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[7], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteBatch")
+	out := new(DeleteBatchResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[20], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -524,12 +584,12 @@ func (c *jotFSJSONClient) GetChunkerParams(ctx context.Context, in *Empty) (*Chu
 	return out, nil
 }
 
-func (c *jotFSJSONClient) StartVacuum(ctx context.Context, in *Empty) (*VacuumID, error) {
+func (c *jotFSProtobufClient) DeletePrefix(ctx context.Context, in *DeletePrefixRequest) (*DeletePrefixResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "StartVacuum")
-	out := new(VacuumID)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[8], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "DeletePrefix")
+	out := new(DeletePrefixResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[21], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -544,12 +604,12 @@ func (c *jotFSJSONClient) StartVacuum(ctx context.Context, in *Empty) (*VacuumID
 	return out, nil
 }
 
-func (c *jotFSJSONClient) VacuumStatus(ctx context.Context, in *VacuumID) (*Vacuum, error) {
+func (c *jotFSProtobufClient) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest) (*Snapshot, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "VacuumStatus")
-	out := new(Vacuum)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[9], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "CreateSnapshot")
+	out := new(Snapshot)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[22], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -564,12 +624,12 @@ func (c *jotFSJSONClient) VacuumStatus(ctx context.Context, in *VacuumID) (*Vacu
 	return out, nil
 }
 
-func (c *jotFSJSONClient) ServerStats(ctx context.Context, in *Empty) (*Stats, error) {
+func (c *jotFSProtobufClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithMethodName(ctx, "ServerStats")
-	out := new(Stats)
-	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[10], in, out)
+	ctx = ctxsetters.WithMethodName(ctx, "ListSnapshots")
+	out := new(ListSnapshotsResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[23], in, out)
 	if err != nil {
 		twerr, ok := err.(twirp.Error)
 		if !ok {
@@ -584,96 +644,3604 @@ func (c *jotFSJSONClient) ServerStats(ctx context.Context, in *Empty) (*Stats, e
 	return out, nil
 }
 
-// ====================
-// JotFS Server Handler
-// ====================
+func (c *jotFSProtobufClient) DiffSnapshot(ctx context.Context, in *DiffSnapshotRequest) (*DiffSnapshotResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DiffSnapshot")
+	out := new(DiffSnapshotResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[24], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
 
-type jotFSServer struct {
-	JotFS
-	hooks *twirp.ServerHooks
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
 }
 
-func NewJotFSServer(svc JotFS, hooks *twirp.ServerHooks) TwirpServer {
-	return &jotFSServer{
-		JotFS: svc,
-		hooks: hooks,
+func (c *jotFSProtobufClient) RollbackSnapshot(ctx context.Context, in *RollbackSnapshotRequest) (*RollbackSnapshotResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "RollbackSnapshot")
+	out := new(RollbackSnapshotResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[25], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
-}
 
-// writeError writes an HTTP response with a valid Twirp error format, and triggers hooks.
-// If err is not a twirp.Error, it will get wrapped with twirp.InternalErrorWith(err)
-func (s *jotFSServer) writeError(ctx context.Context, resp http.ResponseWriter, err error) {
-	writeError(ctx, resp, err, s.hooks)
-}
+	callClientResponseReceived(ctx, c.opts.Hooks)
 
-// JotFSPathPrefix is used for all URL paths on a twirp JotFS server.
-// Requests are always: POST JotFSPathPrefix/method
-// It can be used in an HTTP mux to route twirp requests along with non-twirp requests on other routes.
-const JotFSPathPrefix = "/twirp/server.JotFS/"
+	return out, nil
+}
 
-func (s *jotFSServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	ctx := req.Context()
+func (c *jotFSProtobufClient) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest) (*Empty, error) {
 	ctx = ctxsetters.WithPackageName(ctx, "server")
 	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
-	ctx = ctxsetters.WithResponseWriter(ctx, resp)
-
-	var err error
-	ctx, err = callRequestReceived(ctx, s.hooks)
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteSnapshot")
+	out := new(Empty)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[26], in, out)
 	if err != nil {
-		s.writeError(ctx, resp, err)
-		return
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
 
-	if req.Method != "POST" {
-		msg := fmt.Sprintf("unsupported method %q (only POST is allowed)", req.Method)
-		err = badRouteError(msg, req.Method, req.URL.Path)
-		s.writeError(ctx, resp, err)
-		return
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSProtobufClient) GetChunkerParams(ctx context.Context, in *Empty) (*ChunkerParams, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "GetChunkerParams")
+	out := new(ChunkerParams)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[27], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
 	}
 
-	switch req.URL.Path {
-	case "/twirp/server.JotFS/ChunksExist":
-		s.serveChunksExist(ctx, resp, req)
-		return
-	case "/twirp/server.JotFS/CreateFile":
-		s.serveCreateFile(ctx, resp, req)
-		return
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSProtobufClient) StartVacuum(ctx context.Context, in *Empty) (*VacuumID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "StartVacuum")
+	out := new(VacuumID)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[28], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSProtobufClient) VacuumStatus(ctx context.Context, in *VacuumID) (*Vacuum, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "VacuumStatus")
+	out := new(Vacuum)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[29], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSProtobufClient) ServerStats(ctx context.Context, in *Empty) (*Stats, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ServerStats")
+	out := new(Stats)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[30], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSProtobufClient) ChunkReport(ctx context.Context, in *ChunkReportRequest) (*ChunkReportResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ChunkReport")
+	out := new(ChunkReportResponse)
+	err := doProtobufRequest(ctx, c.client, c.opts.Hooks, c.urls[31], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+// =================
+// JotFS JSON Client
+// =================
+
+type jotFSJSONClient struct {
+	client HTTPClient
+	urls   [32]string
+	opts   twirp.ClientOptions
+}
+
+// NewJotFSJSONClient creates a JSON client that implements the JotFS interface.
+// It communicates using JSON and can be configured with a custom HTTPClient.
+func NewJotFSJSONClient(addr string, client HTTPClient, opts ...twirp.ClientOption) JotFS {
+	if c, ok := client.(*http.Client); ok {
+		client = withoutRedirects(c)
+	}
+
+	clientOpts := twirp.ClientOptions{}
+	for _, o := range opts {
+		o(&clientOpts)
+	}
+
+	prefix := urlBase(addr) + JotFSPathPrefix
+	urls := [32]string{
+		prefix + "ChunksExist",
+		prefix + "CreateFile",
+		prefix + "Append",
+		prefix + "Concat",
+		prefix + "List",
+		prefix + "Head",
+		prefix + "GetFileInfo",
+		prefix + "Search",
+		prefix + "Download",
+		prefix + "DownloadRange",
+		prefix + "Diff",
+		prefix + "DownloadDelta",
+		prefix + "Copy",
+		prefix + "RevertToVersion",
+		prefix + "Rename",
+		prefix + "SetMetadata",
+		prefix + "TagVersion",
+		prefix + "UntagVersion",
+		prefix + "ListTags",
+		prefix + "Delete",
+		prefix + "DeleteBatch",
+		prefix + "DeletePrefix",
+		prefix + "CreateSnapshot",
+		prefix + "ListSnapshots",
+		prefix + "DiffSnapshot",
+		prefix + "RollbackSnapshot",
+		prefix + "DeleteSnapshot",
+		prefix + "GetChunkerParams",
+		prefix + "StartVacuum",
+		prefix + "VacuumStatus",
+		prefix + "ServerStats",
+		prefix + "ChunkReport",
+	}
+
+	return &jotFSJSONClient{
+		client: client,
+		urls:   urls,
+		opts:   clientOpts,
+	}
+}
+
+func (c *jotFSJSONClient) ChunksExist(ctx context.Context, in *ChunksExistRequest) (*ChunksExistResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ChunksExist")
+	out := new(ChunksExistResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[0], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) CreateFile(ctx context.Context, in *File) (*FileID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "CreateFile")
+	out := new(FileID)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[1], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Append(ctx context.Context, in *AppendRequest) (*FileID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Append")
+	out := new(FileID)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[2], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Concat(ctx context.Context, in *ConcatRequest) (*FileID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Concat")
+	out := new(FileID)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[3], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) List(ctx context.Context, in *ListRequest) (*ListResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "List")
+	out := new(ListResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[4], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Head(ctx context.Context, in *HeadRequest) (*HeadResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Head")
+	out := new(HeadResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[5], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) GetFileInfo(ctx context.Context, in *GetFileInfoRequest) (*GetFileInfoResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "GetFileInfo")
+	out := new(GetFileInfoResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[6], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Search(ctx context.Context, in *SearchRequest) (*SearchResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Search")
+	out := new(SearchResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[7], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Download(ctx context.Context, in *FileID) (*DownloadResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Download")
+	out := new(DownloadResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[8], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) DownloadRange(ctx context.Context, in *DownloadRangeRequest) (*DownloadRangeResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadRange")
+	out := new(DownloadRangeResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[9], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Diff(ctx context.Context, in *DiffRequest) (*DiffResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Diff")
+	out := new(DiffResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[10], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) DownloadDelta(ctx context.Context, in *DownloadDeltaRequest) (*DownloadDeltaResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadDelta")
+	out := new(DownloadDeltaResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[11], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Copy(ctx context.Context, in *CopyRequest) (*FileID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Copy")
+	out := new(FileID)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[12], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) RevertToVersion(ctx context.Context, in *RevertToVersionRequest) (*FileID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "RevertToVersion")
+	out := new(FileID)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[13], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Rename(ctx context.Context, in *RenameRequest) (*RenameResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Rename")
+	out := new(RenameResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[14], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) SetMetadata(ctx context.Context, in *SetMetadataRequest) (*SetMetadataResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "SetMetadata")
+	out := new(SetMetadataResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[15], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) TagVersion(ctx context.Context, in *TagVersionRequest) (*Empty, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "TagVersion")
+	out := new(Empty)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[16], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) UntagVersion(ctx context.Context, in *UntagVersionRequest) (*Empty, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "UntagVersion")
+	out := new(Empty)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[17], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) ListTags(ctx context.Context, in *ListTagsRequest) (*ListTagsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ListTags")
+	out := new(ListTagsResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[18], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) Delete(ctx context.Context, in *FileID) (*Empty, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "Delete")
+	out := new(Empty)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[19], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) DeleteBatch(ctx context.Context, in *DeleteBatchRequest) (*DeleteBatchResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteBatch")
+	out := new(DeleteBatchResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[20], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) DeletePrefix(ctx context.Context, in *DeletePrefixRequest) (*DeletePrefixResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DeletePrefix")
+	out := new(DeletePrefixResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[21], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest) (*Snapshot, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "CreateSnapshot")
+	out := new(Snapshot)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[22], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ListSnapshots")
+	out := new(ListSnapshotsResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[23], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) DiffSnapshot(ctx context.Context, in *DiffSnapshotRequest) (*DiffSnapshotResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DiffSnapshot")
+	out := new(DiffSnapshotResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[24], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) RollbackSnapshot(ctx context.Context, in *RollbackSnapshotRequest) (*RollbackSnapshotResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "RollbackSnapshot")
+	out := new(RollbackSnapshotResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[25], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest) (*Empty, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteSnapshot")
+	out := new(Empty)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[26], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) GetChunkerParams(ctx context.Context, in *Empty) (*ChunkerParams, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "GetChunkerParams")
+	out := new(ChunkerParams)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[27], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) StartVacuum(ctx context.Context, in *Empty) (*VacuumID, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "StartVacuum")
+	out := new(VacuumID)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[28], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) VacuumStatus(ctx context.Context, in *VacuumID) (*Vacuum, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "VacuumStatus")
+	out := new(Vacuum)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[29], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) ServerStats(ctx context.Context, in *Empty) (*Stats, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ServerStats")
+	out := new(Stats)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[30], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+func (c *jotFSJSONClient) ChunkReport(ctx context.Context, in *ChunkReportRequest) (*ChunkReportResponse, error) {
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithMethodName(ctx, "ChunkReport")
+	out := new(ChunkReportResponse)
+	err := doJSONRequest(ctx, c.client, c.opts.Hooks, c.urls[31], in, out)
+	if err != nil {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+		callClientError(ctx, c.opts.Hooks, twerr)
+		return nil, err
+	}
+
+	callClientResponseReceived(ctx, c.opts.Hooks)
+
+	return out, nil
+}
+
+// ====================
+// JotFS Server Handler
+// ====================
+
+type jotFSServer struct {
+	JotFS
+	hooks *twirp.ServerHooks
+}
+
+func NewJotFSServer(svc JotFS, hooks *twirp.ServerHooks) TwirpServer {
+	return &jotFSServer{
+		JotFS: svc,
+		hooks: hooks,
+	}
+}
+
+// writeError writes an HTTP response with a valid Twirp error format, and triggers hooks.
+// If err is not a twirp.Error, it will get wrapped with twirp.InternalErrorWith(err)
+func (s *jotFSServer) writeError(ctx context.Context, resp http.ResponseWriter, err error) {
+	writeError(ctx, resp, err, s.hooks)
+}
+
+// JotFSPathPrefix is used for all URL paths on a twirp JotFS server.
+// Requests are always: POST JotFSPathPrefix/method
+// It can be used in an HTTP mux to route twirp requests along with non-twirp requests on other routes.
+const JotFSPathPrefix = "/twirp/server.JotFS/"
+
+func (s *jotFSServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	ctx = ctxsetters.WithPackageName(ctx, "server")
+	ctx = ctxsetters.WithServiceName(ctx, "JotFS")
+	ctx = ctxsetters.WithResponseWriter(ctx, resp)
+
+	var err error
+	ctx, err = callRequestReceived(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	if req.Method != "POST" {
+		msg := fmt.Sprintf("unsupported method %q (only POST is allowed)", req.Method)
+		err = badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/twirp/server.JotFS/ChunksExist":
+		s.serveChunksExist(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/CreateFile":
+		s.serveCreateFile(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Append":
+		s.serveAppend(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Concat":
+		s.serveConcat(ctx, resp, req)
+		return
 	case "/twirp/server.JotFS/List":
 		s.serveList(ctx, resp, req)
 		return
-	case "/twirp/server.JotFS/Head":
-		s.serveHead(ctx, resp, req)
+	case "/twirp/server.JotFS/Head":
+		s.serveHead(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/GetFileInfo":
+		s.serveGetFileInfo(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Search":
+		s.serveSearch(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Download":
+		s.serveDownload(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/DownloadRange":
+		s.serveDownloadRange(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Diff":
+		s.serveDiff(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/DownloadDelta":
+		s.serveDownloadDelta(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Copy":
+		s.serveCopy(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/RevertToVersion":
+		s.serveRevertToVersion(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Rename":
+		s.serveRename(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/SetMetadata":
+		s.serveSetMetadata(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/TagVersion":
+		s.serveTagVersion(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/UntagVersion":
+		s.serveUntagVersion(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/ListTags":
+		s.serveListTags(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/Delete":
+		s.serveDelete(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/DeleteBatch":
+		s.serveDeleteBatch(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/DeletePrefix":
+		s.serveDeletePrefix(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/CreateSnapshot":
+		s.serveCreateSnapshot(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/ListSnapshots":
+		s.serveListSnapshots(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/DiffSnapshot":
+		s.serveDiffSnapshot(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/RollbackSnapshot":
+		s.serveRollbackSnapshot(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/DeleteSnapshot":
+		s.serveDeleteSnapshot(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/GetChunkerParams":
+		s.serveGetChunkerParams(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/StartVacuum":
+		s.serveStartVacuum(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/VacuumStatus":
+		s.serveVacuumStatus(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/ServerStats":
+		s.serveServerStats(ctx, resp, req)
+		return
+	case "/twirp/server.JotFS/ChunkReport":
+		s.serveChunkReport(ctx, resp, req)
+		return
+	default:
+		msg := fmt.Sprintf("no handler for path %q", req.URL.Path)
+		err = badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, err)
+		return
+	}
+}
+
+func (s *jotFSServer) serveChunksExist(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveChunksExistJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveChunksExistProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveChunksExistJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ChunksExist")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(ChunksExistRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ChunksExistResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.ChunksExist(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ChunksExistResponse and nil error while calling ChunksExist. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveChunksExistProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ChunksExist")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(ChunksExistRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ChunksExistResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.ChunksExist(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ChunksExistResponse and nil error while calling ChunksExist. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveCreateFile(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveCreateFileJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveCreateFileProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveCreateFileJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "CreateFile")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(File)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.CreateFile(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling CreateFile. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveCreateFileProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "CreateFile")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(File)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.CreateFile(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling CreateFile. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveAppend(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveAppendJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveAppendProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveAppendJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Append")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(AppendRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Append(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Append. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveAppendProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Append")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(AppendRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Append(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Append. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveConcat(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveConcatJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveConcatProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveConcatJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Concat")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(ConcatRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Concat(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Concat. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveConcatProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Concat")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(ConcatRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Concat(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Concat. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveList(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveListJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveListProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveListJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "List")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(ListRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ListResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.List(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListResponse and nil error while calling List. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveListProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "List")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(ListRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ListResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.List(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListResponse and nil error while calling List. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveHead(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveHeadJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveHeadProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveHeadJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Head")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(HeadRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *HeadResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Head(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *HeadResponse and nil error while calling Head. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveHeadProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Head")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(HeadRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *HeadResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Head(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *HeadResponse and nil error while calling Head. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveGetFileInfo(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveGetFileInfoJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveGetFileInfoProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveGetFileInfoJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "GetFileInfo")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(GetFileInfoRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *GetFileInfoResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.GetFileInfo(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *GetFileInfoResponse and nil error while calling GetFileInfo. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveGetFileInfoProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "GetFileInfo")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(GetFileInfoRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *GetFileInfoResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.GetFileInfo(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *GetFileInfoResponse and nil error while calling GetFileInfo. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveSearch(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveSearchJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveSearchProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveSearchJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Search")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(SearchRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *SearchResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Search(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SearchResponse and nil error while calling Search. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveSearchProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Search")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(SearchRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *SearchResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Search(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SearchResponse and nil error while calling Search. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDownload(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDownloadJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDownloadProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveDownloadJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Download")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(FileID)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DownloadResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Download(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadResponse and nil error while calling Download. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDownloadProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Download")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(FileID)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DownloadResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Download(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadResponse and nil error while calling Download. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDownloadRange(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDownloadRangeJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDownloadRangeProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveDownloadRangeJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadRange")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(DownloadRangeRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DownloadRangeResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.DownloadRange(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadRangeResponse and nil error while calling DownloadRange. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDownloadRangeProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadRange")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(DownloadRangeRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DownloadRangeResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.DownloadRange(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadRangeResponse and nil error while calling DownloadRange. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDiff(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDiffJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDiffProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveDiffJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Diff")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(DiffRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DiffResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Diff(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DiffResponse and nil error while calling Diff. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDiffProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Diff")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(DiffRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DiffResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Diff(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DiffResponse and nil error while calling Diff. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDownloadDelta(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDownloadDeltaJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDownloadDeltaProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveDownloadDeltaJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadDelta")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(DownloadDeltaRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DownloadDeltaResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.DownloadDelta(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadDeltaResponse and nil error while calling DownloadDelta. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDownloadDeltaProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "DownloadDelta")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(DownloadDeltaRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *DownloadDeltaResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.DownloadDelta(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadDeltaResponse and nil error while calling DownloadDelta. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveCopy(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveCopyJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveCopyProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveCopyJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Copy")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(CopyRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Copy(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Copy. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveCopyProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Copy")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(CopyRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Copy(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Copy. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveRevertToVersion(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveRevertToVersionJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveRevertToVersionProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveRevertToVersionJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "RevertToVersion")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(RevertToVersionRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.RevertToVersion(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling RevertToVersion. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveRevertToVersionProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "RevertToVersion")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(RevertToVersionRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *FileID
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.RevertToVersion(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling RevertToVersion. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveRename(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveRenameJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveRenameProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveRenameJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Rename")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(RenameRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *RenameResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Rename(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RenameResponse and nil error while calling Rename. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveRenameProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Rename")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(RenameRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *RenameResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Rename(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RenameResponse and nil error while calling Rename. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveSetMetadata(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveSetMetadataJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveSetMetadataProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveSetMetadataJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "SetMetadata")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(SetMetadataRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *SetMetadataResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.SetMetadata(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SetMetadataResponse and nil error while calling SetMetadata. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveSetMetadataProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "SetMetadata")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(SetMetadataRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *SetMetadataResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.SetMetadata(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *SetMetadataResponse and nil error while calling SetMetadata. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveTagVersion(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveTagVersionJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveTagVersionProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveTagVersionJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "TagVersion")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(TagVersionRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *Empty
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.TagVersion(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling TagVersion. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveTagVersionProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "TagVersion")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(TagVersionRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *Empty
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.TagVersion(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling TagVersion. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveUntagVersion(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveUntagVersionJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveUntagVersionProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveUntagVersionJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "UntagVersion")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(UntagVersionRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *Empty
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.UntagVersion(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling UntagVersion. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveUntagVersionProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "UntagVersion")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
-	case "/twirp/server.JotFS/Download":
-		s.serveDownload(ctx, resp, req)
+	}
+	reqContent := new(UntagVersionRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
-	case "/twirp/server.JotFS/Copy":
-		s.serveCopy(ctx, resp, req)
+	}
+
+	// Call service method
+	var respContent *Empty
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.UntagVersion(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
 		return
-	case "/twirp/server.JotFS/Delete":
-		s.serveDelete(ctx, resp, req)
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling UntagVersion. nil responses are not supported"))
 		return
-	case "/twirp/server.JotFS/GetChunkerParams":
-		s.serveGetChunkerParams(ctx, resp, req)
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
 		return
-	case "/twirp/server.JotFS/StartVacuum":
-		s.serveStartVacuum(ctx, resp, req)
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveListTags(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveListTagsJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveListTagsProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveListTagsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListTags")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
 		return
-	case "/twirp/server.JotFS/VacuumStatus":
-		s.serveVacuumStatus(ctx, resp, req)
+	}
+
+	reqContent := new(ListTagsRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
 		return
-	case "/twirp/server.JotFS/ServerStats":
-		s.serveServerStats(ctx, resp, req)
+	}
+
+	// Call service method
+	var respContent *ListTagsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.ListTags(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListTagsResponse and nil error while calling ListTags. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveListTagsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ListTags")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(ListTagsRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ListTagsResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.ListTags(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListTagsResponse and nil error while calling ListTags. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDelete(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveDeleteJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveDeleteProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveDeleteJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Delete")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(FileID)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *Empty
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Delete(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling Delete. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveDeleteProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "Delete")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(FileID)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
-	default:
-		msg := fmt.Sprintf("no handler for path %q", req.URL.Path)
-		err = badRouteError(msg, req.Method, req.URL.Path)
+	}
+
+	// Call service method
+	var respContent *Empty
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.Delete(ctx, reqContent)
+	}()
+
+	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling Delete. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveChunksExist(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeleteBatch(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -681,9 +4249,9 @@ func (s *jotFSServer) serveChunksExist(ctx context.Context, resp http.ResponseWr
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveChunksExistJSON(ctx, resp, req)
+		s.serveDeleteBatchJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveChunksExistProtobuf(ctx, resp, req)
+		s.serveDeleteBatchProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -691,16 +4259,16 @@ func (s *jotFSServer) serveChunksExist(ctx context.Context, resp http.ResponseWr
 	}
 }
 
-func (s *jotFSServer) serveChunksExistJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeleteBatchJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "ChunksExist")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteBatch")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(ChunksExistRequest)
+	reqContent := new(DeleteBatchRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -708,10 +4276,10 @@ func (s *jotFSServer) serveChunksExistJSON(ctx context.Context, resp http.Respon
 	}
 
 	// Call service method
-	var respContent *ChunksExistResponse
+	var respContent *DeleteBatchResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.ChunksExist(ctx, reqContent)
+		respContent, err = s.JotFS.DeleteBatch(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -719,7 +4287,7 @@ func (s *jotFSServer) serveChunksExistJSON(ctx context.Context, resp http.Respon
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ChunksExistResponse and nil error while calling ChunksExist. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DeleteBatchResponse and nil error while calling DeleteBatch. nil responses are not supported"))
 		return
 	}
 
@@ -746,9 +4314,9 @@ func (s *jotFSServer) serveChunksExistJSON(ctx context.Context, resp http.Respon
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveChunksExistProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeleteBatchProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "ChunksExist")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteBatch")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -760,17 +4328,17 @@ func (s *jotFSServer) serveChunksExistProtobuf(ctx context.Context, resp http.Re
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(ChunksExistRequest)
+	reqContent := new(DeleteBatchRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
 	// Call service method
-	var respContent *ChunksExistResponse
+	var respContent *DeleteBatchResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.ChunksExist(ctx, reqContent)
+		respContent, err = s.JotFS.DeleteBatch(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -778,7 +4346,7 @@ func (s *jotFSServer) serveChunksExistProtobuf(ctx context.Context, resp http.Re
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ChunksExistResponse and nil error while calling ChunksExist. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DeleteBatchResponse and nil error while calling DeleteBatch. nil responses are not supported"))
 		return
 	}
 
@@ -802,7 +4370,7 @@ func (s *jotFSServer) serveChunksExistProtobuf(ctx context.Context, resp http.Re
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveCreateFile(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeletePrefix(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -810,9 +4378,9 @@ func (s *jotFSServer) serveCreateFile(ctx context.Context, resp http.ResponseWri
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveCreateFileJSON(ctx, resp, req)
+		s.serveDeletePrefixJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveCreateFileProtobuf(ctx, resp, req)
+		s.serveDeletePrefixProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -820,16 +4388,16 @@ func (s *jotFSServer) serveCreateFile(ctx context.Context, resp http.ResponseWri
 	}
 }
 
-func (s *jotFSServer) serveCreateFileJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeletePrefixJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "CreateFile")
+	ctx = ctxsetters.WithMethodName(ctx, "DeletePrefix")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(File)
+	reqContent := new(DeletePrefixRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -837,10 +4405,10 @@ func (s *jotFSServer) serveCreateFileJSON(ctx context.Context, resp http.Respons
 	}
 
 	// Call service method
-	var respContent *FileID
+	var respContent *DeletePrefixResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.CreateFile(ctx, reqContent)
+		respContent, err = s.JotFS.DeletePrefix(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -848,7 +4416,7 @@ func (s *jotFSServer) serveCreateFileJSON(ctx context.Context, resp http.Respons
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling CreateFile. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DeletePrefixResponse and nil error while calling DeletePrefix. nil responses are not supported"))
 		return
 	}
 
@@ -875,9 +4443,9 @@ func (s *jotFSServer) serveCreateFileJSON(ctx context.Context, resp http.Respons
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveCreateFileProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeletePrefixProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "CreateFile")
+	ctx = ctxsetters.WithMethodName(ctx, "DeletePrefix")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -889,17 +4457,17 @@ func (s *jotFSServer) serveCreateFileProtobuf(ctx context.Context, resp http.Res
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(File)
+	reqContent := new(DeletePrefixRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
 	// Call service method
-	var respContent *FileID
+	var respContent *DeletePrefixResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.CreateFile(ctx, reqContent)
+		respContent, err = s.JotFS.DeletePrefix(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -907,7 +4475,7 @@ func (s *jotFSServer) serveCreateFileProtobuf(ctx context.Context, resp http.Res
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling CreateFile. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DeletePrefixResponse and nil error while calling DeletePrefix. nil responses are not supported"))
 		return
 	}
 
@@ -931,7 +4499,7 @@ func (s *jotFSServer) serveCreateFileProtobuf(ctx context.Context, resp http.Res
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveList(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveCreateSnapshot(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -939,9 +4507,9 @@ func (s *jotFSServer) serveList(ctx context.Context, resp http.ResponseWriter, r
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveListJSON(ctx, resp, req)
+		s.serveCreateSnapshotJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveListProtobuf(ctx, resp, req)
+		s.serveCreateSnapshotProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -949,16 +4517,16 @@ func (s *jotFSServer) serveList(ctx context.Context, resp http.ResponseWriter, r
 	}
 }
 
-func (s *jotFSServer) serveListJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveCreateSnapshotJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "List")
+	ctx = ctxsetters.WithMethodName(ctx, "CreateSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(ListRequest)
+	reqContent := new(CreateSnapshotRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -966,10 +4534,10 @@ func (s *jotFSServer) serveListJSON(ctx context.Context, resp http.ResponseWrite
 	}
 
 	// Call service method
-	var respContent *ListResponse
+	var respContent *Snapshot
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.List(ctx, reqContent)
+		respContent, err = s.JotFS.CreateSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -977,7 +4545,7 @@ func (s *jotFSServer) serveListJSON(ctx context.Context, resp http.ResponseWrite
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListResponse and nil error while calling List. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Snapshot and nil error while calling CreateSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1004,9 +4572,9 @@ func (s *jotFSServer) serveListJSON(ctx context.Context, resp http.ResponseWrite
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveListProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveCreateSnapshotProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "List")
+	ctx = ctxsetters.WithMethodName(ctx, "CreateSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1018,17 +4586,17 @@ func (s *jotFSServer) serveListProtobuf(ctx context.Context, resp http.ResponseW
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(ListRequest)
+	reqContent := new(CreateSnapshotRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
 	// Call service method
-	var respContent *ListResponse
+	var respContent *Snapshot
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.List(ctx, reqContent)
+		respContent, err = s.JotFS.CreateSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1036,7 +4604,7 @@ func (s *jotFSServer) serveListProtobuf(ctx context.Context, resp http.ResponseW
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListResponse and nil error while calling List. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Snapshot and nil error while calling CreateSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1060,7 +4628,7 @@ func (s *jotFSServer) serveListProtobuf(ctx context.Context, resp http.ResponseW
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveHead(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveListSnapshots(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -1068,9 +4636,9 @@ func (s *jotFSServer) serveHead(ctx context.Context, resp http.ResponseWriter, r
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveHeadJSON(ctx, resp, req)
+		s.serveListSnapshotsJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveHeadProtobuf(ctx, resp, req)
+		s.serveListSnapshotsProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -1078,16 +4646,16 @@ func (s *jotFSServer) serveHead(ctx context.Context, resp http.ResponseWriter, r
 	}
 }
 
-func (s *jotFSServer) serveHeadJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveListSnapshotsJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Head")
+	ctx = ctxsetters.WithMethodName(ctx, "ListSnapshots")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(HeadRequest)
+	reqContent := new(ListSnapshotsRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -1095,10 +4663,10 @@ func (s *jotFSServer) serveHeadJSON(ctx context.Context, resp http.ResponseWrite
 	}
 
 	// Call service method
-	var respContent *HeadResponse
+	var respContent *ListSnapshotsResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Head(ctx, reqContent)
+		respContent, err = s.JotFS.ListSnapshots(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1106,7 +4674,7 @@ func (s *jotFSServer) serveHeadJSON(ctx context.Context, resp http.ResponseWrite
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *HeadResponse and nil error while calling Head. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListSnapshotsResponse and nil error while calling ListSnapshots. nil responses are not supported"))
 		return
 	}
 
@@ -1133,9 +4701,9 @@ func (s *jotFSServer) serveHeadJSON(ctx context.Context, resp http.ResponseWrite
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveHeadProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveListSnapshotsProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Head")
+	ctx = ctxsetters.WithMethodName(ctx, "ListSnapshots")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1147,17 +4715,17 @@ func (s *jotFSServer) serveHeadProtobuf(ctx context.Context, resp http.ResponseW
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(HeadRequest)
+	reqContent := new(ListSnapshotsRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
 	// Call service method
-	var respContent *HeadResponse
+	var respContent *ListSnapshotsResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Head(ctx, reqContent)
+		respContent, err = s.JotFS.ListSnapshots(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1165,7 +4733,7 @@ func (s *jotFSServer) serveHeadProtobuf(ctx context.Context, resp http.ResponseW
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *HeadResponse and nil error while calling Head. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ListSnapshotsResponse and nil error while calling ListSnapshots. nil responses are not supported"))
 		return
 	}
 
@@ -1189,7 +4757,7 @@ func (s *jotFSServer) serveHeadProtobuf(ctx context.Context, resp http.ResponseW
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveDownload(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDiffSnapshot(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -1197,9 +4765,9 @@ func (s *jotFSServer) serveDownload(ctx context.Context, resp http.ResponseWrite
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveDownloadJSON(ctx, resp, req)
+		s.serveDiffSnapshotJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveDownloadProtobuf(ctx, resp, req)
+		s.serveDiffSnapshotProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -1207,16 +4775,16 @@ func (s *jotFSServer) serveDownload(ctx context.Context, resp http.ResponseWrite
 	}
 }
 
-func (s *jotFSServer) serveDownloadJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDiffSnapshotJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Download")
+	ctx = ctxsetters.WithMethodName(ctx, "DiffSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(FileID)
+	reqContent := new(DiffSnapshotRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -1224,10 +4792,10 @@ func (s *jotFSServer) serveDownloadJSON(ctx context.Context, resp http.ResponseW
 	}
 
 	// Call service method
-	var respContent *DownloadResponse
+	var respContent *DiffSnapshotResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Download(ctx, reqContent)
+		respContent, err = s.JotFS.DiffSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1235,7 +4803,7 @@ func (s *jotFSServer) serveDownloadJSON(ctx context.Context, resp http.ResponseW
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadResponse and nil error while calling Download. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DiffSnapshotResponse and nil error while calling DiffSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1262,9 +4830,9 @@ func (s *jotFSServer) serveDownloadJSON(ctx context.Context, resp http.ResponseW
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveDownloadProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDiffSnapshotProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Download")
+	ctx = ctxsetters.WithMethodName(ctx, "DiffSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1276,17 +4844,17 @@ func (s *jotFSServer) serveDownloadProtobuf(ctx context.Context, resp http.Respo
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(FileID)
+	reqContent := new(DiffSnapshotRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
 	// Call service method
-	var respContent *DownloadResponse
+	var respContent *DiffSnapshotResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Download(ctx, reqContent)
+		respContent, err = s.JotFS.DiffSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1294,7 +4862,7 @@ func (s *jotFSServer) serveDownloadProtobuf(ctx context.Context, resp http.Respo
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *DownloadResponse and nil error while calling Download. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *DiffSnapshotResponse and nil error while calling DiffSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1318,7 +4886,7 @@ func (s *jotFSServer) serveDownloadProtobuf(ctx context.Context, resp http.Respo
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveCopy(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveRollbackSnapshot(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -1326,9 +4894,9 @@ func (s *jotFSServer) serveCopy(ctx context.Context, resp http.ResponseWriter, r
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveCopyJSON(ctx, resp, req)
+		s.serveRollbackSnapshotJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveCopyProtobuf(ctx, resp, req)
+		s.serveRollbackSnapshotProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -1336,16 +4904,16 @@ func (s *jotFSServer) serveCopy(ctx context.Context, resp http.ResponseWriter, r
 	}
 }
 
-func (s *jotFSServer) serveCopyJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveRollbackSnapshotJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Copy")
+	ctx = ctxsetters.WithMethodName(ctx, "RollbackSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(CopyRequest)
+	reqContent := new(RollbackSnapshotRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -1353,10 +4921,10 @@ func (s *jotFSServer) serveCopyJSON(ctx context.Context, resp http.ResponseWrite
 	}
 
 	// Call service method
-	var respContent *FileID
+	var respContent *RollbackSnapshotResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Copy(ctx, reqContent)
+		respContent, err = s.JotFS.RollbackSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1364,7 +4932,7 @@ func (s *jotFSServer) serveCopyJSON(ctx context.Context, resp http.ResponseWrite
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Copy. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RollbackSnapshotResponse and nil error while calling RollbackSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1391,9 +4959,9 @@ func (s *jotFSServer) serveCopyJSON(ctx context.Context, resp http.ResponseWrite
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveCopyProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveRollbackSnapshotProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Copy")
+	ctx = ctxsetters.WithMethodName(ctx, "RollbackSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1405,17 +4973,17 @@ func (s *jotFSServer) serveCopyProtobuf(ctx context.Context, resp http.ResponseW
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(CopyRequest)
+	reqContent := new(RollbackSnapshotRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
 	}
 
 	// Call service method
-	var respContent *FileID
+	var respContent *RollbackSnapshotResponse
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Copy(ctx, reqContent)
+		respContent, err = s.JotFS.RollbackSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1423,7 +4991,7 @@ func (s *jotFSServer) serveCopyProtobuf(ctx context.Context, resp http.ResponseW
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *FileID and nil error while calling Copy. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *RollbackSnapshotResponse and nil error while calling RollbackSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1447,7 +5015,7 @@ func (s *jotFSServer) serveCopyProtobuf(ctx context.Context, resp http.ResponseW
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveDelete(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeleteSnapshot(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	header := req.Header.Get("Content-Type")
 	i := strings.Index(header, ";")
 	if i == -1 {
@@ -1455,9 +5023,9 @@ func (s *jotFSServer) serveDelete(ctx context.Context, resp http.ResponseWriter,
 	}
 	switch strings.TrimSpace(strings.ToLower(header[:i])) {
 	case "application/json":
-		s.serveDeleteJSON(ctx, resp, req)
+		s.serveDeleteSnapshotJSON(ctx, resp, req)
 	case "application/protobuf":
-		s.serveDeleteProtobuf(ctx, resp, req)
+		s.serveDeleteSnapshotProtobuf(ctx, resp, req)
 	default:
 		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
 		twerr := badRouteError(msg, req.Method, req.URL.Path)
@@ -1465,16 +5033,16 @@ func (s *jotFSServer) serveDelete(ctx context.Context, resp http.ResponseWriter,
 	}
 }
 
-func (s *jotFSServer) serveDeleteJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeleteSnapshotJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Delete")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
 		return
 	}
 
-	reqContent := new(FileID)
+	reqContent := new(DeleteSnapshotRequest)
 	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
 	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
@@ -1485,7 +5053,7 @@ func (s *jotFSServer) serveDeleteJSON(ctx context.Context, resp http.ResponseWri
 	var respContent *Empty
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Delete(ctx, reqContent)
+		respContent, err = s.JotFS.DeleteSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1493,7 +5061,7 @@ func (s *jotFSServer) serveDeleteJSON(ctx context.Context, resp http.ResponseWri
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling Delete. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling DeleteSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -1520,9 +5088,9 @@ func (s *jotFSServer) serveDeleteJSON(ctx context.Context, resp http.ResponseWri
 	callResponseSent(ctx, s.hooks)
 }
 
-func (s *jotFSServer) serveDeleteProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+func (s *jotFSServer) serveDeleteSnapshotProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
 	var err error
-	ctx = ctxsetters.WithMethodName(ctx, "Delete")
+	ctx = ctxsetters.WithMethodName(ctx, "DeleteSnapshot")
 	ctx, err = callRequestRouted(ctx, s.hooks)
 	if err != nil {
 		s.writeError(ctx, resp, err)
@@ -1534,7 +5102,7 @@ func (s *jotFSServer) serveDeleteProtobuf(ctx context.Context, resp http.Respons
 		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
 		return
 	}
-	reqContent := new(FileID)
+	reqContent := new(DeleteSnapshotRequest)
 	if err = proto.Unmarshal(buf, reqContent); err != nil {
 		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
 		return
@@ -1544,7 +5112,7 @@ func (s *jotFSServer) serveDeleteProtobuf(ctx context.Context, resp http.Respons
 	var respContent *Empty
 	func() {
 		defer ensurePanicResponses(ctx, resp, s.hooks)
-		respContent, err = s.JotFS.Delete(ctx, reqContent)
+		respContent, err = s.JotFS.DeleteSnapshot(ctx, reqContent)
 	}()
 
 	if err != nil {
@@ -1552,7 +5120,7 @@ func (s *jotFSServer) serveDeleteProtobuf(ctx context.Context, resp http.Respons
 		return
 	}
 	if respContent == nil {
-		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling Delete. nil responses are not supported"))
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *Empty and nil error while calling DeleteSnapshot. nil responses are not supported"))
 		return
 	}
 
@@ -2092,6 +5660,135 @@ func (s *jotFSServer) serveServerStatsProtobuf(ctx context.Context, resp http.Re
 	callResponseSent(ctx, s.hooks)
 }
 
+func (s *jotFSServer) serveChunkReport(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	header := req.Header.Get("Content-Type")
+	i := strings.Index(header, ";")
+	if i == -1 {
+		i = len(header)
+	}
+	switch strings.TrimSpace(strings.ToLower(header[:i])) {
+	case "application/json":
+		s.serveChunkReportJSON(ctx, resp, req)
+	case "application/protobuf":
+		s.serveChunkReportProtobuf(ctx, resp, req)
+	default:
+		msg := fmt.Sprintf("unexpected Content-Type: %q", req.Header.Get("Content-Type"))
+		twerr := badRouteError(msg, req.Method, req.URL.Path)
+		s.writeError(ctx, resp, twerr)
+	}
+}
+
+func (s *jotFSServer) serveChunkReportJSON(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ChunkReport")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	reqContent := new(ChunkReportRequest)
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err = unmarshaler.Unmarshal(req.Body, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the json request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ChunkReportResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.ChunkReport(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ChunkReportResponse and nil error while calling ChunkReport. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	var buf bytes.Buffer
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	if err = marshaler.Marshal(&buf, respContent); err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal json response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	respBytes := buf.Bytes()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
+func (s *jotFSServer) serveChunkReportProtobuf(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	var err error
+	ctx = ctxsetters.WithMethodName(ctx, "ChunkReport")
+	ctx, err = callRequestRouted(ctx, s.hooks)
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+
+	buf, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to read request body"))
+		return
+	}
+	reqContent := new(ChunkReportRequest)
+	if err = proto.Unmarshal(buf, reqContent); err != nil {
+		s.writeError(ctx, resp, malformedRequestError("the protobuf request could not be decoded"))
+		return
+	}
+
+	// Call service method
+	var respContent *ChunkReportResponse
+	func() {
+		defer ensurePanicResponses(ctx, resp, s.hooks)
+		respContent, err = s.JotFS.ChunkReport(ctx, reqContent)
+	}()
+
+	if err != nil {
+		s.writeError(ctx, resp, err)
+		return
+	}
+	if respContent == nil {
+		s.writeError(ctx, resp, twirp.InternalError("received a nil *ChunkReportResponse and nil error while calling ChunkReport. nil responses are not supported"))
+		return
+	}
+
+	ctx = callResponsePrepared(ctx, s.hooks)
+
+	respBytes, err := proto.Marshal(respContent)
+	if err != nil {
+		s.writeError(ctx, resp, wrapInternal(err, "failed to marshal proto response"))
+		return
+	}
+
+	ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	resp.Header().Set("Content-Type", "application/protobuf")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	resp.WriteHeader(http.StatusOK)
+	if n, err := resp.Write(respBytes); err != nil {
+		msg := fmt.Sprintf("failed to write response, %d of %d bytes written: %s", n, len(respBytes), err.Error())
+		twerr := twirp.NewError(twirp.Unknown, msg)
+		callError(ctx, s.hooks, twerr)
+	}
+	callResponseSent(ctx, s.hooks)
+}
+
 func (s *jotFSServer) ServiceDescriptor() ([]byte, int) {
 	return twirpFileDescriptor0, 0
 }
@@ -2617,67 +6314,198 @@ func callClientError(ctx context.Context, h *twirp.ClientHooks, err twirp.Error)
 }
 
 var twirpFileDescriptor0 = []byte{
-	// 980 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0xdd, 0x8e, 0xdb, 0x44,
-	0x14, 0x96, 0x13, 0xc7, 0x9b, 0x1c, 0x3b, 0x3f, 0x9d, 0x6e, 0x51, 0x70, 0xf9, 0x09, 0xd6, 0x6a,
-	0x89, 0x5a, 0xc8, 0xd2, 0x82, 0x50, 0xef, 0xd0, 0xb2, 0xd9, 0x85, 0x45, 0x95, 0x58, 0x39, 0xa8,
-	0x17, 0x08, 0xc9, 0x9a, 0xb5, 0x27, 0xc1, 0x5a, 0x7b, 0x1c, 0x3c, 0xe3, 0x25, 0xad, 0xc4, 0x35,
-	0xef, 0xc1, 0x0d, 0x4f, 0x00, 0xcf, 0x87, 0xe6, 0xc7, 0x8e, 0x9d, 0xa4, 0x17, 0x08, 0x71, 0x95,
-	0x99, 0xef, 0x7c, 0x3e, 0xe7, 0xcc, 0x37, 0xe7, 0x9c, 0x09, 0xbc, 0x1b, 0x53, 0x4e, 0x72, 0x8a,
-	0x93, 0xb3, 0x75, 0x9e, 0xf1, 0x8c, 0x9d, 0xe1, 0x75, 0x3c, 0x93, 0x4b, 0x64, 0x31, 0x92, 0xdf,
-	0x93, 0xdc, 0x9b, 0x02, 0xba, 0xf8, 0xb9, 0xa0, 0x77, 0xec, 0x72, 0x13, 0x33, 0xee, 0x93, 0x5f,
-	0x0a, 0xc2, 0x38, 0x42, 0x60, 0xb2, 0x22, 0x65, 0x63, 0x63, 0xd2, 0x9e, 0x3a, 0xbe, 0x5c, 0x7b,
-	0x9f, 0xc2, 0xc3, 0x06, 0x93, 0xad, 0x33, 0xca, 0x08, 0x7a, 0x07, 0x2c, 0x22, 0x00, 0x45, 0xee,
-	0xfa, 0x7a, 0xe7, 0xcd, 0xc0, 0xbc, 0x8a, 0x13, 0x22, 0x5c, 0x51, 0x9c, 0x92, 0xb1, 0x31, 0x31,
-	0xa6, 0x3d, 0x5f, 0xae, 0x2b, 0xf7, 0xad, 0x9a, 0xfb, 0x2f, 0xc1, 0xbe, 0xc8, 0xd6, 0xaf, 0xcb,
-	0x0c, 0x1e, 0x81, 0xc5, 0xf2, 0x30, 0x88, 0x23, 0xf9, 0xa1, 0xe3, 0x77, 0x58, 0x1e, 0x5e, 0x47,
-	0x68, 0x04, 0xed, 0x88, 0xf1, 0x71, 0x4b, 0x3a, 0x13, 0x4b, 0xcf, 0x05, 0x4b, 0xc4, 0xb9, 0x9e,
-	0x0b, 0x1b, 0x2b, 0x52, 0xcd, 0x17, 0x4b, 0xef, 0x05, 0xf4, 0x7d, 0x22, 0x22, 0xfe, 0x6b, 0xaf,
-	0x13, 0xb0, 0x6e, 0x72, 0xb2, 0x8c, 0x37, 0xe2, 0x7c, 0x6b, 0xb9, 0xd2, 0x27, 0xd0, 0x3b, 0xef,
-	0x2f, 0x03, 0xec, 0x97, 0x35, 0xc9, 0xde, 0xc2, 0x43, 0xc7, 0xd0, 0x49, 0xe2, 0x34, 0x56, 0xde,
-	0x4d, 0x5f, 0x6d, 0xd0, 0x29, 0x0c, 0x29, 0xd9, 0xf0, 0x60, 0x8d, 0x57, 0x24, 0xe0, 0xd9, 0x1d,
-	0xa1, 0xe3, 0xf6, 0xc4, 0x98, 0xb6, 0xfd, 0xbe, 0x80, 0x6f, 0xf0, 0x8a, 0xfc, 0x20, 0x40, 0x34,
-	0x86, 0x23, 0xb2, 0x09, 0x93, 0x22, 0x22, 0x63, 0x53, 0xba, 0x2d, 0xb7, 0xc2, 0x12, 0x53, 0x65,
-	0xe9, 0x28, 0x8b, 0xde, 0xa2, 0xf7, 0xa0, 0x87, 0x59, 0x48, 0x68, 0x14, 0xd3, 0xd5, 0xd8, 0x9a,
-	0x18, 0xd3, 0xae, 0xbf, 0x05, 0xbc, 0x9f, 0xc0, 0x79, 0x59, 0xbf, 0xbf, 0x13, 0x30, 0x63, 0xba,
-	0xcc, 0xe4, 0xed, 0xd9, 0xcf, 0x47, 0x33, 0x55, 0x17, 0x33, 0xa9, 0x29, 0x5d, 0x66, 0xbe, 0xb4,
-	0x1e, 0xca, 0xb7, 0x75, 0x20, 0x5f, 0xef, 0x37, 0xb0, 0xbf, 0x25, 0x38, 0xaa, 0xd5, 0xd1, 0xde,
-	0xe5, 0xff, 0x37, 0x41, 0x1a, 0x87, 0x33, 0x0f, 0x1c, 0x4e, 0x85, 0xff, 0x5f, 0x0e, 0x77, 0x06,
-	0x1d, 0xf1, 0x25, 0x43, 0xa7, 0xd0, 0x11, 0x1f, 0xb2, 0xb7, 0xfa, 0x55, 0x66, 0x2f, 0x84, 0x6e,
-	0x09, 0x1d, 0x94, 0xe2, 0x7d, 0x80, 0x30, 0x27, 0x98, 0x93, 0x28, 0xc0, 0x5c, 0xc7, 0xec, 0x69,
-	0xe4, 0x5c, 0x75, 0x61, 0xfc, 0x86, 0x48, 0x21, 0x4c, 0x5f, 0xae, 0xcb, 0x22, 0x37, 0xb7, 0x45,
-	0x7e, 0x04, 0x9d, 0xcb, 0x74, 0xcd, 0x5f, 0x7b, 0x1f, 0xa8, 0x68, 0x65, 0x87, 0xed, 0x46, 0xf3,
-	0x18, 0x38, 0x0b, 0x12, 0xf2, 0x38, 0xa3, 0xb2, 0x8f, 0x91, 0x0b, 0x5d, 0x26, 0xee, 0x89, 0x86,
-	0x8a, 0x67, 0xfa, 0xd5, 0xbe, 0x0a, 0xdd, 0xda, 0x0f, 0xdd, 0xae, 0x42, 0xa3, 0x8f, 0xc0, 0xb9,
-	0x4d, 0xb2, 0xf0, 0x2e, 0xc8, 0x96, 0x4b, 0x46, 0xb8, 0xcc, 0xca, 0xf4, 0x6d, 0x89, 0x7d, 0x2f,
-	0x21, 0xef, 0x77, 0x03, 0x8e, 0x74, 0x54, 0xf4, 0x09, 0x58, 0xa1, 0x9c, 0x20, 0x5a, 0xb7, 0xe3,
-	0x52, 0xb7, 0x7a, 0x5a, 0xbe, 0xe6, 0x88, 0x70, 0x45, 0x9e, 0x94, 0x4d, 0x59, 0xe4, 0x09, 0xfa,
-	0x10, 0xec, 0x1c, 0xd3, 0x15, 0x09, 0x18, 0xc7, 0x39, 0xd7, 0xb2, 0x80, 0x84, 0x16, 0x02, 0x41,
-	0x8f, 0xa1, 0xa7, 0x08, 0x84, 0x46, 0x3a, 0x99, 0xae, 0x04, 0x2e, 0x69, 0xe4, 0x7d, 0x05, 0xa3,
-	0x79, 0xf6, 0x2b, 0x4d, 0xb2, 0x5a, 0x7d, 0x3c, 0x15, 0x12, 0xc8, 0xd8, 0x65, 0x4e, 0xc3, 0x9d,
-	0x9c, 0xfc, 0x8a, 0xe0, 0xfd, 0x69, 0x40, 0x5f, 0xa6, 0x48, 0xf2, 0x1b, 0x9c, 0xe3, 0x94, 0xa1,
-	0x13, 0x18, 0xa4, 0x31, 0x0d, 0x64, 0xc2, 0x81, 0xd4, 0x4b, 0xe9, 0xe8, 0xa4, 0xb1, 0x3a, 0xcc,
-	0x42, 0xe8, 0x76, 0x02, 0x03, 0x7c, 0xbf, 0xaa, 0xb3, 0x94, 0xaa, 0x0e, 0xbe, 0x5f, 0x35, 0x58,
-	0x29, 0xde, 0xd4, 0x59, 0x6d, 0xed, 0x0b, 0x6f, 0xea, 0xac, 0x3e, 0xcd, 0xf2, 0x14, 0x27, 0xf1,
-	0x1b, 0x2c, 0xb2, 0xd2, 0xa7, 0x6c, 0x82, 0x9e, 0x0b, 0xdd, 0x57, 0x38, 0x2c, 0x8a, 0xf4, 0x7a,
-	0x8e, 0x06, 0xd0, 0xd2, 0xe3, 0xae, 0xe7, 0xb7, 0xe2, 0xc8, 0xbb, 0x05, 0x4b, 0xd9, 0xc4, 0xc4,
-	0x62, 0x1c, 0xf3, 0x82, 0x95, 0x13, 0x4b, 0xed, 0x44, 0x55, 0x4a, 0x81, 0x1b, 0x55, 0xa9, 0x91,
-	0x73, 0x2e, 0x2e, 0x3d, 0xcc, 0xd2, 0x75, 0x42, 0x34, 0x41, 0xb5, 0xa9, 0x5d, 0x61, 0xe7, 0xdc,
-	0xfb, 0xc3, 0x80, 0xce, 0x82, 0x63, 0xce, 0xc4, 0x8d, 0xd0, 0x22, 0x0d, 0x96, 0xa2, 0x6d, 0xca,
-	0x22, 0xa3, 0x45, 0xaa, 0xda, 0xe8, 0x09, 0x3c, 0x28, 0x8d, 0xc1, 0x3d, 0xc9, 0x99, 0xbc, 0x06,
-	0xa5, 0xcd, 0x50, 0x93, 0x5e, 0x69, 0x18, 0x4d, 0x61, 0xc4, 0x33, 0x8e, 0x13, 0xe5, 0xaa, 0x2e,
-	0xd0, 0x40, 0xe2, 0xd2, 0xa3, 0x94, 0xe8, 0x14, 0x86, 0x8a, 0x19, 0x61, 0x8e, 0x15, 0x51, 0x8b,
-	0x24, 0xe1, 0x39, 0xe6, 0x58, 0xf0, 0x9e, 0xff, 0x6d, 0x42, 0xe7, 0xbb, 0x8c, 0x5f, 0x2d, 0xd0,
-	0x15, 0xd8, 0xb5, 0x97, 0x0d, 0xb9, 0x65, 0x09, 0xec, 0x3f, 0x8c, 0xee, 0xe3, 0x83, 0x36, 0x5d,
-	0x4d, 0x4f, 0x00, 0x2e, 0x64, 0xf3, 0xca, 0x87, 0xcf, 0xa9, 0x4f, 0x05, 0x77, 0xd0, 0x98, 0x11,
-	0x73, 0xf4, 0x0c, 0x4c, 0x31, 0x86, 0xd1, 0xc3, 0x12, 0xaf, 0xbd, 0x25, 0xee, 0x71, 0x13, 0xd4,
-	0xee, 0x9f, 0x81, 0x29, 0x86, 0xdb, 0xf6, 0x93, 0xda, 0xa4, 0xdd, 0x7e, 0xd2, 0x98, 0x7f, 0x5f,
-	0x40, 0xb7, 0xac, 0x79, 0xb4, 0x93, 0x81, 0x3b, 0x2e, 0xf7, 0x07, 0xba, 0xc2, 0x14, 0x4f, 0xf1,
-	0x36, 0x50, 0xed, 0x61, 0xde, 0x3b, 0xc8, 0xc7, 0x60, 0xcd, 0x89, 0xb8, 0xf8, 0xbd, 0x00, 0xfd,
-	0x72, 0x2f, 0xc7, 0x13, 0x7a, 0x01, 0xa3, 0x6f, 0x08, 0x6f, 0x36, 0x50, 0x93, 0xe2, 0x3e, 0x6a,
-	0xa8, 0x5b, 0xb1, 0x66, 0x60, 0xcb, 0xfe, 0xd6, 0x75, 0xbb, 0xf3, 0x51, 0x35, 0x7d, 0xab, 0x92,
-	0xff, 0x0c, 0x1c, 0xb5, 0x5e, 0xa8, 0x82, 0xde, 0x63, 0x6c, 0x0f, 0xa1, 0x5d, 0x3e, 0x05, 0x7b,
-	0x21, 0x01, 0x55, 0xb5, 0x3b, 0x11, 0xaa, 0xad, 0xb4, 0x7e, 0xfd, 0xe0, 0xc7, 0xe1, 0xce, 0xff,
-	0xaa, 0x5b, 0x4b, 0xfe, 0x7e, 0xfe, 0x4f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xd2, 0xbf, 0x4e, 0x1c,
-	0x71, 0x09, 0x00, 0x00,
+	// 3088 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xc4, 0x3a, 0x5b, 0x73, 0xdb, 0xc6,
+	0xd5, 0x1f, 0xaf, 0x22, 0x0f, 0x2f, 0xa2, 0x57, 0x17, 0xd3, 0x90, 0x1d, 0x2b, 0x88, 0x3f, 0x47,
+	0xb5, 0x1b, 0x39, 0x71, 0x5a, 0x27, 0x71, 0xd2, 0x66, 0x24, 0x92, 0x8a, 0x95, 0xca, 0x97, 0x42,
+	0x4a, 0xda, 0xc9, 0xa4, 0xc3, 0x81, 0x81, 0x25, 0x85, 0x11, 0x08, 0x30, 0xc0, 0x42, 0x91, 0x32,
+	0xd3, 0xd7, 0xbe, 0xa7, 0x6f, 0x6d, 0x9f, 0xda, 0x99, 0x3e, 0xf4, 0x21, 0x7f, 0xa1, 0xbf, 0xa2,
+	0xff, 0xa2, 0x3f, 0xa1, 0xd3, 0x4e, 0x67, 0x6f, 0xc0, 0xe2, 0x42, 0xc9, 0x6e, 0xea, 0xe9, 0x93,
+	0xb8, 0xe7, 0xb2, 0x7b, 0xae, 0x8b, 0x73, 0xf6, 0x08, 0xae, 0x39, 0x1e, 0xc1, 0x81, 0x67, 0xba,
+	0xf7, 0xe6, 0x81, 0x4f, 0xfc, 0xf0, 0x9e, 0x39, 0x77, 0xb6, 0xd9, 0x4f, 0x54, 0x0f, 0x71, 0x70,
+	0x8a, 0x03, 0x7d, 0x0b, 0xd0, 0xe0, 0x38, 0xf2, 0x4e, 0xc2, 0xd1, 0x99, 0x13, 0x12, 0x03, 0x7f,
+	0x15, 0xe1, 0x90, 0x20, 0x04, 0xd5, 0x30, 0x9a, 0x85, 0xfd, 0xd2, 0x66, 0x65, 0xab, 0x6d, 0xb0,
+	0xdf, 0xfa, 0x5b, 0xb0, 0x92, 0xa2, 0x0c, 0xe7, 0xbe, 0x17, 0x62, 0xb4, 0x0e, 0x75, 0x4c, 0x01,
+	0x9c, 0xb8, 0x61, 0x88, 0x95, 0xfe, 0xb7, 0x0a, 0x54, 0xf7, 0x1c, 0x17, 0xd3, 0xbd, 0x3c, 0x73,
+	0x86, 0xfb, 0xa5, 0xcd, 0xd2, 0x56, 0xd3, 0x60, 0xbf, 0xe3, 0xfd, 0xcb, 0xc9, 0xfe, 0xe8, 0x4d,
+	0x58, 0x76, 0x6c, 0x3c, 0x9b, 0xfb, 0x04, 0x7b, 0xd6, 0xf9, 0xf8, 0x04, 0x9f, 0xf7, 0x2b, 0x8c,
+	0xa5, 0xab, 0x80, 0x7f, 0x86, 0xcf, 0xd1, 0x03, 0x68, 0xcc, 0x30, 0x31, 0x6d, 0x93, 0x98, 0xfd,
+	0xea, 0x66, 0x65, 0xab, 0x75, 0x5f, 0xdb, 0xe6, 0xda, 0x6c, 0xd3, 0x03, 0xb7, 0x1f, 0x0b, 0xe4,
+	0xc8, 0x23, 0xc1, 0xb9, 0x11, 0xd3, 0xa2, 0x0f, 0xa0, 0x69, 0x1d, 0x63, 0xeb, 0x84, 0x9d, 0x5c,
+	0x63, 0x8c, 0x1b, 0x29, 0xc6, 0x81, 0xc4, 0x72, 0xce, 0x84, 0x1a, 0x5d, 0x83, 0x86, 0x33, 0x19,
+	0xcf, 0x4c, 0x62, 0x1d, 0xf7, 0xeb, 0x9b, 0xa5, 0xad, 0xb6, 0xb1, 0xe4, 0x4c, 0x1e, 0xd3, 0x25,
+	0xd2, 0xa1, 0xe3, 0x4c, 0xc6, 0x9e, 0x4f, 0xc6, 0xc2, 0x0c, 0x4b, 0x9b, 0xa5, 0xad, 0x86, 0xd1,
+	0x72, 0x26, 0x4f, 0x7c, 0xc2, 0x4c, 0x15, 0x52, 0x75, 0x9f, 0x9b, 0x21, 0xee, 0x37, 0x18, 0x2b,
+	0xfb, 0x8d, 0x6e, 0xc3, 0x32, 0xfd, 0xab, 0x72, 0x36, 0x19, 0x67, 0x87, 0x82, 0x53, 0xbc, 0x4c,
+	0x53, 0xe0, 0xbc, 0xf4, 0xb7, 0xf6, 0x21, 0x74, 0x52, 0x4a, 0xa2, 0x1e, 0x54, 0xa8, 0xbd, 0xb8,
+	0x89, 0xe9, 0x4f, 0xb4, 0x0a, 0xb5, 0x53, 0xd3, 0x8d, 0x70, 0xbf, 0xcc, 0x60, 0x7c, 0xf1, 0xb0,
+	0xfc, 0x7e, 0x49, 0xfb, 0x08, 0xba, 0x69, 0x45, 0x2f, 0xe3, 0x6e, 0x2b, 0xdc, 0xfa, 0x3f, 0x4b,
+	0xd0, 0xd9, 0x99, 0xcf, 0xb1, 0x67, 0x2b, 0xb1, 0xf2, 0x42, 0xfe, 0x55, 0x6d, 0x58, 0x49, 0xdb,
+	0xb0, 0xc0, 0xf5, 0xd5, 0x42, 0xd7, 0xef, 0xe6, 0x5d, 0x78, 0x4b, 0xba, 0x30, 0x25, 0xd5, 0x62,
+	0x5f, 0x7e, 0x4f, 0xfd, 0xff, 0x54, 0x81, 0xce, 0xc0, 0xf7, 0x2c, 0x33, 0xce, 0x95, 0x1e, 0x54,
+	0xec, 0x90, 0x48, 0x6e, 0x3b, 0x24, 0xe8, 0x2a, 0x2c, 0x85, 0x81, 0x35, 0x76, 0x6c, 0x69, 0x80,
+	0x7a, 0x18, 0x58, 0xfb, 0x76, 0x88, 0x3e, 0x56, 0x22, 0xb7, 0xc2, 0xa4, 0x7f, 0x43, 0x4a, 0x9f,
+	0xda, 0x73, 0x61, 0x08, 0xab, 0x36, 0xac, 0x5e, 0x12, 0x87, 0xb5, 0x7c, 0x1c, 0x16, 0xd8, 0xb9,
+	0x7e, 0xb9, 0x9d, 0x97, 0xd2, 0x76, 0x4e, 0x4b, 0xba, 0xd8, 0xce, 0xff, 0xc3, 0x20, 0x7d, 0x00,
+	0xad, 0x81, 0x3f, 0x3f, 0x97, 0x1e, 0x5a, 0x83, 0x3a, 0xf7, 0x07, 0xe3, 0x6e, 0x1b, 0x35, 0xe6,
+	0x0e, 0xe9, 0xb8, 0x72, 0xec, 0x38, 0xfd, 0x3d, 0xb8, 0x72, 0x64, 0x4e, 0x3f, 0xc7, 0x41, 0xe8,
+	0xf8, 0x9e, 0xe2, 0xdf, 0x30, 0x9a, 0x09, 0x56, 0xfa, 0x93, 0x42, 0x88, 0x39, 0x95, 0x8c, 0xc4,
+	0x9c, 0xea, 0x1f, 0xc2, 0xca, 0x67, 0x1e, 0xc9, 0xb1, 0x16, 0xa5, 0x46, 0x9e, 0xf9, 0xff, 0x61,
+	0xf9, 0xc0, 0x09, 0xc9, 0x91, 0x39, 0x0d, 0x2f, 0x60, 0xd4, 0x9f, 0x02, 0x88, 0xed, 0x8f, 0xcc,
+	0xa9, 0xdc, 0xa6, 0x14, 0x6f, 0x23, 0xe5, 0x2c, 0x27, 0x72, 0xde, 0x00, 0xb0, 0x02, 0x6c, 0x12,
+	0x6c, 0x8f, 0x4d, 0xc2, 0x72, 0xae, 0x62, 0x34, 0x05, 0x64, 0x87, 0xe8, 0x0f, 0xa1, 0x97, 0x9c,
+	0x2b, 0x6e, 0xf3, 0xdb, 0x50, 0x25, 0xe6, 0x94, 0xdf, 0xe5, 0xad, 0xfb, 0x48, 0xfa, 0x3c, 0x39,
+	0xd8, 0x60, 0x78, 0x7d, 0x04, 0xeb, 0x06, 0x3e, 0xc5, 0x01, 0x39, 0xf2, 0x2f, 0x35, 0x97, 0x1a,
+	0xb4, 0xe5, 0x54, 0xd0, 0xea, 0x13, 0xa8, 0xd3, 0x9b, 0x77, 0x7f, 0x58, 0xc0, 0x26, 0x6d, 0x50,
+	0x56, 0x8c, 0xf7, 0x00, 0x1a, 0x96, 0xef, 0x4d, 0x5c, 0xc7, 0xe2, 0xfa, 0x74, 0x93, 0xab, 0x7f,
+	0x20, 0xe0, 0x06, 0x0e, 0x7d, 0x37, 0x22, 0x54, 0xa2, 0x98, 0x56, 0xff, 0xae, 0x04, 0xe8, 0x10,
+	0x13, 0x19, 0x8f, 0x8b, 0x65, 0xfd, 0x31, 0x54, 0x42, 0x4c, 0xfa, 0xe5, 0x74, 0x72, 0xe6, 0x59,
+	0x29, 0x88, 0x47, 0x3c, 0xa5, 0xa7, 0x1f, 0x41, 0x1b, 0xbb, 0x98, 0x60, 0x96, 0xd6, 0x4d, 0x43,
+	0xac, 0xb4, 0x07, 0xd0, 0x90, 0x84, 0x2f, 0x13, 0xfe, 0xfa, 0xef, 0x4a, 0xb0, 0x92, 0x3a, 0x54,
+	0xb8, 0x67, 0xa4, 0x5c, 0x20, 0xdc, 0x45, 0x3f, 0x28, 0x94, 0x91, 0x93, 0x2f, 0xba, 0x46, 0xbe,
+	0x57, 0x6a, 0xea, 0x7f, 0x2d, 0x01, 0x1a, 0x32, 0xf5, 0x76, 0xa9, 0x0f, 0x2f, 0x28, 0x19, 0xe8,
+	0x26, 0xd4, 0x6d, 0xfc, 0x1a, 0x6c, 0x1a, 0x7c, 0x81, 0x76, 0x53, 0x1f, 0x02, 0xaa, 0xc4, 0x9b,
+	0x52, 0x89, 0xfc, 0xbe, 0xdb, 0xfb, 0x3c, 0x54, 0xb8, 0x0a, 0x32, 0x70, 0xb4, 0x87, 0xd0, 0x56,
+	0x11, 0x2f, 0x75, 0x3b, 0xdc, 0x83, 0x95, 0xd4, 0x39, 0xc2, 0xb6, 0x7d, 0x58, 0xe2, 0x5e, 0xb3,
+	0x85, 0x0e, 0x72, 0xa9, 0xef, 0x49, 0x86, 0x67, 0x01, 0x9e, 0x38, 0x67, 0x52, 0xe3, 0x75, 0xa8,
+	0xcf, 0x19, 0x40, 0x1c, 0x2b, 0x56, 0xf4, 0xfa, 0xb7, 0x83, 0xf3, 0x71, 0x10, 0x79, 0xec, 0xec,
+	0x86, 0x51, 0xb7, 0x83, 0x73, 0x23, 0xf2, 0xf4, 0xdf, 0x96, 0x60, 0x35, 0xbd, 0x91, 0x38, 0x7a,
+	0x03, 0x9a, 0x5e, 0x34, 0x1b, 0x4f, 0x1c, 0x17, 0x87, 0x6c, 0xb3, 0xaa, 0xd1, 0xf0, 0xa2, 0x19,
+	0x4d, 0x8d, 0x10, 0xdd, 0x81, 0x2b, 0x12, 0x39, 0x3e, 0xe5, 0xb9, 0x16, 0xb2, 0x8d, 0xab, 0xc6,
+	0xb2, 0x20, 0x12, 0x29, 0x18, 0xd2, 0x8c, 0x27, 0x3e, 0x31, 0xdd, 0x71, 0xe8, 0x7c, 0x83, 0x59,
+	0x86, 0x54, 0x8d, 0x26, 0x83, 0x1c, 0x3a, 0xdf, 0xb0, 0xcf, 0xf2, 0xcc, 0x0f, 0x30, 0xfb, 0x74,
+	0x34, 0x0c, 0xf6, 0x5b, 0x1f, 0xc0, 0xda, 0x80, 0x5d, 0x09, 0x87, 0x9e, 0x39, 0x0f, 0x8f, 0x7d,
+	0x72, 0xd1, 0xe5, 0x95, 0xa8, 0x5c, 0x56, 0x55, 0xd6, 0xbf, 0x2d, 0x41, 0x43, 0xf2, 0xbf, 0x0c,
+	0xe3, 0x25, 0x57, 0x54, 0xda, 0x30, 0xd5, 0x8c, 0x61, 0xd2, 0xca, 0xd6, 0x32, 0xca, 0xea, 0xdb,
+	0xb0, 0x4a, 0xaf, 0x37, 0x29, 0x56, 0x78, 0x89, 0xdb, 0xf4, 0x4f, 0x60, 0x2d, 0x43, 0x2f, 0xbc,
+	0xb3, 0x0d, 0xcd, 0x50, 0x02, 0x45, 0xd6, 0xf5, 0xe2, 0xac, 0x93, 0x46, 0x4b, 0x48, 0xf4, 0x0f,
+	0x60, 0x65, 0xe8, 0x4c, 0x26, 0x2f, 0x62, 0xcf, 0x2e, 0x94, 0x89, 0x2f, 0x4c, 0x52, 0x26, 0xbe,
+	0xfe, 0x9b, 0x12, 0x74, 0x25, 0xdf, 0xe0, 0xd8, 0xf4, 0xa6, 0xc5, 0xe5, 0xf3, 0x36, 0x54, 0xc9,
+	0xf9, 0x9c, 0x87, 0xb6, 0x72, 0x05, 0xa6, 0x39, 0x8f, 0xce, 0xe7, 0xd8, 0x60, 0x74, 0x34, 0x22,
+	0x7d, 0xd7, 0x1e, 0xd3, 0xbb, 0x8e, 0x57, 0x5e, 0x75, 0xdf, 0xb5, 0x0f, 0xa3, 0x19, 0x45, 0x78,
+	0xf8, 0x6b, 0x86, 0xe0, 0xe5, 0x44, 0xdd, 0xc3, 0x5f, 0x1f, 0x46, 0x33, 0xfd, 0x11, 0xac, 0xa6,
+	0x75, 0x10, 0xb6, 0x78, 0x1b, 0x96, 0x2c, 0xb6, 0xbb, 0xb4, 0xc4, 0x7a, 0xf1, 0xe1, 0x86, 0x24,
+	0xd3, 0x07, 0x70, 0xd5, 0xf0, 0x5d, 0xf7, 0xb9, 0x69, 0x9d, 0xbc, 0x88, 0x45, 0x56, 0xa1, 0x36,
+	0x0f, 0x22, 0x0f, 0x8b, 0xd4, 0xe1, 0x0b, 0x3d, 0x80, 0x7e, 0x7e, 0x13, 0x21, 0x92, 0x06, 0x8d,
+	0x00, 0x87, 0xc4, 0x0f, 0xb0, 0x2d, 0x73, 0x47, 0xae, 0xd5, 0x9c, 0xe6, 0x19, 0x23, 0x97, 0x68,
+	0x13, 0x5a, 0x91, 0x67, 0x9e, 0x9a, 0x8e, 0x6b, 0x3e, 0x77, 0x65, 0xaa, 0xa8, 0x20, 0xfd, 0x2e,
+	0xac, 0xf1, 0x64, 0x7d, 0x01, 0xb1, 0xf5, 0x9f, 0x43, 0xc7, 0xc0, 0xf4, 0x97, 0xfa, 0x69, 0x09,
+	0x2c, 0xd1, 0xc1, 0xd0, 0x9f, 0xf9, 0x72, 0x43, 0x89, 0x44, 0x9e, 0x90, 0x62, 0xf5, 0x69, 0xb5,
+	0x51, 0xea, 0x95, 0xf5, 0xb7, 0xa0, 0x2b, 0xb7, 0x7c, 0x81, 0x6b, 0x42, 0xdf, 0x84, 0x3a, 0xbf,
+	0x55, 0x16, 0x06, 0xf8, 0xb7, 0x65, 0x68, 0x1d, 0x28, 0x4d, 0xde, 0x02, 0x3a, 0xea, 0x02, 0xd7,
+	0x99, 0x39, 0x44, 0x98, 0x8c, 0x2f, 0x68, 0xbf, 0xe2, 0xe1, 0x33, 0x32, 0x9e, 0x9b, 0x53, 0x3c,
+	0x26, 0xfe, 0x09, 0xf6, 0x44, 0xba, 0x76, 0x28, 0xf8, 0x99, 0x39, 0xc5, 0x47, 0x14, 0x48, 0x4d,
+	0x8e, 0xcf, 0x2c, 0x37, 0xb2, 0xb1, 0xa8, 0xe1, 0xe5, 0x92, 0x62, 0x1c, 0x8f, 0x63, 0x6a, 0x1c,
+	0x23, 0x96, 0xe8, 0x3a, 0x34, 0xcd, 0xd0, 0xc2, 0x9e, 0xed, 0x78, 0x53, 0x56, 0x91, 0x36, 0x8c,
+	0x04, 0x40, 0xe5, 0xb4, 0xa2, 0x20, 0xf4, 0x03, 0xd6, 0x5a, 0x35, 0x0d, 0xb1, 0xa2, 0x5c, 0x36,
+	0x66, 0xc2, 0xe1, 0x80, 0xb5, 0x56, 0x4d, 0x23, 0x01, 0xa0, 0x5b, 0x50, 0x0d, 0xfd, 0x80, 0xb0,
+	0xa6, 0xaa, 0x9b, 0x24, 0x2c, 0x4b, 0x71, 0x3f, 0x20, 0x06, 0xc3, 0xd2, 0x0f, 0x6d, 0xfb, 0x40,
+	0x6d, 0x67, 0x6f, 0x41, 0xd5, 0xf1, 0x26, 0x7e, 0x36, 0xcf, 0x59, 0x95, 0xe2, 0x4d, 0x7c, 0x83,
+	0x61, 0x8b, 0x8c, 0x51, 0x2e, 0x32, 0x86, 0x06, 0x0d, 0x6e, 0x54, 0x1c, 0x8a, 0xca, 0x20, 0x5e,
+	0xa3, 0x9b, 0xd0, 0x62, 0x7b, 0x08, 0xdd, 0xb8, 0xb1, 0x80, 0x82, 0x06, 0x0c, 0xa2, 0xff, 0xbd,
+	0x04, 0x9d, 0x43, 0x6c, 0x06, 0xc9, 0x37, 0xb6, 0x0f, 0x4b, 0x73, 0x93, 0x10, 0x1c, 0x78, 0xc2,
+	0x65, 0x72, 0x49, 0x7d, 0x16, 0xe0, 0x29, 0x3e, 0x93, 0x69, 0xc3, 0x16, 0x89, 0x27, 0x2b, 0xaa,
+	0x27, 0x13, 0x7b, 0x56, 0x53, 0xf6, 0x54, 0xbb, 0x93, 0x5a, 0xb6, 0x00, 0x52, 0xc4, 0x78, 0x35,
+	0x65, 0xc5, 0x2f, 0xa0, 0x2b, 0x4f, 0x79, 0x29, 0x57, 0x64, 0xcc, 0x58, 0xce, 0x99, 0xf1, 0xd7,
+	0xd0, 0x7a, 0x84, 0x4d, 0xfb, 0x92, 0x4b, 0xe7, 0x7b, 0x44, 0x7c, 0x2a, 0x7a, 0xab, 0x99, 0xe8,
+	0xd5, 0xbf, 0x84, 0x36, 0x3f, 0xfe, 0x55, 0x04, 0x98, 0x7e, 0x00, 0xe8, 0x13, 0x4c, 0x62, 0xe6,
+	0x8b, 0xfb, 0x8e, 0x4c, 0x7b, 0x20, 0x5a, 0x88, 0x4a, 0xd2, 0x89, 0xfc, 0xb1, 0x0c, 0x2b, 0xa9,
+	0xed, 0x72, 0x32, 0x97, 0x2e, 0x90, 0xf9, 0x75, 0x68, 0xd3, 0xeb, 0x29, 0x53, 0xa3, 0xb4, 0xbc,
+	0x68, 0xa6, 0xd6, 0x27, 0x94, 0xc4, 0x62, 0xef, 0x48, 0xb2, 0x3e, 0xf1, 0xa2, 0x19, 0x7f, 0x58,
+	0xa2, 0xe9, 0x22, 0xfb, 0x47, 0xf1, 0x3d, 0x8a, 0xd7, 0xe8, 0x51, 0xbe, 0xf5, 0xbf, 0x23, 0x05,
+	0x29, 0x90, 0xf9, 0x95, 0x3d, 0x00, 0xdc, 0x83, 0x1a, 0x2f, 0x3f, 0x6e, 0x43, 0x8d, 0xaa, 0x1d,
+	0x2e, 0xf4, 0x24, 0x47, 0xeb, 0xff, 0x28, 0x41, 0x43, 0xc2, 0x0a, 0x3d, 0x93, 0xae, 0x81, 0xca,
+	0xd9, 0x1a, 0x88, 0x16, 0xd6, 0x49, 0x35, 0xc7, 0x7e, 0x4b, 0x67, 0x56, 0x53, 0xbd, 0x9e, 0x30,
+	0x3c, 0xed, 0x73, 0xf9, 0xfd, 0xda, 0x14, 0x90, 0x7d, 0x1b, 0x3d, 0x54, 0x72, 0xbb, 0xce, 0xe4,
+	0x7d, 0x2d, 0x2b, 0xef, 0xab, 0x49, 0xeb, 0x25, 0xa8, 0x8d, 0x66, 0x73, 0x72, 0xae, 0xbf, 0xc6,
+	0xad, 0x20, 0x9f, 0x87, 0x72, 0x5f, 0xd0, 0x10, 0xda, 0x87, 0xd8, 0xa2, 0x7d, 0x1b, 0x0b, 0x06,
+	0x1a, 0x0b, 0x21, 0x0d, 0x67, 0xcf, 0xc2, 0xf2, 0x5b, 0x27, 0xd7, 0xb1, 0x49, 0xca, 0x79, 0x93,
+	0x54, 0x12, 0x93, 0xbc, 0x0e, 0xed, 0xe7, 0xae, 0x6f, 0x9d, 0x8c, 0xfd, 0xc9, 0x24, 0xc4, 0x44,
+	0xd4, 0x8f, 0x2d, 0x06, 0x7b, 0xca, 0x40, 0xfa, 0x1f, 0x4a, 0xb0, 0x24, 0x4e, 0x45, 0x3f, 0x84,
+	0xba, 0x88, 0x4b, 0xee, 0xd0, 0xd5, 0xe4, 0xf2, 0x4b, 0xc4, 0x32, 0x04, 0x0d, 0x3d, 0x2e, 0x0a,
+	0x5c, 0xf9, 0x35, 0x8f, 0x02, 0x97, 0x5e, 0x44, 0x01, 0x2d, 0x79, 0xc6, 0x21, 0x31, 0x03, 0x79,
+	0xe5, 0x02, 0x03, 0x1d, 0x52, 0x08, 0xfd, 0x7c, 0x73, 0x02, 0xec, 0xd9, 0xb2, 0x98, 0x65, 0x80,
+	0x91, 0x67, 0xc7, 0xcf, 0x7c, 0xb5, 0xe4, 0x99, 0x4f, 0xff, 0x18, 0x7a, 0x43, 0xff, 0x6b, 0xcf,
+	0xf5, 0x95, 0xeb, 0xe3, 0x2e, 0x35, 0x0b, 0x93, 0x47, 0xca, 0xb9, 0x9c, 0x91, 0xd3, 0x88, 0x09,
+	0xf4, 0x5f, 0xc2, 0x6a, 0xbc, 0x01, 0x3d, 0x68, 0x71, 0xdf, 0xbb, 0x0e, 0x75, 0x61, 0x25, 0x6e,
+	0x53, 0xb1, 0xa2, 0x70, 0x17, 0x7b, 0x53, 0x72, 0x2c, 0xf4, 0x11, 0x2b, 0xfd, 0x4b, 0x58, 0xcb,
+	0xec, 0xfc, 0x1f, 0xc8, 0xb7, 0xe8, 0x54, 0xfd, 0xa3, 0x44, 0xee, 0x21, 0x76, 0x2f, 0xea, 0xd7,
+	0x11, 0x54, 0x8f, 0xcd, 0x53, 0x2c, 0x1f, 0x1a, 0xe9, 0x6f, 0xfd, 0x5d, 0x68, 0x19, 0xd8, 0x72,
+	0xe6, 0x98, 0x07, 0x52, 0x21, 0x53, 0x36, 0x7c, 0xf4, 0xaf, 0x60, 0x2d, 0x73, 0x64, 0xac, 0x50,
+	0x3d, 0x60, 0xbb, 0x09, 0x75, 0x56, 0xa4, 0x3a, 0xca, 0x19, 0x86, 0x20, 0x49, 0x69, 0x5f, 0xbe,
+	0xcc, 0x3b, 0x1f, 0x43, 0x8b, 0xd6, 0xd8, 0x52, 0x39, 0xa5, 0x48, 0x2f, 0x2d, 0x2a, 0xd2, 0xcb,
+	0xa9, 0x22, 0xfd, 0x0b, 0x68, 0xee, 0x9e, 0x13, 0xcc, 0x1c, 0xa0, 0xd8, 0xb2, 0xb4, 0xc0, 0x83,
+	0x65, 0xd5, 0x83, 0x97, 0x5c, 0xc5, 0xfa, 0xef, 0x4b, 0xd0, 0xe6, 0xd2, 0x09, 0x3b, 0xbc, 0x09,
+	0x35, 0xd3, 0xb6, 0x45, 0x73, 0xdc, 0xba, 0x7f, 0x45, 0xea, 0x15, 0x4b, 0x60, 0x70, 0x3c, 0xba,
+	0x0b, 0x4b, 0x01, 0x9e, 0xf9, 0xa7, 0xac, 0xe6, 0x5e, 0x40, 0x2a, 0x29, 0xe8, 0xdb, 0x10, 0x53,
+	0x3a, 0xb9, 0xe0, 0xa8, 0x11, 0x58, 0xb3, 0x7a, 0x0d, 0x1a, 0x4c, 0x6d, 0x8a, 0xe2, 0xd9, 0x42,
+	0xcd, 0x40, 0x51, 0xfa, 0x9f, 0xcb, 0xd0, 0x61, 0x72, 0xe2, 0xe0, 0x99, 0x19, 0x98, 0xb3, 0x10,
+	0xdd, 0x82, 0xee, 0xcc, 0xf1, 0xb8, 0x36, 0x9c, 0x85, 0x5b, 0xa1, 0x3d, 0x73, 0x78, 0xe2, 0xb2,
+	0x2d, 0x6f, 0x41, 0xd7, 0x3c, 0x9d, 0xaa, 0x54, 0xdc, 0x26, 0x6d, 0xf3, 0x74, 0x9a, 0xa2, 0x9a,
+	0x99, 0x67, 0x2a, 0x55, 0x45, 0xec, 0x65, 0x9e, 0xa9, 0x54, 0x1d, 0xcf, 0x0f, 0x66, 0xa6, 0xeb,
+	0x7c, 0x63, 0x52, 0x7f, 0x0a, 0x19, 0xd3, 0x40, 0xf4, 0x06, 0x74, 0xe6, 0xa6, 0x75, 0xc2, 0x9a,
+	0x77, 0xa5, 0x4d, 0x6d, 0x4b, 0x20, 0xdb, 0xea, 0x01, 0x5c, 0x8d, 0x89, 0x26, 0x6e, 0x14, 0x1e,
+	0x8f, 0xd9, 0xdc, 0xe6, 0xd4, 0x74, 0x59, 0x31, 0x5c, 0x31, 0xd6, 0x24, 0x7a, 0x8f, 0x62, 0xf7,
+	0x05, 0x92, 0xde, 0x38, 0x8e, 0xe7, 0x3a, 0x9e, 0xd8, 0x7a, 0x89, 0xdf, 0x38, 0x1c, 0xc4, 0xec,
+	0xa4, 0x41, 0xe3, 0x73, 0xd3, 0x8a, 0xa2, 0xd9, 0xfe, 0x90, 0xb6, 0x9a, 0xe2, 0x01, 0xb4, 0x69,
+	0x94, 0x1d, 0x5b, 0x7f, 0x0e, 0x75, 0x8e, 0xa3, 0x11, 0x12, 0x12, 0x93, 0x44, 0xa1, 0xc0, 0x8a,
+	0x15, 0x8d, 0x10, 0x76, 0x95, 0xa5, 0xbe, 0x4b, 0x02, 0xb2, 0x43, 0xe8, 0xf5, 0x6a, 0xf9, 0xb3,
+	0xb9, 0x8b, 0x05, 0x01, 0xaf, 0x8d, 0x5a, 0x31, 0x6c, 0x87, 0xe8, 0x7f, 0x29, 0x43, 0xed, 0x90,
+	0x98, 0x24, 0xfc, 0xef, 0xbd, 0x70, 0x6c, 0x41, 0x8f, 0x37, 0xfd, 0x6c, 0x2b, 0xd5, 0x3d, 0x5d,
+	0x06, 0x67, 0x3b, 0x32, 0xab, 0xde, 0x86, 0x65, 0x4e, 0x49, 0xef, 0x52, 0x35, 0x8c, 0x3a, 0x0c,
+	0x3c, 0x34, 0x89, 0xc9, 0xe8, 0xd2, 0x89, 0x50, 0xcb, 0xd6, 0x24, 0x42, 0x72, 0xea, 0x81, 0xb0,
+	0x5f, 0x8f, 0x25, 0x7f, 0x46, 0xd7, 0x89, 0x34, 0x0c, 0xad, 0xba, 0x81, 0x4b, 0xc3, 0xa8, 0xd8,
+	0x29, 0x37, 0xa1, 0x65, 0x63, 0x3b, 0x9a, 0x8f, 0x03, 0x1a, 0x18, 0xac, 0x5d, 0x29, 0x19, 0xc0,
+	0x40, 0x06, 0x85, 0xe8, 0xbf, 0x12, 0x83, 0x38, 0x03, 0xcf, 0x69, 0x7b, 0x22, 0x2e, 0x85, 0x55,
+	0xa8, 0x85, 0x8e, 0xfc, 0x04, 0x56, 0x0c, 0xbe, 0xa0, 0xd0, 0xc8, 0x23, 0x8e, 0x2b, 0x9c, 0xc2,
+	0x17, 0x54, 0x52, 0x2a, 0x86, 0x6a, 0x93, 0x06, 0x05, 0xb0, 0x50, 0x30, 0x61, 0xf9, 0x91, 0x13,
+	0x12, 0x7f, 0x1a, 0x98, 0xb3, 0xdd, 0xc8, 0x3a, 0xc1, 0xec, 0x36, 0x9d, 0x39, 0x9e, 0xf0, 0x06,
+	0xfd, 0xc9, 0x20, 0xe6, 0x99, 0x30, 0x3d, 0xfd, 0x49, 0x4f, 0xb2, 0xfc, 0xc8, 0x8b, 0x3b, 0x08,
+	0xb6, 0xa0, 0x50, 0xa6, 0x9e, 0x30, 0x28, 0x5f, 0xe8, 0xff, 0xaa, 0xc0, 0x4a, 0x4a, 0x05, 0x71,
+	0x73, 0xbc, 0x05, 0xf5, 0x39, 0xcb, 0x52, 0x51, 0x3f, 0xae, 0xc5, 0x4f, 0xb6, 0x6a, 0x0a, 0x1b,
+	0x82, 0x08, 0xbd, 0x0f, 0xad, 0x24, 0xf5, 0xe4, 0x35, 0x7a, 0x55, 0xf2, 0x64, 0x94, 0x30, 0xc0,
+	0x92, 0x19, 0x79, 0x69, 0x75, 0x79, 0x53, 0x6c, 0x1c, 0xaa, 0xc1, 0xc0, 0xf9, 0x63, 0x1f, 0xf1,
+	0x77, 0x03, 0x35, 0x55, 0x81, 0x83, 0x44, 0xa2, 0x42, 0x6c, 0xe1, 0xb0, 0x5f, 0xbf, 0x58, 0xb2,
+	0xa6, 0xb4, 0x7d, 0x26, 0x86, 0x96, 0x32, 0x31, 0x74, 0x83, 0x6f, 0x2a, 0xa4, 0x6a, 0x70, 0xa9,
+	0xe7, 0x71, 0xe0, 0xa4, 0xbc, 0xda, 0x4c, 0x7b, 0x35, 0x46, 0x4e, 0x1c, 0xd7, 0x65, 0x13, 0xc2,
+	0x12, 0x47, 0xee, 0x39, 0xae, 0x5b, 0x9c, 0x56, 0xad, 0x85, 0x0f, 0x87, 0x4a, 0x42, 0xb5, 0xb9,
+	0x10, 0x93, 0x38, 0x97, 0x6e, 0x41, 0x97, 0x47, 0xef, 0xb1, 0x43, 0x68, 0x04, 0xe3, 0x7e, 0x87,
+	0x1d, 0xd6, 0x66, 0xd0, 0x47, 0x0e, 0x31, 0x4c, 0x82, 0xef, 0xec, 0x00, 0xca, 0xbf, 0xc2, 0xa3,
+	0x65, 0x68, 0x3d, 0x79, 0x3a, 0x1e, 0x3c, 0x7d, 0xb2, 0x77, 0xb0, 0x3f, 0x38, 0xea, 0xfd, 0x1f,
+	0x6a, 0x43, 0xc3, 0x18, 0x3d, 0x3b, 0xd8, 0x19, 0x8c, 0x86, 0xbd, 0x12, 0x5d, 0xed, 0x1a, 0x3b,
+	0x4f, 0x06, 0x8f, 0x46, 0xc3, 0x5e, 0xf9, 0xce, 0x43, 0x40, 0xf9, 0x57, 0x2c, 0xd4, 0x84, 0xda,
+	0xce, 0x70, 0x38, 0x1a, 0x72, 0xe6, 0xc7, 0x4f, 0x87, 0xfb, 0x7b, 0xfb, 0x8c, 0xb9, 0x05, 0x4b,
+	0xc3, 0xd1, 0xc1, 0xe8, 0x88, 0xf1, 0x6e, 0x43, 0x43, 0x76, 0xf7, 0xa8, 0x0b, 0x30, 0x30, 0x46,
+	0x3b, 0x47, 0xa3, 0xe1, 0x78, 0x87, 0x9e, 0xd9, 0x80, 0xea, 0x93, 0x9d, 0xc7, 0xa3, 0x5e, 0x89,
+	0xfe, 0x3a, 0xdc, 0xff, 0x62, 0xd4, 0x2b, 0xdf, 0xff, 0x6e, 0x19, 0x6a, 0x9f, 0xfa, 0x64, 0xef,
+	0x10, 0xed, 0x41, 0x4b, 0x19, 0x6d, 0x23, 0x2d, 0x15, 0xa0, 0xa9, 0xc9, 0xb8, 0xb6, 0x51, 0x88,
+	0x13, 0x91, 0x7e, 0x07, 0x80, 0xbf, 0xa5, 0xb2, 0xc1, 0x77, 0x5b, 0xad, 0xb0, 0xb5, 0xae, 0xba,
+	0xda, 0x1f, 0xa2, 0x7b, 0x50, 0xe7, 0x13, 0x4b, 0xb4, 0x56, 0x38, 0xc1, 0x2c, 0x62, 0xe0, 0xa3,
+	0xb7, 0x84, 0x21, 0x35, 0x8a, 0xcb, 0x31, 0xbc, 0x03, 0x55, 0x6a, 0x0f, 0xb4, 0xa2, 0xbe, 0x7d,
+	0x48, 0xe2, 0xd5, 0x34, 0x50, 0x28, 0xf0, 0x0e, 0x54, 0x69, 0xb3, 0x9a, 0xb0, 0x28, 0x9d, 0xb3,
+	0xb6, 0x9a, 0x06, 0x0a, 0x96, 0x3d, 0x68, 0x29, 0xed, 0x57, 0x62, 0xbb, 0x7c, 0x5b, 0xaa, 0x6d,
+	0x14, 0xe2, 0xc4, 0x3e, 0xef, 0x41, 0x9d, 0xf7, 0xff, 0x89, 0x7a, 0xa9, 0x57, 0x07, 0x6d, 0x3d,
+	0x0b, 0x16, 0x8c, 0x3f, 0x82, 0x86, 0xac, 0xdc, 0x50, 0xc6, 0x04, 0x5a, 0x5f, 0xae, 0x73, 0x75,
+	0xf4, 0x01, 0x74, 0x52, 0x05, 0x2c, 0xba, 0x9e, 0x23, 0x55, 0x2a, 0x66, 0xed, 0xc6, 0x02, 0x6c,
+	0x62, 0x37, 0x5a, 0x2c, 0x25, 0x76, 0x53, 0x0a, 0x3b, 0x6d, 0x35, 0x0d, 0xcc, 0x0b, 0xc0, 0x0a,
+	0xce, 0xbc, 0x00, 0x6a, 0xe9, 0xab, 0xdd, 0x58, 0x80, 0x8d, 0xab, 0xd4, 0x2a, 0x9d, 0x78, 0x26,
+	0x02, 0x28, 0xf3, 0xcf, 0x5c, 0x60, 0xec, 0xc0, 0x72, 0x66, 0x78, 0x87, 0x5e, 0x4b, 0xaa, 0xda,
+	0xa2, 0xa9, 0x5e, 0x6e, 0x8b, 0xf7, 0xa0, 0xce, 0x1f, 0x27, 0x13, 0x6f, 0xa5, 0xde, 0x3f, 0xb5,
+	0xf5, 0x2c, 0x38, 0x09, 0x17, 0x65, 0x52, 0x95, 0x84, 0x4b, 0x7e, 0xc4, 0xa6, 0x6d, 0x14, 0xe2,
+	0xc4, 0x3e, 0x0f, 0x00, 0x92, 0x51, 0x2d, 0xba, 0x26, 0x49, 0x73, 0xe3, 0x5b, 0xad, 0x23, 0x51,
+	0xac, 0x0d, 0x45, 0x0f, 0xa1, 0xad, 0x4e, 0x6a, 0x51, 0x7c, 0x48, 0xc1, 0xfc, 0x36, 0xcb, 0xfb,
+	0x13, 0x68, 0xc8, 0x81, 0x29, 0xba, 0xaa, 0xe6, 0x8f, 0x32, 0xba, 0xd5, 0xfa, 0x79, 0x44, 0x5c,
+	0x41, 0xd7, 0xf9, 0x83, 0x72, 0x2e, 0x4c, 0x33, 0xe7, 0xec, 0x41, 0x4b, 0x19, 0x50, 0x25, 0x36,
+	0xca, 0x4f, 0xc7, 0xb4, 0x8d, 0x42, 0x9c, 0x38, 0x70, 0x1f, 0xda, 0xea, 0xb8, 0x09, 0x65, 0x88,
+	0x53, 0xd3, 0x2c, 0xed, 0x7a, 0x31, 0x52, 0x6c, 0xb5, 0x03, 0xdd, 0xf4, 0x94, 0x08, 0xc5, 0x01,
+	0x59, 0x38, 0x3d, 0xd2, 0x72, 0x13, 0x12, 0x1a, 0xf0, 0xa9, 0xf9, 0x4a, 0x12, 0xf0, 0x45, 0x63,
+	0x1a, 0xed, 0xc6, 0x02, 0xac, 0xa2, 0x9b, 0x32, 0xa0, 0x50, 0x74, 0xcb, 0x8f, 0x5e, 0xb4, 0xeb,
+	0xc5, 0x48, 0xb1, 0xd5, 0x67, 0xd0, 0xcb, 0x0e, 0x17, 0xd0, 0xcd, 0x38, 0x7c, 0x8b, 0x67, 0x17,
+	0xda, 0xe6, 0x62, 0x02, 0xb1, 0xed, 0x4f, 0xa1, 0x9b, 0x9e, 0x1f, 0x24, 0x26, 0x2b, 0x9c, 0x2b,
+	0x64, 0xa3, 0xe0, 0x7d, 0xe8, 0x7d, 0x82, 0x49, 0xba, 0xcd, 0x49, 0x93, 0x68, 0xc5, 0x95, 0x14,
+	0xda, 0x86, 0x16, 0x7b, 0x71, 0x10, 0xf5, 0x7d, 0x86, 0x29, 0xf6, 0x4c, 0xdc, 0x1a, 0xbc, 0x0d,
+	0x6d, 0xfe, 0xfb, 0x90, 0x17, 0xfe, 0x39, 0x0a, 0xad, 0x9b, 0x86, 0xa0, 0xbb, 0x34, 0x8b, 0x29,
+	0x80, 0x57, 0xf7, 0x99, 0x13, 0xe2, 0x25, 0xc7, 0xca, 0xaf, 0x2b, 0x2f, 0x0b, 0x33, 0x5f, 0xd7,
+	0x54, 0xb9, 0xab, 0x6d, 0x14, 0xe2, 0xb8, 0x41, 0x77, 0xaf, 0x7c, 0xb1, 0x9c, 0xf9, 0x7f, 0xb6,
+	0xe7, 0x75, 0xf6, 0xf7, 0xdd, 0x7f, 0x0f, 0x00, 0x54, 0x7e, 0x58, 0x7a, 0xe9, 0x26, 0x00, 0x00,
 }
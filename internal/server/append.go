@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// Append creates a new version of a file made of the chunks of its latest version
+// followed by the request's chunks, so a growing file, e.g. a log, is extended without
+// uploading its existing data again. The new version has the metadata of the version
+// it extends. A version stored inline is first saved to a packfile. The version is
+// only created if the latest version is still the one extended, and an Aborted error
+// is returned if a concurrent request changed the file. Returns a NotFound error if
+// the file does not exist, or a FailedPrecondition error if any chunk does not exist.
+func (srv *Server) Append(ctx context.Context, req *pb.AppendRequest) (*pb.FileID, error) {
+	if req.Name == "" {
+		return nil, twirp.RequiredArgumentError("name")
+	}
+	if len(req.Sums) == 0 {
+		return nil, twirp.RequiredArgumentError("sums")
+	}
+	name, err := srv.newFilename(req.Name)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("name", err.Error())
+	}
+
+	// A retried request returns the file version created by the original request
+	key := db.CreateKey{
+		Name:           name,
+		Manifest:       sum.Compute(append([]byte("append"), bytes.Join(req.Sums, nil)...)),
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if key.IdempotencyKey != "" {
+		prev, err := srv.db.GetJournalledFile(key)
+		if err == nil {
+			return srv.journalledFileID(prev)
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("db GetJournalledFile: %w", err)
+		}
+	}
+
+	latest, err := srv.db.GetLatestFileVersion(name)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("file %s", name))
+	} else if err != nil {
+		return nil, fmt.Errorf("db GetLatestFileVersion: %w", err)
+	}
+	if req.IfMatch != nil {
+		s, err := sum.FromBytes(req.IfMatch)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("if_match", err.Error())
+		}
+		if s != latest.Sum {
+			return nil, conflictError(db.ErrConflict, name)
+		}
+	}
+	prev, err := srv.db.GetFile(latest.Sum)
+	if err != nil {
+		return nil, fmt.Errorf("db GetFile: %w", err)
+	}
+	if prev.Data != nil {
+		if err := srv.packInline(ctx, prev); err != nil {
+			return nil, err
+		}
+	}
+	appended, err := srv.fileChunks(ctx, req.Sums)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]object.Chunk, 0, len(prev.Chunks)+len(appended))
+	chunks = append(chunks, prev.Chunks...)
+	for _, c := range appended {
+		c.Sequence = uint64(len(chunks))
+		chunks = append(chunks, c)
+	}
+	checksums, err := srv.fileChecksums(ctx, chunks, req.Checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	f := object.File{Name: name, Chunks: chunks, CreatedAt: time.Now().UTC(), Versioned: srv.cfg.VersioningEnabled}
+	if err := srv.checkQuotas(srv.newQuotaChange(f, latest, true)); err != nil {
+		return nil, err
+	}
+	cond := db.Precondition{IfMatch: &latest.Sum}
+	s, inserted, err := srv.insertFile(ctx, f, latest.Metadata, checksums, cond, key)
+	if err != nil {
+		return nil, conflictError(err, name)
+	}
+	if !inserted {
+		// A concurrent retry of this request created the file version first
+		return srv.journalledFileID(s)
+	}
+	srv.cache.invalidate(name)
+	id := &pb.FileID{Sum: s[:], Name: name}
+	if err := srv.replicate(ctx, id); err != nil {
+		return nil, err
+	}
+
+	// Delete the extended version if versioning is turned off
+	if !latest.Versioned && !srv.cfg.VersioningEnabled {
+		if err := srv.deleteReplaced(ctx, latest.Sum); err != nil {
+			srv.logger.Error().Msgf("deleting file version %x: %v", latest.Sum, err)
+		}
+	}
+	return id, nil
+}
+
+// packInline saves the data of a file version stored inline to a packfile, unless its
+// chunk is already stored, so the chunk may be referenced by other file versions.
+func (srv *Server) packInline(ctx context.Context, f object.File) error {
+	s := f.Chunks[0].Sum
+	exists, err := srv.db.ChunksExist([]sum.Sum{s})
+	if err != nil {
+		return fmt.Errorf("db ChunksExist: %w", err)
+	}
+	if exists[0] {
+		return nil
+	}
+	var pack serverPack
+	defer pack.discard(srv)
+	if err := pack.append(f.Data, s); err != nil {
+		return fmt.Errorf("writing packfile: %w", err)
+	}
+	_, err = srv.saveServerPack(ctx, &pack)
+	return err
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// maxBatchFiles is the largest number of files UploadFiles creates from one archive.
+const maxBatchFiles = 10000
+
+// UploadedFile is a file version created by UploadFiles.
+type UploadedFile struct {
+	Name string
+	Sum  sum.Sum
+	Size uint64
+}
+
+// UploadFilesResult summarizes an upload of the files in an archive.
+type UploadFilesResult struct {
+	// Files are the new file versions, in the order of the archive.
+	Files []UploadedFile
+	// Chunks is the number of new chunks saved, in Packs packfiles, and Bytes the
+	// size of their data before compression.
+	Chunks uint64
+	Packs  int
+	Bytes  uint64
+}
+
+// UploadFiles creates a version of a file for each regular file in the tar archive r,
+// named by its path in the archive under the directory dst. It saves the per-file
+// requests of a client when uploading many small files, e.g. a source tree. Each
+// file is chunked by the server with the chunker profile of its name, and the new
+// chunks of every file are saved together in shared packfiles. A file no larger than
+// the inline size is stored inline. The versions are created in a single transaction
+// once their chunks are saved, so either all or none are created. check, if not nil,
+// is called with the name of each file, and the upload fails with its error. Returns
+// an InvalidArgument error if the archive is malformed or a file name is invalid.
+func (srv *Server) UploadFiles(ctx context.Context, r io.Reader, dst string, check func(name string) error) (UploadFilesResult, error) {
+	if !srv.beginTask() {
+		return UploadFilesResult{}, twirp.NewError(twirp.Unavailable, "server is shutting down")
+	}
+	defer srv.tasks.Done()
+
+	dir := strings.TrimSuffix(srv.NormalizeName(dst), "/")
+	profiles, err := srv.ChunkerProfiles()
+	if err != nil {
+		return UploadFilesResult{}, err
+	}
+
+	var res UploadFilesResult
+	var pack serverPack
+	defer pack.discard(srv)
+	var versions []db.NewFileVersion
+	var replaced []db.FileInfo
+	var changes []quotaChange
+	names := make(map[string]bool)
+	now := time.Now().UTC()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, twirp.InvalidArgumentError("archive", err.Error())
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			// Directories, links and other special files are skipped
+			continue
+		}
+		if len(versions) == maxBatchFiles {
+			return res, twirp.InvalidArgumentError("archive", fmt.Sprintf("archive has more than %d files", maxBatchFiles))
+		}
+		// The name is not cleaned here, so names with ".." elements are rejected
+		name, err := srv.newFilename(dir + "/" + strings.TrimPrefix(hdr.Name, "./"))
+		if err != nil {
+			return res, twirp.InvalidArgumentError("archive", fmt.Sprintf("file %s: %v", hdr.Name, err))
+		}
+		if names[name] {
+			return res, twirp.InvalidArgumentError("archive", fmt.Sprintf("file %s appears more than once", hdr.Name))
+		}
+		names[name] = true
+		if check != nil {
+			if err := check(name); err != nil {
+				return res, err
+			}
+		}
+
+		f, checksums, err := srv.chunkFile(ctx, tr, name, profiles, &pack, &res)
+		if err != nil {
+			return res, fmt.Errorf("file %s: %w", hdr.Name, err)
+		}
+		f.CreatedAt = now
+
+		latest, err := srv.db.GetLatestFileVersion(name)
+		if err == nil {
+			replaced = append(replaced, latest)
+		} else if !errors.Is(err, db.ErrNotFound) {
+			return res, fmt.Errorf("db GetLatestFileVersion: %w", err)
+		}
+		changes = append(changes, srv.newQuotaChange(f, latest, err == nil))
+		versions = append(versions, db.NewFileVersion{
+			File:      f,
+			Sum:       sum.Compute(f.MarshalBinary()),
+			Metadata:  srv.cfg.DefaultMetadata.apply(name, nil),
+			Checksums: checksums,
+		})
+	}
+	saved, err := srv.saveServerPack(ctx, &pack)
+	if err != nil {
+		return res, err
+	}
+	if saved {
+		res.Packs++
+	}
+	if len(versions) == 0 {
+		return res, nil
+	}
+	if err := srv.checkQuotas(changes...); err != nil {
+		return res, err
+	}
+
+	// Save the new versions to the store before the database, as with CreateFile
+	var keys []string
+	for _, v := range versions {
+		key := v.Sum.AsHex() + ".file"
+		if err := srv.store.Put(ctx, srv.cfg.Bucket, key, bytes.NewReader(v.File.MarshalBinary())); err != nil {
+			return res, mergeErrors(err, srv.deleteKeys(keys))
+		}
+		keys = append(keys, key)
+	}
+	_, err = srv.db.InsertFiles(versions, nil)
+	srv.cache.invalidatePrefix(dir + "/")
+	if err != nil {
+		return res, mergeErrors(fmt.Errorf("db InsertFiles: %w", err), srv.deleteKeys(keys))
+	}
+	for _, v := range versions {
+		res.Files = append(res.Files, UploadedFile{Name: v.File.Name, Sum: v.Sum, Size: v.File.Size()})
+		if err := srv.replicate(ctx, &pb.FileID{Sum: v.Sum[:], Name: v.File.Name}); err != nil {
+			return res, err
+		}
+	}
+
+	// Delete the replaced versions if versioning is turned off
+	for _, latest := range replaced {
+		if !latest.Versioned && !srv.cfg.VersioningEnabled {
+			if err := srv.deleteReplaced(ctx, latest.Sum); err != nil {
+				srv.logger.Error().Msgf("deleting file version %x: %v", latest.Sum, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+type uploadedFileJSON struct {
+	Name string `json:"name"`
+	Sum  string `json:"sum"`
+	Size uint64 `json:"size"`
+}
+
+type filesUploadJSON struct {
+	Files  []uploadedFileJSON `json:"files"`
+	Chunks uint64             `json:"chunks"`
+	Packs  int                `json:"packs"`
+	Bytes  uint64             `json:"bytes"`
+}
+
+// FilesUploadHandler returns a http handler uploading the files of the tar archive in
+// the request body with UploadFiles, under the directory given by the dst query
+// parameter. check, if not nil, is called with the request's context and the name of
+// each file. The new file versions are returned as JSON with status 201.
+func (srv *Server) FilesUploadHandler(check func(ctx context.Context, name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		var checkName func(string) error
+		if check != nil {
+			checkName = func(name string) error { return check(ctx, name) }
+		}
+		dst := req.URL.Query().Get("dst")
+		if dst == "" {
+			dst = "/"
+		}
+		res, err := srv.UploadFiles(ctx, req.Body, dst, checkName)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		out := filesUploadJSON{Files: make([]uploadedFileJSON, len(res.Files)), Chunks: res.Chunks, Packs: res.Packs, Bytes: res.Bytes}
+		for i, f := range res.Files {
+			out.Files[i] = uploadedFileJSON{Name: f.Name, Sum: f.Sum.AsHex(), Size: f.Size}
+		}
+		writeJSON(w, http.StatusCreated, out)
+	}
+}
+
+// chunkFile reads the data of a new version of the file name from r. Data no larger
+// than the inline size is stored inline. Otherwise, it is split into chunks with the
+// chunker profile of the name, as with packFile. Returns the new version, without its
+// creation time, and its whole-file checksums.
+func (srv *Server) chunkFile(ctx context.Context, r io.Reader, name string, profiles []ChunkerProfile, pack *serverPack, res *UploadFilesResult) (object.File, map[string][]byte, error) {
+	f := object.File{Name: name, Versioned: srv.cfg.VersioningEnabled}
+	head, err := ioutil.ReadAll(io.LimitReader(r, int64(srv.cfg.InlineSize)+1))
+	if err != nil {
+		return f, nil, twirp.InvalidArgumentError("data", err.Error())
+	}
+	if len(head) > 0 && uint64(len(head)) <= srv.cfg.InlineSize {
+		f.Data = head
+		s := srv.ChunkHash().Compute(f.Data)
+		f.Chunks = []object.Chunk{{Sequence: 0, Size: uint64(len(f.Data)), Sum: s}}
+		checksums, err := srv.dataChecksums(f.Data, nil)
+		return f, checksums, err
+	}
+	opts := srv.fileChunkerOptions(profiles, name)
+	chunks, checksums, err := srv.packFile(ctx, io.MultiReader(bytes.NewReader(head), r), opts, pack, res)
+	f.Chunks = chunks
+	return f, checksums, err
+}
+
+// packFile splits the data read from r into chunks with opts, adding the chunks which
+// don't exist to the packfile, which is saved once it reaches the packfile size.
+// Returns the file's chunks and whole-file checksums.
+func (srv *Server) packFile(ctx context.Context, r io.Reader, opts chunker.Options, pack *serverPack, res *UploadFilesResult) ([]object.Chunk, map[string][]byte, error) {
+	hashes, err := srv.checksumHashes(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := chunker.New(r, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	chunks := make([]object.Chunk, 0)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, twirp.InvalidArgumentError("data", err.Error())
+		}
+		for _, h := range hashes {
+			h.Write(chunk.Data)
+		}
+		cs := srv.ChunkHash().Compute(chunk.Data)
+		chunks = append(chunks, object.Chunk{Sequence: uint64(len(chunks)), Size: uint64(len(chunk.Data)), Sum: cs})
+		if pack.sums[cs] {
+			continue
+		}
+		exists, err := srv.db.ChunksExist([]sum.Sum{cs})
+		if err != nil {
+			return nil, nil, fmt.Errorf("db ChunksExist: %w", err)
+		}
+		if exists[0] {
+			continue
+		}
+		if err := pack.append(chunk.Data, cs); err != nil {
+			return nil, nil, fmt.Errorf("writing packfile: %w", err)
+		}
+		res.Chunks++
+		res.Bytes += uint64(len(chunk.Data))
+		if pack.builder.BytesWritten() >= srv.packfileSize() {
+			if _, err := srv.saveServerPack(ctx, pack); err != nil {
+				return nil, nil, err
+			}
+			res.Packs++
+		}
+	}
+	checksums, err := verifyChecksums(hashes, nil)
+	return chunks, checksums, err
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// maxCacheEntries is the maximum number of responses held by a responseCache.
+const maxCacheEntries = 256
+
+// responseCache stores the responses of listing and stats requests for a short time,
+// so clients polling the same request don't each query the database. Every response
+// is stored with the prefix of the file names it depends on, and is removed when a
+// file with a name beginning with that prefix is changed. The cache assumes the server
+// is the only writer to its database.
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// gen is incremented by every invalidation, so a response computed while a file
+	// was being changed is not cached.
+	gen uint64
+}
+
+type cacheEntry struct {
+	prefix  string
+	resp    proto.Message
+	expires time.Time
+}
+
+// newResponseCache returns a cache which holds responses for ttl, or nil if ttl is
+// not positive. A nil cache is valid and caches nothing.
+func newResponseCache(ttl time.Duration) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &responseCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+// cacheKey returns the cache key of an RPC request. Maps are marshalled in key order so
+// equal requests have the same key.
+func cacheKey(method string, req proto.Message) string {
+	var buf proto.Buffer
+	buf.SetDeterministic(true)
+	buf.Marshal(req)
+	return method + "\x00" + string(buf.Bytes())
+}
+
+// do returns the cached response for key if it hasn't expired. Otherwise, it calls f
+// and caches its response if it succeeds. prefix is the prefix of every file name the
+// response depends on.
+func (c *responseCache) do(key string, prefix string, f func() (proto.Message, error)) (proto.Message, error) {
+	if c == nil {
+		return f()
+	}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.resp, nil
+	}
+	if ok {
+		delete(c.entries, key)
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	resp, err := f()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return resp, nil
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.removeExpired()
+		if len(c.entries) >= maxCacheEntries {
+			return resp, nil
+		}
+	}
+	c.entries[key] = cacheEntry{prefix: prefix, resp: resp, expires: time.Now().Add(c.ttl)}
+	return resp, nil
+}
+
+// removeExpired removes every expired entry. c.mu must be held.
+func (c *responseCache) removeExpired() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// invalidate removes the responses which may depend on the file name. Invalidating
+// the empty name removes only the responses which depend on every file, such as the
+// server stats.
+func (c *responseCache) invalidate(name string) {
+	c.remove(func(prefix string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
+
+// invalidatePrefix removes the responses which may depend on any file with a name
+// beginning with prefix.
+func (c *responseCache) invalidatePrefix(prefix string) {
+	c.remove(func(p string) bool {
+		return strings.HasPrefix(prefix, p) || strings.HasPrefix(p, prefix)
+	})
+}
+
+func (c *responseCache) remove(match func(prefix string) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for key, e := range c.entries {
+		if match(e.prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
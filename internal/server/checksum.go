@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/tracing"
+)
+
+// checksumAlgorithms are the whole-file checksums the server can compute for new file
+// versions.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// defaultChecksum is the whole-file checksum computed for every new file version.
+const defaultChecksum = "sha256"
+
+// CheckChecksumAlgorithms returns an error if any name is not a supported whole-file
+// checksum algorithm.
+func CheckChecksumAlgorithms(names []string) error {
+	for _, name := range names {
+		if _, ok := checksumAlgorithms[name]; !ok {
+			supported := make([]string, 0, len(checksumAlgorithms))
+			for a := range checksumAlgorithms {
+				supported = append(supported, a)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("unsupported checksum algorithm %q, expected one of %s", name, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+// fileChecksums computes the whole-file checksums of a file made of chunks, verifying
+// them against any expected checksums supplied by the client. The SHA-256 checksum,
+// the checksums in srv.cfg.Checksums and the expected checksums are computed. Each
+// chunk is read from the store once, and every checksum is computed in the same pass.
+// Returns an InvalidArgument error if an expected checksum does not match.
+func (srv *Server) fileChecksums(ctx context.Context, chunks []object.Chunk, expected map[string][]byte) (checksums map[string][]byte, err error) {
+	hashes, err := srv.checksumHashes(expected)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracing.Start(ctx, "fileChecksums")
+	defer func() { tracing.End(ctx, span, err) }()
+
+	indices := make([]db.ChunkIndex, len(chunks))
+	for i, c := range chunks {
+		idx, err := srv.chunkIndex(c.Sum)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, twirp.NewError(twirp.FailedPrecondition, fmt.Sprintf("chunk %x is unavailable", c.Sum))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("db GetChunkIndex: %w", err)
+		}
+		idx.Sequence = c.Sequence
+		indices[i] = idx
+	}
+
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+	if err := srv.writeChunks(ctx, indices, io.MultiWriter(writers...)); err != nil {
+		return nil, err
+	}
+	return verifyChecksums(hashes, expected)
+}
+
+// dataChecksums computes the whole-file checksums of a file's data, as fileChecksums
+// does for a file made of chunks.
+func (srv *Server) dataChecksums(data []byte, expected map[string][]byte) (map[string][]byte, error) {
+	hashes, err := srv.checksumHashes(expected)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hashes {
+		h.Write(data)
+	}
+	return verifyChecksums(hashes, expected)
+}
+
+// checksumHashes returns the hash of each whole-file checksum computed for a new file
+// version, keyed by algorithm. Returns an InvalidArgument error if an expected
+// checksum's algorithm is not supported.
+func (srv *Server) checksumHashes(expected map[string][]byte) (map[string]hash.Hash, error) {
+	hashes := map[string]hash.Hash{defaultChecksum: checksumAlgorithms[defaultChecksum]()}
+	for _, name := range srv.cfg.Checksums {
+		hashes[name] = checksumAlgorithms[name]()
+	}
+	for name := range expected {
+		newHash, ok := checksumAlgorithms[name]
+		if !ok {
+			return nil, twirp.InvalidArgumentError("checksums", fmt.Sprintf("unsupported checksum algorithm %q", name))
+		}
+		hashes[name] = newHash()
+	}
+	return hashes, nil
+}
+
+// verifyChecksums returns the checksum of each hash which has been written the data
+// of a file. Returns an InvalidArgument error if an expected checksum does not match.
+func verifyChecksums(hashes map[string]hash.Hash, expected map[string][]byte) (map[string][]byte, error) {
+	checksums := make(map[string][]byte, len(hashes))
+	for name, h := range hashes {
+		checksums[name] = h.Sum(nil)
+	}
+	for name, want := range expected {
+		if !bytes.Equal(checksums[name], want) {
+			msg := fmt.Sprintf("%s checksum of file is %x, expected %x", name, checksums[name], want)
+			return nil, twirp.InvalidArgumentError("checksums", msg)
+		}
+	}
+	return checksums, nil
+}
+
+// emptyChecksum is the SHA-256 checksum of an empty file.
+var emptyChecksum = sha256.Sum256(nil)
+
+// ByReference returns true if a CreateFile request creates a file version by
+// reference to data already stored: it has no chunks or inline data, and the SHA-256
+// checksum of a non-empty file.
+func ByReference(file *pb.File) bool {
+	want, ok := file.Checksums[defaultChecksum]
+	return len(file.Sums) == 0 && len(file.Data) == 0 && ok && !bytes.Equal(want, emptyChecksum[:])
+}
+
+// referencedChunks returns the chunks, inline data if any, and whole-file checksums of
+// the latest file version with the expected SHA-256 checksum, so a client can create
+// a version of data already stored without uploading any chunks. Checksums the
+// version doesn't have are computed, and every expected checksum is verified. Returns
+// a NotFound error if no file version has the checksum.
+func (srv *Server) referencedChunks(ctx context.Context, expected map[string][]byte) ([]object.Chunk, []byte, map[string][]byte, error) {
+	want := expected[defaultChecksum]
+	notFound := twirp.NotFoundError(fmt.Sprintf("no file version has %s checksum %x", defaultChecksum, want))
+	s, err := srv.db.FindFileVersionByChecksum(defaultChecksum, want)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, nil, nil, notFound
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("db FindFileVersionByChecksum: %w", err)
+	}
+	f, err := srv.db.GetFile(s)
+	if errors.Is(err, db.ErrNotFound) {
+		// The version was deleted since it was found
+		return nil, nil, nil, notFound
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("db GetFile: %w", err)
+	}
+	chunks := f.Chunks
+	if f.Data == nil {
+		sums := make([][]byte, len(f.Chunks))
+		for i := range f.Chunks {
+			sums[i] = f.Chunks[i].Sum[:]
+		}
+		if chunks, err = srv.fileChunks(ctx, sums); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	checksums, err := srv.db.GetFileChecksums(s)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("db GetFileChecksums: %w", err)
+	}
+
+	names := append([]string{defaultChecksum}, srv.cfg.Checksums...)
+	for name := range expected {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, ok := checksums[name]; !ok {
+			// The version predates the checksum, so the file is read to compute it
+			if f.Data != nil {
+				checksums, err = srv.dataChecksums(f.Data, expected)
+			} else {
+				checksums, err = srv.fileChecksums(ctx, chunks, expected)
+			}
+			return chunks, f.Data, checksums, err
+		}
+	}
+	for name, want := range expected {
+		if !bytes.Equal(checksums[name], want) {
+			msg := fmt.Sprintf("%s checksum of file is %x, expected %x", name, checksums[name], want)
+			return nil, nil, nil, twirp.InvalidArgumentError("checksums", msg)
+		}
+	}
+	return chunks, f.Data, checksums, nil
+}
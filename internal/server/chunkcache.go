@@ -0,0 +1,295 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/diskspace"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// ChunkCacheStats are the size of the chunk cache, and its use since the server
+// started.
+type ChunkCacheStats struct {
+	// Chunks is the number of chunks in the cache and Size their total size.
+	Chunks uint64
+	Size   uint64
+	// Hits is the number of chunks read from the cache instead of the store, and
+	// Misses the number read from the store and added to the cache.
+	Hits   uint64
+	Misses uint64
+	// Evictions is the number of chunks removed to keep the cache within its size,
+	// and Shed the number removed because the disk was low on free space.
+	Evictions uint64
+	Shed      uint64
+	// FreeSpace is the free space of the file system holding the cache, if a
+	// minimum free space is set.
+	FreeSpace uint64
+}
+
+// chunkCache keeps decoded chunks read from the store in a local directory, so chunks
+// of frequently read files are not fetched from the store again. Each chunk is stored
+// in a file named by its sum. The cache is limited by the total size of its chunks,
+// and the least recently used chunks are removed when it's full. Chunks read from the
+// cache are checked against their sum. The directory is owned by the server, and
+// chunks left in it by a previous run are reused. If a minimum free space is set, the
+// least recently used chunks are also removed when the free space of the directory's
+// file system falls below it, so the cache does not fill a disk shared with other
+// services.
+type chunkCache struct {
+	dir     string
+	maxSize int64
+	minFree int64
+	// free returns the free space of the file system holding a directory
+	free func(dir string) (uint64, error)
+	// hash is the hash function chunks are checked against their sums with
+	hash sum.Algorithm
+
+	loadOnce sync.Once
+	loadErr  error
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cachedChunk, most recently used first
+	entries map[sum.Sum]*list.Element
+	stats   ChunkCacheStats
+}
+
+type cachedChunk struct {
+	sum  sum.Sum
+	size int64
+}
+
+// newChunkCache returns a cache which keeps at most maxSize bytes of chunks in dir,
+// leaving at least minFree bytes free on its file system if minFree is positive.
+// Returns nil if dir is empty. A nil cache is valid, contains no chunks and discards
+// those added to it.
+func newChunkCache(dir string, maxSize int64, minFree int64, hash sum.Algorithm) *chunkCache {
+	if dir == "" {
+		return nil
+	}
+	return &chunkCache{
+		dir:     dir,
+		maxSize: maxSize,
+		minFree: minFree,
+		free:    diskspace.Free,
+		hash:    hash,
+		lru:     list.New(),
+		entries: make(map[sum.Sum]*list.Element),
+	}
+}
+
+// load adds the chunks already in the cache directory to the cache, in order of their
+// modification time, creating the directory if it does not exist.
+func (c *chunkCache) load() error {
+	c.loadOnce.Do(func() {
+		if err := os.MkdirAll(c.dir, 0755); err != nil {
+			c.loadErr = fmt.Errorf("creating chunk cache directory: %w", err)
+			return
+		}
+		type file struct {
+			chunk cachedChunk
+			used  time.Time
+		}
+		var files []file
+		err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			s, err := sum.FromHex(info.Name())
+			if err != nil {
+				// Not a chunk, e.g. a partially written temporary file
+				os.Remove(path)
+				return nil
+			}
+			files = append(files, file{cachedChunk{s, info.Size()}, info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			c.loadErr = fmt.Errorf("reading chunk cache directory: %w", err)
+			return
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].used.After(files[j].used) })
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, f := range files {
+			chunk := f.chunk
+			c.entries[chunk.sum] = c.lru.PushBack(&chunk)
+			c.size += chunk.size
+		}
+		c.evict()
+		c.shrink(0)
+	})
+	return c.loadErr
+}
+
+// path returns the name of the file holding the chunk s. Chunks are spread over 256
+// subdirectories by the first byte of their sum.
+func (c *chunkCache) path(s sum.Sum) string {
+	h := s.AsHex()
+	return filepath.Join(c.dir, h[:2], h)
+}
+
+// contains returns true if the chunk s is in the cache.
+func (c *chunkCache) contains(s sum.Sum) bool {
+	if c == nil || c.load() != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[s]
+	return ok
+}
+
+// get returns the chunk s, or false if it's not in the cache. A chunk which can't be
+// read, or does not match its sum, is removed.
+func (c *chunkCache) get(s sum.Sum) ([]byte, bool) {
+	if !c.contains(s) {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(c.path(s))
+	if err != nil || c.hash.Compute(b) != s {
+		c.remove(s)
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[s]; ok {
+		c.lru.MoveToFront(e)
+	}
+	c.stats.Hits++
+	return b, true
+}
+
+// put adds the chunk s to the cache, removing the least recently used chunks if the
+// cache is full. The chunk is not added if the disk would be left with less than the
+// minimum free space, even after removing every other chunk.
+func (c *chunkCache) put(s sum.Sum, b []byte) error {
+	if c == nil || int64(len(b)) > c.maxSize {
+		return nil
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+	if c.contains(s) {
+		return nil
+	}
+	c.mu.Lock()
+	ok := c.shrink(int64(len(b)))
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// Write to a temporary file first so a partially written chunk is never read
+	dst := c.path(s)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dst), "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Misses++
+	if _, ok := c.entries[s]; !ok {
+		c.entries[s] = c.lru.PushFront(&cachedChunk{s, int64(len(b))})
+		c.size += int64(len(b))
+	}
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used chunks until the cache is within its maximum
+// size. c.mu must be held.
+func (c *chunkCache) evict() {
+	for c.size > c.maxSize {
+		e := c.lru.Back()
+		chunk := c.lru.Remove(e).(*cachedChunk)
+		delete(c.entries, chunk.sum)
+		c.size -= chunk.size
+		c.stats.Evictions++
+		os.Remove(c.path(chunk.sum))
+	}
+}
+
+// shrink removes the least recently used chunks until the file system holding the
+// cache has room for need bytes in addition to the minimum free space. Returns false
+// if there is still not enough room once the cache is empty. The free space is
+// assumed to be sufficient if it can't be read. c.mu must be held.
+func (c *chunkCache) shrink(need int64) bool {
+	if c.minFree <= 0 {
+		return true
+	}
+	free, err := c.free(c.dir)
+	if err != nil {
+		return true
+	}
+	short := c.minFree + need - int64(free)
+	for short > 0 {
+		e := c.lru.Back()
+		if e == nil {
+			return false
+		}
+		chunk := c.lru.Remove(e).(*cachedChunk)
+		delete(c.entries, chunk.sum)
+		c.size -= chunk.size
+		c.stats.Shed++
+		os.Remove(c.path(chunk.sum))
+		short -= chunk.size
+	}
+	return true
+}
+
+// remove deletes the chunk s from the cache.
+func (c *chunkCache) remove(s sum.Sum) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[s]; ok {
+		c.size -= c.lru.Remove(e).(*cachedChunk).size
+		delete(c.entries, s)
+	}
+	os.Remove(c.path(s))
+}
+
+// ChunkCacheStats returns the size and use of the chunk cache. Returns false if the
+// cache is disabled.
+func (srv *Server) ChunkCacheStats() (ChunkCacheStats, bool) {
+	c := srv.chunkCache
+	if c == nil {
+		return ChunkCacheStats{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Chunks = uint64(len(c.entries))
+	stats.Size = uint64(c.size)
+	if c.minFree > 0 {
+		stats.FreeSpace, _ = c.free(c.dir)
+	}
+	return stats, true
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+
+	"github.com/twitchtv/twirp"
+)
+
+// ChunkReport reports how the chunker and packfiles behaved on the data uploaded in a
+// period: a histogram of the chunk sizes relative to the chunker parameters, how full
+// the packfiles were, and the fraction of the uploaded data which was deduplicated.
+func (srv *Server) ChunkReport(ctx context.Context, req *pb.ChunkReportRequest) (*pb.ChunkReportResponse, error) {
+	if req.Since < 0 || req.Until < 0 || (req.Until > 0 && req.Until <= req.Since) {
+		return nil, twirp.InvalidArgumentError("until", "must be after since")
+	}
+	var since, until time.Time
+	if req.Since > 0 {
+		since = time.Unix(0, req.Since)
+	}
+	if req.Until > 0 {
+		until = time.Unix(0, req.Until)
+	}
+	packSize := req.PackSize
+	if packSize == 0 {
+		packSize = srv.packfileSize()
+	}
+
+	p := srv.cfg.Params
+	chunkBounds := chunkSizeBounds(uint64(p.MinChunkSize), uint64(p.MaxChunkSize))
+	packBounds := make([]uint64, 10)
+	for i := range packBounds {
+		packBounds[i] = packSize * uint64(i+1) / 10
+	}
+	report, err := srv.db.GetChunkReport(since, until, chunkBounds, packBounds)
+	if err != nil {
+		return nil, fmt.Errorf("db GetChunkReport: %w", err)
+	}
+
+	params, _ := srv.GetChunkerParams(ctx, &pb.Empty{})
+	res := &pb.ChunkReportResponse{
+		Params:          params,
+		ChunkSizes:      histogram(chunkBounds, report.ChunkSizes),
+		StoredSize:      report.StoredSize,
+		PackSizes:       histogram(packBounds, report.PackSizes),
+		PackSize:        packSize,
+		NumFileVersions: report.NumFileVersions,
+		FilesSize:       report.FilesSize,
+	}
+	for _, b := range res.ChunkSizes {
+		res.NumChunks += b.Count
+		res.ChunksSize += b.Total
+	}
+	for _, b := range res.PackSizes {
+		res.NumPacks += b.Count
+		res.PacksSize += b.Total
+	}
+	if res.NumPacks > 0 {
+		res.PackFill = float64(res.PacksSize) / float64(res.NumPacks*packSize)
+	}
+	if res.FilesSize > res.ChunksSize {
+		res.DedupHitRate = 1 - float64(res.ChunksSize)/float64(res.FilesSize)
+	}
+	return res, nil
+}
+
+// chunkSizeBounds returns the bounds of the buckets of a chunk size histogram: the
+// minimum chunk size, doubling up to the maximum chunk size. Chunks smaller than the
+// minimum are the ends of files, and the last bucket holds the chunks cut at the
+// maximum.
+func chunkSizeBounds(min uint64, max uint64) []uint64 {
+	if min == 0 {
+		min = 1
+	}
+	var bounds []uint64
+	for b := min; b < max; b *= 2 {
+		bounds = append(bounds, b)
+	}
+	return append(bounds, max)
+}
+
+// histogram returns the buckets of a histogram with the given bounds.
+func histogram(bounds []uint64, buckets []db.Bucket) []*pb.HistogramBucket {
+	res := make([]*pb.HistogramBucket, len(buckets))
+	for i, b := range buckets {
+		res[i] = &pb.HistogramBucket{Count: b.Count, Total: b.Total}
+		if i > 0 {
+			res[i].Min = bounds[i-1]
+		}
+		if i < len(bounds) {
+			res[i].Max = bounds[i]
+		}
+	}
+	return res
+}
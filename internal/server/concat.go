@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// maxConcatSources is the largest number of file versions concatenated by a request.
+const maxConcatSources = 10000
+
+// Concat creates a new version of the file dst whose data is the data of the source
+// versions in order. The new version references the chunks of the sources, so no data
+// is copied, and parts of a large file uploaded in parallel, e.g. as temporary files,
+// may be assembled into one file. Source versions stored inline are first saved to a
+// packfile. Returns a NotFound error if a source version does not exist, or an Aborted
+// error if the request's IfMatch or IfNotExists precondition does not hold.
+func (srv *Server) Concat(ctx context.Context, req *pb.ConcatRequest) (*pb.FileID, error) {
+	if req.Dst == "" {
+		return nil, twirp.RequiredArgumentError("dst")
+	}
+	if len(req.SrcIds) == 0 {
+		return nil, twirp.RequiredArgumentError("src_ids")
+	}
+	if len(req.SrcIds) > maxConcatSources {
+		return nil, twirp.InvalidArgumentError("src_ids", fmt.Sprintf("at most %d versions may be concatenated", maxConcatSources))
+	}
+	name, err := srv.newFilename(req.Dst)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("dst", err.Error())
+	}
+	if err := validateMetadata(req.Metadata); err != nil {
+		return nil, twirp.InvalidArgumentError("metadata", err.Error())
+	}
+	metadata := srv.cfg.DefaultMetadata.apply(name, req.Metadata)
+	if len(metadata) > maxMetadataKeys {
+		msg := fmt.Sprintf("a file version may have at most %d metadata keys, including %d default keys", maxMetadataKeys, len(metadata)-len(req.Metadata))
+		return nil, twirp.InvalidArgumentError("metadata", msg)
+	}
+	cond, err := filePrecondition(&pb.File{IfMatch: req.IfMatch, IfNotExists: req.IfNotExists})
+	if err != nil {
+		return nil, err
+	}
+
+	// A retried request returns the file version created by the original request
+	key := db.CreateKey{
+		Name:           name,
+		Manifest:       sum.Compute(append([]byte("concat"), bytes.Join(req.SrcIds, nil)...)),
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if key.IdempotencyKey != "" {
+		prev, err := srv.db.GetJournalledFile(key)
+		if err == nil {
+			return srv.journalledFileID(prev)
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("db GetJournalledFile: %w", err)
+		}
+	}
+
+	// Splice the chunk lists of the sources, reading each source once
+	sources := make(map[sum.Sum]object.File)
+	chunks := make([]object.Chunk, 0)
+	for i, b := range req.SrcIds {
+		id, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("src_ids", fmt.Sprintf("sum %d: %v", i, err))
+		}
+		f, ok := sources[id]
+		if !ok {
+			f, err = srv.db.GetFile(id)
+			if errors.Is(err, db.ErrNotFound) {
+				return nil, twirp.NotFoundError(fmt.Sprintf("file %x", id))
+			} else if err != nil {
+				return nil, fmt.Errorf("db GetFile: %w", err)
+			}
+			if f.Data != nil {
+				if err := srv.packInline(ctx, f); err != nil {
+					return nil, err
+				}
+			}
+			sources[id] = f
+		}
+		for _, c := range f.Chunks {
+			c.Sequence = uint64(len(chunks))
+			chunks = append(chunks, c)
+		}
+	}
+	checksums, err := srv.fileChecksums(ctx, chunks, req.Checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := srv.db.GetLatestFileVersion(name)
+	hasPrev := err == nil
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("db GetLatestFileVersion: %w", err)
+	}
+	f := object.File{Name: name, Chunks: chunks, CreatedAt: time.Now().UTC(), Versioned: srv.cfg.VersioningEnabled}
+	if err := srv.checkQuotas(srv.newQuotaChange(f, latest, hasPrev)); err != nil {
+		return nil, err
+	}
+	s, inserted, err := srv.insertFile(ctx, f, metadata, checksums, cond, key)
+	if err != nil {
+		return nil, conflictError(err, name)
+	}
+	if !inserted {
+		// A concurrent retry of this request created the file version first
+		return srv.journalledFileID(s)
+	}
+	srv.cache.invalidate(name)
+	id := &pb.FileID{Sum: s[:], Name: name}
+	if err := srv.replicate(ctx, id); err != nil {
+		return nil, err
+	}
+
+	// Delete the previous version if versioning is turned off
+	if hasPrev && !latest.Versioned && !srv.cfg.VersioningEnabled {
+		if err := srv.deleteReplaced(ctx, latest.Sum); err != nil {
+			srv.logger.Error().Msgf("deleting file version %x: %v", latest.Sum, err)
+		}
+	}
+	return id, nil
+}
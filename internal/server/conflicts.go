@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ConflictStrategy is how the server resolves a conflict between two clients which
+// upload the same file concurrently: the client which creates its file version last
+// began its upload when the file had a different latest version.
+type ConflictStrategy string
+
+const (
+	// ConflictReplace makes the last version created the latest version of the file.
+	ConflictReplace ConflictStrategy = "replace"
+	// ConflictReject fails the upload of the last version created.
+	ConflictReject ConflictStrategy = "reject"
+	// ConflictBranch creates the last version under a new name, e.g.
+	// "/notes.conflict-1.txt" for "/notes.txt".
+	ConflictBranch ConflictStrategy = "branch"
+)
+
+// maxBranches is the maximum number of branched names tried for a file.
+const maxBranches = 100
+
+// ConflictRules map name prefixes to the conflict strategy of the files under them.
+// The strategy of a file is that of the longest prefix containing it, or
+// ConflictReplace if there is none. A prefix contains the file of the same name and
+// the files in the directory it names.
+type ConflictRules map[string]ConflictStrategy
+
+// NewConflictRules returns the conflict rules for a map from prefixes to the names of
+// strategies.
+func NewConflictRules(rules map[string]string) (ConflictRules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	cr := make(ConflictRules, len(rules))
+	for prefix, strategy := range rules {
+		s := ConflictStrategy(strings.ToLower(strategy))
+		switch s {
+		case ConflictReplace, ConflictReject, ConflictBranch:
+		default:
+			return nil, fmt.Errorf("invalid conflict strategy %q for prefix %s", strategy, prefix)
+		}
+		cr[cleanFilename(prefix)] = s
+	}
+	return cr, nil
+}
+
+// strategy returns the conflict strategy of the file name.
+func (cr ConflictRules) strategy(name string) ConflictStrategy {
+	strategy := ConflictReplace
+	best := -1
+	for prefix, s := range cr {
+		if len(prefix) <= best {
+			continue
+		}
+		// The root prefix "/" is cleaned to "", so it contains every file
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			strategy = s
+			best = len(prefix)
+		}
+	}
+	return strategy
+}
+
+// branchName returns the nth name a conflicting version of the file name is branched
+// to. The suffix goes before the extension, so the branch opens with the same
+// application as the file.
+func branchName(name string, n int) string {
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if ext == base {
+		// A dot file, e.g. ".profile", has no extension
+		ext = ""
+	}
+	return fmt.Sprintf("%s%s.conflict-%d%s", dir, strings.TrimSuffix(base, ext), n, ext)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// maxEventsSize is the maximum permitted size of a store notification request body.
+const maxEventsSize = 4 * 1024 * 1024
+
+// StoreEvent is a notification from the object store that an object has been created
+// or removed.
+type StoreEvent struct {
+	// Name is the event name, e.g. ObjectRemoved:Delete.
+	Name   string
+	Bucket string
+	Key    string
+	Size   uint64
+	Time   time.Time
+
+	// ETag is the ETag of a created object, if provided by the store.
+	ETag string
+}
+
+// removed returns true if the event signals the deletion of an object.
+func (e StoreEvent) removed() bool {
+	return strings.HasPrefix(strings.TrimPrefix(e.Name, "s3:"), "ObjectRemoved:")
+}
+
+// created returns true if the event signals an object being created or overwritten.
+func (e StoreEvent) created() bool {
+	return strings.HasPrefix(strings.TrimPrefix(e.Name, "s3:"), "ObjectCreated:")
+}
+
+type s3EventRecord struct {
+	EventName string    `json:"eventName"`
+	EventTime time.Time `json:"eventTime"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key  string `json:"key"`
+			Size uint64 `json:"size"`
+			ETag string `json:"eTag"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+type s3EventMessage struct {
+	Records []s3EventRecord `json:"Records"`
+
+	// Set if the message was delivered through SNS
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// ParseStoreEvents parses an S3 bucket notification message. Messages delivered
+// directly by S3 or MinIO, or wrapped in an SNS notification, are accepted. Test
+// events result in an empty slice.
+func ParseStoreEvents(b []byte) ([]StoreEvent, error) {
+	var msg s3EventMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, fmt.Errorf("decoding JSON: %w", err)
+	}
+	if msg.Type == "Notification" {
+		return ParseStoreEvents([]byte(msg.Message))
+	}
+
+	events := make([]StoreEvent, 0, len(msg.Records))
+	for i, r := range msg.Records {
+		// Object keys are URL encoded in notifications
+		key, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: invalid object key %q: %w", i, r.S3.Object.Key, err)
+		}
+		events = append(events, StoreEvent{
+			Name:   r.EventName,
+			Bucket: r.S3.Bucket.Name,
+			Key:    key,
+			Size:   r.S3.Object.Size,
+			ETag:   strings.Trim(r.S3.Object.ETag, `"`),
+			Time:   r.EventTime,
+		})
+	}
+	return events, nil
+}
+
+// HandleStoreEvents checks each event for modifications to packfiles made outside of
+// the server. A packfile which has been deleted, or overwritten with different data, is
+// marked as degraded.
+func (srv *Server) HandleStoreEvents(ctx context.Context, events []StoreEvent) error {
+	for _, e := range events {
+		if e.Bucket != srv.cfg.Bucket || !strings.HasSuffix(e.Key, ".pack") {
+			continue
+		}
+		s, err := sum.FromHex(strings.TrimSuffix(e.Key, ".pack"))
+		if err != nil {
+			continue
+		}
+		if err := srv.db.InsertStoreEvent(e.Key, e.Name, e.Time, time.Now()); err != nil {
+			return fmt.Errorf("db InsertStoreEvent: %w", err)
+		}
+
+		info, err := srv.db.GetPackInfo(s)
+		if errors.Is(err, db.ErrNotFound) {
+			// Not a packfile we know about, or one which has since been vacuumed
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("db GetPackInfo: %w", err)
+		}
+		if !info.DegradedAt.IsZero() {
+			continue
+		}
+
+		var reason string
+		switch {
+		case e.removed():
+			// Confirm the object is actually missing because the server may have
+			// re-uploaded the packfile since the event was sent.
+			exists, err := srv.objectExists(ctx, e.Key)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				reason = "packfile deleted from store"
+			}
+		case e.created():
+			if e.Size != info.Size {
+				reason = fmt.Sprintf("packfile overwritten in store: expected size %d but new size is %d", info.Size, e.Size)
+			} else if e.ETag != "" && info.ETag != "" && !strings.EqualFold(e.ETag, info.ETag) {
+				// An overwrite with data of the same size is only detected by its ETag
+				reason = fmt.Sprintf("packfile overwritten in store: expected ETag %s but new ETag is %s", info.ETag, e.ETag)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		if err := srv.db.MarkPackDegraded(s, time.Now()); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("db MarkPackDegraded: %w", err)
+		}
+		srv.locations.removePack(s)
+		srv.logger.Error().
+			Str("key", e.Key).
+			Str("event", e.Name).
+			Time("event_time", e.Time).
+			Msgf("%s outside of server. Packfile marked as degraded", reason)
+	}
+	return nil
+}
+
+// objectExists returns true if an object exists in the server's bucket.
+func (srv *Server) objectExists(ctx context.Context, key string) (bool, error) {
+	r, err := srv.store.Get(ctx, srv.cfg.Bucket, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting object %s: %w", key, err)
+	}
+	return true, r.Close()
+}
+
+// StoreEventsHandler accepts bucket notifications sent by the object store through a
+// webhook. Requests must provide the token in an Authorization bearer header.
+func (srv *Server) StoreEventsHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		auth := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxEventsSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(b) == 0 {
+			// MinIO sends an empty request to check the endpoint is reachable
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		events, err := ParseStoreEvents(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := srv.HandleStoreEvents(req.Context(), events); err != nil {
+			internalError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	pb "github.com/jotfs/jotfs/internal/protos"
+)
+
+// inlineChunks returns the single chunk of a file whose data is stored inline in the
+// database. Returns an InvalidArgument error if the request also has chunk sums, or
+// its data exceeds the inline size.
+func (srv *Server) inlineChunks(file *pb.File) ([]object.Chunk, error) {
+	if len(file.Sums) > 0 {
+		return nil, twirp.InvalidArgumentError("data", "may not be set with sums")
+	}
+	if srv.cfg.InlineSize == 0 {
+		return nil, twirp.InvalidArgumentError("data", "inline storage is disabled")
+	}
+	if size := uint64(len(file.Data)); size > srv.cfg.InlineSize {
+		return nil, twirp.InvalidArgumentError("data", fmt.Sprintf("size %d exceeds the inline size %d", size, srv.cfg.InlineSize))
+	}
+	s := srv.ChunkHash().Compute(file.Data)
+	return []object.Chunk{{Sequence: 0, Size: uint64(len(file.Data)), Sum: s}}, nil
+}
+
+// inlineData returns the data of a file stored inline, given its chunks. Returns false
+// if the file's data is stored in packfiles.
+func inlineData(indices []db.ChunkIndex) ([]byte, bool) {
+	if len(indices) != 1 || indices[0].Inline == nil {
+		return nil, false
+	}
+	return indices[0].Inline, true
+}
+
+// inlineSection returns the download section of a file stored inline, which holds its
+// data in place of a URL.
+func inlineSection(idx db.ChunkIndex) *pb.Section {
+	s := idx.Block.Sum
+	chunk := &pb.SectionChunk{Sequence: idx.Sequence, Size: idx.Block.ChunkSize, Sum: s[:]}
+	return &pb.Section{Chunks: []*pb.SectionChunk{chunk}, Data: idx.Inline}
+}
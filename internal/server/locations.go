@@ -0,0 +1,211 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/jotfs/jotfs/internal/tracing"
+)
+
+// LocationCacheStats are the size of the chunk location cache, and its use since the
+// server started.
+type LocationCacheStats struct {
+	// Entries is the number of chunk locations in the cache.
+	Entries uint64
+	// Hits is the number of chunks located with the cache, and Misses the number
+	// located with the database or the packfile index cache instead.
+	Hits   uint64
+	Misses uint64
+}
+
+// locationCache is an in-memory cache of the location of chunks in their packfiles,
+// holding up to a fixed number of locations. The least recently used locations are
+// removed when it's full. Locations in degraded packfiles and the chunks of files
+// stored inline are never cached, and the locations in a packfile are removed when
+// it's deleted or degraded.
+type locationCache struct {
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // of db.ChunkIndex, most recently used first
+	entries map[sum.Sum]*list.Element
+	stats   LocationCacheStats
+}
+
+// newLocationCache returns a cache holding up to size locations, or nil if size is not
+// positive. A nil cache is valid, finds no chunks and discards locations added to it.
+func newLocationCache(size int) *locationCache {
+	if size <= 0 {
+		return nil
+	}
+	return &locationCache{size: size, lru: list.New(), entries: make(map[sum.Sum]*list.Element)}
+}
+
+// get returns the location of the chunk s. If packSum is not zero, the location must
+// be in that packfile. Returns false if there is no such location in the cache.
+func (c *locationCache) get(s sum.Sum, packSum sum.Sum) (db.ChunkIndex, bool) {
+	if c == nil {
+		return db.ChunkIndex{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[s]
+	if !ok || (packSum != sum.Sum{} && e.Value.(db.ChunkIndex).PackSum != packSum) {
+		c.stats.Misses++
+		return db.ChunkIndex{}, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(db.ChunkIndex), true
+}
+
+// add adds the locations of chunks to the cache, removing the least recently used
+// locations if it's full. The sequence of each chunk in its file is not kept.
+func (c *locationCache) add(indices []db.ChunkIndex) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, idx := range indices {
+		if idx.PackDegraded || idx.Inline != nil {
+			continue
+		}
+		idx.Sequence = 0
+		if e, ok := c.entries[idx.Block.Sum]; ok {
+			e.Value = idx
+			c.lru.MoveToFront(e)
+			continue
+		}
+		c.entries[idx.Block.Sum] = c.lru.PushFront(idx)
+		for c.lru.Len() > c.size {
+			old := c.lru.Remove(c.lru.Back()).(db.ChunkIndex)
+			delete(c.entries, old.Block.Sum)
+		}
+	}
+}
+
+// removePack removes the locations of the chunks in a packfile.
+func (c *locationCache) removePack(packSum sum.Sum) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for s, e := range c.entries {
+		if e.Value.(db.ChunkIndex).PackSum == packSum {
+			c.lru.Remove(e)
+			delete(c.entries, s)
+		}
+	}
+}
+
+// clear removes all locations from the cache.
+func (c *locationCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[sum.Sum]*list.Element)
+}
+
+// LocationCacheStats returns the size and use of the chunk location cache. Returns
+// false if the cache is disabled.
+func (srv *Server) LocationCacheStats() (LocationCacheStats, bool) {
+	c := srv.locations
+	if c == nil {
+		return LocationCacheStats{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = uint64(c.lru.Len())
+	return stats, true
+}
+
+// chunkIndex returns the location of a chunk in a packfile which is not degraded,
+// from the location cache or the database. Returns db.ErrNotFound if there is no such
+// location.
+func (srv *Server) chunkIndex(s sum.Sum) (db.ChunkIndex, error) {
+	if idx, ok := srv.locations.get(s, sum.Sum{}); ok {
+		return idx, nil
+	}
+	idx, err := srv.db.GetChunkIndex(s)
+	if err != nil {
+		return db.ChunkIndex{}, err
+	}
+	srv.locations.add([]db.ChunkIndex{idx})
+	return idx, nil
+}
+
+// chunkSize returns the size of a chunk, from the location cache or the database.
+// Returns db.ErrNotFound if the chunk does not exist.
+func (srv *Server) chunkSize(s sum.Sum) (uint64, error) {
+	if idx, ok := srv.locations.get(s, sum.Sum{}); ok {
+		return idx.Block.ChunkSize, nil
+	}
+	return srv.db.GetChunkSize(s)
+}
+
+// cachedFileChunks returns the chunks of a file, located with the location cache and
+// the packfile index cache. Returns false if any chunk must be located with the
+// database instead. Returns a NotFound error if the file does not exist.
+func (srv *Server) cachedFileChunks(ctx context.Context, fileID sum.Sum) (indices []db.ChunkIndex, ok bool, err error) {
+	_, span := tracing.Start(ctx, "cachedFileChunks")
+	defer func() { tracing.End(ctx, span, err) }()
+
+	refs, err := srv.db.GetFileChunkRefs(fileID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, false, twirp.NotFoundError(fmt.Sprintf("file %x", fileID))
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("db GetFileChunkRefs: %w", err)
+	}
+
+	indices = make([]db.ChunkIndex, len(refs))
+	var missing []int
+	for i, ref := range refs {
+		if ref.PackDegraded {
+			return nil, false, nil
+		}
+		if ref.Inline != nil {
+			size := uint64(len(ref.Inline))
+			indices[i] = db.ChunkIndex{Sequence: ref.Sequence, Block: object.BlockInfo{Sum: ref.Sum, ChunkSize: size}, Inline: ref.Inline}
+			continue
+		}
+		idx, ok := srv.locations.get(ref.Sum, ref.PackSum)
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		idx.Sequence = ref.Sequence
+		indices[i] = idx
+	}
+	if len(missing) == 0 {
+		return indices, true, nil
+	}
+
+	missingRefs := make([]db.ChunkRef, len(missing))
+	for i, j := range missing {
+		missingRefs[i] = refs[j]
+	}
+	found, ok, err := srv.indexCache.fileChunks(missingRefs)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	srv.locations.add(found)
+	for i, j := range missing {
+		indices[j] = found[i]
+	}
+	return indices, true, nil
+}
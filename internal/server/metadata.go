@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// Limits on the user-defined metadata of a file version.
+const (
+	maxMetadataKeys      = 32
+	maxMetadataKeySize   = 128
+	maxMetadataValueSize = 1024
+)
+
+// contentTypeKey is the metadata key set from the content types of a MetadataRule.
+const contentTypeKey = "content-type"
+
+// MetadataRule sets default metadata on the new file versions under a name prefix.
+// Keys set by the upload are not changed.
+type MetadataRule struct {
+	// Prefix contains the file of the same name and the files in the directory it
+	// names.
+	Prefix string
+	// Metadata are the default keys and values, e.g. an owner or classification.
+	Metadata map[string]string
+	// ContentTypes map file extensions, e.g. ".csv", to the default value of the
+	// "content-type" key. Extensions are not case sensitive.
+	ContentTypes map[string]string
+}
+
+// MetadataRules are the rules setting default metadata on new file versions, ordered
+// from the shortest prefix to the longest.
+type MetadataRules []MetadataRule
+
+// NewMetadataRules validates the rules and returns them in the order they are applied.
+// Where the rules for nested prefixes set the same key, the value of the longest
+// prefix is used.
+func NewMetadataRules(rules []MetadataRule) (MetadataRules, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	mr := make(MetadataRules, len(rules))
+	for i, rule := range rules {
+		if err := validateMetadata(rule.Metadata); err != nil {
+			return nil, fmt.Errorf("metadata rule for prefix %s: %w", rule.Prefix, err)
+		}
+		types := make(map[string]string, len(rule.ContentTypes))
+		for ext, typ := range rule.ContentTypes {
+			if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+				return nil, fmt.Errorf("metadata rule for prefix %s: extension %q must begin with '.'", rule.Prefix, ext)
+			}
+			if err := validateMetadata(map[string]string{contentTypeKey: typ}); err != nil {
+				return nil, fmt.Errorf("metadata rule for prefix %s: %w", rule.Prefix, err)
+			}
+			types[strings.ToLower(ext)] = typ
+		}
+		mr[i] = MetadataRule{Prefix: cleanFilename(rule.Prefix), Metadata: rule.Metadata, ContentTypes: types}
+	}
+	sort.SliceStable(mr, func(i, j int) bool { return len(mr[i].Prefix) < len(mr[j].Prefix) })
+	return mr, nil
+}
+
+// apply returns the metadata of a new version of the file name with the default
+// metadata of the rules containing it added. metadata is not modified.
+func (mr MetadataRules) apply(name string, metadata map[string]string) map[string]string {
+	defaults := make(map[string]string)
+	ext := strings.ToLower(path.Ext(name))
+	for _, rule := range mr {
+		// The root prefix "/" is cleaned to "", so it contains every file
+		if name != rule.Prefix && !strings.HasPrefix(name, rule.Prefix+"/") {
+			continue
+		}
+		for k, v := range rule.Metadata {
+			defaults[k] = v
+		}
+		if typ, ok := rule.ContentTypes[ext]; ok {
+			defaults[contentTypeKey] = typ
+		}
+	}
+	if len(defaults) == 0 {
+		return metadata
+	}
+	for k, v := range metadata {
+		defaults[k] = v
+	}
+	return defaults
+}
+
+// SetMetadata changes the user-defined metadata of a file version. Keys in Set are
+// added or replaced, and keys in Delete are removed. Returns the metadata of the file
+// version after the change, or a NotFound error if the file version does not exist.
+func (srv *Server) SetMetadata(ctx context.Context, req *pb.SetMetadataRequest) (*pb.SetMetadataResponse, error) {
+	if req.Sum == nil {
+		return nil, twirp.RequiredArgumentError("sum")
+	}
+	if len(req.Set) == 0 && len(req.Delete) == 0 {
+		return nil, twirp.RequiredArgumentError("set or delete")
+	}
+	s, err := sum.FromBytes(req.Sum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("sum", err.Error())
+	}
+	if err := validateMetadata(req.Set); err != nil {
+		return nil, twirp.InvalidArgumentError("set", err.Error())
+	}
+
+	var invalid error
+	metadata, err := srv.db.SetMetadata(s, req.Set, req.Delete, func(metadata map[string]string) error {
+		if len(metadata) > maxMetadataKeys {
+			invalid = fmt.Errorf("a file version may have at most %d metadata keys", maxMetadataKeys)
+		}
+		return invalid
+	})
+	if invalid != nil {
+		return nil, twirp.InvalidArgumentError("set", invalid.Error())
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("file %x", s))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db SetMetadata: %w", err)
+	}
+
+	info, err := srv.db.GetFileInfo(s)
+	if err == nil {
+		srv.cache.invalidate(info.Name)
+	} else {
+		srv.cache.invalidatePrefix("")
+	}
+	return &pb.SetMetadataResponse{Metadata: metadata}, nil
+}
+
+// validateMetadata returns an error if any metadata key or value is invalid. Keys may
+// only contain ASCII letters, digits, '-', '_' and '.', so they can be written as
+// key=value pairs on the command line.
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("a file version may have at most %d metadata keys", maxMetadataKeys)
+	}
+	for k, v := range metadata {
+		if k == "" {
+			return errors.New("metadata key cannot be empty")
+		}
+		if len(k) > maxMetadataKeySize {
+			return fmt.Errorf("metadata key %q exceeds maximum size %d", k, maxMetadataKeySize)
+		}
+		for _, c := range k {
+			if !isMetadataKeyChar(c) {
+				return fmt.Errorf("metadata key %q cannot contain %q", k, c)
+			}
+		}
+		if len(v) > maxMetadataValueSize {
+			return fmt.Errorf("value of metadata key %q exceeds maximum size %d", k, maxMetadataValueSize)
+		}
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("value of metadata key %q is not valid UTF-8", k)
+		}
+	}
+	return nil
+}
+
+func isMetadataKeyChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// MigrateResult summarizes a store migration.
+type MigrateResult struct {
+	// Copied is the number of objects copied to the destination store, and Bytes
+	// their total size.
+	Copied int
+	Bytes  uint64
+	// Skipped is the number of objects already in the destination store.
+	Skipped int
+	// Missing is the number of objects referenced by the database which are not in
+	// the source store, e.g. the packfiles of degraded packs.
+	Missing int
+}
+
+// MigrateStore copies every packfile, pack index and file object referenced by the
+// database from the server's store to the bucket of the store dst. The objects are
+// checked against their checksums as they are copied, so a corrupt object is never
+// written to dst. Objects already in dst with the expected size are skipped, so a
+// migration which is interrupted, or run again to copy the objects created since it
+// last ran, only copies the objects which are missing. Nothing in the database is
+// changed unless updateETags is set, in which case the recorded ETag of each packfile
+// is replaced by its ETag in dst, for a scrub once the server has been switched to
+// dst. If progress is not nil, it's called with the key of each object and whether it
+// was "copy", "skip" or "missing".
+func (srv *Server) MigrateStore(ctx context.Context, dst store.Store, bucket string, updateETags bool, progress func(key string, action string)) (MigrateResult, error) {
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+	var res MigrateResult
+	record := func(key string, action string, size uint64) {
+		switch action {
+		case "copy":
+			res.Copied++
+			res.Bytes += size
+		case "skip":
+			res.Skipped++
+		case "missing":
+			res.Missing++
+		}
+		progress(key, action)
+	}
+
+	packs, err := srv.db.ListPacks()
+	if err != nil {
+		return res, fmt.Errorf("db ListPacks: %w", err)
+	}
+	for _, pack := range packs {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		pkey := pack.Sum.AsHex() + ".pack"
+		ikey := pack.Sum.AsHex() + ".index"
+
+		info, err := store.Stat(ctx, dst, bucket, pkey)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return res, fmt.Errorf("getting info for %s: %w", pkey, err)
+		}
+		var index object.PackIndex
+		copied := false
+		if err == nil && info.Size == pack.Size {
+			record(pkey, "skip", 0)
+		} else {
+			index, err = srv.migratePackfile(ctx, dst, bucket, pkey, pack.Sum)
+			if errors.Is(err, store.ErrNotFound) {
+				record(pkey, "missing", 0)
+				continue
+			}
+			if err != nil {
+				return res, err
+			}
+			if info, err = store.Stat(ctx, dst, bucket, pkey); err != nil {
+				return res, fmt.Errorf("getting info for %s: %w", pkey, err)
+			}
+			if info.Size != pack.Size {
+				return res, fmt.Errorf("store saved %d bytes of packfile %s but expected %d", info.Size, pkey, pack.Size)
+			}
+			record(pkey, "copy", pack.Size)
+			copied = true
+		}
+		if updateETags && info.ETag != "" && info.ETag != pack.ETag {
+			if err := srv.db.SetPackETag(pack.Sum, info.ETag); err != nil {
+				return res, fmt.Errorf("db SetPackETag: %w", err)
+			}
+		}
+
+		// The index is copied if the packfile was copied, or it's missing from dst
+		if !copied {
+			_, err := store.Stat(ctx, dst, bucket, ikey)
+			if err == nil {
+				record(ikey, "skip", 0)
+				continue
+			}
+			if !errors.Is(err, store.ErrNotFound) {
+				return res, fmt.Errorf("getting info for %s: %w", ikey, err)
+			}
+			r, err := dst.Get(ctx, bucket, pkey)
+			if err != nil {
+				return res, fmt.Errorf("reading %s: %w", pkey, err)
+			}
+			index, err = object.LoadPackIndexLimited(r, object.PackLimits{ChunkHash: srv.ChunkHash()})
+			if err = mergeErrors(err, r.Close()); err != nil {
+				return res, fmt.Errorf("reading %s: %w", pkey, err)
+			}
+		}
+		if err := srv.migratePackIndex(ctx, dst, bucket, ikey, index); err != nil {
+			return res, err
+		}
+		record(ikey, "copy", uint64(len(index.MarshalBinary())))
+	}
+
+	sums, err := srv.db.ListFileVersionSums()
+	if err != nil {
+		return res, fmt.Errorf("db ListFileVersionSums: %w", err)
+	}
+	for _, s := range sums {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		key := s.AsHex() + ".file"
+		_, err := store.Stat(ctx, dst, bucket, key)
+		if err == nil {
+			record(key, "skip", 0)
+			continue
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return res, fmt.Errorf("getting info for %s: %w", key, err)
+		}
+		b, err := store.GetObject(ctx, srv.store, srv.cfg.Bucket, key)
+		if errors.Is(err, store.ErrNotFound) {
+			record(key, "missing", 0)
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("reading %s: %w", key, err)
+		}
+		if sum.Compute(b) != s {
+			return res, fmt.Errorf("file %s in source store does not match its checksum", key)
+		}
+		if err := dst.Put(ctx, bucket, key, bytes.NewReader(b)); err != nil {
+			return res, fmt.Errorf("uploading %s: %w", key, err)
+		}
+		record(key, "copy", uint64(len(b)))
+	}
+	return res, nil
+}
+
+// migratePackfile streams a packfile from the server's store to dst, and returns its
+// index. The packfile is deleted from dst if it does not match its checksum. Returns
+// an error wrapping store.ErrNotFound if the packfile is not in the server's store.
+func (srv *Server) migratePackfile(ctx context.Context, dst store.Store, bucket string, key string, s sum.Sum) (object.PackIndex, error) {
+	src, err := srv.store.Get(ctx, srv.cfg.Bucket, key)
+	if err != nil {
+		return object.PackIndex{}, fmt.Errorf("reading %s: %w", key, err)
+	}
+	defer src.Close()
+
+	// Upload the packfile while it's read to generate the index
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, w := io.Pipe()
+	var g errgroup.Group
+	g.Go(func() error {
+		err := dst.Put(ctx, bucket, key, r)
+		return mergeErrors(err, r.CloseWithError(err))
+	})
+	index, err := object.LoadPackIndexLimited(io.TeeReader(src, w), object.PackLimits{ChunkHash: srv.ChunkHash()})
+	if err == nil && index.Sum != s {
+		err = fmt.Errorf("packfile %s in source store has checksum %x", key, index.Sum)
+	}
+	if err != nil {
+		cancel()
+		w.CloseWithError(err)
+		g.Wait()
+		return object.PackIndex{}, mergeErrors(fmt.Errorf("reading %s: %w", key, err), deleteIfExists(dst, bucket, key))
+	}
+	if err := w.Close(); err != nil {
+		return object.PackIndex{}, err
+	}
+	if err := g.Wait(); err != nil {
+		return object.PackIndex{}, fmt.Errorf("uploading %s: %w", key, err)
+	}
+	return index, nil
+}
+
+// migratePackIndex writes the index of a packfile to dst. If the server's store holds
+// the index, it must be identical to the index generated from the packfile.
+func (srv *Server) migratePackIndex(ctx context.Context, dst store.Store, bucket string, key string, index object.PackIndex) error {
+	b := index.MarshalBinary()
+	old, err := store.GetObject(ctx, srv.store, srv.cfg.Bucket, key)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("reading %s: %w", key, err)
+	}
+	if err == nil && !bytes.Equal(old, b) {
+		return fmt.Errorf("index %s in source store does not match its packfile", key)
+	}
+	if err := dst.Put(ctx, bucket, key, bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("uploading %s: %w", key, err)
+	}
+	return nil
+}
+
+// deleteIfExists deletes an object, ignoring the error if it does not exist.
+func deleteIfExists(s store.Store, bucket string, key string) error {
+	if err := s.Delete(bucket, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of a file into memory. Files are not
+// memory-mapped on Windows.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// unmapFile releases memory returned by mapFile.
+func unmapFile(b []byte) error {
+	return nil
+}
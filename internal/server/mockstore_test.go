@@ -3,8 +3,13 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jotfs/jotfs/internal/store"
@@ -70,3 +75,110 @@ func (s mockStore) Get(ctx context.Context, bucket string, key string) (io.ReadC
 	b := bytes.NewReader(data)
 	return ioutil.NopCloser(b), nil
 }
+
+func (s mockStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	r, err := s.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := ioutil.ReadAll(r)
+	return ioutil.NopCloser(bytes.NewReader(byteRange(data, offset, length))), nil
+}
+
+// byteRange returns length bytes of b beginning at offset, or fewer if b ends first.
+func byteRange(b []byte, offset uint64, length uint64) []byte {
+	if offset > uint64(len(b)) {
+		return nil
+	}
+	b = b[offset:]
+	if length < uint64(len(b)) {
+		b = b[:length]
+	}
+	return b
+}
+
+// rangeStore records the ranges read from a store with GetRange.
+type rangeStore struct {
+	store.Store
+	ranges []store.Range
+}
+
+func (s *rangeStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	s.ranges = append(s.ranges, store.Range{From: offset, To: offset + length - 1})
+	return s.Store.GetRange(ctx, bucket, key, offset, length)
+}
+
+// slowStore delays each GetRange, and records the largest number of concurrent calls.
+type slowStore struct {
+	store.Store
+	delay time.Duration
+
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+}
+
+func (s *slowStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.active++
+	if s.active > s.maxSeen {
+		s.maxSeen = s.active
+	}
+	s.mu.Unlock()
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	return s.Store.GetRange(ctx, bucket, key, offset, length)
+}
+
+// blockingStore is a store whose Put blocks for packfiles until release is closed.
+type blockingStore struct {
+	store.Store
+	release chan struct{}
+}
+
+func (s *blockingStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	if strings.HasSuffix(key, ".pack") {
+		<-s.release
+	}
+	return s.Store.Put(ctx, bucket, key, r)
+}
+
+// statStore is a mockStore which implements store.Stater. The ETag of an object is
+// its MD5 hash, as for a single part S3 upload. If truncate is set, Put saves all but
+// the last byte of an object.
+type statStore struct {
+	*mockStore
+	truncate bool
+}
+
+func (s *statStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.truncate && len(data) > 0 {
+		data = data[:len(data)-1]
+	}
+	return s.mockStore.Put(ctx, bucket, key, bytes.NewReader(data))
+}
+
+func (s *statStore) Stat(ctx context.Context, bucket string, key string) (store.ObjectInfo, error) {
+	data, ok := s.data[bucket][key]
+	if !ok {
+		return store.ObjectInfo{}, store.ErrNotFound
+	}
+	h := md5.Sum(data)
+	return store.ObjectInfo{Size: uint64(len(data)), ETag: hex.EncodeToString(h[:])}, nil
+}
+
+// failPutStore is a mockStore whose Put method fails after reading part of the object.
+type failPutStore struct {
+	*mockStore
+}
+
+func (s *failPutStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	r.Read(make([]byte, 8))
+	return errors.New("store unavailable")
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NamingRules configures how the server normalizes and validates file names.
+type NamingRules struct {
+	// MaxLength is the maximum length of a name in bytes. Defaults to, and may not
+	// exceed, 1024.
+	MaxLength int
+
+	// Pattern, if set, is a regular expression which new file names must match.
+	Pattern *regexp.Regexp
+
+	// NFC, if true, converts names to Unicode normalization form C, so names which
+	// differ only in their encoding refer to the same file.
+	NFC bool
+}
+
+// NormalizeName cleans a file name, or prefix, provided by a client and applies the
+// server's normalization rules. The result is suitable for looking up files.
+func (srv *Server) NormalizeName(name string) string {
+	name = cleanFilename(name)
+	if srv.cfg.Naming.NFC {
+		name = norm.NFC.String(name)
+	}
+	return name
+}
+
+// newFilename normalizes the name of a file being created and checks that it is
+// valid. Names containing a ".." element are rejected, instead of being resolved, so
+// a client never writes to a different directory than the one it named.
+func (srv *Server) newFilename(name string) (string, error) {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", errors.New(`filename cannot contain ".." elements`)
+		}
+	}
+	name = srv.NormalizeName(name)
+	if err := validateFilename(name, srv.cfg.Naming); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// validateFilename returns an error if the file name is invalid.
+func validateFilename(name string, rules NamingRules) error {
+	maxLength := maxFilenameSize
+	if rules.MaxLength > 0 && rules.MaxLength < maxFilenameSize {
+		maxLength = rules.MaxLength
+	}
+	if len(name) > maxLength {
+		return fmt.Errorf("filename exceeds maximum size %d", maxLength)
+	}
+	if name == "" || name == "/" {
+		return errors.New("invalid filename")
+	}
+	if !utf8.ValidString(name) {
+		return errors.New("filename is not valid UTF-8")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("filename cannot contain control character %U", r)
+		}
+	}
+	if rules.Pattern != nil && !rules.Pattern.MatchString(name) {
+		return fmt.Errorf("filename does not match pattern %s", rules.Pattern)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/store"
+)
+
+// opLogSegmentSize is the number of operation log entries in each segment uploaded to
+// the store. Segment n holds the entries with sequence numbers n*opLogSegmentSize+1 to
+// (n+1)*opLogSegmentSize, so the segment holding an entry can be found without
+// listing the bucket.
+const opLogSegmentSize = 1000
+
+// opLogSegmentKey returns the store key of the segment holding the entry with sequence
+// number seq.
+func opLogSegmentKey(seq int64) string {
+	first := (seq-1)/opLogSegmentSize*opLogSegmentSize + 1
+	return fmt.Sprintf("oplog/%020d.jsonl.gz", first)
+}
+
+// segmentEnd returns the sequence number of the last entry in the segment holding the
+// entry with sequence number seq.
+func segmentEnd(seq int64) int64 {
+	return ((seq-1)/opLogSegmentSize + 1) * opLogSegmentSize
+}
+
+// ShipOpLog uploads the entries in the operation log which have not yet been uploaded
+// to the store. The current, incomplete segment is rewritten each time it changes.
+// Entries are removed from the database once the segment holding them is complete and
+// uploaded.
+func (srv *Server) ShipOpLog(ctx context.Context) error {
+	srv.oplogMu.Lock()
+	defer srv.oplogMu.Unlock()
+
+	var after int64
+	for {
+		entries, err := srv.db.ReadOpLog(after, opLogSegmentSize)
+		if err != nil {
+			return fmt.Errorf("reading operation log: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		end := segmentEnd(entries[0].Seq)
+		for i, e := range entries {
+			if e.Seq > end {
+				entries = entries[:i]
+				break
+			}
+		}
+		last := entries[len(entries)-1].Seq
+
+		if last > srv.oplogShipped {
+			if err := srv.putOpLogSegment(ctx, entries); err != nil {
+				return err
+			}
+			srv.oplogShipped = last
+		}
+		if last < end {
+			return nil
+		}
+		if err := srv.db.PruneOpLog(end); err != nil {
+			return fmt.Errorf("pruning operation log: %w", err)
+		}
+		after = last
+	}
+}
+
+func (srv *Server) putOpLogSegment(ctx context.Context, entries []db.OpLogEntry) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encoding operation %d: %w", e.Seq, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	key := opLogSegmentKey(entries[0].Seq)
+	if err := srv.store.Put(ctx, srv.cfg.Bucket, key, bytes.NewReader(buf.Bytes())); err != nil {
+		return fmt.Errorf("uploading operation log segment %s: %w", key, err)
+	}
+	return nil
+}
+
+// ReplayOpLog applies the entries uploaded to the store by a primary server which are
+// newer than the last entry applied to the database. It is run by a standby server,
+// whose database must start as a copy of the primary's database made after the primary
+// enabled its operation log. Returns the number of entries applied.
+func (srv *Server) ReplayOpLog(ctx context.Context) (int, error) {
+	srv.oplogMu.Lock()
+	defer srv.oplogMu.Unlock()
+
+	applied, err := srv.db.LastOpSeq()
+	if err != nil {
+		return 0, fmt.Errorf("getting last applied operation: %w", err)
+	}
+	n := 0
+	defer func() {
+		if n > 0 {
+			srv.cache.invalidatePrefix("")
+			srv.locations.clear()
+		}
+	}()
+	for {
+		next := applied + 1
+		entries, err := srv.getOpLogSegment(ctx, next)
+		if errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		for _, e := range entries {
+			if e.Seq <= applied {
+				continue
+			}
+			if err := srv.db.ApplyOp(e); err != nil {
+				return n, err
+			}
+			applied = e.Seq
+			n++
+		}
+		if applied != segmentEnd(next) {
+			// The segment is incomplete. Wait for the primary to upload more entries.
+			break
+		}
+	}
+
+	// Keep the entries of the incomplete segment, so this server can upload the whole
+	// segment if it takes over from the primary
+	if complete := segmentEnd(applied+1) - opLogSegmentSize; complete > 0 {
+		if err := srv.db.PruneOpLog(complete); err != nil {
+			return n, fmt.Errorf("pruning operation log: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// getOpLogSegment returns the entries of the segment holding the entry with sequence
+// number seq. Returns store.ErrNotFound if the segment has not been uploaded.
+func (srv *Server) getOpLogSegment(ctx context.Context, seq int64) ([]db.OpLogEntry, error) {
+	key := opLogSegmentKey(seq)
+	r, err := srv.store.Get(ctx, srv.cfg.Bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("reading operation log segment %s: %w", key, err)
+	}
+	var entries []db.OpLogEntry
+	dec := json.NewDecoder(zr)
+	for dec.More() {
+		var e db.OpLogEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("decoding operation log segment %s: %w", key, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/jotfs/jotfs/internal/compress"
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// packIndexMagic begins every packfile index cache file.
+var packIndexMagic = []byte("JOTIDX01")
+
+// packIndexRecordSize is the size of each block record in a packfile index cache file:
+// the chunk sum, followed by the chunk size, block sequence, offset and size as
+// little-endian uint64s, and the compression mode.
+const packIndexRecordSize = sum.Size + 4*8 + 1
+
+// maxMappedIndexes is the maximum number of packfile indexes a packIndexCache keeps
+// mapped into memory.
+const maxMappedIndexes = 4096
+
+// packIndexCache keeps a compact index of the blocks in each packfile in a local
+// directory, so the location of a chunk within its packfile can be found without
+// querying the database. Each index is a file of fixed-size records sorted by chunk
+// sum, which is memory-mapped when first used. Indexes are written when a packfile is
+// uploaded, or built from the database when first needed. A packfile's blocks never
+// change, so an index never needs to be updated, and the directory may be cleared at
+// any time while the server is stopped.
+type packIndexCache struct {
+	dir string
+	db  *db.Adapter
+
+	mu     sync.Mutex
+	mapped map[sum.Sum][]byte
+}
+
+// newPackIndexCache returns a cache which keeps its indexes in dir, or nil if dir is
+// empty. A nil cache is valid and finds no chunks.
+func newPackIndexCache(dir string, adapter *db.Adapter) *packIndexCache {
+	if dir == "" {
+		return nil
+	}
+	return &packIndexCache{dir: dir, db: adapter, mapped: make(map[sum.Sum][]byte)}
+}
+
+func (c *packIndexCache) path(packSum sum.Sum) string {
+	return filepath.Join(c.dir, packSum.AsHex()+".idx")
+}
+
+// add writes the index of a packfile to the cache directory.
+func (c *packIndexCache) add(index object.PackIndex) error {
+	if c == nil {
+		return nil
+	}
+	return c.write(index.Sum, index.Blocks)
+}
+
+func (c *packIndexCache) write(packSum sum.Sum, blocks []object.BlockInfo) error {
+	sorted := make([]object.BlockInfo, len(blocks))
+	copy(sorted, blocks)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Sum[:], sorted[j].Sum[:]) < 0
+	})
+	b := make([]byte, len(packIndexMagic), len(packIndexMagic)+len(sorted)*packIndexRecordSize)
+	copy(b, packIndexMagic)
+	for _, block := range sorted {
+		b = append(b, block.Sum[:]...)
+		for _, v := range []uint64{block.ChunkSize, block.Sequence, block.Offset, block.Size} {
+			var u [8]byte
+			binary.LittleEndian.PutUint64(u[:], v)
+			b = append(b, u[:]...)
+		}
+		b = append(b, block.Mode.AsUint8())
+	}
+
+	// Write to a temporary file first so a partially written index is never read
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(packSum))
+}
+
+// remove deletes the index of a packfile from the cache directory.
+func (c *packIndexCache) remove(packSum sum.Sum) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index, ok := c.mapped[packSum]; ok {
+		delete(c.mapped, packSum)
+		if err := unmapFile(index); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(c.path(packSum)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fileChunks returns the location of each chunk in refs. Returns false if the cache
+// is nil, or if any chunk is in a degraded packfile, in which case the caller should
+// get the locations from the database.
+func (c *packIndexCache) fileChunks(refs []db.ChunkRef) ([]db.ChunkIndex, bool, error) {
+	if c == nil {
+		return nil, false, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chunks := make([]db.ChunkIndex, len(refs))
+	for i, ref := range refs {
+		if ref.PackDegraded {
+			return nil, false, nil
+		}
+		index, err := c.get(ref.PackSum)
+		if err != nil {
+			return nil, false, fmt.Errorf("loading index of packfile %x: %w", ref.PackSum, err)
+		}
+		block, ok, err := lookupBlock(index, ref.Sum)
+		if err != nil {
+			return nil, false, fmt.Errorf("index of packfile %x: %w", ref.PackSum, err)
+		}
+		if !ok {
+			return nil, false, fmt.Errorf("chunk %x not in index of packfile %x", ref.Sum, ref.PackSum)
+		}
+		chunks[i] = db.ChunkIndex{Sequence: ref.Sequence, PackSum: ref.PackSum, Block: block}
+	}
+	return chunks, true, nil
+}
+
+// get returns the mapped index of a packfile, loading it from the cache directory, or
+// building it from the database, if it is not mapped. c.mu must be held.
+func (c *packIndexCache) get(packSum sum.Sum) ([]byte, error) {
+	if index, ok := c.mapped[packSum]; ok {
+		return index, nil
+	}
+	index, err := c.load(packSum)
+	if err != nil {
+		// The index is missing, or its file is invalid, so rebuild it
+		if index, err = c.build(packSum); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.mapped) >= maxMappedIndexes {
+		for s, b := range c.mapped {
+			if err := unmapFile(b); err != nil {
+				return nil, err
+			}
+			delete(c.mapped, s)
+			break
+		}
+	}
+	c.mapped[packSum] = index
+	return index, nil
+}
+
+// build writes the index of a packfile from its blocks in the database, and maps it
+// into memory.
+func (c *packIndexCache) build(packSum sum.Sum) ([]byte, error) {
+	blocks, err := c.db.GetPackBlocks(packSum)
+	if err != nil {
+		return nil, fmt.Errorf("db GetPackBlocks: %w", err)
+	}
+	if err := c.write(packSum, blocks); err != nil {
+		return nil, err
+	}
+	return c.load(packSum)
+}
+
+// load maps the index file of a packfile into memory.
+func (c *packIndexCache) load(packSum sum.Sum) ([]byte, error) {
+	f, err := os.Open(c.path(packSum))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(len(packIndexMagic)) || (size-int64(len(packIndexMagic)))%packIndexRecordSize != 0 {
+		return nil, fmt.Errorf("index file %s has invalid size %d", f.Name(), size)
+	}
+	b, err := mapFile(f, int(size))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b[:len(packIndexMagic)], packIndexMagic) {
+		unmapFile(b)
+		return nil, fmt.Errorf("index file %s has invalid header", f.Name())
+	}
+	return b, nil
+}
+
+// lookupBlock finds the block holding a chunk in a mapped index. Returns false if the
+// index has no such block.
+func lookupBlock(index []byte, s sum.Sum) (object.BlockInfo, bool, error) {
+	records := index[len(packIndexMagic):]
+	n := len(records) / packIndexRecordSize
+	i := sort.Search(n, func(i int) bool {
+		r := records[i*packIndexRecordSize:]
+		return bytes.Compare(r[:sum.Size], s[:]) >= 0
+	})
+	if i == n {
+		return object.BlockInfo{}, false, nil
+	}
+	r := records[i*packIndexRecordSize : (i+1)*packIndexRecordSize]
+	if !bytes.Equal(r[:sum.Size], s[:]) {
+		return object.BlockInfo{}, false, nil
+	}
+	u := func(j int) uint64 {
+		return binary.LittleEndian.Uint64(r[sum.Size+j*8:])
+	}
+	mode, err := compress.FromUint8(r[packIndexRecordSize-1])
+	if err != nil {
+		return object.BlockInfo{}, false, err
+	}
+	block := object.BlockInfo{Sum: s, ChunkSize: u(0), Sequence: u(1), Offset: u(2), Size: u(3), Mode: mode}
+	return block, true, nil
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// maxPackJournalSize is the size at which the pack journal is truncated once no
+// operation is in progress.
+const maxPackJournalSize = 1024 * 1024
+
+// Operations recorded in the pack journal.
+const (
+	// journalPut is recorded before a packfile is saved to the store, and resolved
+	// once its index is inserted into the database.
+	journalPut = "put"
+	// journalDelete is recorded before a packfile is deleted from the store, and
+	// resolved once its index is deleted from the database.
+	journalDelete = "delete"
+	// journalDone resolves an operation.
+	journalDone = "done"
+)
+
+// packJournal is an append-only log of the packfiles being saved to or deleted from
+// the store. A packfile is in both the store and the database, and the journal records
+// the packfiles which may be in only one of them because the server stopped between
+// the two changes. Each line of the journal is an operation followed by the hex sum
+// of a packfile. Lines are synced to disk before the operation begins.
+type packJournal struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	open map[sum.Sum]string
+}
+
+// journalEntry is an unresolved operation read from the pack journal.
+type journalEntry struct {
+	op  string
+	sum sum.Sum
+}
+
+// openPackJournal opens the journal at path, creating it if it does not exist, and
+// returns the operations which were never resolved, in the order they began.
+func openPackJournal(path string) (*packJournal, []journalEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	var order []sum.Sum
+	open := make(map[sum.Sum]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			// A line which was partially written before the server stopped. Its
+			// operation never began.
+			continue
+		}
+		s, err := sum.FromHex(fields[1])
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case journalPut, journalDelete:
+			if _, ok := open[s]; !ok {
+				order = append(order, s)
+			}
+			open[s] = fields[0]
+		case journalDone:
+			delete(open, s)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("reading pack journal: %w", err)
+	}
+	var entries []journalEntry
+	for _, s := range order {
+		if op, ok := open[s]; ok {
+			entries = append(entries, journalEntry{op, s})
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &packJournal{f: f, size: info.Size(), open: open}, entries, nil
+}
+
+// append writes a line to the journal and syncs it. j.mu must be held.
+func (j *packJournal) append(op string, s sum.Sum) error {
+	line := op + " " + s.AsHex() + "\n"
+	if _, err := j.f.WriteString(line); err != nil {
+		return fmt.Errorf("writing pack journal: %w", err)
+	}
+	j.size += int64(len(line))
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("syncing pack journal: %w", err)
+	}
+	return nil
+}
+
+// begin records that an operation on a packfile is beginning.
+func (j *packJournal) begin(op string, s sum.Sum) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(op, s); err != nil {
+		return err
+	}
+	j.open[s] = op
+	return nil
+}
+
+// done records that the operation on a packfile is resolved. The journal is truncated
+// once it's large and no operations are unresolved. If done fails, the operation is
+// resolved again at startup.
+func (j *packJournal) done(s sum.Sum) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.open[s]; !ok {
+		return nil
+	}
+	if err := j.append(journalDone, s); err != nil {
+		return err
+	}
+	delete(j.open, s)
+	if len(j.open) == 0 && j.size > maxPackJournalSize {
+		return j.truncate()
+	}
+	return nil
+}
+
+// truncate empties the journal. j.mu must be held.
+func (j *packJournal) truncate() error {
+	if err := j.f.Truncate(0); err != nil {
+		return fmt.Errorf("truncating pack journal: %w", err)
+	}
+	j.size = 0
+	return j.f.Sync()
+}
+
+// close closes the journal file.
+func (j *packJournal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// endPackOp records that the operation on a packfile is resolved, logging a failure.
+func (srv *Server) endPackOp(s sum.Sum) {
+	if err := srv.journal.done(s); err != nil {
+		srv.logger.Error().Msgf("pack journal: %v", err)
+	}
+}
+
+// Close closes the pack journal. The server should not be used after Close is called.
+func (srv *Server) Close() error {
+	return srv.journal.close()
+}
+
+// RecoverPackJournal opens the pack journal at cfg.PackJournal, if set, and resolves
+// the packfile operations which were in progress when the server last stopped. A
+// packfile being saved is removed from the store if its index is not in the database.
+// A packfile being deleted is removed from both the database and the store. Returns
+// the number of operations resolved.
+func (srv *Server) RecoverPackJournal() (int, error) {
+	if srv.cfg.PackJournal == "" {
+		return 0, nil
+	}
+	j, entries, err := openPackJournal(srv.cfg.PackJournal)
+	if err != nil {
+		return 0, fmt.Errorf("opening pack journal: %w", err)
+	}
+	for i, e := range entries {
+		var err error
+		switch e.op {
+		case journalPut:
+			err = srv.resolvePut(e.sum)
+		case journalDelete:
+			err = srv.resolveDelete(e.sum)
+		}
+		if err != nil {
+			j.close()
+			return i, fmt.Errorf("resolving %s of packfile %x: %w", e.op, e.sum, err)
+		}
+		srv.logger.Info().Msgf("pack journal: resolved %s of packfile %x", e.op, e.sum)
+	}
+	j.open = make(map[sum.Sum]string)
+	if err := j.truncate(); err != nil {
+		j.close()
+		return len(entries), err
+	}
+	srv.journal = j
+	return len(entries), nil
+}
+
+// resolvePut removes a packfile, and its index, from the store if the index is not in
+// the database, i.e. if the packfile was never accepted.
+func (srv *Server) resolvePut(s sum.Sum) error {
+	_, err := srv.db.GetPackInfo(s)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("db GetPackInfo: %w", err)
+	}
+	return srv.deletePackObjects(s)
+}
+
+// resolveDelete removes a packfile from the database and the store.
+func (srv *Server) resolveDelete(s sum.Sum) error {
+	if err := srv.deletePackObjects(s); err != nil {
+		return err
+	}
+	if err := srv.db.DeletePackIndex(s); err != nil {
+		return fmt.Errorf("db DeletePackIndex: %w", err)
+	}
+	srv.locations.removePack(s)
+	if err := srv.indexCache.remove(s); err != nil {
+		srv.logger.Error().Msgf("removing cached index of packfile %x: %v", s, err)
+	}
+	return nil
+}
+
+// deletePackObjects deletes a packfile and its index from the store. Objects which
+// don't exist are ignored.
+func (srv *Server) deletePackObjects(s sum.Sum) error {
+	for _, key := range []string{s.AsHex() + ".pack", s.AsHex() + ".index"} {
+		if err := srv.store.Delete(srv.cfg.Bucket, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("deleting %s: %w", key, err)
+		}
+	}
+	return nil
+}
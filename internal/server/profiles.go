@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/db"
+)
+
+// chunkerProfilesHeader is the header of a GetChunkerParams response which lists the
+// chunker profiles as base64 encoded JSON. It's only set if there are profiles.
+// Clients which don't read it chunk every file with the server's parameters.
+const chunkerProfilesHeader = "x-jotfs-chunker-profiles"
+
+// maxProfileChunkSize is the largest maximum chunk size of a chunker profile: four
+// times the largest average chunk size of the server.
+const maxProfileChunkSize = 256 * 1024 * 1024
+
+// ChunkerProfile is the chunking parameters clients use, in place of the server's, for
+// the files under a prefix. The profile with the longest prefix applies.
+type ChunkerProfile struct {
+	Prefix string `json:"prefix"`
+	ChunkerParams
+}
+
+// options returns the chunker options of the parameters.
+func (p ChunkerParams) options() chunker.Options {
+	return chunker.Options{
+		MinChunkSize:  int(p.MinChunkSize),
+		AvgChunkSize:  int(p.AvgChunkSize),
+		MaxChunkSize:  int(p.MaxChunkSize),
+		Normalization: int(p.Normalization),
+		Algorithm:     chunker.Algorithm(p.Algorithm),
+	}
+}
+
+// SetChunkerProfile saves the chunker profile of a prefix, replacing any profile the
+// prefix has. A zero minimum or maximum chunk size defaults to a quarter, or four
+// times, the average chunk size, and an empty algorithm to the server's algorithm.
+// Returns the saved profile, or an InvalidArgument error if its parameters are
+// invalid.
+func (srv *Server) SetChunkerProfile(p ChunkerProfile) (ChunkerProfile, error) {
+	p.Prefix = srv.NormalizeName(p.Prefix)
+	if p.Prefix == "" {
+		p.Prefix = "/"
+	}
+	if p.AvgChunkSize == 0 {
+		return ChunkerProfile{}, twirp.RequiredArgumentError("avg_chunk_size")
+	}
+	if p.MinChunkSize == 0 {
+		p.MinChunkSize = p.AvgChunkSize / 4
+	}
+	if p.MaxChunkSize == 0 {
+		p.MaxChunkSize = p.AvgChunkSize * 4
+	}
+	if p.Algorithm == "" {
+		p.Algorithm = string(srv.ChunkAlgorithm())
+	}
+	// Every chunk is identified by the server's hash function
+	p.ChunkHash = ""
+	if p.MaxChunkSize > maxProfileChunkSize {
+		msg := fmt.Sprintf("must be at most %d", maxProfileChunkSize)
+		return ChunkerProfile{}, twirp.InvalidArgumentError("max_chunk_size", msg)
+	}
+	if err := p.options().Validate(); err != nil {
+		return ChunkerProfile{}, twirp.NewError(twirp.InvalidArgument, err.Error())
+	}
+
+	err := srv.db.SetChunkerProfile(db.ChunkerProfile{
+		Prefix:        p.Prefix,
+		MinChunkSize:  uint64(p.MinChunkSize),
+		AvgChunkSize:  uint64(p.AvgChunkSize),
+		MaxChunkSize:  uint64(p.MaxChunkSize),
+		Normalization: uint64(p.Normalization),
+		Algorithm:     p.Algorithm,
+	})
+	if err != nil {
+		return ChunkerProfile{}, fmt.Errorf("db SetChunkerProfile: %w", err)
+	}
+	return p, nil
+}
+
+// ChunkerProfiles returns every chunker profile in order of prefix.
+func (srv *Server) ChunkerProfiles() ([]ChunkerProfile, error) {
+	profiles, err := srv.db.ListChunkerProfiles()
+	if err != nil {
+		return nil, fmt.Errorf("db ListChunkerProfiles: %w", err)
+	}
+	res := make([]ChunkerProfile, len(profiles))
+	for i, p := range profiles {
+		res[i] = ChunkerProfile{
+			Prefix: p.Prefix,
+			ChunkerParams: ChunkerParams{
+				MinChunkSize:  uint(p.MinChunkSize),
+				AvgChunkSize:  uint(p.AvgChunkSize),
+				MaxChunkSize:  uint(p.MaxChunkSize),
+				Normalization: uint(p.Normalization),
+				Algorithm:     p.Algorithm,
+			},
+		}
+	}
+	return res, nil
+}
+
+// DeleteChunkerProfile deletes the chunker profile of a prefix, so its files are
+// chunked with the server's parameters again. Returns a NotFound error if the prefix
+// has no profile.
+func (srv *Server) DeleteChunkerProfile(prefix string) error {
+	prefix = srv.NormalizeName(prefix)
+	if prefix == "" {
+		prefix = "/"
+	}
+	err := srv.db.DeleteChunkerProfile(prefix)
+	if errors.Is(err, db.ErrNotFound) {
+		return twirp.NotFoundError(fmt.Sprintf("chunker profile %s", prefix))
+	}
+	if err != nil {
+		return fmt.Errorf("db DeleteChunkerProfile: %w", err)
+	}
+	return nil
+}
+
+// setProfilesHeader sets the chunker profiles header of a GetChunkerParams response.
+func (srv *Server) setProfilesHeader(ctx context.Context) error {
+	profiles, err := srv.ChunkerProfiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(profiles)
+	if err != nil {
+		return fmt.Errorf("encoding chunker profiles: %w", err)
+	}
+	twirp.SetHTTPResponseHeader(ctx, chunkerProfilesHeader, base64.StdEncoding.EncodeToString(b))
+	return nil
+}
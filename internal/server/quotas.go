@@ -0,0 +1,271 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+)
+
+// defaultQuotaGrace is the grace period of a soft limit if its quota does not set one.
+const defaultQuotaGrace = 7 * 24 * time.Hour
+
+// Quota limits the total size of the file versions under a name prefix, counting every
+// version of each file. The limits mirror those of file system quotas: new versions
+// are accepted over the soft limit for a grace period, after which the soft limit is
+// enforced like the hard limit until the usage falls back under it.
+type Quota struct {
+	// Prefix is the directory the quota applies to, e.g. "/backups/alice".
+	Prefix string
+	// Soft, if set, is the usage in bytes above which new versions are accepted for
+	// the Grace period only. A warning is sent when it is first exceeded.
+	Soft  uint64
+	Grace time.Duration
+	// Hard, if set, is the usage in bytes which new versions are never accepted over.
+	Hard uint64
+}
+
+// contains returns true if the file name is under the quota's prefix.
+func (q Quota) contains(name string) bool {
+	// The root prefix "/" is cleaned to "", so it contains every file
+	return name == q.Prefix || strings.HasPrefix(name, q.Prefix+"/")
+}
+
+// QuotaRules are the quotas of name prefixes. A file version counts towards the quota
+// of every prefix containing it.
+type QuotaRules []Quota
+
+// NewQuotaRules returns the rules for a list of quotas. Each quota must set a soft or
+// hard limit, and a soft limit may not be larger than the hard limit. The grace period
+// of a soft limit defaults to 7 days.
+func NewQuotaRules(quotas []Quota) (QuotaRules, error) {
+	if len(quotas) == 0 {
+		return nil, nil
+	}
+	rules := make(QuotaRules, len(quotas))
+	seen := make(map[string]bool)
+	for i, q := range quotas {
+		name := q.Prefix
+		q.Prefix = cleanFilename(q.Prefix)
+		if seen[q.Prefix] {
+			return nil, fmt.Errorf("prefix %s has more than one quota", name)
+		}
+		seen[q.Prefix] = true
+		if q.Soft == 0 && q.Hard == 0 {
+			return nil, fmt.Errorf("quota of prefix %s has no soft or hard limit", name)
+		}
+		if q.Hard > 0 && q.Soft > q.Hard {
+			return nil, fmt.Errorf("soft limit of prefix %s is larger than its hard limit", name)
+		}
+		if q.Soft > 0 && q.Grace <= 0 {
+			q.Grace = defaultQuotaGrace
+		}
+		rules[i] = q
+	}
+	return rules, nil
+}
+
+// QuotaWarning is sent when the usage of a prefix first exceeds its soft limit.
+type QuotaWarning struct {
+	Quota
+	// Usage is the size of the file versions under the prefix, including the new
+	// versions which exceeded the limit, and GraceEnds the time after which new
+	// versions are rejected unless the usage falls back under the soft limit.
+	Usage     uint64
+	GraceEnds time.Time
+}
+
+// QuotaWarner is told when the usage of a prefix exceeds its soft limit.
+type QuotaWarner interface {
+	WarnQuota(w QuotaWarning)
+}
+
+// SetQuotaWarner sets the warner told when a prefix exceeds its soft limit.
+func (srv *Server) SetQuotaWarner(w QuotaWarner) {
+	srv.quotaWarner = w
+}
+
+// QuotaUsage is the usage of a prefix with a quota.
+type QuotaUsage struct {
+	Quota
+	Usage uint64
+	// GraceStarted is the time the soft limit was first exceeded, and is zero if the
+	// usage is under it.
+	GraceStarted time.Time
+}
+
+// QuotaUsages returns the usage of each prefix with a quota.
+func (srv *Server) QuotaUsages() ([]QuotaUsage, error) {
+	usages := make([]QuotaUsage, len(srv.cfg.Quotas))
+	for i, q := range srv.cfg.Quotas {
+		usage, err := srv.quotaUsage(q)
+		if err != nil {
+			return nil, err
+		}
+		usages[i] = QuotaUsage{Quota: q, Usage: usage}
+		if q.Soft == 0 || usage <= q.Soft {
+			continue
+		}
+		start, err := srv.db.GetQuotaGrace(q.Prefix + "/")
+		if err == nil {
+			usages[i].GraceStarted = start
+		} else if !errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("db GetQuotaGrace: %w", err)
+		}
+	}
+	return usages, nil
+}
+
+// quotaUsage returns the total size of the file versions under the quota's prefix.
+func (srv *Server) quotaUsage(q Quota) (uint64, error) {
+	stats, err := srv.db.GetPrefixStats(q.Prefix + "/")
+	if err != nil {
+		return 0, fmt.Errorf("db GetPrefixStats: %w", err)
+	}
+	return stats.TotalFilesSize, nil
+}
+
+// quotaChange is the change in usage of a file made by a new version: the version's
+// size, less the size of the version it replaces if that version is deleted.
+type quotaChange struct {
+	name     string
+	added    uint64
+	replaced uint64
+}
+
+// newQuotaChange returns the change in usage of a file when f replaces the latest
+// version, if the file has one. The latest version is deleted if neither it nor the
+// server is versioned.
+func (srv *Server) newQuotaChange(f object.File, latest db.FileInfo, hasLatest bool) quotaChange {
+	c := quotaChange{name: f.Name, added: f.Size()}
+	if hasLatest && !latest.Versioned && !srv.cfg.VersioningEnabled {
+		c.replaced = latest.Size
+	}
+	return c
+}
+
+// checkQuotas returns a ResourceExhausted error if the changes would take the usage
+// of a prefix over its hard limit, or over its soft limit once its grace period has
+// passed. Changes which don't increase the usage of a prefix are always accepted. The
+// grace period of a soft limit starts, and a warning is sent, the first time it is
+// exceeded, and ends when a check finds the usage back under it.
+func (srv *Server) checkQuotas(changes ...quotaChange) error {
+	for _, q := range srv.cfg.Quotas {
+		var added, replaced uint64
+		for _, c := range changes {
+			if q.contains(c.name) {
+				added += c.added
+				replaced += c.replaced
+			}
+		}
+		if added == 0 && replaced == 0 {
+			continue
+		}
+		usage, err := srv.quotaUsage(q)
+		if err != nil {
+			return err
+		}
+		if err := srv.checkQuota(q, usage, added, replaced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *Server) checkQuota(q Quota, usage uint64, added uint64, replaced uint64) error {
+	after := usage + added
+	if replaced > after {
+		after = 0
+	} else {
+		after -= replaced
+	}
+	grows := after > usage
+	dir := q.Prefix + "/"
+	if grows && q.Hard > 0 && after > q.Hard {
+		return quotaError(dir, "usage of %d bytes would exceed the hard limit of %d bytes", usage, q.Hard)
+	}
+	if q.Soft == 0 {
+		return nil
+	}
+	if after <= q.Soft {
+		// Deletes don't check quotas, so the usage may have fallen back under the
+		// limit since the last check
+		_, err := srv.db.GetQuotaGrace(dir)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("db GetQuotaGrace: %w", err)
+		}
+		if err := srv.db.EndQuotaGrace(dir); err != nil {
+			return fmt.Errorf("db EndQuotaGrace: %w", err)
+		}
+		return nil
+	}
+	if !grows {
+		return nil
+	}
+	now := time.Now()
+	start, started, err := srv.db.StartQuotaGrace(dir, now)
+	if err != nil {
+		return fmt.Errorf("db StartQuotaGrace: %w", err)
+	}
+	ends := start.Add(q.Grace)
+	if started {
+		srv.logger.Warn().Msgf("quota of %s: usage of %d bytes exceeds the soft limit of %d bytes. Uploads are rejected after %s", dir, after, q.Soft, ends.Format(time.RFC3339))
+		if srv.quotaWarner != nil {
+			srv.quotaWarner.WarnQuota(QuotaWarning{Quota: q, Usage: after, GraceEnds: ends})
+		}
+	}
+	if now.After(ends) {
+		return quotaError(dir, "usage of %d bytes has been over the soft limit of %d bytes since %s", usage, q.Soft, start.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkPackQuota returns a ResourceExhausted error if a prefix containing the file
+// name is full: its usage is at its hard limit, or over its soft limit after the grace
+// period. A packfile's size does not count towards quotas, since its chunks may be
+// deduplicated and the version it's uploaded for may replace a larger one, so a
+// packfile is only rejected when no new version could be accepted.
+func (srv *Server) checkPackQuota(name string) error {
+	name = cleanFilename(name)
+	for _, q := range srv.cfg.Quotas {
+		if !q.contains(name) {
+			continue
+		}
+		usage, err := srv.quotaUsage(q)
+		if err != nil {
+			return err
+		}
+		dir := q.Prefix + "/"
+		if q.Hard > 0 && usage >= q.Hard {
+			return quotaError(dir, "usage of %d bytes is at the hard limit of %d bytes", usage, q.Hard)
+		}
+		if q.Soft == 0 || usage <= q.Soft {
+			continue
+		}
+		start, err := srv.db.GetQuotaGrace(dir)
+		if errors.Is(err, db.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("db GetQuotaGrace: %w", err)
+		}
+		if time.Now().After(start.Add(q.Grace)) {
+			return quotaError(dir, "usage of %d bytes has been over the soft limit of %d bytes since %s", usage, q.Soft, start.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// quotaError returns the error of an upload rejected by the quota of the directory
+// dir. The directory is set in the error's "quota" metadata, so clients can tell it
+// from a transient ResourceExhausted error.
+func quotaError(dir string, format string, a ...interface{}) error {
+	msg := fmt.Sprintf("quota of %s exceeded: ", dir) + fmt.Sprintf(format, a...)
+	return twirp.NewError(twirp.ResourceExhausted, msg).WithMeta("quota", dir)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/jotfs/jotfs/internal/db"
+)
+
+// maxReadAheadRange is the largest size of the blocks read with a single ranged request
+// when reading ahead, which limits the memory held by the ranges read ahead of the one
+// being written.
+const maxReadAheadRange = 8 * 1024 * 1024
+
+// readAheadResult is the decoded data of a sequence of chunks read ahead.
+type readAheadResult struct {
+	data bytes.Buffer
+	err  error
+}
+
+// writeChunksAhead writes the data of each chunk to w, in order, as writeChunks does.
+// The chunks are split into ranges, and up to srv.cfg.ReadAhead ranges following the
+// one being written are read concurrently, so the latency of the store overlaps with
+// writing to w.
+func (srv *Server) writeChunksAhead(ctx context.Context, indices []db.ChunkIndex, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ranges := srv.readAheadRanges(indices)
+	results := make([]chan *readAheadResult, len(ranges))
+	for i := range results {
+		results[i] = make(chan *readAheadResult, 1)
+	}
+
+	// slots limits the ranges held in memory to the one being written and those read
+	// ahead of it
+	slots := make(chan struct{}, srv.cfg.ReadAhead+1)
+	go func() {
+		for i, r := range ranges {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(r []db.ChunkIndex, res chan<- *readAheadResult) {
+				var result readAheadResult
+				if len(r) == 1 {
+					if data, ok := srv.chunkCache.get(r[0].Block.Sum); ok {
+						result.data.Write(data)
+						res <- &result
+						return
+					}
+				}
+				result.err = srv.writeRange(ctx, r, &result.data)
+				res <- &result
+			}(r, results[i])
+		}
+	}()
+
+	for _, res := range results {
+		var result *readAheadResult
+		select {
+		case result = <-res:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if result.err != nil {
+			return result.err
+		}
+		if _, err := result.data.WriteTo(w); err != nil {
+			return err
+		}
+		<-slots
+	}
+	return nil
+}
+
+// readAheadRanges splits chunks into the sequences read by writeChunksAhead: a single
+// chunk in the chunk cache, or consecutive chunks in the same packfile read with a
+// single ranged request, which spans at most maxReadAheadRange bytes unless it holds
+// a single chunk.
+func (srv *Server) readAheadRanges(indices []db.ChunkIndex) [][]db.ChunkIndex {
+	var ranges [][]db.ChunkIndex
+	for i := 0; i < len(indices); {
+		if srv.chunkCache.contains(indices[i].Block.Sum) {
+			ranges = append(ranges, indices[i:i+1])
+			i++
+			continue
+		}
+		from := indices[i].Block.Offset
+		j := i + 1
+		for j < len(indices) && inSameRange(indices[j-1], indices[j]) && !srv.chunkCache.contains(indices[j].Block.Sum) {
+			if end := indices[j].Block.Offset + indices[j].Block.Size; end-from > maxReadAheadRange {
+				break
+			}
+			j++
+		}
+		ranges = append(ranges, indices[i:j])
+		i = j
+	}
+	return ranges
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/compress"
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// RechunkResult summarizes a rechunk.
+type RechunkResult struct {
+	// Checked is the number of file versions read, and Rechunked the number whose
+	// chunks were replaced. Skipped is the number deleted while they were rechunked.
+	Checked   int
+	Rechunked int
+	Skipped   int
+	// Chunks is the number of new chunks saved, in Packs packfiles, and Bytes the
+	// size of their data before compression.
+	Chunks uint64
+	Packs  int
+	Bytes  uint64
+}
+
+// rechunkedVersion is a file version whose new chunks are waiting for their packfile
+// to be saved.
+type rechunkedVersion struct {
+	old  sum.Sum
+	file object.File
+}
+
+// serverPack is a packfile of chunks saved by the server itself, rather than uploaded
+// by a client, built in a temporary file.
+type serverPack struct {
+	f       *os.File
+	builder *object.PackfileBuilder
+	sums    map[sum.Sum]bool
+}
+
+// rechunkPack is a packfile of the new chunks of a rechunk.
+type rechunkPack struct {
+	serverPack
+	// versions are the file versions with new chunks in the packfile, or in an
+	// earlier packfile, which are replaced once the packfile is saved
+	versions []rechunkedVersion
+}
+
+// Rechunk splits the data of every file version under a prefix into chunks again,
+// with the chunking parameters which now apply to the version's name: those of the
+// chunker profile with the longest prefix of the name, or the server's parameters if
+// no profile applies. Chunks which don't exist yet are saved in new packfiles, and
+// then the chunks of each version are replaced in a single transaction, so a version
+// is never missing data. A version keeps its ID, metadata, tags and snapshots, but its
+// sum changes. The chunks no longer referenced are reclaimed by the next vacuum.
+// Versions whose chunks are unchanged are left as they are. Every file is rechunked if
+// the prefix is "/".
+func (srv *Server) Rechunk(ctx context.Context, prefix string) (RechunkResult, error) {
+	if !srv.beginTask() {
+		return RechunkResult{}, twirp.NewError(twirp.Unavailable, "server is shutting down")
+	}
+	defer srv.tasks.Done()
+	if !atomic.CompareAndSwapInt32(&srv.isVacuuming, stateNotVacuuming, stateRechunking) {
+		return RechunkResult{}, twirp.NewError(twirp.Unavailable, "vacuum, scrub or rechunk already in progress")
+	}
+	defer atomic.StoreInt32(&srv.isVacuuming, stateNotVacuuming)
+
+	// Match whole directory names only, so /data does not include /database
+	dir := srv.NormalizeName(prefix) + "/"
+	profiles, err := srv.ChunkerProfiles()
+	if err != nil {
+		return RechunkResult{}, err
+	}
+	sums, err := srv.db.ListPrefixVersionSums(dir)
+	if err != nil {
+		return RechunkResult{}, fmt.Errorf("db ListPrefixVersionSums: %w", err)
+	}
+
+	srv.logger.Info().Str("prefix", dir).Int("versions", len(sums)).Msg("Rechunk initiated")
+	start := time.Now()
+	var res RechunkResult
+	var pack rechunkPack
+	defer pack.discard(srv)
+	for _, s := range sums {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		file, err := srv.db.GetFile(s)
+		if errors.Is(err, db.ErrNotFound) {
+			res.Skipped++
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("db GetFile: %w", err)
+		}
+		res.Checked++
+		if file.Data != nil {
+			// A file stored inline has no chunks in packfiles to rechunk
+			continue
+		}
+		chunks, err := srv.rechunkFile(ctx, s, srv.fileChunkerOptions(profiles, file.Name), &pack, &res)
+		if err != nil {
+			return res, fmt.Errorf("rechunking %s: %w", file.Name, err)
+		}
+		if chunksEqual(chunks, file.Chunks) {
+			continue
+		}
+		file.Chunks = chunks
+		pack.versions = append(pack.versions, rechunkedVersion{old: s, file: file})
+	}
+	if err := srv.saveRechunkPack(ctx, &pack, &res); err != nil {
+		return res, err
+	}
+
+	srv.logger.Info().
+		Int64("elapsed", time.Since(start).Milliseconds()).
+		Int("rechunked", res.Rechunked).
+		Msg("Rechunk complete")
+	return res, nil
+}
+
+// fileChunkerOptions returns the options to chunk a file named name with: those of the
+// chunker profile with the longest prefix of the name, or the server's parameters if
+// no profile applies.
+func (srv *Server) fileChunkerOptions(profiles []ChunkerProfile, name string) chunker.Options {
+	var match *ChunkerProfile
+	for i, p := range profiles {
+		in := p.Prefix == "/" || name == p.Prefix || strings.HasPrefix(name, p.Prefix+"/")
+		if in && (match == nil || len(p.Prefix) > len(match.Prefix)) {
+			match = &profiles[i]
+		}
+	}
+	if match == nil {
+		return srv.cfg.Params.options()
+	}
+	return match.options()
+}
+
+// rechunkFile reads the data of a file version and splits it into chunks with opts.
+// Chunks which don't exist are added to the packfile, which is saved once it reaches
+// the packfile size. Returns the new chunks.
+func (srv *Server) rechunkFile(ctx context.Context, s sum.Sum, opts chunker.Options, pack *rechunkPack, res *RechunkResult) ([]object.Chunk, error) {
+	indices, err := srv.getFileChunks(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(srv.writeChunks(ctx, indices, w))
+	}()
+	defer r.Close()
+
+	c, err := chunker.New(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]object.Chunk, 0)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading file data: %w", err)
+		}
+		cs := srv.ChunkHash().Compute(chunk.Data)
+		chunks = append(chunks, object.Chunk{Sequence: uint64(len(chunks)), Size: uint64(len(chunk.Data)), Sum: cs})
+		if pack.sums[cs] {
+			continue
+		}
+		exists, err := srv.db.ChunksExist([]sum.Sum{cs})
+		if err != nil {
+			return nil, fmt.Errorf("db ChunksExist: %w", err)
+		}
+		if exists[0] {
+			continue
+		}
+		if err := pack.append(chunk.Data, cs); err != nil {
+			return nil, fmt.Errorf("writing packfile: %w", err)
+		}
+		res.Chunks++
+		res.Bytes += uint64(len(chunk.Data))
+		if pack.builder.BytesWritten() >= srv.packfileSize() {
+			if err := srv.saveRechunkPack(ctx, pack, res); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return chunks, nil
+}
+
+// append adds a chunk to the packfile, creating the packfile if it's empty.
+func (p *serverPack) append(data []byte, s sum.Sum) error {
+	if p.builder == nil {
+		f, err := ioutil.TempFile("", "jotfs-")
+		if err != nil {
+			return err
+		}
+		builder, err := object.NewPackfileBuilder(f)
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		p.f, p.builder, p.sums = f, builder, make(map[sum.Sum]bool)
+	}
+	if err := p.builder.Append(data, s, compress.Zstd); err != nil {
+		return err
+	}
+	p.sums[s] = true
+	return nil
+}
+
+// discard removes the packfile's temporary file, if it has one.
+func (p *serverPack) discard(srv *Server) {
+	if p.f == nil {
+		return
+	}
+	p.f.Close()
+	if err := os.Remove(p.f.Name()); err != nil {
+		srv.logger.Error().Msgf("removing temporary packfile: %v", err)
+	}
+	p.f, p.builder, p.sums = nil, nil, nil
+}
+
+// saveServerPack saves the packfile, if it has any chunks, to the store and database,
+// and removes its temporary file. Returns false if the packfile is empty.
+func (srv *Server) saveServerPack(ctx context.Context, pack *serverPack) (bool, error) {
+	if pack.builder == nil {
+		return false, nil
+	}
+	defer pack.discard(srv)
+	if err := pack.f.Close(); err != nil {
+		return false, fmt.Errorf("writing packfile: %w", err)
+	}
+	if err := srv.savePackfile(ctx, pack.builder.Build(), pack.f.Name()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// saveRechunkPack saves the packfile, if it has any chunks, to the store and database,
+// and then replaces the chunks of the file versions waiting for it.
+func (srv *Server) saveRechunkPack(ctx context.Context, pack *rechunkPack, res *RechunkResult) error {
+	saved, err := srv.saveServerPack(ctx, &pack.serverPack)
+	if err != nil {
+		return err
+	}
+	if saved {
+		res.Packs++
+	}
+	for _, v := range pack.versions {
+		replaced, err := srv.replaceFileVersion(ctx, v.old, v.file)
+		if err != nil {
+			return fmt.Errorf("replacing chunks of %s: %w", v.file.Name, err)
+		}
+		if replaced {
+			res.Rechunked++
+		} else {
+			res.Skipped++
+		}
+	}
+	pack.versions = nil
+	return nil
+}
+
+// savePackfile saves a packfile built by the server in a local file to the store, and
+// inserts its index into the database.
+func (srv *Server) savePackfile(ctx context.Context, index object.PackIndex, name string) error {
+	bucket := srv.cfg.Bucket
+	if err := srv.journal.begin(journalPut, index.Sum); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if err := srv.resolvePut(index.Sum); err != nil {
+				srv.logger.Error().Msgf("removing packfile %x: %v", index.Sum, err)
+				return
+			}
+		}
+		srv.endPackOp(index.Sum)
+	}()
+
+	ikey := index.Sum.AsHex() + ".index"
+	if err := srv.store.Put(ctx, bucket, ikey, bytes.NewReader(index.MarshalBinary())); err != nil {
+		return fmt.Errorf("saving %s to store: %w", ikey, err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return mergeErrors(err, srv.store.Delete(bucket, ikey))
+	}
+	defer f.Close()
+	pkey := index.Sum.AsHex() + ".pack"
+	if err := srv.store.Put(ctx, bucket, pkey, f); err != nil {
+		err = fmt.Errorf("saving %s to store: %w", pkey, err)
+		return mergeErrors(err, srv.store.Delete(bucket, ikey))
+	}
+	etag, err := srv.verifyPackfile(ctx, pkey, index.Size)
+	if err != nil {
+		err = mergeErrors(err, srv.store.Delete(bucket, ikey))
+		return mergeErrors(err, srv.store.Delete(bucket, pkey))
+	}
+
+	if err := srv.db.InsertPackIndex(index, time.Now().UTC()); err != nil {
+		err = fmt.Errorf("db InsertPackIndex: %w", err)
+		err = mergeErrors(err, srv.store.Delete(bucket, ikey))
+		return mergeErrors(err, srv.store.Delete(bucket, pkey))
+	}
+	committed = true
+	srv.savePackETag(index.Sum, etag)
+	srv.cache.invalidate("")
+	if err := srv.indexCache.add(index); err != nil {
+		srv.logger.Error().Msgf("caching index of packfile %x: %v", index.Sum, err)
+	}
+	return nil
+}
+
+// replaceFileVersion saves the file object of a rechunked file version to the store
+// and replaces the chunks of the version old in the database. The old file object is
+// then deleted. Returns false if the version has been deleted.
+func (srv *Server) replaceFileVersion(ctx context.Context, old sum.Sum, file object.File) (bool, error) {
+	b := file.MarshalBinary()
+	s := sum.Compute(b)
+	fkey := s.AsHex() + ".file"
+	if err := srv.store.Put(ctx, srv.cfg.Bucket, fkey, bytes.NewReader(b)); err != nil {
+		return false, fmt.Errorf("saving %s to store: %w", fkey, err)
+	}
+	err := srv.db.ReplaceFileVersion(old, file, s)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, srv.store.Delete(srv.cfg.Bucket, fkey)
+	}
+	if err != nil {
+		err = fmt.Errorf("db ReplaceFileVersion: %w", err)
+		return false, mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, fkey))
+	}
+	srv.cache.invalidate(file.Name)
+
+	okey := old.AsHex() + ".file"
+	if err := srv.store.Delete(srv.cfg.Bucket, okey); err != nil {
+		srv.logger.Error().Msgf("rechunk: deleting %s: %v", okey, err)
+	}
+	return true, nil
+}
+
+// chunksEqual returns true if a and b are the same chunks.
+func chunksEqual(a []object.Chunk, b []object.Chunk) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
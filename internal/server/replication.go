@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+
+	"github.com/twitchtv/twirp"
+)
+
+// Replicator copies new file versions to a replica.
+type Replicator interface {
+	// Replicate returns once the file version has been acknowledged by a replica, or
+	// an error if no replica acknowledged it.
+	Replicate(ctx context.Context, id sum.Sum) error
+}
+
+// SyncPrefixes are the name prefixes of the files which are replicated synchronously:
+// CreateFile only succeeds once a replica has acknowledged the new file version. A
+// prefix contains the file of the same name and the files in the directory it names.
+type SyncPrefixes []string
+
+// NewSyncPrefixes returns the sync prefixes for a list of names.
+func NewSyncPrefixes(prefixes []string) SyncPrefixes {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	sp := make(SyncPrefixes, len(prefixes))
+	for i, prefix := range prefixes {
+		sp[i] = cleanFilename(prefix)
+	}
+	return sp
+}
+
+// contains returns true if the file name is under one of the prefixes.
+func (sp SyncPrefixes) contains(name string) bool {
+	for _, prefix := range sp {
+		// The root prefix "/" is cleaned to "", so it contains every file
+		if name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReplicator sets the replicator which file versions created under the sync
+// prefixes are copied to before CreateFile returns.
+func (srv *Server) SetReplicator(r Replicator) {
+	srv.replicator = r
+}
+
+// replicate copies a file version created by CreateFile to a replica if its name is
+// under a sync prefix. The version has already been created, so the returned error is
+// Unavailable: a retry of the request with the same idempotency key returns the same
+// version, and replicates it again.
+func (srv *Server) replicate(ctx context.Context, id *pb.FileID) error {
+	name := id.Name
+	if srv.replicator == nil || !srv.cfg.SyncReplication.contains(name) {
+		return nil
+	}
+	s, err := sum.FromBytes(id.Sum)
+	if err != nil {
+		return err
+	}
+	if err := srv.replicator.Replicate(ctx, s); err != nil {
+		srv.logger.Warn().Msgf("file version %s of %s was not acknowledged by a replica: %v", s.AsHex(), name, err)
+		msg := fmt.Sprintf("file version %s was created but not acknowledged by a replica: %v. Retry the request to replicate it", s.AsHex(), err)
+		return twirp.NewError(twirp.Unavailable, msg)
+	}
+	return nil
+}
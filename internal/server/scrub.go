@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/store"
+)
+
+// ScrubResult summarizes a scrub.
+type ScrubResult struct {
+	// Checked is the number of packfiles checked.
+	Checked int
+	// Degraded is the number of packfiles marked as degraded by the scrub.
+	Degraded int
+}
+
+// Scrub checks every healthy packfile in the store against the size and ETag
+// recorded when it was uploaded. Packfiles which are missing, or have been modified
+// outside of the server, are marked as degraded. The ETag of a packfile is recorded if
+// it was not recorded on upload.
+func (srv *Server) Scrub(ctx context.Context) (ScrubResult, error) {
+	if !srv.beginTask() {
+		return ScrubResult{}, twirp.NewError(twirp.Unavailable, "server is shutting down")
+	}
+	defer srv.tasks.Done()
+	if !atomic.CompareAndSwapInt32(&srv.isVacuuming, stateNotVacuuming, stateScrubbing) {
+		return ScrubResult{}, twirp.NewError(twirp.Unavailable, "vacuum, scrub or rechunk already in progress")
+	}
+	defer atomic.StoreInt32(&srv.isVacuuming, stateNotVacuuming)
+
+	packs, err := srv.db.ListPacks()
+	if err != nil {
+		return ScrubResult{}, fmt.Errorf("db ListPacks: %w", err)
+	}
+
+	var res ScrubResult
+	for _, pack := range packs {
+		if !pack.DegradedAt.IsZero() || srv.uploads.isPending(pack.Sum) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		res.Checked++
+
+		key := pack.Sum.AsHex() + ".pack"
+		info, err := store.Stat(ctx, srv.store, srv.cfg.Bucket, key)
+		var reason string
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			reason = "packfile missing from store"
+		case err != nil:
+			return res, fmt.Errorf("getting info for %s: %w", key, err)
+		case info.Size != pack.Size:
+			reason = fmt.Sprintf("packfile size is %d but expected %d", info.Size, pack.Size)
+		case pack.ETag != "" && info.ETag != "" && info.ETag != pack.ETag:
+			reason = fmt.Sprintf("packfile ETag is %s but expected %s", info.ETag, pack.ETag)
+		}
+		if reason == "" {
+			if pack.ETag == "" {
+				srv.savePackETag(pack.Sum, info.ETag)
+			}
+			continue
+		}
+
+		if err := srv.db.MarkPackDegraded(pack.Sum, time.Now()); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return res, fmt.Errorf("db MarkPackDegraded: %w", err)
+		}
+		srv.locations.removePack(pack.Sum)
+		res.Degraded++
+		srv.logger.Error().Str("key", key).Msgf("scrub: %s. Packfile marked as degraded", reason)
+	}
+	return res, nil
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/twitchtv/twirp"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/tracing"
+)
+
+// maxSearchScan is the maximum number of file versions scanned by a Search request.
+const maxSearchScan = 100000
+
+// Search returns the file versions with names matching a pattern, in name order. By
+// default the pattern is a glob: * matches any characters except /, ? matches any
+// single character except /, ** matches any characters including /, and [...] matches
+// a character class, negated by a leading ! or ^. A **/ element matches zero or more
+// directories, and \ escapes the character following it. Patterns not beginning with
+// / are relative to the root directory. If Regex is set, the pattern is an RE2 regular
+// expression, which matches any part of a name unless it's anchored with ^ and $.
+//
+// If Metadata is set, only the file versions with all of the given metadata match. The
+// pattern may be empty to match every file version with the metadata.
+//
+// Only the files with names beginning with the literal prefix of the pattern are
+// scanned, so patterns should begin with a directory name where possible. A request
+// scans at most maxSearchScan file versions, so a response may contain fewer than
+// Limit results, or none, while more remain. The search is complete when NextCursor is
+// empty. Otherwise, it may be passed as Cursor to continue the search.
+func (srv *Server) Search(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+	if req.Pattern == "" && len(req.Metadata) == 0 {
+		return nil, twirp.RequiredArgumentError("pattern")
+	}
+	if req.Limit == 0 {
+		return nil, twirp.RequiredArgumentError("limit")
+	}
+	if req.Limit > 10000 {
+		return nil, twirp.InvalidArgumentError("limit", "max is 10000")
+	}
+	pattern := req.Pattern
+	if srv.cfg.Naming.NFC {
+		pattern = norm.NFC.String(pattern)
+	}
+	var m nameMatcher
+	var err error
+	if pattern == "" {
+		m, err = compileRegex("")
+	} else if req.Regex {
+		m, err = compileRegex(pattern)
+	} else {
+		m, err = compileGlob(pattern)
+	}
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("pattern", err.Error())
+	}
+
+	opts := db.SearchOptions{
+		Prefix:   m.prefix,
+		Glob:     m.glob,
+		Metadata: req.Metadata,
+		Match:    m.re.MatchString,
+		Limit:    req.Limit,
+		MaxScan:  maxSearchScan,
+	}
+	if req.Cursor != "" {
+		cursor, err := decodeListCursor(req.Cursor, db.SortName, true)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("cursor", err.Error())
+		}
+		opts.After = &cursor
+	}
+
+	resp, err := srv.cache.do(cacheKey("Search", req), m.prefix, func() (proto.Message, error) {
+		_, span := tracing.Start(ctx, "db.SearchFiles", label.String("prefix", m.prefix))
+		page, err := srv.db.SearchFiles(opts)
+		tracing.End(ctx, span, err)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &pb.SearchResponse{Info: pbFileInfos(page.Files)}
+		if page.Next != nil {
+			resp.NextCursor = encodeListCursor(*page.Next, db.SortName, true)
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.SearchResponse), nil
+}
+
+// nameMatcher matches file names against a search pattern.
+type nameMatcher struct {
+	// prefix is a prefix of every name matched by the pattern.
+	prefix string
+	// glob, if not empty, is a SQLite GLOB pattern matching at least every name matched
+	// by the pattern.
+	glob string
+	re   *regexp.Regexp
+}
+
+// compileGlob converts a glob pattern to a regular expression, and finds its literal
+// prefix. See Search for the pattern syntax.
+func compileGlob(pattern string) (nameMatcher, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "/" + pattern
+	}
+	var re, glob, prefix strings.Builder
+	re.WriteString("^")
+	literal := true
+	rs := []rune(pattern)
+	for i := 0; i < len(rs); i++ {
+		switch r := rs[i]; r {
+		case '*':
+			literal = false
+			glob.WriteByte('*')
+			if i+1 < len(rs) && rs[i+1] == '*' {
+				i++
+				if rs[i-2] == '/' && i+1 < len(rs) && rs[i+1] == '/' {
+					// Zero or more directories
+					i++
+					re.WriteString("(?:.*/)?")
+				} else {
+					re.WriteString(".*")
+				}
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			literal = false
+			glob.WriteByte('?')
+			re.WriteString("[^/]")
+		case '[':
+			literal = false
+			end := i + 1
+			if end < len(rs) && (rs[end] == '!' || rs[end] == '^') {
+				end++
+			}
+			if end < len(rs) && rs[end] == ']' {
+				// A ] at the start of the class is part of the class
+				end++
+			}
+			for end < len(rs) && rs[end] != ']' {
+				end++
+			}
+			if end == len(rs) {
+				return nameMatcher{}, errors.New("unterminated character class")
+			}
+			class := rs[i+1 : end]
+			negate := class[0] == '!' || class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			if len(class) == 0 {
+				return nameMatcher{}, errors.New("empty character class")
+			}
+			if negate {
+				re.WriteString("[^/")
+				glob.WriteString("[^")
+			} else {
+				re.WriteString("[")
+				glob.WriteString("[")
+			}
+			for _, c := range class {
+				if c == '-' {
+					re.WriteRune(c)
+				} else {
+					re.WriteString(regexp.QuoteMeta(string(c)))
+				}
+			}
+			re.WriteString("]")
+			glob.WriteString(string(class) + "]")
+			i = end
+		default:
+			if r == '\\' {
+				if i+1 == len(rs) {
+					return nameMatcher{}, errors.New("trailing \\ in pattern")
+				}
+				i++
+				r = rs[i]
+			}
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			if r == '*' || r == '?' || r == '[' {
+				glob.WriteString("[" + string(r) + "]")
+			} else {
+				glob.WriteRune(r)
+			}
+			if literal {
+				prefix.WriteRune(r)
+			}
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nameMatcher{}, err
+	}
+	return nameMatcher{prefix: prefix.String(), glob: glob.String(), re: compiled}, nil
+}
+
+// compileRegex compiles an RE2 regular expression. If the expression is anchored to the
+// start of the name, the literal string following the anchor is the prefix.
+func compileRegex(pattern string) (nameMatcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nameMatcher{}, err
+	}
+	m := nameMatcher{re: re}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return m, nil
+	}
+	parsed = parsed.Simplify()
+	if parsed.Op != syntax.OpConcat || parsed.Sub[0].Op != syntax.OpBeginText {
+		return m, nil
+	}
+	var prefix strings.Builder
+	for _, sub := range parsed.Sub[1:] {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		prefix.WriteString(string(sub.Rune))
+	}
+	m.prefix = prefix.String()
+	return m, nil
+}
@@ -3,33 +3,90 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/rs/zerolog"
 	"github.com/twitchtv/twirp"
+	"go.opentelemetry.io/otel/label"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/jotfs/jotfs/internal/chunker"
 	"github.com/jotfs/jotfs/internal/db"
 	"github.com/jotfs/jotfs/internal/log"
 	"github.com/jotfs/jotfs/internal/object"
 	pb "github.com/jotfs/jotfs/internal/protos"
 	"github.com/jotfs/jotfs/internal/store"
 	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/jotfs/jotfs/internal/tracing"
 )
 
 const maxFilenameSize = 1024
 
+// maxDeleteBatch is the maximum number of sums and names in a DeleteBatch request.
+const maxDeleteBatch = 1000
+
+// journalRetention is the time a CreateFile request or packfile upload with an
+// idempotency key may be retried without creating a duplicate file version or
+// packfile.
+const journalRetention = 24 * time.Hour
+
+// idempotencyKeyHeader is the header of a packfile upload's idempotency key. A
+// retry of an upload sends the same key.
+const idempotencyKeyHeader = "x-jotfs-idempotency-key"
+
+// quotaNameHeader is the optional header of a packfile upload naming the file it's
+// uploaded for, so an upload for a file under a full quota is rejected. The name is
+// percent-encoded, since file names may contain bytes not allowed in headers.
+const quotaNameHeader = "x-jotfs-name"
+
+// chunkAlgorithmHeader is the header of a GetChunkerParams response which names the
+// chunking algorithm clients should use. The ChunkerParams message predates it, and
+// clients which don't read it chunk files with FastCDC, which the server accepts.
+const chunkAlgorithmHeader = "x-jotfs-chunk-algorithm"
+
+// chunkHashHeader is the header of a GetChunkerParams response which names the hash
+// function of chunk checksums. Clients which don't read it use BLAKE3, so their
+// packfiles are rejected by a server using another hash function.
+const chunkHashHeader = "x-jotfs-chunk-hash"
+
+// maxIdempotencyKeyLength is the maximum length of a packfile upload's idempotency
+// key in bytes.
+const maxIdempotencyKeyLength = 128
+
+// maxRangeGap is the largest gap between two chunks in a packfile which are read from
+// the store with a single ranged request. Larger gaps are skipped with a new request.
+const maxRangeGap = 1024 * 1024
+
+// defaultPackfileSize is the size at which clients upload a packfile if the config
+// does not set one.
+const defaultPackfileSize = 64 * 1024 * 1024
+
+// storeRetryAfter is the Retry-After header, in seconds, sent with requests which fail
+// because the store is unavailable.
+const storeRetryAfter = "5"
+
+// A vacuum and a scrub may not run at the same time, otherwise the scrub could find
+// packfiles which the vacuum is deleting. A rechunk excludes both, so a vacuum can't
+// delete the chunks it saves before they are referenced.
 const (
 	stateNotVacuuming int32 = iota
 	stateVacuuming
+	stateScrubbing
+	stateRechunking
 )
 
 // Config stores the configuration for the Server.
@@ -46,9 +103,96 @@ type Config struct {
 	// MaxPackfileSize is the maximum permitted size of a packfile in bytes.
 	MaxPackfileSize uint64
 
+	// PackfileSize is the size in bytes at which clients should upload a packfile and
+	// start a new one. Defaults to 64 MiB.
+	PackfileSize uint64
+
+	// PackfileFlushInterval, if set, is the time after which clients should upload a
+	// packfile which is not yet full.
+	PackfileFlushInterval time.Duration
+
+	// InlineSize is the size in bytes of the largest file which may be created with
+	// its data stored inline in the database. Disabled if zero.
+	InlineSize uint64
+
 	DownloadTimeout time.Duration
 
 	Params ChunkerParams
+
+	// Naming configures the normalization and validation of file names.
+	Naming NamingRules
+
+	// CacheTTL is the time the responses of List, Head, GetFileInfo, Search and
+	// ServerStats requests are cached for. Cached responses are removed when the files
+	// they include are changed. Caching is disabled if zero.
+	CacheTTL time.Duration
+
+	// Checksums are the whole-file checksum algorithms, e.g. "md5" and "sha1",
+	// computed for each new file version in addition to SHA-256.
+	Checksums []string
+
+	// IndexCacheDir, if set, is a local directory where an index of the blocks in each
+	// packfile is kept, so downloads can locate chunks without querying the database
+	// for them.
+	IndexCacheDir string
+
+	// ChunkCacheDir, if set, is a local directory where chunks read from the store by
+	// the server are cached, up to ChunkCacheSize bytes, so files which are read often
+	// are not fetched from the store each time.
+	ChunkCacheDir  string
+	ChunkCacheSize int64
+
+	// LocationCacheSize is the number of chunk locations, i.e. the packfile and block
+	// holding each chunk, kept in memory so chunks of files which are read often are
+	// located without querying the database. Disabled if zero.
+	LocationCacheSize int
+
+	// ReadAhead is the number of chunk ranges the server reads from the store ahead of
+	// the one it's writing, when it streams a file for a share link or a checksum.
+	// Disabled if zero.
+	ReadAhead int
+
+	// UploadQueueDir, if set, is a local directory where packfile uploads are queued,
+	// so clients don't wait for the store to save each packfile. Up to
+	// UploadQueueSize packfiles are queued, and saved to the store by
+	// UploadQueueWorkers workers. StartUploadQueue must be called before the server
+	// accepts uploads.
+	UploadQueueDir     string
+	UploadQueueSize    int
+	UploadQueueWorkers int
+
+	// MinFreeSpace, if set, is the free space in bytes kept on the file systems of
+	// ChunkCacheDir and UploadQueueDir. The chunk cache removes chunks, and the upload
+	// queue rejects uploads, when the free space falls below it.
+	MinFreeSpace int64
+
+	// MaxUploads and MaxUploadBytes, if set, limit the number of packfile uploads in
+	// progress at once, and their total size. An upload over either limit is rejected
+	// with a 503 Service Unavailable status, which clients retry, so the memory used
+	// by uploads stays bounded under a load spike.
+	MaxUploads     int
+	MaxUploadBytes uint64
+
+	// PackJournal, if set, is the path of the journal of packfiles being saved to or
+	// deleted from the store. RecoverPackJournal must be called before the server
+	// accepts requests.
+	PackJournal string
+
+	// Conflicts are the strategies for resolving concurrent uploads of the same file,
+	// by name prefix. They apply to uploads which send the version of the file they
+	// began from.
+	Conflicts ConflictRules
+
+	// DefaultMetadata are the rules setting default metadata on new file versions by
+	// name prefix.
+	DefaultMetadata MetadataRules
+
+	// SyncReplication are the prefixes of the files which must be acknowledged by a
+	// replica before CreateFile succeeds. Requires a replicator to be set.
+	SyncReplication SyncPrefixes
+
+	// Quotas limit the total size of the file versions under name prefixes.
+	Quotas QuotaRules
 }
 
 // ChunkerParams store the parameters that should be used to chunk files for a server.
@@ -57,6 +201,25 @@ type ChunkerParams struct {
 	AvgChunkSize  uint `json:"avg_chunk_size"`
 	MaxChunkSize  uint `json:"max_chunk_size"`
 	Normalization uint `json:"normalization"`
+	// Algorithm is the chunking algorithm. Params saved before it was configurable
+	// don't have it, and use FastCDC.
+	Algorithm string `json:"algorithm,omitempty"`
+	// ChunkHash is the hash function of chunk checksums. Params saved before it was
+	// configurable don't have it, and use BLAKE3. It's only set for the server, since
+	// chunks under every chunker profile must deduplicate against each other.
+	ChunkHash string `json:"chunk_hash,omitempty"`
+}
+
+// ChunkHash returns the hash function of chunk checksums.
+func (srv *Server) ChunkHash() sum.Algorithm {
+	a, _ := sum.ParseAlgorithm(srv.cfg.Params.ChunkHash)
+	return a
+}
+
+// ChunkAlgorithm returns the chunking algorithm clients should use.
+func (srv *Server) ChunkAlgorithm() chunker.Algorithm {
+	a, _ := chunker.ParseAlgorithm(srv.cfg.Params.Algorithm)
+	return a
 }
 
 // Server implements the Api interface specified in upload.proto.
@@ -66,12 +229,48 @@ type Server struct {
 	cfg         Config
 	logger      zerolog.Logger
 	isVacuuming int32
+	cache       *responseCache
+	indexCache  *packIndexCache
+	chunkCache  *chunkCache
+	locations   *locationCache
+	uploads     *uploadQueue
+	journal     *packJournal
+
+	// tasks tracks in-progress packfile uploads and vacuums so they may complete
+	// before the server shuts down
+	mu      sync.Mutex
+	tasks   sync.WaitGroup
+	closing bool
+
+	// oplogMu serializes shipping and replaying of the operation log. oplogShipped is
+	// the sequence number of the last entry uploaded to the store.
+	oplogMu      sync.Mutex
+	oplogShipped int64
+
+	aborts abortedUploads
+	// admission is nil if uploads are not limited
+	admission *uploadAdmission
+
+	replicator  Replicator
+	quotaWarner QuotaWarner
 }
 
 // New creates a new Server.
 func New(db *db.Adapter, s store.Store, cfg Config) *Server {
 	logger := zerolog.New(ioutil.Discard).Level(zerolog.Disabled)
-	return &Server{db: db, cfg: cfg, store: s, logger: logger}
+	hash, _ := sum.ParseAlgorithm(cfg.Params.ChunkHash)
+	return &Server{
+		db:         db,
+		cfg:        cfg,
+		store:      s,
+		logger:     logger,
+		cache:      newResponseCache(cfg.CacheTTL),
+		indexCache: newPackIndexCache(cfg.IndexCacheDir, db),
+		chunkCache: newChunkCache(cfg.ChunkCacheDir, cfg.ChunkCacheSize, cfg.MinFreeSpace, hash),
+		locations:  newLocationCache(cfg.LocationCacheSize),
+		uploads:    newUploadQueue(cfg.UploadQueueDir, cfg.UploadQueueSize, cfg.MinFreeSpace),
+		admission:  newUploadAdmission(cfg.MaxUploads, cfg.MaxUploadBytes),
+	}
 }
 
 // SetLogger sets the logger for the server.
@@ -79,14 +278,65 @@ func (srv *Server) SetLogger(logger zerolog.Logger) {
 	srv.logger = logger
 }
 
+// beginTask registers a new background task. Returns false if the server is shutting
+// down, in which case the task must not be started. Otherwise, the caller must call
+// srv.tasks.Done when the task is complete.
+func (srv *Server) beginTask() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closing {
+		return false
+	}
+	srv.tasks.Add(1)
+	return true
+}
+
+// ShuttingDown returns true once Shutdown has been called.
+func (srv *Server) ShuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closing
+}
+
+// Shutdown stops the server from accepting new packfile uploads and vacuums, and waits
+// for any in progress to complete. Returns ctx.Err() if ctx is done before they
+// complete.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.closing = true
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // PackfileUploadHandler accepts a Packfile from a client and saves it to the store.
+// An upload naming a file under a full quota is rejected with a 507 Insufficient
+// Storage status, the counterpart of CreateFile's ResourceExhausted error, which
+// clients don't retry.
 func (srv *Server) PackfileUploadHandler(w http.ResponseWriter, req *http.Request) {
+	if !srv.beginTask() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer srv.tasks.Done()
+
 	if req.ContentLength <= 0 {
 		http.Error(w, "content-length required", http.StatusBadRequest)
 		return
 	}
 	if req.ContentLength > int64(srv.cfg.MaxPackfileSize) {
-		http.Error(w, "content-length exceeds maximum packfile size", http.StatusBadRequest)
+		msg := fmt.Sprintf("content-length exceeds maximum packfile size of %d bytes", srv.cfg.MaxPackfileSize)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
 		return
 	}
 
@@ -102,10 +352,83 @@ func (srv *Server) PackfileUploadHandler(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
+	// A retried upload which already succeeded is acknowledged without reading it
+	key := req.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		msg := fmt.Sprintf("%s may be at most %d bytes", idempotencyKeyHeader, maxIdempotencyKeyLength)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if key != "" {
+		err := srv.db.GetJournalledPack(key, sum)
+		if err == nil {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			internalError(w, fmt.Errorf("db GetJournalledPack: %w", err))
+			return
+		}
+	}
+
+	// Uploads for a file under a full quota are rejected before the packfile is read
+	if name, err := url.PathUnescape(req.Header.Get(quotaNameHeader)); err != nil {
+		msg := fmt.Sprintf("invalid %s: %v", quotaNameHeader, err)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	} else if name != "" {
+		if err := srv.checkPackQuota(name); err != nil {
+			var terr twirp.Error
+			if errors.As(err, &terr) {
+				http.Error(w, terr.Msg(), http.StatusInsufficientStorage)
+				return
+			}
+			internalError(w, err)
+			return
+		}
+	}
+
+	limits, err := srv.packLimits(req)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	if srv.admission != nil {
+		if reason := srv.admission.admit(uint64(req.ContentLength)); reason != "" {
+			w.Header().Set("Retry-After", uploadRetryAfter)
+			http.Error(w, "server busy: "+reason+". Retry later", http.StatusServiceUnavailable)
+			return
+		}
+		defer srv.admission.release(uint64(req.ContentLength))
+	}
+
+	if srv.uploads != nil {
+		srv.queuePackfile(w, req, sum, limits)
+		return
+	}
+
 	digest := sum.AsHex()
 	pkey := digest + ".pack"
 	bucket := srv.cfg.Bucket
 
+	// Until the packfile's index is in the database, the packfile is removed from the
+	// store if the upload fails, or at startup if the server stops
+	if err := srv.journal.begin(journalPut, sum); err != nil {
+		internalError(w, err)
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if err := srv.resolvePut(sum); err != nil {
+				srv.logger.Error().Msgf("removing failed upload of packfile %s: %v", digest, err)
+				return
+			}
+		}
+		srv.endPackOp(sum)
+	}()
+
 	// Launch a background goroutine to upload the packfile to the store as it's being
 	// validated down below
 	ctx, cancel := context.WithCancel(req.Context())
@@ -117,18 +440,42 @@ func (srv *Server) PackfileUploadHandler(w http.ResponseWriter, req *http.Reques
 		return mergeErrors(err, r.CloseWithError(err))
 	})
 
-	rd := io.TeeReader(io.LimitReader(req.Body, req.ContentLength), pfile)
+	rd := &uploadReader{body: io.LimitReader(req.Body, req.ContentLength), w: pfile}
+
+	// stopUpload fails the upload to the store, so the store discards the partial
+	// packfile, and waits for it to return. The context is cancelled first so the
+	// failure is not taken for a store failure.
+	stopUpload := func(err error) error {
+		cancel()
+		pfile.CloseWithError(err)
+		return g.Wait()
+	}
+	// fail ends the request with an internal server error, or records the upload as
+	// aborted if the client has disconnected
+	fail := func(err error) {
+		if req.Context().Err() != nil {
+			srv.uploadAborted(digest, req.ContentLength, rd.n, err)
+			return
+		}
+		internalError(w, err)
+	}
 
-	index, err := object.LoadPackIndex(rd)
+	index, err := object.LoadPackIndexLimited(rd, limits)
 	if err != nil {
-		// TODO: a write error will appear as a read error here because we're using a
-		// TeeReader. Need to distinguish between a malformed client packfile, and a
-		// write failure to the store which should be a http.InternalServerError.
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		perr := stopUpload(err)
+		switch {
+		case rd.readErr != nil || req.Context().Err() != nil:
+			srv.uploadAborted(digest, req.ContentLength, rd.n, err)
+		case rd.writeErr != nil:
+			internalError(w, fmt.Errorf("uploading packfile to store: %w", perr))
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
 	if index.Sum != sum {
 		msg := fmt.Sprintf("provided packfile checksum %x does not match actual checksum %x", sum, index.Sum)
+		stopUpload(errors.New(msg))
 		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
@@ -142,45 +489,174 @@ func (srv *Server) PackfileUploadHandler(w http.ResponseWriter, req *http.Reques
 	}
 
 	if err = g.Wait(); err != nil {
-		err = fmt.Errorf("uploading packfile to store: %w", err)
-		internalError(w, err)
+		fail(fmt.Errorf("uploading packfile to store: %w", err))
+		return
+	}
+	etag, err := srv.verifyPackfile(ctx, pkey, index.Size)
+	if err != nil {
+		fail(mergeErrors(err, srv.store.Delete(bucket, pkey)))
 		return
 	}
 
 	ikey := digest + ".index"
 	b := index.MarshalBinary()
 	if err = srv.store.Put(ctx, bucket, ikey, bytes.NewReader(b)); err != nil {
-		err = mergeErrors(err, srv.store.Delete(bucket, pkey))
-		internalError(w, err)
+		fail(mergeErrors(err, srv.store.Delete(bucket, pkey)))
 		return
 	}
 
 	createdAt := time.Now().UTC()
-	if err = srv.db.InsertPackIndex(index, createdAt); err != nil {
+	_, span := tracing.Start(ctx, "db.InsertPackIndex", label.Int("chunks", len(index.Blocks)))
+	err = srv.insertPackIndex(index, createdAt, key)
+	tracing.End(ctx, span, err)
+	if err != nil {
 		err = mergeErrors(err, srv.store.Delete(bucket, pkey))
 		err = mergeErrors(err, srv.store.Delete(bucket, ikey))
 		internalError(w, err)
 		return
 	}
+	committed = true
+	srv.savePackETag(index.Sum, etag)
+	srv.cache.invalidate("")
+	if err := srv.indexCache.add(index); err != nil {
+		srv.logger.Error().Msgf("caching index of packfile %x: %v", index.Sum, err)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+// insertPackIndex inserts the index of an uploaded packfile, recording it in the
+// upload journal if the upload has an idempotency key. If a concurrent retry of the
+// upload has already inserted the index, it's not inserted again.
+func (srv *Server) insertPackIndex(index object.PackIndex, createdAt time.Time, key string) error {
+	if key == "" {
+		return srv.db.InsertPackIndex(index, createdAt)
+	}
+	_, err := srv.db.InsertPackIndexOnce(index, createdAt, key)
+	return err
+}
+
+// packLimits returns the limits checked as the packfile in an upload request is read.
+// A packfile is not tied to a file, so its chunks may be as large as the largest
+// maximum chunk size of any chunker profile.
+func (srv *Server) packLimits(req *http.Request) (object.PackLimits, error) {
+	size, err := srv.db.MaxProfileChunkSize()
+	if err != nil {
+		return object.PackLimits{}, fmt.Errorf("db MaxProfileChunkSize: %w", err)
+	}
+	if size < srv.cfg.MaxChunkSize {
+		size = srv.cfg.MaxChunkSize
+	}
+	return object.PackLimits{MaxChunkSize: size, Size: uint64(req.ContentLength), ChunkHash: srv.ChunkHash()}, nil
+}
+
+// uploadAborted records a packfile upload which ended because the client
+// disconnected. No response is written since there is no client to receive it.
+func (srv *Server) uploadAborted(digest string, size int64, received int64, err error) {
+	srv.aborts.add(AbortedUpload{Sum: digest, Size: size, Received: received, Reason: err.Error(), At: time.Now().UTC()})
+	srv.logger.Warn().Msgf("packfile upload %s aborted by client after %d of %d bytes: %v", digest, received, size, err)
+}
+
+// verifyPackfile checks the store holds the complete packfile after it has been
+// uploaded, in case the store accepted a truncated body. Returns the ETag of the
+// packfile. The check is skipped, and the ETag is empty, if the store does not
+// implement store.Stater.
+func (srv *Server) verifyPackfile(ctx context.Context, key string, size uint64) (string, error) {
+	st, ok := srv.store.(store.Stater)
+	if !ok {
+		return "", nil
+	}
+	info, err := st.Stat(ctx, srv.cfg.Bucket, key)
+	if err != nil {
+		return "", fmt.Errorf("getting info for %s: %w", key, err)
+	}
+	if info.Size != size {
+		return "", fmt.Errorf("store saved %d bytes of packfile %s but expected %d", info.Size, key, size)
+	}
+	return info.ETag, nil
+}
+
+// savePackETag records the ETag of a packfile. A failure is logged but is not an
+// error because the ETag may be recorded later by a scrub.
+func (srv *Server) savePackETag(s sum.Sum, etag string) {
+	if etag == "" {
+		return
+	}
+	if err := srv.db.SetPackETag(s, etag); err != nil {
+		srv.logger.Error().Msgf("db SetPackETag %x: %v", s, err)
+	}
+}
+
 // CreateFile creates a new file. Returns an error if any chunk referenced by the file
-// does not exist.
+// does not exist, or an Aborted error if the request's IfMatch or IfNotExists
+// precondition does not hold. A file under a sync prefix is replicated before
+// CreateFile returns.
 func (srv *Server) CreateFile(ctx context.Context, file *pb.File) (*pb.FileID, error) {
+	id, err := srv.createFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.replicate(ctx, id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (srv *Server) createFile(ctx context.Context, file *pb.File) (*pb.FileID, error) {
 	name := file.Name
 	if name == "" {
 		return nil, twirp.RequiredArgumentError("name")
 	}
-	name = cleanFilename(name)
-	if err := validateFilename(name); err != nil {
+	name, err := srv.newFilename(name)
+	if err != nil {
 		return nil, twirp.InvalidArgumentError("name", err.Error())
 	}
+	if err := validateMetadata(file.Metadata); err != nil {
+		return nil, twirp.InvalidArgumentError("metadata", err.Error())
+	}
+	metadata := srv.cfg.DefaultMetadata.apply(name, file.Metadata)
+	if len(metadata) > maxMetadataKeys {
+		msg := fmt.Sprintf("a file version may have at most %d metadata keys, including %d default keys", maxMetadataKeys, len(metadata)-len(file.Metadata))
+		return nil, twirp.InvalidArgumentError("metadata", msg)
+	}
+	cond, err := filePrecondition(file)
+	if err != nil {
+		return nil, err
+	}
+	base, hasBase, err := fileBase(file)
+	if err != nil {
+		return nil, err
+	}
+	strategy := ConflictReplace
+	if hasBase {
+		strategy = srv.cfg.Conflicts.strategy(name)
+	}
+
+	// A retried request returns the file version created by the original request
+	key := db.CreateKey{
+		Name:           name,
+		Manifest:       sum.Compute(append(bytes.Join(file.Sums, nil), file.Data...)),
+		IdempotencyKey: file.IdempotencyKey,
+	}
+	if key.IdempotencyKey != "" {
+		prev, err := srv.db.GetJournalledFile(key)
+		if err == nil {
+			return srv.journalledFileID(prev)
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, fmt.Errorf("db GetJournalledFile: %w", err)
+		}
+	}
 
 	// Check if this file has a previous version
 	var hasPrev bool
+	_, span := tracing.Start(ctx, "db.GetLatestFileVersion")
 	prevInfo, err := srv.db.GetLatestFileVersion(name)
+	if errors.Is(err, db.ErrNotFound) {
+		tracing.End(ctx, span, nil)
+	} else {
+		tracing.End(ctx, span, err)
+	}
 	if errors.Is(err, db.ErrNotFound) {
 		hasPrev = false
 	} else if err != nil {
@@ -189,47 +665,230 @@ func (srv *Server) CreateFile(ctx context.Context, file *pb.File) (*pb.FileID, e
 		hasPrev = true
 	}
 
-	chunks := make([]object.Chunk, len(file.Sums))
-	for i, s := range file.Sums {
-		sum, err := sum.FromBytes(s)
+	var chunks []object.Chunk
+	var data []byte
+	var checksums map[string][]byte
+	if len(file.Data) > 0 {
+		if chunks, err = srv.inlineChunks(file); err != nil {
+			return nil, err
+		}
+		data = file.Data
+		if checksums, err = srv.dataChecksums(data, file.Checksums); err != nil {
+			return nil, err
+		}
+	} else if ByReference(file) {
+		chunks, data, checksums, err = srv.referencedChunks(ctx, file.Checksums)
 		if err != nil {
-			msg := fmt.Sprintf("sum %d: %v", i, err)
-			return nil, twirp.InvalidArgumentError("sums", msg)
+			return nil, err
 		}
-
-		size, err := srv.db.GetChunkSize(sum)
-		if errors.Is(err, db.ErrNotFound) {
-			msg := fmt.Sprintf("sum %d %x does not exist", i, sum)
-			return nil, twirp.NewError(twirp.FailedPrecondition, msg)
-		} else if err != nil {
+	} else {
+		if chunks, err = srv.fileChunks(ctx, file.Sums); err != nil {
+			return nil, err
+		}
+		if checksums, err = srv.fileChecksums(ctx, chunks, file.Checksums); err != nil {
 			return nil, err
 		}
+	}
 
-		chunks[i] = object.Chunk{Sequence: uint64(i), Size: size, Sum: sum}
+	// Only the reject and branch strategies need the base to hold when the version is
+	// inserted. A replaced version is reported if the base did not hold just before.
+	resolution := pb.ConflictResolution_NO_CONFLICT
+	if strategy == ConflictReplace {
+		if hasBase && !baseHolds(base, prevInfo, hasPrev) {
+			resolution = pb.ConflictResolution_REPLACED
+		}
+	} else {
+		cond = base
+	}
+
+	f := object.File{Name: name, Chunks: chunks, CreatedAt: time.Now().UTC(), Versioned: srv.cfg.VersioningEnabled, Data: data}
+	if err := srv.checkQuotas(srv.newQuotaChange(f, prevInfo, hasPrev)); err != nil {
+		return nil, err
+	}
+	var s sum.Sum
+	for n := 1; ; n++ {
+		var inserted bool
+		s, inserted, err = srv.insertFile(ctx, f, metadata, checksums, cond, key)
+		if errors.Is(err, db.ErrConflict) && strategy == ConflictBranch && n <= maxBranches {
+			// Try the next branched name, which must not exist
+			if f.Name, err = srv.newFilename(branchName(name, n)); err != nil {
+				return nil, twirp.NewError(twirp.Aborted, fmt.Sprintf("cannot branch conflicting version of file %s: %v", name, err))
+			}
+			cond = db.Precondition{IfNotExists: true}
+			resolution = pb.ConflictResolution_BRANCHED
+			continue
+		}
+		if err != nil {
+			return nil, conflictError(err, name)
+		}
+		if !inserted {
+			// A concurrent retry of this request created the file version first
+			return srv.journalledFileID(s)
+		}
+		break
+	}
+
+	srv.cache.invalidate(f.Name)
+
+	// Delete the previous version if versioning is turned off
+	if f.Name == name && hasPrev && !prevInfo.Versioned && !srv.cfg.VersioningEnabled {
+		if err := srv.deleteReplaced(ctx, prevInfo.Sum); err != nil {
+			log.Error(err)
+		}
 	}
 
-	f := object.File{Name: name, Chunks: chunks, CreatedAt: time.Now().UTC(), Versioned: srv.cfg.VersioningEnabled}
+	return &pb.FileID{Sum: s[:], Name: f.Name, Conflict: resolution}, nil
+}
+
+// insertFile saves a new file version to the store and inserts it into the database,
+// as with InsertFileOnce if key has an idempotency key. The object is deleted from the
+// store if the version is not inserted.
+func (srv *Server) insertFile(ctx context.Context, f object.File, metadata map[string]string, checksums map[string][]byte, cond db.Precondition, key db.CreateKey) (sum.Sum, bool, error) {
 	b := f.MarshalBinary()
-	sum := sum.Compute(b)
+	s := sum.Compute(b)
 
-	fkey := sum.AsHex() + ".file"
+	fkey := s.AsHex() + ".file"
 	if err := srv.store.Put(ctx, srv.cfg.Bucket, fkey, bytes.NewReader(b)); err != nil {
-		return nil, err
+		return s, false, err
 	}
 
-	if err := srv.db.InsertFile(f, sum); err != nil {
-		err = mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, fkey))
-		return nil, err
+	if key.IdempotencyKey == "" {
+		_, span := tracing.Start(ctx, "db.InsertFile", label.Int("chunks", len(f.Chunks)))
+		err := srv.db.InsertFile(f, s, metadata, checksums, cond)
+		tracing.End(ctx, span, err)
+		if err != nil {
+			return s, false, mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, fkey))
+		}
+		return s, true, nil
 	}
 
-	// Delete the previous version if versioning is turned off
-	if hasPrev && !prevInfo.Versioned && !srv.cfg.VersioningEnabled {
-		if _, err = srv.Delete(ctx, &pb.FileID{Sum: prevInfo.Sum[:]}); err != nil {
-			log.Error(err)
+	_, span := tracing.Start(ctx, "db.InsertFileOnce", label.Int("chunks", len(f.Chunks)))
+	prev, inserted, err := srv.db.InsertFileOnce(f, s, metadata, checksums, cond, key)
+	tracing.End(ctx, span, err)
+	if err != nil {
+		return s, false, mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, fkey))
+	}
+	if !inserted {
+		if err := srv.store.Delete(srv.cfg.Bucket, fkey); err != nil {
+			srv.logger.Error().Msgf("deleting %s: %v", fkey, err)
 		}
 	}
+	return prev, inserted, nil
+}
 
-	return &pb.FileID{Sum: sum[:]}, nil
+// journalledFileID returns the response to a retried CreateFile request which created
+// the file version s. The conflict resolution of the original request is not
+// recorded, but the name shows whether the version was branched.
+func (srv *Server) journalledFileID(s sum.Sum) (*pb.FileID, error) {
+	info, err := srv.db.GetFileInfo(s)
+	if errors.Is(err, db.ErrNotFound) {
+		// Deleted since it was created
+		return &pb.FileID{Sum: s[:]}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db GetFileInfo: %w", err)
+	}
+	return &pb.FileID{Sum: s[:], Name: info.Name}, nil
+}
+
+// deleteReplaced deletes a file version replaced by a new version while versioning is
+// turned off, unless the version is tagged or in a snapshot.
+func (srv *Server) deleteReplaced(ctx context.Context, s sum.Sum) error {
+	pinned, err := srv.db.IsVersionPinned(s)
+	if err != nil {
+		return fmt.Errorf("db IsVersionPinned: %w", err)
+	}
+	if pinned {
+		return nil
+	}
+	_, err = srv.Delete(ctx, &pb.FileID{Sum: s[:]})
+	return err
+}
+
+// filePrecondition returns the precondition on the latest version of a file which must
+// hold for the file to be created.
+func filePrecondition(file *pb.File) (db.Precondition, error) {
+	cond := db.Precondition{IfNotExists: file.IfNotExists}
+	if file.IfMatch == nil {
+		return cond, nil
+	}
+	if file.IfNotExists {
+		return cond, twirp.InvalidArgumentError("if_match", "cannot be set with if_not_exists")
+	}
+	s, err := sum.FromBytes(file.IfMatch)
+	if err != nil {
+		return cond, twirp.InvalidArgumentError("if_match", err.Error())
+	}
+	cond.IfMatch = &s
+	return cond, nil
+}
+
+// fileBase returns the precondition which holds if the latest version of a file is
+// still the version the client began uploading the file from. Returns false if the
+// client did not send its base.
+func fileBase(file *pb.File) (db.Precondition, bool, error) {
+	if file.Base == nil && !file.BaseNotExists {
+		return db.Precondition{}, false, nil
+	}
+	if file.IfMatch != nil || file.IfNotExists {
+		return db.Precondition{}, false, twirp.InvalidArgumentError("base", "cannot be set with if_match or if_not_exists")
+	}
+	if file.BaseNotExists {
+		if file.Base != nil {
+			return db.Precondition{}, false, twirp.InvalidArgumentError("base", "cannot be set with base_not_exists")
+		}
+		return db.Precondition{IfNotExists: true}, true, nil
+	}
+	s, err := sum.FromBytes(file.Base)
+	if err != nil {
+		return db.Precondition{}, false, twirp.InvalidArgumentError("base", err.Error())
+	}
+	return db.Precondition{IfMatch: &s}, true, nil
+}
+
+// baseHolds returns true if the base precondition holds for a file with the given
+// latest version.
+func baseHolds(base db.Precondition, latest db.FileInfo, exists bool) bool {
+	if base.IfNotExists {
+		return !exists
+	}
+	return exists && latest.Sum == *base.IfMatch
+}
+
+// conflictError converts a db.ErrConflict error, returned when a precondition on the
+// file name does not hold, to an Aborted error. Other errors are returned unchanged.
+func conflictError(err error, name string) error {
+	if errors.Is(err, db.ErrConflict) {
+		return twirp.NewError(twirp.Aborted, fmt.Sprintf("precondition failed for file %s", name))
+	}
+	return err
+}
+
+// fileChunks returns the chunks of a file from their checksums. Returns a
+// FailedPrecondition error if any chunk does not exist.
+func (srv *Server) fileChunks(ctx context.Context, sums [][]byte) (chunks []object.Chunk, err error) {
+	_, span := tracing.Start(ctx, "db.GetChunkSize", label.Int("chunks", len(sums)))
+	defer func() { tracing.End(ctx, span, err) }()
+
+	chunks = make([]object.Chunk, len(sums))
+	for i, s := range sums {
+		sum, err := sum.FromBytes(s)
+		if err != nil {
+			msg := fmt.Sprintf("sum %d: %v", i, err)
+			return nil, twirp.InvalidArgumentError("sums", msg)
+		}
+
+		size, err := srv.chunkSize(sum)
+		if errors.Is(err, db.ErrNotFound) {
+			msg := fmt.Sprintf("sum %d %x does not exist", i, sum)
+			return nil, twirp.NewError(twirp.FailedPrecondition, msg)
+		} else if err != nil {
+			return nil, err
+		}
+
+		chunks[i] = object.Chunk{Sequence: uint64(i), Size: size, Sum: sum}
+	}
+	return chunks, nil
 }
 
 // ChunksExist checks if a list of chunks already exist in the store. The response
@@ -248,7 +907,9 @@ func (srv *Server) ChunksExist(ctx context.Context, req *pb.ChunksExistRequest)
 		sums[i] = s
 	}
 
+	_, span := tracing.Start(ctx, "db.ChunksExist", label.Int("chunks", len(sums)))
 	exists, err := srv.db.ChunksExist(sums)
+	tracing.End(ctx, span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -256,20 +917,30 @@ func (srv *Server) ChunksExist(ctx context.Context, req *pb.ChunksExistRequest)
 	return &pb.ChunksExistResponse{Exists: exists}, nil
 }
 
-// List returns all versions of files with a given prefix. The response NextPageToken can
-// be used to retrieve the next page of results, unless it has the value -1, in which case
-// no further pages exist. The parameter Limit sets the maximum number of results per
-// page and must be provided. The parameter Exclude may be provided as a glob pattern to
-// exclude files from the response. If Exclude is set, the Include parameter may also
+// List returns all versions of files with a given prefix. The parameter Limit sets the
+// maximum number of results per page and must be provided. The response NextCursor can
+// be passed as Cursor to retrieve the next page of results, unless it's empty, in which
+// case no further pages exist. The parameter Exclude may be provided as a glob pattern
+// to exclude files from the response. If Exclude is set, the Include parameter may also
 // be provided to force inclusion of any files excluded by the Exclude pattern. Results
-// are returned in reverse-chronological order of file created date by default. Ascending
-// may be set to true to reverse the order.
+// are returned in reverse-chronological order of file created date by default. Sort
+// may be set to order the results by name or size instead, and Ascending may be set to
+// true to reverse the order. If Delimiter is set, which requires name order, the files
+// with names containing the delimiter after the prefix are returned as a single entry in
+// Prefixes, like directories. Prefixes count towards Limit.
+//
+// For compatibility with older clients, NextPageToken may be used instead of the cursor
+// when sorting by creation date. It's set to the creation date of the last result, or
+// -1 if no further pages exist.
 func (srv *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
-	prefix := req.Prefix
-	if prefix == "" {
+	if req.Prefix == "" {
 		return nil, twirp.RequiredArgumentError("prefix")
 	}
-	prefix = cleanFilename(prefix)
+	prefix := srv.NormalizeName(req.Prefix)
+	if strings.HasSuffix(req.Prefix, "/") {
+		// Only match files in the directory, not its siblings e.g. /data2 for /data/
+		prefix += "/"
+	}
 	if req.Limit == 0 {
 		return nil, twirp.RequiredArgumentError("limit")
 	}
@@ -279,14 +950,72 @@ func (srv *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListRespo
 	if req.NextPageToken < 0 {
 		return nil, twirp.InvalidArgumentError("next_page_token", "cannot be negative")
 	}
+	var sortOrder db.ListSort
+	switch req.Sort {
+	case pb.ListSort_CREATED_AT:
+		sortOrder = db.SortCreatedAt
+	case pb.ListSort_NAME:
+		sortOrder = db.SortName
+	case pb.ListSort_SIZE:
+		sortOrder = db.SortSize
+	default:
+		return nil, twirp.InvalidArgumentError("sort", "unknown sort order")
+	}
+	if req.Delimiter != "" && sortOrder != db.SortName {
+		return nil, twirp.InvalidArgumentError("delimiter", "requires sort order NAME")
+	}
+
+	opts := db.ListOptions{
+		Prefix:    prefix,
+		Exclude:   srv.NormalizeName(req.Exclude),
+		Include:   srv.NormalizeName(req.Include),
+		Delimiter: req.Delimiter,
+		Sort:      sortOrder,
+		Ascending: req.Ascending,
+		Limit:     req.Limit,
+	}
+	if req.Cursor != "" {
+		cursor, err := decodeListCursor(req.Cursor, sortOrder, req.Ascending)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("cursor", err.Error())
+		}
+		opts.After = &cursor
+	} else if req.NextPageToken > 0 {
+		if sortOrder != db.SortCreatedAt {
+			return nil, twirp.InvalidArgumentError("next_page_token", "only supported by sort order CREATED_AT")
+		}
+		// Continue after every file version created at the time of the token
+		opts.After = &db.ListCursor{CreatedAt: req.NextPageToken}
+		if req.Ascending {
+			opts.After.ID = math.MaxInt64
+		}
+	}
+
+	resp, err := srv.cache.do(cacheKey("List", req), prefix, func() (proto.Message, error) {
+		_, span := tracing.Start(ctx, "db.ListFilePage", label.String("prefix", prefix))
+		page, err := srv.db.ListFilePage(opts)
+		tracing.End(ctx, span, err)
+		if err != nil {
+			return nil, err
+		}
 
-	exclude := cleanFilename(req.Exclude)
-	include := cleanFilename(req.Include)
-	infos, err := srv.db.ListFiles(prefix, req.NextPageToken, req.Limit, exclude, include, req.Ascending)
+		resp := &pb.ListResponse{Info: pbFileInfos(page.Files), Prefixes: page.Prefixes, NextPageToken: -1}
+		if page.Next != nil {
+			resp.NextCursor = encodeListCursor(*page.Next, sortOrder, req.Ascending)
+			if sortOrder == db.SortCreatedAt {
+				resp.NextPageToken = page.Next.CreatedAt
+			}
+		}
+		return resp, nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return resp.(*pb.ListResponse), nil
+}
 
+// pbFileInfos converts file versions to their protobuf representation.
+func pbFileInfos(infos []db.FileInfo) []*pb.FileInfo {
 	res := make([]*pb.FileInfo, len(infos))
 	for i := range infos {
 		info := infos[i] // don't use range value
@@ -295,15 +1024,54 @@ func (srv *Server) List(ctx context.Context, req *pb.ListRequest) (*pb.ListRespo
 			CreatedAt: info.CreatedAt.UnixNano(),
 			Size:      info.Size,
 			Sum:       info.Sum[:],
+			VersionId: info.VersionID,
+			Metadata:  info.Metadata,
 		}
 	}
+	return res
+}
 
-	nextToken := int64(-1)
-	if uint64(len(res)) == req.Limit && len(res) > 0 {
-		nextToken = res[len(res)-1].CreatedAt
-	}
+// listCursor is the encoded form of a cursor returned by List. The sort order is
+// included so a cursor can't be used with a different order.
+type listCursor struct {
+	Sort      db.ListSort `json:"s"`
+	Ascending bool        `json:"a,omitempty"`
+	Name      string      `json:"n,omitempty"`
+	CreatedAt int64       `json:"c,omitempty"`
+	Size      uint64      `json:"z,omitempty"`
+	ID        int64       `json:"i"`
+}
 
-	return &pb.ListResponse{Info: res, NextPageToken: nextToken}, nil
+// encodeListCursor returns an opaque string representation of a cursor.
+func encodeListCursor(c db.ListCursor, sortOrder db.ListSort, ascending bool) string {
+	lc := listCursor{Sort: sortOrder, Ascending: ascending, ID: c.ID}
+	switch sortOrder {
+	case db.SortCreatedAt:
+		lc.CreatedAt = c.CreatedAt
+	case db.SortName:
+		lc.Name = c.Name
+	case db.SortSize:
+		lc.Size = c.Size
+	}
+	b, _ := json.Marshal(lc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeListCursor parses a cursor returned by encodeListCursor, and checks it was
+// created for the same sort order.
+func decodeListCursor(s string, sortOrder db.ListSort, ascending bool) (db.ListCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return db.ListCursor{}, errors.New("invalid cursor")
+	}
+	var lc listCursor
+	if err := json.Unmarshal(b, &lc); err != nil {
+		return db.ListCursor{}, errors.New("invalid cursor")
+	}
+	if lc.Sort != sortOrder || lc.Ascending != ascending {
+		return db.ListCursor{}, errors.New("cursor was created for a different sort order")
+	}
+	return db.ListCursor{Name: lc.Name, CreatedAt: lc.CreatedAt, Size: lc.Size, ID: lc.ID}, nil
 }
 
 // Head returns all versions of a file with a given name. The parameters Limit,
@@ -313,7 +1081,7 @@ func (srv *Server) Head(ctx context.Context, req *pb.HeadRequest) (*pb.HeadRespo
 	if name == "" {
 		return nil, twirp.RequiredArgumentError("name")
 	}
-	name = cleanFilename(name)
+	name = srv.NormalizeName(name)
 	if req.Limit == 0 {
 		return nil, twirp.RequiredArgumentError("limit")
 	}
@@ -324,62 +1092,338 @@ func (srv *Server) Head(ctx context.Context, req *pb.HeadRequest) (*pb.HeadRespo
 		return nil, twirp.InvalidArgumentError("next_page_token", "cannot be negative")
 	}
 
-	versions, err := srv.db.GetFileVersions(name, req.NextPageToken, req.Limit, req.Ascending)
+	resp, err := srv.cache.do(cacheKey("Head", req), name, func() (proto.Message, error) {
+		versions, err := srv.db.GetFileVersions(name, req.NextPageToken, req.Limit, req.Ascending)
+		if err != nil {
+			return nil, fmt.Errorf("db GetFileVersions: %w", err)
+		}
+
+		res := pbFileInfos(versions)
+
+		nextToken := int64(-1)
+		if uint64(len(res)) == req.Limit && len(res) > 0 {
+			nextToken = res[len(res)-1].CreatedAt
+		}
+
+		return &pb.HeadResponse{Info: res, NextPageToken: nextToken}, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("db GetFileVersions: %w", err)
+		return nil, err
 	}
+	return resp.(*pb.HeadResponse), nil
+}
 
-	res := make([]*pb.FileInfo, len(versions))
-	for i := range versions {
-		info := versions[i] // don't use range value
-		res[i] = &pb.FileInfo{
-			Name:      info.Name,
-			CreatedAt: info.CreatedAt.UnixNano(),
-			Size:      info.Size,
-			Sum:       info.Sum[:],
+// GetFileInfo returns the latest version of a file with a given name, the version of
+// the file with a given tag, or the file version with a given sum, along with its
+// number of chunks, the number of versions of the file, and a checksum of its data.
+// The checksum is computed from the checksums of the file's chunks, so a client can
+// check if a local file has the same data by chunking it with the server's chunker
+// parameters, without downloading the file. The response also includes any
+// whole-file checksums computed when the version was created. Returns a NotFound
+// error if the file does not exist.
+func (srv *Server) GetFileInfo(ctx context.Context, req *pb.GetFileInfoRequest) (*pb.GetFileInfoResponse, error) {
+	var name string
+	var s sum.Sum
+	if req.Sum != nil {
+		if req.Tag != "" {
+			return nil, twirp.InvalidArgumentError("tag", "cannot be set with sum")
+		}
+		var err error
+		if s, err = sum.FromBytes(req.Sum); err != nil {
+			return nil, twirp.InvalidArgumentError("sum", err.Error())
+		}
+	} else if req.Name != "" {
+		name = srv.NormalizeName(req.Name)
+	} else {
+		return nil, twirp.RequiredArgumentError("name or sum")
+	}
+
+	resp, err := srv.cache.do(cacheKey("GetFileInfo", req), name, func() (proto.Message, error) {
+		var info db.FileInfo
+		var err error
+		if name != "" && req.Tag != "" {
+			info, err = srv.db.GetTaggedVersion(name, req.Tag)
+		} else if name != "" {
+			info, err = srv.db.GetLatestFileVersion(name)
+		} else {
+			info, err = srv.db.GetFileInfo(s)
+		}
+		if errors.Is(err, db.ErrNotFound) && req.Tag != "" {
+			return nil, twirp.NotFoundError(fmt.Sprintf("tag %s of file %s", req.Tag, name))
+		}
+		if errors.Is(err, db.ErrNotFound) && name != "" {
+			return nil, twirp.NotFoundError(fmt.Sprintf("file %s", name))
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, twirp.NotFoundError(fmt.Sprintf("file %x", s))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting file info: %w", err)
+		}
+
+		f, err := srv.db.GetFile(info.Sum)
+		if err != nil {
+			return nil, fmt.Errorf("db GetFile: %w", err)
+		}
+		sums := make([]byte, 0, len(f.Chunks)*sum.Size)
+		for _, c := range f.Chunks {
+			sums = append(sums, c.Sum[:]...)
+		}
+		checksum := sum.Compute(sums)
+
+		n, err := srv.db.CountFileVersions(info.Name)
+		if err != nil {
+			return nil, fmt.Errorf("db CountFileVersions: %w", err)
+		}
+		checksums, err := srv.db.GetFileChecksums(info.Sum)
+		if err != nil {
+			return nil, fmt.Errorf("db GetFileChecksums: %w", err)
 		}
+
+		return &pb.GetFileInfoResponse{
+			Info:        pbFileInfos([]db.FileInfo{info})[0],
+			NumVersions: n,
+			NumChunks:   uint64(len(f.Chunks)),
+			Checksum:    checksum[:],
+			Checksums:   checksums,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetFileInfoResponse), nil
+}
+
+type chunk struct {
+	Sequence    uint64
+	Sum         sum.Sum
+	Size        uint64
+	BlockOffset uint64
+}
+
+type section struct {
+	chunks  []chunk
+	packSum sum.Sum
+	start   uint64
+	end     uint64
+}
+
+// Download returns a collection of URLs to download the data for a file. Each URL
+// contains data for a contiguous section of the file.
+func (srv *Server) Download(ctx context.Context, id *pb.FileID) (*pb.DownloadResponse, error) {
+	if id.Sum == nil {
+		return nil, twirp.RequiredArgumentError("sum")
+	}
+	fileID, err := sum.FromBytes(id.Sum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("sum", err.Error())
+	}
+	indices, err := srv.getFileChunks(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDegraded(fileID, indices); err != nil {
+		return nil, err
+	}
+	sections, err := srv.downloadSections(ctx, indices)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DownloadResponse{Sections: sections}, nil
+}
+
+// DownloadRange is like Download, but only returns the sections containing the chunks
+// which overlap a byte range of the file. It allows clients to read part of a file
+// without downloading every section.
+func (srv *Server) DownloadRange(ctx context.Context, req *pb.DownloadRangeRequest) (*pb.DownloadRangeResponse, error) {
+	if req.Sum == nil {
+		return nil, twirp.RequiredArgumentError("sum")
+	}
+	fileID, err := sum.FromBytes(req.Sum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("sum", err.Error())
+	}
+	indices, err := srv.getFileChunks(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the chunks overlapping the range
+	first, last := len(indices), len(indices)
+	var offset, start uint64
+	for i, idx := range indices {
+		end := offset + idx.Block.ChunkSize
+		if end > req.Offset && first == len(indices) {
+			first = i
+			start = offset
+		}
+		if req.Length != 0 && end >= req.Offset+req.Length {
+			last = i + 1
+			break
+		}
+		offset = end
+	}
+	if first == len(indices) {
+		// The range begins at or beyond the end of the file
+		return &pb.DownloadRangeResponse{Sections: []*pb.Section{}, Offset: offset}, nil
+	}
+	indices = indices[first:last]
+
+	if err := checkDegraded(fileID, indices); err != nil {
+		return nil, err
+	}
+	sections, err := srv.downloadSections(ctx, indices)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DownloadRangeResponse{Sections: sections, Offset: start}, nil
+}
+
+// DownloadDelta is like Download, but only returns the sections containing the chunks
+// of the file which the client does not already hold, with a recipe listing every
+// chunk of the file in order. A client holding a previous version of the file only
+// needs to download the chunks which changed to rebuild the new version.
+func (srv *Server) DownloadDelta(ctx context.Context, req *pb.DownloadDeltaRequest) (*pb.DownloadDeltaResponse, error) {
+	if req.Sum == nil {
+		return nil, twirp.RequiredArgumentError("sum")
+	}
+	fileID, err := sum.FromBytes(req.Sum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("sum", err.Error())
+	}
+	have := make(map[sum.Sum]bool, len(req.Have))
+	for i, b := range req.Have {
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError(fmt.Sprintf("have[%d]", i), err.Error())
+		}
+		have[s] = true
+	}
+	indices, err := srv.getFileChunks(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the first occurrence of each chunk the client doesn't hold
+	recipe := make([]*pb.RecipeChunk, len(indices))
+	missing := make([]db.ChunkIndex, 0)
+	for i, idx := range indices {
+		s := idx.Block.Sum
+		recipe[i] = &pb.RecipeChunk{Sum: s[:], Size: idx.Block.ChunkSize}
+		if !have[s] {
+			have[s] = true
+			idx.Sequence = uint64(i)
+			missing = append(missing, idx)
+		}
+	}
+
+	if len(missing) == 0 {
+		return &pb.DownloadDeltaResponse{Recipe: recipe, Sections: []*pb.Section{}}, nil
+	}
+	if err := checkDegraded(fileID, missing); err != nil {
+		return nil, err
+	}
+	sections, err := srv.downloadSections(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.DownloadDeltaResponse{Recipe: recipe, Sections: sections}, nil
+}
+
+// Diff compares two versions of a file using their chunk lists. It returns the byte
+// ranges of the new version made of chunks which are not in the old version, and the
+// ranges of the old version made of chunks which are not in the new version. A client
+// holding the old version only needs to download the added ranges to build the new
+// version. The versions may belong to different files.
+func (srv *Server) Diff(ctx context.Context, req *pb.DiffRequest) (*pb.DiffResponse, error) {
+	if req.OldSum == nil {
+		return nil, twirp.RequiredArgumentError("old_sum")
+	}
+	if req.NewSum == nil {
+		return nil, twirp.RequiredArgumentError("new_sum")
+	}
+	oldID, err := sum.FromBytes(req.OldSum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("old_sum", err.Error())
+	}
+	newID, err := sum.FromBytes(req.NewSum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("new_sum", err.Error())
+	}
+	oldChunks, err := srv.getFileChunks(ctx, oldID)
+	if err != nil {
+		return nil, err
 	}
-
-	nextToken := int64(-1)
-	if uint64(len(res)) == req.Limit && len(res) > 0 {
-		nextToken = res[len(res)-1].CreatedAt
+	newChunks, err := srv.getFileChunks(ctx, newID)
+	if err != nil {
+		return nil, err
 	}
 
-	return &pb.HeadResponse{Info: res, NextPageToken: nextToken}, nil
-}
-
-type chunk struct {
-	Sequence    uint64
-	Sum         sum.Sum
-	Size        uint64
-	BlockOffset uint64
+	added, newSize := diffRanges(newChunks, oldChunks)
+	removed, oldSize := diffRanges(oldChunks, newChunks)
+	return &pb.DiffResponse{Added: added, Removed: removed, OldSize: oldSize, NewSize: newSize}, nil
 }
 
-type section struct {
-	chunks  []chunk
-	packSum sum.Sum
-	start   uint64
-	end     uint64
+// diffRanges returns the byte ranges of a file made of chunks which are not in other,
+// merging adjacent chunks into a single range, and the size of the file.
+func diffRanges(chunks []db.ChunkIndex, other []db.ChunkIndex) ([]*pb.ByteRange, uint64) {
+	in := make(map[sum.Sum]bool, len(other))
+	for _, c := range other {
+		in[c.Block.Sum] = true
+	}
+	ranges := make([]*pb.ByteRange, 0)
+	var offset uint64
+	var last *pb.ByteRange
+	for _, c := range chunks {
+		size := c.Block.ChunkSize
+		if !in[c.Block.Sum] {
+			if last != nil && last.Offset+last.Length == offset {
+				last.Length += size
+				last.NumChunks++
+			} else {
+				last = &pb.ByteRange{Offset: offset, Length: size, NumChunks: 1}
+				ranges = append(ranges, last)
+			}
+		}
+		offset += size
+	}
+	return ranges, offset
 }
 
-// Download returns a collection of URLs to download the data for a file. Each URL
-// contains data for a contiguous section of the file.
-func (srv *Server) Download(ctx context.Context, id *pb.FileID) (*pb.DownloadResponse, error) {
-	if id.Sum == nil {
-		return nil, twirp.RequiredArgumentError("sum")
-	}
-	fileID, err := sum.FromBytes(id.Sum)
-	if err != nil {
-		return nil, twirp.InvalidArgumentError("sum", err.Error())
+// getFileChunks returns the chunks of a file, or a NotFound error if the file does not
+// exist.
+func (srv *Server) getFileChunks(ctx context.Context, fileID sum.Sum) ([]db.ChunkIndex, error) {
+	if srv.indexCache != nil || srv.locations != nil {
+		indices, ok, err := srv.cachedFileChunks(ctx, fileID)
+		if err != nil || ok {
+			return indices, err
+		}
 	}
-
+	_, span := tracing.Start(ctx, "db.GetFileChunks")
 	indices, err := srv.db.GetFileChunks(fileID)
+	tracing.End(ctx, span, err)
 	if errors.Is(err, db.ErrNotFound) {
-		return nil, twirp.NotFoundError(fmt.Sprintf("file %x", id.Sum))
+		return nil, twirp.NotFoundError(fmt.Sprintf("file %x", fileID))
 	}
 	if err != nil {
 		return nil, fmt.Errorf("db GetFileChunks: %w", err)
 	}
+	srv.locations.add(indices)
+	return indices, nil
+}
+
+// downloadSections gathers a sequence of chunks into sections, and generates a URL to
+// download each section.
+func (srv *Server) downloadSections(ctx context.Context, indices []db.ChunkIndex) ([]*pb.Section, error) {
+	// A file stored inline is returned with the response
+	if _, ok := inlineData(indices); ok {
+		return []*pb.Section{inlineSection(indices[0])}, nil
+	}
+
+	// Packfiles in the upload queue can't be downloaded from the store yet
+	if err := srv.uploads.wait(ctx, indices); err != nil {
+		return nil, err
+	}
 
 	// Gather the chunks into sections corresponding to contiguous slices of a packfile
 	sections := make([]section, 0)
@@ -440,12 +1484,13 @@ func (srv *Server) Download(ctx context.Context, id *pb.FileID) (*pb.DownloadRes
 		urls[i] = url
 	}
 
-	// Constuct the response
+	// Construct the response
 	rSections := make([]*pb.Section, len(sections))
 	for i, section := range sections {
 		section := section
 		rChunks := make([]*pb.SectionChunk, len(section.chunks))
 		for j, chunk := range section.chunks {
+			chunk := chunk // chunk.Sum is referenced in the response
 			rChunks[j] = &pb.SectionChunk{
 				Sequence:    chunk.Sequence,
 				Size:        chunk.Size,
@@ -460,14 +1505,125 @@ func (srv *Server) Download(ctx context.Context, id *pb.FileID) (*pb.DownloadRes
 			RangeEnd:   section.end,
 		}
 	}
-	resp := &pb.DownloadResponse{Sections: rSections}
+	return rSections, nil
+}
 
-	return resp, nil
+// checkDegraded returns a DataLoss error if any of a file's chunks are in a degraded
+// packfile.
+func checkDegraded(fileID sum.Sum, indices []db.ChunkIndex) error {
+	for _, idx := range indices {
+		if idx.PackDegraded {
+			msg := fmt.Sprintf("file %x has data in packfile %s which was modified outside of the server", fileID, idx.PackSum.AsHex())
+			return twirp.NewError(twirp.DataLoss, msg)
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the contents of a file version to w, reading its chunks from the
+// store. Unlike Download, the data passes through the server. Returns a NotFound error
+// if the file does not exist.
+func (srv *Server) WriteFile(ctx context.Context, fileID sum.Sum, w io.Writer) error {
+	_, span := tracing.Start(ctx, "db.GetFileChunks")
+	indices, err := srv.db.GetFileChunks(fileID)
+	tracing.End(ctx, span, err)
+	if errors.Is(err, db.ErrNotFound) {
+		return twirp.NotFoundError(fmt.Sprintf("file %x", fileID))
+	}
+	if err != nil {
+		return fmt.Errorf("db GetFileChunks: %w", err)
+	}
+	if err := checkDegraded(fileID, indices); err != nil {
+		return err
+	}
+	return srv.writeChunks(ctx, indices, w)
+}
+
+// writeChunks writes the data of each chunk to w, in order, reading the chunks from
+// the chunk cache or their packfiles in the store, or the database if the file is
+// stored inline.
+func (srv *Server) writeChunks(ctx context.Context, indices []db.ChunkIndex, w io.Writer) error {
+	if data, ok := inlineData(indices); ok {
+		_, err := w.Write(data)
+		return err
+	}
+	if srv.cfg.ReadAhead > 0 {
+		return srv.writeChunksAhead(ctx, indices, w)
+	}
+	for i := 0; i < len(indices); {
+		if data, ok := srv.chunkCache.get(indices[i].Block.Sum); ok {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+		// Consecutive chunks in the same packfile are read with a single ranged request,
+		// up to the next cached chunk
+		j := i + 1
+		for j < len(indices) && inSameRange(indices[j-1], indices[j]) && !srv.chunkCache.contains(indices[j].Block.Sum) {
+			j++
+		}
+		if err := srv.writeRange(ctx, indices[i:j], w); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// inSameRange returns true if chunk b follows chunk a in the same packfile, separated
+// by no more than maxRangeGap bytes.
+func inSameRange(a db.ChunkIndex, b db.ChunkIndex) bool {
+	end := a.Block.Offset + a.Block.Size
+	return a.PackSum == b.PackSum && b.Block.Offset >= end && b.Block.Offset-end <= maxRangeGap
+}
 
+// writeRange writes the data of chunks in the same packfile, in ascending order of
+// offset, to w. The range of the packfile holding the chunks is read from the store.
+func (srv *Server) writeRange(ctx context.Context, indices []db.ChunkIndex, w io.Writer) error {
+	packSum := indices[0].PackSum
+	key := packSum.AsHex() + ".pack"
+	from := indices[0].Block.Offset
+	last := indices[len(indices)-1].Block
+	// Packfiles in the upload queue are read from the queue directory
+	r, ok := srv.uploads.getRange(packSum, from, last.Offset+last.Size-from)
+	if !ok {
+		var err error
+		r, err = srv.store.GetRange(ctx, srv.cfg.Bucket, key, from, last.Offset+last.Size-from)
+		if err != nil {
+			return fmt.Errorf("getting %s: %w", key, err)
+		}
+	}
+	defer r.Close()
+	pos := from
+	for _, idx := range indices {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(idx.Block.Offset-pos)); err != nil {
+			return fmt.Errorf("reading packfile %s: %w", packSum.AsHex(), err)
+		}
+		block := make([]byte, idx.Block.Size)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return fmt.Errorf("reading packfile %s: %w", packSum.AsHex(), err)
+		}
+		pos = idx.Block.Offset + idx.Block.Size
+		data, err := object.DecodeBlock(block, srv.ChunkHash())
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", idx.Sequence, err)
+		}
+		if err := srv.chunkCache.put(idx.Block.Sum, data); err != nil {
+			// The cache is best effort, so the chunk is still written
+			srv.logger.Warn().Msgf("caching chunk %s: %v", idx.Block.Sum.AsHex(), err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Copy makes a copy of a file and returns its ID. Returns a NotFound error if the file
-// does not exist.
+// Copy makes a copy of a file and returns its ID. The copy references the same chunks
+// as the source file, so no file data is written, and has the same user-defined
+// metadata. Returns a NotFound error if the file does not exist.
 func (srv *Server) Copy(ctx context.Context, req *pb.CopyRequest) (*pb.FileID, error) {
 	if req.SrcId == nil {
 		return nil, twirp.RequiredArgumentError("src_id")
@@ -476,8 +1632,8 @@ func (srv *Server) Copy(ctx context.Context, req *pb.CopyRequest) (*pb.FileID, e
 	if dst == "" {
 		return nil, twirp.RequiredArgumentError("dst")
 	}
-	dst = cleanFilename(dst)
-	if err := validateFilename(dst); err != nil {
+	dst, err := srv.newFilename(dst)
+	if err != nil {
 		return nil, twirp.InvalidArgumentError("dst", err.Error())
 	}
 	srcID, err := sum.FromBytes(req.SrcId)
@@ -492,8 +1648,19 @@ func (srv *Server) Copy(ctx context.Context, req *pb.CopyRequest) (*pb.FileID, e
 	} else if err != nil {
 		return nil, fmt.Errorf("db GetFile: %w", err)
 	}
+	info, err := srv.db.GetFileInfo(srcID)
+	if err != nil {
+		return nil, fmt.Errorf("db GetFileInfo: %w", err)
+	}
+	checksums, err := srv.db.GetFileChecksums(srcID)
+	if err != nil {
+		return nil, fmt.Errorf("db GetFileChecksums: %w", err)
+	}
 	f.Name = dst
 	f.CreatedAt = time.Now().UTC()
+	if err := srv.checkQuotas(quotaChange{name: dst, added: f.Size()}); err != nil {
+		return nil, err
+	}
 
 	// Save the new file to the database and store
 	b := f.MarshalBinary()
@@ -504,14 +1671,151 @@ func (srv *Server) Copy(ctx context.Context, req *pb.CopyRequest) (*pb.FileID, e
 		return nil, err
 	}
 
-	if err := srv.db.InsertFile(f, sum); err != nil {
+	if err := srv.db.InsertFile(f, sum, info.Metadata, checksums, db.Precondition{}); err != nil {
 		err = mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, fkey))
 		return nil, fmt.Errorf("inserting file: %w", err)
 	}
+	srv.cache.invalidate(dst)
+
+	return &pb.FileID{Sum: sum[:]}, nil
+}
+
+// RevertToVersion restores a previous version of a file by creating a new latest
+// version of the file which references the same chunks, and has the same user-defined
+// metadata, as the previous version. No file data is written. Returns a NotFound error
+// if the version does not exist, or an Aborted error if the request's IfMatch
+// precondition does not hold.
+func (srv *Server) RevertToVersion(ctx context.Context, req *pb.RevertToVersionRequest) (*pb.FileID, error) {
+	if req.Sum == nil {
+		return nil, twirp.RequiredArgumentError("sum")
+	}
+	id, err := sum.FromBytes(req.Sum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("sum", err.Error())
+	}
+	var cond db.Precondition
+	if req.IfMatch != nil {
+		s, err := sum.FromBytes(req.IfMatch)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("if_match", err.Error())
+		}
+		cond.IfMatch = &s
+	}
+
+	f, err := srv.db.GetFile(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("file %x", id))
+	} else if err != nil {
+		return nil, fmt.Errorf("db GetFile: %w", err)
+	}
+	info, err := srv.db.GetFileInfo(id)
+	if err != nil {
+		return nil, fmt.Errorf("db GetFileInfo: %w", err)
+	}
+	checksums, err := srv.db.GetFileChecksums(id)
+	if err != nil {
+		return nil, fmt.Errorf("db GetFileChecksums: %w", err)
+	}
+	latest, err := srv.db.GetLatestFileVersion(f.Name)
+	if err != nil {
+		return nil, fmt.Errorf("db GetLatestFileVersion: %w", err)
+	}
+	f.CreatedAt = time.Now().UTC()
+	f.Versioned = srv.cfg.VersioningEnabled
+	if err := srv.checkQuotas(srv.newQuotaChange(f, latest, true)); err != nil {
+		return nil, err
+	}
+
+	// Save the new version to the database and store
+	b := f.MarshalBinary()
+	sum := sum.Compute(b)
+
+	fkey := sum.AsHex() + ".file"
+	if err := srv.store.Put(ctx, srv.cfg.Bucket, fkey, bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	if err := srv.db.InsertFile(f, sum, info.Metadata, checksums, cond); err != nil {
+		err = mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, fkey))
+		return nil, conflictError(err, f.Name)
+	}
+	srv.cache.invalidate(f.Name)
+
+	// Delete the replaced version if versioning is turned off
+	if !latest.Versioned && !srv.cfg.VersioningEnabled {
+		if err := srv.deleteReplaced(ctx, latest.Sum); err != nil {
+			srv.logger.Error().Msgf("deleting file version %x: %v", latest.Sum, err)
+		}
+	}
 
 	return &pb.FileID{Sum: sum[:]}, nil
 }
 
+// Rename changes the name of a file, keeping its version history. If Prefix is set,
+// every file in the directory Src and its subdirectories is moved to the directory
+// Dst. The rename is atomic: either every file is renamed, or none are. Returns a
+// NotFound error if no files are renamed, or an AlreadyExists error if a new name is
+// already in use. Only the metadata database is changed, so the file objects in the
+// store keep their original names.
+func (srv *Server) Rename(ctx context.Context, req *pb.RenameRequest) (*pb.RenameResponse, error) {
+	if req.Src == "" {
+		return nil, twirp.RequiredArgumentError("src")
+	}
+	if req.Dst == "" {
+		return nil, twirp.RequiredArgumentError("dst")
+	}
+	src := srv.NormalizeName(req.Src)
+	dst, err := srv.newFilename(req.Dst)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("dst", err.Error())
+	}
+
+	var n int
+	if req.Prefix {
+		src, dst = src+"/", dst+"/"
+		if src == "/" {
+			return nil, twirp.InvalidArgumentError("src", "cannot move the root directory")
+		}
+		if strings.HasPrefix(dst, src) || strings.HasPrefix(src, dst) {
+			return nil, twirp.InvalidArgumentError("dst", "cannot move a directory into itself or its parent")
+		}
+		var invalid error
+		_, span := tracing.Start(ctx, "db.RenamePrefix", label.String("src", src), label.String("dst", dst))
+		n, err = srv.db.RenamePrefix(src, dst, func(name string) error {
+			invalid = validateFilename(name, srv.cfg.Naming)
+			return invalid
+		})
+		tracing.End(ctx, span, err)
+		if invalid != nil {
+			return nil, twirp.InvalidArgumentError("dst", err.Error())
+		}
+	} else {
+		if src == dst {
+			return nil, twirp.InvalidArgumentError("dst", "must be different to src")
+		}
+		_, span := tracing.Start(ctx, "db.RenameFile", label.String("src", src), label.String("dst", dst))
+		err = srv.db.RenameFile(src, dst)
+		tracing.End(ctx, span, err)
+		n = 1
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("file %s", src))
+	}
+	if errors.Is(err, db.ErrExists) {
+		return nil, twirp.NewError(twirp.AlreadyExists, err.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("renaming %s: %w", src, err)
+	}
+	if req.Prefix {
+		srv.cache.invalidatePrefix(src)
+		srv.cache.invalidatePrefix(dst)
+	} else {
+		srv.cache.invalidate(src)
+		srv.cache.invalidate(dst)
+	}
+	return &pb.RenameResponse{NumFiles: uint64(n)}, nil
+}
+
 // Delete removes a file. Returns a NotFound error if the files does not exist.
 func (srv *Server) Delete(ctx context.Context, fileID *pb.FileID) (*pb.Empty, error) {
 	if fileID.Sum == nil {
@@ -522,7 +1826,8 @@ func (srv *Server) Delete(ctx context.Context, fileID *pb.FileID) (*pb.Empty, er
 		return nil, twirp.InvalidArgumentError("sum", err.Error())
 	}
 
-	if _, err = srv.db.GetFileInfo(s); errors.Is(err, db.ErrNotFound) {
+	info, err := srv.db.GetFileInfo(s)
+	if errors.Is(err, db.ErrNotFound) {
 		return nil, twirp.NotFoundError(fmt.Sprintf("file %x", s))
 	} else if err != nil {
 		return nil, fmt.Errorf("db GetFileInfo: %w", err)
@@ -536,58 +1841,231 @@ func (srv *Server) Delete(ctx context.Context, fileID *pb.FileID) (*pb.Empty, er
 	if err := srv.db.DeleteFile(s); err != nil {
 		return nil, fmt.Errorf("db DeleteFile: %w", err)
 	}
+	srv.cache.invalidate(info.Name)
 
 	return &pb.Empty{}, nil
 }
 
+// DeleteBatch removes file versions, given by their sums, and all versions of files,
+// given by their names, in a single database transaction. Sums and names which do not
+// exist are ignored. Returns an Aborted error if the latest version of a file in
+// IfMatch differs from the expected version. Returns the sums of the file versions
+// deleted.
+func (srv *Server) DeleteBatch(ctx context.Context, req *pb.DeleteBatchRequest) (*pb.DeleteBatchResponse, error) {
+	if len(req.Sums) == 0 && len(req.Names) == 0 {
+		return nil, twirp.RequiredArgumentError("sums or names")
+	}
+	if len(req.Sums)+len(req.Names) > maxDeleteBatch {
+		return nil, twirp.InvalidArgumentError("sums", fmt.Sprintf("max of %d sums and names combined", maxDeleteBatch))
+	}
+	sums := make([]sum.Sum, len(req.Sums))
+	for i, b := range req.Sums {
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("sums", err.Error())
+		}
+		sums[i] = s
+	}
+	names := make([]string, len(req.Names))
+	requested := make(map[string]bool, len(req.Names))
+	for i, name := range req.Names {
+		names[i] = srv.NormalizeName(name)
+		if names[i] == "" {
+			return nil, twirp.InvalidArgumentError("names", "cannot be empty")
+		}
+		requested[names[i]] = true
+	}
+	var ifMatch map[string]sum.Sum
+	for name, b := range req.IfMatch {
+		normalized := srv.NormalizeName(name)
+		if !requested[normalized] {
+			return nil, twirp.InvalidArgumentError("if_match", fmt.Sprintf("file %s is not in names", name))
+		}
+		s, err := sum.FromBytes(b)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("if_match", err.Error())
+		}
+		if ifMatch == nil {
+			ifMatch = make(map[string]sum.Sum, len(req.IfMatch))
+		}
+		ifMatch[normalized] = s
+	}
+
+	_, span := tracing.Start(ctx, "db.DeleteFiles", label.Int("sums", len(sums)), label.Int("names", len(names)))
+	deleted, err := srv.db.DeleteFiles(sums, names, ifMatch)
+	tracing.End(ctx, span, err)
+	if errors.Is(err, db.ErrConflict) {
+		return nil, twirp.NewError(twirp.Aborted, "precondition failed")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db DeleteFiles: %w", err)
+	}
+	if len(sums) > 0 {
+		// The names of the versions deleted by sum aren't known
+		srv.cache.invalidatePrefix("")
+	} else {
+		for _, name := range names {
+			srv.cache.invalidate(name)
+		}
+	}
+
+	// The files are deleted once they are removed from the database. A file object
+	// which fails to be removed from the store is only logged.
+	resp := &pb.DeleteBatchResponse{Deleted: make([][]byte, len(deleted))}
+	for i := range deleted {
+		resp.Deleted[i] = deleted[i][:]
+		key := deleted[i].AsHex() + ".file"
+		if err := srv.store.Delete(srv.cfg.Bucket, key); err != nil {
+			srv.logger.Error().Msgf("deleting %s: %v", key, err)
+		}
+	}
+	return resp, nil
+}
+
+// DeletePrefix removes every version of every file in a directory and its
+// subdirectories. At most maxDeleteBatch file versions are deleted per request, in a
+// single database transaction, and More is set in the response if files remain. If
+// DryRun is set, the files which would be deleted are counted instead.
+func (srv *Server) DeletePrefix(ctx context.Context, req *pb.DeletePrefixRequest) (*pb.DeletePrefixResponse, error) {
+	if req.Prefix == "" {
+		return nil, twirp.RequiredArgumentError("prefix")
+	}
+	// Match whole directory names only, so /data does not delete /database. The root
+	// matches every file, and must be "/" rather than "//".
+	dir := strings.TrimSuffix(srv.NormalizeName(req.Prefix), "/") + "/"
+
+	if req.DryRun {
+		stats, err := srv.db.GetPrefixStats(dir)
+		if err != nil {
+			return nil, fmt.Errorf("db GetPrefixStats: %w", err)
+		}
+		return &pb.DeletePrefixResponse{
+			NumFiles:        stats.NumFiles,
+			NumFileVersions: stats.NumFileVersions,
+			TotalSize:       stats.TotalFilesSize,
+		}, nil
+	}
+
+	_, span := tracing.Start(ctx, "db.DeletePrefix", label.String("prefix", dir))
+	deleted, stats, more, err := srv.db.DeletePrefix(dir, maxDeleteBatch)
+	tracing.End(ctx, span, err)
+	if err != nil {
+		return nil, fmt.Errorf("db DeletePrefix: %w", err)
+	}
+	srv.cache.invalidatePrefix(dir)
+	for _, s := range deleted {
+		key := s.AsHex() + ".file"
+		if err := srv.store.Delete(srv.cfg.Bucket, key); err != nil {
+			srv.logger.Error().Msgf("deleting %s: %v", key, err)
+		}
+	}
+	return &pb.DeletePrefixResponse{
+		NumFiles:        stats.NumFiles,
+		NumFileVersions: stats.NumFileVersions,
+		TotalSize:       stats.TotalFilesSize,
+		More:            more,
+	}, nil
+}
+
 // GetChunkerParams returns the chunking parameters that clients should use to chunk
 // files for this server.
 func (srv *Server) GetChunkerParams(ctx context.Context, _ *pb.Empty) (*pb.ChunkerParams, error) {
 	p := srv.cfg.Params
+	twirp.SetHTTPResponseHeader(ctx, chunkAlgorithmHeader, string(srv.ChunkAlgorithm()))
+	twirp.SetHTTPResponseHeader(ctx, chunkHashHeader, string(srv.ChunkHash()))
+	if err := srv.setProfilesHeader(ctx); err != nil {
+		return nil, err
+	}
 	return &pb.ChunkerParams{
 		MinChunkSize:  uint64(p.MinChunkSize),
 		AvgChunkSize:  uint64(p.AvgChunkSize),
 		MaxChunkSize:  uint64(p.MaxChunkSize),
 		Normalization: uint64(p.Normalization),
+
+		PackfileSize:          srv.packfileSize(),
+		PackfileFlushInterval: int64(srv.cfg.PackfileFlushInterval),
+		InlineSize:            srv.cfg.InlineSize,
 	}, nil
 }
 
+// packfileSize returns the size at which clients should upload a packfile.
+func (srv *Server) packfileSize() uint64 {
+	if srv.cfg.PackfileSize == 0 {
+		return defaultPackfileSize
+	}
+	return srv.cfg.PackfileSize
+}
+
 // StartVacuum starts a new vacuum process. Returns a twirp.Unavailable error if
 // a vacuum process is already running. Returns an ID for the vacuum which can be used
 // to check the status of the vacuum.
 func (srv *Server) StartVacuum(ctx context.Context, _ *pb.Empty) (*pb.VacuumID, error) {
-	if !atomic.CompareAndSwapInt32(&srv.isVacuuming, stateNotVacuuming, stateVacuuming) {
-		return nil, twirp.NewError(twirp.Unavailable, "vacuum already in progress")
-	}
-	id, err := srv.db.InsertVacuum(time.Now().UTC())
+	id, err := srv.beginVacuum()
 	if err != nil {
-		return nil, fmt.Errorf("db InsertVacuum: %v", err)
+		return nil, err
 	}
 	go func() {
-		defer atomic.StoreInt32(&srv.isVacuuming, stateNotVacuuming)
 		// Don't use the request context because it will be cancelled when the parent
 		// returns
-		ctx := context.Background()
+		srv.vacuum(context.Background(), id)
+	}()
+	return &pb.VacuumID{Id: id}, nil
+}
 
-		srv.logger.Info().Str("id", id).Msg("Vacuum initiated")
-		start := time.Now()
+// Vacuum runs a vacuum and waits for it to complete. It's recorded in the database
+// like a vacuum started by StartVacuum. Returns the ID of the vacuum, and an error if
+// it failed.
+func (srv *Server) Vacuum(ctx context.Context) (string, error) {
+	id, err := srv.beginVacuum()
+	if err != nil {
+		return "", err
+	}
+	return id, srv.vacuum(ctx, id)
+}
 
-		err := srv.runVacuum(ctx, time.Now())
-		if err != nil {
-			srv.logger.Error().Msgf("vacuum failed: %v", err)
-			if err = srv.db.UpdateVacuum(id, time.Now().UTC(), db.VacuumFailed); err != nil {
-				srv.logger.Error().Str("id", id).Msg(err.Error())
-			}
-			return
-		}
-		if err = srv.db.UpdateVacuum(id, time.Now().UTC(), db.VacuumOK); err != nil {
-			srv.logger.Error().Msg(err.Error())
+// beginVacuum records the start of a vacuum, returning its ID, or returns an error if
+// a vacuum, scrub or rechunk is in progress or the server is shutting down. vacuum
+// must be called with the ID once the vacuum has begun.
+func (srv *Server) beginVacuum() (string, error) {
+	if !srv.beginTask() {
+		return "", twirp.NewError(twirp.Unavailable, "server is shutting down")
+	}
+	if !atomic.CompareAndSwapInt32(&srv.isVacuuming, stateNotVacuuming, stateVacuuming) {
+		srv.tasks.Done()
+		return "", twirp.NewError(twirp.Unavailable, "vacuum, scrub or rechunk already in progress")
+	}
+	id, err := srv.db.InsertVacuum(time.Now().UTC())
+	if err != nil {
+		atomic.StoreInt32(&srv.isVacuuming, stateNotVacuuming)
+		srv.tasks.Done()
+		return "", fmt.Errorf("db InsertVacuum: %v", err)
+	}
+	return id, nil
+}
+
+// vacuum runs the vacuum begun by beginVacuum and records its result.
+func (srv *Server) vacuum(ctx context.Context, id string) error {
+	defer srv.tasks.Done()
+	defer atomic.StoreInt32(&srv.isVacuuming, stateNotVacuuming)
+
+	srv.logger.Info().Str("id", id).Msg("Vacuum initiated")
+	start := time.Now()
+
+	err := srv.runVacuum(ctx, time.Now())
+	if err != nil {
+		srv.logger.Error().Msgf("vacuum failed: %v", err)
+		if uerr := srv.db.UpdateVacuum(id, time.Now().UTC(), db.VacuumFailed); uerr != nil {
+			srv.logger.Error().Str("id", id).Msg(uerr.Error())
 		}
+		return err
+	}
+	if err = srv.db.UpdateVacuum(id, time.Now().UTC(), db.VacuumOK); err != nil {
+		srv.logger.Error().Msg(err.Error())
+	}
 
-		elapsed := time.Since(start).Milliseconds()
-		srv.logger.Info().Str("id", id).Int64("elapsed", elapsed).Msg("Vacuum complete")
-	}()
-	return &pb.VacuumID{Id: id}, nil
+	elapsed := time.Since(start).Milliseconds()
+	srv.logger.Info().Str("id", id).Int64("elapsed", elapsed).Msg("Vacuum complete")
+	return nil
 }
 
 // VacuumStatus returns the status of a vacuum process with a given ID. Returns a
@@ -607,27 +2085,79 @@ func (srv *Server) VacuumStatus(ctx context.Context, id *pb.VacuumID) (*pb.Vacuu
 	}, nil
 }
 
+// VacuumRunning returns true if a vacuum is in progress.
+func (srv *Server) VacuumRunning() bool {
+	return atomic.LoadInt32(&srv.isVacuuming) == stateVacuuming
+}
+
 // ServerStats returns summary statistics for the server.
-func (srv *Server) ServerStats(ctx context.Context, _ *pb.Empty) (*pb.Stats, error) {
+func (srv *Server) ServerStats(ctx context.Context, req *pb.Empty) (*pb.Stats, error) {
+	resp, err := srv.cache.do(cacheKey("ServerStats", req), "", func() (proto.Message, error) {
+		return srv.serverStats()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.Stats), nil
+}
+
+func (srv *Server) serverStats() (*pb.Stats, error) {
 	stats, err := srv.db.GetServerStats()
 	if err != nil {
 		return nil, fmt.Errorf("db GetServerStats: %w", err)
 	}
-	return &pb.Stats{
+	res := &pb.Stats{
 		NumFiles:        stats.NumFiles,
 		NumFileVersions: stats.NumFileVersions,
 		TotalFilesSize:  stats.TotalFilesSize,
 		TotalDataSize:   stats.TotalDataSize,
-	}, nil
+		NumChunks:       stats.NumChunks,
+		NumPacks:        stats.NumPacks,
+		TotalPacksSize:  stats.TotalPacksSize,
+	}
+	if stats.TotalPacksSize > 0 {
+		res.DedupRatio = float64(stats.TotalFilesSize) / float64(stats.TotalPacksSize)
+	}
+	return res, nil
 }
 
 // internalError writes a generic internal server error message to a HTTP response, and
-// logs the actual error.
+// logs the actual error. Errors from a store which is unavailable are written with a
+// 503 status instead.
 func internalError(w http.ResponseWriter, e error) {
+	if errors.Is(e, store.ErrUnavailable) {
+		// The store's circuit breaker is open, so the client should retry later
+		w.Header().Set("Retry-After", storeRetryAfter)
+		http.Error(w, e.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	http.Error(w, "internal server error", http.StatusInternalServerError)
 	log.Error(e)
 }
 
+// httpError writes the response of a failed http request. Twirp errors are returned
+// with their HTTP status, and other errors as with internalError.
+func httpError(w http.ResponseWriter, err error) {
+	var terr twirp.Error
+	if errors.As(err, &terr) && terr.Code() != twirp.Internal {
+		http.Error(w, terr.Msg(), twirp.ServerHTTPStatusFromErrorCode(terr.Code()))
+		return
+	}
+	internalError(w, err)
+}
+
+// writeJSON writes a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
 // cleanFilename processes a filename to be stored in the database. Trailing slashes are
 // removed and a leading slash is prefixed if not already present.
 func cleanFilename(name string) string {
@@ -645,17 +2175,6 @@ func cleanFilename(name string) string {
 	return name
 }
 
-// validateFilename returns an error if the file name is invalid.
-func validateFilename(name string) error {
-	if len(name) > maxFilenameSize {
-		return fmt.Errorf("filename exceeds maximum size %d", maxFilenameSize)
-	}
-	if name == "" || name == "/" {
-		return errors.New("invalid filename")
-	}
-	return nil
-}
-
 func mergeErrors(err, minor error) error {
 	if err == nil && minor == nil {
 		return nil
@@ -1,18 +1,30 @@
 package server
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/jotfs/jotfs/internal/compress"
 	"github.com/jotfs/jotfs/internal/db"
 	"github.com/jotfs/jotfs/internal/object"
@@ -76,16 +88,26 @@ func TestPackfileUploadHandlerBadRequest(t *testing.T) {
 	}
 
 	// Bad content length
-	lengths := []int64{0, -1, maxPackfileSize + 1}
-	for _, l := range lengths {
+	lengths := map[int64]int{
+		0:                   http.StatusBadRequest,
+		-1:                  http.StatusBadRequest,
+		maxPackfileSize + 1: http.StatusRequestEntityTooLarge,
+	}
+	for l, code := range lengths {
 		req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
 		req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
 		req.ContentLength = l
-		assert.Equal(t, http.StatusBadRequest, packfileUploadStatus(req))
+		assert.Equal(t, code, packfileUploadStatus(req))
 	}
 
-	// Missing checksum
+	// A packfile longer than its content length
 	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+	req.ContentLength = int64(len(packfile)) - 1
+	assert.Equal(t, http.StatusBadRequest, packfileUploadStatus(req))
+
+	// Missing checksum
+	req = httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
 	assert.Equal(t, http.StatusBadRequest, packfileUploadStatus(req))
 
 	// Checksum does not match
@@ -101,6 +123,104 @@ func TestPackfileUploadHandlerBadRequest(t *testing.T) {
 
 }
 
+// brokenBody is a request body which returns an error after its data has been read,
+// as when the client disconnects.
+type brokenBody struct {
+	r io.Reader
+}
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func TestPackfileUploadAborted(t *testing.T) {
+	srv, store, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+
+	upload := func(body io.Reader) int {
+		req := httptest.NewRequest("POST", "/packfile", body)
+		req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+		req.ContentLength = int64(len(packfile))
+		w := httptest.NewRecorder()
+		srv.PackfileUploadHandler(w, req)
+		return w.Result().StatusCode
+	}
+
+	// The client disconnects half way through the upload
+	upload(&brokenBody{bytes.NewReader(packfile[:len(packfile)/2])})
+	assert.Empty(t, store.data[""])
+	count, aborts := srv.AbortedUploads()
+	assert.Equal(t, uint64(1), count)
+	if assert.Len(t, aborts, 1) {
+		assert.Equal(t, s.AsHex(), aborts[0].Sum)
+		assert.Equal(t, int64(len(packfile)), aborts[0].Size)
+		assert.Equal(t, int64(len(packfile)/2), aborts[0].Received)
+		assert.Contains(t, aborts[0].Reason, "connection reset by peer")
+	}
+
+	// A store failure is not a client error
+	srv.store = &failPutStore{store}
+	assert.Equal(t, http.StatusInternalServerError, upload(bytes.NewReader(packfile)))
+	count, _ = srv.AbortedUploads()
+	assert.Equal(t, uint64(1), count)
+
+	// The upload succeeds once the client retries
+	srv.store = store
+	assert.Equal(t, http.StatusCreated, upload(bytes.NewReader(packfile)))
+	assert.Len(t, store.data[""], 2)
+}
+
+func TestUploadAdmission(t *testing.T) {
+	assert.Nil(t, newUploadAdmission(0, 0))
+
+	// Uploads over either limit are rejected
+	a := newUploadAdmission(2, 100)
+	assert.Empty(t, a.admit(60))
+	assert.Contains(t, a.admit(50), "60 bytes of packfile uploads are in progress")
+	assert.Empty(t, a.admit(40))
+	assert.Contains(t, a.admit(1), "2 packfile uploads are in progress")
+	a.release(60)
+	a.release(40)
+	assert.Equal(t, UploadAdmissionStats{Rejected: 2}, a.stats)
+
+	// An upload larger than the byte limit is admitted on its own
+	assert.Empty(t, a.admit(200))
+	a.release(200)
+
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	_, ok := srv.UploadAdmissionStats()
+	assert.False(t, ok)
+	srv.admission = newUploadAdmission(1, 0)
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+	upload := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+		req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+		w := httptest.NewRecorder()
+		srv.PackfileUploadHandler(w, req)
+		return w
+	}
+
+	// The server is busy while another upload is in progress
+	assert.Empty(t, srv.admission.admit(10))
+	w := upload()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, uploadRetryAfter, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "server busy")
+	srv.admission.release(10)
+	assert.Equal(t, http.StatusCreated, upload().Code)
+	stats, ok := srv.UploadAdmissionStats()
+	assert.True(t, ok)
+	assert.Equal(t, UploadAdmissionStats{Rejected: 1}, stats)
+}
+
 func TestCreateFile(t *testing.T) {
 	srv, _, dbname := testServer(t, true)
 	defer os.Remove(dbname)
@@ -151,6 +271,168 @@ func TestCreateFile(t *testing.T) {
 	assert.Nil(t, f)
 }
 
+func TestCreateFileIdempotent(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+	w := httptest.NewRecorder()
+	srv.PackfileUploadHandler(w, req)
+	assert.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	ctx := context.Background()
+	file := &pb.File{Name: "test.txt", Sums: [][]byte{aSum[:], bSum[:]}, IdempotencyKey: "abc"}
+	f1, err := srv.CreateFile(ctx, file)
+	assert.NoError(t, err)
+
+	// A retried request returns the same file version
+	f2, err := srv.CreateFile(ctx, file)
+	assert.NoError(t, err)
+	assert.Equal(t, f1.Sum, f2.Sum)
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "test.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, head.Info, 1)
+
+	// A different key creates a new version
+	f3, err := srv.CreateFile(ctx, &pb.File{Name: file.Name, Sums: file.Sums, IdempotencyKey: "def"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, f1.Sum, f3.Sum)
+
+	// The key may be reused once the version it created is deleted
+	_, err = srv.Delete(ctx, &pb.FileID{Sum: f1.Sum})
+	assert.NoError(t, err)
+	f4, err := srv.CreateFile(ctx, file)
+	assert.NoError(t, err)
+	assert.NotEqual(t, f1.Sum, f4.Sum)
+}
+
+func TestPackfileUploadIdempotent(t *testing.T) {
+	srv, store, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+	upload := func(body io.Reader, key string) int {
+		req := httptest.NewRequest("POST", "/packfile", body)
+		req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+		req.Header.Set(idempotencyKeyHeader, key)
+		req.ContentLength = int64(len(packfile))
+		w := httptest.NewRecorder()
+		srv.PackfileUploadHandler(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusCreated, upload(bytes.NewReader(packfile), "abc"))
+
+	// A retry is acknowledged without reading the packfile
+	store.data[""] = nil
+	assert.Equal(t, http.StatusCreated, upload(&brokenBody{strings.NewReader("")}, "abc"))
+	assert.Empty(t, store.data[""])
+	packs, err := srv.db.ListPacks()
+	assert.NoError(t, err)
+	assert.Len(t, packs, 1)
+
+	// A long key is rejected
+	assert.Equal(t, http.StatusBadRequest, upload(bytes.NewReader(packfile), strings.Repeat("k", maxIdempotencyKeyLength+1)))
+}
+
+// testReplicator records the file versions replicated to it, and fails while failures
+// is positive.
+type testReplicator struct {
+	ids      []sum.Sum
+	failures int
+}
+
+func (r *testReplicator) Replicate(ctx context.Context, id sum.Sum) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("replica unreachable")
+	}
+	r.ids = append(r.ids, id)
+	return nil
+}
+
+func TestSyncReplication(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	replicator := &testReplicator{failures: 1}
+	srv.SetReplicator(replicator)
+	srv.cfg.SyncReplication = NewSyncPrefixes([]string{"critical/"})
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+
+	// The version is created, but the request fails until it's replicated
+	file := &pb.File{Name: "/critical/a.txt", Sums: sums, IdempotencyKey: "abc"}
+	_, err := srv.CreateFile(ctx, file)
+	assert.Equal(t, twirp.Unavailable, err.(twirp.Error).Code())
+	assert.Empty(t, replicator.ids)
+	id, err := srv.CreateFile(ctx, file)
+	assert.NoError(t, err)
+	s, err := sum.FromBytes(id.Sum)
+	assert.NoError(t, err)
+	assert.Equal(t, []sum.Sum{s}, replicator.ids)
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: file.Name, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, head.Info, 1)
+
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/critical", Sums: sums})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/critical2/a.txt", Sums: sums})
+	assert.NoError(t, err)
+	assert.Len(t, replicator.ids, 2)
+}
+
+func TestPreconditions(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+
+	// Create-only
+	f1, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, IfNotExists: true})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, IfNotExists: true})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+
+	// If-Match
+	f2, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, IfMatch: f1.Sum})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, IfMatch: f1.Sum})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/b.txt", Sums: sums, IfMatch: f1.Sum})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, head.Info, 2)
+
+	// A retried request succeeds even though the precondition no longer holds
+	file := &pb.File{Name: "/a.txt", Sums: sums, IfMatch: f2.Sum, IdempotencyKey: "abc"}
+	f3, err := srv.CreateFile(ctx, file)
+	assert.NoError(t, err)
+	f4, err := srv.CreateFile(ctx, file)
+	assert.NoError(t, err)
+	assert.Equal(t, f3.Sum, f4.Sum)
+
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, IfMatch: f1.Sum, IfNotExists: true})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, IfMatch: []byte{1}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// Conditional delete
+	_, err = srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{Names: []string{"/a.txt"}, IfMatch: map[string][]byte{"/a.txt": f2.Sum}})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+	_, err = srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{Names: []string{"/a.txt"}, IfMatch: map[string][]byte{"/b.txt": f3.Sum}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	head, err = srv.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, head.Info, 3)
+	resp, err := srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{Names: []string{"a.txt"}, IfMatch: map[string][]byte{"a.txt": f3.Sum}})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Deleted, 3)
+}
+
 func TestList(t *testing.T) {
 	srv, _, dbname := testServer(t, true)
 	defer os.Remove(dbname)
@@ -180,6 +462,15 @@ func TestList(t *testing.T) {
 	assert.Equal(t, int64(-1), resp.NextPageToken)
 	assert.Equal(t, []string{"/data/test3.doc"}, getNames(resp.Info))
 
+	// Pagination in descending order
+	resp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/data/test3.doc", "/data/test2.txt"}, getNames(resp.Info))
+	resp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 2, NextPageToken: resp.NextPageToken})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.NextPageToken)
+	assert.Equal(t, []string{"/test.txt"}, getNames(resp.Info))
+
 	// Include / Exclude params
 	resp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Exclude: "data/*", Include: "*.doc"})
 	assert.NoError(t, err)
@@ -192,6 +483,46 @@ func TestList(t *testing.T) {
 	assert.Equal(t, int64(-1), resp.NextPageToken)
 	assert.Equal(t, []string{}, getNames(resp.Info))
 
+	// Cursor pagination sorted by name
+	var names []string
+	req := &pb.ListRequest{Prefix: "/", Limit: 1, Sort: pb.ListSort_NAME, Ascending: true}
+	for {
+		resp, err = srv.List(ctx, req)
+		assert.NoError(t, err)
+		names = append(names, getNames(resp.Info)...)
+		if resp.NextCursor == "" {
+			break
+		}
+		req.Cursor = resp.NextCursor
+	}
+	assert.Equal(t, []string{"/data/test2.txt", "/data/test3.doc", "/test.txt"}, names)
+
+	// Delimiter groups files into directories
+	resp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Sort: pb.ListSort_NAME, Delimiter: "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/test.txt"}, getNames(resp.Info))
+	assert.Equal(t, []string{"/data/"}, resp.Prefixes)
+	assert.Empty(t, resp.NextCursor)
+
+	// A trailing slash only matches files in the directory
+	createTestFile(t, "/database.txt", srv)
+	resp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/data/", Limit: 10, Sort: pb.ListSort_SIZE})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/data/test2.txt", "/data/test3.doc"}, getNames(resp.Info))
+
+	// Invalid requests
+	_, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Delimiter: "/"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Sort: 10})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Cursor: "abc"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Sort: pb.ListSort_NAME, NextPageToken: 1})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	resp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 1, Sort: pb.ListSort_NAME})
+	assert.NoError(t, err)
+	_, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 1, Sort: pb.ListSort_SIZE, Cursor: resp.NextCursor})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
 }
 
 func TestHead(t *testing.T) {
@@ -246,6 +577,192 @@ func TestDownload(t *testing.T) {
 	// Error if file doesn't exist
 	_, err = srv.Download(ctx, &pb.FileID{Sum: make([]byte, sum.Size)})
 	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	// Read the file through the server
+	buf := new(bytes.Buffer)
+	fileID, err := sum.FromBytes(f.Sum)
+	assert.NoError(t, err)
+	assert.NoError(t, srv.WriteFile(ctx, fileID, buf))
+	expected := append(append(append(append([]byte{}, a...), b...), b...), a...)
+	assert.Equal(t, expected, buf.Bytes())
+	err = srv.WriteFile(ctx, sum.Sum{}, buf)
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	// Consecutive chunks are read with a single ranged request. The file's chunks are
+	// a, b, b, a and the packfile holds a then b.
+	rs := &rangeStore{Store: srv.store}
+	srv.store = rs
+	buf.Reset()
+	assert.NoError(t, srv.WriteFile(ctx, fileID, buf))
+	assert.Equal(t, expected, buf.Bytes())
+	if assert.Len(t, rs.ranges, 3) {
+		assert.Equal(t, rs.ranges[2].From, rs.ranges[0].From)
+		assert.Equal(t, rs.ranges[1].To, rs.ranges[0].To)
+		assert.True(t, rs.ranges[1].From > rs.ranges[2].To)
+	}
+}
+
+func TestChunkCache(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	dir, err := ioutil.TempDir("", "jotfs-chunk-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv.chunkCache = newChunkCache(dir, 1024*1024, 0, sum.BLAKE3)
+	rs := &rangeStore{Store: srv.store}
+	srv.store = rs
+	uploadPackfile(t, srv, genTestPackfile(t))
+
+	// The file's chunks are a, b, b, a. They're first read to compute the file's
+	// checksum, which fetches a and b from the store, and later reads are served from
+	// the cache.
+	f := createTestFile(t, "test.txt", srv)
+	fileID, err := sum.FromBytes(f.Sum)
+	assert.NoError(t, err)
+	assert.Len(t, rs.ranges, 1)
+	expected := append(append(append(append([]byte{}, a...), b...), b...), a...)
+	ctx := context.Background()
+	buf := new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, fileID, buf))
+	assert.Equal(t, expected, buf.Bytes())
+	assert.Len(t, rs.ranges, 1)
+	stats, ok := srv.ChunkCacheStats()
+	assert.True(t, ok)
+	assert.Equal(t, ChunkCacheStats{Chunks: 2, Size: uint64(len(a) + len(b)), Hits: 6, Misses: 2}, stats)
+
+	// The cache is reused after a restart, and a corrupt chunk is fetched again
+	assert.NoError(t, ioutil.WriteFile(srv.chunkCache.path(aSum), []byte("corrupt"), 0644))
+	srv.chunkCache = newChunkCache(dir, 1024*1024, 0, sum.BLAKE3)
+	buf.Reset()
+	assert.NoError(t, srv.WriteFile(ctx, fileID, buf))
+	assert.Equal(t, expected, buf.Bytes())
+	assert.Len(t, rs.ranges, 2)
+	stats, _ = srv.ChunkCacheStats()
+	assert.Equal(t, ChunkCacheStats{Chunks: 2, Size: uint64(len(a) + len(b)), Hits: 3, Misses: 1}, stats)
+
+	// The least recently used chunk is evicted once the cache is full
+	srv.chunkCache = newChunkCache(dir, int64(len(a)+len(b)), 0, sum.BLAKE3)
+	_, ok = srv.chunkCache.get(aSum)
+	assert.True(t, ok)
+	assert.NoError(t, srv.chunkCache.put(sum.Compute([]byte("c")), []byte("c")))
+	stats, _ = srv.ChunkCacheStats()
+	assert.EqualValues(t, 1, stats.Evictions)
+	assert.EqualValues(t, 2, stats.Chunks)
+	assert.False(t, srv.chunkCache.contains(bSum))
+
+	// The least recently used chunks are removed while the disk is low on free space,
+	// and a chunk is not added if there is still no room
+	srv.chunkCache = newChunkCache(dir, 1024*1024, 100, sum.BLAKE3)
+	srv.chunkCache.free = func(string) (uint64, error) { return 100, nil }
+	assert.NoError(t, srv.chunkCache.put(sum.Compute([]byte("d")), []byte("d")))
+	stats, _ = srv.ChunkCacheStats()
+	assert.EqualValues(t, 1, stats.Shed)
+	assert.EqualValues(t, 2, stats.Chunks)
+	assert.EqualValues(t, 100, stats.FreeSpace)
+	assert.True(t, srv.chunkCache.contains(sum.Compute([]byte("d"))))
+	srv.chunkCache.minFree = 1 << 40
+	assert.NoError(t, srv.chunkCache.put(sum.Compute([]byte("e")), []byte("e")))
+	stats, _ = srv.ChunkCacheStats()
+	assert.EqualValues(t, 0, stats.Chunks)
+	assert.False(t, srv.chunkCache.contains(sum.Compute([]byte("e"))))
+
+	// A disabled cache contains nothing
+	srv.chunkCache = nil
+	_, ok = srv.ChunkCacheStats()
+	assert.False(t, ok)
+	assert.NoError(t, srv.WriteFile(ctx, fileID, buf))
+
+	// Params saved before the chunk hash was configurable use BLAKE3
+	cache := New(srv.db, srv.store, Config{ChunkCacheDir: dir}).chunkCache
+	assert.Equal(t, sum.BLAKE3, cache.hash)
+	cache = New(srv.db, srv.store, Config{ChunkCacheDir: dir, Params: ChunkerParams{ChunkHash: "sha256"}}).chunkCache
+	assert.Equal(t, sum.SHA256, cache.hash)
+}
+
+func TestReadAhead(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	f := createTestFile(t, "test.txt", srv)
+	fileID, err := sum.FromBytes(f.Sum)
+	assert.NoError(t, err)
+	slow := &slowStore{Store: srv.store, delay: 50 * time.Millisecond}
+	srv.store = slow
+	srv.cfg.ReadAhead = 2
+
+	// The file's chunks are a, b, b, a, read with 3 ranges which are all fetched at
+	// once
+	ctx := context.Background()
+	buf := new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, fileID, buf))
+	assert.Equal(t, bytes.Join([][]byte{a, b, b, a}, nil), buf.Bytes())
+	assert.Equal(t, 3, slow.maxSeen)
+
+	// Reading stops at the first failed write
+	srv.store = slow.Store
+	err = srv.WriteFile(ctx, fileID, failWriter{})
+	assert.Error(t, err)
+
+	// Ranges read ahead are limited in size
+	const size = 5 * 1024 * 1024
+	indices := make([]db.ChunkIndex, 3)
+	for i := range indices {
+		indices[i].Block = object.BlockInfo{Sum: sum.Compute([]byte{byte(i)}), Offset: uint64(i) * size, Size: size}
+	}
+	assert.Len(t, srv.readAheadRanges(indices), 3)
+	indices[1].Block.Size = 1024
+	indices[2].Block.Offset = size + 1024
+	assert.Len(t, srv.readAheadRanges(indices), 2)
+}
+
+func TestDownloadRange(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	f := createTestFile(t, "test.txt", srv)
+	la, lb := uint64(len(a)), uint64(len(b))
+
+	// sequences returns the chunk sequence numbers in a response
+	sequences := func(resp *pb.DownloadRangeResponse) []uint64 {
+		seqs := make([]uint64, 0)
+		for _, s := range resp.Sections {
+			for _, c := range s.Chunks {
+				seqs = append(seqs, c.Sequence)
+			}
+		}
+		return seqs
+	}
+
+	// The file's chunks are a, b, b, a
+	ctx := context.Background()
+	tests := []struct {
+		offset uint64
+		length uint64
+		start  uint64
+		seqs   []uint64
+	}{
+		{0, 0, 0, []uint64{0, 1, 2, 3}},
+		{0, 1, 0, []uint64{0}},
+		{la - 1, 2, 0, []uint64{0, 1}},
+		{la, lb, la, []uint64{1}},
+		{la + lb, 0, la + lb, []uint64{2, 3}},
+		{la + 2*lb + 1, 1000, la + 2*lb, []uint64{3}},
+		{2 * (la + lb), 0, 2 * (la + lb), []uint64{}},
+	}
+	for _, test := range tests {
+		resp, err := srv.DownloadRange(ctx, &pb.DownloadRangeRequest{Sum: f.Sum, Offset: test.offset, Length: test.length})
+		assert.NoError(t, err)
+		assert.Equal(t, test.start, resp.Offset, test)
+		assert.Equal(t, test.seqs, sequences(resp), test)
+	}
+
+	_, err := srv.DownloadRange(ctx, &pb.DownloadRangeRequest{Sum: make([]byte, sum.Size)})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.DownloadRange(ctx, &pb.DownloadRangeRequest{})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
 }
 
 func TestCopy(t *testing.T) {
@@ -257,6 +774,8 @@ func TestCopy(t *testing.T) {
 
 	// Copy
 	ctx := context.Background()
+	before, err := srv.ServerStats(ctx, &pb.Empty{})
+	assert.NoError(t, err)
 	resp, err := srv.Copy(ctx, &pb.CopyRequest{SrcId: f.Sum, Dst: "/data/test2.txt/"})
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
@@ -264,94 +783,1489 @@ func TestCopy(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"/data/test2.txt"}, getNames(lresp.Info))
 
+	// The copy references the same chunks, so no chunk data is added
+	after, err := srv.ServerStats(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Equal(t, before.NumFiles+1, after.NumFiles)
+	assert.Equal(t, 2*before.TotalFilesSize, after.TotalFilesSize)
+	assert.Equal(t, before.NumChunks, after.NumChunks)
+	assert.Equal(t, before.NumPacks, after.NumPacks)
+	assert.Equal(t, before.TotalPacksSize, after.TotalPacksSize)
+	srcID, err := sum.FromBytes(f.Sum)
+	assert.NoError(t, err)
+	dstID, err := sum.FromBytes(resp.Sum)
+	assert.NoError(t, err)
+	src, dst := new(bytes.Buffer), new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, srcID, src))
+	assert.NoError(t, srv.WriteFile(ctx, dstID, dst))
+	assert.Equal(t, src.Bytes(), dst.Bytes())
+
 	// Error if file does not exist
 	_, err = srv.Copy(ctx, &pb.CopyRequest{SrcId: make([]byte, sum.Size), Dst: "abc"})
 	assert.True(t, isTwirpError(err, twirp.NotFound))
 }
 
-func TestDelete(t *testing.T) {
+func TestRevertToVersion(t *testing.T) {
 	srv, _, dbname := testServer(t, true)
 	defer os.Remove(dbname)
-	packfile := genTestPackfile(t)
-	uploadPackfile(t, srv, packfile)
-	f := createTestFile(t, "test.txt", srv)
-
-	// Delete
+	uploadPackfile(t, srv, genTestPackfile(t))
 	ctx := context.Background()
-	_, err := srv.Delete(ctx, f)
+
+	v1, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:]}, Metadata: map[string]string{"k": "v"}})
 	assert.NoError(t, err)
-	lresp, err := srv.List(ctx, &pb.ListRequest{Prefix: "test.txt", Limit: 10})
+	v2, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{bSum[:]}})
 	assert.NoError(t, err)
-	assert.Empty(t, lresp.Info)
 
-	// Error if file does not exist
-	_, err = srv.Delete(ctx, &pb.FileID{Sum: make([]byte, sum.Size)})
-	assert.True(t, isTwirpError(err, twirp.NotFound))
-}
-
-func TestCleanFilename(t *testing.T) {
-	tests := []struct {
-		input  string
-		output string
-	}{
-		{"/tmp/data.txt", "/tmp/data.txt"},
-		{"tmp/data.txt/", "/tmp/data.txt"},
-		{"", ""},
-		{"data.txt", "/data.txt"},
-		{"/data.txt", "/data.txt"},
-		{"//tmp/data.txt", "/tmp/data.txt"},
+	// The restored version becomes the latest, and keeps the previous versions
+	before, err := srv.ServerStats(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	v3, err := srv.RevertToVersion(ctx, &pb.RevertToVersionRequest{Sum: v1.Sum, IfMatch: v2.Sum})
+	assert.NoError(t, err)
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, head.Info, 3) {
+		assert.Equal(t, v3.Sum, head.Info[0].Sum)
+		assert.Equal(t, uint64(len(a)), head.Info[0].Size)
+		assert.Equal(t, map[string]string{"k": "v"}, head.Info[0].Metadata)
 	}
+	after, err := srv.ServerStats(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Equal(t, before.NumChunks, after.NumChunks)
+	assert.Equal(t, before.TotalPacksSize, after.TotalPacksSize)
+	id, err := sum.FromBytes(v3.Sum)
+	assert.NoError(t, err)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, id, buf))
+	assert.Equal(t, a, buf.Bytes())
 
-	for i, test := range tests {
-		assert.Equal(t, test.output, cleanFilename(test.input), i)
+	// Error if the latest version differs, or the version does not exist
+	_, err = srv.RevertToVersion(ctx, &pb.RevertToVersionRequest{Sum: v1.Sum, IfMatch: v2.Sum})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+	_, err = srv.RevertToVersion(ctx, &pb.RevertToVersionRequest{Sum: make([]byte, sum.Size)})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.RevertToVersion(ctx, &pb.RevertToVersionRequest{})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// Without versioning, the restored version replaces the latest version
+	srv, _, dbname = testServer(t, false)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	v1, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+	v2, err = srv.RevertToVersion(ctx, &pb.RevertToVersionRequest{Sum: v1.Sum})
+	assert.NoError(t, err)
+	head, err = srv.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, head.Info, 1) {
+		assert.Equal(t, v2.Sum, head.Info[0].Sum)
 	}
 }
 
-func TestGetChunkerParams(t *testing.T) {
+func TestAppend(t *testing.T) {
 	srv, _, dbname := testServer(t, true)
 	defer os.Remove(dbname)
-
+	uploadPackfile(t, srv, genTestPackfile(t))
 	ctx := context.Background()
-	params, err := srv.GetChunkerParams(ctx, &pb.Empty{})
+
+	// The new version shares the chunks of the version it extends, and its metadata
+	v1, err := srv.CreateFile(ctx, &pb.File{Name: "/log.txt", Sums: [][]byte{aSum[:]}, Metadata: map[string]string{"k": "v"}})
 	assert.NoError(t, err)
-	assert.NotNil(t, params)
+	v2, err := srv.Append(ctx, &pb.AppendRequest{Name: "log.txt", Sums: [][]byte{bSum[:], aSum[:]}, IfMatch: v1.Sum, IdempotencyKey: "k1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/log.txt", v2.Name)
+	resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: v2.Sum})
+	assert.NoError(t, err)
+	expected := append(append(append([]byte{}, a...), b...), a...)
+	checksum := sha256.Sum256(expected)
+	assert.Equal(t, uint64(len(expected)), resp.Info.Size)
+	assert.Equal(t, map[string]string{"k": "v"}, resp.Info.Metadata)
+	assert.Equal(t, checksum[:], resp.Checksums["sha256"])
+	id, err := sum.FromBytes(v2.Sum)
+	assert.NoError(t, err)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, id, buf))
+	assert.Equal(t, expected, buf.Bytes())
+
+	// A retried request returns the version created by the original request
+	retried, err := srv.Append(ctx, &pb.AppendRequest{Name: "/log.txt", Sums: [][]byte{bSum[:], aSum[:]}, IfMatch: v1.Sum, IdempotencyKey: "k1"})
+	assert.NoError(t, err)
+	assert.Equal(t, v2.Sum, retried.Sum)
+
+	// Error if the latest version differs, the file or a chunk does not exist, or no
+	// chunks are appended
+	_, err = srv.Append(ctx, &pb.AppendRequest{Name: "/log.txt", Sums: [][]byte{aSum[:]}, IfMatch: v1.Sum})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+	_, err = srv.Append(ctx, &pb.AppendRequest{Name: "/missing.txt", Sums: [][]byte{aSum[:]}})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.Append(ctx, &pb.AppendRequest{Name: "/log.txt", Sums: [][]byte{make([]byte, sum.Size)}})
+	assert.True(t, isTwirpError(err, twirp.FailedPrecondition))
+	_, err = srv.Append(ctx, &pb.AppendRequest{Name: "/log.txt"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// A version stored inline is saved to a packfile first. Without versioning, the
+	// extended version is replaced.
+	srv, _, dbname = testServer(t, false)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	srv.cfg.InlineSize = 16
+	small := []byte("first line\n")
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/log.txt", Data: small})
+	assert.NoError(t, err)
+	v2, err = srv.Append(ctx, &pb.AppendRequest{Name: "/log.txt", Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/log.txt", Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, head.Info, 1) {
+		assert.Equal(t, v2.Sum, head.Info[0].Sum)
+	}
+	id, err = sum.FromBytes(v2.Sum)
+	assert.NoError(t, err)
+	buf.Reset()
+	assert.NoError(t, srv.WriteFile(ctx, id, buf))
+	assert.Equal(t, append(append([]byte{}, small...), a...), buf.Bytes())
 }
 
-func TestVacuumEmpty(t *testing.T) {
+func TestConcat(t *testing.T) {
 	srv, _, dbname := testServer(t, true)
 	defer os.Remove(dbname)
-
-	// Start vacuum
+	uploadPackfile(t, srv, genTestPackfile(t))
+	srv.cfg.InlineSize = 16
 	ctx := context.Background()
-	id, err := srv.StartVacuum(ctx, &pb.Empty{})
-	assert.NoError(t, err)
 
-	// Get Vacuum
-	_, err = srv.VacuumStatus(ctx, id)
+	// The new version splices the chunks of the sources, including inline sources,
+	// which may appear more than once
+	part1, err := srv.CreateFile(ctx, &pb.File{Name: "/parts/1", Sums: [][]byte{aSum[:], bSum[:]}})
+	assert.NoError(t, err)
+	part2, err := srv.CreateFile(ctx, &pb.File{Name: "/parts/2", Data: []byte("tail")})
+	assert.NoError(t, err)
+	before, err := srv.ServerStats(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	id, err := srv.Concat(ctx, &pb.ConcatRequest{
+		Dst:         "/whole",
+		SrcIds:      [][]byte{part1.Sum, part2.Sum, part1.Sum},
+		Metadata:    map[string]string{"k": "v"},
+		IfNotExists: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/whole", id.Name)
+	expected := append(append(append(append(append([]byte{}, a...), b...), "tail"...), a...), b...)
+	s, err := sum.FromBytes(id.Sum)
+	assert.NoError(t, err)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, s, buf))
+	assert.Equal(t, expected, buf.Bytes())
+	resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: id.Sum})
+	assert.NoError(t, err)
+	checksum := sha256.Sum256(expected)
+	assert.Equal(t, checksum[:], resp.Checksums["sha256"])
+	assert.Equal(t, map[string]string{"k": "v"}, resp.Info.Metadata)
+	after, err := srv.ServerStats(ctx, &pb.Empty{})
 	assert.NoError(t, err)
+	assert.Equal(t, before.NumChunks+1, after.NumChunks)
 
-	// Error if vaccuum does not exist
-	_, err = srv.VacuumStatus(ctx, &pb.VacuumID{Id: "abc"})
+	// Error if a precondition fails, or a source does not exist
+	_, err = srv.Concat(ctx, &pb.ConcatRequest{Dst: "/whole", SrcIds: [][]byte{part1.Sum}, IfNotExists: true})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+	_, err = srv.Concat(ctx, &pb.ConcatRequest{Dst: "/other", SrcIds: [][]byte{part1.Sum, make([]byte, sum.Size)}})
 	assert.True(t, isTwirpError(err, twirp.NotFound))
-
+	_, err = srv.Concat(ctx, &pb.ConcatRequest{Dst: "/other"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
 }
 
-func TestVacuum(t *testing.T) {
+func TestDelete(t *testing.T) {
 	srv, _, dbname := testServer(t, true)
 	defer os.Remove(dbname)
 	packfile := genTestPackfile(t)
 	uploadPackfile(t, srv, packfile)
+	f := createTestFile(t, "test.txt", srv)
 
-	// Create 2 files:
-	// File 1: contains a & b
-	// File 2: contains just a
-	// If we delete file 1 and run a vacuum we should be left with a packfile containing
-	// just data a
+	// Delete
 	ctx := context.Background()
-	f1, err := srv.CreateFile(ctx, &pb.File{
-		Name: "file1",
-		Sums: [][]byte{aSum[:], bSum[:]},
-	})
+	_, err := srv.Delete(ctx, f)
+	assert.NoError(t, err)
+	lresp, err := srv.List(ctx, &pb.ListRequest{Prefix: "test.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, lresp.Info)
+
+	// Error if file does not exist
+	_, err = srv.Delete(ctx, &pb.FileID{Sum: make([]byte, sum.Size)})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+}
+
+func TestDeleteBatch(t *testing.T) {
+	srv, store, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	a := createTestFile(t, "a.txt", srv)
+	b1 := createTestFile(t, "b.txt", srv)
+	b2 := createTestFile(t, "b.txt", srv)
+	c := createTestFile(t, "c.txt", srv)
+
+	// Delete by sum and by name. Missing sums and names are ignored.
+	ctx := context.Background()
+	resp, err := srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{
+		Sums:  [][]byte{a.Sum, b1.Sum, make([]byte, sum.Size)},
+		Names: []string{"b.txt", "missing.txt"},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{a.Sum, b1.Sum, b2.Sum}, resp.Deleted)
+	lresp, err := srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/c.txt"}, getNames(lresp.Info))
+	for _, id := range [][]byte{a.Sum, b1.Sum, b2.Sum} {
+		assert.NotContains(t, store.data[""], fmt.Sprintf("%x.file", id))
+	}
+	assert.Contains(t, store.data[""], fmt.Sprintf("%x.file", c.Sum))
+
+	// Errors
+	_, err = srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{Sums: [][]byte{{1, 2}}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.DeleteBatch(ctx, &pb.DeleteBatchRequest{Names: make([]string, maxDeleteBatch+1)})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+}
+
+func TestDeletePrefix(t *testing.T) {
+	srv, store, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	a := createTestFile(t, "/data/a.txt", srv)
+	b := createTestFile(t, "/data/sub/b.txt", srv)
+	createTestFile(t, "/data/sub/b.txt", srv)
+	createTestFile(t, "/database.txt", srv)
+
+	ctx := context.Background()
+	aID, err := sum.FromBytes(a.Sum)
+	assert.NoError(t, err)
+	info, err := srv.db.GetFileInfo(aID)
+	assert.NoError(t, err)
+
+	// Dry run does not delete anything
+	resp, err := srv.DeletePrefix(ctx, &pb.DeletePrefixRequest{Prefix: "data/", DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.DeletePrefixResponse{NumFiles: 2, NumFileVersions: 3, TotalSize: 3 * info.Size}, resp)
+	lresp, err := srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, lresp.Info, 4)
+
+	resp, err = srv.DeletePrefix(ctx, &pb.DeletePrefixRequest{Prefix: "/data"})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.DeletePrefixResponse{NumFiles: 2, NumFileVersions: 3, TotalSize: 3 * info.Size}, resp)
+	lresp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/database.txt"}, getNames(lresp.Info))
+	for _, id := range [][]byte{a.Sum, b.Sum} {
+		assert.NotContains(t, store.data[""], fmt.Sprintf("%x.file", id))
+	}
+
+	_, err = srv.DeletePrefix(ctx, &pb.DeletePrefixRequest{})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// The root contains every file
+	createTestFile(t, "/data/c.txt", srv)
+	for _, prefix := range []string{"/", "//"} {
+		resp, err = srv.DeletePrefix(ctx, &pb.DeletePrefixRequest{Prefix: prefix, DryRun: true})
+		assert.NoError(t, err)
+		assert.Equal(t, &pb.DeletePrefixResponse{NumFiles: 2, NumFileVersions: 2, TotalSize: 2 * info.Size}, resp)
+	}
+	resp, err = srv.DeletePrefix(ctx, &pb.DeletePrefixRequest{Prefix: "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.DeletePrefixResponse{NumFiles: 2, NumFileVersions: 2, TotalSize: 2 * info.Size}, resp)
+	lresp, err = srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, lresp.Info)
+}
+
+func TestRename(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	createTestFile(t, "/a.txt", srv)
+	createTestFile(t, "/a.txt", srv)
+	createTestFile(t, "/b.txt", srv)
+	createTestFile(t, "/data/c.txt", srv)
+	createTestFile(t, "/data/sub/d.txt", srv)
+
+	ctx := context.Background()
+	list := func() []string {
+		resp, err := srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10})
+		assert.NoError(t, err)
+		return getNames(resp.Info)
+	}
+
+	// Both versions of the file are renamed
+	resp, err := srv.Rename(ctx, &pb.RenameRequest{Src: "a.txt", Dst: "/x.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), resp.NumFiles)
+	hresp, err := srv.Head(ctx, &pb.HeadRequest{Name: "/x.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, hresp.Info, 2)
+
+	resp, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/data", Dst: "/archive/2020", Prefix: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), resp.NumFiles)
+	assert.ElementsMatch(t, []string{"/x.txt", "/x.txt", "/b.txt", "/archive/2020/c.txt", "/archive/2020/sub/d.txt"}, list())
+
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/x.txt", Dst: "/b.txt"})
+	assert.True(t, isTwirpError(err, twirp.AlreadyExists))
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/a.txt", Dst: "/y.txt"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/data", Dst: "/y", Prefix: true})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/archive", Dst: "/archive/old", Prefix: true})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/x.txt", Dst: "/data/../y.txt"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/x.txt"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// The root cannot be moved
+	_, err = srv.Rename(ctx, &pb.RenameRequest{Src: "/", Dst: "/y", Prefix: true})
+	if assert.True(t, isTwirpError(err, twirp.InvalidArgument)) {
+		assert.Contains(t, err.Error(), "cannot move the root directory")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	for _, name := range []string{
+		"/logs/2024-01/a.gz",
+		"/logs/2024-01/sub/b.gz",
+		"/logs/2024-02/c.txt",
+		"/logs/2023-12/d.gz",
+		"/logs/2024-x.gz",
+		"/data/e.gz",
+	} {
+		createTestFile(t, name, srv)
+	}
+
+	ctx := context.Background()
+	search := func(pattern string, regex bool) []string {
+		resp, err := srv.Search(ctx, &pb.SearchRequest{Pattern: pattern, Regex: regex, Limit: 10})
+		assert.NoError(t, err)
+		assert.Empty(t, resp.NextCursor)
+		return getNames(resp.Info)
+	}
+	assert.Equal(t, []string{"/logs/2024-01/a.gz", "/logs/2024-01/sub/b.gz"}, search("logs/2024-*/**.gz", false))
+	assert.Equal(t, []string{"/logs/2024-x.gz"}, search("/logs/2024-*.gz", false))
+	assert.Equal(t, []string{"/logs/2023-12/d.gz", "/logs/2024-01/a.gz"}, search("/*/*/*.gz", false))
+	assert.Equal(t, []string{"/logs/2023-12/d.gz"}, search(`^/logs/2023-\d+/`, true))
+	assert.Equal(t, []string{"/logs/2024-02/c.txt"}, search(`\.txt$`, true))
+
+	// Paginate with a cursor
+	var names []string
+	req := &pb.SearchRequest{Pattern: "**.gz", Limit: 2}
+	for {
+		resp, err := srv.Search(ctx, req)
+		assert.NoError(t, err)
+		names = append(names, getNames(resp.Info)...)
+		if resp.NextCursor == "" {
+			break
+		}
+		req.Cursor = resp.NextCursor
+	}
+	assert.Len(t, names, 5)
+
+	_, err := srv.Search(ctx, &pb.SearchRequest{Pattern: "[a", Limit: 10})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.Search(ctx, &pb.SearchRequest{Pattern: "(a", Regex: true, Limit: 10})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.Search(ctx, &pb.SearchRequest{Pattern: "*", Limit: 0})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  string
+		match   []string
+		noMatch []string
+	}{
+		{"/logs/*.gz", "/logs/", []string{"/logs/a.gz"}, []string{"/logs/a/b.gz", "/logs/a.gzip"}},
+		{"logs/**", "/logs/", []string{"/logs/a", "/logs/a/b/c"}, []string{"/logsa"}},
+		{"/a/**/b.txt", "/a/", []string{"/a/b.txt", "/a/x/y/b.txt"}, []string{"/a/xb.txt"}},
+		{"/file?.txt", "/file", []string{"/file1.txt"}, []string{"/file.txt", "/file/.txt"}},
+		{"/[a-c]x[!0-9]", "/", []string{"/bxy"}, []string{"/dxy", "/ax1", "/ax/"}},
+		{`/a\*b`, "/a*b", []string{"/a*b"}, []string{"/axb"}},
+		{"/über/*", "/über/", []string{"/über/x"}, []string{"/uber/x"}},
+	}
+	for _, test := range tests {
+		m, err := compileGlob(test.pattern)
+		if !assert.NoError(t, err, test.pattern) {
+			continue
+		}
+		assert.Equal(t, test.prefix, m.prefix, test.pattern)
+		for _, name := range test.match {
+			assert.True(t, m.re.MatchString(name), "%s should match %s", test.pattern, name)
+		}
+		for _, name := range test.noMatch {
+			assert.False(t, m.re.MatchString(name), "%s should not match %s", test.pattern, name)
+		}
+	}
+
+	m, err := compileRegex(`^/logs/\d`)
+	assert.NoError(t, err)
+	assert.Equal(t, "/logs/", m.prefix)
+	m, err = compileRegex(`(?i)^/logs/`)
+	assert.NoError(t, err)
+	assert.Equal(t, "", m.prefix)
+}
+
+func TestGetFileInfo(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	v1 := createTestFile(t, "/a.txt", srv)
+	ctx := context.Background()
+	v2, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{bSum[:]}})
+	assert.NoError(t, err)
+
+	resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "a.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, v2.Sum, resp.Info.Sum)
+	assert.Equal(t, uint64(len(b)), resp.Info.Size)
+	assert.Equal(t, uint64(2), resp.NumVersions)
+	assert.Equal(t, uint64(1), resp.NumChunks)
+	checksum := sum.Compute(bSum[:])
+	assert.Equal(t, checksum[:], resp.Checksum)
+
+	resp, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: v1.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, v1.Sum, resp.Info.Sum)
+	assert.Equal(t, uint64(2), resp.NumVersions)
+	assert.Equal(t, uint64(4), resp.NumChunks)
+	checksum = sum.Compute(bytes.Join([][]byte{aSum[:], bSum[:], bSum[:], aSum[:]}, nil))
+	assert.Equal(t, checksum[:], resp.Checksum)
+
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/b.txt"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	missing := sum.Compute([]byte("missing"))
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: missing[:]})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+}
+
+func TestDiff(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+
+	la, lb := uint64(len(a)), uint64(len(b))
+	v1, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+	v2, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:], bSum[:], bSum[:], aSum[:]}})
+	assert.NoError(t, err)
+	v3, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{bSum[:]}})
+	assert.NoError(t, err)
+
+	resp, err := srv.Diff(ctx, &pb.DiffRequest{OldSum: v1.Sum, NewSum: v2.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.DiffResponse{
+		Added:   []*pb.ByteRange{{Offset: la, Length: 2 * lb, NumChunks: 2}},
+		Removed: []*pb.ByteRange{},
+		OldSize: la,
+		NewSize: 2*la + 2*lb,
+	}, resp)
+
+	resp, err = srv.Diff(ctx, &pb.DiffRequest{OldSum: v2.Sum, NewSum: v3.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb.DiffResponse{
+		Added:   []*pb.ByteRange{},
+		Removed: []*pb.ByteRange{{Offset: 0, Length: la, NumChunks: 1}, {Offset: la + 2*lb, Length: la, NumChunks: 1}},
+		OldSize: 2*la + 2*lb,
+		NewSize: lb,
+	}, resp)
+
+	resp, err = srv.Diff(ctx, &pb.DiffRequest{OldSum: v3.Sum, NewSum: v3.Sum})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Added)
+	assert.Empty(t, resp.Removed)
+
+	_, err = srv.Diff(ctx, &pb.DiffRequest{OldSum: v1.Sum})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.Diff(ctx, &pb.DiffRequest{OldSum: v1.Sum, NewSum: make([]byte, sum.Size)})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+}
+
+func TestDownloadDelta(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+
+	f, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:], bSum[:], bSum[:], aSum[:]}})
+	assert.NoError(t, err)
+	recipe := []*pb.RecipeChunk{
+		{Sum: aSum[:], Size: uint64(len(a))},
+		{Sum: bSum[:], Size: uint64(len(b))},
+		{Sum: bSum[:], Size: uint64(len(b))},
+		{Sum: aSum[:], Size: uint64(len(a))},
+	}
+	sectionSums := func(sections []*pb.Section) [][]byte {
+		var sums [][]byte
+		for _, s := range sections {
+			for _, c := range s.Chunks {
+				sums = append(sums, c.Sum)
+			}
+		}
+		return sums
+	}
+
+	// Each missing chunk is only sent once
+	resp, err := srv.DownloadDelta(ctx, &pb.DownloadDeltaRequest{Sum: f.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, recipe, resp.Recipe)
+	assert.Equal(t, [][]byte{aSum[:], bSum[:]}, sectionSums(resp.Sections))
+
+	resp, err = srv.DownloadDelta(ctx, &pb.DownloadDeltaRequest{Sum: f.Sum, Have: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+	assert.Equal(t, recipe, resp.Recipe)
+	assert.Equal(t, [][]byte{bSum[:]}, sectionSums(resp.Sections))
+	assert.Equal(t, uint64(1), resp.Sections[0].Chunks[0].Sequence)
+
+	resp, err = srv.DownloadDelta(ctx, &pb.DownloadDeltaRequest{Sum: f.Sum, Have: [][]byte{aSum[:], bSum[:]}})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Sections)
+
+	_, err = srv.DownloadDelta(ctx, &pb.DownloadDeltaRequest{Sum: f.Sum, Have: [][]byte{{1}}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.DownloadDelta(ctx, &pb.DownloadDeltaRequest{Sum: make([]byte, sum.Size)})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+}
+
+func TestPackIndexCache(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	dir, err := ioutil.TempDir("", "jotfs-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv.indexCache = newPackIndexCache(dir, srv.db)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	f := createTestFile(t, "/a.txt", srv)
+	ctx := context.Background()
+
+	// The index is written when the packfile is uploaded
+	packSum := sum.Compute(packfile)
+	idxPath := filepath.Join(dir, packSum.AsHex()+".idx")
+	info, err := os.Stat(idxPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(packIndexMagic)+2*packIndexRecordSize), info.Size())
+
+	// Downloads match those located with the database
+	cache := srv.indexCache
+	srv.indexCache = nil
+	expected, err := srv.Download(ctx, f)
+	assert.NoError(t, err)
+	srv.indexCache = cache
+	resp, err := srv.Download(ctx, f)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Sections, resp.Sections)
+
+	// A missing or invalid index is rebuilt from the database
+	for _, write := range []func(){
+		func() { os.Remove(idxPath) },
+		func() { ioutil.WriteFile(idxPath, []byte("invalid"), 0644) },
+	} {
+		write()
+		srv.indexCache = newPackIndexCache(dir, srv.db)
+		resp, err = srv.Download(ctx, f)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.Sections, resp.Sections)
+		info, err := os.Stat(idxPath)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(packIndexMagic)+2*packIndexRecordSize), info.Size())
+	}
+
+	_, err = srv.Download(ctx, &pb.FileID{Sum: make([]byte, sum.Size)})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	assert.NoError(t, srv.indexCache.remove(packSum))
+	_, err = os.Stat(idxPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocationCache(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	f := createTestFile(t, "/a.txt", srv)
+	ctx := context.Background()
+	expected, err := srv.Download(ctx, f)
+	assert.NoError(t, err)
+
+	// The file's chunks are a, b, b, a. The first download locates them with the
+	// database, and later downloads with the cache.
+	srv.locations = newLocationCache(10)
+	for i := 0; i < 2; i++ {
+		resp, err := srv.Download(ctx, f)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.Sections, resp.Sections)
+	}
+	stats, ok := srv.LocationCacheStats()
+	assert.True(t, ok)
+	assert.Equal(t, LocationCacheStats{Entries: 2, Hits: 4, Misses: 4}, stats)
+	size, err := srv.chunkSize(aSum)
+	assert.NoError(t, err)
+	assert.EqualValues(t, len(a), size)
+
+	// Locations are removed with their packfile
+	packSum := sum.Compute(packfile)
+	srv.locations.removePack(packSum)
+	stats, _ = srv.LocationCacheStats()
+	assert.EqualValues(t, 0, stats.Entries)
+
+	// Locations in degraded packfiles are not cached
+	assert.NoError(t, srv.db.MarkPackDegraded(packSum, time.Now()))
+	fileID, err := sum.FromBytes(f.Sum)
+	assert.NoError(t, err)
+	_, err = srv.getFileChunks(ctx, fileID)
+	assert.NoError(t, err)
+	stats, _ = srv.LocationCacheStats()
+	assert.EqualValues(t, 0, stats.Entries)
+
+	// The least recently used locations are removed once the cache is full
+	srv.locations = newLocationCache(1)
+	srv.locations.add([]db.ChunkIndex{{PackSum: packSum, Block: object.BlockInfo{Sum: aSum}}})
+	srv.locations.add([]db.ChunkIndex{{PackSum: packSum, Block: object.BlockInfo{Sum: bSum}}})
+	_, ok = srv.locations.get(aSum, sum.Sum{})
+	assert.False(t, ok)
+	_, ok = srv.locations.get(bSum, packSum)
+	assert.True(t, ok)
+	_, ok = srv.locations.get(bSum, aSum)
+	assert.False(t, ok)
+
+	// A disabled cache locates nothing
+	srv.locations = nil
+	_, ok = srv.LocationCacheStats()
+	assert.False(t, ok)
+}
+
+func TestUploadQueue(t *testing.T) {
+	srv, mstore, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	dir, err := ioutil.TempDir("", "jotfs-upload-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blocking := &blockingStore{Store: mstore, release: make(chan struct{})}
+	srv.store = blocking
+	srv.uploads = newUploadQueue(dir, 4, 0)
+	assert.NoError(t, srv.StartUploadQueue())
+
+	// The upload completes before the store saves the packfile
+	packfile := genTestPackfile(t)
+	packSum := sum.Compute(packfile)
+	uploadPackfile(t, srv, packfile)
+	stats, ok := srv.UploadQueueStats()
+	assert.True(t, ok)
+	assert.Equal(t, UploadQueueStats{Pending: 1, PendingSize: uint64(len(packfile))}, stats)
+	queued, err := ioutil.ReadFile(filepath.Join(dir, packSum.AsHex()+".pack"))
+	assert.NoError(t, err)
+	assert.Equal(t, packfile, queued)
+
+	// The chunks may be used at once, but downloads wait for the packfile
+	f := createTestFile(t, "/a.txt", srv)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = srv.Download(ctx, f)
+	assert.True(t, isTwirpError(err, twirp.Unavailable))
+
+	// Vacuums and scrubs skip queued packfiles
+	res, err := srv.Scrub(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Checked)
+
+	close(blocking.release)
+	_, err = srv.Download(context.Background(), f)
+	assert.NoError(t, err)
+	stats, _ = srv.UploadQueueStats()
+	assert.Equal(t, UploadQueueStats{Uploaded: 1}, stats)
+
+	// Uploads are rejected while the disk is low on free space
+	srv.uploads.minFree = 1024
+	srv.uploads.free = func(string) (uint64, error) { return 1024, nil }
+	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(packSum[:]))
+	w := httptest.NewRecorder()
+	srv.PackfileUploadHandler(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, uploadRetryAfter, w.Header().Get("Retry-After"))
+	stats, _ = srv.UploadQueueStats()
+	assert.Equal(t, UploadQueueStats{Uploaded: 1, Rejected: 1, FreeSpace: 1024}, stats)
+	srv.uploads.minFree = 0
+	assert.Equal(t, packfile, mstore.data[""][packSum.AsHex()+".pack"])
+	assert.Contains(t, mstore.data[""], packSum.AsHex()+".index")
+	assert.NoError(t, srv.Shutdown(context.Background()))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	// Queued uploads are resumed by a new server, and incomplete ones are removed
+	delete(mstore.data[""], packSum.AsHex()+".pack")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, packSum.AsHex()+".pack"), packfile, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, packSum.AsHex()+".index"), []byte("index"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tmp-123"), []byte("partial"), 0644))
+	other := sum.Compute([]byte("other"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, other.AsHex()+".pack"), []byte("other"), 0644))
+	srv = New(srv.db, mstore, Config{})
+	srv.uploads = newUploadQueue(dir, 4, 0)
+	assert.NoError(t, srv.StartUploadQueue())
+	assert.NoError(t, srv.Shutdown(context.Background()))
+	assert.Equal(t, packfile, mstore.data[""][packSum.AsHex()+".pack"])
+	files, err = ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	// A disabled queue has no stats
+	srv.uploads = nil
+	_, ok = srv.UploadQueueStats()
+	assert.False(t, ok)
+}
+
+func TestPackJournal(t *testing.T) {
+	srv, mstore, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	path := dbname + ".packs"
+	defer os.Remove(path)
+	srv.cfg.PackJournal = path
+	n, err := srv.RecoverPackJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Uploads resolve their journal entries
+	packfile := genTestPackfile(t)
+	packSum := sum.Compute(packfile)
+	uploadPackfile(t, srv, packfile)
+	assert.Empty(t, srv.journal.open)
+	journal, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "put "+packSum.AsHex()+"\ndone "+packSum.AsHex()+"\n", string(journal))
+	assert.NoError(t, srv.Close())
+
+	// Unresolved operations are resolved by a new server
+	orphan := sum.Compute([]byte("orphan"))
+	mstore.data[""][orphan.AsHex()+".pack"] = []byte("orphan")
+	mstore.data[""][orphan.AsHex()+".index"] = []byte("index")
+	other := sum.Compute([]byte("other"))
+	mstore.data[""][other.AsHex()+".pack"] = []byte("other")
+	lines := []string{
+		"put " + orphan.AsHex(),
+		"delete " + packSum.AsHex(),
+		"put " + other.AsHex(),
+		"done " + other.AsHex(),
+		"delete 1234",
+	}
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644))
+	srv = New(srv.db, mstore, Config{PackJournal: path})
+	n, err = srv.RecoverPackJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NotContains(t, mstore.data[""], orphan.AsHex()+".pack")
+	assert.NotContains(t, mstore.data[""], orphan.AsHex()+".index")
+	assert.NotContains(t, mstore.data[""], packSum.AsHex()+".pack")
+	assert.NotContains(t, mstore.data[""], packSum.AsHex()+".index")
+	_, err = srv.db.GetPackInfo(packSum)
+	assert.True(t, errors.Is(err, db.ErrNotFound))
+	assert.Contains(t, mstore.data[""], other.AsHex()+".pack")
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	// A failed upload removes the objects it saved
+	srv.store = &failPutStore{mstore}
+	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(packSum[:]))
+	w := httptest.NewRecorder()
+	srv.PackfileUploadHandler(w, req)
+	assert.NotEqual(t, http.StatusCreated, w.Result().StatusCode)
+	assert.Empty(t, srv.journal.open)
+	assert.NotContains(t, mstore.data[""], packSum.AsHex()+".pack")
+	assert.NoError(t, srv.Close())
+
+	// Without a journal, recovery does nothing
+	srv = New(srv.db, mstore, Config{})
+	n, err = srv.RecoverPackJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, srv.Close())
+}
+
+func TestChecksums(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	srv.cfg.Checksums = []string{"md5", "sha1"}
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+
+	data := bytes.Join([][]byte{a, b, b, a}, nil)
+	md5Sum := md5.Sum(data)
+	sha1Sum := sha1.Sum(data)
+	sha256Sum := sha256.Sum256(data)
+	expected := map[string][]byte{"md5": md5Sum[:], "sha1": sha1Sum[:], "sha256": sha256Sum[:]}
+
+	f := createTestFile(t, "/a.txt", srv)
+	resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: f.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, resp.Checksums)
+
+	// Copies have the same checksums
+	cp, err := srv.Copy(ctx, &pb.CopyRequest{SrcId: f.Sum, Dst: "/b.txt"})
+	assert.NoError(t, err)
+	resp, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: cp.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, resp.Checksums)
+
+	// Only SHA-256 is computed if no other checksums are configured
+	srv.cfg.Checksums = nil
+	f = createTestFile(t, "/c.txt", srv)
+	resp, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: f.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"sha256": sha256Sum[:]}, resp.Checksums)
+
+	// Expected checksums supplied by the client are verified
+	sums := [][]byte{aSum[:], bSum[:], bSum[:], aSum[:]}
+	id, err := srv.CreateFile(ctx, &pb.File{Name: "/d.txt", Sums: sums, Checksums: map[string][]byte{"sha256": sha256Sum[:], "md5": md5Sum[:]}})
+	assert.NoError(t, err)
+	resp, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: id.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"md5": md5Sum[:], "sha256": sha256Sum[:]}, resp.Checksums)
+
+	wrong := sha256.Sum256(data[1:])
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/e.txt", Sums: sums, Checksums: map[string][]byte{"sha256": wrong[:]}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/e.txt", Sums: sums, Checksums: map[string][]byte{"crc32": {1, 2, 3, 4}}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/e.txt", Limit: 1})
+	assert.NoError(t, err)
+	assert.Empty(t, head.Info)
+
+	assert.NoError(t, CheckChecksumAlgorithms([]string{"sha1", "md5"}))
+	assert.Error(t, CheckChecksumAlgorithms([]string{"crc32"}))
+}
+
+func TestCreateFileByReference(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+
+	data := bytes.Join([][]byte{a, b, b, a}, nil)
+	sha256Sum := sha256.Sum256(data)
+	md5Sum := md5.Sum(data)
+	f := createTestFile(t, "/a.txt", srv)
+
+	// A version of data already stored is created without its chunks
+	id, err := srv.CreateFile(ctx, &pb.File{Name: "/b.txt", Checksums: map[string][]byte{"sha256": sha256Sum[:]}, Metadata: map[string]string{"k": "v"}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, f.Sum, id.Sum)
+	resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: id.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), resp.Info.Size)
+	assert.Equal(t, map[string]string{"k": "v"}, resp.Info.Metadata)
+	s, err := sum.FromBytes(id.Sum)
+	assert.NoError(t, err)
+	file, err := srv.db.GetFile(s)
+	assert.NoError(t, err)
+	assert.Len(t, file.Chunks, 4)
+
+	// Checksums the referenced version doesn't have are computed and verified
+	srv.cfg.Checksums = []string{"md5"}
+	id, err = srv.CreateFile(ctx, &pb.File{Name: "/c.txt", Checksums: map[string][]byte{"sha256": sha256Sum[:]}})
+	assert.NoError(t, err)
+	resp, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: id.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"md5": md5Sum[:], "sha256": sha256Sum[:]}, resp.Checksums)
+	wrong := md5.Sum(data[1:])
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/d.txt", Checksums: map[string][]byte{"sha256": sha256Sum[:], "md5": wrong[:]}})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// Data not stored is reported as not found, and nothing is created
+	missing := sha256.Sum256(data[1:])
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/d.txt", Checksums: map[string][]byte{"sha256": missing[:]}})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/d.txt", Limit: 1})
+	assert.NoError(t, err)
+	assert.Empty(t, head.Info)
+
+	// An empty file is created as usual
+	empty := sha256.Sum256(nil)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/e.txt", Checksums: map[string][]byte{"sha256": empty[:]}})
+	assert.NoError(t, err)
+}
+
+func TestInlineFile(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	ctx := context.Background()
+	data := []byte("small file")
+	checksum := sha256.Sum256(data)
+
+	// Inline storage is disabled by default
+	_, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Data: data})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	srv.cfg.InlineSize = 16
+	srv.locations = newLocationCache(10)
+	params, err := srv.GetChunkerParams(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(16), params.InlineSize)
+	id, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Data: data, Checksums: map[string][]byte{"sha256": checksum[:]}})
+	assert.NoError(t, err)
+	resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: id.Sum})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), resp.Info.Size)
+	assert.Equal(t, checksum[:], resp.Checksums["sha256"])
+
+	// The data is downloaded with the response, and never cached as a chunk location
+	for i := 0; i < 2; i++ {
+		dl, err := srv.Download(ctx, &pb.FileID{Sum: id.Sum})
+		assert.NoError(t, err)
+		if assert.Len(t, dl.Sections, 1) {
+			assert.Equal(t, data, dl.Sections[0].Data)
+			assert.Empty(t, dl.Sections[0].Url)
+			assert.Len(t, dl.Sections[0].Chunks, 1)
+		}
+	}
+	stats, _ := srv.LocationCacheStats()
+	assert.Equal(t, uint64(0), stats.Entries)
+	s, err := sum.FromBytes(id.Sum)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, srv.WriteFile(ctx, s, &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// Copies and versions created by reference are stored inline too
+	cp, err := srv.Copy(ctx, &pb.CopyRequest{SrcId: id.Sum, Dst: "/b.txt"})
+	assert.NoError(t, err)
+	ref, err := srv.CreateFile(ctx, &pb.File{Name: "/c.txt", Checksums: map[string][]byte{"sha256": checksum[:]}})
+	assert.NoError(t, err)
+	for _, b := range [][]byte{cp.Sum, ref.Sum} {
+		s, err := sum.FromBytes(b)
+		assert.NoError(t, err)
+		f, err := srv.db.GetFile(s)
+		assert.NoError(t, err)
+		assert.Equal(t, data, f.Data)
+	}
+
+	// Data larger than the inline size, sent with sums, or not matching its checksum
+	// is rejected
+	wrong := sha256.Sum256(nil)
+	for _, file := range []*pb.File{
+		{Name: "/d.txt", Data: bytes.Repeat([]byte{1}, 17)},
+		{Name: "/d.txt", Data: data, Sums: [][]byte{aSum[:]}},
+		{Name: "/d.txt", Data: data, Checksums: map[string][]byte{"sha256": wrong[:]}},
+	} {
+		_, err = srv.CreateFile(ctx, file)
+		assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	srv.cache = newResponseCache(time.Hour)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	createTestFile(t, "/a.txt", srv)
+
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+	id, err := srv.CreateFile(ctx, &pb.File{Name: "/b.txt", Sums: sums, Metadata: map[string]string{"env": "prod"}})
+	assert.NoError(t, err)
+
+	for _, m := range []map[string]string{{"": "x"}, {"a b": "x"}, {"a=b": "x"}, {"k": "\xff"}, {strings.Repeat("k", 129): "x"}} {
+		_, err = srv.CreateFile(ctx, &pb.File{Name: "/c.txt", Sums: sums, Metadata: m})
+		assert.True(t, isTwirpError(err, twirp.InvalidArgument), m)
+	}
+
+	head := func() map[string]string {
+		resp, err := srv.Head(ctx, &pb.HeadRequest{Name: "/b.txt", Limit: 1})
+		assert.NoError(t, err)
+		return resp.Info[0].Metadata
+	}
+	assert.Equal(t, map[string]string{"env": "prod"}, head())
+
+	resp, err := srv.SetMetadata(ctx, &pb.SetMetadataRequest{Sum: id.Sum, Set: map[string]string{"team": "data"}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "prod", "team": "data"}, resp.Metadata)
+	assert.Equal(t, resp.Metadata, head())
+	_, err = srv.SetMetadata(ctx, &pb.SetMetadataRequest{Sum: id.Sum, Delete: []string{"env"}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "data"}, head())
+
+	// The number of keys is limited
+	many := make(map[string]string)
+	for i := 0; i < maxMetadataKeys; i++ {
+		many[fmt.Sprintf("k%d", i)] = "v"
+	}
+	_, err = srv.SetMetadata(ctx, &pb.SetMetadataRequest{Sum: id.Sum, Set: many})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	assert.Equal(t, map[string]string{"team": "data"}, head())
+
+	_, err = srv.SetMetadata(ctx, &pb.SetMetadataRequest{Sum: id.Sum})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	missing := sum.Compute([]byte("missing"))
+	_, err = srv.SetMetadata(ctx, &pb.SetMetadataRequest{Sum: missing[:], Set: map[string]string{"a": "b"}})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	// Copies keep the metadata
+	_, err = srv.Copy(ctx, &pb.CopyRequest{SrcId: id.Sum, Dst: "/d.txt"})
+	assert.NoError(t, err)
+
+	list, err := srv.List(ctx, &pb.ListRequest{Prefix: "/", Limit: 10, Sort: pb.ListSort_NAME, Ascending: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/a.txt", "/b.txt", "/d.txt"}, getNames(list.Info))
+	assert.Nil(t, list.Info[0].Metadata)
+	assert.Equal(t, map[string]string{"team": "data"}, list.Info[2].Metadata)
+
+	// Search by metadata, with or without a pattern
+	search, err := srv.Search(ctx, &pb.SearchRequest{Limit: 10, Metadata: map[string]string{"team": "data"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/b.txt", "/d.txt"}, getNames(search.Info))
+	search, err = srv.Search(ctx, &pb.SearchRequest{Pattern: "/d*", Limit: 10, Metadata: map[string]string{"team": "data"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/d.txt"}, getNames(search.Info))
+	search, err = srv.Search(ctx, &pb.SearchRequest{Limit: 10, Metadata: map[string]string{"team": "web"}})
+	assert.NoError(t, err)
+	assert.Empty(t, search.Info)
+}
+
+func TestVersionTags(t *testing.T) {
+	srv, _, dbname := testServer(t, false)
+	defer os.Remove(dbname)
+	srv.cache = newResponseCache(time.Hour)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+
+	v1, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+	_, err = srv.TagVersion(ctx, &pb.TagVersionRequest{Sum: v1.Sum, Tag: "release-1.2"})
+	assert.NoError(t, err)
+	for _, tag := range []string{"", "a b", "a/b", strings.Repeat("t", 129)} {
+		_, err = srv.TagVersion(ctx, &pb.TagVersionRequest{Sum: v1.Sum, Tag: tag})
+		assert.True(t, isTwirpError(err, twirp.InvalidArgument), tag)
+	}
+	missing := sum.Compute([]byte("missing"))
+	_, err = srv.TagVersion(ctx, &pb.TagVersionRequest{Sum: missing[:], Tag: "golden"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	// A tagged version is kept when the file is replaced with versioning turned off,
+	// and an untagged version is not
+	v2, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{bSum[:]}})
+	assert.NoError(t, err)
+	v3, err := srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: [][]byte{aSum[:], bSum[:]}})
+	assert.NoError(t, err)
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{v3.Sum, v1.Sum}, [][]byte{head.Info[0].Sum, head.Info[1].Sum})
+	assert.Len(t, head.Info, 2)
+
+	// Tagged versions may be fetched by tag
+	info, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/a.txt", Tag: "release-1.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, v1.Sum, info.Info.Sum)
+	_, err = srv.TagVersion(ctx, &pb.TagVersionRequest{Sum: v3.Sum, Tag: "release-1.2"})
+	assert.NoError(t, err)
+	info, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/a.txt", Tag: "release-1.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, v3.Sum, info.Info.Sum)
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/a.txt", Tag: "golden"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Sum: v2.Sum, Tag: "golden"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	_, err = srv.TagVersion(ctx, &pb.TagVersionRequest{Sum: v1.Sum, Tag: "golden"})
+	assert.NoError(t, err)
+	tags, err := srv.ListTags(ctx, &pb.ListTagsRequest{Name: "/a.txt"})
+	assert.NoError(t, err)
+	if assert.Len(t, tags.Tags, 2) {
+		assert.Equal(t, "golden", tags.Tags[0].Tag)
+		assert.Equal(t, v1.Sum, tags.Tags[0].Sum)
+		assert.Equal(t, "release-1.2", tags.Tags[1].Tag)
+		assert.Equal(t, v3.Sum, tags.Tags[1].Sum)
+	}
+
+	_, err = srv.UntagVersion(ctx, &pb.UntagVersionRequest{Name: "/a.txt", Tag: "golden"})
+	assert.NoError(t, err)
+	_, err = srv.UntagVersion(ctx, &pb.UntagVersionRequest{Name: "/a.txt", Tag: "golden"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	tags, err = srv.ListTags(ctx, &pb.ListTagsRequest{Name: "/a.txt"})
+	assert.NoError(t, err)
+	assert.Len(t, tags.Tags, 1)
+}
+
+func TestSnapshots(t *testing.T) {
+	srv, _, dbname := testServer(t, false)
+	defer os.Remove(dbname)
+	srv.cache = newResponseCache(time.Hour)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+
+	a1, err := srv.CreateFile(ctx, &pb.File{Name: "/data/a.txt", Sums: [][]byte{aSum[:]}, Metadata: map[string]string{"env": "prod"}})
+	assert.NoError(t, err)
+	b1, err := srv.CreateFile(ctx, &pb.File{Name: "/data/b.txt", Sums: [][]byte{bSum[:]}})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/database/c.txt", Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+
+	snap, err := srv.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "v1", Prefix: "/data"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/", snap.Prefix)
+	assert.Equal(t, uint64(2), snap.NumFiles)
+	_, err = srv.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "v1", Prefix: "/"})
+	assert.True(t, isTwirpError(err, twirp.AlreadyExists))
+	_, err = srv.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "a b", Prefix: "/"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "v2"})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	// Versions in a snapshot are kept when replaced with versioning turned off
+	a2, err := srv.CreateFile(ctx, &pb.File{Name: "/data/a.txt", Sums: [][]byte{bSum[:]}})
+	assert.NoError(t, err)
+	head, err := srv.Head(ctx, &pb.HeadRequest{Name: "/data/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, head.Info, 2)
+	_, err = srv.Delete(ctx, &pb.FileID{Sum: b1.Sum})
+	assert.NoError(t, err)
+	d1, err := srv.CreateFile(ctx, &pb.File{Name: "/data/d.txt", Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+
+	diff, err := srv.DiffSnapshot(ctx, &pb.DiffSnapshotRequest{Name: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*pb.SnapshotChange{
+		{Name: "/data/a.txt", Type: pb.SnapshotChangeType_MODIFIED, OldSum: a1.Sum, NewSum: a2.Sum},
+		{Name: "/data/b.txt", Type: pb.SnapshotChangeType_DELETED},
+		{Name: "/data/d.txt", Type: pb.SnapshotChangeType_ADDED, NewSum: d1.Sum},
+	}, diff.Changes)
+	_, err = srv.DiffSnapshot(ctx, &pb.DiffSnapshotRequest{Name: "v1", To: "missing"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	_, err = srv.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "v2", Prefix: "/data/"})
+	assert.NoError(t, err)
+	diff, err = srv.DiffSnapshot(ctx, &pb.DiffSnapshotRequest{Name: "v2", To: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*pb.SnapshotChange{
+		{Name: "/data/a.txt", Type: pb.SnapshotChangeType_MODIFIED, OldSum: a2.Sum, NewSum: a1.Sum},
+		{Name: "/data/b.txt", Type: pb.SnapshotChangeType_ADDED},
+		{Name: "/data/d.txt", Type: pb.SnapshotChangeType_DELETED, OldSum: d1.Sum},
+	}, diff.Changes)
+	list, err := srv.ListSnapshots(ctx, &pb.ListSnapshotsRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, list.Snapshots, 2) {
+		assert.Equal(t, "v2", list.Snapshots[0].Name)
+	}
+	list, err = srv.ListSnapshots(ctx, &pb.ListSnapshotsRequest{Prefix: "/database"})
+	assert.NoError(t, err)
+	assert.Empty(t, list.Snapshots)
+
+	// Rolling back restores the snapshot's versions, with their metadata. Files
+	// whose version was deleted are left unchanged.
+	res, err := srv.RollbackSnapshot(ctx, &pb.RollbackSnapshotRequest{Name: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, [3]uint64{1, 0, 1}, [3]uint64{res.Restored, res.Deleted, res.Unavailable})
+	info, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/data/a.txt"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, a1.Sum, info.Info.Sum)
+	assert.Equal(t, map[string]string{"env": "prod"}, info.Info.Metadata)
+	dl, err := srv.Download(ctx, &pb.FileID{Sum: info.Info.Sum})
+	assert.NoError(t, err)
+	assert.Len(t, dl.Sections, 1)
+
+	// Nothing is restored twice, and pruning deletes the files added since
+	res, err = srv.RollbackSnapshot(ctx, &pb.RollbackSnapshotRequest{Name: "v1", Prune: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [3]uint64{0, 1, 1}, [3]uint64{res.Restored, res.Deleted, res.Unavailable})
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/data/d.txt"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: "/database/c.txt"})
+	assert.NoError(t, err)
+	diff, err = srv.DiffSnapshot(ctx, &pb.DiffSnapshotRequest{Name: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*pb.SnapshotChange{{Name: "/data/b.txt", Type: pb.SnapshotChangeType_DELETED}}, diff.Changes)
+
+	_, err = srv.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Name: "v1"})
+	assert.NoError(t, err)
+	_, err = srv.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Name: "v1"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+	_, err = srv.RollbackSnapshot(ctx, &pb.RollbackSnapshotRequest{Name: "v1"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+}
+
+func TestResponseCache(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	srv.cache = newResponseCache(time.Hour)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	createTestFile(t, "/logs/a.txt", srv)
+	createTestFile(t, "/other/b.txt", srv)
+
+	ctx := context.Background()
+	list := func() []string {
+		resp, err := srv.List(ctx, &pb.ListRequest{Prefix: "/logs/", Limit: 10, Sort: pb.ListSort_NAME, Ascending: true})
+		assert.NoError(t, err)
+		return getNames(resp.Info)
+	}
+	numFiles := func() uint64 {
+		stats, err := srv.ServerStats(ctx, &pb.Empty{})
+		assert.NoError(t, err)
+		return stats.NumFiles
+	}
+	assert.Equal(t, []string{"/logs/a.txt"}, list())
+	assert.Equal(t, uint64(2), numFiles())
+
+	// A change made directly to the database isn't seen until the cache is invalidated
+	assert.NoError(t, srv.db.RenameFile("/logs/a.txt", "/logs/z.txt"))
+	assert.Equal(t, []string{"/logs/a.txt"}, list())
+
+	// Creating a file outside the prefix only invalidates the stats
+	createTestFile(t, "/other/c.txt", srv)
+	assert.Equal(t, []string{"/logs/a.txt"}, list())
+	assert.Equal(t, uint64(3), numFiles())
+
+	createTestFile(t, "/logs/b.txt", srv)
+	assert.Equal(t, []string{"/logs/b.txt", "/logs/z.txt"}, list())
+
+	_, err := srv.DeletePrefix(ctx, &pb.DeletePrefixRequest{Prefix: "/logs"})
+	assert.NoError(t, err)
+	assert.Empty(t, list())
+	assert.Equal(t, uint64(2), numFiles())
+
+	// A response computed while a file is changed is not cached
+	calls := 0
+	f := func() (proto.Message, error) {
+		calls++
+		srv.cache.invalidate("/logs/a.txt")
+		return &pb.Empty{}, nil
+	}
+	srv.cache.do("key", "/logs/", f)
+	srv.cache.do("key", "/logs/", f)
+	assert.Equal(t, 2, calls)
+
+	// Expired responses are not returned
+	cache := newResponseCache(time.Nanosecond)
+	calls = 0
+	f = func() (proto.Message, error) {
+		calls++
+		return &pb.Empty{}, nil
+	}
+	cache.do("key", "", f)
+	time.Sleep(time.Millisecond)
+	cache.do("key", "", f)
+	assert.Equal(t, 2, calls)
+
+	assert.Nil(t, newResponseCache(0))
+}
+
+func TestNamingRules(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	f := createTestFile(t, "test.txt", srv)
+	ctx := context.Background()
+
+	create := func(name string) error {
+		_, err := srv.CreateFile(ctx, &pb.File{Name: name, Sums: [][]byte{aSum[:]}})
+		return err
+	}
+	invalid := []string{
+		"/a/../b.txt",
+		"../b.txt",
+		"/a/\x00.txt",
+		"/a/\nb.txt",
+		"/a/\xff.txt",
+		"/" + strings.Repeat("a", maxFilenameSize),
+	}
+	for _, name := range invalid {
+		assert.True(t, isTwirpError(create(name), twirp.InvalidArgument), name)
+		_, err := srv.Copy(ctx, &pb.CopyRequest{SrcId: f.Sum, Dst: name})
+		assert.True(t, isTwirpError(err, twirp.InvalidArgument), name)
+	}
+	assert.NoError(t, create("/a/./b..c.txt"))
+
+	// Configured rules
+	srv.cfg.Naming = NamingRules{
+		MaxLength: 16,
+		Pattern:   regexp.MustCompile(`^[a-z/.]+$`),
+		NFC:       true,
+	}
+	assert.True(t, isTwirpError(create("/abcdefghijklmnop"), twirp.InvalidArgument))
+	assert.True(t, isTwirpError(create("/ABC"), twirp.InvalidArgument))
+	assert.NoError(t, create("/abc"))
+
+	// Names differing only in normalization form refer to the same file
+	srv.cfg.Naming.Pattern = nil
+	decomposed, composed := "/cafe\u0301", "/caf\u00e9"
+	assert.NoError(t, create(decomposed))
+	resp, err := srv.Head(ctx, &pb.HeadRequest{Name: composed, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{composed}, getNames(resp.Info))
+	assert.Equal(t, composed, srv.NormalizeName(decomposed+"/"))
+}
+
+func TestCleanFilename(t *testing.T) {
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"/tmp/data.txt", "/tmp/data.txt"},
+		{"tmp/data.txt/", "/tmp/data.txt"},
+		{"", ""},
+		{"data.txt", "/data.txt"},
+		{"/data.txt", "/data.txt"},
+		{"//tmp/data.txt", "/tmp/data.txt"},
+	}
+
+	for i, test := range tests {
+		assert.Equal(t, test.output, cleanFilename(test.input), i)
+	}
+}
+
+func TestGetChunkerParams(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+
+	ctx := context.Background()
+	params, err := srv.GetChunkerParams(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.NotNil(t, params)
+	assert.Equal(t, uint64(defaultPackfileSize), params.PackfileSize)
+	assert.Equal(t, int64(0), params.PackfileFlushInterval)
+
+	srv.cfg.PackfileSize = 8 * 1024 * 1024
+	srv.cfg.PackfileFlushInterval = time.Minute
+	params, err = srv.GetChunkerParams(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8*1024*1024), params.PackfileSize)
+	assert.Equal(t, int64(time.Minute), params.PackfileFlushInterval)
+}
+
+func TestChunkHash(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	srv.cfg.Params.ChunkHash = string(sum.SHA256)
+	assert.Equal(t, sum.SHA256, srv.ChunkHash())
+
+	upload := func(packfile []byte) int {
+		s := sum.Compute(packfile)
+		req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+		req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+		w := httptest.NewRecorder()
+		srv.PackfileUploadHandler(w, req)
+		return w.Result().StatusCode
+	}
+
+	// Chunks identified by BLAKE3 are rejected
+	assert.Equal(t, http.StatusBadRequest, upload(genTestPackfile(t)))
+
+	buf := new(bytes.Buffer)
+	builder, err := object.NewPackfileBuilder(buf)
+	assert.NoError(t, err)
+	assert.NoError(t, builder.Append(a, sum.SHA256.Compute(a), compress.Zstd))
+	assert.Equal(t, http.StatusCreated, upload(buf.Bytes()))
+}
+
+func TestChunkerProfiles(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+
+	// Unset sizes default from the average, and the algorithm to the server's
+	p, err := srv.SetChunkerProfile(ChunkerProfile{Prefix: "vm/", ChunkerParams: ChunkerParams{AvgChunkSize: 8 << 20, Normalization: 2}})
+	assert.NoError(t, err)
+	vm := ChunkerProfile{Prefix: "/vm", ChunkerParams: ChunkerParams{MinChunkSize: 2 << 20, AvgChunkSize: 8 << 20, MaxChunkSize: 32 << 20, Normalization: 2, Algorithm: "fastcdc"}}
+	assert.Equal(t, vm, p)
+	src := ChunkerProfile{Prefix: "/src", ChunkerParams: ChunkerParams{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 8192, Algorithm: "rabin"}}
+	_, err = srv.SetChunkerProfile(src)
+	assert.NoError(t, err)
+	profiles, err := srv.ChunkerProfiles()
+	assert.NoError(t, err)
+	assert.Equal(t, []ChunkerProfile{src, vm}, profiles)
+
+	// Uploads may contain chunks as large as the largest profile allows
+	limits, err := srv.packLimits(httptest.NewRequest("POST", "/packfile", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(32<<20), limits.MaxChunkSize)
+
+	invalid := []ChunkerParams{
+		{},
+		{MinChunkSize: 4096, AvgChunkSize: 4096},
+		{AvgChunkSize: 4096, Normalization: 12},
+		{AvgChunkSize: 4096, Algorithm: "cdc"},
+		{AvgChunkSize: 128 << 20},
+	}
+	for i, params := range invalid {
+		_, err := srv.SetChunkerProfile(ChunkerProfile{Prefix: "/x", ChunkerParams: params})
+		assert.Equal(t, twirp.InvalidArgument, err.(twirp.Error).Code(), i)
+	}
+
+	assert.NoError(t, srv.DeleteChunkerProfile("/vm"))
+	err = srv.DeleteChunkerProfile("/vm")
+	assert.Equal(t, twirp.NotFound, err.(twirp.Error).Code())
+	limits, err = srv.packLimits(httptest.NewRequest("POST", "/packfile", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, srv.cfg.MaxChunkSize, limits.MaxChunkSize)
+}
+
+func TestVacuumEmpty(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+
+	// Start vacuum
+	ctx := context.Background()
+	id, err := srv.StartVacuum(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+
+	// Get Vacuum
+	_, err = srv.VacuumStatus(ctx, id)
+	assert.NoError(t, err)
+
+	// Error if vaccuum does not exist
+	_, err = srv.VacuumStatus(ctx, &pb.VacuumID{Id: "abc"})
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+
+	// Vacuum waits for the vacuum to complete
+	for srv.VacuumRunning() {
+		time.Sleep(time.Millisecond)
+	}
+	vid, err := srv.Vacuum(ctx)
+	assert.NoError(t, err)
+	vacuum, err := srv.VacuumStatus(ctx, &pb.VacuumID{Id: vid})
+	assert.NoError(t, err)
+	assert.Equal(t, db.VacuumOK.String(), vacuum.Status)
+}
+
+func TestVacuum(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+
+	// Create 2 files:
+	// File 1: contains a & b
+	// File 2: contains just a
+	// If we delete file 1 and run a vacuum we should be left with a packfile containing
+	// just data a
+	ctx := context.Background()
+	f1, err := srv.CreateFile(ctx, &pb.File{
+		Name: "file1",
+		Sums: [][]byte{aSum[:], bSum[:]},
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -367,17 +2281,63 @@ func TestVacuum(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// The dry run reports the packfile rebuilt without b, and changes nothing
+	for i := 0; i < 2; i++ {
+		report, err := srv.VacuumDryRun(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.RebuiltPacks)
+		assert.Equal(t, 0, report.DeletedPacks)
+		assert.Equal(t, uint64(1), report.Chunks)
+		if assert.Len(t, report.Packs, 1) {
+			p := report.Packs[0]
+			assert.True(t, p.Rebuilt)
+			assert.Equal(t, uint64(2), p.TotalChunks)
+			assert.Equal(t, []string{"/file2"}, p.Files)
+			assert.True(t, p.Bytes > 0 && p.Bytes < p.Size)
+			assert.Equal(t, p.Bytes, report.Bytes)
+		}
+	}
+
 	// Run the vacuum and wait for it to complete
 	err = srv.runVacuum(ctx, time.Now().UTC())
 	assert.NoError(t, err)
-
-	// Should be able to download f2
-	_, err = srv.Download(ctx, f2)
+	report, err := srv.VacuumDryRun(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, VacuumReport{Packs: []ReclaimedPack{}}, report)
+
+	// Should be able to download f2
+	_, err = srv.Download(ctx, f2)
+	assert.NoError(t, err)
+
+	// Error if download f1
+	_, err = srv.Download(ctx, f1)
+	assert.True(t, isTwirpError(err, twirp.NotFound))
+}
+
+func TestShutdown(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+
+	// Shutdown waits for the vacuum to complete
+	ctx := context.Background()
+	id, err := srv.StartVacuum(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.Shutdown(ctx))
+	vacuum, err := srv.VacuumStatus(ctx, id)
 	assert.NoError(t, err)
+	assert.Equal(t, db.VacuumOK.String(), vacuum.Status)
 
-	// Error if download f1
-	_, err = srv.Download(ctx, f1)
-	assert.True(t, isTwirpError(err, twirp.NotFound))
+	// New uploads and vacuums are rejected
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+	w := httptest.NewRecorder()
+	srv.PackfileUploadHandler(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+
+	_, err = srv.StartVacuum(ctx, &pb.Empty{})
+	assert.True(t, isTwirpError(err, twirp.Unavailable))
 }
 
 func TestServerStats(t *testing.T) {
@@ -385,8 +2345,73 @@ func TestServerStats(t *testing.T) {
 	defer os.Remove(dbname)
 
 	ctx := context.Background()
-	_, err := srv.ServerStats(ctx, &pb.Empty{})
+	stats, err := srv.ServerStats(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Zero(t, stats.NumPacks)
+	assert.Zero(t, stats.DedupRatio)
+}
+
+func TestChunkReport(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	srv.cfg.Params = ChunkerParams{MinChunkSize: 256, AvgChunkSize: 512, MaxChunkSize: 1024}
+	ctx := context.Background()
+
+	// Nothing uploaded
+	r, err := srv.ChunkReport(ctx, &pb.ChunkReportRequest{})
+	assert.NoError(t, err)
+	assert.Zero(t, r.NumChunks)
+	assert.Zero(t, r.NumPacks)
+	assert.Zero(t, r.DedupHitRate)
+	assert.Equal(t, uint64(defaultPackfileSize), r.PackSize)
+	assert.Equal(t, uint64(512), r.Params.AvgChunkSize)
+	if assert.Len(t, r.ChunkSizes, 4) {
+		assert.Equal(t, &pb.HistogramBucket{Min: 0, Max: 256}, r.ChunkSizes[0])
+		assert.Equal(t, &pb.HistogramBucket{Min: 256, Max: 512}, r.ChunkSizes[1])
+		assert.Equal(t, &pb.HistogramBucket{Min: 1024, Max: 0}, r.ChunkSizes[3])
+	}
+	assert.Len(t, r.PackSizes, 11)
+
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	createTestFile(t, "a.txt", srv)
+	createTestFile(t, "b.txt", srv)
+
+	// Each file version is made of chunks a, b, b, a, and only the first upload of
+	// each chunk was stored
+	size := uint64(len(packfile))
+	r, err = srv.ChunkReport(ctx, &pb.ChunkReportRequest{PackSize: 2 * size})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), r.NumChunks)
+	assert.Equal(t, uint64(len(a)+len(b)), r.ChunksSize)
+	assert.NotZero(t, r.StoredSize)
+	assert.Equal(t, uint64(2), r.NumFileVersions)
+	assert.Equal(t, 4*r.ChunksSize, r.FilesSize)
+	assert.InDelta(t, 0.75, r.DedupHitRate, 1e-9)
+	for _, chunk := range [][]byte{a, b} {
+		n := uint64(len(chunk))
+		found := false
+		for _, bucket := range r.ChunkSizes {
+			if n >= bucket.Min && (n < bucket.Max || bucket.Max == 0) {
+				found = bucket.Count > 0 && bucket.Total >= n
+			}
+		}
+		assert.True(t, found)
+	}
+	assert.Equal(t, uint64(1), r.NumPacks)
+	assert.Equal(t, size, r.PacksSize)
+	assert.InDelta(t, 0.5, r.PackFill, 1e-9)
+	assert.Equal(t, &pb.HistogramBucket{Min: size, Max: 2 * size * 6 / 10, Count: 1, Total: size}, r.PackSizes[5])
+
+	// A period before the upload
+	r, err = srv.ChunkReport(ctx, &pb.ChunkReportRequest{Until: time.Now().Add(-time.Hour).UnixNano()})
 	assert.NoError(t, err)
+	assert.Zero(t, r.NumChunks)
+	assert.Zero(t, r.NumFileVersions)
+
+	// Invalid period
+	_, err = srv.ChunkReport(ctx, &pb.ChunkReportRequest{Since: 10, Until: 5})
+	assert.Error(t, err)
 }
 
 func TestMergeErrors(t *testing.T) {
@@ -412,6 +2437,506 @@ func TestMergeErrors(t *testing.T) {
 	assert.Equal(t, err1, errors.Unwrap(err))
 }
 
+func TestStoreEvents(t *testing.T) {
+	srv, store, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	uploadPackfile(t, srv, packfile)
+	f := createTestFile(t, "test.txt", srv)
+	key := sum.Compute(packfile).AsHex() + ".pack"
+	handler := srv.StoreEventsHandler("secret")
+
+	send := func(token string, body string) int {
+		req := httptest.NewRequest("POST", "/store/events", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result().StatusCode
+	}
+	event := func(name string, size int) string {
+		return fmt.Sprintf(
+			`{"Records": [{"eventName": %q, "eventTime": "2020-06-01T12:00:00.000Z", "s3": {"bucket": {"name": ""}, "object": {"key": %q, "size": %d}}}]}`,
+			name, key, size,
+		)
+	}
+	ctx := context.Background()
+
+	// Invalid token
+	assert.Equal(t, http.StatusUnauthorized, send("abc", event("ObjectRemoved:Delete", 0)))
+
+	// Invalid body
+	assert.Equal(t, http.StatusBadRequest, send("secret", "{"))
+
+	// Packfile created by the server is not degraded
+	assert.Equal(t, http.StatusOK, send("secret", event("s3:ObjectCreated:Put", len(packfile))))
+	_, err := srv.Download(ctx, f)
+	assert.NoError(t, err)
+
+	// Removal event for an object which still exists is ignored
+	assert.Equal(t, http.StatusOK, send("secret", event("ObjectRemoved:Delete", 0)))
+	_, err = srv.Download(ctx, f)
+	assert.NoError(t, err)
+
+	// Packfile deleted outside of the server, delivered through SNS
+	assert.NoError(t, store.Delete("", key))
+	sns, err := json.Marshal(map[string]string{"Type": "Notification", "Message": event("ObjectRemoved:Delete", 0)})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, send("secret", string(sns)))
+	_, err = srv.Download(ctx, f)
+	assert.True(t, isTwirpError(err, twirp.DataLoss))
+
+	// Chunks in the degraded packfile may be uploaded again
+	resp, err := srv.ChunksExist(ctx, &pb.ChunksExistRequest{Sums: [][]byte{aSum[:]}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false}, resp.Exists)
+}
+
+func TestStoreEventsOverwrite(t *testing.T) {
+	packfile := genTestPackfile(t)
+	key := sum.Compute(packfile).AsHex() + ".pack"
+	h := md5.Sum(packfile)
+	etag := hex.EncodeToString(h[:])
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		size     int
+		etag     string
+		degraded bool
+	}{
+		{"server upload", len(packfile), etag, false},
+		{"server upload, uppercase ETag", len(packfile), strings.ToUpper(etag), false},
+		{"no ETag", len(packfile), "", false},
+		{"different size", len(packfile) + 1, etag, true},
+		{"same size, different ETag", len(packfile), "0123456789abcdef0123456789abcdef", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, ms, dbname := testServer(t, true)
+			defer os.Remove(dbname)
+			srv.store = &statStore{mockStore: ms}
+			uploadPackfile(t, srv, packfile)
+			f := createTestFile(t, "test.txt", srv)
+
+			body := fmt.Sprintf(
+				`{"Records": [{"eventName": "s3:ObjectCreated:Put", "eventTime": "2020-06-01T12:00:00.000Z", "s3": {"bucket": {"name": ""}, "object": {"key": %q, "size": %d, "eTag": %q}}}]}`,
+				key, tt.size, tt.etag,
+			)
+			req := httptest.NewRequest("POST", "/store/events", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			srv.StoreEventsHandler("secret")(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			_, err := srv.Download(ctx, f)
+			if tt.degraded {
+				assert.True(t, isTwirpError(err, twirp.DataLoss))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPackETag(t *testing.T) {
+	srv, ms, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	st := &statStore{mockStore: ms}
+	srv.store = st
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+	key := s.AsHex() + ".pack"
+
+	// Upload fails if the store saves a truncated packfile
+	st.truncate = true
+	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(packfile))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+	w := httptest.NewRecorder()
+	srv.PackfileUploadHandler(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	_, ok := ms.data[""][key]
+	assert.False(t, ok)
+	_, err := srv.db.GetPackInfo(s)
+	assert.Equal(t, db.ErrNotFound, err)
+
+	// The ETag is recorded on upload
+	st.truncate = false
+	uploadPackfile(t, srv, packfile)
+	h := md5.Sum(packfile)
+	etag := hex.EncodeToString(h[:])
+	info, err := srv.db.GetPackInfo(s)
+	assert.NoError(t, err)
+	assert.Equal(t, etag, info.ETag)
+
+	// A creation event with the recorded ETag is the server's own upload
+	event := func(etag string) []StoreEvent {
+		return []StoreEvent{{Name: "ObjectCreated:Put", Key: key, Size: uint64(len(packfile)), ETag: etag}}
+	}
+	ctx := context.Background()
+	assert.NoError(t, srv.HandleStoreEvents(ctx, event(etag)))
+	info, err = srv.db.GetPackInfo(s)
+	assert.NoError(t, err)
+	assert.True(t, info.DegradedAt.IsZero())
+
+	// Overwritten with different data of the same size
+	assert.NoError(t, srv.HandleStoreEvents(ctx, event("abc")))
+	info, err = srv.db.GetPackInfo(s)
+	assert.NoError(t, err)
+	assert.False(t, info.DegradedAt.IsZero())
+}
+
+func TestScrub(t *testing.T) {
+	srv, ms, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	st := &statStore{mockStore: ms}
+	packfile := genTestPackfile(t)
+	s := sum.Compute(packfile)
+	key := s.AsHex() + ".pack"
+	ctx := context.Background()
+
+	// Store without Stat support. The ETag is not recorded.
+	uploadPackfile(t, srv, packfile)
+	info, err := srv.db.GetPackInfo(s)
+	assert.NoError(t, err)
+	assert.Empty(t, info.ETag)
+	res, err := srv.Scrub(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ScrubResult{Checked: 1}, res)
+
+	// The scrub records missing ETags
+	srv.store = st
+	res, err = srv.Scrub(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ScrubResult{Checked: 1}, res)
+	info, err = srv.db.GetPackInfo(s)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, info.ETag)
+
+	// Packfile overwritten with data of the same size
+	corrupt := make([]byte, len(packfile))
+	copy(corrupt, packfile)
+	corrupt[len(corrupt)-1]++
+	ms.data[""][key] = corrupt
+	res, err = srv.Scrub(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ScrubResult{Checked: 1, Degraded: 1}, res)
+
+	// Degraded packfiles are skipped
+	res, err = srv.Scrub(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ScrubResult{}, res)
+
+	// Scrub is unavailable during a vacuum
+	srv.isVacuuming = stateVacuuming
+	_, err = srv.Scrub(ctx)
+	assert.True(t, isTwirpError(err, twirp.Unavailable))
+
+	// Packfile missing from the store
+	srv, ms, dbname = testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, packfile)
+	assert.NoError(t, ms.Delete("", key))
+	res, err = srv.Scrub(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ScrubResult{Checked: 1, Degraded: 1}, res)
+}
+
+func TestRechunk(t *testing.T) {
+	srv, ms, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	id := createTestFile(t, "/data/a.txt", srv)
+	createTestFile(t, "/database.txt", srv)
+	old, err := sum.FromBytes(id.Sum)
+	assert.NoError(t, err)
+	assert.NoError(t, srv.db.TagVersion(old, "v1", time.Now()))
+	before, err := srv.db.GetFileInfo(old)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// Only the files in the directory are rechunked, with its profile
+	_, err = srv.SetChunkerProfile(ChunkerProfile{Prefix: "/data", ChunkerParams: ChunkerParams{AvgChunkSize: 256, Algorithm: "fixed"}})
+	assert.NoError(t, err)
+	res, err := srv.Rechunk(ctx, "data")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Checked)
+	assert.Equal(t, 1, res.Rechunked)
+	assert.Equal(t, 1, res.Packs)
+	assert.True(t, res.Chunks > 0)
+
+	// The version keeps its data, ID and tags under a new sum
+	after, err := srv.db.GetLatestFileVersion("/data/a.txt")
+	assert.NoError(t, err)
+	assert.NotEqual(t, old, after.Sum)
+	assert.Equal(t, before.VersionID, after.VersionID)
+	tagged, err := srv.db.GetTaggedVersion("/data/a.txt", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, after.Sum, tagged.Sum)
+	file, err := srv.db.GetFile(after.Sum)
+	assert.NoError(t, err)
+	for _, c := range file.Chunks {
+		assert.True(t, c.Size <= 256)
+	}
+	buf := new(bytes.Buffer)
+	assert.NoError(t, srv.WriteFile(ctx, after.Sum, buf))
+	expected := append(append(append(append([]byte{}, a...), b...), b...), a...)
+	assert.Equal(t, expected, buf.Bytes())
+	assert.NotContains(t, ms.data[""], old.AsHex()+".file")
+	assert.Contains(t, ms.data[""], after.Sum.AsHex()+".file")
+	other, err := srv.db.GetLatestFileVersion("/database.txt")
+	assert.NoError(t, err)
+	file, err = srv.db.GetFile(other.Sum)
+	assert.NoError(t, err)
+	assert.Len(t, file.Chunks, 4)
+
+	// Versions already chunked with their parameters are unchanged
+	res, err = srv.Rechunk(ctx, "/data")
+	assert.NoError(t, err)
+	assert.Equal(t, RechunkResult{Checked: 1}, res)
+
+	// Rechunk is unavailable during a vacuum
+	srv.isVacuuming = stateVacuuming
+	_, err = srv.Rechunk(ctx, "/data")
+	assert.True(t, isTwirpError(err, twirp.Unavailable))
+}
+
+func TestUploadFiles(t *testing.T) {
+	srv, ms, dbname := testServer(t, false)
+	defer os.Remove(dbname)
+	srv.cfg.InlineSize = 16
+	srv.cfg.Params = ChunkerParams{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16 * 1024, Normalization: 2}
+	ctx := context.Background()
+	big := bytes.Repeat([]byte("0123456789"), 1000)
+	archive := func(files map[string][]byte, dirs ...string) io.Reader {
+		buf := new(bytes.Buffer)
+		w := tar.NewWriter(buf)
+		for _, dir := range dirs {
+			assert.NoError(t, w.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}))
+		}
+		for name, data := range files {
+			assert.NoError(t, w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+			_, err := w.Write(data)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, w.Close())
+		return buf
+	}
+
+	// Small files are stored inline, and the chunks of the others are packed together
+	// and saved once
+	files := map[string][]byte{"./a.txt": []byte("small"), "sub/b.txt": big, "sub/c.txt": big, "empty": nil}
+	res, err := srv.UploadFiles(ctx, archive(files, "sub/"), "/src", nil)
+	assert.NoError(t, err)
+	assert.Len(t, res.Files, 4)
+	assert.Equal(t, 1, res.Packs)
+	assert.Equal(t, uint64(len(big)), res.Bytes)
+	for name, data := range files {
+		name = "/src/" + strings.TrimPrefix(name, "./")
+		info, err := srv.db.GetLatestFileVersion(name)
+		if !assert.NoError(t, err, name) {
+			continue
+		}
+		buf := new(bytes.Buffer)
+		assert.NoError(t, srv.WriteFile(ctx, info.Sum, buf), name)
+		assert.Equal(t, len(data), buf.Len(), name)
+		assert.Contains(t, ms.data[""], info.Sum.AsHex()+".file")
+	}
+	for _, f := range res.Files {
+		if f.Name == "/src/a.txt" {
+			file, err := srv.db.GetFile(f.Sum)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("small"), file.Data)
+		}
+	}
+
+	// A new upload replaces the previous versions, and reuses existing chunks
+	res, err = srv.UploadFiles(ctx, archive(map[string][]byte{"sub/b.txt": big}), "src", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Chunks)
+	assert.Equal(t, 0, res.Packs)
+	n, err := srv.db.CountFileVersions("/src/sub/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), n)
+
+	// No files are created if any name is invalid or fails the check
+	_, err = srv.UploadFiles(ctx, archive(map[string][]byte{"d.txt": nil, "../e.txt": nil}), "/src", nil)
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	denied := twirp.NewError(twirp.PermissionDenied, "denied")
+	_, err = srv.UploadFiles(ctx, archive(map[string][]byte{"d.txt": nil}), "/src", func(name string) error {
+		return denied
+	})
+	assert.Equal(t, denied, err)
+	_, err = srv.db.GetLatestFileVersion("/src/d.txt")
+	assert.True(t, errors.Is(err, db.ErrNotFound))
+	_, err = srv.UploadFiles(ctx, strings.NewReader("not an archive"), "/src", nil)
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+}
+
+func TestUploadFile(t *testing.T) {
+	srv, _, dbname := testServer(t, false)
+	defer os.Remove(dbname)
+	srv.cfg.InlineSize = 16
+	srv.cfg.Params = ChunkerParams{MinChunkSize: 1024, AvgChunkSize: 4096, MaxChunkSize: 16 * 1024, Normalization: 2}
+	ctx := context.Background()
+	handler := srv.FileUploadHandler(nil)
+	put := func(name string, data []byte, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", FilePrefix+name, bytes.NewReader(data))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	// Large files are chunked by the server, and small files stored inline
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+	for _, data := range [][]byte{big, []byte("small"), nil} {
+		w := put("dir/a.bin", data)
+		if !assert.Equal(t, http.StatusCreated, w.Code, w.Body.String()) {
+			continue
+		}
+		var resp struct {
+			Name string `json:"name"`
+			Sum  string `json:"sum"`
+			Size uint64 `json:"size"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "/dir/a.bin", resp.Name)
+		assert.Equal(t, uint64(len(data)), resp.Size)
+		assert.Equal(t, `"`+resp.Sum+`"`, w.Header().Get("ETag"))
+		s, err := sum.FromHex(resp.Sum)
+		assert.NoError(t, err)
+		buf := new(bytes.Buffer)
+		assert.NoError(t, srv.WriteFile(ctx, s, buf))
+		assert.Equal(t, len(data), buf.Len())
+		assert.True(t, bytes.Equal(data, buf.Bytes()))
+	}
+	n, err := srv.db.CountFileVersions("/dir/a.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), n)
+
+	// Preconditions are set by the If-Match and If-None-Match headers
+	latest, err := srv.db.GetLatestFileVersion("/dir/a.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, put("dir/a.bin", big, "If-None-Match", "*").Code)
+	assert.Equal(t, http.StatusPreconditionFailed, put("dir/a.bin", big, "If-Match", aSum.AsHex()).Code)
+	assert.Equal(t, http.StatusCreated, put("dir/a.bin", big, "If-Match", `"`+latest.Sum.AsHex()+`"`).Code)
+	assert.Equal(t, http.StatusCreated, put("dir/b.bin", big, "If-None-Match", "*").Code)
+	assert.Equal(t, http.StatusBadRequest, put("dir/c.bin", big, "If-Match", "x").Code)
+	assert.Equal(t, http.StatusBadRequest, put("dir/../c.bin", big).Code)
+}
+
+func TestMigrateStore(t *testing.T) {
+	srv, ms, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	packfile := genTestPackfile(t)
+	pkey := sum.Compute(packfile).AsHex() + ".pack"
+	uploadPackfile(t, srv, packfile)
+	id := createTestFile(t, "/a.txt", srv)
+	fkey := hex.EncodeToString(id.Sum) + ".file"
+	ctx := context.Background()
+
+	// The packfile, its index and the file are copied once
+	dst := newMockStore()
+	res, err := srv.MigrateStore(ctx, dst, "dst", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Copied)
+	for key, b := range ms.data[""] {
+		assert.Equal(t, b, dst.data["dst"][key], key)
+	}
+	res, err = srv.MigrateStore(ctx, dst, "dst", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateResult{Skipped: 3}, res)
+
+	// A corrupt packfile is not copied
+	corrupt := make([]byte, len(packfile))
+	copy(corrupt, packfile)
+	corrupt[len(corrupt)-1]++
+	ms.data[""][pkey] = corrupt
+	dst = newMockStore()
+	_, err = srv.MigrateStore(ctx, dst, "dst", false, nil)
+	assert.Error(t, err)
+	_, ok := dst.data["dst"][pkey]
+	assert.False(t, ok)
+
+	// Objects missing from the source are reported
+	ms.data[""][pkey] = packfile
+	assert.NoError(t, ms.Delete("", fkey))
+	res, err = srv.MigrateStore(ctx, dst, "dst", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateResult{Copied: 2, Bytes: res.Bytes, Missing: 1}, res)
+}
+
+func TestOpLog(t *testing.T) {
+	primary, store, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	primary.db.EnableOpLog()
+	standbyName := filepath.Join(os.TempDir(), "jotfs-"+xid.New().String())
+	defer os.Remove(standbyName)
+	adapter, err := db.EmptyDisk(standbyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby := New(adapter, store, primary.cfg)
+	ctx := context.Background()
+
+	// Nothing to replay before the primary ships its log
+	n, err := standby.ReplayOpLog(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	uploadPackfile(t, primary, genTestPackfile(t))
+	f := createTestFile(t, "/a.txt", primary)
+	assert.NoError(t, primary.ShipOpLog(ctx))
+	assert.Contains(t, store.data[primary.cfg.Bucket], opLogSegmentKey(1))
+
+	n, err = standby.ReplayOpLog(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	resp, err := standby.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Info, 1) {
+		assert.Equal(t, f.Sum, resp.Info[0].Sum)
+	}
+
+	// The incomplete segment is rewritten as entries are added
+	_, err = primary.Delete(ctx, f)
+	assert.NoError(t, err)
+	assert.NoError(t, primary.ShipOpLog(ctx))
+	n, err = standby.ReplayOpLog(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	resp, err = standby.Head(ctx, &pb.HeadRequest{Name: "/a.txt", Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Info)
+
+	// Replaying again has no effect
+	n, err = standby.ReplayOpLog(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+
+	assert.Equal(t, "oplog/00000000000000000001.jsonl.gz", opLogSegmentKey(1000))
+	assert.Equal(t, "oplog/00000000000000001001.jsonl.gz", opLogSegmentKey(1001))
+	assert.Equal(t, int64(2000), segmentEnd(1001))
+}
+
+func TestParseStoreEvents(t *testing.T) {
+	events, err := ParseStoreEvents([]byte(`{"Service": "Amazon S3", "Event": "s3:TestEvent"}`))
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	body := `{"Records": [{"eventName": "ObjectRemoved:Delete", "s3": {"bucket": {"name": "b"}, "object": {"key": "a+b%2Fc.pack"}}}]}`
+	events, err = ParseStoreEvents([]byte(body))
+	assert.NoError(t, err)
+	assert.Equal(t, []StoreEvent{{Name: "ObjectRemoved:Delete", Bucket: "b", Key: "a b/c.pack"}}, events)
+
+	body = `{"Records": [{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "b"}, "object": {"key": "c.pack", "size": 10, "eTag": "\"d41d8cd9\""}}}]}`
+	events, err = ParseStoreEvents([]byte(body))
+	assert.NoError(t, err)
+	assert.Equal(t, []StoreEvent{{Name: "ObjectCreated:Put", Bucket: "b", Key: "c.pack", Size: 10, ETag: "d41d8cd9"}}, events)
+}
+
 func testServer(t *testing.T, versioning bool) (*Server, *mockStore, string) {
 	id := xid.New()
 	name := filepath.Join(os.TempDir(), "jotfs-"+id.String())
@@ -464,6 +2989,196 @@ func uploadPackfile(t *testing.T, srv *Server, data []byte) {
 	}
 }
 
+func TestConflictStrategies(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+
+	rules, err := NewConflictRules(map[string]string{"/shared": "reject", "/shared/drafts": "Branch", "/": "replace"})
+	assert.NoError(t, err)
+	srv.cfg.Conflicts = rules
+	assert.Equal(t, ConflictReject, rules.strategy("/shared/a.txt"))
+	assert.Equal(t, ConflictBranch, rules.strategy("/shared/drafts/a.txt"))
+	assert.Equal(t, ConflictReplace, rules.strategy("/shared2/a.txt"))
+	_, err = NewConflictRules(map[string]string{"/a": "merge"})
+	assert.Error(t, err)
+
+	for _, dir := range []string{"/other", "/shared", "/shared/drafts"} {
+		name := dir + "/a.txt"
+		base, err := srv.CreateFile(ctx, &pb.File{Name: name, Sums: sums, BaseNotExists: true})
+		assert.NoError(t, err)
+		assert.Equal(t, pb.ConflictResolution_NO_CONFLICT, base.Conflict)
+		assert.Equal(t, name, base.Name)
+
+		// Another client changes the file after the upload began
+		_, err = srv.CreateFile(ctx, &pb.File{Name: name, Sums: sums[:1], Base: base.Sum})
+		assert.NoError(t, err)
+		f, err := srv.CreateFile(ctx, &pb.File{Name: name, Sums: sums, Base: base.Sum})
+		switch dir {
+		case "/other":
+			assert.NoError(t, err)
+			assert.Equal(t, pb.ConflictResolution_REPLACED, f.Conflict)
+			assert.Equal(t, name, f.Name)
+		case "/shared":
+			assert.True(t, isTwirpError(err, twirp.Aborted))
+		case "/shared/drafts":
+			assert.NoError(t, err)
+			assert.Equal(t, pb.ConflictResolution_BRANCHED, f.Conflict)
+			assert.Equal(t, "/shared/drafts/a.conflict-1.txt", f.Name)
+			f, err = srv.CreateFile(ctx, &pb.File{Name: name, Sums: sums, Base: base.Sum})
+			assert.NoError(t, err)
+			assert.Equal(t, "/shared/drafts/a.conflict-2.txt", f.Name)
+		}
+		head, err := srv.Head(ctx, &pb.HeadRequest{Name: name, Limit: 10})
+		assert.NoError(t, err)
+		if dir == "/other" {
+			assert.Len(t, head.Info, 3)
+		} else {
+			assert.Len(t, head.Info, 2)
+		}
+	}
+
+	// A file created since the upload began is a conflict
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/shared/b.txt", Sums: sums})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/shared/b.txt", Sums: sums, BaseNotExists: true})
+	assert.True(t, isTwirpError(err, twirp.Aborted))
+
+	// Uploads without a base replace the latest version
+	f, err := srv.CreateFile(ctx, &pb.File{Name: "/shared/b.txt", Sums: sums})
+	assert.NoError(t, err)
+	assert.Equal(t, pb.ConflictResolution_NO_CONFLICT, f.Conflict)
+
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, Base: f.Sum, IfNotExists: true})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a.txt", Sums: sums, Base: f.Sum, BaseNotExists: true})
+	assert.True(t, isTwirpError(err, twirp.InvalidArgument))
+
+	assert.Equal(t, "/a/b.tar.conflict-3.gz", branchName("/a/b.tar.gz", 3))
+	assert.Equal(t, "/a/.profile.conflict-1", branchName("/a/.profile", 1))
+	assert.Equal(t, "/a/b.conflict-1", branchName("/a/b", 1))
+}
+
+type testQuotaWarner struct {
+	warnings []QuotaWarning
+}
+
+func (w *testQuotaWarner) WarnQuota(q QuotaWarning) {
+	w.warnings = append(w.warnings, q)
+}
+
+func TestQuotas(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	data := genTestPackfile(t)
+	uploadPackfile(t, srv, data)
+	warner := &testQuotaWarner{}
+	srv.SetQuotaWarner(warner)
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+	size := uint64(len(a) + len(b))
+
+	_, err := NewQuotaRules([]Quota{{Prefix: "/a"}})
+	assert.Error(t, err)
+	_, err = NewQuotaRules([]Quota{{Prefix: "/a", Soft: 2, Hard: 1}})
+	assert.Error(t, err)
+	_, err = NewQuotaRules([]Quota{{Prefix: "/a", Hard: 1}, {Prefix: "a/", Hard: 2}})
+	assert.Error(t, err)
+	rules, err := NewQuotaRules([]Quota{
+		{Prefix: "/acme/", Soft: size, Hard: 3 * size, Grace: time.Hour},
+		{Prefix: "/other", Hard: size},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/acme", rules[0].Prefix)
+	srv.cfg.Quotas = rules
+
+	create := func(name string) error {
+		_, err := srv.CreateFile(ctx, &pb.File{Name: name, Sums: sums})
+		return err
+	}
+	isQuotaError := func(err error, dir string) bool {
+		return isTwirpError(err, twirp.ResourceExhausted) && err.(twirp.Error).Meta("quota") == dir
+	}
+
+	// The soft limit may be exceeded for the grace period, with a single warning
+	assert.NoError(t, create("/acme/1"))
+	assert.Empty(t, warner.warnings)
+	assert.NoError(t, create("/acme/2"))
+	assert.NoError(t, create("/acme/3"))
+	if assert.Len(t, warner.warnings, 1) {
+		w := warner.warnings[0]
+		assert.Equal(t, "/acme", w.Prefix)
+		assert.Equal(t, 2*size, w.Usage)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), w.GraceEnds, time.Minute)
+	}
+	usages, err := srv.QuotaUsages()
+	assert.NoError(t, err)
+	assert.Equal(t, 3*size, usages[0].Usage)
+	assert.False(t, usages[0].GraceStarted.IsZero())
+	assert.Equal(t, uint64(0), usages[1].Usage)
+
+	// The hard limit may not be exceeded
+	assert.True(t, isQuotaError(create("/acme/4"), "/acme/"))
+	assert.NoError(t, create("/acme2/4"))
+
+	// Uploads for a full prefix are rejected before the packfile is read
+	s := sum.Compute(data)
+	req := httptest.NewRequest("POST", "/packfile", bytes.NewReader(data))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(s[:]))
+	req.Header.Set("x-jotfs-name", url.PathEscape("/acme/4\x01"))
+	w := httptest.NewRecorder()
+	srv.PackfileUploadHandler(w, req)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+	// Once the grace period passes, the usage must fall under the soft limit
+	latest, err := srv.db.GetLatestFileVersion("/acme/3")
+	assert.NoError(t, err)
+	_, err = srv.Delete(ctx, &pb.FileID{Sum: latest.Sum[:]})
+	assert.NoError(t, err)
+	assert.NoError(t, srv.db.EndQuotaGrace("/acme/"))
+	_, _, err = srv.db.StartQuotaGrace("/acme/", time.Now().Add(-2*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, isQuotaError(create("/acme/3"), "/acme/"))
+	for _, name := range []string{"/acme/1", "/acme/2"} {
+		latest, err = srv.db.GetLatestFileVersion(name)
+		assert.NoError(t, err)
+		_, err = srv.Delete(ctx, &pb.FileID{Sum: latest.Sum[:]})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, create("/acme/1"))
+	_, err = srv.db.GetQuotaGrace("/acme/")
+	assert.True(t, errors.Is(err, db.ErrNotFound))
+	assert.Len(t, warner.warnings, 1)
+
+	// Copies and reverts count towards the quota
+	assert.NoError(t, create("/other/a"))
+	latest, err = srv.db.GetLatestFileVersion("/other/a")
+	assert.NoError(t, err)
+	_, err = srv.Copy(ctx, &pb.CopyRequest{SrcId: latest.Sum[:], Dst: "/other/b"})
+	assert.True(t, isQuotaError(err, "/other/"))
+	_, err = srv.RevertToVersion(ctx, &pb.RevertToVersionRequest{Sum: latest.Sum[:]})
+	assert.True(t, isQuotaError(err, "/other/"))
+}
+
+func TestQuotaReplacedVersion(t *testing.T) {
+	srv, _, dbname := testServer(t, false)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+	srv.cfg.Quotas = QuotaRules{{Prefix: "", Hard: uint64(len(a) + len(b))}}
+
+	// Without versioning, a new version replacing the latest version takes no space
+	_, err := srv.CreateFile(ctx, &pb.File{Name: "/a", Sums: sums})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/a", Sums: sums})
+	assert.NoError(t, err)
+	_, err = srv.CreateFile(ctx, &pb.File{Name: "/b", Sums: sums})
+	assert.True(t, isTwirpError(err, twirp.ResourceExhausted))
+}
+
 func createTestFile(t *testing.T, name string, srv *Server) *pb.FileID {
 	ctx := context.Background()
 	f, err := srv.CreateFile(ctx, &pb.File{
@@ -512,3 +3227,51 @@ faster, stretch out our arms farther... And one fine morning -- So we beat on, b
 against the current, borne back ceaselessly into the past.`)
 
 var bSum = sum.Compute(b)
+
+func TestDefaultMetadata(t *testing.T) {
+	srv, _, dbname := testServer(t, true)
+	defer os.Remove(dbname)
+	uploadPackfile(t, srv, genTestPackfile(t))
+	ctx := context.Background()
+	sums := [][]byte{aSum[:], bSum[:]}
+
+	rules, err := NewMetadataRules([]MetadataRule{
+		{Prefix: "/finance/reports", Metadata: map[string]string{"classification": "restricted"}},
+		{Prefix: "/", ContentTypes: map[string]string{".CSV": "text/csv"}},
+		{Prefix: "/finance", Metadata: map[string]string{"owner": "finance", "classification": "internal"}},
+	})
+	assert.NoError(t, err)
+	srv.cfg.DefaultMetadata = rules
+
+	metadata := func(name string, set map[string]string) map[string]string {
+		_, err := srv.CreateFile(ctx, &pb.File{Name: name, Sums: sums, Metadata: set})
+		assert.NoError(t, err)
+		resp, err := srv.GetFileInfo(ctx, &pb.GetFileInfoRequest{Name: name})
+		assert.NoError(t, err)
+		return resp.Info.Metadata
+	}
+	assert.Equal(t, map[string]string{"owner": "finance", "classification": "internal"}, metadata("/finance/a.txt", nil))
+	assert.Equal(t, map[string]string{"owner": "finance", "classification": "restricted", "content-type": "text/csv"}, metadata("/finance/reports/q1.csv", nil))
+	assert.Equal(t, map[string]string{"content-type": "text/csv"}, metadata("/other/b.csv", nil))
+	assert.Empty(t, metadata("/other/b.txt", nil))
+	assert.Empty(t, metadata("/financed/b.txt", nil))
+
+	// Keys set by the upload take precedence
+	set := map[string]string{"owner": "ops", "content-type": "application/csv"}
+	assert.Equal(t, map[string]string{"owner": "ops", "classification": "internal", "content-type": "application/csv"}, metadata("/finance/c.csv", set))
+
+	for _, rule := range []MetadataRule{
+		{Prefix: "/a", Metadata: map[string]string{"bad key": "x"}},
+		{Prefix: "/a", ContentTypes: map[string]string{"csv": "text/csv"}},
+	} {
+		_, err := NewMetadataRules([]MetadataRule{rule})
+		assert.Error(t, err)
+	}
+}
+
+// failWriter is an io.Writer which fails every write.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
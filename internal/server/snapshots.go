@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// CreateSnapshot records the latest version of every file in a directory under a
+// name, so the directory may later be compared with or rolled back to its state at
+// the time of the snapshot. The snapshot is atomic: it includes either all or none of
+// the changes made by any other request. Versions in a snapshot are not deleted when
+// the file is replaced with versioning turned off. Returns an AlreadyExists error if a
+// snapshot with the same name exists.
+func (srv *Server) CreateSnapshot(ctx context.Context, req *pb.CreateSnapshotRequest) (*pb.Snapshot, error) {
+	if err := validateLabel("snapshot name", req.Name); err != nil {
+		return nil, twirp.InvalidArgumentError("name", err.Error())
+	}
+	if req.Prefix == "" {
+		return nil, twirp.RequiredArgumentError("prefix")
+	}
+	// Match whole directory names only, so /data does not include /database
+	dir := srv.NormalizeName(req.Prefix) + "/"
+
+	snap, err := srv.db.CreateSnapshot(req.Name, dir, time.Now().UTC())
+	if errors.Is(err, db.ErrExists) {
+		return nil, twirp.NewError(twirp.AlreadyExists, fmt.Sprintf("snapshot %s already exists", req.Name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db CreateSnapshot: %w", err)
+	}
+	return snapshotToPB(snap), nil
+}
+
+// ListSnapshots returns the snapshots of a directory and its subdirectories, most
+// recent first. Every snapshot is returned if no directory is given.
+func (srv *Server) ListSnapshots(ctx context.Context, req *pb.ListSnapshotsRequest) (*pb.ListSnapshotsResponse, error) {
+	dir := srv.NormalizeName(req.Prefix) + "/"
+	snapshots, err := srv.db.ListSnapshots(dir)
+	if err != nil {
+		return nil, fmt.Errorf("db ListSnapshots: %w", err)
+	}
+	res := make([]*pb.Snapshot, len(snapshots))
+	for i := range snapshots {
+		res[i] = snapshotToPB(snapshots[i])
+	}
+	return &pb.ListSnapshotsResponse{Snapshots: res}, nil
+}
+
+// DiffSnapshot returns the files added, modified and deleted between a snapshot and
+// either another snapshot or the latest versions of the files in its directory. A file
+// whose versions differ but have the same contents, e.g. because it was restored by
+// RollbackSnapshot, is unchanged. Returns a NotFound error if either snapshot does not
+// exist.
+func (srv *Server) DiffSnapshot(ctx context.Context, req *pb.DiffSnapshotRequest) (*pb.DiffSnapshotResponse, error) {
+	if req.Name == "" {
+		return nil, twirp.RequiredArgumentError("name")
+	}
+	snap, err := srv.getSnapshot(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	from, err := srv.db.GetSnapshotFiles(snap.Name)
+	if err != nil {
+		return nil, fmt.Errorf("db GetSnapshotFiles: %w", err)
+	}
+	var to []db.FileHead
+	if req.To == "" {
+		to, err = srv.db.GetFileHeads(snap.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("db GetFileHeads: %w", err)
+		}
+	} else {
+		to, err = srv.db.GetSnapshotFiles(req.To)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, twirp.NotFoundError(fmt.Sprintf("snapshot %s", req.To))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("db GetSnapshotFiles: %w", err)
+		}
+	}
+
+	// Both lists are in name order, so merge them
+	changes := make([]*pb.SnapshotChange, 0)
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case j == len(to) || (i < len(from) && from[i].Name < to[j].Name):
+			changes = append(changes, &pb.SnapshotChange{Name: from[i].Name, Type: pb.SnapshotChangeType_DELETED, OldSum: headSum(from[i])})
+			i++
+		case i == len(from) || to[j].Name < from[i].Name:
+			changes = append(changes, &pb.SnapshotChange{Name: to[j].Name, Type: pb.SnapshotChangeType_ADDED, NewSum: headSum(to[j])})
+			j++
+		default:
+			same := from[i] == to[j]
+			if !same && !from[i].Deleted && !to[j].Deleted {
+				if same, err = srv.sameContents(from[i].Sum, to[j].Sum); err != nil {
+					return nil, err
+				}
+			}
+			if !same {
+				changes = append(changes, &pb.SnapshotChange{
+					Name:   from[i].Name,
+					Type:   pb.SnapshotChangeType_MODIFIED,
+					OldSum: headSum(from[i]),
+					NewSum: headSum(to[j]),
+				})
+			}
+			i++
+			j++
+		}
+	}
+	return &pb.DiffSnapshotResponse{Changes: changes}, nil
+}
+
+// RollbackSnapshot restores each file in a snapshot whose latest version has different
+// contents to its version in the snapshot. The version is copied to a new latest
+// version, as with RevertToVersion, under the name the file had when the snapshot was
+// taken. If Prune is set, files in the snapshot's directory which are not in the
+// snapshot are deleted. Files whose version in the snapshot has since been deleted are
+// left unchanged. The rollback is atomic, and fails with an Aborted error, without
+// changing any file, if a file in the directory is changed while the rollback is
+// prepared.
+func (srv *Server) RollbackSnapshot(ctx context.Context, req *pb.RollbackSnapshotRequest) (*pb.RollbackSnapshotResponse, error) {
+	if req.Name == "" {
+		return nil, twirp.RequiredArgumentError("name")
+	}
+	snap, err := srv.getSnapshot(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	files, err := srv.db.GetSnapshotFiles(snap.Name)
+	if err != nil {
+		return nil, fmt.Errorf("db GetSnapshotFiles: %w", err)
+	}
+
+	var res pb.RollbackSnapshotResponse
+	var versions []db.NewFileVersion
+	var replaced []db.FileInfo
+	inSnapshot := make(map[string]bool, len(files))
+	now := time.Now().UTC()
+	for _, f := range files {
+		inSnapshot[f.Name] = true
+		if f.Deleted {
+			res.Unavailable++
+			continue
+		}
+		var cond db.Precondition
+		latest, err := srv.db.GetLatestFileVersion(f.Name)
+		if errors.Is(err, db.ErrNotFound) {
+			cond.IfNotExists = true
+		} else if err != nil {
+			return nil, fmt.Errorf("db GetLatestFileVersion: %w", err)
+		} else {
+			same, err := srv.sameContents(latest.Sum, f.Sum)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+			cond.IfMatch = &latest.Sum
+			replaced = append(replaced, latest)
+		}
+
+		v, err := srv.copyVersion(f.Sum, f.Name, now)
+		if err != nil {
+			return nil, err
+		}
+		v.Cond = cond
+		versions = append(versions, v)
+	}
+
+	del := make(map[string]sum.Sum)
+	if req.Prune {
+		heads, err := srv.db.GetFileHeads(snap.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("db GetFileHeads: %w", err)
+		}
+		for _, h := range heads {
+			if !inSnapshot[h.Name] {
+				del[h.Name] = h.Sum
+			}
+		}
+	}
+	if len(versions) == 0 && len(del) == 0 {
+		return &res, nil
+	}
+
+	// Save the new versions to the store before the database, as with CreateFile
+	var keys []string
+	for _, v := range versions {
+		key := v.Sum.AsHex() + ".file"
+		if err := srv.store.Put(ctx, srv.cfg.Bucket, key, bytes.NewReader(v.File.MarshalBinary())); err != nil {
+			return nil, mergeErrors(err, srv.deleteKeys(keys))
+		}
+		keys = append(keys, key)
+	}
+	deleted, err := srv.db.InsertFiles(versions, del)
+	srv.cache.invalidatePrefix(snap.Prefix)
+	if err != nil {
+		err = mergeErrors(err, srv.deleteKeys(keys))
+		return nil, conflictError(err, snap.Prefix)
+	}
+	for _, s := range deleted {
+		key := s.AsHex() + ".file"
+		if err := srv.store.Delete(srv.cfg.Bucket, key); err != nil {
+			srv.logger.Error().Msgf("deleting %s: %v", key, err)
+		}
+	}
+	res.Restored = uint64(len(versions))
+	res.Deleted = uint64(len(del))
+
+	// Delete the replaced versions if versioning is turned off
+	for _, latest := range replaced {
+		if !latest.Versioned && !srv.cfg.VersioningEnabled {
+			if err := srv.deleteReplaced(ctx, latest.Sum); err != nil {
+				srv.logger.Error().Msgf("deleting file version %x: %v", latest.Sum, err)
+			}
+		}
+	}
+	return &res, nil
+}
+
+// DeleteSnapshot deletes a snapshot. The files in its directory are not changed.
+// Returns a NotFound error if the snapshot does not exist.
+func (srv *Server) DeleteSnapshot(ctx context.Context, req *pb.DeleteSnapshotRequest) (*pb.Empty, error) {
+	if req.Name == "" {
+		return nil, twirp.RequiredArgumentError("name")
+	}
+	err := srv.db.DeleteSnapshot(req.Name)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("snapshot %s", req.Name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db DeleteSnapshot: %w", err)
+	}
+	return &pb.Empty{}, nil
+}
+
+// getSnapshot returns a snapshot, or a NotFound error if it does not exist.
+func (srv *Server) getSnapshot(name string) (db.Snapshot, error) {
+	snap, err := srv.db.GetSnapshot(name)
+	if errors.Is(err, db.ErrNotFound) {
+		return db.Snapshot{}, twirp.NotFoundError(fmt.Sprintf("snapshot %s", name))
+	}
+	if err != nil {
+		return db.Snapshot{}, fmt.Errorf("db GetSnapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// copyVersion returns a new version of the file name with the same contents, metadata
+// and checksums as the file version s.
+func (srv *Server) copyVersion(s sum.Sum, name string, createdAt time.Time) (db.NewFileVersion, error) {
+	f, err := srv.db.GetFile(s)
+	if err != nil {
+		return db.NewFileVersion{}, fmt.Errorf("db GetFile: %w", err)
+	}
+	info, err := srv.db.GetFileInfo(s)
+	if err != nil {
+		return db.NewFileVersion{}, fmt.Errorf("db GetFileInfo: %w", err)
+	}
+	checksums, err := srv.db.GetFileChecksums(s)
+	if err != nil {
+		return db.NewFileVersion{}, fmt.Errorf("db GetFileChecksums: %w", err)
+	}
+	f.Name = name
+	f.CreatedAt = createdAt
+	f.Versioned = srv.cfg.VersioningEnabled
+	return db.NewFileVersion{
+		File:      f,
+		Sum:       sum.Compute(f.MarshalBinary()),
+		Metadata:  info.Metadata,
+		Checksums: checksums,
+	}, nil
+}
+
+// sameContents returns true if the file versions x and y have the same contents.
+func (srv *Server) sameContents(x sum.Sum, y sum.Sum) (bool, error) {
+	if x == y {
+		return true, nil
+	}
+	fx, err := srv.db.GetFile(x)
+	if err != nil {
+		return false, fmt.Errorf("db GetFile: %w", err)
+	}
+	fy, err := srv.db.GetFile(y)
+	if err != nil {
+		return false, fmt.Errorf("db GetFile: %w", err)
+	}
+	if len(fx.Chunks) != len(fy.Chunks) {
+		return false, nil
+	}
+	for i := range fx.Chunks {
+		if fx.Chunks[i].Sum != fy.Chunks[i].Sum {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// deleteKeys deletes objects from the store.
+func (srv *Server) deleteKeys(keys []string) error {
+	var err error
+	for _, key := range keys {
+		err = mergeErrors(err, srv.store.Delete(srv.cfg.Bucket, key))
+	}
+	return err
+}
+
+// headSum returns the sum of a file head, or nil if its version has been deleted.
+func headSum(h db.FileHead) []byte {
+	if h.Deleted {
+		return nil
+	}
+	return h.Sum[:]
+}
+
+func snapshotToPB(s db.Snapshot) *pb.Snapshot {
+	return &pb.Snapshot{
+		Name:      s.Name,
+		Prefix:    s.Prefix,
+		CreatedAt: s.CreatedAt.UnixNano(),
+		NumFiles:  s.NumFiles,
+		TotalSize: s.TotalFilesSize,
+	}
+}
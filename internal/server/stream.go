@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// FilePrefix is the path prefix of the streaming upload endpoint.
+const FilePrefix = "/file/"
+
+// UploadFile creates a new version of the file name from the data read from r, for
+// clients which can't chunk the data themselves. The server chunks the data with the
+// chunker profile of the name, and saves its new chunks in packfiles, or stores it
+// inline if it is no larger than the inline size. The version is created only if cond
+// holds for the latest version of the file, and an Aborted error is returned if not.
+func (srv *Server) UploadFile(ctx context.Context, r io.Reader, name string, cond db.Precondition) (UploadedFile, error) {
+	if !srv.beginTask() {
+		return UploadedFile{}, twirp.NewError(twirp.Unavailable, "server is shutting down")
+	}
+	defer srv.tasks.Done()
+
+	name, err := srv.newFilename(name)
+	if err != nil {
+		return UploadedFile{}, twirp.InvalidArgumentError("name", err.Error())
+	}
+	profiles, err := srv.ChunkerProfiles()
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	var pack serverPack
+	defer pack.discard(srv)
+	f, checksums, err := srv.chunkFile(ctx, r, name, profiles, &pack, &UploadFilesResult{})
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	if _, err := srv.saveServerPack(ctx, &pack); err != nil {
+		return UploadedFile{}, err
+	}
+
+	latest, err := srv.db.GetLatestFileVersion(name)
+	hasPrev := err == nil
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return UploadedFile{}, fmt.Errorf("db GetLatestFileVersion: %w", err)
+	}
+	if err := srv.checkQuotas(srv.newQuotaChange(f, latest, hasPrev)); err != nil {
+		return UploadedFile{}, err
+	}
+	f.CreatedAt = time.Now().UTC()
+	metadata := srv.cfg.DefaultMetadata.apply(name, nil)
+	s, _, err := srv.insertFile(ctx, f, metadata, checksums, cond, db.CreateKey{})
+	if err != nil {
+		return UploadedFile{}, conflictError(err, name)
+	}
+	srv.cache.invalidate(name)
+	if err := srv.replicate(ctx, &pb.FileID{Sum: s[:], Name: name}); err != nil {
+		return UploadedFile{}, err
+	}
+
+	// Delete the previous version if versioning is turned off
+	if hasPrev && !latest.Versioned && !srv.cfg.VersioningEnabled {
+		if err := srv.deleteReplaced(ctx, latest.Sum); err != nil {
+			srv.logger.Error().Msgf("deleting file version %x: %v", latest.Sum, err)
+		}
+	}
+	return UploadedFile{Name: name, Sum: s, Size: f.Size()}, nil
+}
+
+// FileUploadHandler returns a http handler creating a version of the file named by the
+// request path after FilePrefix from the request body, with UploadFile. An
+// If-None-Match header of "*" only creates the file if it does not exist, and an
+// If-Match header only replaces the latest version with the given hex-encoded sum; a
+// failed precondition returns status 412. check, if not nil, is called with the
+// request's context and the file name. The new version is returned as JSON with
+// status 201.
+func (srv *Server) FileUploadHandler(check func(ctx context.Context, name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		name, err := srv.newFilename(strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(FilePrefix, "/")))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid file name: %v", err), http.StatusBadRequest)
+			return
+		}
+		var cond db.Precondition
+		if m := req.Header.Get("If-Match"); m != "" {
+			s, err := sum.FromHex(strings.Trim(m, `"`))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid If-Match: %v", err), http.StatusBadRequest)
+				return
+			}
+			cond.IfMatch = &s
+		}
+		if req.Header.Get("If-None-Match") == "*" {
+			cond.IfNotExists = true
+		}
+		if cond.IfMatch != nil && cond.IfNotExists {
+			http.Error(w, "If-Match cannot be set with If-None-Match", http.StatusBadRequest)
+			return
+		}
+		if check != nil {
+			if err := check(ctx, name); err != nil {
+				httpError(w, err)
+				return
+			}
+		}
+
+		f, err := srv.UploadFile(ctx, req.Body, name, cond)
+		var terr twirp.Error
+		if errors.As(err, &terr) && terr.Code() == twirp.Aborted {
+			http.Error(w, terr.Msg(), http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("ETag", `"`+f.Sum.AsHex()+`"`)
+		writeJSON(w, http.StatusCreated, uploadedFileJSON{Name: f.Name, Sum: f.Sum.AsHex(), Size: f.Size})
+	}
+}
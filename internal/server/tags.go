@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// maxTagSize is the maximum length of a version tag or snapshot name in bytes.
+const maxTagSize = 128
+
+// TagVersion labels a file version with a tag, e.g. "release-1.2", so it can be
+// fetched by GetFileInfo with the file's name and the tag. A tag names at most one
+// version of each file, so if the tag names another version of the file it is moved.
+// Tagged versions are not deleted when the file is replaced with versioning turned off.
+// Returns a NotFound error if the file version does not exist.
+func (srv *Server) TagVersion(ctx context.Context, req *pb.TagVersionRequest) (*pb.Empty, error) {
+	if req.Sum == nil {
+		return nil, twirp.RequiredArgumentError("sum")
+	}
+	s, err := sum.FromBytes(req.Sum)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("sum", err.Error())
+	}
+	if err := validateTag(req.Tag); err != nil {
+		return nil, twirp.InvalidArgumentError("tag", err.Error())
+	}
+
+	err = srv.db.TagVersion(s, req.Tag, time.Now().UTC())
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("file %x", s))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db TagVersion: %w", err)
+	}
+	srv.invalidateVersion(s)
+	return &pb.Empty{}, nil
+}
+
+// UntagVersion removes a tag from a file. Returns a NotFound error if the file has no
+// such tag.
+func (srv *Server) UntagVersion(ctx context.Context, req *pb.UntagVersionRequest) (*pb.Empty, error) {
+	if req.Name == "" {
+		return nil, twirp.RequiredArgumentError("name")
+	}
+	if req.Tag == "" {
+		return nil, twirp.RequiredArgumentError("tag")
+	}
+	name := srv.NormalizeName(req.Name)
+
+	err := srv.db.UntagVersion(name, req.Tag)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, twirp.NotFoundError(fmt.Sprintf("tag %s of file %s", req.Tag, name))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db UntagVersion: %w", err)
+	}
+	srv.cache.invalidate(name)
+	return &pb.Empty{}, nil
+}
+
+// ListTags returns the tags of a file, in tag order.
+func (srv *Server) ListTags(ctx context.Context, req *pb.ListTagsRequest) (*pb.ListTagsResponse, error) {
+	if req.Name == "" {
+		return nil, twirp.RequiredArgumentError("name")
+	}
+	tags, err := srv.db.GetFileTags(srv.NormalizeName(req.Name))
+	if err != nil {
+		return nil, fmt.Errorf("db GetFileTags: %w", err)
+	}
+	res := make([]*pb.VersionTag, len(tags))
+	for i := range tags {
+		t := tags[i] // don't use range value
+		res[i] = &pb.VersionTag{Tag: t.Tag, Sum: t.Sum[:], CreatedAt: t.CreatedAt.UnixNano()}
+	}
+	return &pb.ListTagsResponse{Tags: res}, nil
+}
+
+// invalidateVersion removes the cached responses which may include the file version s.
+func (srv *Server) invalidateVersion(s sum.Sum) {
+	info, err := srv.db.GetFileInfo(s)
+	if err == nil {
+		srv.cache.invalidate(info.Name)
+	} else {
+		srv.cache.invalidatePrefix("")
+	}
+}
+
+// validateTag returns an error if a version tag is invalid.
+func validateTag(tag string) error {
+	return validateLabel("tag", tag)
+}
+
+// validateLabel returns an error if a user-defined label, such as a tag or snapshot
+// name, is invalid. Labels follow the same rules as metadata keys.
+func validateLabel(kind string, label string) error {
+	if label == "" {
+		return fmt.Errorf("%s cannot be empty", kind)
+	}
+	if len(label) > maxTagSize {
+		return fmt.Errorf("%s exceeds maximum size %d", kind, maxTagSize)
+	}
+	for _, c := range label {
+		if !isMetadataKeyChar(c) {
+			return fmt.Errorf("%s %q cannot contain %q", kind, label, c)
+		}
+	}
+	return nil
+}
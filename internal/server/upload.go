@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxAbortedUploads is the number of recent aborted uploads kept.
+const maxAbortedUploads = 20
+
+// AbortedUpload is a packfile upload which ended because the client disconnected
+// before the packfile was saved.
+type AbortedUpload struct {
+	// Sum is the hex checksum of the packfile.
+	Sum string
+	// Size is the size of the packfile, and Received the number of bytes received
+	// before the client disconnected.
+	Size     int64
+	Received int64
+	// Reason is the error which ended the upload.
+	Reason string
+	At     time.Time
+}
+
+// abortedUploads records the packfile uploads aborted since the server started.
+type abortedUploads struct {
+	mu    sync.Mutex
+	count uint64
+	// recent holds the most recent aborted uploads, oldest first
+	recent []AbortedUpload
+}
+
+func (a *abortedUploads) add(u AbortedUpload) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.count++
+	if len(a.recent) == maxAbortedUploads {
+		a.recent = append(a.recent[:0], a.recent[1:]...)
+	}
+	a.recent = append(a.recent, u)
+}
+
+// AbortedUploads returns the number of packfile uploads aborted by clients since the
+// server started, and the most recent of them, newest first.
+func (srv *Server) AbortedUploads() (uint64, []AbortedUpload) {
+	srv.aborts.mu.Lock()
+	defer srv.aborts.mu.Unlock()
+	recent := make([]AbortedUpload, len(srv.aborts.recent))
+	for i, u := range srv.aborts.recent {
+		recent[len(recent)-1-i] = u
+	}
+	return srv.aborts.count, recent
+}
+
+// uploadRetryAfter is the Retry-After header, in seconds, sent with uploads rejected
+// because the server is busy.
+const uploadRetryAfter = "1"
+
+// UploadAdmissionStats are the packfile uploads in progress, and the uploads rejected
+// because the server was busy since it started.
+type UploadAdmissionStats struct {
+	Active      uint64
+	ActiveBytes uint64
+	Rejected    uint64
+}
+
+// uploadAdmission limits the number of packfile uploads in progress, and their total
+// size.
+type uploadAdmission struct {
+	max      uint64
+	maxBytes uint64
+
+	mu    sync.Mutex
+	stats UploadAdmissionStats
+}
+
+// newUploadAdmission returns nil if both limits are zero.
+func newUploadAdmission(max int, maxBytes uint64) *uploadAdmission {
+	if max <= 0 && maxBytes == 0 {
+		return nil
+	}
+	if max < 0 {
+		max = 0
+	}
+	return &uploadAdmission{max: uint64(max), maxBytes: maxBytes}
+}
+
+// admit starts an upload of size bytes. Returns the reason the upload is rejected,
+// or an empty string if it's admitted, in which case release must be called when it
+// completes. An upload larger than maxBytes is admitted when no other upload is in
+// progress, so it can complete eventually.
+func (a *uploadAdmission) admit(size uint64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var reason string
+	switch {
+	case a.max > 0 && a.stats.Active >= a.max:
+		reason = fmt.Sprintf("%d packfile uploads are in progress", a.stats.Active)
+	case a.maxBytes > 0 && a.stats.Active > 0 && a.stats.ActiveBytes+size > a.maxBytes:
+		reason = fmt.Sprintf("%d bytes of packfile uploads are in progress", a.stats.ActiveBytes)
+	}
+	if reason != "" {
+		a.stats.Rejected++
+		return reason
+	}
+	a.stats.Active++
+	a.stats.ActiveBytes += size
+	return ""
+}
+
+func (a *uploadAdmission) release(size uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stats.Active--
+	a.stats.ActiveBytes -= size
+}
+
+// UploadAdmissionStats returns the packfile uploads in progress, and the uploads
+// rejected because the server was busy. Returns false if uploads are not limited.
+func (srv *Server) UploadAdmissionStats() (UploadAdmissionStats, bool) {
+	if srv.admission == nil {
+		return UploadAdmissionStats{}, false
+	}
+	srv.admission.mu.Lock()
+	defer srv.admission.mu.Unlock()
+	return srv.admission.stats, true
+}
+
+// uploadReader reads a packfile from a request body and writes it to the store upload
+// as it's read. Errors reading the body and writing to the store are recorded, so they
+// can be told apart from a malformed packfile.
+type uploadReader struct {
+	body     io.Reader
+	w        io.Writer
+	n        int64
+	readErr  error
+	writeErr error
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.readErr = err
+	}
+	if n > 0 {
+		if _, werr := r.w.Write(p[:n]); werr != nil {
+			r.writeErr = werr
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/diskspace"
+	"github.com/jotfs/jotfs/internal/object"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+const (
+	// minUploadRetryDelay and maxUploadRetryDelay bound the time a queued packfile
+	// upload waits before retrying after the store fails. The delay doubles after
+	// each failure.
+	minUploadRetryDelay = time.Second
+	maxUploadRetryDelay = time.Minute
+)
+
+// UploadQueueStats are the packfiles waiting in the upload queue, and the uploads
+// made from it since the server started.
+type UploadQueueStats struct {
+	// Pending is the number of packfiles accepted from clients but not yet saved to
+	// the store, and PendingSize their total size.
+	Pending     uint64
+	PendingSize uint64
+	// Uploaded is the number of packfiles saved to the store, and Retries the number
+	// of uploads which failed and were retried.
+	Uploaded uint64
+	Retries  uint64
+	// Rejected is the number of uploads rejected because the disk was low on free
+	// space.
+	Rejected uint64
+	// FreeSpace is the free space of the file system holding the queue, if a minimum
+	// free space is set.
+	FreeSpace uint64
+}
+
+// uploadQueue saves packfiles to the store in the background. A packfile upload is
+// accepted once the packfile and its index are written to the queue directory, which
+// is the journal of pending uploads, and the index is inserted into the database. A
+// pool of workers then saves each packfile to the store, retrying until the store
+// accepts it, and removes it from the directory. Uploads left in the directory by a
+// previous run are resumed by StartUploadQueue. The queue holds a fixed number of
+// packfiles, and uploads wait for a free slot when it's full. If a minimum free space
+// is set, uploads which would leave less free space on the directory's file system are
+// rejected, and clients retry them once queued packfiles are saved to the store.
+type uploadQueue struct {
+	dir     string
+	minFree int64
+	// free returns the free space of the file system holding a directory
+	free  func(dir string) (uint64, error)
+	slots chan struct{}
+	jobs  chan sum.Sum
+
+	mu      sync.Mutex
+	pending map[sum.Sum]*pendingUpload
+	stats   UploadQueueStats
+}
+
+// pendingUpload is a packfile in the upload queue. done is closed once it's saved to
+// the store.
+type pendingUpload struct {
+	size uint64
+	done chan struct{}
+}
+
+// newUploadQueue returns a queue holding up to size packfiles in dir, leaving at least
+// minFree bytes free on its file system if minFree is positive. Returns nil if dir is
+// empty. A nil queue is valid and has no pending uploads.
+func newUploadQueue(dir string, size int, minFree int64) *uploadQueue {
+	if dir == "" {
+		return nil
+	}
+	if size < 1 {
+		size = 1
+	}
+	return &uploadQueue{
+		dir:     dir,
+		minFree: minFree,
+		free:    diskspace.Free,
+		slots:   make(chan struct{}, size),
+		jobs:    make(chan sum.Sum, size),
+		pending: make(map[sum.Sum]*pendingUpload),
+	}
+}
+
+// packPath and indexPath return the names of the files holding a queued packfile and
+// its index.
+func (q *uploadQueue) packPath(s sum.Sum) string {
+	return filepath.Join(q.dir, s.AsHex()+".pack")
+}
+
+func (q *uploadQueue) indexPath(s sum.Sum) string {
+	return filepath.Join(q.dir, s.AsHex()+".index")
+}
+
+// add records a packfile as pending and queues it for upload. The caller must hold a
+// slot, which is freed once the packfile is uploaded. Returns false, and frees the
+// slot, if the packfile is already pending.
+func (q *uploadQueue) add(s sum.Sum, size uint64) bool {
+	q.mu.Lock()
+	if _, ok := q.pending[s]; ok {
+		q.mu.Unlock()
+		<-q.slots
+		return false
+	}
+	q.pending[s] = &pendingUpload{size: size, done: make(chan struct{})}
+	q.stats.Pending++
+	q.stats.PendingSize += size
+	q.mu.Unlock()
+	q.jobs <- s
+	return true
+}
+
+// finish removes a packfile from the queue once it's saved to the store, and frees
+// its slot.
+func (q *uploadQueue) finish(s sum.Sum) {
+	q.mu.Lock()
+	if p, ok := q.pending[s]; ok {
+		delete(q.pending, s)
+		close(p.done)
+		q.stats.Pending--
+		q.stats.PendingSize -= p.size
+		q.stats.Uploaded++
+	}
+	q.mu.Unlock()
+	<-q.slots
+}
+
+// hasRoom returns true if a packfile of size bytes may be written to the queue
+// directory without leaving less than the minimum free space. The free space is
+// assumed to be sufficient if it can't be read.
+func (q *uploadQueue) hasRoom(size int64) bool {
+	if q.minFree <= 0 {
+		return true
+	}
+	free, err := q.free(q.dir)
+	if err != nil || int64(free)-size >= q.minFree {
+		return true
+	}
+	q.mu.Lock()
+	q.stats.Rejected++
+	q.mu.Unlock()
+	return false
+}
+
+// isPending returns true if a packfile is in the queue.
+func (q *uploadQueue) isPending(s sum.Sum) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.pending[s]
+	return ok
+}
+
+// wait waits until the packfiles holding chunks are saved to the store. Returns an
+// Unavailable error if ctx is done first.
+func (q *uploadQueue) wait(ctx context.Context, indices []db.ChunkIndex) error {
+	if q == nil {
+		return nil
+	}
+	var last sum.Sum
+	for _, idx := range indices {
+		if idx.PackSum == last {
+			continue
+		}
+		last = idx.PackSum
+		q.mu.Lock()
+		p, ok := q.pending[idx.PackSum]
+		q.mu.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return twirp.NewError(twirp.Unavailable, fmt.Sprintf("packfile %x is waiting to be uploaded to the store", idx.PackSum))
+		}
+	}
+	return nil
+}
+
+// getRange returns a reader of a range of a packfile in the queue directory. Returns
+// false if the packfile is not in the queue.
+func (q *uploadQueue) getRange(s sum.Sum, offset uint64, length uint64) (io.ReadCloser, bool) {
+	if !q.isPending(s) {
+		return nil, false
+	}
+	f, err := os.Open(q.packPath(s))
+	if err != nil {
+		// The packfile was saved to the store and removed from the queue
+		return nil, false
+	}
+	r := io.NewSectionReader(f, int64(offset), int64(length))
+	return readCloser{r, f}, true
+}
+
+// readCloser combines a Reader with the Closer of its source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// StartUploadQueue starts the workers of the upload queue, if it's enabled, and
+// resumes the uploads left in the queue directory by a previous run. Packfiles which
+// were not completely written, or whose index is not in the database, are removed.
+func (srv *Server) StartUploadQueue() error {
+	q := srv.uploads
+	if q == nil {
+		return nil
+	}
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return fmt.Errorf("creating upload queue directory: %w", err)
+	}
+	names, err := filepath.Glob(filepath.Join(q.dir, "*.pack"))
+	if err != nil {
+		return err
+	}
+	var resumed []sum.Sum
+	var sizes []uint64
+	for _, name := range names {
+		s, err := sum.FromHex(strings.TrimSuffix(filepath.Base(name), ".pack"))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(q.indexPath(s)); err != nil {
+			srv.logger.Warn().Msgf("upload queue: packfile %x has no index and is discarded", s)
+			os.Remove(name)
+			continue
+		}
+		if _, err := srv.db.GetPackInfo(s); errors.Is(err, db.ErrNotFound) {
+			// The server stopped before the index was inserted, so the upload
+			// was never accepted
+			os.Remove(name)
+			os.Remove(q.indexPath(s))
+			continue
+		} else if err != nil {
+			return fmt.Errorf("db GetPackInfo: %w", err)
+		}
+		resumed = append(resumed, s)
+		sizes = append(sizes, uint64(info.Size()))
+	}
+	// Remove temporary files and indexes of packfiles which were never queued
+	others, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range others {
+		name := info.Name()
+		if strings.HasSuffix(name, ".pack") {
+			continue
+		}
+		if s, err := sum.FromHex(strings.TrimSuffix(name, ".index")); err == nil {
+			if _, err := os.Stat(q.packPath(s)); err == nil {
+				continue
+			}
+		}
+		os.Remove(filepath.Join(q.dir, name))
+	}
+
+	workers := srv.cfg.UploadQueueWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go srv.uploadWorker()
+	}
+	for i, s := range resumed {
+		if !srv.beginTask() {
+			break
+		}
+		srv.logger.Info().Msgf("upload queue: resuming upload of packfile %x", s)
+		// Resumed uploads may exceed the size of the queue, so they wait for a
+		// slot in the background
+		go func(s sum.Sum, size uint64) {
+			q.slots <- struct{}{}
+			if !q.add(s, size) {
+				srv.tasks.Done()
+			}
+		}(s, sizes[i])
+	}
+	return nil
+}
+
+// uploadWorker saves the packfiles in the upload queue to the store. Each upload is a
+// server task, so Shutdown waits for the queue to empty.
+func (srv *Server) uploadWorker() {
+	q := srv.uploads
+	for s := range q.jobs {
+		delay := minUploadRetryDelay
+		for {
+			err := srv.uploadQueued(s)
+			if err == nil {
+				break
+			}
+			srv.logger.Error().Msgf("upload queue: uploading packfile %x: %v. Retrying in %s", s, err, delay)
+			q.mu.Lock()
+			q.stats.Retries++
+			q.mu.Unlock()
+			time.Sleep(delay)
+			if delay *= 2; delay > maxUploadRetryDelay {
+				delay = maxUploadRetryDelay
+			}
+		}
+		q.finish(s)
+		srv.tasks.Done()
+	}
+}
+
+// uploadQueued saves a queued packfile and its index to the store, and removes them
+// from the queue directory.
+func (srv *Server) uploadQueued(s sum.Sum) error {
+	q := srv.uploads
+	ctx := context.Background()
+	bucket := srv.cfg.Bucket
+	pkey := s.AsHex() + ".pack"
+	ikey := s.AsHex() + ".index"
+
+	f, err := os.Open(q.packPath(s))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := srv.store.Put(ctx, bucket, pkey, f); err != nil {
+		return fmt.Errorf("uploading %s: %w", pkey, err)
+	}
+	etag, err := srv.verifyPackfile(ctx, pkey, uint64(info.Size()))
+	if err != nil {
+		return mergeErrors(err, srv.store.Delete(bucket, pkey))
+	}
+	index, err := ioutil.ReadFile(q.indexPath(s))
+	if err != nil {
+		return err
+	}
+	if err := srv.store.Put(ctx, bucket, ikey, bytes.NewReader(index)); err != nil {
+		return fmt.Errorf("uploading %s: %w", ikey, err)
+	}
+	srv.savePackETag(s, etag)
+
+	f.Close()
+	if err := os.Remove(q.packPath(s)); err != nil {
+		srv.logger.Error().Msgf("upload queue: removing packfile %x: %v", s, err)
+	}
+	os.Remove(q.indexPath(s))
+	return nil
+}
+
+// queuePackfile accepts a packfile upload into the upload queue, responding once the
+// packfile is written to the queue directory and its index is in the database. The
+// request waits for a free slot if the queue is full, and is rejected with a 503
+// status if the disk is low on free space.
+func (srv *Server) queuePackfile(w http.ResponseWriter, req *http.Request, packSum sum.Sum, limits object.PackLimits) {
+	q := srv.uploads
+	digest := packSum.AsHex()
+	if !q.hasRoom(req.ContentLength) {
+		w.Header().Set("Retry-After", uploadRetryAfter)
+		http.Error(w, "server busy: upload queue is low on disk space. Retry later", http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case q.slots <- struct{}{}:
+	case <-req.Context().Done():
+		srv.uploadAborted(digest, req.ContentLength, 0, req.Context().Err())
+		return
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			<-q.slots
+		}
+	}()
+
+	f, err := ioutil.TempFile(q.dir, "tmp-")
+	if err != nil {
+		internalError(w, fmt.Errorf("creating upload queue file: %w", err))
+		return
+	}
+	tmp := f.Name()
+	defer func() {
+		f.Close()
+		os.Remove(tmp)
+	}()
+
+	rd := &uploadReader{body: io.LimitReader(req.Body, req.ContentLength), w: f}
+	index, err := object.LoadPackIndexLimited(rd, limits)
+	if err != nil {
+		switch {
+		case rd.readErr != nil || req.Context().Err() != nil:
+			srv.uploadAborted(digest, req.ContentLength, rd.n, err)
+		case rd.writeErr != nil:
+			internalError(w, fmt.Errorf("writing packfile to upload queue: %w", err))
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if index.Sum != packSum {
+		msg := fmt.Sprintf("provided packfile checksum %x does not match actual checksum %x", packSum, index.Sum)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		internalError(w, fmt.Errorf("writing packfile to upload queue: %w", err))
+		return
+	}
+	if err := f.Close(); err != nil {
+		internalError(w, fmt.Errorf("writing packfile to upload queue: %w", err))
+		return
+	}
+
+	// The index is written before the packfile, so a packfile in the directory
+	// always has an index
+	if err := writeFileSync(q.indexPath(packSum), index.MarshalBinary()); err != nil {
+		internalError(w, fmt.Errorf("writing index to upload queue: %w", err))
+		return
+	}
+	if err := os.Rename(tmp, q.packPath(packSum)); err != nil {
+		os.Remove(q.indexPath(packSum))
+		internalError(w, fmt.Errorf("writing packfile to upload queue: %w", err))
+		return
+	}
+
+	err = srv.insertPackIndex(index, time.Now().UTC(), req.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		if !q.isPending(packSum) {
+			os.Remove(q.packPath(packSum))
+			os.Remove(q.indexPath(packSum))
+		}
+		internalError(w, fmt.Errorf("db InsertPackIndex: %w", err))
+		return
+	}
+	srv.cache.invalidate("")
+	if err := srv.indexCache.add(index); err != nil {
+		srv.logger.Error().Msgf("caching index of packfile %x: %v", index.Sum, err)
+	}
+
+	// The upload is a task until it's saved to the store. The request's task is held,
+	// so the server can't have finished shutting down.
+	srv.tasks.Add(1)
+	queued = true
+	if !q.add(packSum, index.Size) {
+		srv.tasks.Done()
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writeFileSync writes data to a file, via a temporary file which is synced to disk
+// and renamed, so the file is never partially written.
+func writeFileSync(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// UploadQueueStats returns the packfiles waiting in the upload queue and the uploads
+// made from it. Returns false if the queue is disabled.
+func (srv *Server) UploadQueueStats() (UploadQueueStats, bool) {
+	q := srv.uploads
+	if q == nil {
+		return UploadQueueStats{}, false
+	}
+	q.mu.Lock()
+	stats := q.stats
+	q.mu.Unlock()
+	if q.minFree > 0 {
+		stats.FreeSpace, _ = q.free(q.dir)
+	}
+	return stats, true
+}
@@ -16,12 +16,20 @@ import (
 )
 
 func (srv *Server) runVacuum(ctx context.Context, createdBefore time.Time) error {
+	if err := srv.db.DeleteJournalBefore(createdBefore.Add(-journalRetention)); err != nil {
+		return fmt.Errorf("db DeleteJournalBefore: %w", err)
+	}
+
 	zrs, err := srv.db.GetZeroRefcount(createdBefore.UTC())
 	if err != nil {
 		return fmt.Errorf("db GetZeroRefcount: %w", err)
 	}
 
 	for _, zr := range zrs {
+		if srv.uploads.isPending(zr.PackID) {
+			// The packfile is not in the store yet
+			continue
+		}
 		index, err := getPackIndex(ctx, srv.store, srv.cfg.Bucket, zr.PackID)
 		if err != nil {
 			return err
@@ -34,7 +42,11 @@ func (srv *Server) runVacuum(ctx context.Context, createdBefore time.Time) error
 			}
 		}
 
-		// Remove the old index and packfile from the store
+		// Remove the old index and packfile from the store, and then the database. The
+		// deletion is completed at startup if the server stops in between.
+		if err := srv.journal.begin(journalDelete, index.Sum); err != nil {
+			return err
+		}
 		oldIKey := index.Sum.AsHex() + ".index"
 		oldPKey := index.Sum.AsHex() + ".pack"
 		err1 := srv.store.Delete(srv.cfg.Bucket, oldIKey)
@@ -50,13 +62,93 @@ func (srv *Server) runVacuum(ctx context.Context, createdBefore time.Time) error
 			return err
 		}
 
-		srv.db.DeletePackIndex(index.Sum)
+		if err := srv.db.DeletePackIndex(index.Sum); err != nil {
+			return fmt.Errorf("db DeletePackIndex: %w", err)
+		}
+		srv.endPackOp(index.Sum)
+		srv.cache.invalidate("")
+		srv.locations.removePack(index.Sum)
+		if err := srv.indexCache.remove(index.Sum); err != nil {
+			srv.logger.Error().Msgf("removing cached index of packfile %x: %v", index.Sum, err)
+		}
 		srv.logger.Debug().Msgf("vacuum deleted packfile %x", index.Sum)
 	}
 
 	return nil
 }
 
+// VacuumReport describes the data a vacuum would reclaim, without changing anything.
+type VacuumReport struct {
+	// DeletedPacks is the number of packfiles with no referenced chunks, which a
+	// vacuum deletes, and RebuiltPacks the number with some referenced chunks, which it
+	// replaces with a packfile holding only those chunks.
+	DeletedPacks int
+	RebuiltPacks int
+	// Chunks is the number of unreferenced chunks removed, and Bytes the number of
+	// bytes freed in the store.
+	Chunks uint64
+	Bytes  uint64
+	// Packs lists each packfile the vacuum would delete or rebuild.
+	Packs []ReclaimedPack
+}
+
+// ReclaimedPack is a packfile a vacuum would delete or rebuild.
+type ReclaimedPack struct {
+	Sum  sum.Sum
+	Size uint64
+	// Chunks is the number of unreferenced chunks in the packfile, out of TotalChunks,
+	// and Bytes the number of bytes freed by removing them.
+	Chunks      uint64
+	TotalChunks uint64
+	Bytes       uint64
+	// Rebuilt is true if the packfile holds referenced chunks, so it's replaced rather
+	// than deleted.
+	Rebuilt bool
+	// Files names the files with data in a rebuilt packfile, which is moved to the new
+	// packfile, up to the limit given to VacuumDryRun.
+	Files []string
+}
+
+// VacuumDryRun reports the packfiles a vacuum started now would delete or rebuild,
+// and the space it would reclaim, without changing anything. At most maxFiles names
+// of the files with data in each rebuilt packfile are listed.
+func (srv *Server) VacuumDryRun(ctx context.Context, maxFiles int) (VacuumReport, error) {
+	packs, err := srv.db.ListReclaimablePacks(time.Now().UTC())
+	if err != nil {
+		return VacuumReport{}, fmt.Errorf("db ListReclaimablePacks: %w", err)
+	}
+	report := VacuumReport{Packs: make([]ReclaimedPack, 0, len(packs))}
+	for _, p := range packs {
+		if srv.uploads.isPending(p.Sum) {
+			// The packfile is not in the store yet, so the vacuum skips it
+			continue
+		}
+		rp := ReclaimedPack{
+			Sum:         p.Sum,
+			Size:        p.Size,
+			Chunks:      p.Chunks,
+			TotalChunks: p.NumChunks,
+			Bytes:       p.Size,
+			Rebuilt:     p.Chunks < p.NumChunks,
+		}
+		if rp.Rebuilt {
+			rp.Bytes = p.ChunksSize
+			if maxFiles > 0 {
+				if rp.Files, err = srv.db.ListPackFiles(p.Sum, maxFiles); err != nil {
+					return VacuumReport{}, fmt.Errorf("db ListPackFiles: %w", err)
+				}
+			}
+			report.RebuiltPacks++
+		} else {
+			report.DeletedPacks++
+		}
+		report.Chunks += rp.Chunks
+		report.Bytes += rp.Bytes
+		report.Packs = append(report.Packs, rp)
+	}
+	return report, nil
+}
+
 // getPackIndex gets a pack index from the store.
 func getPackIndex(ctx context.Context, s store.Store, bucket string, sum sum.Sum) (object.PackIndex, error) {
 	ikey := sum.AsHex() + ".index"
@@ -136,6 +228,19 @@ func (srv *Server) rebuildPackfile(ctx context.Context, zr db.ZeroRefcount, inde
 	}
 	newIndex := object.PackIndex{Blocks: blocks, Sum: hash.Sum(), Size: size}
 	newIKey := newIndex.Sum.AsHex() + ".index"
+	if err := srv.journal.begin(journalPut, newIndex.Sum); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if err := srv.resolvePut(newIndex.Sum); err != nil {
+				srv.logger.Error().Msgf("rebuildPackfile: removing packfile %x: %v", newIndex.Sum, err)
+				return
+			}
+		}
+		srv.endPackOp(newIndex.Sum)
+	}()
 	buf := bytes.NewReader(newIndex.MarshalBinary())
 	if err = srv.store.Put(ctx, bucket, newIKey, buf); err != nil {
 		return fmt.Errorf("saving %s to store: %w", newIKey, err)
@@ -152,6 +257,11 @@ func (srv *Server) rebuildPackfile(ctx context.Context, zr db.ZeroRefcount, inde
 		err = fmt.Errorf("saving %s to store: %w", newPKey, err)
 		return mergeErrors(err, srv.store.Delete(bucket, newIKey))
 	}
+	etag, err := srv.verifyPackfile(ctx, newPKey, newIndex.Size)
+	if err != nil {
+		err = mergeErrors(err, srv.store.Delete(bucket, newIKey))
+		return mergeErrors(err, srv.store.Delete(bucket, newPKey))
+	}
 
 	createdAt := time.Now().UTC()
 	if err := srv.db.UpdateIndex(newIndex, createdAt, index.Sum, m); err != nil {
@@ -159,6 +269,8 @@ func (srv *Server) rebuildPackfile(ctx context.Context, zr db.ZeroRefcount, inde
 		err = mergeErrors(err, srv.store.Delete(bucket, newIKey))
 		return mergeErrors(err, srv.store.Delete(bucket, newPKey))
 	}
+	committed = true
+	srv.savePackETag(newIndex.Sum, etag)
 
 	srv.logger.Debug().
 		Int64("elapsed", time.Since(start).Milliseconds()).
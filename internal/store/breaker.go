@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a Breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed passes requests to the store.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails requests without sending them to the store.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen sends a single trial request to the store, after the breaker
+	// has been open for its cooldown, and fails other requests until it completes.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerHealth reports the health of the store behind a Breaker.
+type BreakerHealth struct {
+	State BreakerState
+	// ConsecutiveFailures is the number of requests which have failed since the last
+	// request succeeded, and Failures the total since the breaker was created.
+	ConsecutiveFailures int
+	Failures            uint64
+	// Trips is the number of times the breaker has opened. OpenedAt is the time it
+	// last opened, and is zero while the breaker is closed.
+	Trips    uint64
+	OpenedAt time.Time
+	// LastError is the error of the last failed request, if any.
+	LastError error
+}
+
+// Breaker is a circuit breaker for a store. It opens after threshold consecutive
+// requests fail, and fails requests with ErrUnavailable while open, so requests do not
+// pile up waiting on a store which is unreachable. After cooldown, a single trial
+// request is sent to the store, and the breaker closes if it succeeds. A request which
+// returns ErrNotFound succeeds, since the store answered it, and a request whose
+// context is done is not counted.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	onChange  func(h BreakerHealth)
+
+	mu     sync.Mutex
+	health BreakerHealth
+	now    func() time.Time
+}
+
+// NewBreaker creates a Breaker. onChange, if not nil, is called with the store's
+// health each time the breaker changes state.
+func NewBreaker(threshold int, cooldown time.Duration, onChange func(h BreakerHealth)) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		health:    BreakerHealth{State: BreakerClosed},
+		now:       time.Now,
+	}
+}
+
+// Cooldown returns the time the breaker stays open before a trial request.
+func (b *Breaker) Cooldown() time.Duration {
+	return b.cooldown
+}
+
+// Health returns the health of the store.
+func (b *Breaker) Health() BreakerHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.health
+}
+
+// Err returns an error wrapping ErrUnavailable if the breaker is failing requests,
+// and nil otherwise.
+func (b *Breaker) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.health.State == BreakerClosed {
+		return nil
+	}
+	return b.unavailable()
+}
+
+// unavailable returns the error requests fail with while the breaker is open. b.mu
+// must be held.
+func (b *Breaker) unavailable() error {
+	h := b.health
+	retry := h.OpenedAt.Add(b.cooldown).Sub(b.now())
+	if retry < 0 {
+		retry = 0
+	}
+	return fmt.Errorf("%w: %d consecutive requests failed, last error: %v. Retrying in %s",
+		ErrUnavailable, h.ConsecutiveFailures, h.LastError, retry.Round(time.Second))
+}
+
+// allow returns nil if a request may be sent to the store.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.health.State {
+	case BreakerClosed:
+		return nil
+	case BreakerOpen:
+		if b.now().Sub(b.health.OpenedAt) >= b.cooldown {
+			b.setState(BreakerHalfOpen)
+			return nil
+		}
+	}
+	return b.unavailable()
+}
+
+// record records the outcome of a request sent to the store.
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		if b.health.State == BreakerHalfOpen {
+			// The next request is the trial
+			b.setState(BreakerOpen)
+		}
+		return
+	}
+	if err == nil || errors.Is(err, ErrNotFound) {
+		b.health.ConsecutiveFailures = 0
+		if b.health.State != BreakerClosed {
+			b.health.OpenedAt = time.Time{}
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+	b.health.ConsecutiveFailures++
+	b.health.Failures++
+	b.health.LastError = err
+	if b.health.State == BreakerHalfOpen || (b.health.State == BreakerClosed && b.health.ConsecutiveFailures >= b.threshold) {
+		b.health.Trips++
+		b.health.OpenedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+// setState changes the breaker's state. b.mu must be held.
+func (b *Breaker) setState(s BreakerState) {
+	if b.health.State == s {
+		return
+	}
+	b.health.State = s
+	if b.onChange != nil {
+		b.onChange(b.health)
+	}
+}
+
+// Wrap returns a store which sends requests to s through the breaker. The returned
+// store implements Stater only if s does.
+func (b *Breaker) Wrap(s Store) Store {
+	bs := breakerStore{Store: s, b: b}
+	if st, ok := s.(Stater); ok {
+		return &breakerStater{bs, st}
+	}
+	return &bs
+}
+
+type breakerStore struct {
+	Store
+	b *Breaker
+}
+
+// do sends a request to the store if the breaker allows it.
+func (s *breakerStore) do(ctx context.Context, f func() error) error {
+	if err := s.b.allow(); err != nil {
+		return err
+	}
+	err := f()
+	s.b.record(ctx, err)
+	return err
+}
+
+func (s *breakerStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	return s.do(ctx, func() error {
+		return s.Store.Put(ctx, bucket, key, r)
+	})
+}
+
+func (s *breakerStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := s.do(ctx, func() error {
+		var err error
+		r, err = s.Store.Get(ctx, bucket, key)
+		return err
+	})
+	return r, err
+}
+
+func (s *breakerStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := s.do(ctx, func() error {
+		var err error
+		r, err = s.Store.GetRange(ctx, bucket, key, offset, length)
+		return err
+	})
+	return r, err
+}
+
+func (s *breakerStore) Copy(bucket string, from string, to string) error {
+	return s.do(context.Background(), func() error {
+		return s.Store.Copy(bucket, from, to)
+	})
+}
+
+func (s *breakerStore) Delete(bucket string, key string) error {
+	return s.do(context.Background(), func() error {
+		return s.Store.Delete(bucket, key)
+	})
+}
+
+type breakerStater struct {
+	breakerStore
+	st Stater
+}
+
+func (s *breakerStater) Stat(ctx context.Context, bucket string, key string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := s.do(ctx, func() error {
+		var err error
+		info, err = s.st.Stat(ctx, bucket, key)
+		return err
+	})
+	return info, err
+}
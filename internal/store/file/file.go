@@ -0,0 +1,308 @@
+// Package file implements a store backed by a directory on the local filesystem.
+package file
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/store"
+)
+
+// URLPrefix is the path prefix of the URLs returned by PresignGetURL. A Store serves
+// these URLs with its ServeHTTP method, which should be mounted at this prefix with
+// the prefix stripped.
+const URLPrefix = "/store/objects"
+
+func init() {
+	store.Register("file", openURL)
+}
+
+// openURL opens a store from a URL of the form file:///path/to/dir. The bucket is
+// always empty.
+func openURL(u *url.URL) (store.Store, string, error) {
+	dir := u.Path
+	if u.Opaque != "" {
+		// Relative path e.g. file:data
+		dir = u.Opaque
+	}
+	if dir == "" {
+		return nil, "", errors.New("file URL must include a directory")
+	}
+	s, err := New(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, "", nil
+}
+
+// Store implements the Store interface for a directory on the local filesystem. Each
+// bucket is a subdirectory of the root directory.
+type Store struct {
+	root   string
+	secret []byte
+}
+
+// New creates a new Store saving objects to the directory root. The directory is
+// created if it does not exist.
+func New(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	// URLs are signed with a random secret so they are only valid for the lifetime of
+	// the process
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating URL secret: %w", err)
+	}
+	return &Store{root: root, secret: secret}, nil
+}
+
+// filename returns the location of an object on disk.
+func (s *Store) filename(bucket string, key string) (string, error) {
+	name := path.Join(bucket, key)
+	if key == "" || strings.HasPrefix(name, "..") || path.IsAbs(name) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+// Put saves an object to the store. The object is written to a temporary file and
+// renamed so a partially written object is never visible.
+func (s *Store) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, &ctxReader{ctx, r}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Get returns an object from the store. Returns store.ErrNotFound if the object does
+// not exist.
+func (s *Store) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetRange returns length bytes of an object beginning at offset. Returns
+// store.ErrNotFound if the object does not exist.
+func (s *Store) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	r, err := s.Get(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f := r.(*os.File)
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rangeReader{io.LimitReader(f, int64(length)), f}, nil
+}
+
+// rangeReader reads part of a file and closes the file.
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Stat returns the size of an object. Its ETag is derived from the size and
+// modification time of the file, so it changes whenever the object is written.
+// Returns store.ErrNotFound if the object does not exist.
+func (s *Store) Stat(ctx context.Context, bucket string, key string) (store.ObjectInfo, error) {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return store.ObjectInfo{}, err
+	}
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return store.ObjectInfo{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.ObjectInfo{}, err
+	}
+	return store.ObjectInfo{
+		Size: uint64(info.Size()),
+		ETag: fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}, nil
+}
+
+// List calls fn with the key, size and ETag of each object in the bucket whose key
+// begins with prefix. Objects being written are not listed.
+func (s *Store) List(ctx context.Context, bucket string, prefix string, fn func(key string, info store.ObjectInfo) error) error {
+	root := filepath.Join(s.root, filepath.FromSlash(bucket))
+	// Only walk the directory containing the prefix
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = filepath.Join(root, filepath.FromSlash(prefix[:i]))
+	}
+	err := filepath.Walk(start, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return fn(key, store.ObjectInfo{
+			Size: uint64(info.Size()),
+			ETag: fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		})
+	})
+	if os.IsNotExist(err) {
+		// No objects have the prefix
+		return nil
+	}
+	return err
+}
+
+// Copy makes a copy of an object. Returns store.ErrNotFound if the object does not
+// exist.
+func (s *Store) Copy(bucket string, from string, to string) error {
+	r, err := s.Get(context.Background(), bucket, from)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.Put(context.Background(), bucket, to, r)
+}
+
+// Delete removes an object. As with the S3 store, no error is returned if the object
+// does not exist.
+func (s *Store) Delete(bucket string, key string) error {
+	name, err := s.filename(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PresignGetURL returns a URL to GET an object from the server hosting the store. The
+// URL is relative to the server's address. Ranges are requested by the client with a
+// Range header, so contentRange is not included in the URL.
+func (s *Store) PresignGetURL(bucket string, key string, expires time.Duration, contentRange *store.Range) (string, error) {
+	if _, err := s.filename(bucket, key); err != nil {
+		return "", err
+	}
+	name := path.Join(bucket, key)
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", s.sign(name, exp))
+	return URLPrefix + "/" + name + "?" + q.Encode(), nil
+}
+
+func (s *Store) sign(name string, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(name + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves objects at URLs generated by PresignGetURL, with URLPrefix removed
+// from the request path.
+func (s *Store) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		code := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	q := req.URL.Query()
+	exp := q.Get("expires")
+	sig := q.Get("signature")
+	if !hmac.Equal([]byte(sig), []byte(s.sign(name, exp))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		http.Error(w, "URL expired", http.StatusForbidden)
+		return
+	}
+	filename, err := s.filename("", name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, req, "", info.ModTime(), f)
+}
+
+// ctxReader is an io.Reader which returns an error once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
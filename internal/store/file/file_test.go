@@ -0,0 +1,342 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tempStore(t *testing.T) (*Store, string) {
+	dir, err := ioutil.TempDir("", "jotfs-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func TestImplements(t *testing.T) {
+	assert.Implements(t, (*store.Store)(nil), new(Store))
+	assert.Implements(t, (*store.Stater)(nil), new(Store))
+	assert.Implements(t, (*store.Lister)(nil), new(Store))
+}
+
+func TestList(t *testing.T) {
+	s, dir := tempStore(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	for _, key := range []string{"data/a.csv", "data/2020/b.csv", "data2/c.csv", "d.csv"} {
+		assert.NoError(t, s.Put(ctx, "bucket", key, strings.NewReader(key)))
+	}
+
+	list := func(prefix string) map[string]uint64 {
+		keys := make(map[string]uint64)
+		err := s.List(ctx, "bucket", prefix, func(key string, info store.ObjectInfo) error {
+			keys[key] = info.Size
+			return nil
+		})
+		assert.NoError(t, err)
+		return keys
+	}
+	assert.Equal(t, map[string]uint64{"data/a.csv": 10, "data/2020/b.csv": 15}, list("data/"))
+	assert.Equal(t, map[string]uint64{"data/a.csv": 10, "data/2020/b.csv": 15, "data2/c.csv": 11}, list("data"))
+	assert.Len(t, list(""), 4)
+	assert.Len(t, list("nope/"), 0)
+
+	// Listing stops at the first error
+	errStop := errors.New("stop")
+	n := 0
+	err := s.List(ctx, "bucket", "", func(key string, info store.ObjectInfo) error {
+		n++
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestStore(t *testing.T) {
+	s, dir := tempStore(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	assert.NoError(t, s.Put(ctx, "bucket", "a.pack", strings.NewReader("hello")))
+	b, err := store.GetObject(ctx, s, "bucket", "a.pack")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+
+	assert.NoError(t, s.Copy("bucket", "a.pack", "b.pack"))
+	b, err = store.GetObject(ctx, s, "bucket", "b.pack")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+
+	assert.NoError(t, s.Delete("bucket", "a.pack"))
+	_, err = s.Get(ctx, "bucket", "a.pack")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, s.Copy("bucket", "a.pack", "c.pack"))
+
+	// Deleting a missing object is not an error
+	assert.NoError(t, s.Delete("bucket", "a.pack"))
+
+	info, err := s.Stat(ctx, "bucket", "b.pack")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), info.Size)
+	assert.NotEmpty(t, info.ETag)
+	_, err = s.Stat(ctx, "bucket", "a.pack")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	for _, c := range []struct {
+		offset, length uint64
+		want           string
+	}{{1, 3, "ell"}, {3, 10, "lo"}, {8, 2, ""}} {
+		r, err := s.GetRange(ctx, "bucket", "b.pack", c.offset, c.length)
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, c.want, string(b))
+	}
+	_, err = s.GetRange(ctx, "bucket", "a.pack", 0, 1)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// Keys may not escape the root directory
+	assert.Error(t, s.Put(ctx, "", "../x", strings.NewReader("x")))
+}
+
+func TestPresignGetURL(t *testing.T) {
+	s, dir := tempStore(t)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, s.Put(context.Background(), "", "a.pack", bytes.NewReader([]byte("0123456789"))))
+
+	mux := http.NewServeMux()
+	mux.Handle(URLPrefix+"/", http.StripPrefix(URLPrefix, s))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(u string) (int, string) {
+		req, err := http.NewRequest("GET", srv.URL+u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=2-5")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	u, err := s.PresignGetURL("", "a.pack", time.Minute, &store.Range{From: 2, To: 5})
+	assert.NoError(t, err)
+	code, body := get(u)
+	assert.Equal(t, http.StatusPartialContent, code)
+	assert.Equal(t, "2345", body)
+
+	// Tampered URL
+	code, _ = get(strings.Replace(u, "a.pack", "b.pack", 1))
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Expired URL
+	u, err = s.PresignGetURL("", "a.pack", -time.Minute, nil)
+	assert.NoError(t, err)
+	code, _ = get(u)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-file-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, bucket, err := store.Open("file://" + dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "", bucket)
+	assert.IsType(t, &Store{}, s)
+
+	_, _, err = store.Open("nope://bucket")
+	assert.Error(t, err)
+	_, _, err = store.Open("bucket")
+	assert.Error(t, err)
+}
+
+// failingStore is a Store whose Put, Get and Delete methods fail with err until they
+// have been called failures times. Put reads part of the object before failing.
+type failingStore struct {
+	*Store
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *failingStore) fail() error {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	return nil
+}
+
+func (s *failingStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	if err := s.fail(); err != nil {
+		r.Read(make([]byte, 2))
+		return err
+	}
+	return s.Store.Put(ctx, bucket, key, r)
+}
+
+func (s *failingStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, bucket, key)
+}
+
+func (s *failingStore) Delete(bucket string, key string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Store.Delete(bucket, key)
+}
+
+func TestRetry(t *testing.T) {
+	fs, dir := tempStore(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	errUnavailable := errors.New("503 slow down")
+	s := &failingStore{Store: fs, err: errUnavailable}
+	policy := store.RetryPolicy{MaxAttempts: 3, MinDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	rs := store.Retry(s, policy)
+	assert.Implements(t, (*store.Stater)(nil), rs)
+
+	// A seekable object is read again from the start
+	s.failures = 2
+	err := rs.Put(ctx, "", "a", strings.NewReader("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, s.calls)
+	r, err := fs.Get(ctx, "", "a")
+	assert.NoError(t, err)
+	b, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "hello", string(b))
+
+	// Fails after MaxAttempts
+	s.calls, s.failures = 0, 3
+	_, err = rs.Get(ctx, "", "a")
+	assert.True(t, errors.Is(err, errUnavailable))
+	assert.Equal(t, 3, s.calls)
+
+	// A streamed object is not retried
+	s.calls, s.failures = 0, 1
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("hello"))
+		pw.Close()
+	}()
+	err = rs.Put(ctx, "", "b", pr)
+	assert.True(t, errors.Is(err, errUnavailable))
+	assert.Equal(t, 1, s.calls)
+
+	// ErrNotFound is not retried
+	s.calls, s.failures = 0, 0
+	_, err = rs.Get(ctx, "", "missing")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+	assert.Equal(t, 1, s.calls)
+
+	// Retries are limited by the budget. It starts with 10 retries saved, and the 10
+	// requests add less than one more
+	policy.Budget = 0.1
+	rs = store.Retry(s, policy)
+	s.calls, s.failures = 0, 100
+	for i := 0; i < 10; i++ {
+		assert.Error(t, rs.Delete("", "a"))
+	}
+	assert.Equal(t, 10+10, s.calls)
+	s.failures = 0
+	assert.NoError(t, rs.Delete("", "a"))
+}
+
+func TestBreaker(t *testing.T) {
+	fs, dir := tempStore(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	errUnavailable := errors.New("connection refused")
+	s := &failingStore{Store: fs, err: errUnavailable}
+	var states []store.BreakerState
+	b := store.NewBreaker(2, 50*time.Millisecond, func(h store.BreakerHealth) {
+		states = append(states, h.State)
+	})
+	bs := b.Wrap(s)
+	assert.Implements(t, (*store.Stater)(nil), bs)
+	assert.NoError(t, bs.Put(ctx, "", "a", strings.NewReader("hello")))
+
+	// A missing object is not a failure
+	_, err := bs.Get(ctx, "", "missing")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+	assert.NoError(t, b.Err())
+
+	// Opens after 2 consecutive failures, then fails fast
+	s.calls, s.failures = 0, 2
+	for i := 0; i < 2; i++ {
+		_, err = bs.Get(ctx, "", "a")
+		assert.True(t, errors.Is(err, errUnavailable))
+	}
+	_, err = bs.Get(ctx, "", "a")
+	assert.True(t, errors.Is(err, store.ErrUnavailable))
+	assert.Contains(t, err.Error(), "connection refused")
+	assert.Equal(t, 2, s.calls)
+	h := b.Health()
+	assert.Equal(t, store.BreakerOpen, h.State)
+	assert.Equal(t, 2, h.ConsecutiveFailures)
+	assert.Equal(t, uint64(1), h.Trips)
+	assert.True(t, errors.Is(b.Err(), store.ErrUnavailable))
+
+	// A failed trial request opens it again
+	time.Sleep(60 * time.Millisecond)
+	s.failures = 1
+	assert.Error(t, bs.Delete("", "a"))
+	assert.Equal(t, 3, s.calls)
+	assert.True(t, errors.Is(bs.Delete("", "a"), store.ErrUnavailable))
+	assert.Equal(t, uint64(2), b.Health().Trips)
+
+	// A cancelled request is not counted
+	time.Sleep(60 * time.Millisecond)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	s.failures = 1
+	_, err = bs.Get(cctx, "", "a")
+	assert.True(t, errors.Is(err, errUnavailable))
+	assert.Equal(t, store.BreakerOpen, b.Health().State)
+
+	// A successful trial request closes it
+	r, err := bs.Get(ctx, "", "a")
+	assert.NoError(t, err)
+	r.Close()
+	h = b.Health()
+	assert.Equal(t, store.BreakerClosed, h.State)
+	assert.Zero(t, h.ConsecutiveFailures)
+	assert.Equal(t, uint64(3), h.Failures)
+	assert.NoError(t, b.Err())
+	assert.Equal(t, []store.BreakerState{
+		store.BreakerOpen, store.BreakerHalfOpen, store.BreakerOpen,
+		store.BreakerHalfOpen, store.BreakerOpen, store.BreakerHalfOpen, store.BreakerClosed,
+	}, states)
+}
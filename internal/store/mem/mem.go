@@ -0,0 +1,277 @@
+// Package mem implements a store which keeps objects in memory. Its objects are lost
+// when the process exits, so it's intended for tests and trying out a server.
+package mem
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/store/file"
+)
+
+func init() {
+	store.Register("mem", openURL)
+}
+
+// openURL opens a new, empty store from a URL of the form mem://bucket.
+func openURL(u *url.URL) (store.Store, string, error) {
+	s, err := New()
+	if err != nil {
+		return nil, "", err
+	}
+	return s, u.Host, nil
+}
+
+// Store implements the Store interface in memory.
+type Store struct {
+	secret []byte
+
+	mu      sync.RWMutex
+	objects map[string]object
+}
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// New creates a new, empty Store.
+func New() (*Store, error) {
+	// URLs are signed with a random secret so a URL from one store is not valid for
+	// another
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating URL secret: %w", err)
+	}
+	return &Store{secret: secret, objects: make(map[string]object)}, nil
+}
+
+// name returns the name an object is saved under.
+func name(bucket string, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return path.Join(bucket, key), nil
+}
+
+// Put saves an object to the store. The object is only visible once it has been read
+// completely.
+func (s *Store) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	name, err := name(bucket, key)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(&ctxReader{ctx, r})
+	if err != nil {
+		return err
+	}
+	s.put(name, b)
+	return nil
+}
+
+func (s *Store) put(name string, b []byte) {
+	md5sum := md5.Sum(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = object{data: b, etag: hex.EncodeToString(md5sum[:]), modTime: time.Now()}
+}
+
+func (s *Store) get(bucket string, key string) (object, error) {
+	name, err := name(bucket, key)
+	if err != nil {
+		return object{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.objects[name]
+	if !ok {
+		return object{}, store.ErrNotFound
+	}
+	return o, nil
+}
+
+// Get returns an object from the store. Returns store.ErrNotFound if the object does
+// not exist.
+func (s *Store) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	o, err := s.get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+// GetRange returns length bytes of an object beginning at offset. Returns
+// store.ErrNotFound if the object does not exist.
+func (s *Store) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	o, err := s.get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	b := o.data
+	if offset > uint64(len(b)) {
+		offset = uint64(len(b))
+	}
+	b = b[offset:]
+	if length < uint64(len(b)) {
+		b = b[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Stat returns the size and ETag of an object. Its ETag is the MD5 hash of its data, as
+// for single part uploads to S3. Returns store.ErrNotFound if the object does not
+// exist.
+func (s *Store) Stat(ctx context.Context, bucket string, key string) (store.ObjectInfo, error) {
+	o, err := s.get(bucket, key)
+	if err != nil {
+		return store.ObjectInfo{}, err
+	}
+	return store.ObjectInfo{Size: uint64(len(o.data)), ETag: o.etag}, nil
+}
+
+// List calls fn with the key, size and ETag of each object in the bucket whose key
+// begins with prefix, in key order.
+func (s *Store) List(ctx context.Context, bucket string, prefix string, fn func(key string, info store.ObjectInfo) error) error {
+	type entry struct {
+		key  string
+		info store.ObjectInfo
+	}
+	root := bucket + "/"
+	if bucket == "" {
+		root = ""
+	}
+	s.mu.RLock()
+	var entries []entry
+	for name, o := range s.objects {
+		if !strings.HasPrefix(name, root+prefix) {
+			continue
+		}
+		info := store.ObjectInfo{Size: uint64(len(o.data)), ETag: o.etag}
+		entries = append(entries, entry{name[len(root):], info})
+	}
+	s.mu.RUnlock()
+
+	// Objects are listed without holding the lock, so fn may use the store
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(e.key, e.info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy makes a copy of an object. Returns store.ErrNotFound if the object does not
+// exist.
+func (s *Store) Copy(bucket string, from string, to string) error {
+	o, err := s.get(bucket, from)
+	if err != nil {
+		return err
+	}
+	name, err := name(bucket, to)
+	if err != nil {
+		return err
+	}
+	s.put(name, o.data)
+	return nil
+}
+
+// Delete removes an object. As with the S3 store, no error is returned if the object
+// does not exist.
+func (s *Store) Delete(bucket string, key string) error {
+	name, err := name(bucket, key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+	return nil
+}
+
+// PresignGetURL returns a URL to GET an object from the server hosting the store. As
+// for the file store, the URL is relative to the server's address and begins with
+// file.URLPrefix. Ranges are requested by the client with a Range header, so
+// contentRange is not included in the URL.
+func (s *Store) PresignGetURL(bucket string, key string, expires time.Duration, contentRange *store.Range) (string, error) {
+	name, err := name(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", s.sign(name, exp))
+	return file.URLPrefix + "/" + name + "?" + q.Encode(), nil
+}
+
+func (s *Store) sign(name string, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(name + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves objects at URLs generated by PresignGetURL, with file.URLPrefix
+// removed from the request path.
+func (s *Store) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		code := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	q := req.URL.Query()
+	exp := q.Get("expires")
+	sig := q.Get("signature")
+	if !hmac.Equal([]byte(sig), []byte(s.sign(name, exp))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expUnix {
+		http.Error(w, "URL expired", http.StatusForbidden)
+		return
+	}
+	s.mu.RLock()
+	o, ok := s.objects[name]
+	s.mu.RUnlock()
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	http.ServeContent(w, req, "", o.modTime, bytes.NewReader(o.data))
+}
+
+// ctxReader is an io.Reader which returns an error once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,165 @@
+package mem
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/store/file"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStore(t *testing.T) *Store {
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestImplements(t *testing.T) {
+	assert.Implements(t, (*store.Store)(nil), new(Store))
+	assert.Implements(t, (*store.Stater)(nil), new(Store))
+	assert.Implements(t, (*store.Lister)(nil), new(Store))
+}
+
+func TestStore(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+
+	assert.NoError(t, s.Put(ctx, "bucket", "a.pack", strings.NewReader("hello")))
+	b, err := store.GetObject(ctx, s, "bucket", "a.pack")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+	_, err = s.Get(ctx, "other", "a.pack")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	assert.NoError(t, s.Copy("bucket", "a.pack", "b.pack"))
+	b, err = store.GetObject(ctx, s, "bucket", "b.pack")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), b)
+
+	assert.NoError(t, s.Delete("bucket", "a.pack"))
+	_, err = s.Get(ctx, "bucket", "a.pack")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, s.Copy("bucket", "a.pack", "c.pack"))
+
+	// Deleting a missing object is not an error
+	assert.NoError(t, s.Delete("bucket", "a.pack"))
+
+	info, err := s.Stat(ctx, "bucket", "b.pack")
+	assert.NoError(t, err)
+	assert.Equal(t, store.ObjectInfo{Size: 5, ETag: "5d41402abc4b2a76b9719d911017c592"}, info)
+	_, err = s.Stat(ctx, "bucket", "a.pack")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	for _, c := range []struct {
+		offset, length uint64
+		want           string
+	}{{1, 3, "ell"}, {3, 10, "lo"}, {8, 2, ""}} {
+		r, err := s.GetRange(ctx, "bucket", "b.pack", c.offset, c.length)
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, c.want, string(b))
+	}
+	_, err = s.GetRange(ctx, "bucket", "a.pack", 0, 1)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// A cancelled Put saves nothing
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Error(t, s.Put(cctx, "bucket", "c.pack", strings.NewReader("x")))
+	_, err = s.Stat(ctx, "bucket", "c.pack")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestList(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	for _, key := range []string{"data/a.csv", "data/2020/b.csv", "data2/c.csv", "d.csv"} {
+		assert.NoError(t, s.Put(ctx, "bucket", key, strings.NewReader(key)))
+	}
+	assert.NoError(t, s.Put(ctx, "bucket2", "data/e.csv", strings.NewReader("e")))
+
+	list := func(prefix string) []string {
+		var keys []string
+		err := s.List(ctx, "bucket", prefix, func(key string, info store.ObjectInfo) error {
+			assert.EqualValues(t, len(key), info.Size)
+			keys = append(keys, key)
+			return nil
+		})
+		assert.NoError(t, err)
+		return keys
+	}
+	assert.Equal(t, []string{"data/2020/b.csv", "data/a.csv"}, list("data/"))
+	assert.Equal(t, []string{"data/2020/b.csv", "data/a.csv", "data2/c.csv"}, list("data"))
+	assert.Len(t, list(""), 4)
+	assert.Len(t, list("nope/"), 0)
+
+	// Listing stops at the first error
+	errStop := errors.New("stop")
+	n := 0
+	err := s.List(ctx, "bucket", "", func(key string, info store.ObjectInfo) error {
+		n++
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestPresignGetURL(t *testing.T) {
+	s := newStore(t)
+	assert.NoError(t, s.Put(context.Background(), "", "a.pack", strings.NewReader("0123456789")))
+
+	mux := http.NewServeMux()
+	mux.Handle(file.URLPrefix+"/", http.StripPrefix(file.URLPrefix, s))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(u string) (int, string) {
+		req, err := http.NewRequest("GET", srv.URL+u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=2-5")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	u, err := s.PresignGetURL("", "a.pack", time.Minute, &store.Range{From: 2, To: 5})
+	assert.NoError(t, err)
+	code, body := get(u)
+	assert.Equal(t, http.StatusPartialContent, code)
+	assert.Equal(t, "2345", body)
+
+	// Tampered URL
+	code, _ = get(strings.Replace(u, "a.pack", "b.pack", 1))
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Expired URL
+	u, err = s.PresignGetURL("", "a.pack", -time.Minute, nil)
+	assert.NoError(t, err)
+	code, _ = get(u)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestOpen(t *testing.T) {
+	s, bucket, err := store.Open("mem://test")
+	assert.NoError(t, err)
+	assert.Equal(t, "test", bucket)
+	assert.IsType(t, &Store{}, s)
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Driver opens a Store from a URL. It also returns the name of the bucket given in the
+// URL.
+type Driver func(u *url.URL) (Store, string, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a store driver available for URLs with the given scheme. Drivers
+// usually call Register from the init function of their package. Register panics if
+// a driver is already registered for the scheme.
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("store: Register driver is nil")
+	}
+	if _, ok := drivers[scheme]; ok {
+		panic("store: Register called twice for scheme " + scheme)
+	}
+	drivers[scheme] = driver
+}
+
+// Drivers returns the schemes of all registered drivers in sorted order.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open opens a Store using the driver registered for the scheme of rawURL, e.g.
+// "s3://bucket" or "file:///var/lib/jotfs". Returns the store and the bucket named in
+// the URL.
+func Open(rawURL string) (Store, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid store URL: %w", err)
+	}
+	if u.Scheme == "" {
+		return nil, "", fmt.Errorf("store URL %q has no scheme", rawURL)
+	}
+	driversMu.RLock()
+	driver, ok := drivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unknown store scheme %q (registered: %v)", u.Scheme, Drivers())
+	}
+	return driver(u)
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryMinDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay = 5 * time.Second
+
+	// retryBudgetCap is the maximum number of retries saved in a retry budget, so a
+	// burst of failures after a quiet period is retried.
+	retryBudgetCap = 10
+)
+
+// RetryPolicy configures the retries of failed store requests.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is made. Requests are not
+	// retried if it's less than 2.
+	MaxAttempts int
+
+	// MinDelay is the delay before the first retry, which doubles with each retry up
+	// to MaxDelay. A random delay of up to this amount is used, so requests which
+	// failed together are not retried together. Default to 100ms and 5s.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// Budget limits retries to this fraction of requests, e.g. 0.1 for one retry per
+	// ten requests, so a store which is failing every request is not sent more
+	// retries than requests. Retries are not limited if it's zero.
+	Budget float64
+}
+
+// Retryable is implemented by stores which can tell whether a failed request may
+// succeed if it's retried, e.g. because the store was throttling requests.
+type Retryable interface {
+	Retryable(err error) bool
+}
+
+// Retry wraps a store so failed requests are retried according to the policy. Get,
+// GetRange, Copy, Delete and Stat are idempotent, so are always retried. A Put is
+// only retried if its reader is an io.Seeker, so it can be read again from the start.
+// Only errors the store classifies as retryable are retried if it implements
+// Retryable, otherwise every error except ErrNotFound and a cancelled context. The
+// returned store implements Stater only if s does.
+func Retry(s Store, p RetryPolicy) Store {
+	if p.MinDelay <= 0 {
+		p.MinDelay = defaultRetryMinDelay
+	}
+	if p.MaxDelay < p.MinDelay {
+		p.MaxDelay = defaultRetryMaxDelay
+		if p.MaxDelay < p.MinDelay {
+			p.MaxDelay = p.MinDelay
+		}
+	}
+	rs := retryStore{Store: s, policy: p, budget: &retryBudget{ratio: p.Budget, tokens: retryBudgetCap}}
+	if r, ok := s.(Retryable); ok {
+		rs.retryable = r.Retryable
+	} else {
+		rs.retryable = defaultRetryable
+	}
+	if st, ok := s.(Stater); ok {
+		return &retryStater{rs, st}
+	}
+	return &rs
+}
+
+func defaultRetryable(err error) bool {
+	return !errors.Is(err, ErrNotFound) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+type retryStore struct {
+	Store
+	policy    RetryPolicy
+	budget    *retryBudget
+	retryable func(err error) bool
+}
+
+// do calls f until it succeeds, returns an error which is not retryable, or has been
+// called MaxAttempts times. The delay between attempts ends early if ctx is done.
+func (s *retryStore) do(ctx context.Context, f func() error) error {
+	s.budget.deposit()
+	delay := s.policy.MinDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= s.policy.MaxAttempts || !s.retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if !s.budget.withdraw() {
+			return err
+		}
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(delay)) + 1))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		if delay *= 2; delay > s.policy.MaxDelay {
+			delay = s.policy.MaxDelay
+		}
+	}
+}
+
+func (s *retryStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return s.Store.Put(ctx, bucket, key, r)
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return s.Store.Put(ctx, bucket, key, r)
+	}
+	first := true
+	return s.do(ctx, func() error {
+		if !first {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		first = false
+		return s.Store.Put(ctx, bucket, key, r)
+	})
+}
+
+func (s *retryStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := s.do(ctx, func() error {
+		var err error
+		r, err = s.Store.Get(ctx, bucket, key)
+		return err
+	})
+	return r, err
+}
+
+func (s *retryStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := s.do(ctx, func() error {
+		var err error
+		r, err = s.Store.GetRange(ctx, bucket, key, offset, length)
+		return err
+	})
+	return r, err
+}
+
+func (s *retryStore) Copy(bucket string, from string, to string) error {
+	return s.do(context.Background(), func() error {
+		return s.Store.Copy(bucket, from, to)
+	})
+}
+
+func (s *retryStore) Delete(bucket string, key string) error {
+	return s.do(context.Background(), func() error {
+		return s.Store.Delete(bucket, key)
+	})
+}
+
+type retryStater struct {
+	retryStore
+	st Stater
+}
+
+func (s *retryStater) Stat(ctx context.Context, bucket string, key string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := s.do(ctx, func() error {
+		var err error
+		info, err = s.st.Stat(ctx, bucket, key)
+		return err
+	})
+	return info, err
+}
+
+// retryBudget limits the number of retries to a fraction of the number of requests.
+// Each request adds the fraction to the budget, and each retry spends one.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens += b.ratio; b.tokens > retryBudgetCap {
+		b.tokens = retryBudgetCap
+	}
+}
+
+// withdraw returns false if there is no budget for a retry.
+func (b *retryBudget) withdraw() bool {
+	if b.ratio <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// ReceiveEvents long-polls an SQS queue for bucket notifications and passes the body
+// of each message to handle. A message is deleted from the queue once handle returns
+// without error, otherwise it is redelivered after its visibility timeout. Blocks
+// until ctx is cancelled or the queue cannot be read.
+func (s *Store) ReceiveEvents(ctx context.Context, queueURL string, handle func(body []byte) error) error {
+	svc := sqs.New(s.sess)
+	for {
+		resp, err := svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            &queueURL,
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("receiving messages: %w", err)
+		}
+		for _, msg := range resp.Messages {
+			if err := handle([]byte(aws.StringValue(msg.Body))); err != nil {
+				continue
+			}
+			_, err := svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      &queueURL,
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("deleting message: %w", err)
+			}
+		}
+	}
+}
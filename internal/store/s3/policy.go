@@ -0,0 +1,132 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// The IAM actions required by the store. These must be kept up to date with the S3
+// and SQS operations used in this package.
+var (
+	// objectActions are required on every object in the bucket.
+	objectActions = []string{
+		"s3:GetObject",            // Get, PresignGetURL and the source of Copy
+		"s3:PutObject",            // Put and the destination of Copy
+		"s3:AbortMultipartUpload", // Put aborts a failed multipart upload
+		"s3:DeleteObject",         // Delete
+	}
+
+	// bucketActions are required on the bucket. Without s3:ListBucket, S3 returns
+	// AccessDenied instead of NoSuchKey when getting an object which does not exist,
+	// so Get cannot return store.ErrNotFound.
+	bucketActions = []string{
+		"s3:ListBucket",
+	}
+
+	// queueActions are required on the queue read by ReceiveEvents.
+	queueActions = []string{
+		"sqs:ReceiveMessage",
+		"sqs:DeleteMessage",
+	}
+)
+
+// PolicyConfig describes how the store is used by the server.
+type PolicyConfig struct {
+	Bucket string
+	// Region is used to choose the AWS partition of the resource ARNs. Defaults to
+	// the standard partition if empty.
+	Region string
+	// EventsQueue is the URL of the SQS queue bucket notifications are read from, if
+	// any.
+	EventsQueue string
+}
+
+// PolicyDocument is an IAM policy document.
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a single statement in an IAM policy.
+type PolicyStatement struct {
+	Sid      string   `json:"Sid"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// Policy returns the least privilege IAM policy granting the permissions the store
+// requires.
+func Policy(cfg PolicyConfig) (PolicyDocument, error) {
+	if cfg.Bucket == "" {
+		return PolicyDocument{}, fmt.Errorf("bucket is required")
+	}
+	partition := partitionID(cfg.Region)
+	bucketARN := fmt.Sprintf("arn:%s:s3:::%s", partition, cfg.Bucket)
+	doc := PolicyDocument{
+		Version: "2012-10-17",
+		Statement: []PolicyStatement{
+			{
+				Sid:      "JotFSObjects",
+				Effect:   "Allow",
+				Action:   objectActions,
+				Resource: []string{bucketARN + "/*"},
+			},
+			{
+				Sid:      "JotFSBucket",
+				Effect:   "Allow",
+				Action:   bucketActions,
+				Resource: []string{bucketARN},
+			},
+		},
+	}
+	if cfg.EventsQueue != "" {
+		queueARN, err := queueARN(cfg.EventsQueue, cfg.Region)
+		if err != nil {
+			return PolicyDocument{}, err
+		}
+		doc.Statement = append(doc.Statement, PolicyStatement{
+			Sid:      "JotFSEvents",
+			Effect:   "Allow",
+			Action:   queueActions,
+			Resource: []string{queueARN},
+		})
+	}
+	return doc, nil
+}
+
+// partitionID returns the AWS partition containing region, e.g. "aws" or "aws-cn".
+func partitionID(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
+// queueARN converts an SQS queue URL, e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/queue, to its ARN. The region in
+// the URL's host takes precedence over region.
+func queueARN(queueURL string, region string) (string, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid queue URL: %w", err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid queue URL %q: path must be /<account>/<queue>", queueURL)
+	}
+	host := strings.Split(u.Hostname(), ".")
+	if len(host) > 2 && host[0] == "sqs" {
+		region = host[1]
+	} else if len(host) > 2 && host[1] == "queue" {
+		// Legacy format <region>.queue.amazonaws.com
+		region = host[0]
+	}
+	if region == "" {
+		return "", fmt.Errorf("unable to determine the region of queue %q", queueURL)
+	}
+	return fmt.Sprintf("arn:%s:sqs:%s:%s:%s", partitionID(region), region, parts[0], parts[1]), nil
+}
@@ -2,21 +2,30 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/jotfs/jotfs/internal/store"
 )
 
-// Config stores the configuration for the S3 store.
+// Config stores the configuration for the S3 store. If AccessKey is not set, credentials
+// are obtained from the default AWS credential chain: environment variables, the shared
+// credentials and config files, web identity tokens, and ECS or EC2 instance roles.
 type Config struct {
 	Region     string
 	Endpoint   string
@@ -24,16 +33,120 @@ type Config struct {
 	SecretKey  string
 	PathStyle  bool
 	DisableSSL bool
+
+	// RoleARN, if set, is an IAM role to assume using the credentials above. Temporary
+	// credentials for the role are refreshed automatically before they expire.
+	RoleARN string
+	// ExternalID is the external ID to provide when assuming RoleARN, if required by
+	// the role's trust policy.
+	ExternalID string
+
+	// PartSize is the size, in bytes, of the parts objects are uploaded in. Objects no
+	// larger than a part are uploaded in a single request. Defaults to 8 MiB, and may
+	// not be less than 5 MiB.
+	PartSize int64
+	// UploadConcurrency is the number of parts of an object uploaded at once. Each
+	// upload buffers up to PartSize * UploadConcurrency bytes. Defaults to 4.
+	UploadConcurrency int
 }
 
+const (
+	defaultPartSize          = 8 * 1024 * 1024
+	defaultUploadConcurrency = 4
+
+	// abortTimeout is the maximum time allowed to abort a failed multipart upload.
+	abortTimeout = 30 * time.Second
+)
+
 // Store implements the Store interface for an S3-compatible backend.
 type Store struct {
-	cfg Config
-	svc *s3.S3
+	cfg  Config
+	svc  *s3.S3
+	sess *session.Session
+}
+
+func init() {
+	store.Register("s3", openURL)
+}
+
+// openURL opens a store from a URL of the form:
+//
+//	s3://[access_key:secret_key@]bucket[?endpoint=...&region=...&path_style=true&disable_ssl=true&role_arn=...&external_id=...&part_size=...&upload_concurrency=...]
+//
+// The AWS default credential chain is used if the URL has no user info. The part_size
+// is in MiB.
+func openURL(u *url.URL) (store.Store, string, error) {
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("s3 URL must include a bucket name")
+	}
+	q := u.Query()
+	cfg := Config{
+		Endpoint:   q.Get("endpoint"),
+		Region:     q.Get("region"),
+		RoleARN:    q.Get("role_arn"),
+		ExternalID: q.Get("external_id"),
+	}
+	if u.User != nil {
+		cfg.AccessKey = u.User.Username()
+		cfg.SecretKey, _ = u.User.Password()
+	}
+	var err error
+	if cfg.PathStyle, err = queryBool(q, "path_style"); err != nil {
+		return nil, "", err
+	}
+	if cfg.DisableSSL, err = queryBool(q, "disable_ssl"); err != nil {
+		return nil, "", err
+	}
+	partSize, err := queryInt(q, "part_size")
+	if err != nil {
+		return nil, "", err
+	}
+	cfg.PartSize = int64(partSize) * 1024 * 1024
+	if cfg.UploadConcurrency, err = queryInt(q, "upload_concurrency"); err != nil {
+		return nil, "", err
+	}
+	s, err := New(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	return s, u.Host, nil
+}
+
+func queryBool(q url.Values, name string) (bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return b, nil
+}
+
+func queryInt(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
 }
 
 // New creates a new client for accessing an S3-backed store.
 func New(cfg Config) (*Store, error) {
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < s3manager.MinUploadPartSize {
+		return nil, fmt.Errorf("part size must be at least %d bytes", s3manager.MinUploadPartSize)
+	}
+	if cfg.UploadConcurrency <= 0 {
+		cfg.UploadConcurrency = defaultUploadConcurrency
+	}
 	acfg := aws.Config{
 		Endpoint:         &cfg.Endpoint,
 		S3ForcePathStyle: &cfg.PathStyle,
@@ -42,28 +155,62 @@ func New(cfg Config) (*Store, error) {
 	}
 	if cfg.AccessKey != "" {
 		acfg.Credentials = credentials.NewStaticCredentials(cfg.AccessKey, cfg.SecretKey, "")
-	} else {
-		fmt.Println("Using shared credentials")
-		acfg.Credentials = credentials.NewSharedCredentials("", "")
 	}
-	sess, err := session.NewSession(&acfg)
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            acfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
 	if err != nil {
 		return nil, err
 	}
+	if cfg.RoleARN != "" {
+		// The STS client uses the credentials, but not the custom endpoint, of sess
+		stsCfg := aws.Config{Endpoint: aws.String("")}
+		creds := stscreds.NewCredentials(sess.Copy(&stsCfg), cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if cfg.ExternalID != "" {
+				p.ExternalID = &cfg.ExternalID
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
 	svc := s3.New(sess)
-	return &Store{cfg, svc}, nil
+	return &Store{cfg, svc, sess}, nil
 }
 
-// Put saves an object to S3.
+// Put saves an object to S3. Objects larger than the part size are uploaded in parts
+// with a multipart upload, which is aborted if the upload fails, so S3 does not keep
+// its parts.
 func (s *Store) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
 	uploader := s3manager.NewUploaderWithClient(s.svc, func(u *s3manager.Uploader) {
-		u.Concurrency = 1
+		u.PartSize = s.cfg.PartSize
+		u.Concurrency = s.cfg.UploadConcurrency
+		// The uploader aborts with ctx, which fails if ctx was cancelled, so failed
+		// uploads are aborted below instead
+		u.LeavePartsOnError = true
 	})
 	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Body:   r,
 		Bucket: &bucket,
 		Key:    &key,
 	})
+	var mpErr s3manager.MultiUploadFailure
+	if errors.As(err, &mpErr) {
+		if aerr := s.abortUpload(bucket, key, mpErr.UploadID()); aerr != nil {
+			return fmt.Errorf("%w; aborting multipart upload: %v", err, aerr)
+		}
+	}
+	return err
+}
+
+// abortUpload aborts a multipart upload and deletes its uploaded parts.
+func (s *Store) abortUpload(bucket string, key string, uploadID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	_, err := s.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
 	return err
 }
 
@@ -85,6 +232,107 @@ func (s *Store) Get(ctx context.Context, bucket string, key string) (io.ReadClos
 	return resp.Body, nil
 }
 
+// GetRange returns length bytes of an object beginning at offset, using a ranged GET
+// request. Returns store.ErrNotFound if the object does not exist.
+func (s *Store) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	if length == 0 {
+		// A range cannot be empty
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	resp, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if aerr, ok := err.(awserr.Error); ok {
+		if aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, store.ErrNotFound
+		}
+		if aerr.Code() == "InvalidRange" {
+			// The range begins after the end of the object
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat returns the size and ETag of an object. Returns store.ErrNotFound if the
+// object does not exist.
+func (s *Store) Stat(ctx context.Context, bucket string, key string) (store.ObjectInfo, error) {
+	resp, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if aerr, ok := err.(awserr.Error); ok {
+		// HEAD responses have no body, so S3 returns a generic code
+		if aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey {
+			return store.ObjectInfo{}, store.ErrNotFound
+		}
+	}
+	if err != nil {
+		return store.ObjectInfo{}, err
+	}
+	return store.ObjectInfo{
+		Size: uint64(aws.Int64Value(resp.ContentLength)),
+		ETag: strings.Trim(aws.StringValue(resp.ETag), `"`),
+	}, nil
+}
+
+// List calls fn with the key, size and ETag of each object in the bucket whose key
+// begins with prefix, in lexical order of key.
+func (s *Store) List(ctx context.Context, bucket string, prefix string, fn func(key string, info store.ObjectInfo) error) error {
+	input := &s3.ListObjectsV2Input{Bucket: &bucket, Prefix: &prefix}
+	var ferr error
+	err := s.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			info := store.ObjectInfo{
+				Size: uint64(aws.Int64Value(obj.Size)),
+				ETag: strings.Trim(aws.StringValue(obj.ETag), `"`),
+			}
+			if ferr = fn(aws.StringValue(obj.Key), info); ferr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// Retryable returns true if a request which failed with err may succeed if retried:
+// if the request was throttled, failed with a server error or timed out, or the
+// connection failed.
+func (s *Store) Retryable(err error) bool {
+	if errors.Is(err, store.ErrNotFound) {
+		return false
+	}
+	var mpErr s3manager.MultiUploadFailure
+	if errors.As(err, &mpErr) && mpErr.OrigErr() != nil {
+		// The error of the failed part
+		err = mpErr.OrigErr()
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	if aerr.Code() == request.CanceledErrorCode {
+		return false
+	}
+	if request.IsErrorRetryable(aerr) || request.IsErrorThrottle(aerr) {
+		return true
+	}
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) {
+		return rf.StatusCode() >= 500 || rf.StatusCode() == 429
+	}
+	return false
+}
+
 // Copy makes a copy of an object.
 func (s *Store) Copy(bucket string, from string, to string) error {
 	_, err := s.svc.CopyObject(&s3.CopyObjectInput{
@@ -4,17 +4,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/jotfs/jotfs/internal/store"
 
 	"github.com/stretchr/testify/assert"
@@ -52,6 +58,7 @@ func TestMain(m *testing.M) {
 func TestImplements(t *testing.T) {
 	// Ensure the S3 Store implements the Store interface
 	assert.Implements(t, (*store.Store)(nil), new(Store))
+	assert.Implements(t, (*store.Lister)(nil), new(Store))
 }
 
 func TestPut(t *testing.T) {
@@ -71,9 +78,23 @@ func TestPut(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, data, dataGet)
 
+	// Get part of the object
+	for _, c := range []struct {
+		offset, length uint64
+		want           string
+	}{{6, 5, "world"}, {6, 100, "world!"}, {100, 1, ""}, {0, 0, ""}} {
+		r, err = s.GetRange(ctx, bucket, k0, c.offset, c.length)
+		assert.NoError(t, err)
+		dataGet, err = ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, string(dataGet))
+	}
+
 	// Error if object doesn't exist
 	_, err = s.Get(ctx, bucket, "does-not-exist")
 	assert.Equal(t, store.ErrNotFound, err)
+	_, err = s.GetRange(ctx, bucket, "does-not-exist", 0, 1)
+	assert.Equal(t, store.ErrNotFound, err)
 
 	// Delete
 	err = s.Delete(bucket, k0)
@@ -88,6 +109,57 @@ func TestPut(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	ms, err := New(Config{
+		Endpoint:          cfg.Endpoint,
+		AccessKey:         cfg.AccessKey,
+		SecretKey:         cfg.SecretKey,
+		DisableSSL:        true,
+		PathStyle:         true,
+		Region:            cfg.Region,
+		PartSize:          s3manager.MinUploadPartSize,
+		UploadConcurrency: 2,
+	})
+	assert.NoError(t, err)
+
+	// Objects larger than a part are uploaded in parts
+	k0 := randKey()
+	data := make([]byte, 12*1024*1024)
+	rand.Read(data)
+	assert.NoError(t, ms.Put(ctx, bucket, k0, bytes.NewReader(data)))
+	defer ms.Delete(bucket, k0)
+	info, err := ms.Stat(ctx, bucket, k0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), info.Size)
+	assert.True(t, strings.HasSuffix(info.ETag, "-3"))
+
+	// A failed upload is aborted
+	k1 := randKey()
+	r := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(errors.New("read failed")))
+	assert.Error(t, ms.Put(ctx, bucket, k1, r))
+	uploads, err := ms.svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(bucket), Prefix: &k1})
+	assert.NoError(t, err)
+	assert.Empty(t, uploads.Uploads)
+}
+
+func TestUploadConfig(t *testing.T) {
+	st, _, err := openURL(&url.URL{Scheme: "s3", Host: "b", RawQuery: "part_size=16&upload_concurrency=8"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(16*1024*1024), st.(*Store).cfg.PartSize)
+	assert.Equal(t, 8, st.(*Store).cfg.UploadConcurrency)
+
+	st, _, err = openURL(&url.URL{Scheme: "s3", Host: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(defaultPartSize), st.(*Store).cfg.PartSize)
+	assert.Equal(t, defaultUploadConcurrency, st.(*Store).cfg.UploadConcurrency)
+
+	_, _, err = openURL(&url.URL{Scheme: "s3", Host: "b", RawQuery: "part_size=1"})
+	assert.Error(t, err)
+	_, _, err = openURL(&url.URL{Scheme: "s3", Host: "b", RawQuery: "upload_concurrency=x"})
+	assert.Error(t, err)
+}
+
 func TestCopy(t *testing.T) {
 	ctx := context.Background()
 
@@ -166,3 +238,62 @@ func (s *Store) makeBucket(name string) error {
 	})
 	return err
 }
+
+func TestCredentialChain(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "env-access-key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret-key")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	// Credentials are read from the environment if static keys are not set
+	s, err := New(Config{Region: "eu-west-1"})
+	assert.NoError(t, err)
+	v, err := s.sess.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "env-access-key", v.AccessKeyID)
+
+	// Static keys take precedence
+	s, err = New(Config{Region: "eu-west-1", AccessKey: "a", SecretKey: "b"})
+	assert.NoError(t, err)
+	v, err = s.sess.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", v.AccessKeyID)
+}
+
+func TestPolicy(t *testing.T) {
+	doc, err := Policy(PolicyConfig{Bucket: "data", Region: "eu-west-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "2012-10-17", doc.Version)
+	if assert.Len(t, doc.Statement, 2) {
+		assert.Equal(t, []string{"arn:aws:s3:::data/*"}, doc.Statement[0].Resource)
+		assert.Contains(t, doc.Statement[0].Action, "s3:PutObject")
+		assert.NotContains(t, doc.Statement[0].Action, "s3:*")
+		assert.Equal(t, []string{"arn:aws:s3:::data"}, doc.Statement[1].Resource)
+	}
+
+	// Events queue
+	doc, err = Policy(PolicyConfig{Bucket: "data", EventsQueue: "https://sqs.cn-north-1.amazonaws.com.cn/123456789012/events"})
+	assert.NoError(t, err)
+	if assert.Len(t, doc.Statement, 3) {
+		assert.Equal(t, []string{"arn:aws-cn:sqs:cn-north-1:123456789012:events"}, doc.Statement[2].Resource)
+		assert.Equal(t, []string{"sqs:ReceiveMessage", "sqs:DeleteMessage"}, doc.Statement[2].Action)
+	}
+
+	// Legacy queue URL
+	arn, err := queueARN("https://us-west-2.queue.amazonaws.com/123456789012/events", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:sqs:us-west-2:123456789012:events", arn)
+
+	// Region from config
+	arn, err = queueARN("http://localhost:9324/123456789012/events", "us-east-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:events", arn)
+
+	// Errors
+	_, err = Policy(PolicyConfig{})
+	assert.Error(t, err)
+	_, err = queueARN("http://localhost:9324/events", "us-east-1")
+	assert.Error(t, err)
+	_, err = queueARN("http://localhost:9324/123456789012/events", "")
+	assert.Error(t, err)
+}
@@ -12,12 +12,22 @@ import (
 // ErrNotFound is returned when an object in the store could not be found.
 var ErrNotFound = errors.New("not found")
 
+// ErrUnavailable is returned by a store wrapped by a Breaker while requests are failing
+// fast because the store is unreachable.
+var ErrUnavailable = errors.New("store unavailable")
+
 // Store is an interface to an object store.
 type Store interface {
 	Put(ctx context.Context, bucket string, key string, r io.Reader) error
 
 	Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
 
+	// GetRange returns length bytes of an object beginning at offset, so part of a
+	// large object can be read without downloading the rest of it. Fewer bytes are
+	// returned if the object ends first. Returns ErrNotFound if the object does not
+	// exist.
+	GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error)
+
 	// Copy makes a copy of a file. Returns an error if the file does not exist.
 	Copy(bucket string, from string, to string) error
 
@@ -34,6 +44,52 @@ type Range struct {
 	To   uint64
 }
 
+// ObjectInfo describes an object in a store.
+type ObjectInfo struct {
+	Size uint64
+	// ETag identifies the content of the object. Its format depends on the store, so
+	// it may only be compared with other ETags from the same store. Empty if the
+	// store does not provide ETags.
+	ETag string
+}
+
+// Stater is implemented by stores which can get the info for an object without
+// reading it.
+type Stater interface {
+	// Stat returns the info for an object. Returns ErrNotFound if the object does not
+	// exist.
+	Stat(ctx context.Context, bucket string, key string) (ObjectInfo, error)
+}
+
+// Lister is implemented by stores which can list the objects in a bucket.
+type Lister interface {
+	// List calls fn with the key and info of each object in the bucket whose key
+	// begins with prefix. Listing stops if fn returns an error, and the error is
+	// returned.
+	List(ctx context.Context, bucket string, prefix string, fn func(key string, info ObjectInfo) error) error
+}
+
+// Stat returns the info for an object using the store's Stat method. If the store
+// does not implement Stater, the object is read to find its size and the ETag is
+// empty. Returns ErrNotFound if the object does not exist.
+func Stat(ctx context.Context, s Store, bucket string, key string) (ObjectInfo, error) {
+	if st, ok := s.(Stater); ok {
+		return st.Stat(ctx, bucket, key)
+	}
+	r, err := s.Get(ctx, bucket, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return ObjectInfo{}, mergeErrors(err, r.Close())
+	}
+	if err := r.Close(); err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: uint64(n)}, nil
+}
+
 // GetObject is a wrapper around the store Get method. It returns the contents of an
 // object as a byte slice.
 func GetObject(ctx context.Context, s Store, bucket string, key string) ([]byte, error) {
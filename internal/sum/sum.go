@@ -1,6 +1,7 @@
 package sum
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -12,6 +13,48 @@ import (
 // Size is the byte-size of a checksum
 const Size = 32
 
+// Algorithm is a hash function computing checksums of Size bytes.
+type Algorithm string
+
+const (
+	// BLAKE3 is the default hash function, and the only one used for the checksums of
+	// packfiles and files.
+	BLAKE3 Algorithm = "blake3"
+	// SHA256 may be chosen for chunk checksums by deployments which must use a
+	// FIPS-approved hash. It's slower than BLAKE3.
+	SHA256 Algorithm = "sha256"
+)
+
+// ParseAlgorithm returns the hash function with the given name. The empty name is
+// BLAKE3.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch a := Algorithm(name); a {
+	case "":
+		return BLAKE3, nil
+	case BLAKE3, SHA256:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q", name)
+}
+
+// Compute returns the checksum of a byte slice computed with the hash function. The
+// empty algorithm is BLAKE3.
+func (a Algorithm) Compute(data []byte) Sum {
+	if a == SHA256 {
+		return sha256.Sum256(data)
+	}
+	return Compute(data)
+}
+
+// New returns a new Hash computed with the hash function. The empty algorithm is
+// BLAKE3.
+func (a Algorithm) New() *Hash {
+	if a == SHA256 {
+		return &Hash{sha256.New()}
+	}
+	return &Hash{blake3.New()}
+}
+
 // Sum stores a checksum
 type Sum [Size]byte
 
@@ -0,0 +1,150 @@
+// Package tracing instruments the server with OpenTelemetry spans. Spans are created
+// with the global tracer provider, so tracing is a no-op unless a provider is
+// registered with global.SetTracerProvider.
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+
+	"github.com/jotfs/jotfs/internal/store"
+)
+
+const tracerName = "github.com/jotfs/jotfs"
+
+// Start creates a new span as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...label.KeyValue) (context.Context, trace.Span) {
+	return global.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span, recording err on the span if it is not nil.
+func End(ctx context.Context, span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(ctx, err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Handler returns a http handler which creates a span for each request. The trace
+// context is extracted from the request headers if present.
+func Handler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := global.TextMapPropagator().Extract(req.Context(), req.Header)
+		ctx, span := global.Tracer(tracerName).Start(ctx, req.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(name, "", req)...),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, req.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(sw.status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(sw.status))
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Store wraps a store so a span is created for each Put, Get, GetRange and Stat. The
+// remaining methods do not take a context and are not traced. The returned store
+// implements store.Stater only if s does.
+func Store(s store.Store) store.Store {
+	if st, ok := s.(store.Stater); ok {
+		return &tracedStater{tracedStore{s}, st}
+	}
+	return &tracedStore{s}
+}
+
+type tracedStore struct {
+	store.Store
+}
+
+func (s *tracedStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	ctx, span := Start(ctx, "store.Put", label.String("bucket", bucket), label.String("key", key))
+	cr := &countingReader{r: r}
+	err := s.Store.Put(ctx, bucket, key, cr)
+	span.SetAttributes(label.Int64("bytes", cr.n))
+	End(ctx, span, err)
+	return err
+}
+
+func (s *tracedStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	ctx, span := Start(ctx, "store.Get", label.String("bucket", bucket), label.String("key", key))
+	r, err := s.Store.Get(ctx, bucket, key)
+	if err != nil {
+		End(ctx, span, err)
+		return nil, err
+	}
+	// The span ends when the object has been read and closed
+	return &spanReader{ReadCloser: r, ctx: ctx, span: span}, nil
+}
+
+func (s *tracedStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	ctx, span := Start(ctx, "store.GetRange", label.String("bucket", bucket), label.String("key", key),
+		label.Uint64("offset", offset), label.Uint64("length", length))
+	r, err := s.Store.GetRange(ctx, bucket, key, offset, length)
+	if err != nil {
+		End(ctx, span, err)
+		return nil, err
+	}
+	return &spanReader{ReadCloser: r, ctx: ctx, span: span}, nil
+}
+
+type tracedStater struct {
+	tracedStore
+	st store.Stater
+}
+
+func (s *tracedStater) Stat(ctx context.Context, bucket string, key string) (store.ObjectInfo, error) {
+	ctx, span := Start(ctx, "store.Stat", label.String("bucket", bucket), label.String("key", key))
+	info, err := s.st.Stat(ctx, bucket, key)
+	End(ctx, span, err)
+	return info, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type spanReader struct {
+	io.ReadCloser
+	ctx  context.Context
+	span trace.Span
+	n    int64
+}
+
+func (r *spanReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *spanReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.span.SetAttributes(label.Int64("bytes", r.n))
+	End(r.ctx, r.span, err)
+	return err
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagators"
+	exporttrace "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/store/file"
+)
+
+func setupExporter() *tracetest.InMemoryExporter {
+	exp := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	global.SetTextMapPropagator(propagators.TraceContext{})
+	return exp
+}
+
+func attr(span *exporttrace.SpanData, key label.Key) (label.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return label.Value{}, false
+}
+
+func TestHandler(t *testing.T) {
+	exp := setupExporter()
+
+	var inner string
+	h := Handler("jotfs", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, span := Start(req.Context(), "inner")
+		inner = span.SpanContext().TraceID.String()
+		End(req.Context(), span, errors.New("failed"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/twirp/JotFS/CreateFile", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// The handler span continues the trace from the request headers
+	assert.Equal(t, traceID, inner)
+	spans := exp.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "inner", spans[0].Name)
+		assert.Equal(t, codes.Error, spans[0].StatusCode)
+
+		span := spans[1]
+		assert.Equal(t, "/twirp/JotFS/CreateFile", span.Name)
+		assert.Equal(t, traceID, span.SpanContext.TraceID.String())
+		assert.True(t, span.HasRemoteParent)
+		assert.Equal(t, codes.Error, span.StatusCode)
+		status, ok := attr(span, "http.status_code")
+		assert.True(t, ok)
+		assert.Equal(t, int64(500), status.AsInt64())
+	}
+}
+
+func TestStore(t *testing.T) {
+	exp := setupExporter()
+
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fs, err := file.New(dir)
+	assert.NoError(t, err)
+	s := Store(fs)
+
+	ctx := context.Background()
+	data := []byte("hello")
+	assert.NoError(t, s.Put(ctx, "bucket", "key", bytes.NewReader(data)))
+
+	// Get span ends after the object is closed
+	r, err := s.Get(ctx, "bucket", "key")
+	assert.NoError(t, err)
+	assert.Len(t, exp.GetSpans(), 1)
+	b, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, b)
+	assert.NoError(t, r.Close())
+
+	_, err = s.Get(ctx, "bucket", "missing")
+	assert.Error(t, err)
+
+	spans := exp.GetSpans()
+	if assert.Len(t, spans, 3) {
+		for i, name := range []string{"store.Put", "store.Get"} {
+			assert.Equal(t, name, spans[i].Name)
+			n, ok := attr(spans[i], "bytes")
+			assert.True(t, ok)
+			assert.Equal(t, int64(len(data)), n.AsInt64())
+		}
+		assert.Equal(t, "store.Get", spans[2].Name)
+		assert.Equal(t, codes.Error, spans[2].StatusCode)
+	}
+
+	// Stat is traced if supported by the underlying store
+	_, ok := s.(store.Stater)
+	assert.True(t, ok)
+	_, ok = Store(struct{ store.Store }{fs}).(store.Stater)
+	assert.False(t, ok)
+
+	// Methods without a context are passed through
+	assert.NoError(t, s.Copy("bucket", "key", "key2"))
+	assert.NoError(t, s.Delete("bucket", "key2"))
+}
@@ -0,0 +1,101 @@
+// Package jotfstest provides an in-process JotFS server for the tests of programs which
+// use the client package. Each server has its own in-memory metadata database and
+// object store, so tests are hermetic and may run in parallel, and the server serves
+// the same API as a real server:
+//
+//	func TestBackup(t *testing.T) {
+//		srv := jotfstest.NewServer(t)
+//		c := srv.Client(nil)
+//		id, err := c.Upload(context.Background(), strings.NewReader("hello"), "/a.txt")
+//		...
+//	}
+package jotfstest
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jotfs/jotfs/client"
+	"github.com/jotfs/jotfs/server"
+)
+
+// shutdownTimeout is the maximum time Close waits for packfile uploads and vacuums in
+// progress to complete.
+const shutdownTimeout = 10 * time.Second
+
+// Server is a JotFS server listening on a local address. It's closed when the test
+// which created it, and all of its subtests, complete.
+type Server struct {
+	// URL is the address of the server, e.g. http://127.0.0.1:40123, to pass to
+	// client.New.
+	URL string
+
+	t      testing.TB
+	srv    *server.Server
+	http   *httptest.Server
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewServer starts a server with the default configuration. The test fails
+// immediately if the server cannot be started.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	return NewServerConfig(t, server.Config{})
+}
+
+// NewServerConfig starts a server with a configuration, e.g. to enable versioning or
+// the admin API. The database and store configuration is ignored, as are the options
+// which only apply to server.Run, such as the address and TLS configuration. The test
+// fails immediately if the server cannot be started.
+func NewServerConfig(t testing.TB, cfg server.Config) *Server {
+	t.Helper()
+	srv, err := server.NewInMemory(cfg)
+	if err != nil {
+		t.Fatalf("jotfstest: creating server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.Start(ctx)
+	h := httptest.NewServer(srv)
+	s := &Server{URL: h.URL, t: t, srv: srv, http: h, cancel: cancel}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a new client for the server. opts may be nil, as for client.New. The
+// test fails immediately if the client cannot be created.
+func (s *Server) Client(opts *client.Options) *client.Client {
+	s.t.Helper()
+	c, err := client.New(s.URL, opts)
+	if err != nil {
+		s.t.Fatalf("jotfstest: creating client: %v", err)
+	}
+	return c
+}
+
+// Close stops the server and discards its data. Requests in progress are allowed to
+// complete first. Close is called automatically when the test completes, and may be
+// called more than once.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	s.http.Close()
+	s.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		s.t.Errorf("jotfstest: shutting down server: %v", err)
+	}
+	if err := s.srv.Close(); err != nil {
+		s.t.Errorf("jotfstest: closing server: %v", err)
+	}
+}
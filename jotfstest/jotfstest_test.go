@@ -0,0 +1,64 @@
+package jotfstest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/jotfs/jotfs/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t)
+	c := srv.Client(nil)
+	ctx := context.Background()
+
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/data/a.bin")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, c.Download(ctx, id, &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	it := c.List("/data/", nil)
+	info, err := it.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/a.bin", info.Name)
+	assert.EqualValues(t, len(data), info.Size)
+	_, err = it.Next(ctx)
+	assert.Equal(t, io.EOF, err)
+
+	// Each server has its own data
+	other := NewServer(t).Client(nil)
+	_, err = other.List("/", nil).Next(ctx)
+	assert.Equal(t, io.EOF, err)
+
+	// Closing more than once is a no-op
+	srv.Close()
+	srv.Close()
+	_, err = http.Get(srv.URL + "/healthz")
+	assert.Error(t, err)
+}
+
+func TestServerConfig(t *testing.T) {
+	srv := NewServerConfig(t, server.Config{AdminToken: "secret"})
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/admin/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
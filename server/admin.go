@@ -0,0 +1,1073 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	iserver "github.com/jotfs/jotfs/internal/server"
+)
+
+// adminPrefix is the path prefix of the admin API.
+const adminPrefix = "/admin"
+
+const (
+	// maxAdminVacuums is the number of recent vacuums listed by the jobs endpoint.
+	maxAdminVacuums = 20
+
+	// maxAdminUploads is the number of recent file versions listed by the uploads
+	// endpoint.
+	maxAdminUploads = 50
+
+	// maxAdminPackFiles is the number of files listed for each packfile rebuilt by a
+	// vacuum in the dry run report.
+	maxAdminPackFiles = 20
+)
+
+// adminHandler returns a http handler for the admin API. Requests must be
+// authenticated with the bearer token, except for the dashboard, which only contains
+// static content. The handler expects adminPrefix to be removed from the request path.
+// A standby server only serves GET requests.
+//
+// The API has the following endpoints:
+//
+//	GET  /              the web dashboard
+//	GET  /stats         summary statistics for the server and its database checkpoints
+//	GET  /namespaces    statistics for each top-level directory
+//	GET  /uploads       the most recently uploaded file versions
+//	GET  /config        the server configuration, excluding credentials
+//	GET  /jobs          the status of background jobs and recent vacuums
+//	POST /jobs/{name}   start a run of a scheduled background job
+//	POST /vacuum        start a vacuum. With ?dry_run=true, report what a vacuum would
+//	                    reclaim instead
+//	GET  /vacuum/{id}   the status of a vacuum
+//	POST /scrub         check every packfile in the store and wait for the result
+//	POST /compact       upload a compacted copy of the database. With ?swap=true, the
+//	                    copy replaces the database when the server next starts
+//	POST /backup        upload a backup of the database
+//	GET  /backups       the database backups in the store, oldest first
+//	GET  /shares        the share links, including expired links
+//	POST /shares        create a share link for a prefix
+//	DELETE /shares/{id} revoke a share link
+//	GET  /chunker-profiles  the chunker profiles
+//	POST /chunker-profiles  set the chunker profile of a prefix
+//	DELETE /chunker-profiles/{prefix}  delete the chunker profile of a prefix
+//	POST /rechunk       chunk the files under ?prefix= again with the parameters which
+//	                    now apply to them, and wait for the result
+func adminHandler(s *Server, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", getHandler(s.adminStats))
+	mux.HandleFunc("/namespaces", getHandler(s.adminNamespaces))
+	mux.HandleFunc("/uploads", getHandler(s.adminUploads))
+	mux.HandleFunc("/config", getHandler(s.adminConfig))
+	mux.HandleFunc("/jobs", getHandler(s.adminJobs))
+	mux.HandleFunc("/jobs/", postHandler(s.adminRunJob))
+	mux.HandleFunc("/vacuum", postHandler(s.adminStartVacuum))
+	mux.HandleFunc("/vacuum/", getHandler(s.adminVacuumStatus))
+	mux.HandleFunc("/scrub", postHandler(s.adminScrub))
+	mux.HandleFunc("/rechunk", postHandler(s.adminRechunk))
+	mux.HandleFunc("/compact", postHandler(s.adminCompact))
+	mux.HandleFunc("/backup", postHandler(s.adminBackup))
+	mux.HandleFunc("/backups", getHandler(s.adminBackups))
+	mux.HandleFunc("/shares", s.adminShares)
+	mux.HandleFunc("/shares/", s.adminDeleteShare)
+	mux.HandleFunc("/chunker-profiles", s.adminChunkerProfiles)
+	mux.HandleFunc("/chunker-profiles/", s.adminDeleteChunkerProfile)
+
+	dashboard := getHandler(dashboardHandler)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/" {
+			dashboard(w, req)
+			return
+		}
+		auth := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if s.cfg.Standby && req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, errStandby.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// adminStats is the response of the stats endpoint.
+type adminStats struct {
+	NumFiles        uint64 `json:"num_files"`
+	NumFileVersions uint64 `json:"num_file_versions"`
+	TotalFilesSize  uint64 `json:"total_files_size"`
+	TotalDataSize   uint64 `json:"total_data_size"`
+	NumChunks       uint64 `json:"num_chunks"`
+	NumPacks        uint64 `json:"num_packs"`
+	TotalPacksSize  uint64 `json:"total_packs_size"`
+	// DedupRatio is the ratio of the total size of all file versions to the total
+	// size of all packfiles. Zero if no packfiles are stored.
+	DedupRatio  float64          `json:"dedup_ratio"`
+	Checkpoints adminCheckpoints `json:"checkpoints"`
+	// AbortedUploads is the packfile uploads aborted by clients since the server
+	// started.
+	AbortedUploads adminAbortedUploads `json:"aborted_uploads"`
+	// Shed is omitted if load shedding is disabled.
+	Shed *adminShed `json:"shed,omitempty"`
+	// RateLimit is omitted if rate limiting is disabled.
+	RateLimit *adminRateLimit `json:"rate_limit,omitempty"`
+	// Throttle is omitted if bandwidth throttling is disabled.
+	Throttle *adminThrottle `json:"throttle,omitempty"`
+	// Store is omitted if the store's circuit breaker is disabled.
+	Store *adminStoreHealth `json:"store,omitempty"`
+	// Replication is omitted if synchronous replication is disabled.
+	Replication *adminReplication `json:"replication,omitempty"`
+	// ChunkFilter is omitted if the chunk filter is disabled.
+	ChunkFilter *adminChunkFilter `json:"chunk_filter,omitempty"`
+	// ChunkCache is omitted if the chunk cache is disabled.
+	ChunkCache *adminChunkCache `json:"chunk_cache,omitempty"`
+	// LocationCache is omitted if the chunk location cache is disabled.
+	LocationCache *adminLocationCache `json:"location_cache,omitempty"`
+	// UploadQueue is omitted if the upload queue is disabled.
+	UploadQueue *adminUploadQueue `json:"upload_queue,omitempty"`
+	// Uploads is omitted if the packfile uploads in progress are not limited.
+	Uploads *adminUploads `json:"uploads,omitempty"`
+	// Quotas is omitted if no quotas are set.
+	Quotas []adminQuota `json:"quotas,omitempty"`
+}
+
+// adminQuota is the usage of a prefix with a quota. GraceStarted is the time the usage
+// first exceeded the soft limit, and is omitted if the usage is under it.
+type adminQuota struct {
+	Prefix       string     `json:"prefix"`
+	Usage        uint64     `json:"usage"`
+	SoftLimit    uint64     `json:"soft_limit,omitempty"`
+	HardLimit    uint64     `json:"hard_limit,omitempty"`
+	GraceSeconds float64    `json:"grace_seconds,omitempty"`
+	GraceStarted *time.Time `json:"grace_started,omitempty"`
+}
+
+// adminUploads is the packfile uploads in progress, and the uploads rejected because
+// the server was busy since it started.
+type adminUploads struct {
+	Active      uint64 `json:"active"`
+	ActiveBytes uint64 `json:"active_bytes"`
+	Rejected    uint64 `json:"rejected"`
+}
+
+// adminUploadQueue is the packfiles waiting in the upload queue, and the uploads made
+// from it since the server started. FreeSpace is omitted if no minimum free space is
+// set.
+type adminUploadQueue struct {
+	Pending     uint64 `json:"pending"`
+	PendingSize uint64 `json:"pending_size"`
+	Uploaded    uint64 `json:"uploaded"`
+	Retries     uint64 `json:"retries"`
+	Rejected    uint64 `json:"rejected"`
+	FreeSpace   uint64 `json:"free_space,omitempty"`
+}
+
+// adminLocationCache is the number of chunk locations in the location cache, and the
+// chunks located with it since the server started.
+type adminLocationCache struct {
+	Entries uint64 `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+}
+
+// adminChunkCache is the size of the chunk cache, and the chunks read from it and
+// added to it since the server started. FreeSpace is omitted if no minimum free space
+// is set.
+type adminChunkCache struct {
+	Chunks    uint64 `json:"chunks"`
+	Size      uint64 `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Shed      uint64 `json:"shed"`
+	FreeSpace uint64 `json:"free_space,omitempty"`
+}
+
+// adminChunkFilter is the size of the chunk filter, and the chunk lookups made in it
+// since the server started. Skipped is the number of chunks the filter showed did not
+// exist without querying the database.
+type adminChunkFilter struct {
+	Chunks         uint64 `json:"chunks"`
+	Capacity       uint64 `json:"capacity"`
+	Size           uint64 `json:"size"`
+	Builds         uint64 `json:"builds"`
+	Lookups        uint64 `json:"lookups"`
+	Skipped        uint64 `json:"skipped"`
+	FalsePositives uint64 `json:"false_positives"`
+}
+
+// adminReplication is the number of file versions acknowledged by a replica, and those
+// which no replica acknowledged, since the server started.
+type adminReplication struct {
+	Acknowledged uint64 `json:"acknowledged"`
+	Failed       uint64 `json:"failed"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// adminStoreHealth is the state of the store's circuit breaker, and the store requests
+// which failed since the server started.
+type adminStoreHealth struct {
+	Breaker             string     `json:"breaker"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Failures            uint64     `json:"failures"`
+	Trips               uint64     `json:"trips"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// adminShed is the number of requests being served, and the requests rejected by load
+// shedding in each priority class since the server started.
+type adminShed struct {
+	InFlight            int    `json:"in_flight"`
+	RejectedInteractive uint64 `json:"rejected_interactive"`
+	RejectedUpload      uint64 `json:"rejected_upload"`
+	RejectedBackground  uint64 `json:"rejected_background"`
+}
+
+// adminRateLimit is the number of clients being rate limited, and the requests
+// rejected by rate limiting since the server started.
+type adminRateLimit struct {
+	Clients  int    `json:"clients"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// adminThrottle is the number of clients being throttled, and the number and total
+// length of the pauses made to keep clients to their bandwidth limits since the server
+// started.
+type adminThrottle struct {
+	Clients       int     `json:"clients"`
+	Pauses        uint64  `json:"pauses"`
+	PausedSeconds float64 `json:"paused_seconds"`
+}
+
+// adminAbortedUploads is the number of packfile uploads aborted by clients, and the
+// most recent of them, newest first.
+type adminAbortedUploads struct {
+	Count  uint64               `json:"count"`
+	Recent []adminAbortedUpload `json:"recent"`
+}
+
+type adminAbortedUpload struct {
+	Sum      string    `json:"sum"`
+	Size     int64     `json:"size"`
+	Received int64     `json:"received"`
+	Reason   string    `json:"reason"`
+	At       time.Time `json:"at"`
+}
+
+// adminCheckpoints is the checkpoints of the database's write-ahead log since the
+// server started.
+type adminCheckpoints struct {
+	Count uint64 `json:"count"`
+	// Busy is the number of checkpoints which could not copy the whole log because of
+	// concurrent database access.
+	Busy                uint64     `json:"busy"`
+	TotalDurationMillis float64    `json:"total_duration_ms"`
+	MaxDurationMillis   float64    `json:"max_duration_ms"`
+	LastDurationMillis  float64    `json:"last_duration_ms"`
+	Last                *time.Time `json:"last,omitempty"`
+	WALSize             int64      `json:"wal_size"`
+}
+
+func (s *Server) adminStats(w http.ResponseWriter, req *http.Request) {
+	stats, err := s.srv.ServerStats(req.Context(), &pb.Empty{})
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	walSize, err := s.db.WALSize()
+	if err != nil {
+		s.httpError(w, fmt.Errorf("db WALSize: %w", err))
+		return
+	}
+	ckpt := s.db.CheckpointStats()
+	res := adminStats{
+		NumFiles:        stats.NumFiles,
+		NumFileVersions: stats.NumFileVersions,
+		TotalFilesSize:  stats.TotalFilesSize,
+		TotalDataSize:   stats.TotalDataSize,
+		NumChunks:       stats.NumChunks,
+		NumPacks:        stats.NumPacks,
+		TotalPacksSize:  stats.TotalPacksSize,
+		DedupRatio:      stats.DedupRatio,
+		Checkpoints: adminCheckpoints{
+			Count:               ckpt.Checkpoints,
+			Busy:                ckpt.Busy,
+			TotalDurationMillis: millis(ckpt.TotalDuration),
+			MaxDurationMillis:   millis(ckpt.MaxDuration),
+			LastDurationMillis:  millis(ckpt.LastDuration),
+			WALSize:             walSize,
+		},
+	}
+	if !ckpt.Last.IsZero() {
+		last := ckpt.Last.UTC()
+		res.Checkpoints.Last = &last
+	}
+	count, aborts := s.srv.AbortedUploads()
+	res.AbortedUploads = adminAbortedUploads{Count: count, Recent: make([]adminAbortedUpload, len(aborts))}
+	for i, u := range aborts {
+		res.AbortedUploads.Recent[i] = adminAbortedUpload(u)
+	}
+	if s.shedder != nil {
+		shed := s.shedder.stats()
+		res.Shed = &shed
+	}
+	if s.limiter != nil {
+		limit := s.limiter.stats()
+		res.RateLimit = &limit
+	}
+	if s.throttle != nil {
+		throttle := s.throttle.stats()
+		res.Throttle = &throttle
+	}
+	if s.breaker != nil {
+		h := s.breaker.Health()
+		res.Store = &adminStoreHealth{
+			Breaker:             string(h.State),
+			ConsecutiveFailures: h.ConsecutiveFailures,
+			Failures:            h.Failures,
+			Trips:               h.Trips,
+		}
+		if !h.OpenedAt.IsZero() {
+			opened := h.OpenedAt.UTC()
+			res.Store.OpenedAt = &opened
+		}
+		if h.LastError != nil {
+			res.Store.LastError = h.LastError.Error()
+		}
+	}
+	if s.replicator != nil {
+		replication := s.replicator.stats()
+		res.Replication = &replication
+	}
+	if f, ok := s.db.ChunkFilterStats(); ok {
+		filter := adminChunkFilter(f)
+		res.ChunkFilter = &filter
+	}
+	if c, ok := s.srv.ChunkCacheStats(); ok {
+		cache := adminChunkCache(c)
+		res.ChunkCache = &cache
+	}
+	if c, ok := s.srv.LocationCacheStats(); ok {
+		cache := adminLocationCache(c)
+		res.LocationCache = &cache
+	}
+	if q, ok := s.srv.UploadQueueStats(); ok {
+		queue := adminUploadQueue(q)
+		res.UploadQueue = &queue
+	}
+	if u, ok := s.srv.UploadAdmissionStats(); ok {
+		uploads := adminUploads(u)
+		res.Uploads = &uploads
+	}
+	quotas, err := s.srv.QuotaUsages()
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	for _, q := range quotas {
+		quota := adminQuota{
+			Prefix:       q.Prefix + "/",
+			Usage:        q.Usage,
+			SoftLimit:    q.Soft,
+			HardLimit:    q.Hard,
+			GraceSeconds: q.Grace.Seconds(),
+		}
+		if !q.GraceStarted.IsZero() {
+			started := q.GraceStarted.UTC()
+			quota.GraceStarted = &started
+		}
+		res.Quotas = append(res.Quotas, quota)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// adminNamespace is the statistics of a single namespace.
+type adminNamespace struct {
+	Namespace       string `json:"namespace"`
+	NumFiles        uint64 `json:"num_files"`
+	NumFileVersions uint64 `json:"num_file_versions"`
+	TotalFilesSize  uint64 `json:"total_files_size"`
+}
+
+func (s *Server) adminNamespaces(w http.ResponseWriter, req *http.Request) {
+	stats, err := s.db.GetNamespaceStats()
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := make([]adminNamespace, len(stats))
+	for i, ns := range stats {
+		res[i] = adminNamespace(ns)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// adminUpload is a single file version returned by the uploads endpoint.
+type adminUpload struct {
+	Name      string    `json:"name"`
+	VersionID string    `json:"version_id"`
+	Sum       string    `json:"sum"`
+	Size      uint64    `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Server) adminUploads(w http.ResponseWriter, req *http.Request) {
+	infos, err := s.db.ListFiles("", 0, maxAdminUploads, "", "", false)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := make([]adminUpload, len(infos))
+	for i, info := range infos {
+		res[i] = adminUpload{
+			Name:      info.Name,
+			VersionID: info.VersionID,
+			Sum:       info.Sum.AsHex(),
+			Size:      info.Size,
+			CreatedAt: info.CreatedAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// adminConfig is the response of the config endpoint. Credentials and tokens are
+// never included.
+type adminConfig struct {
+	Store struct {
+		URL        string `json:"url,omitempty"`
+		Bucket     string `json:"bucket"`
+		Endpoint   string `json:"endpoint,omitempty"`
+		Region     string `json:"region,omitempty"`
+		PathStyle  bool   `json:"path_style"`
+		DisableSSL bool   `json:"disable_ssl"`
+		RoleARN    string `json:"role_arn,omitempty"`
+
+		PartSize          int64 `json:"part_size,omitempty"`
+		UploadConcurrency int   `json:"upload_concurrency,omitempty"`
+
+		RetryAttempts int     `json:"retry_attempts,omitempty"`
+		RetryBudget   float64 `json:"retry_budget,omitempty"`
+
+		BreakerThreshold       int     `json:"breaker_threshold,omitempty"`
+		BreakerCooldownSeconds float64 `json:"breaker_cooldown_seconds,omitempty"`
+	} `json:"store"`
+	Build                  BuildInfo `json:"build"`
+	VersioningEnabled      bool      `json:"versioning_enabled"`
+	Chunker                chunker   `json:"chunker"`
+	SyncReplication        []string  `json:"sync_replication"`
+	Replicas               []string  `json:"replicas"`
+	PackfileSize           uint64    `json:"packfile_size"`
+	MaxPackfileSize        uint64    `json:"max_packfile_size"`
+	PackfileFlushSeconds   float64   `json:"packfile_flush_interval_seconds"`
+	InlineSize             uint64    `json:"inline_size"`
+	DownloadTimeoutSeconds float64   `json:"download_timeout_seconds"`
+	VacuumIntervalSeconds  float64   `json:"vacuum_interval_seconds"`
+	ShutdownTimeoutSeconds float64   `json:"shutdown_timeout_seconds"`
+	CacheTTLSeconds        float64   `json:"cache_ttl_seconds"`
+	OpLogIntervalSeconds   float64   `json:"oplog_interval_seconds"`
+	DatabaseBusyTimeoutMS  int64     `json:"database_busy_timeout_ms"`
+	DatabaseSynchronous    string    `json:"database_synchronous"`
+	DatabaseReaders        int       `json:"database_readers"`
+	BackupIntervalSeconds  float64   `json:"backup_interval_seconds"`
+	BackupRetain           int       `json:"backup_retain"`
+	ChunkFilter            bool      `json:"chunk_filter"`
+	Standby                bool      `json:"standby"`
+	Checksums              []string  `json:"checksums"`
+	IndexCacheDir          string    `json:"index_cache_dir,omitempty"`
+	ChunkCacheDir          string    `json:"chunk_cache_dir,omitempty"`
+	ChunkCacheSize         int64     `json:"chunk_cache_size,omitempty"`
+	LocationCacheSize      int       `json:"location_cache_size,omitempty"`
+	ReadAhead              int       `json:"read_ahead"`
+	UploadQueueDir         string    `json:"upload_queue_dir,omitempty"`
+	UploadQueueSize        int       `json:"upload_queue_size,omitempty"`
+	UploadQueueWorkers     int       `json:"upload_queue_workers,omitempty"`
+	MinFreeSpace           int64     `json:"min_free_space,omitempty"`
+	MaxUploads             int       `json:"max_uploads,omitempty"`
+	MaxUploadBytes         uint64    `json:"max_upload_bytes,omitempty"`
+	PolicyFile             string    `json:"policy_file,omitempty"`
+	UsageReports           bool      `json:"usage_reports"`
+	EventsWebhook          bool      `json:"events_webhook"`
+	EventsQueue            string    `json:"events_queue,omitempty"`
+	CORSOrigins            []string  `json:"cors_origins"`
+	Addr                   string    `json:"addr,omitempty"`
+	TLS                    bool      `json:"tls"`
+}
+
+type chunker struct {
+	MinChunkSize  uint64 `json:"min_chunk_size"`
+	AvgChunkSize  uint64 `json:"avg_chunk_size"`
+	MaxChunkSize  uint64 `json:"max_chunk_size"`
+	Normalization uint64 `json:"normalization"`
+	Algorithm     string `json:"algorithm"`
+	ChunkHash     string `json:"chunk_hash"`
+}
+
+func (s *Server) adminConfig(w http.ResponseWriter, req *http.Request) {
+	params, err := s.srv.GetChunkerParams(req.Context(), &pb.Empty{})
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	cfg := s.cfg
+	var res adminConfig
+	res.Store.URL = redactURL(cfg.Store.URL)
+	res.Store.Bucket = cfg.Store.Bucket
+	res.Store.Endpoint = cfg.Store.Endpoint
+	res.Store.Region = cfg.Store.Region
+	res.Store.PathStyle = cfg.Store.PathStyle
+	res.Store.DisableSSL = cfg.Store.DisableSSL
+	res.Store.RoleARN = cfg.Store.RoleARN
+	res.Store.PartSize = cfg.Store.PartSize
+	res.Store.UploadConcurrency = cfg.Store.UploadConcurrency
+	if cfg.StoreRetry.MaxAttempts > 1 {
+		res.Store.RetryAttempts = cfg.StoreRetry.MaxAttempts
+		res.Store.RetryBudget = cfg.StoreRetry.Budget
+	}
+	if cfg.StoreBreaker.Threshold > 0 {
+		res.Store.BreakerThreshold = cfg.StoreBreaker.Threshold
+		res.Store.BreakerCooldownSeconds = s.breaker.Cooldown().Seconds()
+	}
+	res.Build = buildInfo(cfg.Build)
+	res.VersioningEnabled = cfg.VersioningEnabled
+	res.Chunker = chunker{
+		MinChunkSize:  params.MinChunkSize,
+		AvgChunkSize:  params.AvgChunkSize,
+		MaxChunkSize:  params.MaxChunkSize,
+		Normalization: params.Normalization,
+		Algorithm:     string(s.srv.ChunkAlgorithm()),
+		ChunkHash:     string(s.srv.ChunkHash()),
+	}
+	res.SyncReplication = cfg.SyncReplication
+	if res.SyncReplication == nil {
+		res.SyncReplication = []string{}
+	}
+	res.Replicas = []string{}
+	for _, replica := range cfg.Replicas {
+		res.Replicas = append(res.Replicas, redactURL(replica.Endpoint))
+	}
+	res.PackfileSize = cfg.PackfileSize
+	res.MaxPackfileSize = cfg.MaxPackfileSize
+	res.PackfileFlushSeconds = cfg.PackfileFlushInterval.Seconds()
+	res.InlineSize = cfg.InlineSize
+	res.DownloadTimeoutSeconds = cfg.DownloadTimeout.Seconds()
+	res.VacuumIntervalSeconds = cfg.VacuumInterval.Seconds()
+	res.ShutdownTimeoutSeconds = cfg.ShutdownTimeout.Seconds()
+	res.CacheTTLSeconds = cfg.CacheTTL.Seconds()
+	res.OpLogIntervalSeconds = cfg.OpLogInterval.Seconds()
+	res.DatabaseBusyTimeoutMS = cfg.DatabaseBusyTimeout.Milliseconds()
+	res.DatabaseSynchronous = cfg.DatabaseSynchronous
+	res.DatabaseReaders = cfg.DatabaseReaders
+	res.BackupIntervalSeconds = cfg.BackupInterval.Seconds()
+	res.BackupRetain = cfg.BackupRetain
+	res.ChunkFilter = !cfg.DisableChunkFilter
+	res.Standby = cfg.Standby
+	res.Checksums = cfg.Checksums
+	if res.Checksums == nil {
+		res.Checksums = []string{}
+	}
+	res.IndexCacheDir = cfg.IndexCacheDir
+	res.ChunkCacheDir = cfg.ChunkCacheDir
+	res.ChunkCacheSize = cfg.ChunkCacheSize
+	res.LocationCacheSize = cfg.LocationCacheSize
+	res.ReadAhead = cfg.ReadAhead
+	res.UploadQueueDir = cfg.UploadQueueDir
+	res.UploadQueueSize = cfg.UploadQueueSize
+	res.UploadQueueWorkers = cfg.UploadQueueWorkers
+	res.MinFreeSpace = cfg.MinFreeSpace
+	res.MaxUploads = cfg.MaxUploads
+	res.MaxUploadBytes = cfg.MaxUploadBytes
+	res.PolicyFile = cfg.PolicyFile
+	res.UsageReports = cfg.Report.enabled()
+	res.EventsWebhook = cfg.EventsToken != ""
+	res.EventsQueue = cfg.EventsQueue
+	res.CORSOrigins = cfg.CORSOrigins
+	if res.CORSOrigins == nil {
+		res.CORSOrigins = []string{}
+	}
+	res.Addr = cfg.Addr
+	res.TLS = cfg.TLSCert != "" || cfg.TLSConfig != nil
+	writeJSON(w, http.StatusOK, res)
+}
+
+// adminVacuum is the status of a single vacuum.
+type adminVacuum struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+func newAdminVacuum(v db.Vacuum) adminVacuum {
+	res := adminVacuum{ID: v.ID, Status: v.Status.String(), StartedAt: time.Unix(0, v.StartedAt).UTC()}
+	if v.CompletedAt != 0 {
+		t := time.Unix(0, v.CompletedAt).UTC()
+		res.CompletedAt = &t
+	}
+	return res
+}
+
+// adminJobs is the response of the jobs endpoint.
+type adminJobs struct {
+	VacuumRunning         bool          `json:"vacuum_running"`
+	VacuumIntervalSeconds float64       `json:"vacuum_interval_seconds"`
+	EventsQueue           string        `json:"events_queue,omitempty"`
+	ShuttingDown          bool          `json:"shutting_down"`
+	Vacuums               []adminVacuum `json:"vacuums"`
+	Jobs                  []adminJob    `json:"jobs"`
+}
+
+// adminJob is the status of a scheduled background job. The times of the last run
+// are omitted if the job has not run.
+type adminJob struct {
+	Name       string     `json:"name"`
+	Schedule   string     `json:"schedule"`
+	Running    bool       `json:"running"`
+	NextRun    time.Time  `json:"next_run"`
+	LastStart  *time.Time `json:"last_start,omitempty"`
+	LastFinish *time.Time `json:"last_finish,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Runs       int64      `json:"runs"`
+	Failures   int64      `json:"failures"`
+}
+
+func newAdminJob(j JobStatus) adminJob {
+	res := adminJob{
+		Name:      j.Name,
+		Schedule:  j.Schedule,
+		Running:   j.Running,
+		NextRun:   j.NextRun.UTC(),
+		LastError: j.LastError,
+		Runs:      j.Runs,
+		Failures:  j.Failures,
+	}
+	if !j.LastStart.IsZero() {
+		t := j.LastStart.UTC()
+		res.LastStart = &t
+	}
+	if !j.LastFinish.IsZero() {
+		t := j.LastFinish.UTC()
+		res.LastFinish = &t
+	}
+	return res
+}
+
+func (s *Server) adminJobs(w http.ResponseWriter, req *http.Request) {
+	vacuums, err := s.db.ListVacuums(maxAdminVacuums)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := adminJobs{
+		VacuumRunning:         s.srv.VacuumRunning(),
+		VacuumIntervalSeconds: s.cfg.VacuumInterval.Seconds(),
+		EventsQueue:           s.cfg.EventsQueue,
+		ShuttingDown:          s.srv.ShuttingDown(),
+		Vacuums:               make([]adminVacuum, len(vacuums)),
+		Jobs:                  []adminJob{},
+	}
+	for i, v := range vacuums {
+		res.Vacuums[i] = newAdminVacuum(v)
+	}
+	for _, j := range s.Jobs() {
+		res.Jobs = append(res.Jobs, newAdminJob(j))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) adminRunJob(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/jobs/")
+	err := s.RunJob(name)
+	if errors.Is(err, ErrJobNotFound) {
+		http.Error(w, fmt.Sprintf("job %q is not scheduled", name), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrJobRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// adminVacuumReport is the response of the vacuum endpoint for a dry run.
+type adminVacuumReport struct {
+	DeletedPacks int                `json:"deleted_packs"`
+	RebuiltPacks int                `json:"rebuilt_packs"`
+	Chunks       uint64             `json:"chunks"`
+	Bytes        uint64             `json:"bytes"`
+	Packs        []adminReclaimPack `json:"packs"`
+}
+
+type adminReclaimPack struct {
+	Sum         string   `json:"sum"`
+	Size        uint64   `json:"size"`
+	Chunks      uint64   `json:"chunks"`
+	TotalChunks uint64   `json:"total_chunks"`
+	Bytes       uint64   `json:"bytes"`
+	Rebuilt     bool     `json:"rebuilt"`
+	Files       []string `json:"files,omitempty"`
+}
+
+func (s *Server) adminStartVacuum(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("dry_run") == "true" {
+		s.adminVacuumDryRun(w, req)
+		return
+	}
+	id, err := s.srv.StartVacuum(req.Context(), &pb.Empty{})
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct {
+		ID string `json:"id"`
+	}{id.Id})
+}
+
+func (s *Server) adminVacuumDryRun(w http.ResponseWriter, req *http.Request) {
+	report, err := s.VacuumDryRun(req.Context(), maxAdminPackFiles)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := adminVacuumReport{
+		DeletedPacks: report.DeletedPacks,
+		RebuiltPacks: report.RebuiltPacks,
+		Chunks:       report.Chunks,
+		Bytes:        report.Bytes,
+		Packs:        make([]adminReclaimPack, len(report.Packs)),
+	}
+	for i, p := range report.Packs {
+		res.Packs[i] = adminReclaimPack(p)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) adminVacuumStatus(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/vacuum/")
+	v, err := s.db.GetVacuum(id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "vacuum not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAdminVacuum(v))
+}
+
+// adminScrub is the response of the scrub endpoint.
+type adminScrub struct {
+	Checked  int `json:"checked"`
+	Degraded int `json:"degraded"`
+}
+
+func (s *Server) adminScrub(w http.ResponseWriter, req *http.Request) {
+	res, err := s.srv.Scrub(req.Context())
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminScrub{Checked: res.Checked, Degraded: res.Degraded})
+}
+
+// adminRechunk is the response of the rechunk endpoint.
+type adminRechunk struct {
+	Checked   int    `json:"checked"`
+	Rechunked int    `json:"rechunked"`
+	Skipped   int    `json:"skipped"`
+	Chunks    uint64 `json:"chunks"`
+	Packs     int    `json:"packs"`
+	Bytes     uint64 `json:"bytes"`
+}
+
+func (s *Server) adminRechunk(w http.ResponseWriter, req *http.Request) {
+	prefix := req.URL.Query().Get("prefix")
+	if prefix == "" {
+		prefix = "/"
+	}
+	res, err := s.Rechunk(req.Context(), prefix)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminRechunk{
+		Checked:   res.Checked,
+		Rechunked: res.Rechunked,
+		Skipped:   res.Skipped,
+		Chunks:    res.Chunks,
+		Packs:     res.Packs,
+		Bytes:     res.Bytes,
+	})
+}
+
+// adminCompact is the response of the compact endpoint.
+type adminCompact struct {
+	Key           string `json:"key"`
+	Size          int64  `json:"size"`
+	FreeBytes     int64  `json:"free_bytes"`
+	CompactedSize int64  `json:"compacted_size"`
+	Pending       bool   `json:"pending"`
+}
+
+func (s *Server) adminCompact(w http.ResponseWriter, req *http.Request) {
+	swap := req.URL.Query().Get("swap") == "true"
+	res, err := s.CompactDatabase(req.Context(), &CompactOpts{Swap: swap})
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminCompact{
+		Key:           res.Key,
+		Size:          res.Size,
+		FreeBytes:     res.FreeBytes,
+		CompactedSize: res.CompactedSize,
+		Pending:       res.Pending,
+	})
+}
+
+// adminBackup is a database backup returned by the backup endpoints.
+type adminBackup struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// Deleted is the number of older backups deleted by the backup.
+	Deleted int `json:"deleted,omitempty"`
+}
+
+func (s *Server) adminBackup(w http.ResponseWriter, req *http.Request) {
+	res, err := s.BackupDatabase(req.Context())
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminBackup{Key: res.Key, Size: res.Size, Deleted: res.Deleted})
+}
+
+func (s *Server) adminBackups(w http.ResponseWriter, req *http.Request) {
+	backups, err := s.ListDatabaseBackups(req.Context())
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := make([]adminBackup, len(backups))
+	for i, b := range backups {
+		res[i] = adminBackup{Key: b.Key, Size: b.Size}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// adminShare is a share link returned by the shares endpoints. The token is only
+// returned when the link is created.
+type adminShare struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newAdminShare(sh db.Share) adminShare {
+	return adminShare{ID: sh.ID, Prefix: sh.Prefix, CreatedAt: sh.CreatedAt, ExpiresAt: sh.ExpiresAt}
+}
+
+func (s *Server) adminShares(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		s.adminListShares(w, req)
+	case http.MethodPost:
+		s.adminCreateShare(w, req)
+	default:
+		code := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func (s *Server) adminListShares(w http.ResponseWriter, req *http.Request) {
+	shares, err := s.db.ListShares()
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := make([]adminShare, len(shares))
+	for i, sh := range shares {
+		res[i] = newAdminShare(sh)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) adminCreateShare(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Prefix           string `json:"prefix"`
+		ExpiresInSeconds int64  `json:"expires_in_seconds"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiry := defaultShareExpiry
+	if body.ExpiresInSeconds != 0 {
+		expiry = time.Duration(body.ExpiresInSeconds) * time.Second
+	}
+	if expiry <= 0 || expiry > maxShareExpiry {
+		http.Error(w, fmt.Sprintf("expires_in_seconds must be in range 1 to %d", int64(maxShareExpiry/time.Second)), http.StatusBadRequest)
+		return
+	}
+
+	token, hash, err := newShareToken()
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	prefix := s.srv.NormalizeName(body.Prefix)
+	if prefix == "" {
+		prefix = "/"
+	}
+	now := time.Now().UTC()
+	id, err := s.db.InsertShare(hash, prefix, now, now.Add(expiry))
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	res := newAdminShare(db.Share{ID: id, Prefix: prefix, CreatedAt: now, ExpiresAt: now.Add(expiry)})
+	res.Token = token
+	res.Path = sharePrefix + "/" + token + "/"
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (s *Server) adminDeleteShare(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		code := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	err := s.db.DeleteShare(strings.TrimPrefix(req.URL.Path, "/shares/"))
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "share not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminChunkerProfiles(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		profiles, err := s.srv.ChunkerProfiles()
+		if err != nil {
+			s.httpError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, profiles)
+	case http.MethodPost:
+		s.adminSetChunkerProfile(w, req)
+	default:
+		code := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func (s *Server) adminSetChunkerProfile(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Prefix        string `json:"prefix"`
+		MinChunkSize  uint   `json:"min_chunk_size"`
+		AvgChunkSize  uint   `json:"avg_chunk_size"`
+		MaxChunkSize  uint   `json:"max_chunk_size"`
+		Normalization *uint  `json:"normalization"`
+		Algorithm     string `json:"algorithm"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := iserver.ChunkerProfile{
+		Prefix: body.Prefix,
+		ChunkerParams: iserver.ChunkerParams{
+			MinChunkSize: body.MinChunkSize,
+			AvgChunkSize: body.AvgChunkSize,
+			MaxChunkSize: body.MaxChunkSize,
+			Algorithm:    body.Algorithm,
+		},
+	}
+	if body.Normalization != nil {
+		p.Normalization = *body.Normalization
+	} else {
+		params, err := s.srv.GetChunkerParams(req.Context(), &pb.Empty{})
+		if err != nil {
+			s.httpError(w, err)
+			return
+		}
+		p.Normalization = uint(params.Normalization)
+	}
+	p, err := s.srv.SetChunkerProfile(p)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) adminDeleteChunkerProfile(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		code := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	if err := s.srv.DeleteChunkerProfile(strings.TrimPrefix(req.URL.Path, "/chunker-profiles")); err != nil {
+		s.httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpError writes an error response. Twirp errors are returned with their HTTP
+// status. Other errors are logged and an internal server error is returned.
+func (s *Server) httpError(w http.ResponseWriter, err error) {
+	var terr twirp.Error
+	if errors.As(err, &terr) && terr.Code() != twirp.Internal {
+		http.Error(w, terr.Msg(), twirp.ServerHTTPStatusFromErrorCode(terr.Code()))
+		return
+	}
+	s.logger.Error().Msg(err.Error())
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// redactURL removes the password, if any, from a URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
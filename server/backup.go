@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// backupSuffix is appended to the database's file name to name the copy written
+	// by BackupDatabase before it's uploaded, and restoreSuffix to name the backup
+	// downloaded by RestoreDatabase before it replaces the database.
+	backupSuffix  = ".backup-tmp"
+	restoreSuffix = ".restore-tmp"
+)
+
+// DatabaseBackup is a copy of the database in the store.
+type DatabaseBackup struct {
+	Key  string
+	Size int64
+}
+
+// BackupResult describes a backup of the database made by BackupDatabase.
+type BackupResult struct {
+	DatabaseBackup
+	// Deleted is the number of older backups deleted to keep Config.BackupRetain
+	// backups.
+	Deleted int
+}
+
+// BackupDatabase uploads a consistent copy of the metadata database to the store's
+// backups/db/ prefix, while the server keeps serving requests. The copy is read in a
+// single transaction, so it holds every change committed when the backup started. If
+// Config.BackupRetain is set, the oldest backups are then deleted. The copy is written
+// alongside the database before it's uploaded, so there must be space for it.
+func (s *Server) BackupDatabase(ctx context.Context) (BackupResult, error) {
+	if s.cfg.Database == "" {
+		return BackupResult{}, errors.New("database file is not set")
+	}
+	tmp := s.cfg.Database + backupSuffix
+	if err := removeFiles(tmp); err != nil {
+		return BackupResult{}, err
+	}
+	defer os.Remove(tmp)
+	if err := s.db.VacuumInto(tmp); err != nil {
+		return BackupResult{}, fmt.Errorf("copying database: %w", err)
+	}
+	key, size, err := s.uploadDBCopy(ctx, tmp)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	res := BackupResult{DatabaseBackup: DatabaseBackup{Key: key, Size: size}}
+	if s.cfg.BackupRetain > 0 {
+		if res.Deleted, err = s.pruneDBBackups(ctx); err != nil {
+			return res, fmt.Errorf("deleting old backups: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// ListDatabaseBackups returns the copies of the database in the store, made by
+// BackupDatabase and CompactDatabase, oldest first.
+func (s *Server) ListDatabaseBackups(ctx context.Context) ([]DatabaseBackup, error) {
+	return listDBBackups(ctx, s.store, s.cfg.Store.Bucket)
+}
+
+// pruneDBBackups deletes the oldest database backups until Config.BackupRetain are
+// left, returning the number deleted.
+func (s *Server) pruneDBBackups(ctx context.Context) (int, error) {
+	backups, err := s.ListDatabaseBackups(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := 0; i < len(backups)-s.cfg.BackupRetain; i++ {
+		if err := s.store.Delete(s.cfg.Store.Bucket, backups[i].Key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// listDBBackups returns the database copies in the bucket, oldest first.
+func listDBBackups(ctx context.Context, st store.Store, bucket string) ([]DatabaseBackup, error) {
+	lister, ok := st.(store.Lister)
+	if !ok {
+		return nil, errors.New("store does not support listing objects")
+	}
+	var backups []DatabaseBackup
+	err := lister.List(ctx, bucket, dbBackupPrefix, func(key string, info store.ObjectInfo) error {
+		if strings.HasSuffix(key, ".db") {
+			backups = append(backups, DatabaseBackup{Key: key, Size: int64(info.Size)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing database backups: %w", err)
+	}
+	// The keys begin with the time each copy was made
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Key < backups[j].Key })
+	return backups, nil
+}
+
+// RestoreOpts may be provided to RestoreDatabase to configure the restore.
+type RestoreOpts struct {
+	// Key is the key of the backup to restore. Defaults to the latest backup.
+	Key string
+	// Force, if true, replaces the database if it exists.
+	Force bool
+}
+
+// RestoreDatabase downloads a backup made by BackupDatabase or CompactDatabase from
+// the store and writes it to cfg.Database, returning the backup restored. The backup
+// is checked for corruption, and that its schema is supported, before it replaces the
+// database. Returns an error if the database exists unless opts.Force is set. Only the
+// store is opened, and the server must be stopped while the database is replaced.
+func RestoreDatabase(ctx context.Context, cfg Config, opts *RestoreOpts) (DatabaseBackup, error) {
+	if opts == nil {
+		opts = &RestoreOpts{}
+	}
+	if cfg.Database == "" {
+		return DatabaseBackup{}, errors.New("database location is required")
+	}
+	logger := zerolog.New(ioutil.Discard).Level(zerolog.Disabled)
+	if cfg.Logger != nil {
+		logger = *cfg.Logger
+	}
+	exists, err := fileExists(cfg.Database)
+	if err != nil {
+		return DatabaseBackup{}, fmt.Errorf("opening file %s: %w", cfg.Database, err)
+	}
+	if exists && !opts.Force {
+		return DatabaseBackup{}, fmt.Errorf("database %s already exists", cfg.Database)
+	}
+	st, err := openStore(&cfg.Store)
+	if err != nil {
+		return DatabaseBackup{}, fmt.Errorf("connecting to store: %w", err)
+	}
+
+	backup := DatabaseBackup{Key: opts.Key}
+	if backup.Key == "" {
+		backups, err := listDBBackups(ctx, st, cfg.Store.Bucket)
+		if err != nil {
+			return DatabaseBackup{}, err
+		}
+		if len(backups) == 0 {
+			return DatabaseBackup{}, errors.New("no database backups found in the store")
+		}
+		backup = backups[len(backups)-1]
+	}
+
+	tmp := cfg.Database + restoreSuffix
+	if err := removeFiles(tmp, tmp+"-wal", tmp+"-shm"); err != nil {
+		return DatabaseBackup{}, err
+	}
+	defer removeFiles(tmp, tmp+"-wal", tmp+"-shm")
+	if backup.Size, err = downloadFile(ctx, st, cfg.Store.Bucket, backup.Key, tmp); err != nil {
+		return DatabaseBackup{}, fmt.Errorf("downloading backup %s: %w", backup.Key, err)
+	}
+	if err := checkBackup(tmp, databaseOptions(&cfg)); err != nil {
+		return DatabaseBackup{}, fmt.Errorf("backup %s: %w", backup.Key, err)
+	}
+
+	// The log of the replaced database, and any compacted copy of it, must not be
+	// applied to the backup
+	err = removeFiles(
+		cfg.Database+"-wal", cfg.Database+"-shm",
+		cfg.Database+compactedSuffix, cfg.Database+compactedSumSuffix,
+	)
+	if err != nil {
+		return DatabaseBackup{}, err
+	}
+	if err := os.Rename(tmp, cfg.Database); err != nil {
+		return DatabaseBackup{}, err
+	}
+	logger.Info().Msgf("Restored database %s from backup %s", cfg.Database, backup.Key)
+	return backup, nil
+}
+
+// downloadFile writes an object in the store to filename, returning its size.
+func downloadFile(ctx context.Context, st store.Store, bucket string, key string, filename string) (int64, error) {
+	r, err := st.Get(ctx, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// checkBackup returns an error if the database at filename is corrupt or has a schema
+// newer than supported.
+func checkBackup(filename string, opts db.DiskOptions) error {
+	adapter, err := db.OpenDisk(filename, opts)
+	if err != nil {
+		return err
+	}
+	defer adapter.Close()
+	if err := adapter.QuickCheck(); err != nil {
+		return err
+	}
+	_, err = adapter.Migrations()
+	return err
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+)
+
+const (
+	defaultCheckpointSize = 64 * miB
+	defaultCheckpointIdle = 5 * time.Second
+
+	// checkpointInterval is the time between checks for whether the database's
+	// write-ahead log should be checkpointed.
+	checkpointInterval = time.Second
+)
+
+// checkpointer decides when to checkpoint the database's write-ahead log. While
+// updates are being made, the log is only checkpointed once it reaches a size
+// threshold, and then without waiting for the writer, so uploads are never stalled by
+// a checkpoint. Once no updates have been made for an idle period, the whole log is
+// checkpointed and truncated.
+type checkpointer struct {
+	db   *db.Adapter
+	size int64
+	idle time.Duration
+
+	mu sync.Mutex
+	// writes is the adapter's number of updates when the log was last truncated.
+	writes uint64
+}
+
+func newCheckpointer(adapter *db.Adapter, size int64, idle time.Duration) *checkpointer {
+	if size <= 0 {
+		size = defaultCheckpointSize
+	}
+	if idle <= 0 {
+		idle = defaultCheckpointIdle
+	}
+	return &checkpointer{db: adapter, size: size, idle: idle}
+}
+
+// checkpointIfDue checkpoints the log if the database has been idle since the last
+// update, or if the log has reached its size threshold. Returns false if no checkpoint
+// was made.
+func (c *checkpointer) checkpointIfDue(now time.Time) (db.CheckpointResult, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, last := c.db.Writes()
+	if n == c.writes {
+		return db.CheckpointResult{}, false, nil
+	}
+	if now.Sub(last) >= c.idle {
+		res, err := c.db.Checkpoint(db.CheckpointTruncate)
+		if err != nil {
+			return res, true, err
+		}
+		if !res.Busy {
+			c.writes = n
+		}
+		return res, true, nil
+	}
+
+	size, err := c.db.WALSize()
+	if err != nil {
+		return db.CheckpointResult{}, false, err
+	}
+	if size < c.size {
+		return db.CheckpointResult{}, false, nil
+	}
+	res, err := c.db.Checkpoint(db.CheckpointPassive)
+	return res, true, err
+}
+
+// millis returns a duration as a number of milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
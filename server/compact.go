@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// dbBackupPrefix is the store prefix of the database copies uploaded by
+	// CompactDatabase and BackupDatabase.
+	dbBackupPrefix = "backups/db/"
+
+	// dbBackupTimeFormat formats the time a database copy was made in its key, so
+	// the keys sort in the order the copies were made.
+	dbBackupTimeFormat = "20060102T150405.000Z"
+
+	// compactedSuffix is appended to the database's file name to name a compacted
+	// copy which replaces the database when the server next starts, and
+	// compactedSumSuffix to name the file holding the checksum of the database when the
+	// copy was made.
+	compactedSuffix    = ".compact"
+	compactedSumSuffix = ".compact.sum"
+
+	// compactingSuffix names the copy while it's being written.
+	compactingSuffix = ".compact-tmp"
+)
+
+// CompactOpts may be provided to CompactDatabase to configure the compaction.
+type CompactOpts struct {
+	// Swap, if true, replaces the database with the compacted copy when the server
+	// next starts. Updates are blocked while the copy is made, so it holds every
+	// change. The copy is discarded at the start if the database has changed since it
+	// was made.
+	Swap bool
+}
+
+// CompactResult describes a compacted copy of the database.
+type CompactResult struct {
+	// Key is the key of the copy in the store.
+	Key string
+	// Size is the size of the database in bytes, and FreeBytes the size of its unused
+	// pages.
+	Size      int64
+	FreeBytes int64
+	// CompactedSize is the size of the copy in bytes.
+	CompactedSize int64
+	// Pending is true if the copy replaces the database when the server next starts.
+	Pending bool
+}
+
+// CompactDatabase writes a copy of the metadata database without its free pages,
+// which accumulate as vacuums delete rows, and uploads it to the store's backups/db/
+// prefix. If opts.Swap is set, the copy also replaces the database when the server
+// next starts, shrinking the file. The copy is written alongside the database, so
+// there must be space for it.
+func (s *Server) CompactDatabase(ctx context.Context, opts *CompactOpts) (CompactResult, error) {
+	if opts == nil {
+		opts = &CompactOpts{}
+	}
+	if s.cfg.Database == "" {
+		return CompactResult{}, errors.New("database file is not set")
+	}
+	ps, err := s.db.PageStats()
+	if err != nil {
+		return CompactResult{}, fmt.Errorf("getting database page stats: %w", err)
+	}
+	res := CompactResult{Size: ps.Pages * ps.PageSize, FreeBytes: ps.FreePages * ps.PageSize}
+
+	tmp := s.cfg.Database + compactingSuffix
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return CompactResult{}, err
+	}
+	defer os.Remove(tmp)
+	var checksum string
+	if opts.Swap {
+		err = s.db.Freeze(func(filename string) error {
+			if err := s.db.VacuumInto(tmp); err != nil {
+				return err
+			}
+			checksum, err = fileChecksum(filename)
+			return err
+		})
+	} else {
+		err = s.db.VacuumInto(tmp)
+	}
+	if err != nil {
+		return CompactResult{}, fmt.Errorf("compacting database: %w", err)
+	}
+
+	res.Key, res.CompactedSize, err = s.uploadDBCopy(ctx, tmp)
+	if err != nil {
+		return CompactResult{}, err
+	}
+
+	if opts.Swap {
+		// The checksum is written first, so a copy is never found without it
+		sumFile := s.cfg.Database + compactedSumSuffix
+		if err := ioutil.WriteFile(sumFile, []byte(checksum), 0644); err != nil {
+			return CompactResult{}, err
+		}
+		if err := os.Rename(tmp, s.cfg.Database+compactedSuffix); err != nil {
+			return CompactResult{}, err
+		}
+		res.Pending = true
+	}
+	return res, nil
+}
+
+// uploadDBCopy uploads the database copy at filename to the store's backups/db/
+// prefix, under a key holding the current time, and returns the key and the size of
+// the copy.
+func (s *Server) uploadDBCopy(ctx context.Context, filename string) (string, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	key := dbBackupPrefix + time.Now().UTC().Format(dbBackupTimeFormat) + ".db"
+	if err := s.store.Put(ctx, s.cfg.Store.Bucket, key, f); err != nil {
+		return "", 0, fmt.Errorf("uploading database copy: %w", err)
+	}
+	return key, info.Size(), nil
+}
+
+// swapCompactedDB replaces the database at filename with the copy written by
+// CompactDatabase, if there is one and the database has not changed since it was
+// made. The copy is removed if the database has changed. It must be called before
+// the database is opened.
+func swapCompactedDB(filename string, logger zerolog.Logger) error {
+	pending := filename + compactedSuffix
+	sumFile := filename + compactedSumSuffix
+	if _, err := os.Stat(pending); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	want, err := ioutil.ReadFile(sumFile)
+	if err != nil {
+		return fmt.Errorf("reading checksum of compacted database: %w", err)
+	}
+
+	changed := false
+	if info, err := os.Stat(filename + "-wal"); err == nil && info.Size() > 0 {
+		// The log holds updates which have not been checkpointed
+		changed = true
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !changed {
+		got, err := fileChecksum(filename)
+		if err != nil {
+			return err
+		}
+		changed = got != string(want)
+	}
+	if changed {
+		logger.Warn().Msgf("Database %s has changed since it was compacted. Discarding the compacted copy", filename)
+		return removeFiles(pending, sumFile)
+	}
+
+	if err := removeFiles(filename+"-wal", filename+"-shm"); err != nil {
+		return err
+	}
+	if err := os.Rename(pending, filename); err != nil {
+		return err
+	}
+	logger.Info().Msgf("Replaced database %s with its compacted copy", filename)
+	return removeFiles(sumFile)
+}
+
+// fileChecksum returns the hex-encoded SHA-256 checksum of a file.
+func fileChecksum(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removeFiles removes each file which exists.
+func removeFiles(names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twitchtv/twirp"
+)
+
+// The Connect protocol (https://connectrpc.com/docs/protocol) allows browser clients
+// to call the API directly with fetch. Every method in the API is unary, so only
+// unary requests are supported. Requests are translated to Twirp requests, which
+// differ mainly in their URL path, protobuf content type and error format.
+
+const (
+	connectContentTypeJSON  = "application/json"
+	connectContentTypeProto = "application/proto"
+	twirpContentTypeProto   = "application/protobuf"
+
+	connectTimeoutHeader  = "Connect-Timeout-Ms"
+	connectVersionHeader  = "Connect-Protocol-Version"
+	corsMaxAge            = "7200"
+	corsAllowMethods      = "POST, OPTIONS"
+	corsAllowHeaders      = "Authorization, Content-Type, Connect-Protocol-Version, Connect-Timeout-Ms, Traceparent, Tracestate"
+	corsExposeHeaders     = "X-Jotfs-Request-Id"
+	maxConnectTimeoutMsec = 1e10
+)
+
+// connectHandler returns a http handler serving the Connect protocol at the path
+// prefix connectPrefix by forwarding requests to a Twirp handler at twirpPrefix.
+// Cross-origin requests are allowed from the given origins, or from any origin if
+// origins contains "*".
+func connectHandler(h http.Handler, connectPrefix string, twirpPrefix string, origins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if origin := req.Header.Get("Origin"); origin != "" && originAllowed(origin, origins) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if req.Method == http.MethodOptions {
+				// Preflight request
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			connectError(w, twirp.NewError(twirp.Unimplemented, "only unary POST requests are supported"), http.StatusMethodNotAllowed)
+			return
+		}
+		if v := req.Header.Get(connectVersionHeader); v != "" && v != "1" {
+			connectError(w, twirp.InvalidArgumentError(connectVersionHeader, "must be 1"), 0)
+			return
+		}
+		if enc := req.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+			connectError(w, twirp.NewError(twirp.Unimplemented, "unsupported content encoding "+enc), 0)
+			return
+		}
+
+		contentType := req.Header.Get("Content-Type")
+		if i := strings.Index(contentType, ";"); i != -1 {
+			contentType = contentType[:i]
+		}
+		switch strings.TrimSpace(strings.ToLower(contentType)) {
+		case connectContentTypeJSON:
+			contentType = connectContentTypeJSON
+		case connectContentTypeProto:
+			contentType = twirpContentTypeProto
+		default:
+			code := http.StatusUnsupportedMediaType
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		ctx := req.Context()
+		if s := req.Header.Get(connectTimeoutHeader); s != "" {
+			ms, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || ms <= 0 || ms > maxConnectTimeoutMsec {
+				connectError(w, twirp.InvalidArgumentError(connectTimeoutHeader, "invalid timeout"), 0)
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+			defer cancel()
+		}
+
+		r := req.Clone(ctx)
+		r.URL.Path = twirpPrefix + strings.TrimPrefix(req.URL.Path, connectPrefix)
+		r.URL.RawPath = ""
+		r.RequestURI = ""
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Del(connectVersionHeader)
+		r.Header.Del(connectTimeoutHeader)
+
+		cw := &connectWriter{ResponseWriter: w}
+		h.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+func originAllowed(origin string, origins []string) bool {
+	for _, o := range origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// connectWriter translates a Twirp response to a Connect response. Successful
+// responses are passed through. Error responses are buffered until finish is called.
+type connectWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	errBody     bytes.Buffer
+}
+
+func (w *connectWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	if status != http.StatusOK {
+		return
+	}
+	h := w.Header()
+	if h.Get("Content-Type") == twirpContentTypeProto {
+		h.Set("Content-Type", connectContentTypeProto)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *connectWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != http.StatusOK {
+		return w.errBody.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// finish writes the Connect error for a buffered Twirp error response.
+func (w *connectWriter) finish() {
+	if !w.wroteHeader || w.status == http.StatusOK {
+		return
+	}
+	var terr struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(w.errBody.Bytes(), &terr); err != nil || terr.Code == "" {
+		// Not a Twirp error
+		w.Header().Del("Content-Length")
+		connectError(w.ResponseWriter, twirp.NewError(twirp.Unknown, strings.TrimSpace(w.errBody.String())), w.status)
+		return
+	}
+	connectError(w.ResponseWriter, twirp.NewError(twirp.ErrorCode(terr.Code), terr.Msg), 0)
+}
+
+// connectError writes an error in the Connect protocol's JSON format. The HTTP status
+// is derived from the error code if status is zero.
+func connectError(w http.ResponseWriter, err twirp.Error, status int) {
+	code := connectCode(err.Code())
+	if status == 0 {
+		status = connectHTTPStatus(code)
+	}
+	b, _ := json.Marshal(struct {
+		Code    string `json:"code"`
+		Message string `json:"message,omitempty"`
+	}{string(code), err.Msg()})
+	w.Header().Set("Content-Type", connectContentTypeJSON)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// connectCode maps a Twirp error code to a Connect error code. Connect codes are the
+// same as Twirp codes, except for those without an equivalent.
+func connectCode(code twirp.ErrorCode) twirp.ErrorCode {
+	switch code {
+	case twirp.Malformed:
+		return twirp.InvalidArgument
+	case twirp.BadRoute:
+		return twirp.Unimplemented
+	case twirp.NoError:
+		return twirp.Unknown
+	}
+	if !twirp.IsValidErrorCode(code) {
+		return twirp.Unknown
+	}
+	return code
+}
+
+// connectHTTPStatus returns the HTTP status code for a Connect error code.
+func connectHTTPStatus(code twirp.ErrorCode) int {
+	switch code {
+	case twirp.Canceled:
+		return 499
+	case twirp.InvalidArgument, twirp.FailedPrecondition, twirp.OutOfRange:
+		return http.StatusBadRequest
+	case twirp.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case twirp.NotFound:
+		return http.StatusNotFound
+	case twirp.AlreadyExists, twirp.Aborted:
+		return http.StatusConflict
+	case twirp.PermissionDenied:
+		return http.StatusForbidden
+	case twirp.Unauthenticated:
+		return http.StatusUnauthorized
+	case twirp.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case twirp.Unimplemented:
+		return http.StatusNotImplemented
+	case twirp.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	_ "embed" // for the dashboard page
+	"net/http"
+)
+
+// dashboardCSP restricts the dashboard to its own inline script and style, and to
+// requests to the admin API.
+const dashboardCSP = "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'; form-action 'none'; frame-ancestors 'none'"
+
+// dashboardHandler serves the admin dashboard. The page itself contains no server
+// data. It asks the operator for the admin token, which is kept in session storage,
+// and reads everything it displays from the admin API.
+func dashboardHandler(w http.ResponseWriter, req *http.Request) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Security-Policy", dashboardCSP)
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML is the admin dashboard. API paths are relative to the page so the
+// dashboard works when the server is mounted under a sub-path.
+//
+//go:embed dashboard.html
+var dashboardHTML string
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	iserver "github.com/jotfs/jotfs/internal/server"
+	"github.com/jotfs/jotfs/internal/store"
+
+	"github.com/rs/xid"
+	"github.com/rs/zerolog"
+	"github.com/twitchtv/twirp"
+)
+
+const chunkParamsKey = "params.json"
+
+// modulePath is the path of the module the server is built from.
+const modulePath = "github.com/jotfs/jotfs"
+
+func loggingServerHooks(logger zerolog.Logger) *twirp.ServerHooks {
+	hooks := &twirp.ServerHooks{}
+
+	// Define a key type to keep context.WithValue happy
+	type key int
+	const (
+		receivedAtKey key = iota + 1
+		msgKey
+		reqIDKey
+	)
+
+	hooks.RequestReceived = func(ctx context.Context) (context.Context, error) {
+		reqID := xid.New().String()
+		twirp.SetHTTPResponseHeader(ctx, "x-jotfs-request-id", reqID)
+		ctx = context.WithValue(ctx, reqIDKey, xid.New().String())
+		ctx = context.WithValue(ctx, receivedAtKey, time.Now())
+		return ctx, nil
+	}
+
+	hooks.Error = func(ctx context.Context, err twirp.Error) context.Context {
+		ctx = context.WithValue(ctx, msgKey, err.Error())
+		return ctx
+	}
+
+	hooks.ResponseSent = func(ctx context.Context) {
+		var reqID string
+		if v := ctx.Value(reqIDKey); v != nil {
+			reqID = v.(string)
+		}
+		var elapsed time.Duration
+		if v := ctx.Value(receivedAtKey); v != nil {
+			elapsed = time.Since(v.(time.Time))
+		}
+		var msg string
+		if v := ctx.Value(msgKey); v != nil {
+			msg = v.(string)
+		}
+		method, _ := twirp.MethodName(ctx)
+		code, _ := twirp.StatusCode(ctx)
+		status, _ := strconv.Atoi(code)
+
+		rpcLogger := logger.With().
+			Str("method", method).
+			Int("status", status).
+			Int64("elapsed", elapsed.Milliseconds()).
+			Str("id", reqID).
+			Logger()
+
+		if 200 <= status && status < 300 {
+			rpcLogger.Info().Msg(msg)
+		} else if 400 <= status && status < 500 {
+			rpcLogger.Warn().Msg(msg)
+		} else {
+			rpcLogger.Error().Msg(msg)
+		}
+	}
+
+	return hooks
+}
+
+// errStandby is returned for requests which would change the files of a standby server.
+var errStandby = errors.New("server is a standby and does not accept changes")
+
+// standbyMethods are the RPCs served by a standby server. They don't change the
+// database or store.
+var standbyMethods = map[string]bool{
+	"ChunksExist":      true,
+	"List":             true,
+	"Head":             true,
+	"GetFileInfo":      true,
+	"Search":           true,
+	"Download":         true,
+	"DownloadRange":    true,
+	"DownloadDelta":    true,
+	"Diff":             true,
+	"ListTags":         true,
+	"ListSnapshots":    true,
+	"DiffSnapshot":     true,
+	"GetChunkerParams": true,
+	"VacuumStatus":     true,
+	"ServerStats":      true,
+	"ChunkReport":      true,
+}
+
+// standbyServerHooks returns hooks which reject any RPC not in standbyMethods with an
+// Unavailable error.
+func standbyServerHooks() *twirp.ServerHooks {
+	return &twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			method, _ := twirp.MethodName(ctx)
+			if !standbyMethods[method] {
+				return ctx, twirp.NewError(twirp.Unavailable, errStandby.Error())
+			}
+			return ctx, nil
+		},
+	}
+}
+
+// standbyHandler responds to requests which would change the files of a standby server.
+func standbyHandler(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, errStandby.Error(), http.StatusServiceUnavailable)
+}
+
+// postHandler returns a http handler which returns a 500 error code unless invoked
+// through a POST request.
+func postHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			code := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// putHandler returns a http handler which returns a 405 error code unless invoked
+// through a PUT request.
+func putHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" {
+			code := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// logHandler returns a http handler which logs the status code and execution time of
+// the request.
+func logHandler(logger zerolog.Logger, handler http.HandlerFunc, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		ww := &responseWriter{w, 0, ""}
+		handler(ww, req)
+		elapsedMillis := time.Since(start).Milliseconds()
+		reqID := xid.New().String()
+
+		w.Header().Set("x-jotfs-request-id", reqID)
+
+		rpcLogger := logger.With().
+			Str("method", name).
+			Int("status", ww.statusCode).
+			Int("elapsed", int(elapsedMillis)).
+			Str("id", reqID).
+			Logger()
+
+		if 200 <= ww.statusCode && ww.statusCode < 300 {
+			rpcLogger.Info().Msg("")
+		} else if 400 <= ww.statusCode && ww.statusCode < 500 {
+			rpcLogger.Warn().Msg(ww.errMsg)
+		} else {
+			rpcLogger.Error().Msg(ww.errMsg)
+		}
+	}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	errMsg     string
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.statusCode >= 400 {
+		w.errMsg = string(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// healthzHandler reports that the server process is alive.
+func healthzHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports whether the server is ready to accept requests. The server is
+// ready if the database may be queried and the chunker parameters object may be read
+// from the store, and the store's circuit breaker, if any, is not open.
+func readyzHandler(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := s.ready(req.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// versionHandler reports the server's build.
+func versionHandler(build BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, build)
+	}
+}
+
+// buildInfo returns b, with its version set to the version of the jotfs module in the
+// binary's build information if it is not set.
+func buildInfo(b BuildInfo) BuildInfo {
+	if b.Version != "" {
+		return b
+	}
+	b.Version = "devel"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	mod := &info.Main
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			mod = dep
+		}
+	}
+	if mod.Path == modulePath && mod.Version != "" && mod.Version != "(devel)" {
+		b.Version = mod.Version
+	}
+	return b
+}
+
+// ready returns an error if the server is shutting down, or its database or store
+// cannot be reached.
+func (s *Server) ready(ctx context.Context) error {
+	if s.srv.ShuttingDown() {
+		return errors.New("shutting down")
+	}
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	if err := s.db.Ping(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	// The check is a trial request for the store's circuit breaker, and fails fast
+	// while it's open
+	st := s.store
+	if s.breaker != nil {
+		st = s.breaker.Wrap(st)
+	}
+	r, err := st.Get(ctx, s.cfg.Store.Bucket, chunkParamsKey)
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	r.Close()
+	return nil
+}
+
+// getHandler returns a http handler which returns a 405 error code unless invoked
+// through a GET or HEAD request.
+func getHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			code := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// getChunkerParams gets the chunker parameters from the store. Return nil if the file
+// does not exist.
+func getChunkerParams(ctx context.Context, s store.Store, bucket string) (*iserver.ChunkerParams, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	r, err := s.Get(ctx, bucket, chunkParamsKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var params iserver.ChunkerParams
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading object: %v", err)
+	}
+	if err := json.Unmarshal(b, &params); err != nil {
+		return nil, fmt.Errorf("decoding JSON: %v", err)
+	}
+
+	return &params, nil
+}
+
+// waitForChunkerParams gets the chunker parameters from the store, retrying with
+// exponential backoff for up to timeout if the store is unreachable.
+func waitForChunkerParams(ctx context.Context, s store.Store, bucket string, timeout time.Duration, logger zerolog.Logger) (*iserver.ChunkerParams, error) {
+	deadline := time.Now().Add(timeout)
+	delay := minStoreRetryDelay
+	for {
+		params, err := getChunkerParams(ctx, s, bucket)
+		if err == nil || time.Now().Add(delay).After(deadline) {
+			return params, err
+		}
+		logger.Warn().Msgf("store unreachable, retrying in %s: %v", delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxStoreRetryDelay {
+			delay = maxStoreRetryDelay
+		}
+	}
+}
+
+// saveChunkerParams saves the chunker params to the store.
+func saveChunkerParams(ctx context.Context, s store.Store, bucket string, params *iserver.ChunkerParams) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("encoding json: %v", err)
+	}
+	if err := s.Put(ctx, bucket, chunkParamsKey, bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("putting object to store: %v", err)
+	}
+
+	return nil
+}
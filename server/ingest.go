@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jotfs/jotfs/client"
+	"github.com/jotfs/jotfs/internal/pipenet"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/store/file"
+
+	"github.com/twitchtv/twirp"
+)
+
+// ingestETagKey is the metadata key recording the ETag of the object a file version was
+// ingested from, so objects which have not changed are skipped when ingesting again.
+const ingestETagKey = "ingest-etag"
+
+// IngestOpts may be provided to IngestStore to configure the import.
+type IngestOpts struct {
+	// Prefix selects the objects in the source bucket whose keys begin with it. The
+	// prefix is removed from each key to form the file's name.
+	Prefix string
+	// Dst is the directory the files are created in. Defaults to "/".
+	Dst string
+	// Progress, if set, is called for each object with its key and whether it was
+	// "upload", "skip" or "invalid".
+	Progress func(key string, action string)
+}
+
+// IngestResult summarizes an import of objects from another store.
+type IngestResult struct {
+	// Uploaded is the number of objects uploaded as new file versions, and Bytes their
+	// total size.
+	Uploaded int
+	Bytes    uint64
+	// Unchanged is the number of objects skipped because the latest version of their
+	// file was ingested from the same object.
+	Unchanged int
+	// Invalid is the number of objects skipped because their key is not a valid file
+	// name, e.g. a key containing a ".." element.
+	Invalid int
+}
+
+// IngestStore imports the objects in the bucket of the store src, e.g. an existing
+// data set in S3, as files. Each object is chunked and deduplicated against the data
+// already stored, as if uploaded by a client, but is read by the server directly from
+// src. The source store must support listing, and its objects are not changed. Each
+// new file version records the ETag of its object in the metadata key "ingest-etag",
+// so running the import again only uploads objects which are new or have changed.
+func (s *Server) IngestStore(ctx context.Context, src StoreConfig, opts *IngestOpts) (IngestResult, error) {
+	if opts == nil {
+		opts = &IngestOpts{}
+	}
+	if s.cfg.Standby {
+		return IngestResult{}, errStandby
+	}
+	if src.Bucket == "" && src.URL == "" {
+		return IngestResult{}, errors.New("source store bucket or URL is required")
+	}
+	srcStore, err := openStore(&src)
+	if err != nil {
+		return IngestResult{}, fmt.Errorf("opening source store: %w", err)
+	}
+	lister, ok := srcStore.(store.Lister)
+	if !ok {
+		return IngestResult{}, errors.New("source store does not support listing objects")
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(string, string) {}
+	}
+	dst := path.Clean("/" + opts.Dst)
+	c, err := s.localClient()
+	if err != nil {
+		return IngestResult{}, err
+	}
+
+	var res IngestResult
+	err = lister.List(ctx, src.Bucket, opts.Prefix, func(key string, info store.ObjectInfo) error {
+		if strings.HasSuffix(key, "/") {
+			// A directory marker
+			return nil
+		}
+		rel := strings.TrimPrefix(key, opts.Prefix)
+		if rel == "" {
+			// The prefix is the key of a single object
+			rel = path.Base(key)
+		}
+		// The name is not cleaned here, so the server rejects keys with ".." elements
+		// instead of creating files outside dst
+		name := strings.TrimSuffix(dst, "/") + "/" + rel
+		invalid := func() error {
+			res.Invalid++
+			progress(key, "invalid")
+			return nil
+		}
+		if info.ETag != "" {
+			latest, err := c.Latest(ctx, name)
+			if isInvalidArgument(err) {
+				return invalid()
+			}
+			if err != nil && !errors.Is(err, client.ErrNotFound) {
+				return fmt.Errorf("getting latest version of %s: %w", name, err)
+			}
+			if err == nil && latest.Size == info.Size && latest.Metadata[ingestETagKey] == info.ETag {
+				res.Unchanged++
+				progress(key, "skip")
+				return nil
+			}
+		}
+
+		r, err := srcStore.Get(ctx, src.Bucket, key)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", key, err)
+		}
+		defer r.Close()
+		var metadata map[string]string
+		if info.ETag != "" {
+			metadata = map[string]string{ingestETagKey: info.ETag}
+		}
+		_, err = c.UploadWithMetadata(ctx, r, name, metadata)
+		if isInvalidArgument(err) {
+			return invalid()
+		}
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", key, err)
+		}
+		res.Uploaded++
+		res.Bytes += info.Size
+		progress(key, "upload")
+		return nil
+	})
+	return res, err
+}
+
+// isInvalidArgument returns true if err wraps a Twirp InvalidArgument error.
+func isInvalidArgument(err error) bool {
+	var terr twirp.Error
+	return errors.As(err, &terr) && terr.Code() == twirp.InvalidArgument
+}
+
+// localHost is the address of the server's own handlers for a local client.
+const localHost = "jotfs.local:80"
+
+// localClient returns a client which sends requests directly to the server's API
+// handlers in the same process, over in-memory connections. Requests are not subject
+// to the access policy or load shedding. Downloads from stores which serve their own
+// URLs are also handled in the process, and other stores are requested as usual.
+func (s *Server) localClient() (*client.Client, error) {
+	s.localOnce.Do(func() {
+		mux := http.NewServeMux()
+		api := pb.NewJotFSServer(s.srv, nil)
+		mux.Handle(api.PathPrefix(), api)
+		mux.HandleFunc("/packfile", s.srv.PackfileUploadHandler)
+		if h, ok := s.store.(http.Handler); ok {
+			mux.Handle(file.URLPrefix+"/", http.StripPrefix(file.URLPrefix, h))
+		}
+		ln := pipenet.Listen(localHost)
+		s.local = &http.Server{Handler: mux}
+		s.localTransport = ln.Transport()
+		go s.local.Serve(ln)
+	})
+	if s.local == nil {
+		return nil, errors.New("server closed")
+	}
+	hclient := &http.Client{Transport: s.localTransport}
+	return client.New("http://"+localHost, &client.Options{HTTPClient: hclient})
+}
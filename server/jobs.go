@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/rs/zerolog"
+)
+
+// Names of the background jobs run by the scheduler.
+const (
+	JobVacuum  = "vacuum"
+	JobBackup  = "backup"
+	JobCompact = "compact"
+	JobScrub   = "scrub"
+	JobOpLog   = "oplog"
+	JobReport  = "report"
+)
+
+// defaultMaxJobs is the default number of background jobs run at once.
+const defaultMaxJobs = 2
+
+// jobOff is the schedule which disables a job.
+const jobOff = "off"
+
+var (
+	// ErrJobNotFound is returned by RunJob for a job which is not scheduled.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobRunning is returned by RunJob for a job which is already running.
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// JobStatus describes a background job.
+type JobStatus struct {
+	Name     string
+	Schedule string
+	Running  bool
+	// NextRun is the time the job next runs.
+	NextRun time.Time
+	// LastStart is the time the last run started, and LastFinish the time it
+	// finished, which is zero if it's running or the server stopped during it.
+	// LastError is the error returned by the last run, if it failed.
+	LastStart  time.Time
+	LastFinish time.Time
+	LastError  string
+	// Runs is the number of times the job has run, and Failures the number of runs
+	// which failed.
+	Runs     int64
+	Failures int64
+}
+
+// job is a background task run on a schedule.
+type job struct {
+	name  string
+	spec  string
+	sched schedule
+	run   func(ctx context.Context) error
+	// trigger starts a run without waiting for the next scheduled time.
+	trigger chan struct{}
+
+	// The fields below are guarded by the scheduler's mutex
+	running bool
+	next    time.Time
+	last    db.JobRun
+}
+
+// scheduler runs background jobs on their schedules, at most maxJobs at once. A job
+// due while the limit is reached waits for another to finish, and a job is never
+// run twice at once. The last run of each job is recorded in the database, so the
+// schedules carry on from where they were when the server restarts: a job which was
+// due while the server was stopped runs once as soon as it starts.
+type scheduler struct {
+	db     *db.Adapter
+	logger zerolog.Logger
+	slots  chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newScheduler(adapter *db.Adapter, maxJobs int, logger zerolog.Logger) *scheduler {
+	if maxJobs <= 0 {
+		maxJobs = defaultMaxJobs
+	}
+	return &scheduler{
+		db:     adapter,
+		logger: logger,
+		slots:  make(chan struct{}, maxJobs),
+		jobs:   make(map[string]*job),
+	}
+}
+
+// add schedules a job. spec is parsed by parseSchedule. The job is not added if spec
+// is "off".
+func (s *scheduler) add(name string, spec string, run func(ctx context.Context) error) error {
+	if spec == jobOff {
+		return nil
+	}
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[name] = &job{name: name, spec: spec, sched: sched, run: run, trigger: make(chan struct{}, 1)}
+	return nil
+}
+
+// start loads the last run of each job from the database and runs the jobs on their
+// schedules until ctx is cancelled.
+func (s *scheduler) start(ctx context.Context) {
+	runs, err := s.db.ListJobRuns()
+	if err != nil {
+		s.logger.Error().Msgf("reading background job runs: %v", err)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		j.next = j.sched.next(now)
+		if last, ok := runs[j.name]; ok {
+			j.last = last
+			if next := j.sched.next(last.StartedAt); next.Before(j.next) {
+				j.next = next
+			}
+		}
+		go s.loop(ctx, j)
+	}
+}
+
+// loop runs a job at each of its scheduled times, or when it's triggered, until ctx is
+// cancelled.
+func (s *scheduler) loop(ctx context.Context, j *job) {
+	for {
+		s.mu.Lock()
+		next := j.next
+		s.mu.Unlock()
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-j.trigger:
+			timer.Stop()
+		case <-timer.C:
+		}
+		s.run(ctx, j)
+	}
+}
+
+// run waits for a free slot, runs a job and records the run.
+func (s *scheduler) run(ctx context.Context, j *job) {
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-s.slots }()
+
+	s.mu.Lock()
+	run := j.last
+	run.Name = j.name
+	run.StartedAt = time.Now()
+	run.FinishedAt = time.Time{}
+	run.Error = ""
+	run.Runs++
+	j.last = run
+	j.running = true
+	s.mu.Unlock()
+	s.save(run)
+
+	err := j.run(ctx)
+
+	now := time.Now()
+	run.FinishedAt = now
+	if err != nil {
+		run.Error = err.Error()
+		run.Failures++
+		s.logger.Error().Msgf("background job %s: %v", j.name, err)
+	}
+	s.mu.Lock()
+	j.last = run
+	j.running = false
+	// Scheduled times missed while the job ran are skipped
+	j.next = j.sched.next(run.StartedAt)
+	if j.next.Before(now) {
+		j.next = j.sched.next(now)
+	}
+	s.mu.Unlock()
+	s.save(run)
+}
+
+// save records a job run in the database.
+func (s *scheduler) save(run db.JobRun) {
+	if err := s.db.SaveJobRun(run); err != nil {
+		s.logger.Error().Msgf("recording run of background job %s: %v", run.Name, err)
+	}
+}
+
+// runNow starts a run of a job without waiting for its next scheduled time. Returns
+// ErrJobNotFound if the job is not scheduled and ErrJobRunning if it's running.
+func (s *scheduler) runNow(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.running {
+		return ErrJobRunning
+	}
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// A run has already been triggered
+	}
+	return nil
+}
+
+// status returns the status of each job, ordered by name.
+func (s *scheduler) status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		res = append(res, JobStatus{
+			Name:       j.name,
+			Schedule:   j.spec,
+			Running:    j.running,
+			NextRun:    j.next,
+			LastStart:  j.last.StartedAt,
+			LastFinish: j.last.FinishedAt,
+			LastError:  j.last.Error,
+			Runs:       j.last.Runs,
+			Failures:   j.last.Failures,
+		})
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Name < res[k].Name })
+	return res
+}
+
+// newJobs schedules the server's background jobs. The schedule of each job is taken
+// from Config.Jobs, or from the interval configured for it. A standby server runs no
+// jobs.
+func (s *Server) newJobs() (*scheduler, error) {
+	sched := newScheduler(s.db, s.cfg.MaxJobs, s.logger)
+	specs := make(map[string]string)
+	every := func(d time.Duration) string {
+		if d <= 0 {
+			return jobOff
+		}
+		return "@every " + d.String()
+	}
+	specs[JobVacuum] = every(s.cfg.VacuumInterval)
+	specs[JobBackup] = every(s.cfg.BackupInterval)
+	specs[JobOpLog] = every(s.cfg.OpLogInterval)
+	specs[JobCompact] = jobOff
+	specs[JobScrub] = jobOff
+	specs[JobReport] = jobOff
+	if s.cfg.Report.enabled() {
+		specs[JobReport] = every(reportCheckInterval)
+	}
+	for name, spec := range s.cfg.Jobs {
+		if _, ok := specs[name]; !ok {
+			return nil, fmt.Errorf("unknown background job %q", name)
+		}
+		if spec != jobOff {
+			if _, err := parseSchedule(spec); err != nil {
+				return nil, fmt.Errorf("job %s: %w", name, err)
+			}
+		}
+		specs[name] = spec
+	}
+	if s.cfg.Standby {
+		return sched, nil
+	}
+
+	runs := map[string]func(ctx context.Context) error{
+		JobVacuum: func(ctx context.Context) error {
+			// The vacuum is left to complete when the server stops, like a vacuum
+			// started by a client
+			_, err := s.srv.Vacuum(context.Background())
+			return err
+		},
+		JobBackup: func(ctx context.Context) error {
+			res, err := s.BackupDatabase(ctx)
+			if err == nil {
+				s.logger.Info().Msgf("Backed up database to %s (%d bytes)", res.Key, res.Size)
+			}
+			return err
+		},
+		JobCompact: func(ctx context.Context) error {
+			res, err := s.CompactDatabase(ctx, nil)
+			if err == nil {
+				s.logger.Info().Msgf("Uploaded compacted database %s (%d bytes)", res.Key, res.CompactedSize)
+			}
+			return err
+		},
+		JobScrub: func(ctx context.Context) error {
+			res, err := s.srv.Scrub(ctx)
+			if err == nil && res.Degraded > 0 {
+				s.logger.Warn().Msgf("Scrub found %d degraded packfiles out of %d", res.Degraded, res.Checked)
+			}
+			return err
+		},
+		JobOpLog: s.srv.ShipOpLog,
+		JobReport: func(ctx context.Context) error {
+			return s.sendReportIfDue(ctx, time.Now())
+		},
+	}
+	for name, spec := range specs {
+		if err := sched.add(name, spec, runs[name]); err != nil {
+			return nil, err
+		}
+	}
+	return sched, nil
+}
+
+// Jobs returns the status of each scheduled background job, ordered by name.
+func (s *Server) Jobs() []JobStatus {
+	return s.jobs.status()
+}
+
+// RunJob starts a run of a scheduled background job without waiting for its next
+// scheduled time. Returns ErrJobNotFound if the job is not scheduled, and
+// ErrJobRunning if it's already running.
+func (s *Server) RunJob(name string) error {
+	return s.jobs.runNow(name)
+}
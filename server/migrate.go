@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MigrateOpts may be provided to MigrateStore to configure the migration.
+type MigrateOpts struct {
+	// UpdateETags replaces the ETag recorded for each packfile with its ETag in the
+	// destination store. Set it on the last migration before the server is switched to
+	// the destination store, while no packfiles are being uploaded, so a scrub does
+	// not mark every packfile as degraded.
+	UpdateETags bool
+	// Progress, if set, is called for each object with its key and whether it was
+	// "copy", "skip" or "missing".
+	Progress func(key string, action string)
+}
+
+// MigrateResult summarizes a store migration.
+type MigrateResult struct {
+	// Copied is the number of objects copied to the destination store, and Bytes
+	// their total size.
+	Copied int
+	Bytes  uint64
+	// Skipped is the number of objects already in the destination store.
+	Skipped int
+	// Missing is the number of objects referenced by the database which are not in
+	// the server's store, e.g. the packfiles of degraded packs.
+	Missing int
+}
+
+// MigrateStore copies every object referenced by the server's database from its store
+// to the store dst, e.g. to move from MinIO to S3. Each packfile and file object is
+// checked against its checksum as it's copied. Nothing clients see is changed, so the
+// migration may run while the server is serving requests. Objects already in dst are
+// skipped, so running the migration again copies only the objects created since. To
+// switch stores, migrate while the server runs, then stop the server, migrate again
+// with UpdateETags set and restart the server with dst.
+func (s *Server) MigrateStore(ctx context.Context, dst StoreConfig, opts *MigrateOpts) (MigrateResult, error) {
+	if opts == nil {
+		opts = &MigrateOpts{}
+	}
+	if dst.Bucket == "" && dst.URL == "" {
+		return MigrateResult{}, errors.New("destination store bucket or URL is required")
+	}
+	store, err := openStore(&dst)
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("opening destination store: %w", err)
+	}
+	if dst == s.cfg.Store {
+		return MigrateResult{}, errors.New("destination store is the server's store")
+	}
+	res, err := s.srv.MigrateStore(ctx, store, dst.Bucket, opts.UpdateETags, opts.Progress)
+	return MigrateResult{Copied: res.Copied, Bytes: res.Bytes, Skipped: res.Skipped, Missing: res.Missing}, err
+}
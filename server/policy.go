@@ -0,0 +1,672 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/twitchtv/twirp"
+
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	iserver "github.com/jotfs/jotfs/internal/server"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// policyReloadInterval is the time between checks for changes to the access policy
+// file.
+const policyReloadInterval = 10 * time.Second
+
+// policyFile is the TOML encoding of an access policy file. The file grants API keys
+// permission to read, write and delete files by name prefix, e.g.
+//
+//	[roles.backup]
+//	read = ["/backups"]
+//	write = ["/backups"]
+//
+//	[roles.ops]
+//	read = ["/"]
+//	delete = ["/"]
+//	admin = true
+//
+//	[[keys]]
+//	name = "nightly-backup"
+//	sha256 = "<hex SHA-256 of the key>"
+//	roles = ["backup"]
+//	requests_per_second = 20
+//	bytes_per_second = 10485760
+//	max_upload_mbps = 100
+//
+// Keys are stored as their SHA-256 hash, so the file may be kept in version control.
+// A prefix grants access to the file or directory with that name, and everything
+// below it. Admin grants access to vacuums and server stats. A key's
+// requests_per_second and bytes_per_second, if set, replace the server's per-client
+// rate limits for the key, and its max_upload_mbps and max_download_mbps its bandwidth
+// limits.
+type policyFile struct {
+	Roles map[string]policyRole `toml:"roles"`
+	Keys  []policyKey           `toml:"keys"`
+}
+
+type policyRole struct {
+	Read   []string `toml:"read"`
+	Write  []string `toml:"write"`
+	Delete []string `toml:"delete"`
+	Admin  bool     `toml:"admin"`
+}
+
+type policyKey struct {
+	Name   string   `toml:"name"`
+	SHA256 string   `toml:"sha256"`
+	Roles  []string `toml:"roles"`
+
+	RequestsPerSecond int64 `toml:"requests_per_second"`
+	BytesPerSecond    int64 `toml:"bytes_per_second"`
+	MaxUploadMbps     int64 `toml:"max_upload_mbps"`
+	MaxDownloadMbps   int64 `toml:"max_download_mbps"`
+}
+
+type permission int
+
+const (
+	permRead permission = iota
+	permWrite
+	permDelete
+)
+
+func (p permission) String() string {
+	return [...]string{"read", "write", "delete"}[p]
+}
+
+// grants are the permissions of an API key.
+type grants struct {
+	name     string
+	prefixes [3][]string
+	admin    bool
+	// rateRequests and rateBytes are the key's rate limits, if set
+	rateRequests float64
+	rateBytes    float64
+	// uploadBytes and downloadBytes are the key's bandwidth limits in bytes per
+	// second, if set
+	uploadBytes   float64
+	downloadBytes float64
+}
+
+// allowed returns true if the key may act on the file, or every file under the
+// prefix, name. name must be normalized.
+func (g *grants) allowed(p permission, name string) bool {
+	for _, prefix := range g.prefixes[p] {
+		if prefix == "/" || name == prefix || strings.HasPrefix(name, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// accessPolicy maps the SHA-256 hash of each API key to its grants.
+type accessPolicy map[[sha256.Size]byte]*grants
+
+// loadPolicy reads and validates an access policy file.
+func loadPolicy(filename string) (accessPolicy, error) {
+	var f policyFile
+	md, err := toml.DecodeFile(filename, &f)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown field %s", undecoded[0])
+	}
+	for name, role := range f.Roles {
+		for _, prefixes := range [][]string{role.Read, role.Write, role.Delete} {
+			for i, prefix := range prefixes {
+				if prefix == "" {
+					return nil, fmt.Errorf("role %s: empty prefix", name)
+				}
+				prefixes[i] = path.Clean("/" + prefix)
+			}
+		}
+	}
+
+	policy := make(accessPolicy, len(f.Keys))
+	for i, key := range f.Keys {
+		if key.Name == "" {
+			key.Name = fmt.Sprintf("keys[%d]", i)
+		}
+		b, err := hex.DecodeString(key.SHA256)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("key %s: sha256 must be %d hex-encoded bytes", key.Name, sha256.Size)
+		}
+		var h [sha256.Size]byte
+		copy(h[:], b)
+		if _, ok := policy[h]; ok {
+			return nil, fmt.Errorf("key %s: duplicate sha256", key.Name)
+		}
+		if key.RequestsPerSecond < 0 || key.BytesPerSecond < 0 || key.MaxUploadMbps < 0 || key.MaxDownloadMbps < 0 {
+			return nil, fmt.Errorf("key %s: rate limits may not be negative", key.Name)
+		}
+		g := &grants{
+			name:          key.Name,
+			rateRequests:  float64(key.RequestsPerSecond),
+			rateBytes:     float64(key.BytesPerSecond),
+			uploadBytes:   float64(key.MaxUploadMbps) * bytesPerMbps,
+			downloadBytes: float64(key.MaxDownloadMbps) * bytesPerMbps,
+		}
+		for _, name := range key.Roles {
+			role, ok := f.Roles[name]
+			if !ok {
+				return nil, fmt.Errorf("key %s: unknown role %q", key.Name, name)
+			}
+			g.prefixes[permRead] = append(g.prefixes[permRead], role.Read...)
+			g.prefixes[permWrite] = append(g.prefixes[permWrite], role.Write...)
+			g.prefixes[permDelete] = append(g.prefixes[permDelete], role.Delete...)
+			g.admin = g.admin || role.Admin
+		}
+		policy[h] = g
+	}
+	return policy, nil
+}
+
+// policyWatcher holds the access policy loaded from a file, and reloads it when the
+// file changes.
+type policyWatcher struct {
+	filename string
+
+	mu      sync.RWMutex
+	policy  accessPolicy
+	modTime time.Time
+	size    int64
+}
+
+// newPolicyWatcher loads the access policy from filename.
+func newPolicyWatcher(filename string) (*policyWatcher, error) {
+	w := &policyWatcher{filename: filename}
+	if _, err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// reload loads the policy file if it has changed since it was last loaded. Returns
+// true if the policy was reloaded. The current policy is kept if the file is invalid.
+func (w *policyWatcher) reload() (bool, error) {
+	info, err := os.Stat(w.filename)
+	if err != nil {
+		return false, err
+	}
+	w.mu.RLock()
+	unchanged := w.policy != nil && info.ModTime().Equal(w.modTime) && info.Size() == w.size
+	w.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	policy, err := loadPolicy(w.filename)
+	if err != nil {
+		return false, fmt.Errorf("access policy %s: %w", w.filename, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.policy = policy
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return true, nil
+}
+
+// authenticate returns the grants of the API key sent as a bearer token with req.
+func (w *policyWatcher) authenticate(req *http.Request) (*grants, error) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errors.New("API key required")
+	}
+	h := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	g, ok := w.policy[h]
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+	return g, nil
+}
+
+type grantsKey struct{}
+
+// handler returns a http handler which authenticates each Twirp request before passing
+// it to h. The grants of the request's API key are added to its context.
+func (w *policyWatcher) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		g, err := w.authenticate(req)
+		if err != nil {
+			twirp.WriteError(rw, twirp.NewError(twirp.Unauthenticated, err.Error()))
+			return
+		}
+		h.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), grantsKey{}, g)))
+	})
+}
+
+// uploadHandler returns a http handler which only passes uploads to h if they are sent
+// with an API key which may write to at least one prefix. As with handler, the key's
+// grants are added to the request context.
+func (w *policyWatcher) uploadHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		g, err := w.authenticate(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if len(g.prefixes[permWrite]) == 0 {
+			http.Error(rw, fmt.Sprintf("key %s may not upload files", g.name), http.StatusForbidden)
+			return
+		}
+		h(rw, req.WithContext(context.WithValue(req.Context(), grantsKey{}, g)))
+	}
+}
+
+// policyServer checks the grants of each request's API key before passing it to the
+// server.
+type policyServer struct {
+	*iserver.Server
+	db *db.Adapter
+}
+
+func requestGrants(ctx context.Context) *grants {
+	g, _ := ctx.Value(grantsKey{}).(*grants)
+	if g == nil {
+		// Requests are always authenticated by policyWatcher.handler
+		return &grants{}
+	}
+	return g
+}
+
+// checkWritePermission returns a PermissionDenied error unless the request's key may
+// write to the file name, which is already normalized.
+func checkWritePermission(ctx context.Context, name string) error {
+	g := requestGrants(ctx)
+	if !g.allowed(permWrite, name) {
+		return twirp.NewError(twirp.PermissionDenied, fmt.Sprintf("key %s may not %s %s", g.name, permWrite, name))
+	}
+	return nil
+}
+
+// check returns a PermissionDenied error unless the request's key has permission p on
+// each of names, which are normalized first.
+func (s *policyServer) check(ctx context.Context, p permission, names ...string) error {
+	g := requestGrants(ctx)
+	for _, name := range names {
+		name = s.NormalizeName(name)
+		if name == "" {
+			name = "/"
+		}
+		if !g.allowed(p, name) {
+			return twirp.NewError(twirp.PermissionDenied, fmt.Sprintf("key %s may not %s %s", g.name, p, name))
+		}
+	}
+	return nil
+}
+
+// checkVersion checks permission p on the name of a file version, as with check. A
+// version which does not exist passes the check, so the server returns its NotFound
+// error.
+func (s *policyServer) checkVersion(ctx context.Context, p permission, b []byte) error {
+	id, err := sum.FromBytes(b)
+	if err != nil {
+		// Let the server report the invalid argument
+		return nil
+	}
+	info, err := s.db.GetFileInfo(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("db GetFileInfo: %w", err)
+	}
+	return s.check(ctx, p, info.Name)
+}
+
+// checkSnapshot checks permission p on the directory of a snapshot, as with check. A
+// snapshot which does not exist passes the check, so the server returns its NotFound
+// error.
+func (s *policyServer) checkSnapshot(ctx context.Context, p permission, name string) error {
+	snap, err := s.db.GetSnapshot(name)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("db GetSnapshot: %w", err)
+	}
+	return s.check(ctx, p, snap.Prefix)
+}
+
+func (s *policyServer) checkAdmin(ctx context.Context) error {
+	if g := requestGrants(ctx); !g.admin {
+		return twirp.NewError(twirp.PermissionDenied, fmt.Sprintf("key %s is not an admin", g.name))
+	}
+	return nil
+}
+
+// filterInfos removes the files the request's key may not read.
+func filterInfos(ctx context.Context, infos []*pb.FileInfo) []*pb.FileInfo {
+	g := requestGrants(ctx)
+	res := infos[:0]
+	for _, info := range infos {
+		if g.allowed(permRead, info.Name) {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+func (s *policyServer) CreateFile(ctx context.Context, file *pb.File) (*pb.FileID, error) {
+	if err := s.check(ctx, permWrite, file.Name); err != nil {
+		return nil, err
+	}
+	if iserver.ByReference(file) {
+		if err := s.checkReference(ctx, file.Checksums["sha256"]); err != nil {
+			return nil, err
+		}
+	}
+	return s.Server.CreateFile(ctx, file)
+}
+
+func (s *policyServer) Append(ctx context.Context, req *pb.AppendRequest) (*pb.FileID, error) {
+	if err := s.check(ctx, permWrite, req.Name); err != nil {
+		return nil, err
+	}
+	return s.Server.Append(ctx, req)
+}
+
+func (s *policyServer) Concat(ctx context.Context, req *pb.ConcatRequest) (*pb.FileID, error) {
+	if err := s.check(ctx, permWrite, req.Dst); err != nil {
+		return nil, err
+	}
+	for _, id := range req.SrcIds {
+		if err := s.checkVersion(ctx, permRead, id); err != nil {
+			return nil, err
+		}
+	}
+	return s.Server.Concat(ctx, req)
+}
+
+// checkReference returns a NotFound error unless the request's key may read the file
+// version a new version with the SHA-256 checksum would reference, so a key can't get
+// the data of a file it may not read from its checksum alone. The error is the same as
+// for a checksum no version has, so the file's existence isn't disclosed either.
+func (s *policyServer) checkReference(ctx context.Context, checksum []byte) error {
+	id, err := s.db.FindFileVersionByChecksum("sha256", checksum)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("db FindFileVersionByChecksum: %w", err)
+	}
+	info, err := s.db.GetFileInfo(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("db GetFileInfo: %w", err)
+	}
+	if !requestGrants(ctx).allowed(permRead, info.Name) {
+		return twirp.NotFoundError(fmt.Sprintf("no file version has sha256 checksum %x", checksum))
+	}
+	return nil
+}
+
+// List requires permission to read the prefix. Files under the prefix which the key
+// may not read are removed from the response, e.g. /logs2 when listing /logs.
+func (s *policyServer) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	if err := s.check(ctx, permRead, req.Prefix); err != nil {
+		return nil, err
+	}
+	resp, err := s.Server.List(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Info = filterInfos(ctx, resp.Info)
+	g := requestGrants(ctx)
+	prefixes := resp.Prefixes[:0]
+	for _, p := range resp.Prefixes {
+		if g.allowed(permRead, s.NormalizeName(p)) {
+			prefixes = append(prefixes, p)
+		}
+	}
+	resp.Prefixes = prefixes
+	return resp, nil
+}
+
+func (s *policyServer) Head(ctx context.Context, req *pb.HeadRequest) (*pb.HeadResponse, error) {
+	if err := s.check(ctx, permRead, req.Name); err != nil {
+		return nil, err
+	}
+	return s.Server.Head(ctx, req)
+}
+
+func (s *policyServer) GetFileInfo(ctx context.Context, req *pb.GetFileInfoRequest) (*pb.GetFileInfoResponse, error) {
+	var err error
+	if req.Sum != nil {
+		err = s.checkVersion(ctx, permRead, req.Sum)
+	} else {
+		err = s.check(ctx, permRead, req.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Server.GetFileInfo(ctx, req)
+}
+
+// Search only returns the files the key may read.
+func (s *policyServer) Search(ctx context.Context, req *pb.SearchRequest) (*pb.SearchResponse, error) {
+	resp, err := s.Server.Search(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Info = filterInfos(ctx, resp.Info)
+	return resp, nil
+}
+
+func (s *policyServer) Download(ctx context.Context, id *pb.FileID) (*pb.DownloadResponse, error) {
+	if err := s.checkVersion(ctx, permRead, id.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.Download(ctx, id)
+}
+
+func (s *policyServer) DownloadRange(ctx context.Context, req *pb.DownloadRangeRequest) (*pb.DownloadRangeResponse, error) {
+	if err := s.checkVersion(ctx, permRead, req.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.DownloadRange(ctx, req)
+}
+
+func (s *policyServer) DownloadDelta(ctx context.Context, req *pb.DownloadDeltaRequest) (*pb.DownloadDeltaResponse, error) {
+	if err := s.checkVersion(ctx, permRead, req.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.DownloadDelta(ctx, req)
+}
+
+func (s *policyServer) Diff(ctx context.Context, req *pb.DiffRequest) (*pb.DiffResponse, error) {
+	if err := s.checkVersion(ctx, permRead, req.OldSum); err != nil {
+		return nil, err
+	}
+	if err := s.checkVersion(ctx, permRead, req.NewSum); err != nil {
+		return nil, err
+	}
+	return s.Server.Diff(ctx, req)
+}
+
+func (s *policyServer) Copy(ctx context.Context, req *pb.CopyRequest) (*pb.FileID, error) {
+	if err := s.checkVersion(ctx, permRead, req.SrcId); err != nil {
+		return nil, err
+	}
+	if err := s.check(ctx, permWrite, req.Dst); err != nil {
+		return nil, err
+	}
+	return s.Server.Copy(ctx, req)
+}
+
+func (s *policyServer) RevertToVersion(ctx context.Context, req *pb.RevertToVersionRequest) (*pb.FileID, error) {
+	if err := s.checkVersion(ctx, permRead, req.Sum); err != nil {
+		return nil, err
+	}
+	if err := s.checkVersion(ctx, permWrite, req.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.RevertToVersion(ctx, req)
+}
+
+func (s *policyServer) Rename(ctx context.Context, req *pb.RenameRequest) (*pb.RenameResponse, error) {
+	if err := s.check(ctx, permDelete, req.Src); err != nil {
+		return nil, err
+	}
+	if err := s.check(ctx, permWrite, req.Dst); err != nil {
+		return nil, err
+	}
+	return s.Server.Rename(ctx, req)
+}
+
+func (s *policyServer) SetMetadata(ctx context.Context, req *pb.SetMetadataRequest) (*pb.SetMetadataResponse, error) {
+	if err := s.checkVersion(ctx, permWrite, req.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.SetMetadata(ctx, req)
+}
+
+func (s *policyServer) TagVersion(ctx context.Context, req *pb.TagVersionRequest) (*pb.Empty, error) {
+	if err := s.checkVersion(ctx, permWrite, req.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.TagVersion(ctx, req)
+}
+
+func (s *policyServer) UntagVersion(ctx context.Context, req *pb.UntagVersionRequest) (*pb.Empty, error) {
+	if err := s.check(ctx, permWrite, req.Name); err != nil {
+		return nil, err
+	}
+	return s.Server.UntagVersion(ctx, req)
+}
+
+func (s *policyServer) ListTags(ctx context.Context, req *pb.ListTagsRequest) (*pb.ListTagsResponse, error) {
+	if err := s.check(ctx, permRead, req.Name); err != nil {
+		return nil, err
+	}
+	return s.Server.ListTags(ctx, req)
+}
+
+func (s *policyServer) Delete(ctx context.Context, id *pb.FileID) (*pb.Empty, error) {
+	if err := s.checkVersion(ctx, permDelete, id.Sum); err != nil {
+		return nil, err
+	}
+	return s.Server.Delete(ctx, id)
+}
+
+func (s *policyServer) DeleteBatch(ctx context.Context, req *pb.DeleteBatchRequest) (*pb.DeleteBatchResponse, error) {
+	for _, b := range req.Sums {
+		if err := s.checkVersion(ctx, permDelete, b); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.check(ctx, permDelete, req.Names...); err != nil {
+		return nil, err
+	}
+	return s.Server.DeleteBatch(ctx, req)
+}
+
+func (s *policyServer) DeletePrefix(ctx context.Context, req *pb.DeletePrefixRequest) (*pb.DeletePrefixResponse, error) {
+	if err := s.check(ctx, permDelete, req.Prefix); err != nil {
+		return nil, err
+	}
+	return s.Server.DeletePrefix(ctx, req)
+}
+
+func (s *policyServer) CreateSnapshot(ctx context.Context, req *pb.CreateSnapshotRequest) (*pb.Snapshot, error) {
+	if err := s.check(ctx, permRead, req.Prefix); err != nil {
+		return nil, err
+	}
+	return s.Server.CreateSnapshot(ctx, req)
+}
+
+// ListSnapshots only returns the snapshots of directories the key may read.
+func (s *policyServer) ListSnapshots(ctx context.Context, req *pb.ListSnapshotsRequest) (*pb.ListSnapshotsResponse, error) {
+	resp, err := s.Server.ListSnapshots(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := resp.Snapshots[:0]
+	for _, snap := range resp.Snapshots {
+		if s.check(ctx, permRead, snap.Prefix) == nil {
+			snapshots = append(snapshots, snap)
+		}
+	}
+	resp.Snapshots = snapshots
+	return resp, nil
+}
+
+func (s *policyServer) DiffSnapshot(ctx context.Context, req *pb.DiffSnapshotRequest) (*pb.DiffSnapshotResponse, error) {
+	if err := s.checkSnapshot(ctx, permRead, req.Name); err != nil {
+		return nil, err
+	}
+	if req.To != "" {
+		if err := s.checkSnapshot(ctx, permRead, req.To); err != nil {
+			return nil, err
+		}
+	}
+	return s.Server.DiffSnapshot(ctx, req)
+}
+
+// RollbackSnapshot requires permission to read and write the snapshot's directory,
+// and to delete from it if files are pruned.
+func (s *policyServer) RollbackSnapshot(ctx context.Context, req *pb.RollbackSnapshotRequest) (*pb.RollbackSnapshotResponse, error) {
+	perms := []permission{permRead, permWrite}
+	if req.Prune {
+		perms = append(perms, permDelete)
+	}
+	for _, p := range perms {
+		if err := s.checkSnapshot(ctx, p, req.Name); err != nil {
+			return nil, err
+		}
+	}
+	return s.Server.RollbackSnapshot(ctx, req)
+}
+
+func (s *policyServer) DeleteSnapshot(ctx context.Context, req *pb.DeleteSnapshotRequest) (*pb.Empty, error) {
+	if err := s.checkSnapshot(ctx, permWrite, req.Name); err != nil {
+		return nil, err
+	}
+	return s.Server.DeleteSnapshot(ctx, req)
+}
+
+func (s *policyServer) StartVacuum(ctx context.Context, e *pb.Empty) (*pb.VacuumID, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.Server.StartVacuum(ctx, e)
+}
+
+func (s *policyServer) VacuumStatus(ctx context.Context, id *pb.VacuumID) (*pb.Vacuum, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.Server.VacuumStatus(ctx, id)
+}
+
+func (s *policyServer) ServerStats(ctx context.Context, e *pb.Empty) (*pb.Stats, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.Server.ServerStats(ctx, e)
+}
+
+func (s *policyServer) ChunkReport(ctx context.Context, req *pb.ChunkReportRequest) (*pb.ChunkReportResponse, error) {
+	if err := s.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return s.Server.ChunkReport(ctx, req)
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twitchtv/twirp"
+)
+
+// RateLimitConfig configures the rate limiting of API requests and packfile uploads.
+// Each limit is a token bucket which refills at the given rate and holds up to one
+// second of it, so short bursts are allowed. Requests over a limit are rejected with
+// a ResourceExhausted error, or a 429 Too Many Requests status for packfile uploads,
+// and a Retry-After header, and clients retry them. A zero limit is unlimited. Rate
+// limiting is disabled if every field is zero and the server has no access policy.
+type RateLimitConfig struct {
+	// Requests and Bytes limit the requests per second, and the bytes of request
+	// bodies per second, received from all clients together.
+	Requests float64
+	Bytes    float64
+
+	// ClientRequests and ClientBytes limit the requests and bytes per second received
+	// from each client. A client is identified by its API key if the server has an
+	// access policy and the key is valid, and otherwise by its IP address. A key's
+	// limits may be overridden in the access policy file.
+	ClientRequests float64
+	ClientBytes    float64
+}
+
+func (c RateLimitConfig) enabled() bool {
+	return c.Requests > 0 || c.Bytes > 0 || c.ClientRequests > 0 || c.ClientBytes > 0
+}
+
+// rateClientIdle is the time after which the limits of an idle client are forgotten.
+// Its buckets are full again long before then.
+const rateClientIdle = time.Minute
+
+// tokenBucket holds up to one second of tokens at its rate. A bucket with a zero
+// rate is unlimited.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: burst(rate), last: now}
+}
+
+// burst is the capacity of a bucket with the given rate.
+func burst(rate float64) float64 {
+	return math.Max(rate, 1)
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate <= 0 || !now.After(b.last) {
+		return
+	}
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*b.rate, burst(b.rate))
+	b.last = now
+}
+
+// wait returns the time until the bucket holds n tokens, or zero if it does now.
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take removes n tokens from the bucket. The bucket may be left in debt, which
+// delays later requests until it's repaid.
+func (b *tokenBucket) take(n float64, now time.Time) {
+	if b.rate <= 0 {
+		return
+	}
+	b.refill(now)
+	b.tokens -= n
+}
+
+// rateBuckets are the request and byte buckets of a client, or of the server.
+type rateBuckets struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+	used     time.Time
+}
+
+func newRateBuckets(requests float64, bytes float64, now time.Time) *rateBuckets {
+	return &rateBuckets{
+		requests: newTokenBucket(requests, now),
+		bytes:    newTokenBucket(bytes, now),
+		used:     now,
+	}
+}
+
+// rateLimiter limits the rate of requests, and the rate of the bytes sent in their
+// bodies, received from all clients and from each client.
+type rateLimiter struct {
+	cfg    RateLimitConfig
+	policy *policyWatcher
+
+	mu      sync.Mutex
+	global  *rateBuckets
+	clients map[string]*rateBuckets
+	swept   time.Time
+	// rejected is the number of requests rejected since the server started
+	rejected uint64
+}
+
+func newRateLimiter(cfg RateLimitConfig, policy *policyWatcher) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		cfg:     cfg,
+		policy:  policy,
+		global:  newRateBuckets(cfg.Requests, cfg.Bytes, now),
+		clients: make(map[string]*rateBuckets),
+		swept:   now,
+	}
+}
+
+// requestClient returns the name of the client which sent req, and the grants of its
+// API key. A client is identified by its API key if policy is not nil and the key is
+// valid, in which case its grants are returned, and otherwise by its IP address.
+func requestClient(policy *policyWatcher, req *http.Request) (string, *grants) {
+	if policy != nil {
+		if g, err := policy.authenticate(req); err == nil {
+			return "key " + g.name, g
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip " + host, nil
+}
+
+// client returns the name and limits of the client which sent req.
+func (l *rateLimiter) client(req *http.Request) (name string, requests float64, bytes float64) {
+	name, g := requestClient(l.policy, req)
+	requests, bytes = l.cfg.ClientRequests, l.cfg.ClientBytes
+	if g != nil && g.rateRequests > 0 {
+		requests = g.rateRequests
+	}
+	if g != nil && g.rateBytes > 0 {
+		bytes = g.rateBytes
+	}
+	return name, requests, bytes
+}
+
+// allow admits a request, taking a token for it from the global and client request
+// buckets. The bytes in its body are taken from the byte buckets as they're read, so
+// a request is only rejected for the bytes of earlier requests. Returns the client's
+// byte buckets, and zero, if the request is admitted, and the time to wait before
+// retrying it if it's not.
+func (l *rateLimiter) allow(req *http.Request, now time.Time) ([]*tokenBucket, time.Duration) {
+	name, requests, bytes := l.client(req)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	c, ok := l.clients[name]
+	if !ok || c.requests.rate != requests || c.bytes.rate != bytes {
+		// The client's limits are new, or have changed in the access policy
+		c = newRateBuckets(requests, bytes, now)
+		l.clients[name] = c
+	}
+	c.used = now
+	var wait time.Duration
+	for _, b := range []*tokenBucket{l.global.requests, c.requests} {
+		if d := b.wait(1, now); d > wait {
+			wait = d
+		}
+	}
+	for _, b := range []*tokenBucket{l.global.bytes, c.bytes} {
+		// A byte bucket in debt must be repaid before another request is admitted
+		if d := b.wait(0, now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		l.rejected++
+		return nil, wait
+	}
+	l.global.requests.take(1, now)
+	c.requests.take(1, now)
+	return []*tokenBucket{l.global.bytes, c.bytes}, 0
+}
+
+// sweep forgets the clients which have been idle for rateClientIdle. l.mu must be
+// held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateClientIdle {
+		return
+	}
+	for name, c := range l.clients {
+		if now.Sub(c.used) >= rateClientIdle {
+			delete(l.clients, name)
+		}
+	}
+	l.swept = now
+}
+
+// rateBody takes the bytes read from a request body from the byte buckets.
+type rateBody struct {
+	io.ReadCloser
+	l       *rateLimiter
+	buckets []*tokenBucket
+}
+
+func (b *rateBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		now := time.Now()
+		b.l.mu.Lock()
+		for _, bucket := range b.buckets {
+			bucket.take(float64(n), now)
+		}
+		b.l.mu.Unlock()
+	}
+	return n, err
+}
+
+// admit admits req, or returns the Retry-After header and the error to reject it
+// with.
+func (l *rateLimiter) admit(req *http.Request) (*http.Request, string, error) {
+	buckets, wait := l.allow(req, time.Now())
+	if wait > 0 {
+		secs := int64(math.Ceil(wait.Seconds()))
+		msg := fmt.Sprintf("rate limit exceeded: retry in %s", time.Duration(secs)*time.Second)
+		return nil, strconv.FormatInt(secs, 10), twirp.NewError(twirp.ResourceExhausted, msg)
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &rateBody{req.Body, l, buckets}
+	}
+	return req, "", nil
+}
+
+// twirpHandler returns a handler which rate limits requests to the Twirp API.
+func (l *rateLimiter) twirpHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req, retryAfter, err := l.admit(req)
+		if err != nil {
+			w.Header().Set("Retry-After", retryAfter)
+			twirp.WriteError(w, err)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// handler returns a handler which rate limits requests.
+func (l *rateLimiter) handler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		req, retryAfter, err := l.admit(req)
+		if err != nil {
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, err.(twirp.Error).Msg(), http.StatusTooManyRequests)
+			return
+		}
+		h(w, req)
+	}
+}
+
+func (l *rateLimiter) stats() adminRateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return adminRateLimit{Clients: len(l.clients), Rejected: l.rejected}
+}
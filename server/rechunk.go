@@ -0,0 +1,35 @@
+package server
+
+import "context"
+
+// RechunkResult summarizes a rechunk.
+type RechunkResult struct {
+	// Checked is the number of file versions read, and Rechunked the number whose
+	// chunks were replaced. Skipped is the number deleted while they were rechunked.
+	Checked   int
+	Rechunked int
+	Skipped   int
+	// Chunks is the number of new chunks saved, in Packs packfiles, and Bytes the
+	// size of their data before compression.
+	Chunks uint64
+	Packs  int
+	Bytes  uint64
+}
+
+// Rechunk chunks the data of every file version under a prefix again, with the
+// parameters of the chunker profile which now applies to the version's name, or the
+// server's parameters if none applies, and swaps the new chunks in for the old. A
+// version keeps its ID, metadata, tags and snapshots, but its sum changes. The old
+// chunks are reclaimed by the next vacuum. Every file is rechunked if the prefix is
+// "/".
+func (s *Server) Rechunk(ctx context.Context, prefix string) (RechunkResult, error) {
+	r, err := s.srv.Rechunk(ctx, prefix)
+	return RechunkResult{
+		Checked:   r.Checked,
+		Rechunked: r.Rechunked,
+		Skipped:   r.Skipped,
+		Chunks:    r.Chunks,
+		Packs:     r.Packs,
+		Bytes:     r.Bytes,
+	}, err
+}
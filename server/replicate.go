@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/client"
+	"github.com/jotfs/jotfs/internal/sum"
+)
+
+// defaultReplicaTimeout is the time a replica has to acknowledge a file version if the
+// config does not set one.
+const defaultReplicaTimeout = time.Minute
+
+// ReplicaConfig is another JotFS server which file versions are replicated to.
+type ReplicaConfig struct {
+	// Endpoint is the address of the server, including the scheme, e.g.
+	// https://replica.example.com:6777.
+	Endpoint string
+
+	// Key, if set, is the API key sent to the replica. It must grant write access to
+	// the replicated files if the replica has an access policy.
+	Key string
+}
+
+// replicator copies file versions from the server to the first of its replicas which
+// acknowledges them.
+type replicator struct {
+	local     *client.Client
+	replicas  []*client.Client
+	endpoints []string
+	timeout   time.Duration
+
+	mu           sync.Mutex
+	acknowledged uint64
+	failed       uint64
+	lastError    string
+}
+
+func newReplicator(local *client.Client, replicas []ReplicaConfig, timeout time.Duration) (*replicator, error) {
+	if timeout <= 0 {
+		timeout = defaultReplicaTimeout
+	}
+	r := &replicator{local: local, timeout: timeout}
+	for _, replica := range replicas {
+		c, err := client.New(replica.Endpoint, &client.Options{Key: replica.Key})
+		if err != nil {
+			return nil, fmt.Errorf("replica: %w", err)
+		}
+		r.replicas = append(r.replicas, c)
+		r.endpoints = append(r.endpoints, strings.TrimSuffix(replica.Endpoint, "/"))
+	}
+	return r, nil
+}
+
+// Replicate copies the file version id to the replicas, in order, until one of them
+// acknowledges it by creating the version, or already has a latest version of the file
+// with the same data.
+func (r *replicator) Replicate(ctx context.Context, id sum.Sum) error {
+	var errs []string
+	for i, replica := range r.replicas {
+		rctx, cancel := context.WithTimeout(ctx, r.timeout)
+		_, err := r.local.Replicate(rctx, replica, client.FileID(id))
+		cancel()
+		if err == nil {
+			r.mu.Lock()
+			r.acknowledged++
+			r.mu.Unlock()
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", r.endpoints[i], err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	err := errors.New(strings.Join(errs, "; "))
+	r.mu.Lock()
+	r.failed++
+	r.lastError = err.Error()
+	r.mu.Unlock()
+	return err
+}
+
+func (r *replicator) stats() adminReplication {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return adminReplication{
+		Acknowledged: r.acknowledged,
+		Failed:       r.failed,
+		LastError:    r.lastError,
+	}
+}
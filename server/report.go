@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+	iserver "github.com/jotfs/jotfs/internal/server"
+)
+
+const (
+	defaultReportInterval = 7 * 24 * time.Hour
+
+	// reportCheckInterval is the time between checks for whether a usage report is
+	// due. Reports are sent at most this long after they are due.
+	reportCheckInterval = time.Hour
+
+	reportTimeout = time.Minute
+)
+
+// ReportConfig configures the usage report a Server sends at a regular interval. The
+// report summarizes the data added and deleted since the previous report, and the
+// server's total size and deduplication savings. It is posted as JSON to WebhookURL
+// and emailed to To through SMTPAddr, if either is set.
+type ReportConfig struct {
+	// Interval is the time between reports. Defaults to 7 days. The time of the last
+	// report is kept in the database, so the schedule is unaffected by restarts.
+	Interval time.Duration
+
+	// Tenant names the server in the report, e.g. the tenant it serves.
+	Tenant string
+
+	// WebhookURL, if set, is a URL the report is posted to as JSON.
+	WebhookURL string
+
+	// SMTPAddr, if set, is the host:port of an SMTP server the report is emailed
+	// through. SMTPUsername and SMTPPassword are used for PLAIN authentication, if
+	// set. From and To are the sender and recipient addresses.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	To           []string
+}
+
+func (c ReportConfig) enabled() bool {
+	return c.WebhookURL != "" || c.SMTPAddr != ""
+}
+
+func (c ReportConfig) validate() error {
+	if c.SMTPAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+		return fmt.Errorf("invalid report SMTP address: %w", err)
+	}
+	if c.From == "" || len(c.To) == 0 {
+		return errors.New("report sender and recipients are required to send reports by email")
+	}
+	return nil
+}
+
+// usageReport is the usage of a server over a period.
+type usageReport struct {
+	Tenant          string    `json:"tenant,omitempty"`
+	PeriodStart     time.Time `json:"period_start"`
+	PeriodEnd       time.Time `json:"period_end"`
+	VersionsAdded   uint64    `json:"versions_added"`
+	BytesAdded      uint64    `json:"bytes_added"`
+	VersionsDeleted uint64    `json:"versions_deleted"`
+	BytesDeleted    uint64    `json:"bytes_deleted"`
+	NumFiles        uint64    `json:"num_files"`
+	NumFileVersions uint64    `json:"num_file_versions"`
+	TotalFilesSize  uint64    `json:"total_files_size"`
+	TotalPacksSize  uint64    `json:"total_packs_size"`
+	DedupSavings    uint64    `json:"dedup_savings"`
+	DedupRatio      float64   `json:"dedup_ratio"`
+}
+
+var reportEmail = template.Must(template.New("report").Parse(`JotFS usage report{{if .Tenant}} for {{.Tenant}}{{end}}
+{{.PeriodStart.Format "2006-01-02 15:04 MST"}} to {{.PeriodEnd.Format "2006-01-02 15:04 MST"}}
+
+Added:    {{.VersionsAdded}} file versions, {{.BytesAdded}} bytes
+Deleted:  {{.VersionsDeleted}} file versions, {{.BytesDeleted}} bytes
+
+Files:          {{.NumFiles}} ({{.NumFileVersions}} versions)
+Files size:     {{.TotalFilesSize}} bytes
+Stored size:    {{.TotalPacksSize}} bytes
+Dedup savings:  {{.DedupSavings}} bytes (ratio {{printf "%.2f" .DedupRatio}})
+`))
+
+// sendReportIfDue sends a usage report if the configured interval has passed since the
+// last report. If no report has been sent, the current usage is recorded as the start
+// of the first report's period.
+func (s *Server) sendReportIfDue(ctx context.Context, now time.Time) error {
+	interval := s.cfg.Report.Interval
+	if interval <= 0 {
+		interval = defaultReportInterval
+	}
+	usage, err := s.db.GetUsage()
+	if err != nil {
+		return fmt.Errorf("db GetUsage: %w", err)
+	}
+	last, err := s.db.GetLastUsageReport()
+	if errors.Is(err, db.ErrNotFound) {
+		return s.db.InsertUsageReport(db.UsageReport{CreatedAt: now, Usage: usage})
+	}
+	if err != nil {
+		return fmt.Errorf("db GetLastUsageReport: %w", err)
+	}
+	if now.Sub(last.CreatedAt) < interval {
+		return nil
+	}
+	stats, err := s.db.GetServerStats()
+	if err != nil {
+		return fmt.Errorf("db GetServerStats: %w", err)
+	}
+
+	period := usage.Sub(last.Usage)
+	report := usageReport{
+		Tenant:          s.cfg.Report.Tenant,
+		PeriodStart:     last.CreatedAt.UTC(),
+		PeriodEnd:       now.UTC(),
+		VersionsAdded:   period.VersionsAdded,
+		BytesAdded:      period.BytesAdded,
+		VersionsDeleted: period.VersionsDeleted,
+		BytesDeleted:    period.BytesDeleted,
+		NumFiles:        stats.NumFiles,
+		NumFileVersions: stats.NumFileVersions,
+		TotalFilesSize:  stats.TotalFilesSize,
+		TotalPacksSize:  stats.TotalPacksSize,
+	}
+	if stats.TotalFilesSize > stats.TotalPacksSize {
+		report.DedupSavings = stats.TotalFilesSize - stats.TotalPacksSize
+	}
+	if stats.TotalPacksSize > 0 {
+		report.DedupRatio = float64(stats.TotalFilesSize) / float64(stats.TotalPacksSize)
+	}
+
+	if s.cfg.Report.WebhookURL != "" {
+		if err := s.postWebhook(ctx, report); err != nil {
+			return fmt.Errorf("posting usage report: %w", err)
+		}
+	}
+	if s.cfg.Report.SMTPAddr != "" {
+		if err := s.emailReport(report); err != nil {
+			return fmt.Errorf("emailing usage report: %w", err)
+		}
+	}
+	return s.db.InsertUsageReport(db.UsageReport{CreatedAt: now, Usage: usage})
+}
+
+// postWebhook posts a usage report or quota warning to the report webhook as JSON.
+func (s *Server) postWebhook(ctx context.Context, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.Report.WebhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// emailReport emails a usage report through the report SMTP server.
+func (s *Server) emailReport(report usageReport) error {
+	cfg := s.cfg.Report
+	subject := "JotFS usage report"
+	if report.Tenant != "" {
+		subject += " for " + report.Tenant
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", report.PeriodEnd.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	if err := reportEmail.Execute(&msg, report); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	return smtp.SendMail(cfg.SMTPAddr, auth, cfg.From, cfg.To, msg.Bytes())
+}
+
+// quotaWarning is posted to the report webhook when the usage of a prefix exceeds the
+// soft limit of its quota. The Type field tells it from a usage report.
+type quotaWarning struct {
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Prefix    string    `json:"prefix"`
+	Usage     uint64    `json:"usage"`
+	SoftLimit uint64    `json:"soft_limit"`
+	HardLimit uint64    `json:"hard_limit,omitempty"`
+	GraceEnds time.Time `json:"grace_ends"`
+}
+
+// reportWarner posts quota warnings to the report webhook.
+type reportWarner struct {
+	s *Server
+}
+
+// WarnQuota implements iserver.QuotaWarner. The warning is posted in the background,
+// so the upload which exceeded the limit is not delayed.
+func (w reportWarner) WarnQuota(q iserver.QuotaWarning) {
+	warning := quotaWarning{
+		Type:      "quota_warning",
+		Tenant:    w.s.cfg.Report.Tenant,
+		Prefix:    q.Prefix + "/",
+		Usage:     q.Usage,
+		SoftLimit: q.Soft,
+		HardLimit: q.Hard,
+		GraceEnds: q.GraceEnds.UTC(),
+	}
+	go func() {
+		if err := w.s.postWebhook(context.Background(), warning); err != nil {
+			w.s.logger.Error().Msgf("posting quota warning for %s: %v", warning.Prefix, err)
+		}
+	}()
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+	"github.com/rs/zerolog"
+)
+
+// SchemaMigration is a versioned change to the schema of the metadata database.
+type SchemaMigration struct {
+	Version int
+	Name    string
+	// Applied is true if the migration has been applied to the database. AppliedAt is
+	// the time it was applied, which is zero if it was applied before migrations were
+	// recorded.
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// MigrateDatabaseOpts are the options for MigrateDatabase.
+type MigrateDatabaseOpts struct {
+	// DryRun, if set to true, lists the migrations without applying any.
+	DryRun bool
+}
+
+// MigrateDatabase applies the schema migrations not yet applied to the database at
+// cfg.Database, creating the database if it does not exist, and returns every
+// migration known to this version of the server. Only the database is opened, so the
+// store need not be reachable, but the server should be stopped first.
+func MigrateDatabase(cfg Config, opts *MigrateDatabaseOpts) ([]SchemaMigration, error) {
+	if cfg.Database == "" {
+		return nil, errors.New("database location is required")
+	}
+	if opts == nil {
+		opts = &MigrateDatabaseOpts{}
+	}
+	logger := zerolog.New(ioutil.Discard).Level(zerolog.Disabled)
+	if cfg.Logger != nil {
+		logger = *cfg.Logger
+	}
+	adapter, err := openDB(cfg.Database, databaseOptions(&cfg), logger)
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	defer adapter.Close()
+	if !opts.DryRun {
+		if err := migrateDB(adapter, false, logger); err != nil {
+			return nil, err
+		}
+	}
+	migrations, err := adapter.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("reading schema migrations: %w", err)
+	}
+	res := make([]SchemaMigration, len(migrations))
+	for i, m := range migrations {
+		res[i] = SchemaMigration(m)
+	}
+	return res, nil
+}
+
+// migrateDB applies the pending schema migrations to the database or, if manual is
+// true, returns an error if any are pending.
+func migrateDB(adapter *db.Adapter, manual bool, logger zerolog.Logger) error {
+	if !manual {
+		applied, err := adapter.Migrate()
+		if err != nil {
+			return fmt.Errorf("upgrading database schema: %w", err)
+		}
+		for _, m := range applied {
+			logger.Info().Msgf("Applied schema migration %03d_%s", m.Version, m.Name)
+		}
+		return nil
+	}
+	migrations, err := adapter.Migrations()
+	if err != nil {
+		return fmt.Errorf("reading schema migrations: %w", err)
+	}
+	pending := 0
+	for _, m := range migrations {
+		if !m.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d schema migrations are pending. Apply them with MigrateDatabase or jotfs admin migrate-db", pending)
+	}
+	return nil
+}
@@ -0,0 +1,1208 @@
+// Package server allows a JotFS server to be embedded in another Go program.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	ichunker "github.com/jotfs/jotfs/internal/chunker"
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	iserver "github.com/jotfs/jotfs/internal/server"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/store/file"
+	"github.com/jotfs/jotfs/internal/store/mem"
+	"github.com/jotfs/jotfs/internal/store/s3"
+	"github.com/jotfs/jotfs/internal/sum"
+	"github.com/jotfs/jotfs/internal/tracing"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+	"github.com/rs/zerolog"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	kiB = 1024
+	miB = 1024 * kiB
+
+	defaultMaxPackfileSize = 128 * miB
+	defaultPackfileSize    = 64 * miB
+	defaultChunkCacheSize  = 1024 * miB
+	maxInlineSize          = 64 * kiB
+	defaultUploadQueueSize = 16
+	defaultUploadWorkers   = 4
+	defaultAvgChunkSize    = 512 * kiB
+	defaultNormalization   = 2
+	defaultShutdownTimeout = 5 * time.Minute
+	forcedShutdownTimeout  = 30 * time.Second
+
+	defaultDatabaseBusyTimeout = 5 * time.Second
+	defaultDatabaseSynchronous = "FULL"
+	defaultDatabaseReaders     = 16
+
+	minStoreRetryDelay = 500 * time.Millisecond
+	maxStoreRetryDelay = 30 * time.Second
+
+	defaultBreakerCooldown = 30 * time.Second
+
+	// readyTimeout is the maximum time a readiness check waits for the database and
+	// store to respond.
+	readyTimeout = 5 * time.Second
+)
+
+// Config stores the configuration for a Server.
+type Config struct {
+	// Database is the location of the SQLite metadata database. The database is
+	// created if it does not exist. It is opened in write-ahead log mode, so changes
+	// are first written to a log file alongside it, named with a "-wal" suffix. The
+	// packfiles being saved to or deleted from the store are recorded in a journal
+	// alongside it, named with a ".packs" suffix, so a packfile left in only one of the
+	// database and the store when the server stops is removed when it restarts.
+	Database string
+
+	// CheckpointSize is the size, in bytes, the database's write-ahead log may reach
+	// while changes are being made before the server copies it into the database.
+	// These checkpoints don't wait for the writer, so the log may grow beyond this size
+	// under sustained writes. Defaults to 64 MiB.
+	CheckpointSize int64
+
+	// CheckpointIdle is the time after the last change to the database at which the
+	// server copies the whole write-ahead log into the database and truncates it.
+	// Defaults to 5 seconds.
+	CheckpointIdle time.Duration
+
+	// DatabaseBusyTimeout is how long a database connection waits for a lock held by
+	// another connection, such as a checkpoint or an admin command, before the request
+	// fails. Defaults to 5 seconds.
+	DatabaseBusyTimeout time.Duration
+
+	// DatabaseSynchronous is SQLite's synchronous setting for the database: OFF, NORMAL,
+	// FULL or EXTRA. With NORMAL, commits aren't synced until the log is checkpointed,
+	// which makes writes faster, but the last changes may be lost on power failure.
+	// Defaults to FULL.
+	DatabaseSynchronous string
+
+	// DatabaseReaders is the largest number of connections used to read from the
+	// database at once. Changes are made through a single connection. Defaults to 16.
+	DatabaseReaders int
+
+	// ManualMigrations, if set to true, stops the server from applying schema
+	// migrations to an existing database when it starts. The server fails to start
+	// while migrations are pending, until they are applied with MigrateDatabase.
+	ManualMigrations bool
+
+	// BackupInterval, if set, is the time between backups of the database to the
+	// store. See BackupDatabase.
+	BackupInterval time.Duration
+
+	// BackupRetain is the number of database backups kept in the store. The oldest
+	// are deleted after each backup. Every backup is kept if zero.
+	BackupRetain int
+
+	// Jobs sets the schedules of the background jobs, keyed by job name: "vacuum",
+	// "backup", "compact", "scrub", "oplog" and "report". A schedule is a five-field
+	// cron expression in UTC, e.g. "30 3 * * *", "@every <duration>", e.g.
+	// "@every 6h", one of @hourly, @daily, @weekly or @monthly, or "off" to disable the
+	// job. By default the vacuum, backup and operation log jobs run every
+	// VacuumInterval, BackupInterval and OpLogInterval, the usage report is checked
+	// hourly, and the compaction and scrub don't run.
+	Jobs map[string]string
+
+	// MaxJobs is the largest number of background jobs run at once. A job due while
+	// the limit is reached waits for another to finish. Defaults to 2.
+	MaxJobs int
+
+	// Store is the configuration for the S3-compatible object store.
+	Store StoreConfig
+
+	// VersioningEnabled, if set to true, turns on file versioning.
+	VersioningEnabled bool
+
+	// AvgChunkSize is the average chunk size, in bytes, used when the server is first
+	// started on an empty bucket. The chunk size is fixed after that. Defaults to
+	// 512 KiB.
+	AvgChunkSize uint
+
+	// ChunkAlgorithm is the chunking algorithm, "fastcdc", "rabin" or "fixed", used
+	// when the server is first started on an empty bucket, and fixed after that like
+	// the chunk size. Fixed size chunks suit buckets of compressed or encrypted data,
+	// which content-defined chunking doesn't deduplicate. Defaults to "fastcdc".
+	ChunkAlgorithm string
+
+	// ChunkHash is the hash function, "blake3" or "sha256", chunks are identified by
+	// when the server is first started on an empty bucket, and fixed after that like
+	// the chunking algorithm. Defaults to "blake3".
+	ChunkHash string
+
+	// PackfileSize is the size, in bytes, at which clients upload a packfile of new
+	// chunks and start another. Larger packfiles need fewer store requests, which suits
+	// stores with a high latency per request such as S3, but each upload buffers a
+	// packfile in the client's memory and a failed upload is repeated in full. Defaults
+	// to 64 MiB.
+	PackfileSize uint64
+
+	// MaxPackfileSize is the largest packfile, in bytes, the server accepts. It must be
+	// at least PackfileSize. Clients which predate PackfileSize always upload packfiles
+	// of up to 64 MiB. Defaults to 128 MiB, or twice PackfileSize if that's larger.
+	MaxPackfileSize uint64
+
+	// PackfileFlushInterval, if set, is the time after which clients upload a packfile
+	// which is not yet full, so data written slowly, e.g. to a FileWriter, is not
+	// buffered by the client indefinitely. By default, packfiles are uploaded when full
+	// or when the upload ends.
+	PackfileFlushInterval time.Duration
+
+	// InlineSize, if set, is the size in bytes of the largest file clients store
+	// inline in the database instead of uploading it in a packfile, which saves a
+	// store request per small file and keeps small files out of packfiles. It may
+	// be at most 64 KiB. Disabled by default.
+	InlineSize uint64
+
+	// DownloadTimeout is the maximum time allotted to a client to download a file.
+	// Defaults to 2 hours.
+	DownloadTimeout time.Duration
+
+	// VacuumInterval is the time between automatic vacuums. Set to zero to disable
+	// the automatic vacuum.
+	VacuumInterval time.Duration
+
+	// EventsToken, if set, enables a webhook at /store/events which accepts bucket
+	// notifications authenticated with this bearer token.
+	EventsToken string
+
+	// EventsQueue, if set, is the URL of an SQS queue to read bucket notifications
+	// from.
+	EventsQueue string
+
+	// AdminToken, if set, enables the admin API at /admin/. Requests must be
+	// authenticated with this bearer token.
+	AdminToken string
+
+	// PolicyFile, if set, is a TOML file granting API keys permission to read, write
+	// and delete files by name prefix. API requests and packfile uploads must then
+	// send a key from the file as a bearer token. The file is reloaded when it
+	// changes. The admin API, share links and store webhooks are not affected.
+	PolicyFile string
+
+	// CORSOrigins are the origins which browser clients may call the API's Connect
+	// protocol endpoints from. "*" allows any origin.
+	CORSOrigins []string
+
+	// MaxNameLength is the maximum length of a file name in bytes. Defaults to, and
+	// may not exceed, 1024.
+	MaxNameLength int
+
+	// NamePattern, if set, is a regular expression which new file names must match,
+	// e.g. `^[A-Za-z0-9/._-]+$`. Names always begin with "/".
+	NamePattern string
+
+	// NormalizeNames, if true, converts file names to Unicode normalization form C
+	// when files are created and looked up. Files created with a name in another form
+	// before the option was enabled may no longer be found by name.
+	NormalizeNames bool
+
+	// Conflicts map name prefixes to the strategy for resolving concurrent uploads of
+	// the same file under them: "replace" (the default) makes the last version created
+	// the latest, "reject" fails the last upload, and "branch" creates the last version
+	// under a new name, e.g. "/a/notes.conflict-1.txt". The longest matching prefix
+	// applies. Only uploads which send the version of the file they began from, such as
+	// those made by a sync, can be detected as concurrent.
+	Conflicts map[string]string
+
+	// DefaultMetadata are rules setting default metadata, such as an owner,
+	// classification or content type, on the new file versions under a name prefix, so
+	// files get consistent metadata without the cooperation of clients. Keys set by the
+	// upload take precedence, followed by the rules with the longest prefixes.
+	DefaultMetadata []MetadataRule
+
+	// SyncReplication are the name prefixes of critical files, e.g. "/finance". An
+	// upload of a file under one only succeeds once the new file version's data and
+	// metadata have been acknowledged by one of Replicas, which are tried in order,
+	// trading latency for no data loss if this server or its store is lost. If no
+	// replica acknowledges the version, the upload fails with an Unavailable error,
+	// although the version has been created on this server. Clients retry the request,
+	// which replicates the same version again. Copies, reverts and renames are not
+	// replicated. Requires Replicas.
+	SyncReplication []string
+
+	// Replicas are other JotFS servers which file versions under the SyncReplication
+	// prefixes are copied to. A replica should use the same chunker parameters, so
+	// only the chunks it does not already store are sent.
+	Replicas []ReplicaConfig
+
+	// Quotas limit the total size of the file versions, counting every version, under
+	// name prefixes, e.g. the directory of each tenant. An upload which would take the
+	// usage of a prefix over its hard limit fails with a ResourceExhausted error. Over
+	// the soft limit, uploads succeed for the quota's grace period, 7 days by default,
+	// after which they fail until the usage falls back under it. A warning is logged,
+	// and posted to the Report webhook if set, when the soft limit is first exceeded.
+	Quotas []Quota
+
+	// ReplicaTimeout is the maximum time each replica has to acknowledge a file
+	// version. Defaults to 1 minute.
+	ReplicaTimeout time.Duration
+
+	// Shedding configures the rejection of lower priority requests when the server is
+	// under pressure. Disabled by default.
+	Shedding ShedConfig
+
+	// RateLimit configures the rate limiting of API requests and packfile uploads,
+	// for all clients together and for each client. Disabled by default.
+	RateLimit RateLimitConfig
+
+	// Throttle configures the bandwidth limits of each client. Disabled by default.
+	Throttle ThrottleConfig
+
+	// StoreRetry configures the retries of store requests which fail with a transient
+	// error, such as an S3 503 Slow Down response. Requests are not retried by default.
+	StoreRetry RetryConfig
+
+	// StoreBreaker configures the circuit breaker for the store. Disabled by default.
+	StoreBreaker BreakerConfig
+
+	// StoreWaitTimeout is the maximum time New waits for the object store to become
+	// reachable. Connection attempts are retried with exponential backoff. By default,
+	// New fails if the store cannot be reached on the first attempt.
+	StoreWaitTimeout time.Duration
+
+	// IDGenerator, if set, generates the identifiers for new packfiles and file
+	// versions. ULIDs are used by default.
+	IDGenerator IDGenerator
+
+	// Logger is used for all server logging. Logging is disabled if nil.
+	Logger *zerolog.Logger
+
+	// Addr is the address Run listens on, e.g. ":6777".
+	Addr string
+
+	// TLSCert and TLSKey are the certificate and key files used by Run to serve
+	// HTTPS. HTTP is used if they are not set.
+	TLSCert string
+	TLSKey  string
+
+	// TLSConfig is an optional TLS configuration used by Run.
+	TLSConfig *tls.Config
+
+	// ShutdownTimeout is the maximum time Run waits for in-flight requests, packfile
+	// uploads and vacuums to complete after ctx is cancelled. Connections still open
+	// after the timeout are closed. Defaults to 5 minutes.
+	ShutdownTimeout time.Duration
+
+	// CacheTTL is the time the responses of List, Head, GetFileInfo, Search and
+	// ServerStats requests are cached for, so clients repeatedly polling the same
+	// listing don't each query the database. A cached response is removed as soon as a
+	// file it includes is changed by the server. Caching is disabled if zero.
+	CacheTTL time.Duration
+
+	// OpLogInterval, if set, turns on the operation log. Every change to the metadata
+	// database is recorded in the log, which is uploaded to the store's oplog/ prefix
+	// at this interval. A standby server replays the log to keep a copy of the
+	// database up to date.
+	OpLogInterval time.Duration
+
+	// DisableChunkFilter, if true, turns off the in-memory filter over the hashes of
+	// the stored chunks. The filter lets the server answer that a chunk does not exist
+	// without querying the database, which cuts the database load of large uploads of
+	// mostly new data. It uses about 10 bits per chunk.
+	DisableChunkFilter bool
+
+	// Checksums are the whole-file checksum algorithms computed for each new file
+	// version and returned by GetFileInfo, for systems which require them. Supported
+	// algorithms are "md5" and "sha1". A SHA-256 checksum is always computed. The
+	// server reads the file's chunks from the store once to compute every checksum.
+	Checksums []string
+
+	// IndexCacheDir, if set, is a local directory where the server keeps a compact,
+	// memory-mapped index of the blocks in each packfile. Downloads locate each chunk
+	// in the index instead of the database, which is faster for large files. Indexes
+	// are built when first needed, and the directory may be cleared while the server
+	// is stopped.
+	IndexCacheDir string
+
+	// ChunkCacheDir, if set, is a local directory where the server caches the chunks it
+	// reads from the store, so share links and other downloads served through the
+	// server don't fetch frequently read files from the store each time. Clients
+	// downloading with presigned URLs read from the store directly, and use their own
+	// cache. The least recently used chunks are removed once the cache holds
+	// ChunkCacheSize bytes, which defaults to 1 GiB.
+	ChunkCacheDir  string
+	ChunkCacheSize int64
+
+	// LocationCacheSize, if set, is the number of chunk locations the server keeps in
+	// memory, so downloads and checksums of frequently read files locate their chunks
+	// without querying the database or the index in IndexCacheDir. Each location takes
+	// about 250 bytes. The least recently used locations are removed when it's full.
+	LocationCacheSize int
+
+	// ReadAhead is the number of chunk ranges, of up to 8 MiB each, the server reads
+	// from the store concurrently ahead of the one it's writing when it streams a
+	// file, e.g. for a share link. Disabled if zero.
+	ReadAhead int
+
+	// UploadQueueDir, if set, is a local directory where the server queues packfile
+	// uploads. An upload completes once the packfile is written to the directory, and
+	// the server saves it to the store in the background, so clients don't wait on
+	// the store's latency. Downloads of files with chunks in a queued packfile wait
+	// for it to be saved. Uploads left in the directory when the server stops are
+	// resumed when it starts, so the directory must be on persistent storage. Up to
+	// UploadQueueSize packfiles are queued, 16 by default, and uploads wait for a free
+	// slot when the queue is full. UploadQueueWorkers packfiles, 4 by default, are
+	// saved to the store at once.
+	UploadQueueDir     string
+	UploadQueueSize    int
+	UploadQueueWorkers int
+
+	// MinFreeSpace, if set, is the free space in bytes the server keeps on the file
+	// systems of ChunkCacheDir and UploadQueueDir, so the caches don't fill a disk
+	// shared with other services. When the free space falls below it, the chunk cache
+	// removes its least recently used chunks, and the upload queue rejects new
+	// uploads with a 503 Service Unavailable status and a Retry-After header until
+	// queued packfiles are saved to the store. The free space is reported by the
+	// admin stats endpoint.
+	MinFreeSpace int64
+
+	// MaxUploads, if set, is the maximum number of packfile uploads in progress at
+	// once, and MaxUploadBytes the maximum total size of the packfiles being uploaded.
+	// An upload over either limit is rejected at once with a 503 Service Unavailable
+	// status and a Retry-After header, which clients retry, so the memory and
+	// bandwidth used by uploads stay bounded under a load spike. A packfile larger
+	// than MaxUploadBytes is accepted when no other upload is in progress. Unlimited by
+	// default.
+	MaxUploads     int
+	MaxUploadBytes uint64
+
+	// Standby, if true, runs the server as a warm standby for a primary server with
+	// the same store. The standby's database must start as a copy of the primary's
+	// database made after the primary enabled its operation log. The standby fetches
+	// and replays the primary's log every OpLogInterval, serves read-only requests,
+	// and runs no vacuums. To take over from the primary, stop the primary and
+	// restart the standby with Standby unset.
+	Standby bool
+
+	// Report configures a usage report sent at a regular interval. No reports are
+	// sent unless a webhook URL or SMTP server is set. A standby server sends no
+	// reports.
+	Report ReportConfig
+
+	// Build identifies the server's build. It is served at /version. If Version is
+	// not set, the version of the jotfs module the binary was built with is used.
+	Build BuildInfo
+}
+
+// BuildInfo identifies the build of a server binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	BuildDate string `json:"build_date,omitempty"`
+	CommitID  string `json:"commit_id,omitempty"`
+}
+
+// MetadataRule sets default metadata on the new file versions under a name prefix.
+type MetadataRule struct {
+	// Prefix contains the file of the same name and the files in the directory it
+	// names, e.g. "/finance".
+	Prefix string
+	// Metadata are the default keys and values.
+	Metadata map[string]string
+	// ContentTypes map file extensions, e.g. ".csv", to the default value of the
+	// "content-type" key.
+	ContentTypes map[string]string
+}
+
+// Quota limits the total size of the file versions under a name prefix. Soft and Hard
+// are in bytes, and either may be zero for no limit.
+type Quota struct {
+	// Prefix contains the file of the same name and the files in the directory it
+	// names, e.g. "/tenants/acme".
+	Prefix string
+	Soft   uint64
+	// Grace is the time uploads succeed for once the usage exceeds the soft limit.
+	Grace time.Duration
+	Hard  uint64
+}
+
+// RetryConfig configures the retries of failed store requests. Retries are made
+// after an exponentially increasing, random delay. Reads, copies and deletes are
+// retried, as are uploads of objects held in memory, such as pack indexes. Packfiles
+// are streamed to the store as they are received, so are not retried, but the S3
+// store retries each part of a multipart upload.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of times a request is made. Requests are not
+	// retried if it's less than 2.
+	MaxAttempts int
+
+	// MinDelay is the maximum delay before the first retry, which doubles with each
+	// retry up to MaxDelay. Default to 100ms and 5s.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// Budget limits retries to this fraction of store requests, e.g. 0.1 for one
+	// retry per ten requests, so a failing store is not overwhelmed by retries.
+	// Retries are not limited if it's zero.
+	Budget float64
+}
+
+// BreakerConfig configures the circuit breaker for the store. After Threshold
+// consecutive store requests fail, requests which need the store fail immediately with
+// a "store unavailable" error instead of waiting on the store, and the server reports
+// it's not ready. Once the breaker has been open for Cooldown, a single trial request
+// is sent to the store, and the breaker closes if it succeeds. Requests for objects
+// which do not exist succeed.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failures which open the breaker. The
+	// breaker is disabled if it's zero.
+	Threshold int
+
+	// Cooldown is the time the breaker stays open before a trial request. Defaults to
+	// 30s.
+	Cooldown time.Duration
+}
+
+// IDGenerator creates unique identifiers for packfiles and file versions.
+type IDGenerator interface {
+	// New returns a new identifier for an object created at time t.
+	New(t time.Time) (string, error)
+}
+
+// StoreConfig stores the configuration for the object store. If AccessKey
+// is not set, credentials are obtained from the default AWS credential chain, e.g.
+// AWS_* environment variables, shared credential files, or an ECS task or EC2
+// instance role.
+type StoreConfig struct {
+	// URL, if set, selects the store driver by its scheme, e.g. "s3://bucket" or
+	// "file:///var/lib/jotfs". The bucket is taken from the URL, and the other fields
+	// are ignored.
+	URL string
+
+	Bucket     string
+	Endpoint   string
+	Region     string
+	AccessKey  string
+	SecretKey  string
+	PathStyle  bool
+	DisableSSL bool
+
+	// RoleARN, if set, is an IAM role to assume when accessing the store. ExternalID
+	// is passed to STS when assuming the role, if set.
+	RoleARN    string
+	ExternalID string
+
+	// PartSize is the size, in bytes, of the parts objects are uploaded to S3 in, and
+	// UploadConcurrency the number of parts uploaded at once. Failed uploads are
+	// aborted. Default to 8 MiB and 4.
+	PartSize          int64
+	UploadConcurrency int
+}
+
+// Server is a JotFS server which may be mounted on any http.ServeMux or listener.
+type Server struct {
+	cfg     Config
+	db      *db.Adapter
+	store   store.Store
+	srv     *iserver.Server
+	policy  *policyWatcher
+	ckpt    *checkpointer
+	shedder *shedder
+	// limiter is nil if rate limiting is disabled
+	limiter *rateLimiter
+	// throttle is nil if bandwidth throttling is disabled
+	throttle *throttler
+	breaker  *store.Breaker
+	// replicator is nil if synchronous replication is disabled
+	replicator *replicator
+	jobs       *scheduler
+	handler    http.Handler
+	logger     zerolog.Logger
+
+	// local serves the server's own clients over in-memory connections. It's started
+	// by the first call to localClient, and is nil if the server closed first.
+	localOnce      sync.Once
+	local          *http.Server
+	localTransport *http.Transport
+}
+
+// eventReceiver is implemented by stores which can read bucket notifications from a
+// queue.
+type eventReceiver interface {
+	ReceiveEvents(ctx context.Context, queueURL string, handle func(body []byte) error) error
+}
+
+// New creates a new Server by opening the metadata database and connecting to the
+// object store. The server's background tasks are not started until Start is called.
+func New(cfg Config) (*Server, error) {
+	if cfg.Store.Bucket == "" && cfg.Store.URL == "" {
+		return nil, errors.New("store bucket is required")
+	}
+	logger := zerolog.New(ioutil.Discard).Level(zerolog.Disabled)
+	if cfg.Logger != nil {
+		logger = *cfg.Logger
+	}
+
+	adapter, err := openDB(cfg.Database, databaseOptions(&cfg), logger)
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	if err := migrateDB(adapter, cfg.ManualMigrations, logger); err != nil {
+		adapter.Close()
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	s, err := openStore(&cfg.Store)
+	if err != nil {
+		adapter.Close()
+		return nil, fmt.Errorf("connecting to store: %w", err)
+	}
+	srv, err := newServer(cfg, adapter, s)
+	if err != nil {
+		adapter.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// NewInMemory creates a new Server with an in-memory metadata database and object
+// store, so nothing is persisted once the server is closed. cfg.Database and the store
+// configuration, other than the bucket name, are ignored. It's intended for tests: see
+// the jotfstest package.
+func NewInMemory(cfg Config) (*Server, error) {
+	if cfg.Store.Bucket == "" {
+		cfg.Store.Bucket = "jotfs"
+	}
+	cfg.Database = ""
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		return nil, fmt.Errorf("database: %w", err)
+	}
+	s, err := mem.New()
+	if err != nil {
+		adapter.Close()
+		return nil, fmt.Errorf("store: %w", err)
+	}
+	srv, err := newServer(cfg, adapter, s)
+	if err != nil {
+		adapter.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// packJournalSuffix names the journal of packfile uploads and deletions alongside the
+// database.
+const packJournalSuffix = ".packs"
+
+// newServer creates a Server from an open database and store.
+func newServer(cfg Config, adapter *db.Adapter, s store.Store) (*Server, error) {
+	logger := zerolog.New(ioutil.Discard).Level(zerolog.Disabled)
+	if cfg.Logger != nil {
+		logger = *cfg.Logger
+	}
+	if cfg.IDGenerator != nil {
+		adapter.SetIDGenerator(cfg.IDGenerator)
+	}
+	naming := iserver.NamingRules{MaxLength: cfg.MaxNameLength, NFC: cfg.NormalizeNames}
+	if cfg.NamePattern != "" {
+		var err error
+		if naming.Pattern, err = regexp.Compile(cfg.NamePattern); err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %w", err)
+		}
+	}
+	if err := iserver.CheckChecksumAlgorithms(cfg.Checksums); err != nil {
+		return nil, err
+	}
+	conflicts, err := iserver.NewConflictRules(cfg.Conflicts)
+	if err != nil {
+		return nil, err
+	}
+	metadataRules := make([]iserver.MetadataRule, len(cfg.DefaultMetadata))
+	for i, rule := range cfg.DefaultMetadata {
+		metadataRules[i] = iserver.MetadataRule(rule)
+	}
+	defaultMetadata, err := iserver.NewMetadataRules(metadataRules)
+	if err != nil {
+		return nil, err
+	}
+	quotas := make([]iserver.Quota, len(cfg.Quotas))
+	for i, q := range cfg.Quotas {
+		quotas[i] = iserver.Quota(q)
+	}
+	quotaRules, err := iserver.NewQuotaRules(quotas)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.IndexCacheDir != "" {
+		if err := os.MkdirAll(cfg.IndexCacheDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating index cache directory: %w", err)
+		}
+	}
+	var policy *policyWatcher
+	if cfg.PolicyFile != "" {
+		var err error
+		if policy, err = newPolicyWatcher(cfg.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+	// The defaults are kept in cfg so the admin config reports the sizes in use
+	if cfg.PackfileSize == 0 {
+		cfg.PackfileSize = defaultPackfileSize
+	}
+	if cfg.ChunkCacheDir != "" && cfg.ChunkCacheSize <= 0 {
+		cfg.ChunkCacheSize = defaultChunkCacheSize
+	}
+	if cfg.UploadQueueDir != "" {
+		if cfg.UploadQueueSize <= 0 {
+			cfg.UploadQueueSize = defaultUploadQueueSize
+		}
+		if cfg.UploadQueueWorkers <= 0 {
+			cfg.UploadQueueWorkers = defaultUploadWorkers
+		}
+	}
+	if cfg.MaxPackfileSize == 0 {
+		cfg.MaxPackfileSize = defaultMaxPackfileSize
+		if 2*cfg.PackfileSize > cfg.MaxPackfileSize {
+			cfg.MaxPackfileSize = 2 * cfg.PackfileSize
+		}
+	}
+	if cfg.PackfileSize > cfg.MaxPackfileSize {
+		return nil, fmt.Errorf("packfile size %d exceeds the maximum packfile size %d", cfg.PackfileSize, cfg.MaxPackfileSize)
+	}
+	if cfg.InlineSize > maxInlineSize {
+		return nil, fmt.Errorf("inline size %d exceeds the maximum %d", cfg.InlineSize, maxInlineSize)
+	}
+	if len(cfg.SyncReplication) > 0 && len(cfg.Replicas) == 0 {
+		return nil, errors.New("synchronous replication requires a replica")
+	}
+	if cfg.Standby && cfg.OpLogInterval <= 0 {
+		return nil, errors.New("standby server requires an operation log interval")
+	}
+	if err := cfg.Report.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.EventsQueue != "" {
+		if _, ok := s.(eventReceiver); !ok {
+			return nil, errors.New("store does not support reading notifications from a queue")
+		}
+	}
+
+	algorithm, err := ichunker.ParseAlgorithm(cfg.ChunkAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := sum.ParseAlgorithm(cfg.ChunkHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the chunking parameters from the store or create the object if it doesn't exist
+	ctx := context.Background()
+	params, err := waitForChunkerParams(ctx, s, cfg.Store.Bucket, cfg.StoreWaitTimeout, logger)
+	if err != nil {
+		return nil, fmt.Errorf("getting chunker params: %w", err)
+	}
+	if params != nil {
+		if _, err := ichunker.ParseAlgorithm(params.Algorithm); err != nil {
+			return nil, fmt.Errorf("chunker params: %w", err)
+		}
+		if _, err := sum.ParseAlgorithm(params.ChunkHash); err != nil {
+			return nil, fmt.Errorf("chunker params: %w", err)
+		}
+	}
+	if params == nil {
+		avg := cfg.AvgChunkSize
+		if avg == 0 {
+			avg = defaultAvgChunkSize
+		}
+		params = &iserver.ChunkerParams{
+			MinChunkSize:  avg / 4,
+			AvgChunkSize:  avg,
+			MaxChunkSize:  avg * 4,
+			Normalization: defaultNormalization,
+			Algorithm:     string(algorithm),
+			ChunkHash:     string(hash),
+		}
+		if err = saveChunkerParams(ctx, s, cfg.Store.Bucket, params); err != nil {
+			return nil, fmt.Errorf("saving chunker params: %w", err)
+		}
+	}
+
+	// Only the internal server uses the traced store. The Server keeps the original so
+	// its optional interfaces may be checked. Retries wrap the original too, so its
+	// errors are classified, and are traced as part of a single request.
+	istore := s
+	if cfg.StoreRetry.MaxAttempts > 1 {
+		istore = store.Retry(istore, store.RetryPolicy(cfg.StoreRetry))
+	}
+	var breaker *store.Breaker
+	if cfg.StoreBreaker.Threshold > 0 {
+		cooldown := cfg.StoreBreaker.Cooldown
+		if cooldown <= 0 {
+			cooldown = defaultBreakerCooldown
+		}
+		breaker = store.NewBreaker(cfg.StoreBreaker.Threshold, cooldown, func(h store.BreakerHealth) {
+			if h.State == store.BreakerClosed {
+				logger.Info().Msg("store circuit breaker closed")
+			} else {
+				logger.Warn().Msgf("store circuit breaker %s after %d consecutive failures: %v", h.State, h.ConsecutiveFailures, h.LastError)
+			}
+		})
+		istore = breaker.Wrap(istore)
+	}
+	istore = tracing.Store(istore)
+	var shed *shedder
+	if cfg.Shedding.enabled() {
+		shed = newShedder(cfg.Shedding)
+		istore = shed.timedStore(istore)
+	}
+	var packJournal string
+	if cfg.Database != "" {
+		packJournal = cfg.Database + packJournalSuffix
+	}
+	srv := iserver.New(adapter, istore, iserver.Config{
+		Bucket:                cfg.Store.Bucket,
+		VersioningEnabled:     cfg.VersioningEnabled,
+		MaxChunkSize:          uint64(params.MaxChunkSize),
+		MaxPackfileSize:       cfg.MaxPackfileSize,
+		PackfileSize:          cfg.PackfileSize,
+		PackfileFlushInterval: cfg.PackfileFlushInterval,
+		InlineSize:            cfg.InlineSize,
+		DownloadTimeout:       cfg.DownloadTimeout,
+		Params:                *params,
+		Naming:                naming,
+		CacheTTL:              cfg.CacheTTL,
+		Checksums:             cfg.Checksums,
+		IndexCacheDir:         cfg.IndexCacheDir,
+		ChunkCacheDir:         cfg.ChunkCacheDir,
+		ChunkCacheSize:        cfg.ChunkCacheSize,
+		LocationCacheSize:     cfg.LocationCacheSize,
+		ReadAhead:             cfg.ReadAhead,
+		UploadQueueDir:        cfg.UploadQueueDir,
+		UploadQueueSize:       cfg.UploadQueueSize,
+		UploadQueueWorkers:    cfg.UploadQueueWorkers,
+		MinFreeSpace:          cfg.MinFreeSpace,
+		MaxUploads:            cfg.MaxUploads,
+		MaxUploadBytes:        cfg.MaxUploadBytes,
+		PackJournal:           packJournal,
+		Conflicts:             conflicts,
+		DefaultMetadata:       defaultMetadata,
+		SyncReplication:       iserver.NewSyncPrefixes(cfg.SyncReplication),
+		Quotas:                quotaRules,
+	})
+	srv.SetLogger(logger)
+	if cfg.OpLogInterval > 0 {
+		adapter.EnableOpLog()
+	}
+	if !cfg.DisableChunkFilter {
+		adapter.EnableChunkFilter()
+	}
+	if !cfg.Standby {
+		n, err := srv.RecoverPackJournal()
+		if err != nil {
+			return nil, fmt.Errorf("recovering pack journal: %w", err)
+		}
+		if n > 0 {
+			logger.Warn().Msgf("Resolved %d packfile uploads or deletions interrupted when the server last stopped", n)
+		}
+		if err := srv.StartUploadQueue(); err != nil {
+			srv.Close()
+			return nil, fmt.Errorf("starting upload queue: %w", err)
+		}
+	}
+
+	hooks := loggingServerHooks(logger)
+	if cfg.Standby {
+		hooks = twirp.ChainHooks(standbyServerHooks(), hooks)
+	}
+	var impl pb.JotFS = srv
+	if policy != nil {
+		impl = &policyServer{Server: srv, db: adapter}
+	}
+	twirpHandler := pb.NewJotFSServer(impl, hooks)
+	var api http.Handler = twirpHandler
+	if policy != nil {
+		api = policy.handler(twirpHandler)
+	}
+	if shed != nil {
+		api = shed.twirpHandler(api, twirpHandler.PathPrefix())
+	}
+	var throttle *throttler
+	if cfg.Throttle.enabled() || policy != nil {
+		// Requests are paced once they have a request slot
+		throttle = newThrottler(cfg.Throttle, policy)
+		api = throttle.handler(api)
+	}
+	var limiter *rateLimiter
+	if cfg.RateLimit.enabled() || policy != nil {
+		// Requests over the limit are rejected before they queue for a request slot
+		limiter = newRateLimiter(cfg.RateLimit, policy)
+		api = limiter.twirpHandler(api)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(twirpHandler.PathPrefix(), api)
+	connectPrefix := strings.TrimPrefix(twirpHandler.PathPrefix(), "/twirp")
+	mux.Handle(connectPrefix, connectHandler(api, connectPrefix, twirpHandler.PathPrefix(), cfg.CORSOrigins))
+	upload := func(h http.HandlerFunc) http.HandlerFunc {
+		if cfg.Standby {
+			h = standbyHandler
+		} else if policy != nil {
+			h = policy.uploadHandler(h)
+		}
+		if shed != nil {
+			h = shed.handler(priorityUpload, h)
+		}
+		if throttle != nil {
+			h = throttle.handler(h).ServeHTTP
+		}
+		if limiter != nil {
+			h = limiter.handler(h)
+		}
+		return h
+	}
+	mux.HandleFunc("/packfile", logHandler(logger, upload(postHandler(srv.PackfileUploadHandler)), "PackfileUpload"))
+	var checkWrite func(context.Context, string) error
+	if policy != nil {
+		checkWrite = checkWritePermission
+	}
+	mux.HandleFunc("/files", logHandler(logger, upload(postHandler(srv.FilesUploadHandler(checkWrite))), "FilesUpload"))
+	mux.HandleFunc(iserver.FilePrefix, logHandler(logger, upload(putHandler(srv.FileUploadHandler(checkWrite))), "FileUpload"))
+	if h, ok := s.(http.Handler); ok {
+		// The store serves its own download URLs
+		objects := http.StripPrefix(file.URLPrefix, h)
+		if throttle != nil {
+			objects = throttle.handler(objects)
+		}
+		mux.Handle(file.URLPrefix+"/", objects)
+	}
+	if cfg.EventsToken != "" && !cfg.Standby {
+		mux.HandleFunc("/store/events", logHandler(logger, postHandler(srv.StoreEventsHandler(cfg.EventsToken)), "StoreEvents"))
+	}
+
+	server := &Server{
+		cfg:      cfg,
+		db:       adapter,
+		store:    s,
+		srv:      srv,
+		policy:   policy,
+		ckpt:     newCheckpointer(adapter, cfg.CheckpointSize, cfg.CheckpointIdle),
+		shedder:  shed,
+		limiter:  limiter,
+		throttle: throttle,
+		breaker:  breaker,
+		logger:   logger,
+	}
+
+	// Health checks are not traced or logged because they are polled frequently
+	root := http.NewServeMux()
+	root.Handle("/", tracing.Handler("jotfs", mux))
+	root.HandleFunc("/healthz", getHandler(healthzHandler))
+	root.HandleFunc("/readyz", getHandler(readyzHandler(server)))
+	root.HandleFunc("/version", getHandler(versionHandler(buildInfo(cfg.Build))))
+	share := http.StripPrefix(sharePrefix, shareHandler(server)).ServeHTTP
+	if throttle != nil {
+		share = throttle.handler(http.HandlerFunc(share)).ServeHTTP
+	}
+	if shed != nil {
+		share = shed.handler(priorityInteractive, share)
+	}
+	root.HandleFunc(sharePrefix+"/", logHandler(logger, getHandler(share), "Share"))
+	if cfg.AdminToken != "" {
+		admin := http.StripPrefix(adminPrefix, adminHandler(server, cfg.AdminToken)).ServeHTTP
+		if shed != nil {
+			admin = shed.handler(priorityBackground, admin)
+		}
+		root.HandleFunc(adminPrefix+"/", logHandler(logger, admin, "Admin"))
+	}
+	server.handler = root
+
+	if server.jobs, err = server.newJobs(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Report.WebhookURL != "" {
+		srv.SetQuotaWarner(reportWarner{server})
+	}
+
+	if len(cfg.SyncReplication) > 0 {
+		local, err := server.localClient()
+		if err != nil {
+			return nil, err
+		}
+		server.replicator, err = newReplicator(local, cfg.Replicas, cfg.ReplicaTimeout)
+		if err != nil {
+			return nil, err
+		}
+		srv.SetReplicator(server.replicator)
+	}
+
+	return server, nil
+}
+
+// NewHandler creates a new Server and starts its background tasks. The tasks run until
+// ctx is cancelled.
+func NewHandler(ctx context.Context, cfg Config) (*Server, error) {
+	srv, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	srv.Start(ctx)
+	return srv, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.handler.ServeHTTP(w, req)
+}
+
+// Start launches the server's background tasks: the scheduled jobs, such as the
+// automatic vacuum, and, if configured, the bucket notification consumer and the
+// access policy reloader. The database log is checkpointed in the background. A
+// standby server only runs the operation log replayer, the checkpoints and the policy
+// reloader. The tasks run until ctx is cancelled.
+func (s *Server) Start(ctx context.Context) {
+	if s.policy != nil {
+		go s.every(ctx, policyReloadInterval, func() {
+			reloaded, err := s.policy.reload()
+			if err != nil {
+				s.logger.Error().Msgf("reloading access policy: %v", err)
+			}
+			if reloaded {
+				s.logger.Info().Msgf("Reloaded access policy %s", s.cfg.PolicyFile)
+			}
+		})
+	}
+
+	go s.every(ctx, checkpointInterval, func() {
+		res, ok, err := s.ckpt.checkpointIfDue(time.Now())
+		if err != nil {
+			s.logger.Error().Msgf("checkpointing database: %v", err)
+		} else if ok {
+			s.logger.Debug().Msgf("Checkpointed %d of %d database log frames in %s", res.Checkpointed, res.LogFrames, res.Duration)
+		}
+	})
+
+	if s.cfg.Standby {
+		go s.every(ctx, s.cfg.OpLogInterval, func() {
+			n, err := s.srv.ReplayOpLog(ctx)
+			if err != nil {
+				s.logger.Error().Msgf("replaying operation log: %v", err)
+			}
+			if n > 0 {
+				s.logger.Info().Msgf("Replayed %d operations", n)
+			}
+		})
+		return
+	}
+
+	s.jobs.start(ctx)
+
+	if s.cfg.EventsQueue != "" {
+		receiver := s.store.(eventReceiver)
+		go func() {
+			err := receiver.ReceiveEvents(ctx, s.cfg.EventsQueue, func(body []byte) error {
+				events, err := iserver.ParseStoreEvents(body)
+				if err != nil {
+					s.logger.Warn().Msgf("invalid store notification: %v", err)
+					return nil
+				}
+				err = s.srv.HandleStoreEvents(ctx, events)
+				if err != nil {
+					s.logger.Error().Msgf("handling store notification: %v", err)
+				}
+				return err
+			})
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error().Msgf("store notifications: %v", err)
+			}
+		}()
+	}
+}
+
+// every calls f every interval until ctx is cancelled.
+func (s *Server) every(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
+
+// Shutdown stops the server from accepting new packfile uploads and vacuums, and waits
+// for any in progress to complete, or until ctx is done. Shutdown should be called
+// after the server has stopped receiving requests, and before Close.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// Close closes the server's metadata database and pack journal. The server should not
+// be used after Close is called.
+func (s *Server) Close() error {
+	s.localOnce.Do(func() {})
+	if s.local != nil {
+		s.local.Close()
+		s.localTransport.CloseIdleConnections()
+	}
+	err := s.srv.Close()
+	if dbErr := s.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// Run creates a new Server and serves it on cfg.Addr until ctx is cancelled. In-flight
+// requests are allowed to complete, for up to cfg.ShutdownTimeout, before Run returns.
+func Run(ctx context.Context, cfg Config) error {
+	srv, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	bgCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv.Start(bgCtx)
+
+	httpServer := &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   srv,
+		TLSConfig: cfg.TLSConfig,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" || cfg.TLSConfig != nil {
+			errc <- httpServer.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			errc <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Allow in-flight requests to complete before closing the database. Requests which
+	// are still running after the timeout have their contexts cancelled, and are given
+	// a short time to clean up any objects they have written to the store.
+	cancel()
+	timeout := cfg.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	srv.logger.Info().Msgf("Shutting down. Waiting up to %s for requests to complete", timeout)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	drainErr := httpServer.Shutdown(shutdownCtx)
+	if drainErr != nil {
+		srv.logger.Warn().Msgf("shutdown timeout exceeded, closing open connections: %v", drainErr)
+		httpServer.Close()
+		var cancelForced context.CancelFunc
+		shutdownCtx, cancelForced = context.WithTimeout(context.Background(), forcedShutdownTimeout)
+		defer cancelForced()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.logger.Error().Msgf("background tasks still running at shutdown: %v", err)
+	}
+	if drainErr != nil {
+		return fmt.Errorf("server shutdown: %w", drainErr)
+	}
+	return nil
+}
+
+// openStore connects to the object store given by cfg. If the store is opened from
+// cfg.URL, cfg.Bucket is set to the bucket given in the URL.
+func openStore(cfg *StoreConfig) (store.Store, error) {
+	if cfg.URL != "" {
+		s, bucket, err := store.Open(cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Bucket = bucket
+		return s, nil
+	}
+	return s3.New(s3.Config{
+		Region:     cfg.Region,
+		Endpoint:   cfg.Endpoint,
+		AccessKey:  cfg.AccessKey,
+		SecretKey:  cfg.SecretKey,
+		PathStyle:  cfg.PathStyle,
+		DisableSSL: cfg.DisableSSL,
+		RoleARN:    cfg.RoleARN,
+		ExternalID: cfg.ExternalID,
+
+		PartSize:          cfg.PartSize,
+		UploadConcurrency: cfg.UploadConcurrency,
+	})
+}
+
+// databaseOptions sets the defaults of the database settings in cfg and returns the
+// options to open the database with.
+func databaseOptions(cfg *Config) db.DiskOptions {
+	if cfg.DatabaseBusyTimeout <= 0 {
+		cfg.DatabaseBusyTimeout = defaultDatabaseBusyTimeout
+	}
+	if cfg.DatabaseSynchronous == "" {
+		cfg.DatabaseSynchronous = defaultDatabaseSynchronous
+	}
+	if cfg.DatabaseReaders <= 0 {
+		cfg.DatabaseReaders = defaultDatabaseReaders
+	}
+	return db.DiskOptions{
+		BusyTimeout: cfg.DatabaseBusyTimeout,
+		Synchronous: cfg.DatabaseSynchronous,
+		MaxReaders:  cfg.DatabaseReaders,
+	}
+}
+
+// openDB opens the SQLite database at filename, creating it if it does not exist. A
+// compacted copy made by CompactDatabase is swapped in first. Schema migrations are
+// not applied to an existing database.
+func openDB(filename string, opts db.DiskOptions, logger zerolog.Logger) (*db.Adapter, error) {
+	if err := swapCompactedDB(filename, logger); err != nil {
+		return nil, fmt.Errorf("replacing database with compacted copy: %w", err)
+	}
+	exists, err := fileExists(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening file %s: %w", filename, err)
+	}
+	if exists {
+		logger.Info().Msgf("Using existing database %s", filename)
+	} else {
+		logger.Info().Msgf("Creating new database %s", filename)
+	}
+	adapter, err := db.OpenDisk(filename, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := adapter.InitSchema(); err != nil {
+			adapter.Close()
+			return nil, fmt.Errorf("internal error: creating database schema: %w", err)
+		}
+	}
+	return adapter, nil
+}
+
+func fileExists(f string) (bool, error) {
+	info, err := os.Stat(f)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return false, errors.New("is a directory but a file is required")
+	}
+	return true, nil
+}
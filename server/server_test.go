@@ -0,0 +1,1882 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jotfs/jotfs/client"
+	"github.com/jotfs/jotfs/internal/db"
+	pb "github.com/jotfs/jotfs/internal/protos"
+	iserver "github.com/jotfs/jotfs/internal/server"
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/jotfs/jotfs/internal/store/file"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+)
+
+func TestServer(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	srv, err := newServer(Config{
+		Store:        StoreConfig{Bucket: "test"},
+		AvgChunkSize: 64 * kiB,
+		EventsToken:  "secret",
+	}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.Start(ctx)
+
+	// Mount the server on a sub-path of another mux
+	mux := http.NewServeMux()
+	mux.Handle("/jotfs/", http.StripPrefix("/jotfs", srv))
+	api := httptest.NewServer(mux)
+	defer api.Close()
+
+	// Chunker params are saved to the store on first start
+	_, ok := s.data[chunkParamsKey]
+	assert.True(t, ok)
+
+	c, err := client.New(api.URL+"/jotfs", nil)
+	assert.NoError(t, err)
+	_, err = c.Upload(ctx, bytes.NewReader([]byte("hello")), "/hello.txt")
+	assert.NoError(t, err)
+	info, err := c.Latest(ctx, "/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), info.Size)
+
+	// Store events webhook is enabled
+	req, err := http.NewRequest("POST", api.URL+"/jotfs/store/events", strings.NewReader(""))
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Packfile uploads must be POST requests
+	resp, err = http.Get(api.URL + "/jotfs/packfile")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestFileStoreURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv, err := New(Config{
+		Database: filepath.Join(dir, "jotfs.db"),
+		Store:    StoreConfig{URL: "file://" + filepath.Join(dir, "store")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	api := httptest.NewServer(srv)
+	defer api.Close()
+
+	// Download URLs are served by the server itself
+	ctx := context.Background()
+	c, err := client.New(api.URL, nil)
+	assert.NoError(t, err)
+	_, err = c.Upload(ctx, strings.NewReader("hello"), "/hello.txt")
+	assert.NoError(t, err)
+	info, err := c.Latest(ctx, "/hello.txt")
+	assert.NoError(t, err)
+	buf := new(bytes.Buffer)
+	assert.NoError(t, c.Download(ctx, info.FileID, buf))
+	assert.Equal(t, "hello", buf.String())
+}
+
+func TestNewRequiresBucket(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err)
+}
+
+func TestEventsQueueUnsupported(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, EventsQueue: "queue"}, adapter, s)
+	assert.Error(t, err)
+}
+
+func TestPackfileSize(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, PackfileSize: 32 * miB, MaxPackfileSize: 16 * miB}, adapter, s)
+	assert.Error(t, err)
+
+	srv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, s)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(defaultPackfileSize), srv.cfg.PackfileSize)
+	assert.Equal(t, uint64(defaultMaxPackfileSize), srv.cfg.MaxPackfileSize)
+
+	// The maximum defaults to twice a packfile size larger than 64 MiB
+	srv, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, PackfileSize: 256 * miB, PackfileFlushInterval: time.Minute}, adapter, s)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(512*miB), srv.cfg.MaxPackfileSize)
+
+	params, err := srv.srv.GetChunkerParams(context.Background(), &pb.Empty{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(256*miB), params.PackfileSize)
+	assert.Equal(t, int64(time.Minute), params.PackfileFlushInterval)
+}
+
+func TestStoreWaitTimeout(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &flakyStore{memStore: memStore{data: make(map[string][]byte)}, failures: 2}
+
+	// Fails without a wait timeout
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, s)
+	assert.Error(t, err)
+
+	// Succeeds once the store becomes reachable
+	s.failures = 1
+	srv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}, StoreWaitTimeout: time.Minute}, adapter, s)
+	assert.NoError(t, err)
+	assert.NotNil(t, srv)
+	assert.Equal(t, 0, s.failures)
+}
+
+func TestConnect(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	srv, err := newServer(Config{
+		Store:       StoreConfig{Bucket: "test"},
+		CORSOrigins: []string{"https://app.example.com"},
+	}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	do := func(method string, path string, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Connect-Protocol-Version", "1")
+		req.Header.Set("Origin", "https://app.example.com")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	// JSON request
+	w := do("POST", "/server.JotFS/GetChunkerParams", "application/json", []byte("{}"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	var params map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &params))
+	assert.Contains(t, params, "avg_chunk_size")
+
+	// Protobuf request
+	b, err := proto.Marshal(&pb.FileID{Sum: make([]byte, 32)})
+	assert.NoError(t, err)
+	w = do("POST", "/server.JotFS/Download", "application/proto", b)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var cerr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &cerr))
+	assert.Equal(t, "not_found", cerr.Code)
+	assert.NotEmpty(t, cerr.Message)
+
+	w = do("POST", "/server.JotFS/ServerStats", "application/proto", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/proto", w.Header().Get("Content-Type"))
+	var stats pb.Stats
+	assert.NoError(t, proto.Unmarshal(w.Body.Bytes(), &stats))
+
+	// Unknown methods
+	w = do("POST", "/server.JotFS/Missing", "application/json", []byte("{}"))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	// Unsupported content type
+	w = do("POST", "/server.JotFS/ServerStats", "text/plain", nil)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	// Preflight request
+	w = do("OPTIONS", "/server.JotFS/ServerStats", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Connect-Protocol-Version")
+
+	// Origins which are not allowed do not receive CORS headers
+	req := httptest.NewRequest("POST", "/server.JotFS/ServerStats", nil)
+	req.Header.Set("Content-Type", "application/proto")
+	req.Header.Set("Origin", "https://other.example.com")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestAdmin(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	srv, err := newServer(Config{
+		Store:          StoreConfig{Bucket: "test", SecretKey: "store-secret"},
+		AdminToken:     "admin-secret",
+		EventsToken:    "events-secret",
+		VacuumInterval: time.Hour,
+	}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	do := func(method string, path string, token string, v interface{}) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if v != nil && w.Code < 300 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		assert.NotContains(t, w.Body.String(), "secret")
+		return w.Code
+	}
+
+	// Requests must be authenticated
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/admin/stats", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/admin/stats", "wrong", nil))
+
+	var stats adminStats
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/stats", "admin-secret", &stats))
+	assert.Zero(t, stats.NumFiles)
+	assert.Zero(t, stats.DedupRatio)
+
+	// The dashboard does not require a token
+	req := httptest.NewRequest("GET", "/admin/", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.NotEmpty(t, w.Header().Get("Content-Security-Policy"))
+
+	// Upload the same file to two namespaces
+	api := httptest.NewServer(srv)
+	defer api.Close()
+	c, err := client.New(api.URL, nil)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("hello"), 1000)
+	_, err = c.Upload(ctx, bytes.NewReader(data), "/a/hello.txt")
+	assert.NoError(t, err)
+	_, err = c.Upload(ctx, bytes.NewReader(data), "/b/hello.txt")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/stats", "admin-secret", &stats))
+	assert.Equal(t, uint64(2), stats.NumFiles)
+	assert.Greater(t, stats.DedupRatio, 1.0)
+
+	var namespaces []adminNamespace
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/namespaces", "admin-secret", &namespaces))
+	assert.Equal(t, []adminNamespace{
+		{Namespace: "/a/", NumFiles: 1, NumFileVersions: 1, TotalFilesSize: uint64(len(data))},
+		{Namespace: "/b/", NumFiles: 1, NumFileVersions: 1, TotalFilesSize: uint64(len(data))},
+	}, namespaces)
+
+	var uploads []adminUpload
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/uploads", "admin-secret", &uploads))
+	if assert.Len(t, uploads, 2) {
+		assert.Equal(t, "/b/hello.txt", uploads[0].Name)
+		assert.Equal(t, "/a/hello.txt", uploads[1].Name)
+		assert.NotEmpty(t, uploads[0].VersionID)
+	}
+
+	var cfg adminConfig
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/config", "admin-secret", &cfg))
+	assert.Equal(t, "test", cfg.Store.Bucket)
+	assert.True(t, cfg.EventsWebhook)
+	assert.Equal(t, float64(3600), cfg.VacuumIntervalSeconds)
+	assert.Equal(t, uint64(defaultAvgChunkSize), cfg.Chunker.AvgChunkSize)
+
+	// The dry run reports nothing to reclaim
+	var report adminVacuumReport
+	assert.Equal(t, http.StatusOK, do("POST", "/admin/vacuum?dry_run=true", "admin-secret", &report))
+	assert.Equal(t, adminVacuumReport{Packs: []adminReclaimPack{}}, report)
+
+	// Start a vacuum and wait for it to complete
+	assert.Equal(t, http.StatusMethodNotAllowed, do("GET", "/admin/vacuum", "admin-secret", nil))
+	var started struct{ ID string }
+	assert.Equal(t, http.StatusAccepted, do("POST", "/admin/vacuum", "admin-secret", &started))
+	assert.NotEmpty(t, started.ID)
+	var vacuum adminVacuum
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, http.StatusOK, do("GET", "/admin/vacuum/"+started.ID, "admin-secret", &vacuum))
+		if vacuum.Status != db.VacuumRunning.String() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, db.VacuumOK.String(), vacuum.Status)
+	assert.NotNil(t, vacuum.CompletedAt)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/admin/vacuum/missing", "admin-secret", nil))
+
+	var scrub adminScrub
+	assert.Equal(t, http.StatusOK, do("POST", "/admin/scrub", "admin-secret", &scrub))
+	assert.Equal(t, adminScrub{Checked: 1}, scrub)
+
+	var jobs adminJobs
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/jobs", "admin-secret", &jobs))
+	assert.False(t, jobs.ShuttingDown)
+	if assert.Len(t, jobs.Vacuums, 1) {
+		assert.Equal(t, started.ID, jobs.Vacuums[0].ID)
+	}
+	assert.NotNil(t, jobs.Jobs)
+	assert.Equal(t, http.StatusNotFound, do("POST", "/admin/jobs/missing", "admin-secret", nil))
+
+	// Admin API is disabled without a token
+	srv, err = newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, s)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/admin/stats", "", nil))
+}
+
+func TestShare(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(Config{
+		Store:      StoreConfig{Bucket: "test"},
+		AdminToken: "admin-secret",
+	}, adapter, &memStore{data: make(map[string][]byte)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	api := httptest.NewServer(srv)
+	defer api.Close()
+
+	c, err := client.New(api.URL, nil)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	for name, data := range map[string]string{
+		"/photos/a.txt":       "old",
+		"/photos/sub/b c.txt": "bbb",
+		"/photos2/c.txt":      "ccc",
+		"/private.txt":        "ddd",
+	} {
+		_, err = c.Upload(ctx, strings.NewReader(data), name)
+		assert.NoError(t, err)
+	}
+	_, err = c.Upload(ctx, strings.NewReader("new"), "/photos/a.txt")
+	assert.NoError(t, err)
+
+	do := func(method string, path string, body string, header ...string) *http.Response {
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	read := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	// Create a share link
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/shares", `{"prefix": "/photos", "expires_in_seconds": -1}`).StatusCode)
+	resp := do("POST", "/admin/shares", `{"prefix": "photos/", "expires_in_seconds": 3600}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var share adminShare
+	assert.NoError(t, json.Unmarshal([]byte(read(resp)), &share))
+	assert.Equal(t, "/photos", share.Prefix)
+	assert.Equal(t, "/share/"+share.Token+"/", share.Path)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), share.ExpiresAt, time.Minute)
+
+	// The token is not listed
+	var shares []adminShare
+	resp = do("GET", "/admin/shares", "")
+	assert.NoError(t, json.Unmarshal([]byte(read(resp)), &shares))
+	if assert.Len(t, shares, 1) {
+		assert.Equal(t, share.ID, shares[0].ID)
+		assert.Empty(t, shares[0].Token)
+	}
+
+	// The listing contains the latest version of files under the prefix only
+	var listing shareListing
+	resp = do("GET", share.Path, "", "Accept", "application/json")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.Unmarshal([]byte(read(resp)), &listing))
+	assert.Equal(t, "/photos", listing.Prefix)
+	assert.False(t, listing.Truncated)
+	if assert.Len(t, listing.Files, 2) {
+		assert.Equal(t, "a.txt", listing.Files[0].Name)
+		assert.Equal(t, uint64(3), listing.Files[0].Size)
+		assert.Equal(t, "sub/b c.txt", listing.Files[1].Name)
+		assert.Equal(t, "./sub/b%20c.txt", listing.Files[1].URL)
+	}
+
+	resp = do("GET", share.Path, "")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, read(resp), `<a href="./sub/b%20c.txt">sub/b c.txt</a>`)
+
+	// Download files relative to the share link
+	resp = do("GET", share.Path+"a.txt", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "new", read(resp))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	resp = do("GET", share.Path+"sub/b%20c.txt", "")
+	assert.Equal(t, "bbb", read(resp))
+
+	// Files outside the prefix are not accessible
+	for _, path := range []string{"../private.txt", "%2e%2e/private.txt", "../photos2/c.txt", "missing.txt"} {
+		assert.Equal(t, http.StatusNotFound, do("GET", share.Path+path, "").StatusCode, path)
+	}
+
+	// Revoked and expired links are not found
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/admin/shares/"+share.ID, "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/shares/"+share.ID, "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, do("GET", share.Path, "").StatusCode)
+
+	token, hash, err := newShareToken()
+	assert.NoError(t, err)
+	now := time.Now()
+	_, err = adapter.InsertShare(hash, "/photos", now.Add(-time.Hour), now.Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/share/"+token+"/a.txt", "").StatusCode)
+}
+
+func TestAdminChunkerProfiles(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(Config{
+		Store:      StoreConfig{Bucket: "test"},
+		AdminToken: "admin-secret",
+	}, adapter, &memStore{data: make(map[string][]byte)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	do := func(method string, path string, body string, v interface{}) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		if v != nil && w.Code < 300 {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	// Unset parameters default from the server's
+	var profile iserver.ChunkerProfile
+	assert.Equal(t, http.StatusOK, do("POST", "/admin/chunker-profiles", `{"prefix": "vm/", "avg_chunk_size": 8388608}`, &profile))
+	assert.Equal(t, iserver.ChunkerProfile{
+		Prefix: "/vm",
+		ChunkerParams: iserver.ChunkerParams{
+			MinChunkSize:  2 << 20,
+			AvgChunkSize:  8 << 20,
+			MaxChunkSize:  32 << 20,
+			Normalization: defaultNormalization,
+			Algorithm:     "fastcdc",
+		},
+	}, profile)
+	assert.Equal(t, http.StatusOK, do("POST", "/admin/chunker-profiles", `{"prefix": "/src", "avg_chunk_size": 65536, "normalization": 0, "algorithm": "rabin"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/chunker-profiles", `{"prefix": "/src", "avg_chunk_size": 65536, "algorithm": "cdc"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/admin/chunker-profiles", `{"prefix": "/src"}`, nil))
+
+	var profiles []iserver.ChunkerProfile
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/chunker-profiles", "", &profiles))
+	if assert.Len(t, profiles, 2) {
+		assert.Equal(t, "/src", profiles[0].Prefix)
+		assert.Equal(t, uint(0), profiles[0].Normalization)
+		assert.Equal(t, profile, profiles[1])
+	}
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/admin/chunker-profiles/vm", "", nil))
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/admin/chunker-profiles/vm", "", nil))
+	assert.Equal(t, http.StatusOK, do("GET", "/admin/chunker-profiles", "", &profiles))
+	assert.Len(t, profiles, 1)
+
+	var rechunk adminRechunk
+	assert.Equal(t, http.StatusOK, do("POST", "/admin/rechunk?prefix=/src", "", &rechunk))
+	assert.Equal(t, adminRechunk{}, rechunk)
+	assert.Equal(t, http.StatusMethodNotAllowed, do("GET", "/admin/rechunk", "", nil))
+}
+
+func TestRouter(t *testing.T) {
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+	tenants := map[string]http.Handler{"tenanta": handler("a"), "tenantb": handler("b")}
+	get := func(r *Router, host string, state *tls.ConnectionState) (int, string) {
+		req := httptest.NewRequest("GET", "https://"+host+"/twirp/server.JotFS/List", nil)
+		req.TLS = state
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	sni := NewRouter(TenantFromSNI("jot.example.com"), tenants)
+	code, body := get(sni, "tenantA.jot.example.com", &tls.ConnectionState{ServerName: "tenanta.jot.example.com"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "a", body)
+	code, body = get(sni, "tenantb.jot.example.com:443", &tls.ConnectionState{ServerName: "tenantb.jot.example.com"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "b", body)
+
+	// The Host header must match the TLS server name
+	code, _ = get(sni, "tenantb.jot.example.com", &tls.ConnectionState{ServerName: "tenanta.jot.example.com"})
+	assert.Equal(t, http.StatusMisdirectedRequest, code)
+	code, _ = get(sni, "tenantc.jot.example.com", &tls.ConnectionState{ServerName: "tenantc.jot.example.com"})
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get(sni, "a.tenanta.jot.example.com", &tls.ConnectionState{ServerName: "a.tenanta.jot.example.com"})
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = get(sni, "tenanta.jot.example.com", nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	certs := NewRouter(TenantFromCertificate(func(cert *x509.Certificate) string {
+		return cert.Subject.CommonName
+	}), tenants)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "tenantb"}}
+	code, body = get(certs, "jot.example.com", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "b", body)
+	code, _ = get(certs, "jot.example.com", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestHealth(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &flakyStore{memStore: memStore{data: make(map[string][]byte)}}
+	srv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+
+	// Not ready if the store is unreachable
+	s.failures = 1
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+
+	// Not ready once shutting down, but still alive
+	assert.NoError(t, srv.Shutdown(context.Background()))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+
+	// Not ready if the database is closed
+	srv, err = newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, s)
+	assert.NoError(t, err)
+	assert.NoError(t, adapter.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("POST", "/healthz", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestSyncReplication(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, SyncReplication: []string{"/critical"}}, adapter, &memStore{data: make(map[string][]byte)})
+	assert.Error(t, err)
+
+	replicaDB, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicaSrv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}}, replicaDB, &memStore{data: make(map[string][]byte)})
+	assert.NoError(t, err)
+	replicaAPI := httptest.NewServer(replicaSrv)
+	defer replicaAPI.Close()
+	replica, err := client.New(replicaAPI.URL, nil)
+	assert.NoError(t, err)
+
+	// The primary serves downloads from its file store, which are read to copy chunks
+	// the replica does not have
+	fs, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(Config{
+		Store:           StoreConfig{Bucket: "test"},
+		SyncReplication: []string{"/critical"},
+		Replicas:        []ReplicaConfig{{Endpoint: replicaAPI.URL}},
+	}, adapter, fs)
+	assert.NoError(t, err)
+	api := httptest.NewServer(srv)
+	defer api.Close()
+	c, err := client.New(api.URL, nil)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("critical"), 1000)
+	id, err := c.Upload(ctx, bytes.NewReader(data), "/critical/a.txt")
+	assert.NoError(t, err)
+	latest, err := replica.Stat(ctx, "/critical/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), latest.Size)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, sum[:], latest.Checksums["sha256"])
+
+	// Replicating the version again is acknowledged without creating another version
+	replicated, err := c.Replicate(ctx, replica, id)
+	assert.NoError(t, err)
+	assert.Equal(t, latest.FileID, replicated)
+
+	// Files outside the sync prefixes are not replicated
+	_, err = c.Upload(ctx, bytes.NewReader(data), "/other/a.txt")
+	assert.NoError(t, err)
+	_, err = replica.Latest(ctx, "/other/a.txt")
+	assert.Equal(t, client.ErrNotFound, err)
+
+	// The version is created, but the request fails, if no replica acknowledges it
+	replicaAPI.Close()
+	_, err = srv.srv.CreateFile(ctx, &pb.File{Name: "/critical/b.txt"})
+	var terr twirp.Error
+	assert.True(t, errors.As(err, &terr))
+	assert.Equal(t, twirp.Unavailable, terr.Code())
+	_, err = c.Latest(ctx, "/critical/b.txt")
+	assert.NoError(t, err)
+
+	stats := srv.replicator.stats()
+	assert.Equal(t, uint64(1), stats.Acknowledged)
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.NotEmpty(t, stats.LastError)
+}
+
+func TestStoreBreaker(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &flakyStore{memStore: memStore{data: make(map[string][]byte)}}
+	srv, err := newServer(Config{
+		Store:        StoreConfig{Bucket: "test"},
+		StoreBreaker: BreakerConfig{Threshold: 2, Cooldown: 200 * time.Millisecond},
+		AdminToken:   "secret",
+	}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+	stats := func() adminStoreHealth {
+		code, body := get("/admin/stats")
+		assert.Equal(t, http.StatusOK, code)
+		var res adminStats
+		assert.NoError(t, json.Unmarshal([]byte(body), &res))
+		if res.Store == nil {
+			t.Fatal("store health missing from stats")
+		}
+		return *res.Store
+	}
+	assert.Equal(t, "closed", stats().Breaker)
+
+	// The breaker opens after 2 consecutive failures
+	s.failures = 2
+	for i := 0; i < 2; i++ {
+		code, _ := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	}
+	h := stats()
+	assert.Equal(t, "open", h.Breaker)
+	assert.Equal(t, 2, h.ConsecutiveFailures)
+	assert.Equal(t, uint64(1), h.Trips)
+	assert.NotNil(t, h.OpenedAt)
+	assert.Equal(t, "connection refused", h.LastError)
+
+	// Requests needing the store fail fast while it's open, even though the store has
+	// recovered
+	code, body := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "store unavailable")
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/packfile", strings.NewReader("x"))
+	req.Header.Set("x-jotfs-checksum", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, 2, stats().ConsecutiveFailures)
+
+	// Closes once a trial request succeeds after the cooldown
+	time.Sleep(250 * time.Millisecond)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	h = stats()
+	assert.Equal(t, "closed", h.Breaker)
+	assert.Nil(t, h.OpenedAt)
+}
+
+func TestVersion(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	build := BuildInfo{Version: "v1.2.3", BuildDate: "2020-06-01", CommitID: "abc123"}
+	srv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}, Build: build}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	get := func() BuildInfo {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var res BuildInfo
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		return res
+	}
+	assert.Equal(t, build, get())
+
+	// Without a version, the module version is used, which is unknown in tests
+	srv, err = newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	assert.Equal(t, BuildInfo{Version: "devel"}, get())
+}
+
+func TestStandby(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &memStore{data: make(map[string][]byte)}
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, Standby: true}, adapter, s)
+	assert.Error(t, err)
+
+	srv, err := newServer(Config{
+		Store:         StoreConfig{Bucket: "test"},
+		AdminToken:    "admin-secret",
+		OpLogInterval: time.Minute,
+		Standby:       true,
+	}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	post := func(path string, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Read-only requests are served, and changes are rejected
+	assert.Equal(t, http.StatusOK, post("/twirp/server.JotFS/ServerStats", `{}`))
+	assert.Equal(t, http.StatusServiceUnavailable, post("/twirp/server.JotFS/CreateFile", `{"name": "/a.txt"}`))
+	assert.Equal(t, http.StatusServiceUnavailable, post("/packfile", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, post("/files", ""))
+	req := httptest.NewRequest("PUT", "/file/a.txt", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, post("/admin/vacuum", ""))
+}
+
+func TestPolicy(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jotfs-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hash := func(key string) string {
+		h := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(h[:])
+	}
+	policy := fmt.Sprintf(`
+[roles.uploader]
+read = ["/uploads"]
+write = ["/uploads"]
+
+[roles.reader]
+read = ["/"]
+
+[[keys]]
+name = "ci"
+sha256 = "%s"
+roles = ["uploader"]
+
+[[keys]]
+name = "auditor"
+sha256 = "%s"
+roles = ["reader"]
+`, hash("ci-key"), hash("auditor-key"))
+	filename := filepath.Join(dir, "policy.toml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(policy), 0644))
+
+	s := &memStore{data: make(map[string][]byte)}
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, PolicyFile: filepath.Join(dir, "missing.toml")}, adapter, s)
+	assert.Error(t, err)
+	srv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}, AvgChunkSize: 64 * kiB, PolicyFile: filename}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	api := httptest.NewServer(srv)
+	defer api.Close()
+	ctx := context.Background()
+
+	newClient := func(key string) *client.Client {
+		c, err := client.New(api.URL, &client.Options{Key: key})
+		assert.NoError(t, err)
+		return c
+	}
+	ci := newClient("ci-key")
+	auditor := newClient("auditor-key")
+	code := func(err error) twirp.ErrorCode {
+		var terr twirp.Error
+		if errors.As(err, &terr) {
+			return terr.Code()
+		}
+		return twirp.NoError
+	}
+
+	// Requests require a valid key
+	_, err = newClient("").Latest(ctx, "/uploads/a.txt")
+	assert.Equal(t, twirp.Unauthenticated, code(err))
+	_, err = newClient("wrong").Latest(ctx, "/uploads/a.txt")
+	assert.Equal(t, twirp.Unauthenticated, code(err))
+
+	// Keys may only act on their prefixes
+	id, err := ci.Upload(ctx, strings.NewReader("hello"), "/uploads/a.txt")
+	assert.NoError(t, err)
+	_, err = ci.Upload(ctx, strings.NewReader("hello"), "/other/a.txt")
+	assert.Equal(t, twirp.PermissionDenied, code(err))
+	_, err = ci.Upload(ctx, strings.NewReader("hello"), "/uploads/../other/a.txt")
+	assert.Error(t, err)
+	_, err = auditor.Upload(ctx, strings.NewReader("hello"), "/uploads/b.txt")
+	assert.Error(t, err)
+	id, err = ci.Append(ctx, strings.NewReader(" world"), "/uploads/a.txt", &id)
+	assert.NoError(t, err)
+	_, err = auditor.Append(ctx, strings.NewReader("!"), "/uploads/a.txt", nil)
+	assert.Error(t, err)
+	_, err = ci.Concat(ctx, []client.FileID{id, id}, "/uploads/twice.txt", nil)
+	assert.NoError(t, err)
+	_, err = ci.Concat(ctx, []client.FileID{id}, "/other/twice.txt", nil)
+	assert.Equal(t, twirp.PermissionDenied, code(err))
+	stat, err := auditor.StatVersion(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "/uploads/a.txt", stat.Name)
+	assert.Equal(t, twirp.PermissionDenied, code(auditor.Delete(ctx, id)))
+	_, err = ci.List("/", nil).Next(ctx)
+	assert.Equal(t, twirp.PermissionDenied, code(err))
+	req := httptest.NewRequest("POST", "/twirp/server.JotFS/ServerStats", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer ci-key")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Each file of an uploaded archive is checked
+	archive := func(name string) io.Reader {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 5}))
+		_, err := tw.Write([]byte("hello"))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+		return buf
+	}
+	files, err := ci.UploadArchive(ctx, archive("a.txt"), "/uploads/src")
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, "/uploads/src/a.txt", files[0].Name)
+	}
+	_, err = ci.UploadArchive(ctx, archive("a.txt"), "/other")
+	assert.Error(t, err)
+	_, err = ci.UploadArchive(ctx, archive("../../other/a.txt"), "/uploads/src")
+	assert.Error(t, err)
+	_, err = auditor.UploadArchive(ctx, archive("a.txt"), "/uploads/src")
+	assert.Error(t, err)
+	put := func(key, name string) int {
+		req := httptest.NewRequest("PUT", "/file"+name, strings.NewReader("hello"))
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusCreated, put("ci-key", "/uploads/put.txt"))
+	assert.Equal(t, http.StatusForbidden, put("ci-key", "/other/put.txt"))
+	assert.Equal(t, http.StatusForbidden, put("auditor-key", "/uploads/put.txt"))
+
+	// Changes to the file are picked up on reload
+	policy = strings.Replace(policy, `write = ["/uploads"]`, `write = ["/uploads", "/other"]`, 1)
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(policy), 0644))
+	assert.NoError(t, os.Chtimes(filename, time.Now(), time.Now().Add(time.Minute)))
+	reloaded, err := srv.policy.reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	_, err = ci.Upload(ctx, strings.NewReader("hello"), "/other/a.txt")
+	assert.NoError(t, err)
+
+	// A version may only be created by reference to data the key may read
+	secret := []byte("secret")
+	_, err = ci.Upload(ctx, bytes.NewReader(secret), "/other/secret.txt")
+	assert.NoError(t, err)
+	_, err = ci.UploadByChecksum(ctx, sha256.Sum256(secret), "/uploads/secret.txt", nil)
+	assert.Equal(t, client.ErrNotFound, err)
+	public := []byte("public")
+	_, err = ci.Upload(ctx, bytes.NewReader(public), "/uploads/public.txt")
+	assert.NoError(t, err)
+	res, err := ci.UploadByChecksum(ctx, sha256.Sum256(public), "/uploads/copy.txt", nil)
+	assert.NoError(t, err)
+	assert.True(t, res.Referenced)
+
+	// An invalid file is rejected, and the previous policy kept
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("[[keys]]\nsha256 = \"abc\"\n"), 0644))
+	_, err = srv.policy.reload()
+	assert.Error(t, err)
+	_, err = ci.Upload(ctx, strings.NewReader("hello"), "/other/b.txt")
+	assert.NoError(t, err)
+}
+
+func TestUsageReport(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []usageReport
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var report usageReport
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&report))
+		reports = append(reports, report)
+	}))
+	defer webhook.Close()
+
+	s := &memStore{data: make(map[string][]byte)}
+	_, err = newServer(Config{Store: StoreConfig{Bucket: "test"}, Report: ReportConfig{SMTPAddr: "localhost:25"}}, adapter, s)
+	assert.Error(t, err)
+	srv, err := newServer(Config{
+		Store:  StoreConfig{Bucket: "test"},
+		Report: ReportConfig{Tenant: "acme", WebhookURL: webhook.URL},
+	}, adapter, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	api := httptest.NewServer(srv)
+	defer api.Close()
+	ctx := context.Background()
+	c, err := client.New(api.URL, nil)
+	assert.NoError(t, err)
+
+	// The first check records the start of the first period without sending a report
+	start := time.Now()
+	assert.NoError(t, srv.sendReportIfDue(ctx, start))
+	assert.Empty(t, reports)
+
+	_, err = c.Upload(ctx, strings.NewReader("hello"), "/a.txt")
+	assert.NoError(t, err)
+	id, err := c.Upload(ctx, strings.NewReader("hello world"), "/b.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, c.Delete(ctx, id))
+
+	// No report is sent until the interval has passed
+	assert.NoError(t, srv.sendReportIfDue(ctx, start.Add(24*time.Hour)))
+	assert.Empty(t, reports)
+	end := start.Add(defaultReportInterval)
+	assert.NoError(t, srv.sendReportIfDue(ctx, end))
+	if assert.Len(t, reports, 1) {
+		r := reports[0]
+		assert.Equal(t, "acme", r.Tenant)
+		assert.True(t, r.PeriodStart.Equal(start))
+		assert.True(t, r.PeriodEnd.Equal(end))
+		assert.Equal(t, uint64(2), r.VersionsAdded)
+		assert.Equal(t, uint64(16), r.BytesAdded)
+		assert.Equal(t, uint64(1), r.VersionsDeleted)
+		assert.Equal(t, uint64(11), r.BytesDeleted)
+		assert.Equal(t, uint64(1), r.NumFiles)
+		assert.Equal(t, uint64(5), r.TotalFilesSize)
+	}
+
+	// The next report only covers the usage since the last one
+	_, err = c.Upload(ctx, strings.NewReader("bye"), "/c.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, srv.sendReportIfDue(ctx, end.Add(defaultReportInterval)))
+	if assert.Len(t, reports, 2) {
+		r := reports[1]
+		assert.True(t, r.PeriodStart.Equal(end))
+		assert.Equal(t, uint64(1), r.VersionsAdded)
+		assert.Equal(t, uint64(3), r.BytesAdded)
+		assert.Equal(t, uint64(0), r.VersionsDeleted)
+	}
+
+	// A failed report is retried on the next check
+	webhook.Close()
+	assert.Error(t, srv.sendReportIfDue(ctx, end.Add(3*defaultReportInterval)))
+	last, err := adapter.GetLastUsageReport()
+	assert.NoError(t, err)
+	assert.True(t, last.CreatedAt.Equal(end.Add(defaultReportInterval)))
+}
+
+func TestCheckpointer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	adapter, err := openDB(filepath.Join(dir, "jotfs.db"), db.DiskOptions{}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// Creating the schema is checkpointed once the database is idle. After that, there
+	// is nothing to do until the database is written to.
+	c := newCheckpointer(adapter, 1, time.Minute)
+	_, ok, err := c.checkpointIfDue(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = c.checkpointIfDue(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// The log is checkpointed when it reaches the size threshold, but not truncated
+	_, err = adapter.InsertVacuum(now)
+	assert.NoError(t, err)
+	res, ok, err := c.checkpointIfDue(time.Now())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, res.Busy)
+	assert.Greater(t, res.LogFrames, 0)
+	size, err := adapter.WALSize()
+	assert.NoError(t, err)
+	assert.Greater(t, size, int64(0))
+
+	// Below the size threshold, the log is only checkpointed once the database is idle
+	c = newCheckpointer(adapter, defaultCheckpointSize, time.Minute)
+	_, ok, err = c.checkpointIfDue(time.Now())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = c.checkpointIfDue(time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	size, err = adapter.WALSize()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+
+	// ... and only once after each write
+	_, ok, err = c.checkpointIfDue(time.Now().Add(2 * time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(3), adapter.CheckpointStats().Checkpoints)
+}
+
+func TestShedder(t *testing.T) {
+	ctx := context.Background()
+	isShed := func(err error) bool {
+		var terr twirp.Error
+		return errors.As(err, &terr) && terr.Code() == twirp.Unavailable
+	}
+
+	// Lower classes may use fewer of the slots
+	s := newShedder(ShedConfig{MaxRequests: 4})
+	bg, err := s.acquire(ctx, priorityBackground)
+	assert.NoError(t, err)
+	_, err = s.acquire(ctx, priorityBackground)
+	assert.True(t, isShed(err))
+	for i := 0; i < 2; i++ {
+		_, err = s.acquire(ctx, priorityUpload)
+		assert.NoError(t, err)
+	}
+	_, err = s.acquire(ctx, priorityUpload)
+	assert.True(t, isShed(err))
+	_, err = s.acquire(ctx, priorityInteractive)
+	assert.NoError(t, err)
+	_, err = s.acquire(ctx, priorityInteractive)
+	assert.True(t, isShed(err))
+	bg()
+	assert.Equal(t, adminShed{InFlight: 3, RejectedInteractive: 1, RejectedUpload: 1, RejectedBackground: 1}, s.stats())
+
+	// Queued requests are served highest class first
+	s = newShedder(ShedConfig{MaxRequests: 1, QueueTimeout: time.Minute})
+	release, err := s.acquire(ctx, priorityUpload)
+	assert.NoError(t, err)
+	order := make(chan priority, 2)
+	for _, p := range []priority{priorityBackground, priorityInteractive} {
+		p := p
+		go func() {
+			release, err := s.acquire(ctx, p)
+			assert.NoError(t, err)
+			order <- p
+			release()
+		}()
+		for queued := false; !queued; {
+			time.Sleep(time.Millisecond)
+			s.mu.Lock()
+			queued = len(s.waiting[p]) == 1
+			s.mu.Unlock()
+		}
+	}
+	release()
+	assert.Equal(t, priorityInteractive, <-order)
+	assert.Equal(t, priorityBackground, <-order)
+
+	// A queued request is shed when its wait times out
+	s = newShedder(ShedConfig{MaxRequests: 1, QueueTimeout: 10 * time.Millisecond})
+	release, err = s.acquire(ctx, priorityUpload)
+	assert.NoError(t, err)
+	_, err = s.acquire(ctx, priorityInteractive)
+	assert.True(t, isShed(err))
+	release()
+	_, err = s.acquire(ctx, priorityInteractive)
+	assert.NoError(t, err)
+
+	// Interactive requests are not shed under pressure
+	s = newShedder(ShedConfig{MaxHeapBytes: 1})
+	_, err = s.acquire(ctx, priorityUpload)
+	assert.True(t, isShed(err))
+	_, err = s.acquire(ctx, priorityInteractive)
+	assert.NoError(t, err)
+	s = newShedder(ShedConfig{MaxStoreLatency: time.Second})
+	now := time.Now()
+	s.latency.add(2*time.Second, now)
+	assert.Equal(t, time.Duration(0), s.latency.average(now))
+	assert.Equal(t, 2*time.Second, s.latency.average(now.Add(storeLatencyWindow)))
+	_, err = s.acquire(ctx, priorityBackground)
+	assert.True(t, isShed(err))
+	_, err = s.acquire(ctx, priorityUpload)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), s.latency.average(now.Add(3*storeLatencyWindow)))
+
+	// Rejected requests get an Unavailable error and a Retry-After header
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(Config{
+		Store:    StoreConfig{Bucket: "test"},
+		Shedding: ShedConfig{MaxHeapBytes: 1},
+	}, adapter, &memStore{data: make(map[string][]byte)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	w := post("/twirp/server.JotFS/CreateFile")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, shedRetryAfter, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "upload request rejected")
+	assert.Equal(t, http.StatusServiceUnavailable, post("/packfile").Code)
+	assert.Equal(t, http.StatusOK, post("/twirp/server.JotFS/GetChunkerParams").Code)
+	assert.Equal(t, uint64(2), srv.shedder.stats().RejectedUpload)
+}
+
+func TestRateLimiter(t *testing.T) {
+	request := func(addr string, key string) *http.Request {
+		req := httptest.NewRequest("POST", "/packfile", strings.NewReader("0123456789"))
+		req.RemoteAddr = addr
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		return req
+	}
+	now := time.Now()
+
+	// Each client gets a burst of one second of requests
+	l := newRateLimiter(RateLimitConfig{ClientRequests: 2}, nil)
+	for i := 0; i < 2; i++ {
+		_, wait := l.allow(request("10.0.0.1:1234", ""), now)
+		assert.Zero(t, wait)
+	}
+	_, wait := l.allow(request("10.0.0.1:5678", ""), now)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	_, wait = l.allow(request("10.0.0.2:1234", ""), now)
+	assert.Zero(t, wait)
+	_, wait = l.allow(request("10.0.0.1:1234", ""), now.Add(500*time.Millisecond))
+	assert.Zero(t, wait)
+	assert.Equal(t, adminRateLimit{Clients: 2, Rejected: 1}, l.stats())
+
+	// The global limit applies to all clients together
+	l = newRateLimiter(RateLimitConfig{Requests: 1, ClientRequests: 10}, nil)
+	_, wait = l.allow(request("10.0.0.1:1234", ""), now)
+	assert.Zero(t, wait)
+	_, wait = l.allow(request("10.0.0.2:1234", ""), now)
+	assert.Equal(t, time.Second, wait)
+
+	// Bytes are taken as the body is read, and the debt delays the next request
+	l = newRateLimiter(RateLimitConfig{ClientBytes: 5}, nil)
+	req, _, err := l.admit(request("10.0.0.1:1234", ""))
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(b))
+	_, retryAfter, err := l.admit(request("10.0.0.1:1234", ""))
+	var terr twirp.Error
+	assert.True(t, errors.As(err, &terr) && terr.Code() == twirp.ResourceExhausted)
+	assert.Equal(t, "1", retryAfter)
+
+	// Idle clients are forgotten
+	l.sweep(time.Now().Add(rateClientIdle))
+	assert.Zero(t, l.stats().Clients)
+
+	// Keys in the access policy are limited by name, and may override the limits
+	dir, err := ioutil.TempDir("", "jotfs-ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hash := func(key string) string {
+		h := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(h[:])
+	}
+	policy := fmt.Sprintf(`
+[roles.rw]
+read = ["/"]
+write = ["/"]
+
+[[keys]]
+name = "fast"
+sha256 = "%s"
+roles = ["rw"]
+requests_per_second = 3
+
+[[keys]]
+name = "slow"
+sha256 = "%s"
+roles = ["rw"]
+`, hash("fast-key"), hash("slow-key"))
+	filename := filepath.Join(dir, "policy.toml")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(policy), 0644))
+	watcher, err := newPolicyWatcher(filename)
+	assert.NoError(t, err)
+	l = newRateLimiter(RateLimitConfig{ClientRequests: 1}, watcher)
+	for i := 0; i < 3; i++ {
+		_, wait = l.allow(request(fmt.Sprintf("10.0.0.%d:1234", i), "fast-key"), now)
+		assert.Zero(t, wait)
+	}
+	_, wait = l.allow(request("10.0.0.1:1234", "slow-key"), now)
+	assert.Zero(t, wait)
+	_, wait = l.allow(request("10.0.0.2:1234", "slow-key"), now)
+	assert.Equal(t, time.Second, wait)
+	name, _, _ := l.client(request("10.0.0.1:1234", "invalid-key"))
+	assert.Equal(t, "ip 10.0.0.1", name)
+
+	// Rejected requests get a ResourceExhausted error, or a 429 status for packfile
+	// uploads, and a Retry-After header
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(Config{
+		Store:     StoreConfig{Bucket: "test"},
+		RateLimit: RateLimitConfig{ClientRequests: 1},
+	}, adapter, &memStore{data: make(map[string][]byte)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, post("/twirp/server.JotFS/GetChunkerParams").Code)
+	w := post("/twirp/server.JotFS/GetChunkerParams")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), string(twirp.ResourceExhausted))
+	assert.Contains(t, w.Body.String(), "rate limit exceeded")
+	w = post("/packfile")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.Equal(t, uint64(2), srv.limiter.stats().Rejected)
+}
+
+func TestThrottler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	now := time.Now()
+
+	// Clients are paused for the time their bucket is in debt
+	th := newThrottler(ThrottleConfig{DownloadBytes: 1000}, nil)
+	c := th.client(req, now)
+	assert.Zero(t, th.delay(c.download, 1000, now))
+	assert.Equal(t, 500*time.Millisecond, th.delay(c.download, 500, now))
+	assert.Zero(t, th.delay(c.download, 0, now.Add(500*time.Millisecond)))
+	assert.Same(t, c, th.client(req, now))
+	assert.Zero(t, c.upload.wait(1e9, now))
+
+	// Request bodies and responses are paced, not rejected
+	th = newThrottler(ThrottleConfig{UploadBytes: 10000, DownloadBytes: 10000}, nil)
+	data := bytes.Repeat([]byte("x"), 15000)
+	h := th.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, data, b)
+		w.Write(data)
+	}))
+	start := time.Now()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", bytes.NewReader(data)))
+	assert.True(t, time.Since(start) >= 900*time.Millisecond)
+	assert.Equal(t, data, w.Body.Bytes())
+	stats := th.stats()
+	assert.Equal(t, 1, stats.Clients)
+	assert.NotZero(t, stats.Pauses)
+}
+
+func TestIngestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, data string) {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		assert.NoError(t, ioutil.WriteFile(name, []byte(data), 0644))
+	}
+	write("data/a.csv", "1,2,3")
+	write("data/2020/b.csv", "4,5,6")
+	write("data/bad\x01.csv", "7,8,9")
+	write("other/c.csv", "0")
+
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(Config{Store: StoreConfig{Bucket: "test"}}, adapter, &memStore{data: make(map[string][]byte)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	ctx := context.Background()
+	src := StoreConfig{URL: "file://" + dir}
+
+	res, err := srv.IngestStore(ctx, src, &IngestOpts{Prefix: "data/", Dst: "/imported"})
+	assert.NoError(t, err)
+	assert.Equal(t, IngestResult{Uploaded: 2, Bytes: 10, Invalid: 1}, res)
+	c, err := srv.localClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.Latest(ctx, "/imported/2020/b.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), info.Size)
+	assert.NotEmpty(t, info.Metadata[ingestETagKey])
+
+	// Only changed objects are uploaded again
+	time.Sleep(10 * time.Millisecond)
+	write("data/a.csv", "1,2,3,4")
+	res, err = srv.IngestStore(ctx, src, &IngestOpts{Prefix: "data/", Dst: "/imported"})
+	assert.NoError(t, err)
+	assert.Equal(t, IngestResult{Uploaded: 1, Bytes: 7, Unchanged: 1, Invalid: 1}, res)
+
+	_, err = srv.IngestStore(ctx, StoreConfig{}, nil)
+	assert.Error(t, err)
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := Config{
+		Database:         filepath.Join(dir, "jotfs.db"),
+		Store:            StoreConfig{URL: "file://" + filepath.Join(dir, "store")},
+		ManualMigrations: true,
+	}
+
+	// Database created before schema versioning has only the base schema
+	base, err := ioutil.ReadFile(filepath.Join("..", "internal", "db", "schema", "000_base.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdb, err := sql.Open("sqlite3", cfg.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sdb.Exec(string(base))
+	assert.NoError(t, err)
+	assert.NoError(t, sdb.Close())
+
+	// The server refuses to start while migrations are pending
+	_, err = New(cfg)
+	assert.Error(t, err)
+
+	migrations, err := MigrateDatabase(cfg, &MigrateDatabaseOpts{DryRun: true})
+	assert.NoError(t, err)
+	assert.True(t, len(migrations) > 1)
+	assert.Equal(t, SchemaMigration{Version: 0, Name: "base", Applied: true}, migrations[0])
+	assert.False(t, migrations[1].Applied)
+
+	migrations, err = MigrateDatabase(cfg, nil)
+	assert.NoError(t, err)
+	for _, m := range migrations {
+		assert.True(t, m.Applied)
+	}
+	assert.True(t, migrations[0].AppliedAt.IsZero())
+	assert.False(t, migrations[1].AppliedAt.IsZero())
+
+	srv, err := New(cfg)
+	assert.NoError(t, err)
+	if err == nil {
+		srv.Close()
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	// 2024-03-15 is a Friday
+	now := at("2024-03-15 10:20")
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"* * * * *", "2024-03-15 10:21"},
+		{"30 3 * * *", "2024-03-16 03:30"},
+		{"*/15 * * * *", "2024-03-15 10:30"},
+		{"0 9-17/4 * * *", "2024-03-15 13:00"},
+		{"0 0 * * 0", "2024-03-17 00:00"},
+		{"0 0 1,20 * 1", "2024-03-18 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		{"@monthly", "2024-04-01 00:00"},
+		{"@hourly", "2024-03-15 11:00"},
+		{"@every 90m", "2024-03-15 11:50"},
+	}
+	for _, test := range tests {
+		sched, err := parseSchedule(test.spec)
+		if assert.NoError(t, err, test.spec) {
+			assert.Equal(t, at(test.next), sched.next(now), test.spec)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 7", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *", "@every 1ms", "@every x"} {
+		_, err := parseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	adapter, err := db.EmptyInMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adapter.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zerolog.Nop()
+
+	// Runs of a job never overlap, and the number running at once is limited
+	sched := newScheduler(adapter, 1, logger)
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	work := func(ctx context.Context) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+	assert.NoError(t, sched.add("a", "@hourly", work))
+	assert.NoError(t, sched.add("b", "@hourly", func(ctx context.Context) error {
+		if err := work(ctx); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	}))
+	assert.NoError(t, sched.add("c", jobOff, work))
+	assert.Error(t, sched.add("d", "never", work))
+	sched.start(ctx)
+
+	assert.Equal(t, ErrJobNotFound, sched.runNow("c"))
+	assert.NoError(t, sched.runNow("a"))
+	assert.NoError(t, sched.runNow("b"))
+	waitFor := func(f func(jobs []JobStatus) bool) []JobStatus {
+		for i := 0; i < 500; i++ {
+			if jobs := sched.status(); f(jobs) {
+				return jobs
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("timed out")
+		return nil
+	}
+	waitFor(func(jobs []JobStatus) bool { return jobs[0].Running || jobs[1].Running })
+	release <- struct{}{}
+	release <- struct{}{}
+	jobs := waitFor(func(jobs []JobStatus) bool {
+		return jobs[0].Runs == 1 && jobs[1].Runs == 1 && !jobs[0].Running && !jobs[1].Running
+	})
+	assert.Equal(t, 1, maxRunning)
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, "a", jobs[0].Name)
+	assert.Empty(t, jobs[0].LastError)
+	assert.Equal(t, "failed", jobs[1].LastError)
+	assert.Equal(t, int64(1), jobs[1].Failures)
+	assert.True(t, jobs[1].NextRun.After(jobs[1].LastStart))
+
+	// The runs are kept in the database, so a job due while the server was stopped
+	// runs when it starts
+	cancel()
+	runs, err := adapter.ListJobRuns()
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	run := runs["a"]
+	run.StartedAt = run.StartedAt.Add(-2 * time.Hour)
+	assert.NoError(t, adapter.SaveJobRun(run))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	sched = newScheduler(adapter, 1, logger)
+	ran := make(chan struct{}, 1)
+	assert.NoError(t, sched.add("a", "@every 1h", func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}))
+	sched.start(ctx)
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run")
+	}
+	jobs = waitFor(func(jobs []JobStatus) bool { return jobs[0].Runs == 2 && !jobs[0].Running })
+	assert.Equal(t, int64(0), jobs[0].Failures)
+}
+
+func TestBackupDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := Config{
+		Database:     filepath.Join(dir, "jotfs.db"),
+		Store:        StoreConfig{URL: "file://" + filepath.Join(dir, "store")},
+		BackupRetain: 1,
+	}
+	ctx := context.Background()
+	upload := func(srv *Server, name string) {
+		c, err := srv.localClient()
+		assert.NoError(t, err)
+		_, err = c.Upload(ctx, strings.NewReader("hello"), name)
+		assert.NoError(t, err)
+	}
+	exists := func(srv *Server, name string) bool {
+		_, err := srv.db.GetLatestFileVersion(name)
+		return err == nil
+	}
+
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(srv, "/a.txt")
+	first, err := srv.BackupDatabase(ctx)
+	assert.NoError(t, err)
+	assert.True(t, first.Size > 0)
+	assert.Equal(t, 0, first.Deleted)
+	time.Sleep(2 * time.Millisecond)
+	upload(srv, "/b.txt")
+
+	// The oldest backups are deleted
+	res, err := srv.BackupDatabase(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Deleted)
+	backups, err := srv.ListDatabaseBackups(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []DatabaseBackup{res.DatabaseBackup}, backups)
+	upload(srv, "/c.txt")
+	assert.NoError(t, srv.Close())
+
+	// The database is only replaced if forced
+	_, err = RestoreDatabase(ctx, cfg, nil)
+	assert.Error(t, err)
+	restored, err := RestoreDatabase(ctx, cfg, &RestoreOpts{Force: true})
+	assert.NoError(t, err)
+	assert.Equal(t, res.DatabaseBackup, restored)
+
+	srv, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, exists(srv, "/a.txt"))
+	assert.True(t, exists(srv, "/b.txt"))
+	assert.False(t, exists(srv, "/c.txt"))
+	assert.NoError(t, srv.Close())
+
+	// Deleted and corrupt backups are not restored
+	_, err = RestoreDatabase(ctx, cfg, &RestoreOpts{Key: first.Key, Force: true})
+	assert.Error(t, err)
+	corrupt := filepath.Join(dir, "store", filepath.FromSlash(dbBackupPrefix+"corrupt.db"))
+	assert.NoError(t, ioutil.WriteFile(corrupt, []byte("not a database"), 0644))
+	_, err = RestoreDatabase(ctx, cfg, &RestoreOpts{Key: dbBackupPrefix + "corrupt.db", Force: true})
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), "backup "), err.Error())
+	}
+}
+
+func TestCompactDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jotfs-compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := Config{
+		Database: filepath.Join(dir, "jotfs.db"),
+		Store:    StoreConfig{URL: "file://" + filepath.Join(dir, "store")},
+	}
+	ctx := context.Background()
+	upload := func(name string) {
+		srv, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		c, err := srv.localClient()
+		assert.NoError(t, err)
+		_, err = c.Upload(ctx, strings.NewReader("hello"), name)
+		assert.NoError(t, err)
+	}
+	compact := func(swap bool) CompactResult {
+		srv, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		res, err := srv.CompactDatabase(ctx, &CompactOpts{Swap: swap})
+		assert.NoError(t, err)
+		return res
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	}
+	files := func() []string {
+		srv, err := New(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		c, err := srv.localClient()
+		assert.NoError(t, err)
+		var names []string
+		it := c.List("/", nil)
+		for {
+			info, err := it.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			names = append(names, info.Name)
+		}
+		return names
+	}
+
+	upload("/a.txt")
+
+	// The copy is uploaded to the store
+	res := compact(false)
+	assert.False(t, res.Pending)
+	assert.True(t, res.CompactedSize > 0)
+	assert.True(t, exists(filepath.Join(dir, "store", filepath.FromSlash(res.Key))))
+	assert.False(t, exists(cfg.Database+compactedSuffix))
+	assert.False(t, exists(cfg.Database+compactingSuffix))
+
+	// The copy replaces the database at the next start
+	res = compact(true)
+	assert.True(t, res.Pending)
+	assert.True(t, exists(cfg.Database+compactedSuffix))
+	assert.Equal(t, []string{"/a.txt"}, files())
+	assert.False(t, exists(cfg.Database+compactedSuffix))
+	assert.False(t, exists(cfg.Database+compactedSumSuffix))
+
+	// The copy is discarded if the database changed after it was made
+	compact(true)
+	upload("/b.txt")
+	assert.ElementsMatch(t, []string{"/a.txt", "/b.txt"}, files())
+	assert.False(t, exists(cfg.Database+compactedSuffix))
+}
+
+type memStore struct {
+	sync.Mutex
+	data map[string][]byte
+}
+
+func (s *memStore) Put(ctx context.Context, bucket string, key string, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data[key] = b
+	return nil
+}
+
+func (s *memStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.data[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.data[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	if offset > uint64(len(b)) {
+		offset = uint64(len(b))
+	}
+	b = b[offset:]
+	if length < uint64(len(b)) {
+		b = b[:length]
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memStore) Copy(bucket string, from string, to string) error {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.data[from]
+	if !ok {
+		return store.ErrNotFound
+	}
+	s.data[to] = b
+	return nil
+}
+
+func (s *memStore) Delete(bucket string, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *memStore) PresignGetURL(bucket string, key string, expires time.Duration, contentRange *store.Range) (string, error) {
+	return "", nil
+}
+
+// flakyStore is a memStore whose Get method fails until it has been called failures
+// times.
+type flakyStore struct {
+	memStore
+	failures int
+}
+
+func (s *flakyStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	if s.failures > 0 {
+		s.failures--
+		return nil, errors.New("connection refused")
+	}
+	return s.memStore.Get(ctx, bucket, key)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/db"
+)
+
+const (
+	// sharePrefix is the path prefix of share links.
+	sharePrefix = "/share"
+
+	defaultShareExpiry = 7 * 24 * time.Hour
+	maxShareExpiry     = 90 * 24 * time.Hour
+
+	// maxShareFiles is the maximum number of file versions read when listing a share.
+	maxShareFiles = 10000
+
+	shareTokenSize = 32
+)
+
+// newShareToken returns a new random share token and its hash.
+func newShareToken() (string, [32]byte, error) {
+	b := make([]byte, shareTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", [32]byte{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, sha256.Sum256([]byte(token)), nil
+}
+
+// shareDir returns the prefix of the names of the files shared under prefix.
+func shareDir(prefix string) string {
+	if prefix == "/" {
+		return prefix
+	}
+	return prefix + "/"
+}
+
+// shareFile is a file listed by a share.
+type shareFile struct {
+	// Name is the name of the file relative to the share's prefix.
+	Name      string    `json:"name"`
+	Size      uint64    `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	// URL is the download URL of the file relative to the listing.
+	URL string `json:"url"`
+}
+
+// shareListing is the response of a share's listing.
+type shareListing struct {
+	Prefix    string      `json:"prefix"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	Files     []shareFile `json:"files"`
+	// Truncated is true if the share contains more files than could be listed.
+	Truncated bool `json:"truncated"`
+}
+
+// shareHandler returns a http handler for share links. The handler expects sharePrefix
+// to be removed from the request path. A share link is /{token}/, which lists the
+// latest version of each file under the share's prefix as HTML, or as JSON if
+// requested by the Accept header or ?format=json. Files are downloaded from
+// /{token}/{name}.
+func shareHandler(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p := strings.TrimPrefix(req.URL.Path, "/")
+		i := strings.Index(p, "/")
+		if i == -1 {
+			if p == "" {
+				http.NotFound(w, req)
+				return
+			}
+			http.Redirect(w, req, p+"/", http.StatusMovedPermanently)
+			return
+		}
+		token, rel := p[:i], p[i+1:]
+
+		share, err := s.db.GetShare(sha256.Sum256([]byte(token)))
+		if errors.Is(err, db.ErrNotFound) || (err == nil && time.Now().After(share.ExpiresAt)) {
+			http.Error(w, "share link not found or expired", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.httpError(w, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, no-store")
+		if rel == "" {
+			s.shareList(w, req, share)
+			return
+		}
+		s.shareDownload(w, req, s.srv.NormalizeName(path.Join(share.Prefix, path.Clean("/"+rel))))
+	}
+}
+
+func (s *Server) shareList(w http.ResponseWriter, req *http.Request, share db.Share) {
+	dir := shareDir(share.Prefix)
+	infos, err := s.db.ListFiles(dir, 0, maxShareFiles, "", "", false)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+
+	// Versions are listed newest first, so the first version of each name is its latest
+	res := shareListing{
+		Prefix:    share.Prefix,
+		ExpiresAt: share.ExpiresAt,
+		Files:     make([]shareFile, 0),
+		Truncated: len(infos) == maxShareFiles,
+	}
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		if seen[info.Name] || !strings.HasPrefix(info.Name, dir) {
+			continue
+		}
+		seen[info.Name] = true
+		name := strings.TrimPrefix(info.Name, dir)
+		res.Files = append(res.Files, shareFile{
+			Name:      name,
+			Size:      info.Size,
+			CreatedAt: info.CreatedAt,
+			URL:       "./" + (&url.URL{Path: name}).EscapedPath(),
+		})
+	}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Name < res.Files[j].Name })
+
+	if strings.Contains(req.Header.Get("Accept"), "application/json") || req.URL.Query().Get("format") == "json" {
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	if err := shareTemplate.Execute(w, res); err != nil {
+		s.logger.Error().Msgf("share listing: %v", err)
+	}
+}
+
+func (s *Server) shareDownload(w http.ResponseWriter, req *http.Request, name string) {
+	info, err := s.db.GetLatestFileVersion(name)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+
+	// Shared files are never rendered by the browser in the server's origin
+	h := w.Header()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	h.Set("Content-Security-Policy", "sandbox")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Length", strconv.FormatUint(info.Size, 10))
+	h.Set("Last-Modified", info.CreatedAt.Format(http.TimeFormat))
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	cw := &countingWriter{w: w}
+	if err := s.srv.WriteFile(req.Context(), info.Sum, cw); err != nil {
+		if cw.n == 0 {
+			h.Del("Content-Length")
+			h.Del("Content-Disposition")
+			s.httpError(w, err)
+			return
+		}
+		// The response is incomplete, which the client detects from the length
+		s.logger.Error().Msgf("share download %s: %v", name, err)
+	}
+}
+
+// countingWriter counts the bytes written to an io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+var shareTemplate = template.Must(template.New("share").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Prefix}}</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 2rem; color: #222; }
+  h1 { font-size: 1.3rem; }
+  table { border-collapse: collapse; }
+  th, td { text-align: left; padding: 0.3rem 1.5rem 0.3rem 0; border-bottom: 1px solid #ddd; }
+  td.num { text-align: right; }
+  .note { color: #666; font-size: 0.9rem; }
+</style>
+</head>
+<body>
+<h1>{{.Prefix}}</h1>
+<p class="note">This link expires at {{time .ExpiresAt}}.</p>
+<table>
+<thead><tr><th>Name</th><th>Size</th><th>Uploaded</th></tr></thead>
+<tbody>
+{{- range .Files}}
+<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td class="num">{{.Size}}</td><td>{{time .CreatedAt}}</td></tr>
+{{- else}}
+<tr><td colspan="3">No files</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- if .Truncated}}
+<p class="note">Only some of the files are listed.</p>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,409 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jotfs/jotfs/internal/store"
+	"github.com/twitchtv/twirp"
+)
+
+// ShedConfig configures load shedding. Requests are divided into priority classes:
+// interactive reads, such as listing and downloading files, come before uploads and
+// other changes, which come before background and admin requests. When the server is
+// under pressure, requests of the lower classes are rejected with an Unavailable
+// error, which clients retry, so the server degrades gracefully instead of timing out
+// every request equally. Load shedding is disabled if every field is zero.
+type ShedConfig struct {
+	// MaxRequests is the maximum number of requests served at once. Interactive
+	// requests may use every slot, uploads three quarters of them and background
+	// requests a quarter, so a burst of uploads cannot starve reads. Unlimited if zero.
+	MaxRequests int
+
+	// QueueTimeout is the maximum time a request waits for a slot when MaxRequests
+	// are being served. Waiting requests are granted slots in priority order. A
+	// request is rejected immediately if it's zero.
+	QueueTimeout time.Duration
+
+	// MaxHeapBytes, if set, rejects uploads and background requests while the size of
+	// the server's heap exceeds it.
+	MaxHeapBytes uint64
+
+	// MaxStoreLatency, if set, rejects background requests while the average time
+	// taken by requests to the store exceeds it.
+	MaxStoreLatency time.Duration
+}
+
+func (c ShedConfig) enabled() bool {
+	return c.MaxRequests > 0 || c.MaxHeapBytes > 0 || c.MaxStoreLatency > 0
+}
+
+// priority is the priority class of a request. Lower values are served first.
+type priority int
+
+const (
+	priorityInteractive priority = iota
+	priorityUpload
+	priorityBackground
+	numPriorities
+)
+
+func (p priority) String() string {
+	switch p {
+	case priorityInteractive:
+		return "interactive"
+	case priorityUpload:
+		return "upload"
+	case priorityBackground:
+		return "background"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// interactiveMethods are the RPCs in the interactive class. They read files without
+// changing them.
+var interactiveMethods = map[string]bool{
+	"List":             true,
+	"Head":             true,
+	"GetFileInfo":      true,
+	"Search":           true,
+	"Download":         true,
+	"DownloadRange":    true,
+	"DownloadDelta":    true,
+	"Diff":             true,
+	"ListTags":         true,
+	"ListSnapshots":    true,
+	"DiffSnapshot":     true,
+	"GetChunkerParams": true,
+	"VacuumStatus":     true,
+}
+
+// backgroundMethods are the RPCs in the background class. Every other RPC is in the
+// upload class.
+var backgroundMethods = map[string]bool{
+	"StartVacuum": true,
+	"ServerStats": true,
+	"ChunkReport": true,
+}
+
+// methodPriority returns the priority class of an RPC.
+func methodPriority(method string) priority {
+	if interactiveMethods[method] {
+		return priorityInteractive
+	}
+	if backgroundMethods[method] {
+		return priorityBackground
+	}
+	return priorityUpload
+}
+
+const (
+	// heapSampleInterval is the minimum time between reads of the heap size, which
+	// briefly stop the world.
+	heapSampleInterval = time.Second
+
+	// storeLatencyWindow is the period the average store latency is measured over.
+	storeLatencyWindow = 10 * time.Second
+
+	// shedRetryAfter is the Retry-After header, in seconds, sent with rejected requests.
+	shedRetryAfter = "1"
+)
+
+// shedder limits the number of requests served at once and rejects requests of the
+// lower priority classes when the server is under pressure.
+type shedder struct {
+	cfg ShedConfig
+
+	mu       sync.Mutex
+	inFlight int
+	// waiting holds the channels of requests waiting for a slot, oldest first, by class
+	waiting [numPriorities][]chan struct{}
+	shed    [numPriorities]uint64
+
+	heapMu sync.Mutex
+	heapAt time.Time
+	heap   uint64
+
+	latency latencyWindow
+}
+
+func newShedder(cfg ShedConfig) *shedder {
+	return &shedder{cfg: cfg}
+}
+
+// limit returns the number of slots requests of class p may use.
+func (s *shedder) limit(p priority) int {
+	n := s.cfg.MaxRequests
+	switch p {
+	case priorityUpload:
+		n = n * 3 / 4
+	case priorityBackground:
+		n = n / 4
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// acquire waits for a slot for a request of class p. The returned function must be
+// called to release the slot when the request completes. Returns an Unavailable error
+// if the request is shed.
+func (s *shedder) acquire(ctx context.Context, p priority) (func(), error) {
+	if reason := s.pressure(p); reason != "" {
+		return nil, s.reject(p, reason)
+	}
+	if s.cfg.MaxRequests <= 0 {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.inFlight < s.limit(p) && !s.queued(p) {
+		s.inFlight++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	if s.cfg.QueueTimeout <= 0 {
+		s.mu.Unlock()
+		return nil, s.reject(p, "all request slots are in use")
+	}
+	ch := make(chan struct{})
+	s.waiting[p] = append(s.waiting[p], ch)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.cfg.QueueTimeout)
+	defer timer.Stop()
+	var reason string
+	select {
+	case <-ch:
+		return s.release, nil
+	case <-timer.C:
+		reason = fmt.Sprintf("no request slot was free within %s", s.cfg.QueueTimeout)
+	case <-ctx.Done():
+		reason = "the request was cancelled while queued"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.waiting[p] {
+		if w == ch {
+			s.waiting[p] = append(s.waiting[p][:i], s.waiting[p][i+1:]...)
+			s.shed[p]++
+			return nil, shedError(p, reason)
+		}
+	}
+	// The slot was granted as the wait ended
+	return s.release, nil
+}
+
+// queued returns true if any request of class p, or a higher class, is waiting for a
+// slot. s.mu must be held.
+func (s *shedder) queued(p priority) bool {
+	for c := priorityInteractive; c <= p; c++ {
+		if len(s.waiting[c]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// release frees a slot and grants slots to the waiting requests, highest class first.
+func (s *shedder) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	for p := priorityInteractive; p < numPriorities; p++ {
+		for len(s.waiting[p]) > 0 && s.inFlight < s.limit(p) {
+			close(s.waiting[p][0])
+			s.waiting[p] = s.waiting[p][1:]
+			s.inFlight++
+		}
+		if len(s.waiting[p]) > 0 {
+			// Lower classes wait until this class has been served
+			return
+		}
+	}
+}
+
+// pressure returns the reason requests of class p are shed, or an empty string if
+// they are not. Interactive requests are never shed because of pressure.
+func (s *shedder) pressure(p priority) string {
+	if p == priorityInteractive {
+		return ""
+	}
+	if s.cfg.MaxHeapBytes > 0 && s.heapSize(time.Now()) > s.cfg.MaxHeapBytes {
+		return "the server's memory use is high"
+	}
+	if p == priorityBackground && s.cfg.MaxStoreLatency > 0 && s.latency.average(time.Now()) > s.cfg.MaxStoreLatency {
+		return "the store is responding slowly"
+	}
+	return ""
+}
+
+// heapSize returns the size of the heap, read at most once per heapSampleInterval.
+func (s *shedder) heapSize(now time.Time) uint64 {
+	s.heapMu.Lock()
+	defer s.heapMu.Unlock()
+	if now.Sub(s.heapAt) >= heapSampleInterval {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		s.heap = m.HeapAlloc
+		s.heapAt = now
+	}
+	return s.heap
+}
+
+func (s *shedder) reject(p priority, reason string) error {
+	s.mu.Lock()
+	s.shed[p]++
+	s.mu.Unlock()
+	return shedError(p, reason)
+}
+
+func shedError(p priority, reason string) twirp.Error {
+	return twirp.NewError(twirp.Unavailable, fmt.Sprintf("server overloaded: %s request rejected because %s. Retry later", p, reason))
+}
+
+// twirpHandler returns a handler which sheds requests to the Twirp API at prefix by
+// the priority class of their method.
+func (s *shedder) twirpHandler(h http.Handler, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p := methodPriority(strings.TrimPrefix(req.URL.Path, prefix))
+		release, err := s.acquire(req.Context(), p)
+		if err != nil {
+			w.Header().Set("Retry-After", shedRetryAfter)
+			twirp.WriteError(w, err)
+			return
+		}
+		defer release()
+		h.ServeHTTP(w, req)
+	})
+}
+
+// handler returns a handler which sheds requests as class p.
+func (s *shedder) handler(p priority, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		release, err := s.acquire(req.Context(), p)
+		if err != nil {
+			w.Header().Set("Retry-After", shedRetryAfter)
+			http.Error(w, err.(twirp.Error).Msg(), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		h(w, req)
+	}
+}
+
+func (s *shedder) stats() adminShed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return adminShed{
+		InFlight:            s.inFlight,
+		RejectedInteractive: s.shed[priorityInteractive],
+		RejectedUpload:      s.shed[priorityUpload],
+		RejectedBackground:  s.shed[priorityBackground],
+	}
+}
+
+// latencyWindow measures the average latency of store requests over a period of
+// storeLatencyWindow. The average of the last complete period is reported, so a
+// single slow request does not shed requests, and the average is reset if the store
+// has not been used for a period.
+type latencyWindow struct {
+	mu    sync.Mutex
+	start time.Time
+	sum   time.Duration
+	n     int
+	last  time.Duration
+}
+
+func (l *latencyWindow) add(d time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(now)
+	l.sum += d
+	l.n++
+}
+
+func (l *latencyWindow) average(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(now)
+	return l.last
+}
+
+// roll starts a new period if the current one has ended. l.mu must be held.
+func (l *latencyWindow) roll(now time.Time) {
+	elapsed := now.Sub(l.start)
+	if elapsed < storeLatencyWindow {
+		return
+	}
+	l.last = 0
+	if l.n > 0 && elapsed < 2*storeLatencyWindow {
+		l.last = l.sum / time.Duration(l.n)
+	}
+	l.start = now
+	l.sum = 0
+	l.n = 0
+}
+
+// timedStore records the latency of requests to a store. Only Get, GetRange, Copy,
+// Delete and Stat are timed, since the duration of a Put depends on the size of the
+// object and how fast it's read.
+func (s *shedder) timedStore(st store.Store) store.Store {
+	if stater, ok := st.(store.Stater); ok {
+		return &timedStater{timedStore{st, &s.latency}, stater}
+	}
+	return &timedStore{st, &s.latency}
+}
+
+type timedStore struct {
+	store.Store
+	latency *latencyWindow
+}
+
+func (s *timedStore) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.Store.Get(ctx, bucket, key)
+	s.latency.add(time.Since(start), time.Now())
+	return r, err
+}
+
+func (s *timedStore) GetRange(ctx context.Context, bucket string, key string, offset uint64, length uint64) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.Store.GetRange(ctx, bucket, key, offset, length)
+	s.latency.add(time.Since(start), time.Now())
+	return r, err
+}
+
+func (s *timedStore) Copy(bucket string, from string, to string) error {
+	start := time.Now()
+	err := s.Store.Copy(bucket, from, to)
+	s.latency.add(time.Since(start), time.Now())
+	return err
+}
+
+func (s *timedStore) Delete(bucket string, key string) error {
+	start := time.Now()
+	err := s.Store.Delete(bucket, key)
+	s.latency.add(time.Since(start), time.Now())
+	return err
+}
+
+type timedStater struct {
+	timedStore
+	stater store.Stater
+}
+
+func (s *timedStater) Stat(ctx context.Context, bucket string, key string) (store.ObjectInfo, error) {
+	start := time.Now()
+	info, err := s.stater.Stat(ctx, bucket, key)
+	s.latency.add(time.Since(start), time.Now())
+	return info, err
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrMisdirected is returned by a TenantFunc if a request was sent on a connection
+// established for a different host. The client should retry on a new connection.
+var ErrMisdirected = errors.New("misdirected request")
+
+// TenantFunc returns the name of the tenant a request is for. The tenant should be
+// derived from the request's TLS connection, rather than its headers, so a client can't
+// address a tenant it hasn't connected to.
+type TenantFunc func(req *http.Request) (string, error)
+
+// TenantFromSNI returns a TenantFunc which takes the tenant name from the server name
+// sent by the client in the TLS handshake. The server name must be a subdomain of
+// domain, e.g. the tenant of tenanta.jot.example.com is tenanta if domain is
+// jot.example.com. Requests with a Host header which differs from the server name
+// return ErrMisdirected, since an HTTP/2 client may reuse a connection for any host
+// covered by the server's certificate.
+func TenantFromSNI(domain string) TenantFunc {
+	suffix := "." + strings.ToLower(strings.TrimSuffix(domain, "."))
+	return func(req *http.Request) (string, error) {
+		if req.TLS == nil || req.TLS.ServerName == "" {
+			return "", errors.New("TLS server name required")
+		}
+		name := strings.ToLower(req.TLS.ServerName)
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, name) {
+			return "", ErrMisdirected
+		}
+		tenant := strings.TrimSuffix(name, suffix)
+		if tenant == name || tenant == "" || strings.Contains(tenant, ".") {
+			return "", fmt.Errorf("server name %s is not a subdomain of %s", name, suffix[1:])
+		}
+		return tenant, nil
+	}
+}
+
+// TenantFromCertificate returns a TenantFunc which takes the tenant name from an
+// attribute of the client's verified certificate, for example its organizational
+// unit. The server's TLS config must verify client certificates, i.e. set ClientCAs and
+// ClientAuth to tls.RequireAndVerifyClientCert.
+func TenantFromCertificate(attr func(cert *x509.Certificate) string) TenantFunc {
+	return func(req *http.Request) (string, error) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+			return "", errors.New("verified client certificate required")
+		}
+		tenant := attr(req.TLS.VerifiedChains[0][0])
+		if tenant == "" {
+			return "", errors.New("client certificate does not name a tenant")
+		}
+		return tenant, nil
+	}
+}
+
+// Router is an http.Handler which serves several tenants from a single listener. Each
+// tenant is a separate Server, with its own database and bucket, so a tenant's
+// requests can only access its own files.
+type Router struct {
+	tenant  TenantFunc
+	tenants map[string]http.Handler
+}
+
+// NewRouter returns a Router which sends each request to the handler of the tenant
+// returned by tenant. Tenant handlers are usually created with NewHandler.
+func NewRouter(tenant TenantFunc, tenants map[string]http.Handler) *Router {
+	return &Router{tenant: tenant, tenants: tenants}
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name, err := r.tenant(req)
+	if errors.Is(err, ErrMisdirected) {
+		http.Error(w, err.Error(), http.StatusMisdirectedRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	h, ok := r.tenants[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown tenant %q", name), http.StatusNotFound)
+		return
+	}
+	h.ServeHTTP(w, req)
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bytesPerMbps is the number of bytes per second in a megabit per second.
+const bytesPerMbps = 1e6 / 8
+
+// ThrottleConfig configures the bandwidth of each client. Unlike rate limiting,
+// throttling never rejects a request: a client sending or receiving faster than its
+// limit is slowed down by pausing the reads of its request bodies, or the writes of
+// its responses, so the bandwidth of the server is shared fairly between clients.
+// Each limit allows a burst of one second of data. A client is identified as for
+// rate limiting, and a key's limits may be overridden in the access policy file. A
+// zero limit is unlimited. Throttling is disabled if both fields are zero and the
+// server has no access policy.
+type ThrottleConfig struct {
+	// UploadBytes is the maximum bytes per second received from each client in API
+	// requests and packfile uploads.
+	UploadBytes float64
+
+	// DownloadBytes is the maximum bytes per second sent to each client in API
+	// responses, share links and, for a file or in-memory store, downloads of objects
+	// from the store. Downloads from an S3 store go directly to the store and are not
+	// throttled.
+	DownloadBytes float64
+}
+
+func (c ThrottleConfig) enabled() bool {
+	return c.UploadBytes > 0 || c.DownloadBytes > 0
+}
+
+// clientThrottle is the upload and download buckets of a client.
+type clientThrottle struct {
+	upload   *tokenBucket
+	download *tokenBucket
+	used     time.Time
+}
+
+// throttler paces the data sent and received by each client.
+type throttler struct {
+	cfg    ThrottleConfig
+	policy *policyWatcher
+
+	mu      sync.Mutex
+	clients map[string]*clientThrottle
+	swept   time.Time
+	// pauses is the number of times, and paused the total time, a client was paused
+	// since the server started
+	pauses uint64
+	paused time.Duration
+}
+
+func newThrottler(cfg ThrottleConfig, policy *policyWatcher) *throttler {
+	return &throttler{
+		cfg:     cfg,
+		policy:  policy,
+		clients: make(map[string]*clientThrottle),
+		swept:   time.Now(),
+	}
+}
+
+// client returns the buckets of the client which sent req.
+func (t *throttler) client(req *http.Request, now time.Time) *clientThrottle {
+	name, g := requestClient(t.policy, req)
+	upload, download := t.cfg.UploadBytes, t.cfg.DownloadBytes
+	if g != nil && g.uploadBytes > 0 {
+		upload = g.uploadBytes
+	}
+	if g != nil && g.downloadBytes > 0 {
+		download = g.downloadBytes
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.swept) >= rateClientIdle {
+		for name, c := range t.clients {
+			if now.Sub(c.used) >= rateClientIdle {
+				delete(t.clients, name)
+			}
+		}
+		t.swept = now
+	}
+	c, ok := t.clients[name]
+	if !ok || c.upload.rate != upload || c.download.rate != download {
+		// The client's limits are new, or have changed in the access policy
+		c = &clientThrottle{upload: newTokenBucket(upload, now), download: newTokenBucket(download, now)}
+		t.clients[name] = c
+	}
+	c.used = now
+	return c
+}
+
+// delay takes n bytes from b and returns the time to pause for the bucket to be out of
+// debt.
+func (t *throttler) delay(b *tokenBucket, n int, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b.take(float64(n), now)
+	return b.wait(0, now)
+}
+
+// pace takes n bytes from b and pauses until the bucket is out of debt, or ctx is
+// cancelled.
+func (t *throttler) pace(ctx context.Context, b *tokenBucket, n int) {
+	d := t.delay(b, n, time.Now())
+	if d <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.pauses++
+	t.paused += d
+	t.mu.Unlock()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// throttledBody paces the reads of a request body.
+type throttledBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	t      *throttler
+	bucket *tokenBucket
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if b.bucket.rate > 0 && len(p) > int(burst(b.bucket.rate)) {
+		// Smaller reads keep the pauses short
+		p = p[:int(burst(b.bucket.rate))]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.t.pace(b.ctx, b.bucket, n)
+	}
+	return n, err
+}
+
+// throttledWriter paces the writes of a response.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	t      *throttler
+	bucket *tokenBucket
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if max := int(burst(w.bucket.rate)); n > max {
+			n = max
+		}
+		w.t.pace(w.ctx, w.bucket, n)
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// handler returns a handler which paces the request body and response of each
+// request to h.
+func (t *throttler) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := t.client(req, time.Now())
+		if c.upload.rate > 0 && req.Body != nil && req.Body != http.NoBody {
+			req.Body = &throttledBody{req.Body, req.Context(), t, c.upload}
+		}
+		if c.download.rate > 0 {
+			w = &throttledWriter{w, req.Context(), t, c.download}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (t *throttler) stats() adminThrottle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return adminThrottle{Clients: len(t.clients), Pauses: t.pauses, PausedSeconds: t.paused.Seconds()}
+}
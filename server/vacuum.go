@@ -0,0 +1,65 @@
+package server
+
+import "context"
+
+// VacuumReport describes the data a vacuum would reclaim.
+type VacuumReport struct {
+	// DeletedPacks is the number of packfiles with no referenced chunks, which a
+	// vacuum deletes, and RebuiltPacks the number with some referenced chunks, which it
+	// replaces with a packfile holding only those chunks.
+	DeletedPacks int
+	RebuiltPacks int
+	// Chunks is the number of unreferenced chunks removed, and Bytes the number of
+	// bytes freed in the store.
+	Chunks uint64
+	Bytes  uint64
+	// Packs lists each packfile the vacuum would delete or rebuild.
+	Packs []ReclaimedPack
+}
+
+// ReclaimedPack is a packfile a vacuum would delete or rebuild.
+type ReclaimedPack struct {
+	// Sum is the hex-encoded checksum of the packfile, and Size its size in bytes.
+	Sum  string
+	Size uint64
+	// Chunks is the number of unreferenced chunks in the packfile, out of TotalChunks,
+	// and Bytes the number of bytes freed by removing them.
+	Chunks      uint64
+	TotalChunks uint64
+	Bytes       uint64
+	// Rebuilt is true if the packfile holds referenced chunks, so it's replaced rather
+	// than deleted.
+	Rebuilt bool
+	// Files names the files with data in a rebuilt packfile, up to the limit given to
+	// VacuumDryRun. Their data is copied to the new packfile.
+	Files []string
+}
+
+// VacuumDryRun reports the packfiles a vacuum started now would delete or rebuild,
+// and the space it would reclaim, without changing anything. At most maxFiles names
+// of the files with data in each rebuilt packfile are listed.
+func (s *Server) VacuumDryRun(ctx context.Context, maxFiles int) (VacuumReport, error) {
+	r, err := s.srv.VacuumDryRun(ctx, maxFiles)
+	if err != nil {
+		return VacuumReport{}, err
+	}
+	report := VacuumReport{
+		DeletedPacks: r.DeletedPacks,
+		RebuiltPacks: r.RebuiltPacks,
+		Chunks:       r.Chunks,
+		Bytes:        r.Bytes,
+		Packs:        make([]ReclaimedPack, len(r.Packs)),
+	}
+	for i, p := range r.Packs {
+		report.Packs[i] = ReclaimedPack{
+			Sum:         p.Sum.AsHex(),
+			Size:        p.Size,
+			Chunks:      p.Chunks,
+			TotalChunks: p.TotalChunks,
+			Bytes:       p.Bytes,
+			Rebuilt:     p.Rebuilt,
+			Files:       p.Files,
+		}
+	}
+	return report, nil
+}